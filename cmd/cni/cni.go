@@ -27,6 +27,7 @@ import (
 	"github.com/vishvananda/netlink"
 
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
 
 	"github.com/alibaba/hybridnet/pkg/request"
 
@@ -88,6 +89,15 @@ func cmdAdd(args *skel.CmdArgs) error {
 	return types.PrintResult(result, cniVersion)
 }
 
+// routeGateway returns the gateway of default route in result, pods of gateway-less subnets
+// are routed through the virtual gateway configured by daemon
+func routeGateway(gateway, virtualGateway string) net.IP {
+	if len(gateway) == 0 {
+		return net.ParseIP(virtualGateway)
+	}
+	return net.ParseIP(gateway)
+}
+
 func generateCNIResult(cniVersion string, cniResponse *request.PodResponse) (*current.Result, error) {
 	result := &current.Result{CNIVersion: cniVersion}
 	result.IPs = []*current.IPConfig{}
@@ -108,7 +118,7 @@ func generateCNIResult(cniVersion string, cniResponse *request.PodResponse) (*cu
 
 			route = types.Route{
 				Dst: net.IPNet{IP: net.ParseIP("0.0.0.0").To4(), Mask: net.CIDRMask(0, 32)},
-				GW:  net.ParseIP(address.Gateway),
+				GW:  routeGateway(address.Gateway, constants.PodVirtualV4DefaultGateway),
 			}
 		case networkingv1.IPv6:
 			ip = current.IPConfig{
@@ -119,7 +129,7 @@ func generateCNIResult(cniVersion string, cniResponse *request.PodResponse) (*cu
 
 			route = types.Route{
 				Dst: net.IPNet{IP: net.ParseIP("::").To16(), Mask: net.CIDRMask(0, 128)},
-				GW:  net.ParseIP(address.Gateway),
+				GW:  routeGateway(address.Gateway, constants.PodVirtualV6DefaultGateway),
 			}
 		}
 
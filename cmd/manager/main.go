@@ -31,17 +31,20 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	policyv1beta1client "k8s.io/client-go/kubernetes/typed/policy/v1beta1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 
 	multiclusterv1 "github.com/alibaba/hybridnet/pkg/apis/multicluster/v1"
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
 	"github.com/alibaba/hybridnet/pkg/controllers/concurrency"
 	"github.com/alibaba/hybridnet/pkg/controllers/multicluster"
 	"github.com/alibaba/hybridnet/pkg/controllers/multicluster/clusterchecker"
 	"github.com/alibaba/hybridnet/pkg/controllers/networking"
 	"github.com/alibaba/hybridnet/pkg/controllers/utils"
 	"github.com/alibaba/hybridnet/pkg/feature"
+	"github.com/alibaba/hybridnet/pkg/ipam/store"
 	"github.com/alibaba/hybridnet/pkg/managerruntime"
 	zapinit "github.com/alibaba/hybridnet/pkg/zap"
 )
@@ -63,6 +66,29 @@ func main() {
 		clientQPS             float32
 		clientBurst           int
 		metricsPort           int
+		externalIPTimeout     time.Duration
+		allocationEventSink   string
+		unboundIPThreshold    time.Duration
+		ipOwnerPeriod         time.Duration
+		ipDuplicatePeriod     time.Duration
+		nodeIPDrainAddress    string
+		allocationBaseDelay   time.Duration
+		allocationMaxDelay    time.Duration
+		statefulAllocTimeout  time.Duration
+		subnetRebalanceAddr   string
+		capacityQueryAddr     string
+		podIPConfigMapName    string
+		nsDeletionRecycle     bool
+		lazyAllocation        bool
+		crashLoopIPThreshold  time.Duration
+		crashLoopIPPolicy     string
+		allocationRequestAddr string
+		allocFailEventPeriod  time.Duration
+		staleNodeIPPolicy     string
+		stuckFinalizerPeriod  time.Duration
+		ipSwapDualHomed       time.Duration
+		ipSwapBindTimeout     time.Duration
+		endpointTLS           networking.EndpointTLSConfig
 	)
 
 	// register flags
@@ -70,6 +96,35 @@ func main() {
 	pflag.Float32Var(&clientQPS, "kube-client-qps", 300, "The QPS limit of apiserver client.")
 	pflag.IntVar(&clientBurst, "kube-client-burst", 600, "The Burst limit of apiserver client.")
 	pflag.IntVar(&metricsPort, "metrics-port", 9899, "The port to listen on for prometheus metrics.")
+	pflag.DurationVar(&externalIPTimeout, "external-ip-timeout", 2*time.Minute, "The timeout for externally addressed pods to get ip instances created.")
+	pflag.DurationVar(&unboundIPThreshold, "unbound-ip-warning-threshold", 5*time.Minute, "The age for an ip instance allocated but not bound to pod nic to be warned with an event, disabled if zero.")
+	pflag.DurationVar(&stuckFinalizerPeriod, "stuck-finalizer-threshold", 10*time.Minute, "The time for a pod to be terminating with ip finalizer after its deletion timestamp before it is counted as stuck in metrics, disabled if zero.")
+	pflag.DurationVar(&ipOwnerPeriod, "ip-owner-reconcile-period", 10*time.Minute, "The period to set missing owner references of ip instances in use and recycle those whose pods are gone, disabled if zero.")
+	pflag.DurationVar(&ipDuplicatePeriod, "ip-duplicate-check-period", 5*time.Minute, "The period to detect ip instances claiming the same address and delete all but the one bound to a live pod, disabled if zero.")
+	pflag.DurationVar(&allocationBaseDelay, "allocation-requeue-base-delay", 0, "The base delay to requeue pods failing to get ips, which doubles on consecutive failures and can be overridden by network, failures are left to the rate limiter of controller if zero.")
+	pflag.DurationVar(&allocationMaxDelay, "allocation-requeue-max-delay", 5*time.Minute, "The max delay to requeue pods failing to get ips, which can be overridden by network.")
+	pflag.DurationVar(&allocFailEventPeriod, "allocation-failure-event-interval", 5*time.Minute, "The min interval between the allocation failure events of a pod, which prevents event storms of pods failing repeatedly, every failure is warned if zero.")
+	pflag.DurationVar(&statefulAllocTimeout, "stateful-allocate-timeout", time.Minute, "The timeout of ip allocation for a stateful pod, which will be aborted between steps and requeued, disabled if zero.")
+	pflag.StringVar(&nodeIPDrainAddress, "node-ip-drain-addr", "", "The address to serve the endpoint for draining ips of a node before decommissioning on, disabled if empty.")
+	pflag.StringVar(&podIPConfigMapName, "pod-ip-configmap", "", "The name of ConfigMap maintained in each namespace which maps pods to their ips for legacy consumers, disabled if empty.")
+	pflag.StringVar(&subnetRebalanceAddr, "subnet-rebalance-addr", "", "The address to serve the endpoint for rebalancing subnets of a network on, disabled if empty.")
+	pflag.StringVar(&endpointTLS.CertFile, "endpoint-tls-cert-file", "", "The certificate file to serve the endpoints of manager with mutual tls, endpoints changing pods or ips are only served on loopback addresses without tls.")
+	pflag.StringVar(&endpointTLS.KeyFile, "endpoint-tls-key-file", "", "The private key file to serve the endpoints of manager with mutual tls.")
+	pflag.StringVar(&endpointTLS.ClientCAFile, "endpoint-tls-client-ca-file", "", "The ca file to verify the client certificates of the endpoints of manager.")
+	pflag.StringVar(&capacityQueryAddr, "capacity-query-addr", "", "The address to serve the endpoint for querying ip capacity for a prospective pod on, disabled if empty.")
+	pflag.BoolVar(&nsDeletionRecycle, "recycle-ips-on-namespace-deletion", true, "Whether to release the ips of stateful pods instead of reserving them if their namespace is being deleted.")
+	pflag.BoolVar(&lazyAllocation, "lazy-allocation", false, "Whether to defer the allocation of pods until their cni add requests arrive, which are reported by daemons through allocation request endpoint.")
+	pflag.StringVar(&allocationRequestAddr, "allocation-request-addr", "", "The address to serve the endpoint for daemons requesting allocation for pods in lazy allocation mode on, which must be a loopback address unless endpoint tls is set, disabled if empty.")
+	pflag.DurationVar(&crashLoopIPThreshold, "crashloop-ip-reclaim-threshold", 0, "The duration for a pod to crash-loop before it is deleted to reclaim its ips, disabled if zero.")
+	pflag.StringVar(&crashLoopIPPolicy, "crashloop-ip-reclaim-policy", networking.CrashLoopIPPolicyReserve, "The policy of ips of stateful pods reclaimed from crash-looping, \"reserve\" or \"release\", ips of other pods are always released.")
+	pflag.StringVar(&staleNodeIPPolicy, "stale-node-ip-policy", networking.StaleNodeIPPolicyRelocate, "The policy of ips left on another node by allocated pods, \"relocate\" moves ips of overlay networks and bgp networks to the current node and reallocates the others, \"reallocate\" always reallocates ips.")
+	pflag.DurationVar(&ipSwapDualHomed, "ip-swap-dual-homed-period", 10*time.Second, "The min period that a pod holds both the original and new ips when its ip is swapped, counted from the swap starts, it only works with feature gate IPSwap.")
+	pflag.DurationVar(&ipSwapBindTimeout, "ip-swap-bind-timeout", time.Minute, "The timeout for daemon to configure the new ip on nic when the ip of a pod is swapped, after which the swap is cancelled, it only works with feature gate IPSwap.")
+	pflag.BoolVar(&store.PreserveIPAnnotations, "preserve-ip-annotations-on-reallocation", false, "Whether to restore the ip annotations of the last allocation in a single patch rather than remove them if reallocated ips fail to be committed, it only works if the last ips are still held by the pod.")
+	pflag.StringVar(&store.AllocationHookURL, "allocation-hook-url", "", "The url of allocation hook which is called before allocated ips are committed to pod, empty means allocation hook is disabled.")
+	pflag.DurationVar(&store.AllocationHookTimeout, "allocation-hook-timeout", 3*time.Second, "The timeout of calling allocation hook.")
+	pflag.StringVar(&store.AllocationHookFailurePolicy, "allocation-hook-failure-policy", store.AllocationHookFailurePolicyIgnore, `What to do if allocation hook fails to answer, "Ignore" to commit ips or "Fail" to fail the allocation.`)
+	pflag.StringVar(&allocationEventSink, "allocation-event-sink", "", "The sink to export allocation events of pods with full details to, only \"json\" is supported which writes json lines to stdout, disabled if empty.")
 
 	// parse flags
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
@@ -83,6 +138,11 @@ func main() {
 		"commit-id", gitCommit,
 		"controller-concurrency", controllerConcurrency)
 
+	if err := endpointTLS.Validate(); err != nil {
+		entryLog.Error(err, "invalid endpoint tls config")
+		os.Exit(1)
+	}
+
 	signalContext := ctrl.SetupSignalHandler()
 
 	clientConfig := ctrl.GetConfigOrDie()
@@ -123,6 +183,7 @@ func main() {
 	}
 
 	ipamStore := networking.NewIPAMStore(mgr.GetClient())
+	store.SetEventRecorder(mgr.GetEventRecorderFor("IPAMStore"))
 
 	if err = (&networking.IPAMReconciler{
 		Client:                mgr.GetClient(),
@@ -137,12 +198,50 @@ func main() {
 		Client:                mgr.GetClient(),
 		IPAMManager:           ipamManager,
 		IPAMStore:             ipamStore,
+		Recorder:              mgr.GetEventRecorderFor(networking.ControllerIPInstance + "Controller"),
 		ControllerConcurrency: concurrency.ControllerConcurrency(controllerConcurrency[networking.ControllerIPInstance]),
 	}).SetupWithManager(mgr); err != nil {
 		entryLog.Error(err, "unable to inject controller", "controller", networking.ControllerIPInstance)
 		os.Exit(1)
 	}
 
+	if err = (&networking.IPBindingReconciler{
+		Client:                mgr.GetClient(),
+		IPAMManager:           ipamManager,
+		IPAMStore:             ipamStore,
+		ControllerConcurrency: concurrency.ControllerConcurrency(controllerConcurrency[networking.ControllerIPBinding]),
+	}).SetupWithManager(mgr); err != nil {
+		entryLog.Error(err, "unable to inject controller", "controller", networking.ControllerIPBinding)
+		os.Exit(1)
+	}
+
+	if err = (&networking.IPImportReconciler{
+		Client:                mgr.GetClient(),
+		IPAMManager:           ipamManager,
+		IPAMStore:             ipamStore,
+		ControllerConcurrency: concurrency.ControllerConcurrency(controllerConcurrency[networking.ControllerIPImport]),
+	}).SetupWithManager(mgr); err != nil {
+		entryLog.Error(err, "unable to inject controller", "controller", networking.ControllerIPImport)
+		os.Exit(1)
+	}
+
+	if err = (&networking.IPReservationReconciler{
+		Client:                mgr.GetClient(),
+		IPAMManager:           ipamManager,
+		ControllerConcurrency: concurrency.ControllerConcurrency(controllerConcurrency[networking.ControllerIPReservation]),
+	}).SetupWithManager(mgr); err != nil {
+		entryLog.Error(err, "unable to inject controller", "controller", networking.ControllerIPReservation)
+		os.Exit(1)
+	}
+
+	if err = (&networking.IPInstanceNodeLabelReconciler{
+		Client:                mgr.GetClient(),
+		ControllerConcurrency: concurrency.ControllerConcurrency(controllerConcurrency[networking.ControllerIPInstanceNodeLabel]),
+	}).SetupWithManager(mgr); err != nil {
+		entryLog.Error(err, "unable to inject controller", "controller", networking.ControllerIPInstanceNodeLabel)
+		os.Exit(1)
+	}
+
 	if err = (&networking.NodeReconciler{
 		Client:                mgr.GetClient(),
 		ControllerConcurrency: concurrency.ControllerConcurrency(controllerConcurrency[networking.ControllerNode]),
@@ -151,18 +250,204 @@ func main() {
 		os.Exit(1)
 	}
 
+	podAllocationEventSink, err := networking.NewAllocationEventSink(allocationEventSink, os.Stdout)
+	if err != nil {
+		entryLog.Error(err, "unable to create allocation event sink")
+		os.Exit(1)
+	}
+
+	if staleNodeIPPolicy != networking.StaleNodeIPPolicyRelocate && staleNodeIPPolicy != networking.StaleNodeIPPolicyReallocate {
+		entryLog.Error(fmt.Errorf("unknown policy %q", staleNodeIPPolicy), "invalid stale node ip policy")
+		os.Exit(1)
+	}
+
+	// rebalance hints are shared between rebalancer and pod controller
+	subnetRebalanceHints := networking.NewSubnetRebalanceHints()
+
 	if err = (&networking.PodReconciler{
-		APIReader:             mgr.GetAPIReader(),
-		Client:                mgr.GetClient(),
-		Recorder:              mgr.GetEventRecorderFor(networking.ControllerPod + "Controller"),
-		IPAMStore:             ipamStore,
-		IPAMManager:           ipamManager,
-		ControllerConcurrency: concurrency.ControllerConcurrency(controllerConcurrency[networking.ControllerPod]),
+		APIReader:                      mgr.GetAPIReader(),
+		Client:                         mgr.GetClient(),
+		Recorder:                       mgr.GetEventRecorderFor(networking.ControllerPod + "Controller"),
+		IPAMStore:                      ipamStore,
+		IPAMManager:                    ipamManager,
+		ExternalIPTimeout:              externalIPTimeout,
+		AllocationEventSink:            podAllocationEventSink,
+		AllocationRequeueBaseDelay:     allocationBaseDelay,
+		AllocationRequeueMaxDelay:      allocationMaxDelay,
+		AllocationFailureEventInterval: allocFailEventPeriod,
+		StatefulAllocateTimeout:        statefulAllocTimeout,
+		SubnetRebalanceHints:           subnetRebalanceHints,
+		RecycleOnNamespaceDeletion:     nsDeletionRecycle,
+		LazyAllocation:                 lazyAllocation,
+		StaleNodeIPPolicy:              staleNodeIPPolicy,
+		ControllerConcurrency:          concurrency.ControllerConcurrency(controllerConcurrency[networking.ControllerPod]),
 	}).SetupWithManager(mgr); err != nil {
 		entryLog.Error(err, "unable to inject controller", "controller", networking.ControllerPod)
 		os.Exit(1)
 	}
 
+	if feature.IPSwapEnabled() {
+		if err = (&networking.IPSwapReconciler{
+			APIReader:             mgr.GetAPIReader(),
+			Client:                mgr.GetClient(),
+			Recorder:              mgr.GetEventRecorderFor(networking.ControllerIPSwap + "Controller"),
+			IPAMStore:             ipamStore,
+			IPAMManager:           ipamManager,
+			DualHomedPeriod:       ipSwapDualHomed,
+			BindTimeout:           ipSwapBindTimeout,
+			ControllerConcurrency: concurrency.ControllerConcurrency(controllerConcurrency[networking.ControllerIPSwap]),
+		}).SetupWithManager(mgr); err != nil {
+			entryLog.Error(err, "unable to inject controller", "controller", networking.ControllerIPSwap)
+			os.Exit(1)
+		}
+	}
+
+	if err = mgr.Add(&networking.IPInstanceGarbageCollection{
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+		Logger:    mgr.GetLogger().WithName("gc").WithName(networking.GarbageCollectionIPInstance),
+		IPAMStore: ipamStore,
+	}); err != nil {
+		entryLog.Error(err, "unable to inject garbage collection", "gc", networking.GarbageCollectionIPInstance)
+		os.Exit(1)
+	}
+
+	if err = mgr.Add(&networking.UnboundIPInstanceMonitor{
+		Client:    mgr.GetClient(),
+		Recorder:  mgr.GetEventRecorderFor(networking.MonitorUnboundIPInstance),
+		Logger:    mgr.GetLogger().WithName("monitor").WithName(networking.MonitorUnboundIPInstance),
+		Threshold: unboundIPThreshold,
+	}); err != nil {
+		entryLog.Error(err, "unable to inject monitor", "monitor", networking.MonitorUnboundIPInstance)
+		os.Exit(1)
+	}
+
+	if stuckFinalizerPeriod > 0 {
+		if err = mgr.Add(&networking.StuckFinalizerMonitor{
+			Client:    mgr.GetClient(),
+			Logger:    mgr.GetLogger().WithName("monitor").WithName(networking.MonitorStuckFinalizer),
+			Threshold: stuckFinalizerPeriod,
+		}); err != nil {
+			entryLog.Error(err, "unable to inject monitor", "monitor", networking.MonitorStuckFinalizer)
+			os.Exit(1)
+		}
+	}
+
+	if ipOwnerPeriod > 0 {
+		if err = mgr.Add(&networking.IPInstanceOwnerReconciler{
+			Client:    mgr.GetClient(),
+			APIReader: mgr.GetAPIReader(),
+			Logger:    mgr.GetLogger().WithName("reconciler").WithName(networking.ReconcilerIPInstanceOwner),
+			IPAMStore: ipamStore,
+			Period:    ipOwnerPeriod,
+		}); err != nil {
+			entryLog.Error(err, "unable to inject reconciler", "reconciler", networking.ReconcilerIPInstanceOwner)
+			os.Exit(1)
+		}
+	}
+
+	if ipDuplicatePeriod > 0 {
+		if err = mgr.Add(&networking.IPInstanceDuplicateReconciler{
+			Client:    mgr.GetClient(),
+			APIReader: mgr.GetAPIReader(),
+			Logger:    mgr.GetLogger().WithName("reconciler").WithName(networking.ReconcilerIPInstanceDuplicate),
+			Recorder:  mgr.GetEventRecorderFor(networking.ReconcilerIPInstanceDuplicate),
+			IPAMStore: ipamStore,
+			Period:    ipDuplicatePeriod,
+		}); err != nil {
+			entryLog.Error(err, "unable to inject reconciler", "reconciler", networking.ReconcilerIPInstanceDuplicate)
+			os.Exit(1)
+		}
+	}
+
+	if len(nodeIPDrainAddress) > 0 {
+		if err = mgr.Add(&networking.NodeIPDrainer{
+			Client:      mgr.GetClient(),
+			APIReader:   mgr.GetAPIReader(),
+			Logger:      mgr.GetLogger().WithName("drainer").WithName(networking.DrainerNodeIP),
+			IPAMStore:   ipamStore,
+			BindAddress: nodeIPDrainAddress,
+			TLS:         &endpointTLS,
+		}); err != nil {
+			entryLog.Error(err, "unable to inject drainer", "drainer", networking.DrainerNodeIP)
+			os.Exit(1)
+		}
+	}
+
+	if len(subnetRebalanceAddr) > 0 {
+		if err = mgr.Add(&networking.SubnetRebalancer{
+			Client:      mgr.GetClient(),
+			Logger:      mgr.GetLogger().WithName("rebalancer").WithName(networking.RebalancerSubnet),
+			Evictions:   policyv1beta1client.NewForConfigOrDie(clientConfig),
+			IPAMManager: ipamManager,
+			Hints:       subnetRebalanceHints,
+			BindAddress: subnetRebalanceAddr,
+			TLS:         &endpointTLS,
+		}); err != nil {
+			entryLog.Error(err, "unable to inject rebalancer", "rebalancer", networking.RebalancerSubnet)
+			os.Exit(1)
+		}
+	}
+
+	if len(capacityQueryAddr) > 0 {
+		if err = mgr.Add(&networking.CapacityQuerier{
+			Client:      mgr.GetClient(),
+			Logger:      mgr.GetLogger().WithName("querier").WithName(networking.QuerierCapacity),
+			IPAMManager: ipamManager,
+			BindAddress: capacityQueryAddr,
+			TLS:         &endpointTLS,
+		}); err != nil {
+			entryLog.Error(err, "unable to inject querier", "querier", networking.QuerierCapacity)
+			os.Exit(1)
+		}
+	}
+
+	if len(allocationRequestAddr) > 0 {
+		if err = mgr.Add(&networking.AllocationRequester{
+			Client:      mgr.GetClient(),
+			Logger:      mgr.GetLogger().WithName("requester").WithName(networking.RequesterAllocation),
+			BindAddress: allocationRequestAddr,
+			TLS:         &endpointTLS,
+		}); err != nil {
+			entryLog.Error(err, "unable to inject requester", "requester", networking.RequesterAllocation)
+			os.Exit(1)
+		}
+	} else if lazyAllocation {
+		entryLog.Info("lazy allocation is enabled without allocation request endpoint, pods will not be allocated "+
+			"until annotated by others", "annotation", constants.AnnotationAllocationRequested)
+	}
+
+	if crashLoopIPThreshold > 0 {
+		if crashLoopIPPolicy != networking.CrashLoopIPPolicyReserve && crashLoopIPPolicy != networking.CrashLoopIPPolicyRelease {
+			entryLog.Error(fmt.Errorf("unknown policy %q", crashLoopIPPolicy), "invalid crash-loop ip reclaim policy")
+			os.Exit(1)
+		}
+		if err = mgr.Add(&networking.CrashLoopIPReclaimer{
+			Client:    mgr.GetClient(),
+			Logger:    mgr.GetLogger().WithName("reclaimer").WithName(networking.ReclaimerCrashLoopIP),
+			Recorder:  mgr.GetEventRecorderFor(networking.ReclaimerCrashLoopIP),
+			Evictions: policyv1beta1client.NewForConfigOrDie(clientConfig),
+			Threshold: crashLoopIPThreshold,
+			Policy:    crashLoopIPPolicy,
+		}); err != nil {
+			entryLog.Error(err, "unable to inject reclaimer", "reclaimer", networking.ReclaimerCrashLoopIP)
+			os.Exit(1)
+		}
+	}
+
+	if len(podIPConfigMapName) > 0 {
+		if err = (&networking.PodIPConfigMapReconciler{
+			Client:                mgr.GetClient(),
+			APIReader:             mgr.GetAPIReader(),
+			Recorder:              mgr.GetEventRecorderFor(networking.ControllerPodIPConfigMap + "Controller"),
+			ConfigMapName:         podIPConfigMapName,
+			ControllerConcurrency: concurrency.ControllerConcurrency(controllerConcurrency[networking.ControllerPodIPConfigMap]),
+		}).SetupWithManager(mgr); err != nil {
+			entryLog.Error(err, "unable to inject controller", "controller", networking.ControllerPodIPConfigMap)
+			os.Exit(1)
+		}
+	}
+
 	if err = (&networking.NetworkStatusReconciler{
 		Client:                mgr.GetClient(),
 		IPAMManager:           ipamManager,
@@ -183,6 +468,15 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err = (&networking.SubnetReconciler{
+		Client:                mgr.GetClient(),
+		Recorder:              mgr.GetEventRecorderFor(networking.ControllerSubnet + "Controller"),
+		ControllerConcurrency: concurrency.ControllerConcurrency(controllerConcurrency[networking.ControllerSubnet]),
+	}).SetupWithManager(mgr); err != nil {
+		entryLog.Error(err, "unable to inject controller", "controller", networking.ControllerSubnet)
+		os.Exit(1)
+	}
+
 	if err = (&networking.QuotaReconciler{
 		Client:                mgr.GetClient(),
 		ControllerConcurrency: concurrency.ControllerConcurrency(controllerConcurrency[networking.ControllerQuota]),
@@ -292,6 +586,24 @@ func initIndexers(mgr ctrl.Manager) (err error) {
 		return err
 	}
 
+	// init phase indexer for IPInstances
+	if err = mgr.GetFieldIndexer().IndexField(context.TODO(), &networkingv1.IPInstance{},
+		utils.IndexerFieldIPInstancePhase, utils.IndexIPInstanceByPhase); err != nil {
+		return err
+	}
+
+	// init pod indexer for IPBindings
+	if err = mgr.GetFieldIndexer().IndexField(context.TODO(), &networkingv1.IPBinding{},
+		networking.IndexerFieldIPBindingPod, func(obj client.Object) []string {
+			binding, ok := obj.(*networkingv1.IPBinding)
+			if !ok {
+				return nil
+			}
+			return []string{binding.Spec.PodName}
+		}); err != nil {
+		return err
+	}
+
 	// init network indexer for Subnets
 	return mgr.GetFieldIndexer().IndexField(context.TODO(), &networkingv1.Subnet{},
 		networking.IndexerFieldNetwork, func(obj client.Object) []string {
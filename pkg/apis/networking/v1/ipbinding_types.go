@@ -0,0 +1,85 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IPBindingPhase is the phase of ip binding
+type IPBindingPhase string
+
+const (
+	// IPBindingPhaseReserved means that ip instances of the ips are reserved for pod
+	IPBindingPhaseReserved = IPBindingPhase("Reserved")
+	// IPBindingPhaseBound means that the ips are used by pod
+	IPBindingPhaseBound = IPBindingPhase("Bound")
+	// IPBindingPhaseFailed means that the ips can not be reserved, e.g., used by another pod
+	IPBindingPhaseFailed = IPBindingPhase("Failed")
+)
+
+// IPBindingSpec defines the desired state of IPBinding
+type IPBindingSpec struct {
+	// PodName is the name of pod in the same namespace which the ips are bound to
+	// +kubebuilder:validation:Required
+	PodName string `json:"podName"`
+	// Network is the network of ips
+	// +kubebuilder:validation:Required
+	Network string `json:"network"`
+	// IPs are the fixed ips of pod, at most one for each ip family
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=2
+	IPs []string `json:"ips"`
+}
+
+// IPBindingStatus defines the observed state of IPBinding
+type IPBindingStatus struct {
+	// +kubebuilder:validation:Optional
+	Phase IPBindingPhase `json:"phase,omitempty"`
+	// +kubebuilder:validation:Optional
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="PodName",type=string,JSONPath=`.spec.podName`
+// +kubebuilder:printcolumn:name="Network",type=string,JSONPath=`.spec.network`
+// +kubebuilder:printcolumn:name="IPs",type=string,JSONPath=`.spec.ips`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+
+// IPBinding is the Schema for the ipbindings API, it binds fixed ips to a pod by name
+type IPBinding struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IPBindingSpec   `json:"spec,omitempty"`
+	Status IPBindingStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IPBindingList contains a list of IPBinding
+type IPBindingList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IPBinding `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IPBinding{}, &IPBindingList{})
+}
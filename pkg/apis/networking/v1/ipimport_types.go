@@ -0,0 +1,86 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IPImportEntry is an existing assignment of ip to be imported
+type IPImportEntry struct {
+	// IP is the address in use
+	// +kubebuilder:validation:Required
+	IP string `json:"ip"`
+	// PodName is the name of pod in the same namespace which the ip is reserved for, and reserved ips
+	// are reused by stateful pods of the same name. Empty means the ip is used outside of cluster
+	// +kubebuilder:validation:Optional
+	PodName string `json:"podName,omitempty"`
+}
+
+// IPImportSpec defines the desired state of IPImport
+type IPImportSpec struct {
+	// Network is the network of ips
+	// +kubebuilder:validation:Required
+	Network string `json:"network"`
+	// Entries are the assignments of ips to be imported
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinItems=1
+	Entries []IPImportEntry `json:"entries"`
+}
+
+// IPImportFailure is an entry which can not be imported
+type IPImportFailure struct {
+	IP     string `json:"ip"`
+	Reason string `json:"reason"`
+}
+
+// IPImportStatus defines the observed state of IPImport
+type IPImportStatus struct {
+	// Imported is the count of entries imported
+	// +kubebuilder:validation:Optional
+	Imported int32 `json:"imported"`
+	// Failures are the entries which can not be imported, e.g., out of subnets or conflicted
+	// +kubebuilder:validation:Optional
+	Failures []IPImportFailure `json:"failures,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Network",type=string,JSONPath=`.spec.network`
+// +kubebuilder:printcolumn:name="Imported",type=integer,JSONPath=`.status.imported`
+
+// IPImport is the Schema for the ipimports API, it imports existing ip assignments as reserved ips
+type IPImport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IPImportSpec   `json:"spec,omitempty"`
+	Status IPImportStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IPImportList contains a list of IPImport
+type IPImportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IPImport `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IPImport{}, &IPImportList{})
+}
@@ -0,0 +1,85 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IPReservationPhase is the phase of ip reservation
+type IPReservationPhase string
+
+const (
+	// IPReservationPhaseActive means that the ips are reserved and never allocated
+	IPReservationPhaseActive = IPReservationPhase("Active")
+	// IPReservationPhaseRejected means that the ips can not be reserved, e.g., some of them are in use
+	IPReservationPhaseRejected = IPReservationPhase("Rejected")
+)
+
+// IPReservationSpec defines the desired state of IPReservation
+type IPReservationSpec struct {
+	// Subnet is the subnet of ips
+	// +kubebuilder:validation:Required
+	Subnet string `json:"subnet"`
+	// Start is the first ip of range
+	// +kubebuilder:validation:Required
+	Start string `json:"start"`
+	// End is the last ip of range, the range is a single ip if it is empty
+	// +kubebuilder:validation:Optional
+	End string `json:"end,omitempty"`
+}
+
+// IPReservationStatus defines the observed state of IPReservation
+type IPReservationStatus struct {
+	// +kubebuilder:validation:Optional
+	Phase IPReservationPhase `json:"phase,omitempty"`
+	// ObservedGeneration is the generation of spec which the phase is decided for
+	// +kubebuilder:validation:Optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// +kubebuilder:validation:Optional
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Subnet",type=string,JSONPath=`.spec.subnet`
+// +kubebuilder:printcolumn:name="Start",type=string,JSONPath=`.spec.start`
+// +kubebuilder:printcolumn:name="End",type=string,JSONPath=`.spec.end`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+
+// IPReservation is the Schema for the ipreservations API, it reserves a range of subnet for infrastructure
+type IPReservation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IPReservationSpec   `json:"spec,omitempty"`
+	Status IPReservationStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IPReservationList contains a list of IPReservation
+type IPReservationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IPReservation `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IPReservation{}, &IPReservationList{})
+}
@@ -38,6 +38,77 @@ type NetworkSpec struct {
 	Mode NetworkMode `json:"mode,omitempty"`
 	// +kubebuilder:validation:Optional
 	Config *NetworkConfig `json:"config,omitempty"`
+	// Paused stops new allocations on this network for maintenance, pods are left pending
+	// until it is cleared, existing pods are not affected
+	// +kubebuilder:validation:Optional
+	Paused bool `json:"paused,omitempty"`
+	// Priority breaks the tie if node belongs to multiple networks of the same type,
+	// the network with higher priority is selected for pods
+	// +kubebuilder:validation:Optional
+	Priority int32 `json:"priority,omitempty"`
+	// DefaultInterfaceName is the name of the default interface of pods in this network, the
+	// global default of daemon is used if it is empty
+	// +kubebuilder:validation:Optional
+	DefaultInterfaceName string `json:"defaultInterfaceName,omitempty"`
+	// AllocationRetry overrides the requeue backoff of manager for pods failing to get ips
+	// from this network
+	// +kubebuilder:validation:Optional
+	AllocationRetry *AllocationRetry `json:"allocationRetry,omitempty"`
+	// AllocationRateLimit limits how fast ips are allocated from this network, pods over the rate are
+	// requeued, so a workload creating pods in a loop cannot exhaust the network at once
+	// +kubebuilder:validation:Optional
+	AllocationRateLimit *AllocationRateLimit `json:"allocationRateLimit,omitempty"`
+	// AddressPool is the name of address space shared by networks, subnets of networks in
+	// the same address pool are allowed to overlap and an address is never allocated through
+	// two networks at the same time
+	// +kubebuilder:validation:Optional
+	AddressPool string `json:"addressPool,omitempty"`
+	// MACAddressMode is how MAC addresses of pods are generated, Random is the default and
+	// IPDerived derives them from IP addresses to keep them stable across pod recreations
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Random;IPDerived
+	MACAddressMode MACAddressMode `json:"macAddressMode,omitempty"`
+	// SpecifiedSubnetPolicy is what to do if the subnet specified by pod is being deleted or exhausted,
+	// Strict is the default which fails the allocation and Fallback allocates from the network instead
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Strict;Fallback
+	SpecifiedSubnetPolicy SpecifiedSubnetPolicy `json:"specifiedSubnetPolicy,omitempty"`
+	// PatchCalicoPodIPsAnnotation is whether to annotate pods in this network with their ips for
+	// Calico interop, the global default of daemon is used if it is empty
+	// +kubebuilder:validation:Optional
+	PatchCalicoPodIPsAnnotation *bool `json:"patchCalicoPodIPsAnnotation,omitempty"`
+	// PrimaryIPFamily is the ip family of dual-stack pods returned first and whose default route is
+	// installed first, the global default of daemon is used if it is empty
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=IPv4;IPv6
+	PrimaryIPFamily PrimaryIPFamily `json:"primaryIPFamily,omitempty"`
+	// DefaultIPFamily is the ip family of pods in this network without ip family specified, the global
+	// default of manager is used if it is empty
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=IPv4Only;IPv6Only;DualStack
+	DefaultIPFamily IPFamily `json:"defaultIPFamily,omitempty"`
+}
+
+// AllocationRetry is the requeue backoff of pods failing to get ips, the delay starts from
+// BaseDelay and doubles on every consecutive failure until MaxDelay
+type AllocationRetry struct {
+	// +kubebuilder:validation:Required
+	BaseDelay metav1.Duration `json:"baseDelay"`
+	// MaxDelay caps the delay, the global max delay of manager is used if it is empty
+	// +kubebuilder:validation:Optional
+	MaxDelay metav1.Duration `json:"maxDelay,omitempty"`
+}
+
+// AllocationRateLimit is a token bucket of ip allocations, which is refilled by QPS tokens per second
+// and holds Burst tokens at most
+type AllocationRateLimit struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+	QPS int32 `json:"qps"`
+	// Burst is the max allocations allowed at once, QPS is used if it is empty
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	Burst int32 `json:"burst,omitempty"`
 }
 
 // NetworkStatus defines the observed state of Network
@@ -64,6 +135,7 @@ type NetworkStatus struct {
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:scope=Cluster
 // +kubebuilder:printcolumn:name="NetID",type=integer,JSONPath=`.spec.netID`
+// +kubebuilder:printcolumn:name="Paused",type=boolean,JSONPath=`.spec.paused`
 
 // Network is the Schema for the networks API
 type Network struct {
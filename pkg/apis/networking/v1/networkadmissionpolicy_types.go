@@ -0,0 +1,64 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NetworkAdmissionPolicySpec defines the desired state of NetworkAdmissionPolicy
+type NetworkAdmissionPolicySpec struct {
+	// NamespaceSelector selects the namespaces whose pods are allowed to request the networks,
+	// network types and ip families of this policy
+	// +kubebuilder:validation:Required
+	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector"`
+	// Networks are the names of networks governed by this policy
+	// +kubebuilder:validation:Optional
+	Networks []string `json:"networks,omitempty"`
+	// NetworkTypes are the network types governed by this policy
+	// +kubebuilder:validation:Optional
+	NetworkTypes []NetworkType `json:"networkTypes,omitempty"`
+	// IPFamilies are the ip families governed by this policy, which are IPv4Only, IPv6Only or DualStack
+	// +kubebuilder:validation:Optional
+	IPFamilies []string `json:"ipFamilies,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// NetworkAdmissionPolicy is the Schema for the networkadmissionpolicies API. Once a network, network type or
+// ip family is governed by any policy, only pods in the namespaces selected by one of the policies governing
+// it are admitted to request it
+type NetworkAdmissionPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NetworkAdmissionPolicySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// NetworkAdmissionPolicyList contains a list of NetworkAdmissionPolicy
+type NetworkAdmissionPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NetworkAdmissionPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NetworkAdmissionPolicy{}, &NetworkAdmissionPolicyList{})
+}
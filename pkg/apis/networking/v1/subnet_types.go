@@ -33,6 +33,10 @@ type SubnetSpec struct {
 	Network string `json:"network"`
 	// +kubebuilder:validation:Optional
 	Config *SubnetConfig `json:"config"`
+	// ConflictedIPs are the addresses found in use outside of cluster, they are added by manager
+	// once reported and never allocated until removed by operator
+	// +kubebuilder:validation:Optional
+	ConflictedIPs []string `json:"conflictedIPs,omitempty"`
 }
 
 // SubnetStatus defines the observed state of Subnet
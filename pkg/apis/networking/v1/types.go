@@ -31,6 +31,40 @@ const (
 	NetworkModeVxlan = NetworkMode("VXLAN")
 )
 
+type MACAddressMode string
+
+const (
+	MACAddressModeRandom    = MACAddressMode("Random")
+	MACAddressModeIPDerived = MACAddressMode("IPDerived")
+)
+
+// SpecifiedSubnetPolicy decides what to do if the subnet specified by pod can not be allocated from
+type SpecifiedSubnetPolicy string
+
+const (
+	// SpecifiedSubnetPolicyStrict fails the allocation, specified subnet is a hard constraint
+	SpecifiedSubnetPolicyStrict = SpecifiedSubnetPolicy("Strict")
+	// SpecifiedSubnetPolicyFallback allocates from the other subnets of network, specified subnet is a hint
+	SpecifiedSubnetPolicyFallback = SpecifiedSubnetPolicy("Fallback")
+)
+
+// PrimaryIPFamily is the ip family treated as primary for dual-stack pods
+type PrimaryIPFamily string
+
+const (
+	PrimaryIPFamilyIPv4 = PrimaryIPFamily("IPv4")
+	PrimaryIPFamilyIPv6 = PrimaryIPFamily("IPv6")
+)
+
+// IPFamily is the ip family of pods, i.e., which families of ips they are allocated
+type IPFamily string
+
+const (
+	IPFamilyIPv4Only  = IPFamily("IPv4Only")
+	IPFamilyIPv6Only  = IPFamily("IPv6Only")
+	IPFamilyDualStack = IPFamily("DualStack")
+)
+
 type Count struct {
 	// +kubebuilder:validation:Optional
 	Total int32 `json:"total"`
@@ -75,6 +109,34 @@ type SubnetConfig struct {
 	Private *bool `json:"private"`
 	// +kubebuilder:validation:Optional
 	AllowSubnets []string `json:"allowSubnets"`
+	// StatefulBaseIP is used to assign deterministic ip for stateful pod, which
+	// will be computed as base ip + pod ordinal
+	// +kubebuilder:validation:Optional
+	StatefulBaseIP string `json:"statefulBaseIP,omitempty"`
+	// AddressSelector is the name of selector registered in manager to pick
+	// addresses from subnet, the default one picks addresses in turn
+	// +kubebuilder:validation:Optional
+	AddressSelector string `json:"addressSelector,omitempty"`
+	// Topology is used to select subnet for pod by the label of its scheduled node
+	// +kubebuilder:validation:Optional
+	Topology *SubnetTopology `json:"topology,omitempty"`
+	// GatewayLess declares subnet without gateway for pods routed purely by /32 or /128
+	// advertisement, which is only supported in BGP network
+	// +kubebuilder:validation:Optional
+	GatewayLess *bool `json:"gatewayLess,omitempty"`
+}
+
+type SubnetTopology struct {
+	// +kubebuilder:validation:Required
+	Key string `json:"key"`
+	// +kubebuilder:validation:Required
+	Value string `json:"value"`
+	// Weight turns topology into a soft preference, local subnets of higher weight are
+	// preferred and pods fall back to any subnet if no local subnet is available
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	Weight *int32 `json:"weight,omitempty"`
 }
 
 type NetworkConfig struct {
@@ -104,11 +166,46 @@ type BGPPeer struct {
 	GracefulRestartSeconds int32 `json:"gracefulRestartSeconds,omitempty"`
 	// +kubebuilder:validation:Optional
 	Password string `json:"password,omitempty"`
+	// ExportPolicy decides which local routes will be advertised to the peer, "All" by default
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=All;Subnets;IPs;None
+	ExportPolicy BGPExportPolicy `json:"exportPolicy,omitempty"`
+	// ImportPolicy decides whether routes received from the peer will be accepted, "Accept" by default
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Accept;Reject
+	ImportPolicy BGPImportPolicy `json:"importPolicy,omitempty"`
 }
 
+type BGPExportPolicy string
+
+const (
+	// BGPExportPolicyAll means both subnet routes and pod ip routes will be advertised
+	BGPExportPolicyAll = BGPExportPolicy("All")
+	// BGPExportPolicySubnets means only subnet routes will be advertised
+	BGPExportPolicySubnets = BGPExportPolicy("Subnets")
+	// BGPExportPolicyIPs means only pod ip (/32 or /128) routes will be advertised
+	BGPExportPolicyIPs = BGPExportPolicy("IPs")
+	// BGPExportPolicyNone means no route will be advertised
+	BGPExportPolicyNone = BGPExportPolicy("None")
+)
+
+type BGPImportPolicy string
+
+const (
+	BGPImportPolicyAccept = BGPImportPolicy("Accept")
+	BGPImportPolicyReject = BGPImportPolicy("Reject")
+)
+
 type IPPhase string
 
+// IP phases of IPInstance, an allocated IP will transit in
+// Using -> Binding -> Bound, and be Reserved after decoupled from a stateful pod
 const (
-	IPPhaseUsing    = IPPhase("Using")
+	// IPPhaseUsing means IP is allocated to pod, but nic of pod has not been configured
+	IPPhaseUsing = IPPhase("Using")
+	// IPPhaseBinding means daemon is configuring nic of pod with IP
+	IPPhaseBinding = IPPhase("Binding")
+	// IPPhaseBound means nic of pod has been configured with IP
+	IPPhaseBound    = IPPhase("Bound")
 	IPPhaseReserved = IPPhase("Reserved")
 )
@@ -21,8 +21,11 @@ import (
 	"math"
 	"math/big"
 	"net"
+	"strings"
 
 	"github.com/containernetworking/plugins/pkg/ip"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 // TODO: unit tests
@@ -35,6 +38,183 @@ func IsPrivateSubnet(subnet *Subnet) bool {
 	return *subnet.Spec.Config.Private
 }
 
+// IsGatewayLessSubnet checks whether subnet is declared without gateway
+func IsGatewayLessSubnet(subnet *Subnet) bool {
+	if subnet == nil || subnet.Spec.Config == nil || subnet.Spec.Config.GatewayLess == nil {
+		return false
+	}
+
+	return *subnet.Spec.Config.GatewayLess
+}
+
+// PickNetworkByPriority picks the network of the highest priority, networks of the same priority are
+// ordered by name to make the selection deterministic, ambiguous reports whether other networks share
+// the highest priority with the picked one
+func PickNetworkByPriority(networks []Network) (picked *Network, ambiguous bool) {
+	for i := range networks {
+		var network = &networks[i]
+		switch {
+		case picked == nil || network.Spec.Priority > picked.Spec.Priority:
+			picked, ambiguous = network, false
+		case network.Spec.Priority == picked.Spec.Priority:
+			ambiguous = true
+			if network.Name < picked.Name {
+				picked = network
+			}
+		}
+	}
+	return
+}
+
+// GetNetworkDefaultInterfaceName returns the name of default interface of pods in network,
+// the global default name is returned if network does not specify one
+func GetNetworkDefaultInterfaceName(networkObj *Network, globalDefault string) string {
+	if networkObj == nil || len(networkObj.Spec.DefaultInterfaceName) == 0 {
+		return globalDefault
+	}
+
+	return networkObj.Spec.DefaultInterfaceName
+}
+
+// GetNetworkPatchCalicoPodIPsAnnotation returns whether to patch the Calico pod ips annotation for pods
+// in network, the global default is used if network does not specify it
+func GetNetworkPatchCalicoPodIPsAnnotation(networkObj *Network, globalDefault bool) bool {
+	if networkObj == nil || networkObj.Spec.PatchCalicoPodIPsAnnotation == nil {
+		return globalDefault
+	}
+
+	return *networkObj.Spec.PatchCalicoPodIPsAnnotation
+}
+
+// GetNetworkPrimaryIPVersion returns the ip version treated as primary for dual-stack pods in network,
+// the global preference is used if network does not specify it
+func GetNetworkPrimaryIPVersion(networkObj *Network, preferIPv6 bool) IPVersion {
+	var primaryIPFamily PrimaryIPFamily
+	if networkObj != nil {
+		primaryIPFamily = networkObj.Spec.PrimaryIPFamily
+	}
+
+	switch {
+	case primaryIPFamily == PrimaryIPFamilyIPv6:
+		return IPv6
+	case primaryIPFamily == PrimaryIPFamilyIPv4:
+		return IPv4
+	case preferIPv6:
+		return IPv6
+	default:
+		return IPv4
+	}
+}
+
+// IsIPReservationActive checks whether the ips of reservation are reserved for its current spec
+func IsIPReservationActive(reservation *IPReservation) bool {
+	return reservation.Status.Phase == IPReservationPhaseActive &&
+		reservation.Status.ObservedGeneration == reservation.Generation
+}
+
+// GetSubnetAddressSelector returns the name of address selector of subnet, empty means the default one
+func GetSubnetAddressSelector(subnet *Subnet) string {
+	if subnet == nil || subnet.Spec.Config == nil {
+		return ""
+	}
+
+	return subnet.Spec.Config.AddressSelector
+}
+
+// IsSubnetTopologyMatched checks whether subnet has a topology matching the labels of node
+func IsSubnetTopologyMatched(subnet *Subnet, nodeLabels map[string]string) bool {
+	if subnet == nil || subnet.Spec.Config == nil || subnet.Spec.Config.Topology == nil {
+		return false
+	}
+
+	value, exist := nodeLabels[subnet.Spec.Config.Topology.Key]
+	return exist && value == subnet.Spec.Config.Topology.Value
+}
+
+// GetSubnetTopologyWeight returns the locality weight of subnet topology, weighted reports whether
+// the topology is a soft preference
+func GetSubnetTopologyWeight(subnet *Subnet) (weight int32, weighted bool) {
+	if subnet == nil || subnet.Spec.Config == nil || subnet.Spec.Config.Topology == nil ||
+		subnet.Spec.Config.Topology.Weight == nil {
+		return 0, false
+	}
+
+	return *subnet.Spec.Config.Topology.Weight, true
+}
+
+// CheckNetworkAdmission checks whether pods in the namespace with labels are allowed to request the network,
+// network type and ip family by admission policies, empty network means no network is specified. Anything
+// governed by none of the policies is allowed
+func CheckNetworkAdmission(policies []NetworkAdmissionPolicy, namespaceLabels map[string]string,
+	network string, networkType NetworkType, ipFamily string) error {
+	var governing = func(kind, value string, governs func(spec *NetworkAdmissionPolicySpec) bool) error {
+		if len(value) == 0 {
+			return nil
+		}
+
+		var names []string
+		for i := range policies {
+			policy := &policies[i]
+			if !governs(&policy.Spec) {
+				continue
+			}
+			// an invalid selector selects nothing, so the governed ones are never allowed by mistake
+			selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.NamespaceSelector)
+			if err == nil && selector.Matches(labels.Set(namespaceLabels)) {
+				return nil
+			}
+			names = append(names, policy.Name)
+		}
+
+		if len(names) > 0 {
+			return fmt.Errorf("%s %s is not allowed in the namespace by network admission policies %s",
+				kind, value, strings.Join(names, ","))
+		}
+		return nil
+	}
+
+	if err := governing("network", network, func(spec *NetworkAdmissionPolicySpec) bool {
+		for _, n := range spec.Networks {
+			if n == network {
+				return true
+			}
+		}
+		return false
+	}); err != nil {
+		return err
+	}
+
+	if err := governing("network type", string(networkType), func(spec *NetworkAdmissionPolicySpec) bool {
+		for _, t := range spec.NetworkTypes {
+			if strings.EqualFold(string(t), string(networkType)) {
+				return true
+			}
+		}
+		return false
+	}); err != nil {
+		return err
+	}
+
+	return governing("ip family", ipFamily, func(spec *NetworkAdmissionPolicySpec) bool {
+		for _, f := range spec.IPFamilies {
+			if strings.EqualFold(f, ipFamily) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// IsUsingPhase checks whether an IP is being used by pod, no matter whether the nic is configured
+func IsUsingPhase(phase IPPhase) bool {
+	switch phase {
+	case IPPhaseUsing, IPPhaseBinding, IPPhaseBound:
+		return true
+	default:
+		return false
+	}
+}
+
 func IsIPv6Subnet(subnet *Subnet) bool {
 	if subnet == nil {
 		return false
@@ -195,6 +375,14 @@ func CalculateCapacity(ar *AddressRange) int64 {
 		end = lastIP(cidr)
 	}
 
+	// network address and ipv4 broadcast address will never be allocated
+	if first := nextIP(cidr.IP); ipToInt(start).Cmp(ipToInt(first)) < 0 {
+		start = first
+	}
+	if last := lastIP(cidr); ipToInt(end).Cmp(ipToInt(last)) > 0 {
+		end = last
+	}
+
 	return capacity(start, end) - int64(len(ar.ExcludeIPs))
 }
 
@@ -22,6 +22,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestValidateAddressRange(t *testing.T) {
@@ -257,6 +258,23 @@ func TestCalculateCapacity(t *testing.T) {
 			},
 			99,
 		},
+		{
+			"start and end at network and broadcast address",
+			&AddressRange{
+				Start: "192.168.0.0",
+				End:   "192.168.0.3",
+				CIDR:  "192.168.0.0/30",
+			},
+			2,
+		},
+		{
+			"ipv6 start at subnet-router anycast address",
+			&AddressRange{
+				Start: "fe80::",
+				CIDR:  "fe80::/126",
+			},
+			3,
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -314,3 +332,237 @@ func TestGetNetworkType(t *testing.T) {
 		})
 	}
 }
+
+func TestIsUsingPhase(t *testing.T) {
+	tests := []struct {
+		phase    IPPhase
+		expected bool
+	}{
+		{IPPhaseUsing, true},
+		{IPPhaseBinding, true},
+		{IPPhaseBound, true},
+		{IPPhaseReserved, false},
+		{IPPhase(""), false},
+	}
+	for _, test := range tests {
+		t.Run(string(test.phase), func(t *testing.T) {
+			if IsUsingPhase(test.phase) != test.expected {
+				t.Errorf("test %s fails, expect %v but got %v", test.phase, test.expected, !test.expected)
+			}
+		})
+	}
+}
+
+func TestIsGatewayLessSubnet(t *testing.T) {
+	gatewayLess, withGateway := true, false
+	tests := []struct {
+		name     string
+		subnet   *Subnet
+		expected bool
+	}{
+		{"nil subnet", nil, false},
+		{"no config", &Subnet{}, false},
+		{"not set", &Subnet{Spec: SubnetSpec{Config: &SubnetConfig{}}}, false},
+		{"with gateway", &Subnet{Spec: SubnetSpec{Config: &SubnetConfig{GatewayLess: &withGateway}}}, false},
+		{"gateway-less", &Subnet{Spec: SubnetSpec{Config: &SubnetConfig{GatewayLess: &gatewayLess}}}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if IsGatewayLessSubnet(test.subnet) != test.expected {
+				t.Errorf("test %s fails, expect %v but got %v", test.name, test.expected, !test.expected)
+			}
+		})
+	}
+}
+
+func TestPickNetworkByPriority(t *testing.T) {
+	newNetwork := func(name string, priority int32) Network {
+		return Network{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       NetworkSpec{Priority: priority},
+		}
+	}
+
+	tests := []struct {
+		name              string
+		networks          []Network
+		expectedNetwork   string
+		expectedAmbiguous bool
+	}{
+		{
+			"no network",
+			nil,
+			"",
+			false,
+		},
+		{
+			"single network",
+			[]Network{newNetwork("net1", 0)},
+			"net1",
+			false,
+		},
+		{
+			"higher priority wins",
+			[]Network{newNetwork("net1", 0), newNetwork("net2", 10), newNetwork("net3", 5)},
+			"net2",
+			false,
+		},
+		{
+			"same priority picked by name",
+			[]Network{newNetwork("net2", 0), newNetwork("net1", 0)},
+			"net1",
+			true,
+		},
+		{
+			"tie of lower priority is not ambiguous",
+			[]Network{newNetwork("net1", 0), newNetwork("net2", 0), newNetwork("net3", 1)},
+			"net3",
+			false,
+		},
+		{
+			"tie of highest priority",
+			[]Network{newNetwork("net3", 1), newNetwork("net1", 0), newNetwork("net2", 1)},
+			"net2",
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			network, ambiguous := PickNetworkByPriority(test.networks)
+			var networkName string
+			if network != nil {
+				networkName = network.Name
+			}
+			if networkName != test.expectedNetwork || ambiguous != test.expectedAmbiguous {
+				t.Errorf("test %s fails, expect %s(ambiguous %v) but got %s(ambiguous %v)", test.name,
+					test.expectedNetwork, test.expectedAmbiguous, networkName, ambiguous)
+			}
+		})
+	}
+}
+
+func TestGetNetworkPrimaryIPVersion(t *testing.T) {
+	networkOf := func(primaryIPFamily PrimaryIPFamily) *Network {
+		return &Network{Spec: NetworkSpec{PrimaryIPFamily: primaryIPFamily}}
+	}
+
+	tests := []struct {
+		name       string
+		network    *Network
+		preferIPv6 bool
+		expected   IPVersion
+	}{
+		{"nil", nil, false, IPv4},
+		{"nil prefer ipv6", nil, true, IPv6},
+		{"empty", networkOf(""), false, IPv4},
+		{"empty prefer ipv6", networkOf(""), true, IPv6},
+		{"ipv4 first", networkOf(PrimaryIPFamilyIPv4), true, IPv4},
+		{"ipv6 first", networkOf(PrimaryIPFamilyIPv6), false, IPv6},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if version := GetNetworkPrimaryIPVersion(test.network, test.preferIPv6); version != test.expected {
+				t.Errorf("test %s fails, expect %s but got %s", test.name, test.expected, version)
+			}
+		})
+	}
+}
+
+func TestIsSubnetTopologyMatched(t *testing.T) {
+	weight := int32(10)
+	subnetWithTopology := func(topology *SubnetTopology) *Subnet {
+		return &Subnet{Spec: SubnetSpec{Config: &SubnetConfig{Topology: topology}}}
+	}
+	nodeLabels := map[string]string{"topology.kubernetes.io/zone": "zone-a"}
+
+	tests := []struct {
+		name     string
+		subnet   *Subnet
+		matched  bool
+		weight   int32
+		weighted bool
+	}{
+		{"nil subnet", nil, false, 0, false},
+		{"no config", &Subnet{}, false, 0, false},
+		{"no topology", subnetWithTopology(nil), false, 0, false},
+		{"matched", subnetWithTopology(&SubnetTopology{Key: "topology.kubernetes.io/zone", Value: "zone-a"}), true, 0, false},
+		{"value unmatched", subnetWithTopology(&SubnetTopology{Key: "topology.kubernetes.io/zone", Value: "zone-b"}), false, 0, false},
+		{"key missing", subnetWithTopology(&SubnetTopology{Key: "topology.kubernetes.io/region", Value: "zone-a"}), false, 0, false},
+		{"weighted", subnetWithTopology(&SubnetTopology{Key: "topology.kubernetes.io/zone", Value: "zone-a", Weight: &weight}), true, 10, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.matched, IsSubnetTopologyMatched(test.subnet, nodeLabels))
+			weight, weighted := GetSubnetTopologyWeight(test.subnet)
+			assert.Equal(t, test.weight, weight)
+			assert.Equal(t, test.weighted, weighted)
+		})
+	}
+}
+
+func TestCheckNetworkAdmission(t *testing.T) {
+	policies := []NetworkAdmissionPolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "underlay-teams"},
+			Spec: NetworkAdmissionPolicySpec{
+				NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+				NetworkTypes:      []NetworkType{NetworkTypeUnderlay},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "underlay-ops"},
+			Spec: NetworkAdmissionPolicySpec{
+				NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "ops"}},
+				NetworkTypes:      []NetworkType{NetworkTypeUnderlay},
+				Networks:          []string{"net-ops"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "dual-stack"},
+			Spec: NetworkAdmissionPolicySpec{
+				NamespaceSelector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "dual-stack", Operator: metav1.LabelSelectorOpExists},
+				}},
+				IPFamilies: []string{"DualStack"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "invalid"},
+			Spec: NetworkAdmissionPolicySpec{
+				NamespaceSelector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "team", Operator: "Unknown"},
+				}},
+				Networks: []string{"net-invalid"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		network     string
+		networkType NetworkType
+		ipFamily    string
+		allowed     bool
+	}{
+		{"ungoverned", nil, "net1", NetworkTypeOverlay, "IPv4Only", true},
+		{"governed type allowed", map[string]string{"team": "a"}, "net1", NetworkTypeUnderlay, "IPv4Only", true},
+		{"governed type allowed by another policy", map[string]string{"team": "ops"}, "", NetworkTypeUnderlay, "IPv4Only", true},
+		{"governed type denied", map[string]string{"team": "b"}, "", NetworkTypeUnderlay, "IPv4Only", false},
+		{"governed type case insensitive", nil, "", "underlay", "IPv4Only", false},
+		{"governed network denied", map[string]string{"team": "a"}, "net-ops", NetworkTypeUnderlay, "IPv4Only", false},
+		{"governed network allowed", map[string]string{"team": "ops"}, "net-ops", NetworkTypeUnderlay, "IPv4Only", true},
+		{"governed family allowed", map[string]string{"dual-stack": ""}, "", NetworkTypeOverlay, "DualStack", true},
+		{"governed family denied", map[string]string{"team": "a"}, "", NetworkTypeUnderlay, "DualStack", false},
+		{"invalid selector", map[string]string{"team": "a"}, "net-invalid", NetworkTypeOverlay, "IPv4Only", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckNetworkAdmission(policies, test.labels, test.network, test.networkType, test.ipFamily)
+			assert.Equal(t, test.allowed, err == nil, "unexpected admission: %v", err)
+		})
+	}
+
+	assert.NoError(t, CheckNetworkAdmission(nil, nil, "net-ops", NetworkTypeUnderlay, "DualStack"))
+}
@@ -17,18 +17,125 @@
 package constants
 
 const (
-	AnnotationIP       = "networking.alibaba.com/ip"
-	AnnotationIPPool   = "networking.alibaba.com/ip-pool"
+	AnnotationIP = "networking.alibaba.com/ip"
+
+	// AnnotationIPPool pins ips of stateful pod by ordinal, in format of "ip0,ip1" with "v4/v6" for
+	// each ordinal of dual-stack pod, or "ipv4=ip0,ip1;ipv6=ip0" whose families are mapped independently
+	// and the family not covered will be allocated dynamically
+	AnnotationIPPool = "networking.alibaba.com/ip-pool"
+
 	AnnotationIPFamily = "networking.alibaba.com/ip-family"
 
 	AnnotationIPRetain = "networking.alibaba.com/ip-retain"
 
+	// AnnotationIPScaleDownPolicy is set on StatefulSet to decide whether ips of the pods removed by
+	// scaling down, whose ordinals are not less than replicas, are reserved ("Reserve" by default) or
+	// released ("Release")
+	AnnotationIPScaleDownPolicy = "networking.alibaba.com/ip-scale-down-policy"
+
+	// AnnotationNetwork and AnnotationSubnet record where the allocated IPs of pod come from,
+	// subnets are joined by "/" with ipv4 first on dual stack mode
+	AnnotationNetwork = "networking.alibaba.com/network"
+	AnnotationSubnet  = "networking.alibaba.com/subnet"
+
 	AnnotationSpecifiedNetwork = "networking.alibaba.com/specified-network"
 	AnnotationSpecifiedSubnet  = "networking.alibaba.com/specified-subnet"
 
+	// AnnotationSpecifiedNetIDRange is used to allocate from any subnet whose net ID
+	// is in range, e.g. "100-110"
+	AnnotationSpecifiedNetIDRange = "networking.alibaba.com/specified-netid-range"
+
+	// AnnotationSubnetAntiAffinity asks for allocating pod from a subnet which is not occupied by the
+	// listed workloads in the same namespace, e.g. "StatefulSet/db,Deployment/web", it is best-effort
+	// and allocation falls back to any subnet if no subnet satisfies, isolation is at subnet level only
+	// and a subnet holding any ip of the listed workloads is not used as a whole
+	AnnotationSubnetAntiAffinity = "networking.alibaba.com/subnet-anti-affinity"
+
+	// AnnotationSubnetAffinity asks for allocating pod from the same subnets as a related pod in the same
+	// namespace, referred by name, e.g. "db-0", or by label selector, e.g. "app=db,role=leader", it is
+	// best-effort and allocation falls back to any subnet if the subnets are unknown or exhausted
+	AnnotationSubnetAffinity = "networking.alibaba.com/subnet-affinity"
+
 	AnnotationNetworkType = "networking.alibaba.com/network-type"
 
+	// AnnotationInterfaceSysctls is used to set allowlisted sysctls on pod interface,
+	// e.g. "net.ipv4.conf.rp_filter=2,net.ipv6.conf.disable_ipv6=0"
+	AnnotationInterfaceSysctls = "networking.alibaba.com/interface-sysctls"
+
+	// AnnotationHostInterface records the host side veth of pod for node-side debugging,
+	// it is only set when daemon runs with --annotate-host-interface
+	AnnotationHostInterface = "networking.alibaba.com/host-interface"
+
+	// AnnotationCalicoPodIPs is the pod ips annotation read by Calico, e.g. "192.168.0.2/32,fd00::2/128", it is
+	// set by daemon for pods in networks interoperating with Calico
+	AnnotationCalicoPodIPs = "cni.projectcalico.org/podIPs"
+
+	// AnnotationInterfaces lists all the interfaces expected by a multi-nic pod, e.g. "eth0,net1",
+	// the default interface is configured by hybridnet cni and the others are delegated to cni
+	// plugins using hybridnet ipam
+	AnnotationInterfaces = "networking.alibaba.com/interfaces"
+
+	// AnnotationExternalIP marks pod whose ip instances are created by an external controller,
+	// allocation will be skipped but nic of pod will still be configured by daemon
+	AnnotationExternalIP = "networking.alibaba.com/external-ip"
+
+	// AnnotationAllocationFailure records the last permanent allocation failure of pod, it is set
+	// by manager and removed once ips are allocated
+	AnnotationAllocationFailure = "networking.alibaba.com/allocation-failure"
+
+	// AnnotationNetworkExhausted records the network which has no ip available for pod, it is set
+	// by manager and removed once ips are allocated
+	AnnotationNetworkExhausted = "networking.alibaba.com/network-exhausted"
+
+	// AnnotationCorrelationID records the pod uid and allocation attempt which the ips of pod come
+	// from, it is set by manager and logged by both manager and daemon
+	AnnotationCorrelationID = "networking.alibaba.com/correlation-id"
+
+	// AnnotationAllocationRequested records the time when allocation is requested for pod by daemon, pods
+	// without it are not allocated by manager in lazy allocation mode
+	AnnotationAllocationRequested = "networking.alibaba.com/allocation-requested"
+
+	// AnnotationIPReleaseOnDeletion asks manager to release the ips of stateful pod on its deletion
+	// instead of reserving them, e.g., set by crash-loop ip reclaimer
+	AnnotationIPReleaseOnDeletion = "networking.alibaba.com/ip-release-on-deletion"
+
+	// AnnotationIPConflicted is set on IPInstance with the reason if its address is found in use outside of
+	// cluster, e.g., by daemon once the address of a vlan pod is answered by another MAC address before its nic
+	// is configured, or by other detectors, the address will be quarantined in subnet by manager
+	AnnotationIPConflicted = "networking.alibaba.com/ip-conflicted"
+
+	// AnnotationIPReadinessGate set to "true" makes daemon report PodConditionIPBound once the nic of pod
+	// is configured, it only works together with a readiness gate of the same condition type in pod spec
+	AnnotationIPReadinessGate = "networking.alibaba.com/ip-readiness-gate"
+
+	// AnnotationIngressBandwidth and AnnotationEgressBandwidth are used to limit the rate of pod
+	// traffic in bits per second, e.g. "10M", they only take effect when daemon runs with
+	// --enable-bandwidth-shaping
+	AnnotationIngressBandwidth = "networking.alibaba.com/ingress-bandwidth"
+	AnnotationEgressBandwidth  = "networking.alibaba.com/egress-bandwidth"
+
+	// AnnotationLoopbackSubnet asks for an additional loopback ip from the subnet of a bgp network, e.g.
+	// for anycast services, which is configured on a dummy interface of pod and advertised by bgp as a host route
+	AnnotationLoopbackSubnet = "networking.alibaba.com/loopback-subnet"
+
+	// AnnotationRouteTable selects a route table on host for the routes of pod ips and the traffic from
+	// pod, e.g. "100", for policy routing
+	AnnotationRouteTable = "networking.alibaba.com/route-table"
+
+	// AnnotationIPSwap asks manager to swap the ip of a running pod for a new one, from the subnet
+	// of the value or the same subnet if empty, it only works with feature gate IPSwap
+	AnnotationIPSwap = "networking.alibaba.com/ip-swap"
+
+	// AnnotationIPSwapFrom is set on the ip instance swapped in with the original address, which is
+	// removed from the nic of pod by daemon once the swap is committed
+	AnnotationIPSwapFrom = "networking.alibaba.com/ip-swap-from"
+
 	AnnotationNodeVtepIP           = "networking.alibaba.com/vtep-ip"
 	AnnotationNodeVtepMac          = "networking.alibaba.com/vtep-mac"
 	AnnotationNodeLocalVxlanIPList = "networking.alibaba.com/local-vxlan-ip-list"
 )
+
+const (
+	IPScaleDownPolicyReserve = "Reserve"
+	IPScaleDownPolicyRelease = "Release"
+)
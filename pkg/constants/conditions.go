@@ -0,0 +1,36 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package constants
+
+// PodConditionIPAllocated reports the committed ip allocation of pod in pod status, it is set to
+// "True" with network, subnets and addresses in message once ips are coupled with pod, and set to
+// "False" once they are released, or with the reason and error if allocation fails before ips are coupled
+const PodConditionIPAllocated = "networking.alibaba.com/IPAllocated"
+
+// PodConditionIPBound is reported by daemon for pods opting in with AnnotationIPReadinessGate, it is
+// set to "True" once ip instances are bound and the nic is configured, so a pod declaring it as a
+// readiness gate will not be ready before its network is really up
+const PodConditionIPBound = "networking.alibaba.com/IPBound"
+
+const (
+	ReasonIPAllocated = "IPAllocated"
+	ReasonIPReleased  = "IPReleased"
+	ReasonIPBound     = "IPBound"
+
+	ReasonIPAllocationFailed = "IPAllocationFailed"
+	ReasonNetworkExhausted   = "NetworkExhausted"
+)
@@ -18,3 +18,9 @@ package constants
 
 const FinalizerIPAllocated = "networking.alibaba.com/ip-allocated"
 const FinalizerManagerRuntimeRegistered = "multicluster.alibaba.com/manager-runtime-registered"
+
+// FinalizerSubnetProtection holds a deleting subnet until all of its ip instances are recycled
+const FinalizerSubnetProtection = "networking.alibaba.com/subnet-protection"
+
+// FinalizerIPBindingProtection holds a deleting ip binding until its ip instances in use are handed over to pods
+const FinalizerIPBindingProtection = "networking.alibaba.com/ip-binding-protection"
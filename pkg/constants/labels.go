@@ -23,6 +23,17 @@ const (
 	LabelNode    = "networking.alibaba.com/node"
 	LabelPod     = "networking.alibaba.com/pod"
 
+	// LabelLoopbackPod is set on the ip instance of loopback ip instead of LabelPod, so that it is never
+	// taken as an ip of pod nic
+	LabelLoopbackPod = "networking.alibaba.com/loopback-pod"
+
+	// LabelSwapPod is set on the ip instance of an ip being swapped in for pod instead of LabelPod, until
+	// the swap is committed and it replaces the original ip of pod
+	LabelSwapPod = "networking.alibaba.com/swap-pod"
+
+	LabelOwnerKind = "networking.alibaba.com/owner-kind"
+	LabelOwnerName = "networking.alibaba.com/owner-name"
+
 	LabelSpecifiedNetwork = "networking.alibaba.com/specified-network"
 	LabelSpecifiedSubnet  = "networking.alibaba.com/specified-subnet"
 
@@ -33,6 +44,10 @@ const (
 
 	LabelNetworkType = "networking.alibaba.com/network-type"
 
+	// LabelPodIPConfigMap marks the pod ip ConfigMap maintained by manager, ConfigMaps of the same name
+	// without it are managed by users and never overwritten
+	LabelPodIPConfigMap = "networking.alibaba.com/pod-ip-configmap"
+
 	LabelUnderlayNetworkAttachment = "networking.alibaba.com/underlay-network-attachment"
 	LabelOverlayNetworkAttachment  = "networking.alibaba.com/overlay-network-attachment"
 )
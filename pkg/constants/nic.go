@@ -5,4 +5,7 @@ const (
 	ContainerHostLinkMac    = "ee:ee:ee:ee:ee:ee"
 	VxlanLinkInfix          = ".vxlan"
 	ContainerNicName        = "eth0"
+
+	// ContainerLoopbackNicName is the dummy interface in pod carrying its loopback ip
+	ContainerLoopbackNicName = "loopback0"
 )
@@ -24,6 +24,8 @@ const (
 
 	RpFilterSysctl = "/proc/sys/net/ipv4/conf/%s/rp_filter"
 
+	PromoteSecondariesSysctl = "/proc/sys/net/ipv4/conf/%s/promote_secondaries"
+
 	IPv4NeighGCThresh1 = "/proc/sys/net/ipv4/neigh/default/gc_thresh1"
 	IPv4NeighGCThresh2 = "/proc/sys/net/ipv4/neigh/default/gc_thresh2"
 	IPv4NeighGCThresh3 = "/proc/sys/net/ipv4/neigh/default/gc_thresh3"
@@ -175,7 +175,7 @@ func (r *RemoteVtepReconciler) pickEndpointIPListForNode(ctx context.Context, no
 			continue
 		}
 		// only using IP will be valid endpoint
-		if ipInstance == nil || ipInstance.Status.Phase != networkingv1.IPPhaseUsing {
+		if ipInstance == nil || !networkingv1.IsUsingPhase(ipInstance.Status.Phase) {
 			continue
 		}
 		endpointIP, _, _ := net.ParseCIDR(ipInstance.Spec.Address.IP)
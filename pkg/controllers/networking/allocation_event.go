@@ -0,0 +1,163 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/ipam/types"
+	"github.com/alibaba/hybridnet/pkg/utils/transform"
+)
+
+type AllocationEventAction string
+
+const (
+	AllocationEventActionAllocate AllocationEventAction = "Allocate"
+	AllocationEventActionAssign   AllocationEventAction = "Assign"
+	AllocationEventActionRelease  AllocationEventAction = "Release"
+	AllocationEventActionReserve  AllocationEventAction = "Reserve"
+	AllocationEventActionDecouple AllocationEventAction = "Decouple"
+)
+
+const AllocationEventSinkJSON = "json"
+
+// AllocationEvent is the structured record of an allocation lifecycle event of pod, it carries
+// full details which may be lost in kubernetes events because of aggregation
+type AllocationEvent struct {
+	Timestamp    time.Time             `json:"timestamp"`
+	Action       AllocationEventAction `json:"action"`
+	PodName      string                `json:"podName"`
+	PodNamespace string                `json:"podNamespace"`
+	PodUID       string                `json:"podUID"`
+	NodeName     string                `json:"nodeName,omitempty"`
+	Network      string                `json:"network,omitempty"`
+	IPs          []AllocationEventIP   `json:"ips,omitempty"`
+	Decision     string                `json:"decision,omitempty"`
+	Forced       bool                  `json:"forced,omitempty"`
+	// Local reports whether ips are allocated from subnets local to the topology of node,
+	// it is absent if subnet topology is not involved in allocation
+	Local *bool `json:"local,omitempty"`
+}
+
+type AllocationEventIP struct {
+	Address string `json:"address"`
+	Gateway string `json:"gateway,omitempty"`
+	Subnet  string `json:"subnet"`
+	Network string `json:"network"`
+}
+
+// AllocationEventSink receives allocation events of pods, implementations should not block
+// reconciliation for long
+type AllocationEventSink interface {
+	Record(event *AllocationEvent)
+}
+
+// NewAllocationEventSink returns the sink of specified type, nil will be returned for empty type
+// which means allocation events are not exported
+func NewAllocationEventSink(sinkType string, w io.Writer) (AllocationEventSink, error) {
+	switch sinkType {
+	case "":
+		return nil, nil
+	case AllocationEventSinkJSON:
+		return NewJSONAllocationEventSink(w), nil
+	default:
+		return nil, fmt.Errorf("unsupported allocation event sink %q", sinkType)
+	}
+}
+
+// jsonAllocationEventSink writes allocation events as json lines
+type jsonAllocationEventSink struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+}
+
+func NewJSONAllocationEventSink(w io.Writer) AllocationEventSink {
+	return &jsonAllocationEventSink{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+func (j *jsonAllocationEventSink) Record(event *AllocationEvent) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	// allocation has been done, failures of exporting events should never affect it
+	_ = j.encoder.Encode(event)
+}
+
+// allocatedIPsForEvent lists the allocated ips of pod and their network to be carried by allocation
+// event, which should be called before the ips are changed. Nothing is listed if allocation events
+// are not exported, and allocation events are best effort so failures of listing are ignored.
+func (r *PodReconciler) allocatedIPsForEvent(pod *corev1.Pod) (string, []*types.IP) {
+	if r.AllocationEventSink == nil {
+		return "", nil
+	}
+
+	ipInstances, err := utils.ListAllocatedIPInstancesOfPod(r, pod)
+	if err != nil || len(ipInstances) == 0 {
+		return "", nil
+	}
+	return ipInstances[0].Spec.Network, transform.TransferIPInstancesForIPAM(ipInstances)
+}
+
+// recordAllocationEvent exports allocation event of pod to sink if sink is configured
+func (r *PodReconciler) recordAllocationEvent(pod *corev1.Pod, action AllocationEventAction, networkName string,
+	ips []*types.IP, decision string, forced bool) {
+	r.recordAllocationEventWithLocality(pod, action, networkName, ips, decision, forced, nil)
+}
+
+// recordAllocationEventWithLocality is recordAllocationEvent with the topology locality of allocated ips
+func (r *PodReconciler) recordAllocationEventWithLocality(pod *corev1.Pod, action AllocationEventAction, networkName string,
+	ips []*types.IP, decision string, forced bool, local *bool) {
+	if r.AllocationEventSink == nil {
+		return
+	}
+
+	event := &AllocationEvent{
+		Timestamp:    time.Now(),
+		Action:       action,
+		PodName:      pod.Name,
+		PodNamespace: pod.Namespace,
+		PodUID:       string(pod.UID),
+		NodeName:     pod.Spec.NodeName,
+		Network:      networkName,
+		Decision:     strings.TrimPrefix(decision, ", "),
+		Forced:       forced,
+		Local:        local,
+	}
+	for _, ip := range ips {
+		eventIP := AllocationEventIP{
+			Address: ip.Address.String(),
+			Subnet:  ip.Subnet,
+			Network: ip.Network,
+		}
+		if ip.Gateway != nil {
+			eventIP.Gateway = ip.Gateway.String()
+		}
+		event.IPs = append(event.IPs, eventIP)
+	}
+
+	r.AllocationEventSink.Record(event)
+}
@@ -0,0 +1,162 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+)
+
+// eventSink keeps the allocation events recorded
+type eventSink struct {
+	events []*AllocationEvent
+}
+
+func (e *eventSink) Record(event *AllocationEvent) {
+	e.events = append(e.events, event)
+}
+
+// eventClient lists the ip instances in namespace
+type eventClient struct {
+	client.Client
+	ipInstances []networkingv1.IPInstance
+}
+
+func (e *eventClient) List(_ context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOptions := &client.ListOptions{}
+	listOptions.ApplyOptions(opts)
+
+	ipList := list.(*networkingv1.IPInstanceList)
+	for _, ipInstance := range e.ipInstances {
+		if len(listOptions.Namespace) == 0 || listOptions.Namespace == ipInstance.Namespace {
+			ipList.Items = append(ipList.Items, ipInstance)
+		}
+	}
+	return nil
+}
+
+// eventStore decouples and reserves ips without touching anything
+type eventStore struct {
+	IPAMStore
+}
+
+func (eventStore) DeCouple(_ *corev1.Pod) error {
+	return nil
+}
+
+func (eventStore) IPReserve(_ *corev1.Pod) error {
+	return nil
+}
+
+func eventIPInstance(namespace, name, address, podName string) networkingv1.IPInstance {
+	return networkingv1.IPInstance{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: networkingv1.IPInstanceSpec{
+			Network: "network1",
+			Subnet:  "subnet1",
+			Address: networkingv1.Address{IP: address, Gateway: "192.168.0.1", Version: networkingv1.IPv4},
+		},
+		Status: networkingv1.IPInstanceStatus{PodName: podName, PodNamespace: namespace},
+	}
+}
+
+func TestAllocationEventsCarryIPs(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1", UID: "pod1-uid"},
+		Spec:       corev1.PodSpec{NodeName: "node1"},
+	}
+	terminating := eventIPInstance("default", "terminating", "192.168.0.4/24", "pod1")
+	terminating.DeletionTimestamp = &metav1.Time{}
+	ipInstances := []networkingv1.IPInstance{
+		eventIPInstance("default", "own", "192.168.0.2/24", "pod1"),
+		eventIPInstance("default", "other", "192.168.0.3/24", "pod2"),
+		eventIPInstance("other", "other-namespace", "192.168.0.5/24", "pod1"),
+		terminating,
+	}
+
+	tests := []struct {
+		name           string
+		act            func(r *PodReconciler) error
+		expectedAction AllocationEventAction
+	}{
+		{
+			"decouple",
+			func(r *PodReconciler) error { return r.decouple(pod) },
+			AllocationEventActionDecouple,
+		},
+		{
+			"reserve",
+			func(r *PodReconciler) error { return r.reserve(pod) },
+			AllocationEventActionReserve,
+		},
+		{
+			"release stateful",
+			func(r *PodReconciler) error { return r.releaseStateful(pod, "scaled-down") },
+			AllocationEventActionRelease,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sink := &eventSink{}
+			r := &PodReconciler{
+				Client:              &eventClient{ipInstances: ipInstances},
+				Recorder:            record.NewFakeRecorder(10),
+				IPAMStore:           eventStore{},
+				AllocationEventSink: sink,
+			}
+
+			if err := test.act(r); err != nil {
+				t.Fatalf("test %s fails: unexpected error %v", test.name, err)
+			}
+			if len(sink.events) != 1 {
+				t.Fatalf("test %s fails: expected one event but got %d", test.name, len(sink.events))
+			}
+
+			event := sink.events[0]
+			if event.Action != test.expectedAction || event.Network != "network1" || event.NodeName != "node1" {
+				t.Errorf("test %s fails: unexpected event %+v", test.name, event)
+			}
+			expectedIP := AllocationEventIP{Address: "192.168.0.2/24", Gateway: "192.168.0.1", Subnet: "subnet1", Network: "network1"}
+			if len(event.IPs) != 1 || event.IPs[0] != expectedIP {
+				t.Errorf("test %s fails: expected ips [%+v] but got %+v", test.name, expectedIP, event.IPs)
+			}
+		})
+	}
+}
+
+func TestAllocationEventsSkipListingWithoutSink(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1"},
+	}
+
+	// nil client will panic if ip instances are listed
+	r := &PodReconciler{
+		Recorder:  record.NewFakeRecorder(10),
+		IPAMStore: eventStore{},
+	}
+	if err := r.reserve(pod); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/request"
+)
+
+const RequesterAllocation = "AllocationRequester"
+
+// AllocationRequestPath is the http path of requesting allocation for a pod in lazy allocation mode,
+// e.g., "POST /request-allocation" with request.AllocationRequest as json body
+const AllocationRequestPath = "/request-allocation"
+
+var _ manager.Runnable = &AllocationRequester{}
+var _ manager.LeaderElectionRunnable = &AllocationRequester{}
+
+// AllocationRequester serves an endpoint for daemons to request allocation for pods whose cni add
+// requests arrive, when pods are not allocated on scheduling in lazy allocation mode. The request is
+// recorded on pod by annotation, which triggers the allocation by pod controller, so it serves on
+// every manager rather than only on leader, and survives the failover of leader.
+type AllocationRequester struct {
+	client.Client
+	Logger logr.Logger
+
+	// BindAddress is the address which request endpoint listens on, it must be a loopback address
+	// unless TLS is set, because requests annotate pods and daemons requesting are on other nodes
+	BindAddress string
+	TLS         *EndpointTLSConfig
+}
+
+func (r *AllocationRequester) NeedLeaderElection() bool {
+	return false
+}
+
+func (r *AllocationRequester) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle(AllocationRequestPath, r)
+
+	r.Logger.Info("allocation requester is serving", "address", r.BindAddress, "path", AllocationRequestPath,
+		"tls", r.TLS.Enabled())
+	if err := serveEndpoint(ctx, r.BindAddress, mux, r.TLS, true); err != nil {
+		return fmt.Errorf("unable to serve allocation requester: %v", err)
+	}
+	return nil
+}
+
+func (r *AllocationRequester) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("method %s is not allowed", req.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	allocationRequest := &request.AllocationRequest{}
+	if err := json.NewDecoder(req.Body).Decode(allocationRequest); err != nil {
+		http.Error(w, fmt.Sprintf("unable to decode allocation request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(allocationRequest.PodName) == 0 || len(allocationRequest.PodNamespace) == 0 {
+		http.Error(w, "pod name and namespace are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.Request(req.Context(), allocationRequest.PodNamespace, allocationRequest.PodName); err != nil {
+		if apierrors.IsNotFound(err) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Request marks pod as requesting allocation, nothing will be done if pod has been marked or allocated
+func (r *AllocationRequester) Request(ctx context.Context, namespace, name string) error {
+	pod := &corev1.Pod{}
+	if err := r.Get(ctx, apitypes.NamespacedName{Namespace: namespace, Name: name}, pod); err != nil {
+		return err
+	}
+
+	if metav1.HasAnnotation(pod.ObjectMeta, constants.AnnotationAllocationRequested) ||
+		metav1.HasAnnotation(pod.ObjectMeta, constants.AnnotationIP) {
+		return nil
+	}
+
+	r.Logger.V(4).Info("allocation is requested for pod", "namespace", namespace, "name", name)
+
+	patchBody, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				constants.AnnotationAllocationRequested: time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return r.Patch(ctx, pod, client.RawPatch(apitypes.MergePatchType, patchBody))
+}
@@ -0,0 +1,192 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/alibaba/hybridnet/pkg/constants"
+)
+
+// requesterClient serves pods by name and records the patches
+type requesterClient struct {
+	client.Client
+	pods    map[string]*corev1.Pod
+	patches []string
+}
+
+func (c *requesterClient) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	pod, exist := c.pods[key.Name]
+	if !exist || pod.Namespace != key.Namespace {
+		return apierrors.NewNotFound(schema.GroupResource{}, key.Name)
+	}
+	pod.DeepCopyInto(obj.(*corev1.Pod))
+	return nil
+}
+
+func (c *requesterClient) Patch(_ context.Context, obj client.Object, patch client.Patch, _ ...client.PatchOption) error {
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	c.patches = append(c.patches, obj.GetName()+":"+string(data))
+	return nil
+}
+
+func newAllocationRequester() (*AllocationRequester, *requesterClient) {
+	c := &requesterClient{
+		pods: map[string]*corev1.Pod{
+			"pending": {
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pending"},
+			},
+			"requested": {
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "default",
+					Name:        "requested",
+					Annotations: map[string]string{constants.AnnotationAllocationRequested: "2021-01-01T00:00:00Z"},
+				},
+			},
+			"allocated": {
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "default",
+					Name:        "allocated",
+					Annotations: map[string]string{constants.AnnotationIP: "192.168.0.1"},
+				},
+			},
+		},
+	}
+	return &AllocationRequester{
+		Client: c,
+		Logger: logr.Discard(),
+	}, c
+}
+
+func TestAllocationRequesterServeHTTP(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		body         string
+		expectCode   int
+		expectPatch  bool
+		expectedName string
+	}{
+		{
+			"request pending pod",
+			http.MethodPost,
+			`{"pod_name":"pending","pod_namespace":"default"}`,
+			http.StatusAccepted,
+			true,
+			"pending",
+		},
+		{
+			"pod requested already",
+			http.MethodPost,
+			`{"pod_name":"requested","pod_namespace":"default"}`,
+			http.StatusAccepted,
+			false,
+			"",
+		},
+		{
+			"pod allocated already",
+			http.MethodPost,
+			`{"pod_name":"allocated","pod_namespace":"default"}`,
+			http.StatusAccepted,
+			false,
+			"",
+		},
+		{
+			"pod not found",
+			http.MethodPost,
+			`{"pod_name":"pending","pod_namespace":"other"}`,
+			http.StatusNotFound,
+			false,
+			"",
+		},
+		{
+			"get is not allowed",
+			http.MethodGet,
+			`{"pod_name":"pending","pod_namespace":"default"}`,
+			http.StatusMethodNotAllowed,
+			false,
+			"",
+		},
+		{
+			"invalid body",
+			http.MethodPost,
+			`pending`,
+			http.StatusBadRequest,
+			false,
+			"",
+		},
+		{
+			"missing namespace",
+			http.MethodPost,
+			`{"pod_name":"pending"}`,
+			http.StatusBadRequest,
+			false,
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requester, c := newAllocationRequester()
+
+			recorder := httptest.NewRecorder()
+			requester.ServeHTTP(recorder, httptest.NewRequest(test.method, AllocationRequestPath, strings.NewReader(test.body)))
+			if recorder.Code != test.expectCode {
+				t.Fatalf("expect code %d, got %d: %s", test.expectCode, recorder.Code, recorder.Body.String())
+			}
+
+			if !test.expectPatch {
+				if len(c.patches) > 0 {
+					t.Errorf("expect nothing patched, got %v", c.patches)
+				}
+				return
+			}
+			if len(c.patches) != 1 {
+				t.Fatalf("expect one patch, got %v", c.patches)
+			}
+			if !strings.HasPrefix(c.patches[0], test.expectedName+":") ||
+				!strings.Contains(c.patches[0], constants.AnnotationAllocationRequested) {
+				t.Errorf("expect pod %s annotated as requested, got %s", test.expectedName, c.patches[0])
+			}
+		})
+	}
+}
+
+func TestAllocationRequesterRefusesNonLoopbackAddressWithoutTLS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	requester, _ := newAllocationRequester()
+	requester.BindAddress = ":0"
+	if err := requester.Start(ctx); err == nil {
+		t.Errorf("expect requester refused on all interfaces without tls")
+	}
+}
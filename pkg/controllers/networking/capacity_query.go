@@ -0,0 +1,283 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/ipam/types"
+	globalutils "github.com/alibaba/hybridnet/pkg/utils"
+)
+
+const QuerierCapacity = "CapacityQuerier"
+
+// CapacityQueryPath is the http path of querying capacity for a prospective pod, e.g.,
+// "POST /query-capacity?node=node1" with the pod as json body, both node and network
+// parameters are optional
+const CapacityQueryPath = "/query-capacity"
+
+var _ manager.Runnable = &CapacityQuerier{}
+
+// CapacityQuerier serves an endpoint for capacity-aware tools, e.g., cluster autoscaler, to check whether
+// a pod can still get ips before it is scheduled. The network of pod is resolved from its annotations,
+// labels and node in the same way as allocation, and nothing is allocated. It only serves on leader,
+// because the IPAM manager is only refreshed there. It is read-only, so it is allowed on any address
+// without tls, but it exposes the capacity of networks.
+type CapacityQuerier struct {
+	client.Client
+	Logger logr.Logger
+
+	IPAMManager IPAMManager
+
+	// BindAddress is the address which query endpoint listens on
+	BindAddress string
+	// TLS makes query endpoint served with mutual tls, nil means plain http
+	TLS *EndpointTLSConfig
+}
+
+// CapacityQueryOptions tells where the prospective pod is going, both of them are optional
+type CapacityQueryOptions struct {
+	// Node is the node which pod is expected to be scheduled on, it overrides spec.nodeName of pod
+	Node string
+	// Network is the network which pod is expected to use, it overrides the network resolved from pod
+	Network string
+}
+
+// CapacityQueryResult is the response of querying capacity
+type CapacityQueryResult struct {
+	Network   string           `json:"network,omitempty"`
+	IPFamily  string           `json:"ipFamily"`
+	Available bool             `json:"available"`
+	Reason    string           `json:"reason,omitempty"`
+	Subnets   []SubnetCapacity `json:"subnets,omitempty"`
+	// Candidates are the results of every network which an unscheduled underlay pod may use, pod is
+	// available if any of them is
+	Candidates []*CapacityQueryResult `json:"candidates,omitempty"`
+}
+
+// SubnetCapacity describes a subnet which can serve the pod
+type SubnetCapacity struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Available uint32 `json:"available"`
+}
+
+func (r *CapacityQuerier) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle(CapacityQueryPath, r)
+
+	r.Logger.Info("capacity querier is serving", "address", r.BindAddress, "path", CapacityQueryPath,
+		"tls", r.TLS.Enabled())
+	if err := serveEndpoint(ctx, r.BindAddress, mux, r.TLS, false); err != nil {
+		return fmt.Errorf("unable to serve capacity querier: %v", err)
+	}
+	return nil
+}
+
+func (r *CapacityQuerier) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("method %s is not allowed", req.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	pod := &corev1.Pod{}
+	if err := json.NewDecoder(req.Body).Decode(pod); err != nil {
+		http.Error(w, fmt.Sprintf("unable to decode pod: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	result, err := r.Query(pod, CapacityQueryOptions{
+		Node:    req.URL.Query().Get("node"),
+		Network: req.URL.Query().Get("network"),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// Query checks whether the network of pod has available ips for its ip family, and lists the subnets
+// which can serve it, a pod whose network can not be resolved is reported as unavailable. The underlay
+// network of an unscheduled pod depends on its node, so every underlay network is evaluated as a candidate
+// if neither node nor network is given.
+func (r *CapacityQuerier) Query(pod *corev1.Pod, options CapacityQueryOptions) (*CapacityQueryResult, error) {
+	var config = &utils.NetworkConfig{IPFamily: pod.Annotations[constants.AnnotationIPFamily]}
+	var result = &CapacityQueryResult{IPFamily: string(config.IPFamilyOf(nil))}
+
+	if len(options.Node) > 0 {
+		pod = pod.DeepCopy()
+		pod.Spec.NodeName = options.Node
+	}
+
+	var networkNames []string
+	switch {
+	case len(options.Network) > 0:
+		networkNames = []string{options.Network}
+	case len(pod.Spec.NodeName) == 0:
+		networkConfig, err := utils.ResolveNetworkConfigOfObjectIgnoringMissingSubnets(context.TODO(), r, pod)
+		if err != nil {
+			result.Reason = fmt.Sprintf("unable to resolve network config: %v", err)
+			return result, nil
+		}
+		if len(networkConfig.NetworkName) == 0 && networkConfig.NetworkTypeOf(nil) == types.Underlay {
+			networkNames = networksOfTypeInManager(r.IPAMManager, types.Underlay)
+			sort.Strings(networkNames)
+			if len(networkNames) == 0 {
+				result.Reason = "no underlay network found"
+				return result, nil
+			}
+			break
+		}
+		fallthrough
+	default:
+		networkName, _, err := selectNetworkForPod(context.TODO(), r, r.IPAMManager, pod)
+		if err != nil {
+			result.Reason = fmt.Sprintf("unable to select network: %v", err)
+			return result, nil
+		}
+		networkNames = []string{networkName}
+	}
+
+	if len(networkNames) == 1 {
+		return r.queryNetwork(pod, config, networkNames[0])
+	}
+
+	for _, networkName := range networkNames {
+		candidate, err := r.queryNetwork(pod, config, networkName)
+		if err != nil {
+			return nil, err
+		}
+		result.Available = result.Available || candidate.Available
+		result.Candidates = append(result.Candidates, candidate)
+	}
+	if !result.Available {
+		result.Reason = fmt.Sprintf("no available %s ip in any of underlay networks %s", result.IPFamily,
+			strings.Join(networkNames, ","))
+	}
+	return result, nil
+}
+
+// queryNetwork checks whether network has available ips for pod
+func (r *CapacityQuerier) queryNetwork(pod *corev1.Pod, config *utils.NetworkConfig, networkName string) (*CapacityQueryResult, error) {
+	var ipFamily = config.IPFamilyOf(nil)
+	var result = &CapacityQueryResult{Network: networkName, IPFamily: string(ipFamily)}
+
+	// the ip family inherits from the selected network if it is not specified by pod
+	if network, err := utils.GetNetwork(r, networkName); err == nil {
+		ipFamily = config.IPFamilyOf(network)
+		result.IPFamily = string(ipFamily)
+	}
+
+	subnetList, err := utils.ListSubnets(r, client.MatchingFields{IndexerFieldNetwork: networkName})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list subnets of network %s: %v", networkName, err)
+	}
+
+	var specifiedSubnets = map[string]bool{}
+	if subnetNameStr := globalutils.PickFirstNonEmptyString(pod.Annotations[constants.AnnotationSpecifiedSubnet],
+		pod.Labels[constants.LabelSpecifiedSubnet]); len(subnetNameStr) > 0 {
+		for _, subnetName := range strings.Split(subnetNameStr, "/") {
+			specifiedSubnets[subnetName] = true
+		}
+	}
+
+	// available ipv4/ipv6 subnets are grouped by net ID, which is how dual-stack subnets are paired
+	var v4NetIDs, v6NetIDs = map[int32]bool{}, map[int32]bool{}
+	for i := range subnetList.Items {
+		var subnet = &subnetList.Items[i]
+		if !subnet.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if len(specifiedSubnets) > 0 {
+			if !specifiedSubnets[subnet.Name] {
+				continue
+			}
+		} else if networkingv1.IsPrivateSubnet(subnet) {
+			continue
+		}
+
+		isIPv6 := networkingv1.IsIPv6Subnet(subnet)
+		if (isIPv6 && ipFamily == types.IPv4Only) || (!isIPv6 && ipFamily == types.IPv6Only) {
+			continue
+		}
+
+		usage, err := subnetUsageInManager(r.IPAMManager, networkName, subnet.Name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get usage of subnet %s: %v", subnet.Name, err)
+		}
+		if usage == nil || usage.Available == 0 {
+			continue
+		}
+
+		var netID int32
+		if subnet.Spec.NetID != nil {
+			netID = *subnet.Spec.NetID
+		}
+		capacity := SubnetCapacity{
+			Name:      subnet.Name,
+			Version:   string(networkingv1.IPv4),
+			Available: usage.Available,
+		}
+		if isIPv6 {
+			capacity.Version = string(networkingv1.IPv6)
+			v6NetIDs[netID] = true
+		} else {
+			v4NetIDs[netID] = true
+		}
+		result.Subnets = append(result.Subnets, capacity)
+	}
+
+	result.Available = capacityAvailable(ipFamily, v4NetIDs, v6NetIDs)
+	if !result.Available {
+		result.Reason = fmt.Sprintf("no available %s ip in network %s", ipFamily, networkName)
+	}
+	return result, nil
+}
+
+// capacityAvailable checks the net IDs of available subnets for ip family, dual-stack requires
+// an ipv4 and an ipv6 subnet paired by the same net ID
+func capacityAvailable(ipFamily types.IPFamilyMode, v4NetIDs, v6NetIDs map[int32]bool) bool {
+	switch ipFamily {
+	case types.IPv4Only:
+		return len(v4NetIDs) > 0
+	case types.IPv6Only:
+		return len(v6NetIDs) > 0
+	case types.DualStack:
+		for netID := range v4NetIDs {
+			if v6NetIDs[netID] {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,271 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/ipam"
+	"github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+func TestCapacityAvailable(t *testing.T) {
+	tests := []struct {
+		name     string
+		ipFamily types.IPFamilyMode
+		v4NetIDs map[int32]bool
+		v6NetIDs map[int32]bool
+		expected bool
+	}{
+		{"ipv4 available", types.IPv4Only, map[int32]bool{1: true}, nil, true},
+		{"ipv4 unavailable", types.IPv4Only, nil, map[int32]bool{1: true}, false},
+		{"ipv6 available", types.IPv6Only, nil, map[int32]bool{1: true}, true},
+		{"ipv6 unavailable", types.IPv6Only, map[int32]bool{1: true}, nil, false},
+		{"dual stack paired", types.DualStack, map[int32]bool{1: true, 2: true}, map[int32]bool{2: true}, true},
+		{"dual stack not paired", types.DualStack, map[int32]bool{1: true}, map[int32]bool{2: true}, false},
+		{"dual stack without ipv6", types.DualStack, map[int32]bool{1: true}, nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if available := capacityAvailable(test.ipFamily, test.v4NetIDs, test.v6NetIDs); available != test.expected {
+				t.Errorf("expected available %v, got %v", test.expected, available)
+			}
+		})
+	}
+}
+
+// capacityClient serves networks and subnets for capacity querier, subnets are listed by network indexer
+type capacityClient struct {
+	client.Client
+	networks []networkingv1.Network
+	subnets  []networkingv1.Subnet
+}
+
+func (c *capacityClient) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	if network, ok := obj.(*networkingv1.Network); ok {
+		for i := range c.networks {
+			if c.networks[i].Name == key.Name {
+				c.networks[i].DeepCopyInto(network)
+				return nil
+			}
+		}
+	}
+	return apierrors.NewNotFound(schema.GroupResource{}, key.Name)
+}
+
+func (c *capacityClient) List(_ context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOptions := &client.ListOptions{}
+	listOptions.ApplyOptions(opts)
+
+	subnetList, ok := list.(*networkingv1.SubnetList)
+	if !ok {
+		return nil
+	}
+	networkName, _ := listOptions.FieldSelector.RequiresExactMatch(IndexerFieldNetwork)
+	for i := range c.subnets {
+		if c.subnets[i].Spec.Network == networkName {
+			subnetList.Items = append(subnetList.Items, c.subnets[i])
+		}
+	}
+	return nil
+}
+
+// capacityIPAMManager reports usages of subnets by name in both single and dual stack mode
+type capacityIPAMManager struct {
+	IPAMManager
+	underlayNetworks []string
+	available        map[string]uint32
+}
+
+func (m *capacityIPAMManager) SubnetUsage(_, subnet string) (*types.Usage, error) {
+	return &types.Usage{Available: m.available[subnet]}, nil
+}
+
+func (m *capacityIPAMManager) GetNetworksByType(networkType types.NetworkType) []string {
+	if networkType == types.Underlay {
+		return m.underlayNetworks
+	}
+	return nil
+}
+
+func (m *capacityIPAMManager) DualStack() ipam.DualStackInterface {
+	return &capacityDualStackManager{manager: m}
+}
+
+type capacityDualStackManager struct {
+	ipam.DualStackInterface
+	manager *capacityIPAMManager
+}
+
+func (d *capacityDualStackManager) SubnetUsage(network, subnet string) (*types.Usage, error) {
+	return d.manager.SubnetUsage(network, subnet)
+}
+
+func (d *capacityDualStackManager) GetNetworksByType(networkType types.NetworkType) []string {
+	return d.manager.GetNetworksByType(networkType)
+}
+
+func newCapacityQuerier() *CapacityQuerier {
+	subnet := func(name, network string) networkingv1.Subnet {
+		return networkingv1.Subnet{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: networkingv1.SubnetSpec{
+				Network: network,
+				Range:   networkingv1.AddressRange{Version: networkingv1.IPv4},
+			},
+		}
+	}
+
+	return &CapacityQuerier{
+		Client: &capacityClient{
+			networks: []networkingv1.Network{
+				{ObjectMeta: metav1.ObjectMeta{Name: "underlay1"}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "underlay2"}},
+			},
+			subnets: []networkingv1.Subnet{
+				subnet("full", "underlay1"),
+				subnet("free", "underlay2"),
+			},
+		},
+		IPAMManager: &capacityIPAMManager{
+			underlayNetworks: []string{"underlay2", "underlay1"},
+			available:        map[string]uint32{"free": 10},
+		},
+	}
+}
+
+func capacityQueryPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "pod1",
+			Annotations: map[string]string{
+				constants.AnnotationNetworkType: string(types.Underlay),
+				constants.AnnotationIPFamily:    string(types.IPv4Only),
+			},
+		},
+	}
+}
+
+func TestCapacityQueryEvaluatesCandidatesForUnscheduledUnderlayPod(t *testing.T) {
+	result, err := newCapacityQuerier().Query(capacityQueryPod(), CapacityQueryOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !result.Available || len(result.Network) > 0 {
+		t.Fatalf("expected available without a single network, got %+v", result)
+	}
+	if len(result.Candidates) != 2 || result.Candidates[0].Network != "underlay1" ||
+		result.Candidates[1].Network != "underlay2" {
+		t.Fatalf("expected candidates of every underlay network in order, got %+v", result.Candidates)
+	}
+	if result.Candidates[0].Available || !result.Candidates[1].Available {
+		t.Errorf("expected only underlay2 available, got %+v, %+v", result.Candidates[0], result.Candidates[1])
+	}
+}
+
+func TestCapacityQuerierServeHTTP(t *testing.T) {
+	querier := newCapacityQuerier()
+	body, _ := json.Marshal(capacityQueryPod())
+
+	tests := []struct {
+		name         string
+		method       string
+		query        string
+		body         string
+		expectedCode int
+		expected     *CapacityQueryResult
+	}{
+		{
+			name:         "method not allowed",
+			method:       http.MethodGet,
+			body:         string(body),
+			expectedCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:         "invalid pod",
+			method:       http.MethodPost,
+			body:         "{",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "network specified",
+			method:       http.MethodPost,
+			query:        "?network=underlay2",
+			body:         string(body),
+			expectedCode: http.StatusOK,
+			expected: &CapacityQueryResult{
+				Network:   "underlay2",
+				IPFamily:  string(types.IPv4Only),
+				Available: true,
+				Subnets:   []SubnetCapacity{{Name: "free", Version: string(networkingv1.IPv4), Available: 10}},
+			},
+		},
+		{
+			name:         "network without capacity",
+			method:       http.MethodPost,
+			query:        "?network=underlay1",
+			body:         string(body),
+			expectedCode: http.StatusOK,
+			expected: &CapacityQueryResult{
+				Network:  "underlay1",
+				IPFamily: string(types.IPv4Only),
+				Reason:   "no available IPv4Only ip in network underlay1",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, CapacityQueryPath+test.query, strings.NewReader(test.body))
+			recorder := httptest.NewRecorder()
+			querier.ServeHTTP(recorder, req)
+
+			if recorder.Code != test.expectedCode {
+				t.Fatalf("expected code %d, got %d: %s", test.expectedCode, recorder.Code, recorder.Body.String())
+			}
+			if test.expected == nil {
+				return
+			}
+
+			result := &CapacityQueryResult{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), result); err != nil {
+				t.Fatalf("unable to decode result: %v", err)
+			}
+			expected, _ := json.Marshal(test.expected)
+			actual, _ := json.Marshal(result)
+			if string(expected) != string(actual) {
+				t.Errorf("expected %s, got %s", expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	policyv1beta1client "k8s.io/client-go/kubernetes/typed/policy/v1beta1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/ipam/strategy"
+)
+
+const ReclaimerCrashLoopIP = "CrashLoopIPReclaimer"
+
+const ReasonCrashLoopIPReclaimed = "CrashLoopIPReclaimed"
+
+// policies of ips held by crash-looping pods which are reclaimed
+const (
+	CrashLoopIPPolicyReserve = "reserve"
+	CrashLoopIPPolicyRelease = "release"
+)
+
+var _ manager.Runnable = &CrashLoopIPReclaimer{}
+
+// CrashLoopIPReclaimer periodically reclaims the ips held by pods crash-looping longer than threshold, for
+// subnets with scarce ips. An ip can not be taken back from a living pod sandbox safely, so the pod is
+// evicted for its workload to recreate it, pods without controllers are never touched because nothing
+// recreates them. By policy, ips of stateful pods are reserved for the recreated pods as usual, or
+// released, while ips of other pods are always released along with pods
+type CrashLoopIPReclaimer struct {
+	client.Client
+	Logger    logr.Logger
+	Recorder  record.EventRecorder
+	Evictions policyv1beta1client.EvictionsGetter
+
+	// Threshold is how long a pod is allowed to crash-loop before its ips are reclaimed
+	Threshold time.Duration
+
+	// Policy is either reserve or release, about ips of stateful pods
+	Policy string
+
+	// Period is the interval of checking, one minute by default
+	Period time.Duration
+}
+
+func (r *CrashLoopIPReclaimer) Start(ctx context.Context) error {
+	r.Logger.Info("crash-loop ip reclaimer is starting", "threshold", r.Threshold, "policy", r.Policy)
+
+	if r.Period <= 0 {
+		r.Period = time.Minute
+	}
+
+	wait.UntilWithContext(ctx, func(c context.Context) {
+		podList := &corev1.PodList{}
+		if err := r.List(c, podList); err != nil {
+			r.Logger.Error(err, "unable to list pods")
+			return
+		}
+
+		now := time.Now()
+		for i := range podList.Items {
+			var pod = &podList.Items[i]
+			if pod.Spec.HostNetwork || !pod.DeletionTimestamp.IsZero() || utils.PodIsExternallyAddressed(pod) ||
+				!metav1.HasAnnotation(pod.ObjectMeta, constants.AnnotationIP) || metav1.GetControllerOf(pod) == nil {
+				continue
+			}
+
+			since, crashLooping := utils.PodCrashLoopingSince(pod)
+			if !crashLooping || now.Sub(since) < r.Threshold {
+				continue
+			}
+
+			if err := r.reclaim(c, pod, since); err != nil {
+				r.Logger.Error(err, "unable to reclaim ips of crash-looping pod", "namespace", pod.Namespace,
+					"name", pod.Name)
+			}
+		}
+	}, r.Period)
+
+	r.Logger.Info("crash-loop ip reclaimer is stopping")
+	return nil
+}
+
+func (r *CrashLoopIPReclaimer) reclaim(ctx context.Context, pod *corev1.Pod, since time.Time) error {
+	var outcome = "released"
+	if strategy.OwnByStatefulWorkload(pod) {
+		if r.Policy == CrashLoopIPPolicyRelease {
+			// pod controller releases ips of stateful pod on deletion instead of reserving them
+			patchBody := fmt.Sprintf(`{"metadata":{"annotations":{%q:"true"}}}`, constants.AnnotationIPReleaseOnDeletion)
+			if err := r.Patch(ctx, pod, client.RawPatch(apitypes.MergePatchType, []byte(patchBody))); err != nil {
+				return client.IgnoreNotFound(err)
+			}
+		} else {
+			outcome = "reserved"
+		}
+	}
+
+	if err := evictPod(ctx, r.Evictions, pod); err != nil {
+		if apierrors.IsTooManyRequests(err) {
+			// refused by disruption budget, it will be tried again in the next period
+			r.Logger.Info("eviction of crash-looping pod is refused, retry later", "namespace", pod.Namespace,
+				"name", pod.Name, "reason", err.Error())
+			return nil
+		}
+		return client.IgnoreNotFound(err)
+	}
+
+	r.Logger.Info("reclaim ips of crash-looping pod", "namespace", pod.Namespace, "name", pod.Name,
+		"since", since, "ips", outcome)
+	r.Recorder.Eventf(pod, corev1.EventTypeWarning, ReasonCrashLoopIPReclaimed,
+		"pod has been crash-looping since %s, longer than %v, it is evicted to reclaim its IPs, which will be %s",
+		since.Format(time.RFC3339), r.Threshold, outcome)
+	return nil
+}
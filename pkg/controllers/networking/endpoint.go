@@ -0,0 +1,120 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// EndpointTLSConfig is the tls config of the http endpoints served by manager, clients must present
+// certificates signed by the client ca once it is set
+type EndpointTLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+// Enabled checks whether endpoints are served with mutual tls, it is safe to be called on nil config
+func (e *EndpointTLSConfig) Enabled() bool {
+	return e != nil && len(e.CertFile) > 0
+}
+
+// Validate checks that cert, key and client ca files are set together
+func (e *EndpointTLSConfig) Validate() error {
+	if e == nil || (len(e.CertFile) == 0 && len(e.KeyFile) == 0 && len(e.ClientCAFile) == 0) {
+		return nil
+	}
+	if len(e.CertFile) == 0 || len(e.KeyFile) == 0 || len(e.ClientCAFile) == 0 {
+		return fmt.Errorf("tls cert, key and client ca files of endpoints must be set together")
+	}
+	return nil
+}
+
+// serveEndpoint serves handler on address until ctx is done. Endpoints are served with mutual tls if it
+// is configured, otherwise the protected ones, which change pods or ips, are only allowed on loopback
+// addresses, because nothing else authenticates the requests
+func serveEndpoint(ctx context.Context, address string, handler http.Handler, tlsConfig *EndpointTLSConfig, protected bool) error {
+	if protected && !tlsConfig.Enabled() && !isLoopbackAddress(address) {
+		return fmt.Errorf("endpoint on non-loopback address %s must be served with tls", address)
+	}
+
+	server := &http.Server{
+		Addr:    address,
+		Handler: handler,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	var err error
+	if tlsConfig.Enabled() {
+		if server.TLSConfig, err = newEndpointTLSConfig(tlsConfig.ClientCAFile); err != nil {
+			return err
+		}
+		err = server.ListenAndServeTLS(tlsConfig.CertFile, tlsConfig.KeyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// newEndpointTLSConfig requires and verifies client certificates by the ca file
+func newEndpointTLSConfig(clientCAFile string) (*tls.Config, error) {
+	caPEM, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read client ca file %s: %v", clientCAFile, err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificate is found in client ca file %s", clientCAFile)
+	}
+
+	return &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+// isLoopbackAddress checks whether the listening address only accepts local connections,
+// an address without host listens on all interfaces
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil || len(host) == 0 {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,78 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestIsLoopbackAddress(t *testing.T) {
+	tests := []struct {
+		address  string
+		loopback bool
+	}{
+		{"127.0.0.1:9901", true},
+		{"[::1]:9901", true},
+		{"localhost:9901", true},
+		{":9901", false},
+		{"0.0.0.0:9901", false},
+		{"10.0.0.1:9901", false},
+		{"invalid", false},
+	}
+
+	for _, test := range tests {
+		if loopback := isLoopbackAddress(test.address); loopback != test.loopback {
+			t.Errorf("expect loopback %v of %s, got %v", test.loopback, test.address, loopback)
+		}
+	}
+}
+
+func TestEndpointTLSConfigValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    *EndpointTLSConfig
+		enabled   bool
+		expectErr bool
+	}{
+		{"nil", nil, false, false},
+		{"empty", &EndpointTLSConfig{}, false, false},
+		{"complete", &EndpointTLSConfig{CertFile: "tls.crt", KeyFile: "tls.key", ClientCAFile: "ca.crt"}, true, false},
+		{"missing client ca", &EndpointTLSConfig{CertFile: "tls.crt", KeyFile: "tls.key"}, true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.config.Validate(); (err != nil) != test.expectErr {
+				t.Errorf("expect error %v, got %v", test.expectErr, err)
+			}
+			if enabled := test.config.Enabled(); enabled != test.enabled {
+				t.Errorf("expect enabled %v, got %v", test.enabled, enabled)
+			}
+		})
+	}
+}
+
+func TestServeProtectedEndpointRefusesNonLoopbackWithoutTLS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := serveEndpoint(ctx, ":0", http.NotFoundHandler(), nil, true); err == nil {
+		t.Errorf("expect protected endpoint refused on all interfaces without tls")
+	}
+}
@@ -16,11 +16,40 @@
 
 package networking
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 func wrapError(wrapMessage string, err error) error {
 	if err == nil {
 		return nil
 	}
-	return fmt.Errorf("%s: %v", wrapMessage, err)
+	return fmt.Errorf("%s: %w", wrapMessage, err)
+}
+
+// PermanentError means a failure which will not be recovered by retrying,
+// e.g. misconfiguration of pod, so it should not be requeued
+type PermanentError struct {
+	Err error
+}
+
+func (p *PermanentError) Error() string {
+	return p.Err.Error()
+}
+
+func (p *PermanentError) Unwrap() error {
+	return p.Err
+}
+
+func newPermanentError(format string, args ...interface{}) error {
+	return &PermanentError{
+		Err: fmt.Errorf(format, args...),
+	}
+}
+
+// IsPermanentError checks whether a permanent error exists in error chain
+func IsPermanentError(err error) bool {
+	var permanentError *PermanentError
+	return errors.As(err, &permanentError)
 }
@@ -17,8 +17,11 @@
 package networking
 
 import (
+	"context"
+
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
 	"github.com/alibaba/hybridnet/pkg/constants"
 	"github.com/alibaba/hybridnet/pkg/controllers/utils"
 	"github.com/alibaba/hybridnet/pkg/feature"
@@ -80,17 +83,57 @@ func SubnetGetter(c client.Reader) allocator.SubnetGetter {
 			return nil, err
 		}
 
+		reservations, err := activeIPReservations(c)
+		if err != nil {
+			return nil, err
+		}
+
 		var subnets []*ipamtypes.Subnet
 		for i := range subnetList.Items {
 			subnet := &subnetList.Items[i]
 			if subnet.Spec.Network == networkName {
-				subnets = append(subnets, transform.TransferSubnetForIPAM(subnet))
+				ipamSubnet := transform.TransferSubnetForIPAM(subnet)
+				ipamSubnet.RangeReservedIPs = rangeReservedIPsOf(ipamSubnet, reservations[subnet.Name])
+				subnets = append(subnets, ipamSubnet)
 			}
 		}
 		return subnets, nil
 	}
 }
 
+// activeIPReservations lists the active ip reservations by subnets, the others never affect ipam
+func activeIPReservations(c client.Reader) (map[string][]*networkingv1.IPReservation, error) {
+	var reservationList = &networkingv1.IPReservationList{}
+	if err := c.List(context.TODO(), reservationList); err != nil {
+		return nil, err
+	}
+
+	var reservations = map[string][]*networkingv1.IPReservation{}
+	for i := range reservationList.Items {
+		reservation := &reservationList.Items[i]
+		if reservation.DeletionTimestamp.IsZero() && networkingv1.IsIPReservationActive(reservation) {
+			reservations[reservation.Spec.Subnet] = append(reservations[reservation.Spec.Subnet], reservation)
+		}
+	}
+	return reservations, nil
+}
+
+// rangeReservedIPsOf expands the ranges of active ip reservations of subnet, which have been validated
+// before being active
+func rangeReservedIPsOf(subnet *ipamtypes.Subnet, reservations []*networkingv1.IPReservation) map[string]struct{} {
+	var ips = map[string]struct{}{}
+	for _, reservation := range reservations {
+		rangeIPs, err := utils.ExpandIPRange(reservation.Spec.Start, reservation.Spec.End, subnet.CIDR, MaxIPReservationSize)
+		if err != nil {
+			continue
+		}
+		for _, ip := range rangeIPs {
+			ips[ip] = struct{}{}
+		}
+	}
+	return ips
+}
+
 func IPSetGetter(c client.Reader) allocator.IPSetGetter {
 	return func(subnetName string) (ipamtypes.IPSet, error) {
 		ipList, err := utils.ListIPInstances(c, client.MatchingLabels{
@@ -119,6 +162,27 @@ func (i *ipamManager) DualStack() ipam.DualStackInterface {
 	return i.dualStack
 }
 
+func reserveRangeInManager(manager IPAMManager, networkName, subnetName string, ips []string) error {
+	if feature.DualStackEnabled() {
+		return manager.DualStack().ReserveRange(networkName, subnetName, ips)
+	}
+	return manager.ReserveRange(networkName, subnetName, ips)
+}
+
+func subnetUsageInManager(manager IPAMManager, networkName, subnetName string) (*types.Usage, error) {
+	if feature.DualStackEnabled() {
+		return manager.DualStack().SubnetUsage(networkName, subnetName)
+	}
+	return manager.SubnetUsage(networkName, subnetName)
+}
+
+func networksOfTypeInManager(manager IPAMManager, networkType types.NetworkType) []string {
+	if feature.DualStackEnabled() {
+		return manager.DualStack().GetNetworksByType(networkType)
+	}
+	return manager.GetNetworksByType(networkType)
+}
+
 func (i *ipamManager) Refresh(networks []string) error {
 	if feature.DualStackEnabled() {
 		return i.DualStack().Refresh(networks)
@@ -20,10 +20,12 @@ import (
 	"context"
 
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
@@ -62,6 +64,22 @@ func (r *IPAMReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 	return ctrl.Result{}, nil
 }
 
+func (r *IPAMReconciler) enqueueNetworkOfIPReservation(object client.Object, q workqueue.RateLimitingInterface) {
+	reservation, ok := object.(*networkingv1.IPReservation)
+	if !ok {
+		return
+	}
+	subnet, err := utils.GetSubnet(r.Client, reservation.Spec.Subnet)
+	if err != nil {
+		return
+	}
+	q.Add(reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name: subnet.Spec.Network,
+		},
+	})
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *IPAMReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
@@ -89,6 +107,27 @@ func (r *IPAMReconciler) SetupWithManager(mgr ctrl.Manager) error {
 				&predicate.GenerationChangedPredicate{},
 				&utils.SubnetSpecChangePredicate{},
 			)).
+		// both networks of the previous and current subnets are refreshed if ip reservation is changed,
+		// so that the previous range is released
+		Watches(&source.Kind{Type: &networkingv1.IPReservation{}},
+			&handler.Funcs{
+				CreateFunc: func(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+					r.enqueueNetworkOfIPReservation(e.Object, q)
+				},
+				UpdateFunc: func(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+					r.enqueueNetworkOfIPReservation(e.ObjectOld, q)
+					r.enqueueNetworkOfIPReservation(e.ObjectNew, q)
+				},
+				DeleteFunc: func(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+					r.enqueueNetworkOfIPReservation(e.Object, q)
+				},
+				GenericFunc: func(e event.GenericEvent, q workqueue.RateLimitingInterface) {
+					r.enqueueNetworkOfIPReservation(e.Object, q)
+				},
+			},
+			builder.WithPredicates(
+				&utils.IPReservationActiveChangePredicate{},
+			)).
 		WithOptions(
 			controller.Options{
 				MaxConcurrentReconciles: r.Max(),
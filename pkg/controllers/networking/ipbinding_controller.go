@@ -0,0 +1,262 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/controllers/concurrency"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/feature"
+	"github.com/alibaba/hybridnet/pkg/ipam/store"
+	"github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+const ControllerIPBinding = "IPBinding"
+
+// IndexerFieldIPBindingPod is the field indexer of IPBinding on spec.podName
+const IndexerFieldIPBindingPod = "spec.podName"
+
+// IPBindingReconciler reconciles a IPBinding object, it reserves ip instances of the fixed ips
+// for pod by name before pod appears, and the ip instances will be assigned to pod on allocation
+type IPBindingReconciler struct {
+	client.Client
+
+	IPAMManager IPAMManager
+	IPAMStore   IPAMStore
+
+	concurrency.ControllerConcurrency
+}
+
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=ipbindings,verbs=get;list;watch
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=ipbindings/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=ipbindings/finalizers,verbs=update
+
+func (r *IPBindingReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var binding = &networkingv1.IPBinding{}
+	if err := r.Get(ctx, req.NamespacedName, binding); err != nil {
+		return ctrl.Result{}, wrapError("unable to fetch IPBinding", client.IgnoreNotFound(err))
+	}
+
+	// reserved ip instances are garbage-collected along with ip binding, but the ones in use are handed
+	// over to their pods first, otherwise the ips would be released while still configured on pods
+	if !binding.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(binding, constants.FinalizerIPBindingProtection) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.handOver(ctx, binding); err != nil {
+			return ctrl.Result{}, wrapError("unable to hand over ip instances in use", err)
+		}
+		return ctrl.Result{}, wrapError("unable to remove finalizer", r.removeFinalizer(ctx, binding))
+	}
+
+	if err := r.addFinalizer(ctx, binding); err != nil {
+		return ctrl.Result{}, wrapError("unable to add finalizer", err)
+	}
+
+	phase, message, err := r.bind(ctx, binding)
+	if err != nil {
+		return ctrl.Result{}, wrapError("unable to bind ips", err)
+	}
+
+	return ctrl.Result{}, wrapError("unable to update status of IPBinding", r.updateStatus(ctx, binding, phase, message))
+}
+
+// bind reserves the ips of binding which have no ip instances yet, failures which can not be
+// recovered by retrying are returned as message of failed phase
+func (r *IPBindingReconciler) bind(ctx context.Context, binding *networkingv1.IPBinding) (networkingv1.IPBindingPhase, string, error) {
+	ips, ipFamily, err := utils.ParseBindingIPs(binding.Spec.IPs)
+	if err != nil {
+		return networkingv1.IPBindingPhaseFailed, err.Error(), nil
+	}
+	if !feature.DualStackEnabled() && ipFamily != types.IPv4Only {
+		return networkingv1.IPBindingPhaseFailed, fmt.Sprintf("ip family %s is only supported in dual stack mode", ipFamily), nil
+	}
+
+	var (
+		missingIPs []string
+		bound      = true
+	)
+	for _, ip := range ips {
+		var ipInstance = &networkingv1.IPInstance{}
+		err = r.Get(ctx, apitypes.NamespacedName{Namespace: binding.Namespace, Name: store.IPInstanceNameOf(net.ParseIP(ip))}, ipInstance)
+		switch {
+		case apierrors.IsNotFound(err):
+			missingIPs = append(missingIPs, ip)
+			bound = false
+		case err != nil:
+			return "", "", fmt.Errorf("unable to get ip instance of %s: %v", ip, err)
+		case !ipInstance.DeletionTimestamp.IsZero():
+			// the ip instance will be reserved again after released
+			return "", "", fmt.Errorf("ip instance of %s is being released", ip)
+		case ipInstance.Spec.Network != binding.Spec.Network:
+			return networkingv1.IPBindingPhaseFailed, fmt.Sprintf("ip %s is in use in network %s", ip, ipInstance.Spec.Network), nil
+		case len(ipInstance.Status.PodName) > 0 && ipInstance.Status.PodName != binding.Spec.PodName:
+			return networkingv1.IPBindingPhaseFailed, fmt.Sprintf("ip %s is in use by pod %s", ip, ipInstance.Status.PodName), nil
+		case !networkingv1.IsUsingPhase(ipInstance.Status.Phase):
+			bound = false
+		}
+	}
+
+	if len(missingIPs) > 0 {
+		if message, err := r.reserve(binding, missingIPs); err != nil || len(message) > 0 {
+			return networkingv1.IPBindingPhaseFailed, message, err
+		}
+	}
+
+	if bound {
+		return networkingv1.IPBindingPhaseBound, "", nil
+	}
+	return networkingv1.IPBindingPhaseReserved, "", nil
+}
+
+// reserve assigns ips to pod of binding in ipam and creates reserved ip instances owned by binding
+func (r *IPBindingReconciler) reserve(binding *networkingv1.IPBinding, ips []string) (string, error) {
+	var (
+		owner     = store.IPBindingOwnerOf(binding)
+		podName   = binding.Spec.PodName
+		namespace = binding.Namespace
+		network   = binding.Spec.Network
+	)
+
+	if feature.DualStackEnabled() {
+		_, ipFamily, _ := utils.ParseBindingIPs(ips)
+		assignedIPs, err := r.IPAMManager.DualStack().Assign(ipFamily, network, nil, ips, podName, namespace, false)
+		if err != nil {
+			return fmt.Sprintf("unable to assign ips %v: %v", ips, err), nil
+		}
+		if err = r.IPAMStore.DualStack().IPBind(namespace, podName, assignedIPs, owner); err != nil {
+			_ = r.IPAMManager.DualStack().Release(ipFamily, network, squashIPSliceToSubnets(assignedIPs), squashIPSliceToIPs(assignedIPs))
+			return "", fmt.Errorf("unable to reserve ips %v: %v", ips, err)
+		}
+		return "", nil
+	}
+
+	assignedIP, err := r.IPAMManager.Assign(network, "", podName, namespace, ips[0], false)
+	if err != nil {
+		return fmt.Sprintf("unable to assign ip %s: %v", ips[0], err), nil
+	}
+	if err = r.IPAMStore.IPBind(namespace, podName, assignedIP, owner); err != nil {
+		_ = r.IPAMManager.Release(assignedIP.Network, assignedIP.Subnet, assignedIP.Address.IP.String())
+		return "", fmt.Errorf("unable to reserve ip %s: %v", ips[0], err)
+	}
+	return "", nil
+}
+
+// handOver makes the ip instances of binding in use owned by their pods, so that they are kept until
+// pods are gone, the ones whose pods are gone are left to garbage collection
+func (r *IPBindingReconciler) handOver(ctx context.Context, binding *networkingv1.IPBinding) error {
+	log := ctrllog.FromContext(ctx)
+
+	ipInstanceList, err := utils.ListIPInstances(r, client.InNamespace(binding.Namespace))
+	if err != nil {
+		return fmt.Errorf("unable to list ip instances: %v", err)
+	}
+
+	for _, ipInstance := range utils.IPInstancesInUseOfBinding(binding, ipInstanceList.Items) {
+		var pod = &corev1.Pod{}
+		if err = r.Get(ctx, apitypes.NamespacedName{Namespace: ipInstance.Namespace, Name: ipInstance.Status.PodName}, pod); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("unable to get pod %s: %v", ipInstance.Status.PodName, err)
+		}
+
+		owner := store.IPInstanceOwnerOf(pod)
+		log.Info("hand over ip instance in use to pod", "name", ipInstance.Name, "pod", pod.Name,
+			"ownerKind", owner.Kind, "ownerName", owner.Name)
+
+		patch := client.MergeFromWithOptions(ipInstance.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		var ownerReferences []metav1.OwnerReference
+		for _, ref := range ipInstance.OwnerReferences {
+			if ref.Controller == nil || !*ref.Controller {
+				ownerReferences = append(ownerReferences, ref)
+			}
+		}
+		ipInstance.OwnerReferences = append(ownerReferences, *owner)
+		if err = r.Patch(ctx, ipInstance, patch); err != nil {
+			return fmt.Errorf("unable to patch owner of ip instance %s: %v", ipInstance.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *IPBindingReconciler) addFinalizer(ctx context.Context, binding *networkingv1.IPBinding) error {
+	if controllerutil.ContainsFinalizer(binding, constants.FinalizerIPBindingProtection) {
+		return nil
+	}
+
+	patch := client.MergeFrom(binding.DeepCopy())
+	controllerutil.AddFinalizer(binding, constants.FinalizerIPBindingProtection)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Patch(ctx, binding, patch)
+	})
+}
+
+func (r *IPBindingReconciler) removeFinalizer(ctx context.Context, binding *networkingv1.IPBinding) error {
+	patch := client.MergeFrom(binding.DeepCopy())
+	controllerutil.RemoveFinalizer(binding, constants.FinalizerIPBindingProtection)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Patch(ctx, binding, patch)
+	})
+}
+
+func (r *IPBindingReconciler) updateStatus(ctx context.Context, binding *networkingv1.IPBinding,
+	phase networkingv1.IPBindingPhase, message string) error {
+	if binding.Status.Phase == phase && binding.Status.Message == message {
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		patch := client.MergeFrom(binding.DeepCopy())
+		binding.Status.Phase = phase
+		binding.Status.Message = message
+		return r.Status().Patch(ctx, binding, patch)
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IPBindingReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(ControllerIPBinding).
+		For(&networkingv1.IPBinding{}, builder.WithPredicates(
+			&predicate.GenerationChangedPredicate{},
+		)).
+		// phase follows the reserved ip instances, which are reserved again once recycled
+		Owns(&networkingv1.IPInstance{}).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.Max(),
+		}).
+		Complete(r)
+}
@@ -0,0 +1,214 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/controllers/concurrency"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/feature"
+	"github.com/alibaba/hybridnet/pkg/ipam/store"
+)
+
+const ControllerIPImport = "IPImport"
+
+// ipImportRetryInterval is the interval to retry the entries failing to be imported, because
+// conflicts may be cleared and subnets may be added later
+const ipImportRetryInterval = 5 * time.Minute
+
+// IPImportReconciler reconciles a IPImport object, it registers existing ip assignments, e.g., of
+// a brownfield cluster, as reserved ip instances, so that the ips will never be allocated to others
+type IPImportReconciler struct {
+	client.Client
+
+	IPAMManager IPAMManager
+	IPAMStore   IPAMStore
+
+	concurrency.ControllerConcurrency
+}
+
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=ipimports,verbs=get;list;watch
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=ipimports/status,verbs=get;update;patch
+
+func (r *IPImportReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var ipImport = &networkingv1.IPImport{}
+	if err := r.Get(ctx, req.NamespacedName, ipImport); err != nil {
+		return ctrl.Result{}, wrapError("unable to fetch IPImport", client.IgnoreNotFound(err))
+	}
+
+	// imported ip instances are garbage-collected along with ip import
+	if !ipImport.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	imported, failures, err := r.importEntries(ctx, ipImport)
+	if err != nil {
+		return ctrl.Result{}, wrapError("unable to import ips", err)
+	}
+
+	if err = r.updateStatus(ctx, ipImport, imported, failures); err != nil {
+		return ctrl.Result{}, wrapError("unable to update status of IPImport", err)
+	}
+
+	if len(failures) > 0 {
+		return ctrl.Result{RequeueAfter: ipImportRetryInterval}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// importEntries imports the entries which have no ip instances yet, and counts the entries whose
+// ip instances exist, failures which can not be recovered by retrying at once are returned
+func (r *IPImportReconciler) importEntries(ctx context.Context, ipImport *networkingv1.IPImport) (imported int32,
+	failures []networkingv1.IPImportFailure, err error) {
+	var seen = map[string]bool{}
+	var fail = func(ip, format string, args ...interface{}) {
+		failures = append(failures, networkingv1.IPImportFailure{IP: ip, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	for _, entry := range ipImport.Spec.Entries {
+		ip := net.ParseIP(entry.IP)
+		if ip == nil {
+			fail(entry.IP, "invalid ip")
+			continue
+		}
+		if seen[ip.String()] {
+			fail(entry.IP, "duplicate entry")
+			continue
+		}
+		seen[ip.String()] = true
+
+		if len(entry.PodName) > 0 && len(validation.IsDNS1123Subdomain(entry.PodName)) > 0 {
+			fail(entry.IP, "invalid pod name %s", entry.PodName)
+			continue
+		}
+		if !feature.DualStackEnabled() && ip.To4() == nil {
+			fail(entry.IP, "ipv6 is only supported in dual stack mode")
+			continue
+		}
+
+		var ipInstance = &networkingv1.IPInstance{}
+		err = r.Get(ctx, apitypes.NamespacedName{Namespace: ipImport.Namespace, Name: store.IPInstanceNameOf(ip)}, ipInstance)
+		switch {
+		case apierrors.IsNotFound(err):
+			var reason string
+			if reason, err = r.importIP(ipImport, entry.PodName, ip); err != nil {
+				return 0, nil, err
+			}
+			if len(reason) > 0 {
+				fail(entry.IP, reason)
+				continue
+			}
+			imported++
+		case err != nil:
+			return 0, nil, fmt.Errorf("unable to get ip instance of %s: %v", entry.IP, err)
+		case !ipInstance.DeletionTimestamp.IsZero():
+			// the ip will be imported again after released
+			return 0, nil, fmt.Errorf("ip instance of %s is being released", entry.IP)
+		case ipInstance.Spec.Network != ipImport.Spec.Network:
+			fail(entry.IP, "ip is in use in network %s", ipInstance.Spec.Network)
+		case metav1.IsControlledBy(ipInstance, ipImport):
+			imported++
+		case len(entry.PodName) > 0 && ipInstance.Status.PodName == entry.PodName:
+			// imported ip instance adopted by pod, or allocated to pod already
+			imported++
+		case len(ipInstance.Status.PodName) > 0:
+			fail(entry.IP, "ip is in use by pod %s", ipInstance.Status.PodName)
+		default:
+			fail(entry.IP, "ip is imported by others")
+		}
+	}
+	return imported, failures, nil
+}
+
+// importIP assigns ip in ipam, which validates subnet membership and conflicts, and creates the reserved
+// ip instance owned by ip import, the pod name is empty for ip used outside of cluster
+func (r *IPImportReconciler) importIP(ipImport *networkingv1.IPImport, podName string, ip net.IP) (string, error) {
+	var (
+		owner     = store.IPImportOwnerOf(ipImport)
+		namespace = ipImport.Namespace
+		network   = ipImport.Spec.Network
+	)
+
+	if feature.DualStackEnabled() {
+		ipFamily := utils.ToIPFamilyMode(ip.To4() == nil)
+		assignedIPs, err := r.IPAMManager.DualStack().Assign(ipFamily, network, nil, []string{ip.String()}, podName, namespace, false)
+		if err != nil {
+			return fmt.Sprintf("unable to assign ip: %v", err), nil
+		}
+		if err = r.IPAMStore.DualStack().IPBind(namespace, podName, assignedIPs, owner); err != nil {
+			_ = r.IPAMManager.DualStack().Release(ipFamily, network, squashIPSliceToSubnets(assignedIPs), squashIPSliceToIPs(assignedIPs))
+			return "", fmt.Errorf("unable to reserve ip %s: %v", ip, err)
+		}
+		return "", nil
+	}
+
+	assignedIP, err := r.IPAMManager.Assign(network, "", podName, namespace, ip.String(), false)
+	if err != nil {
+		return fmt.Sprintf("unable to assign ip: %v", err), nil
+	}
+	if err = r.IPAMStore.IPBind(namespace, podName, assignedIP, owner); err != nil {
+		_ = r.IPAMManager.Release(assignedIP.Network, assignedIP.Subnet, assignedIP.Address.IP.String())
+		return "", fmt.Errorf("unable to reserve ip %s: %v", ip, err)
+	}
+	return "", nil
+}
+
+func (r *IPImportReconciler) updateStatus(ctx context.Context, ipImport *networkingv1.IPImport, imported int32,
+	failures []networkingv1.IPImportFailure) error {
+	if ipImport.Status.Imported == imported && reflect.DeepEqual(ipImport.Status.Failures, failures) {
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		patch := client.MergeFrom(ipImport.DeepCopy())
+		ipImport.Status.Imported = imported
+		ipImport.Status.Failures = failures
+		return r.Status().Patch(ctx, ipImport, patch)
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IPImportReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(ControllerIPImport).
+		For(&networkingv1.IPImport{}, builder.WithPredicates(
+			&predicate.GenerationChangedPredicate{},
+		)).
+		// imported ips are counted by ip instances, which are imported again once recycled
+		Owns(&networkingv1.IPInstance{}).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.Max(),
+		}).
+		Complete(r)
+}
@@ -18,7 +18,13 @@ package networking
 
 import (
 	"context"
+	"fmt"
+	"net"
 
+	corev1 "k8s.io/api/core/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -26,13 +32,17 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
 	"github.com/alibaba/hybridnet/pkg/controllers/concurrency"
 	"github.com/alibaba/hybridnet/pkg/controllers/utils"
 	"github.com/alibaba/hybridnet/pkg/feature"
+	"github.com/alibaba/hybridnet/pkg/ipam/store"
 )
 
 const ControllerIPInstance = "IPInstance"
 
+const ReasonIPConflicted = "IPConflicted"
+
 // IPInstanceReconciler reconciles a IPInstance object
 type IPInstanceReconciler struct {
 	client.Client
@@ -41,6 +51,8 @@ type IPInstanceReconciler struct {
 	IPAMManager IPAMManager
 	IPAMStore   IPAMStore
 
+	Recorder record.EventRecorder
+
 	concurrency.ControllerConcurrency
 }
 
@@ -59,12 +71,60 @@ func (r *IPInstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		if err = r.releaseIP(&ip); err != nil {
 			return ctrl.Result{}, wrapError("unable to release IPInstance", err)
 		}
+		return ctrl.Result{}, nil
+	}
+
+	if reason, conflicted := ip.Annotations[constants.AnnotationIPConflicted]; conflicted {
+		if err = r.quarantineConflictedIP(ctx, &ip, reason); err != nil {
+			return ctrl.Result{}, wrapError("unable to quarantine conflicted IPInstance", err)
+		}
 	}
 
 	return ctrl.Result{}, nil
 }
 
+// quarantineConflictedIP adds the address of ip instance to conflicted ips of its subnet, so that it will never
+// be allocated again until removed by operator. The conflict annotation is consumed then, otherwise the address
+// will be added back after operator clears it.
+func (r *IPInstanceReconciler) quarantineConflictedIP(ctx context.Context, ipInstance *networkingv1.IPInstance, reason string) error {
+	var address = utils.ToIPFormat(ipInstance.Name)
+	var subnet = &networkingv1.Subnet{}
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := r.Get(ctx, apitypes.NamespacedName{Name: ipInstance.Spec.Subnet}, subnet); err != nil {
+			return err
+		}
+		for _, conflictedIP := range subnet.Spec.ConflictedIPs {
+			if net.ParseIP(conflictedIP).Equal(net.ParseIP(address)) {
+				return nil
+			}
+		}
+
+		// conflicted ips are replaced as a whole by merge patch, so lock is required
+		patch := client.MergeFromWithOptions(subnet.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		subnet.Spec.ConflictedIPs = append(subnet.Spec.ConflictedIPs, address)
+		return r.Patch(ctx, subnet, patch)
+	}); err != nil {
+		return fmt.Errorf("unable to add conflicted ip %s to subnet %s: %v", address, ipInstance.Spec.Subnet, err)
+	}
+
+	r.Recorder.Eventf(ipInstance, corev1.EventTypeWarning, ReasonIPConflicted,
+		"ip %s is conflicted with address outside of cluster: %s, it is quarantined in subnet %s until removed from "+
+			"conflictedIPs", address, reason, subnet.Name)
+	r.Recorder.Eventf(subnet, corev1.EventTypeWarning, ReasonIPConflicted,
+		"ip %s of pod %s/%s is conflicted with address outside of cluster: %s", address, ipInstance.Namespace,
+		ipInstance.Status.PodName, reason)
+
+	patchBody := fmt.Sprintf(`{"metadata":{"annotations":{%q:null}}}`, constants.AnnotationIPConflicted)
+	return client.IgnoreNotFound(r.Patch(ctx, ipInstance, client.RawPatch(apitypes.MergePatchType, []byte(patchBody))))
+}
+
 func (r *IPInstanceReconciler) releaseIP(ipInstance *networkingv1.IPInstance) (err error) {
+	// claim must be given back before finalizer is removed, otherwise it will
+	// never be retried, so releasing claim is expected to be idempotent
+	if err = store.ReleaseClaimedIPs(ipInstance.Spec.Network, []string{utils.ToIPFormat(ipInstance.Name)}); err != nil {
+		return fmt.Errorf("unable to release claim of ip: %v", err)
+	}
+
 	if feature.DualStackEnabled() {
 		if err = r.IPAMManager.DualStack().Release(utils.ToIPFamilyMode(networkingv1.IsIPv6IPInstance(ipInstance)),
 			ipInstance.Spec.Network,
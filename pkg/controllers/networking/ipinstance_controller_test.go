@@ -0,0 +1,133 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+)
+
+// quarantineClient serves a subnet and records the patches
+type quarantineClient struct {
+	client.Client
+	subnet            *networkingv1.Subnet
+	subnetPatched     bool
+	ipInstancePatches []string
+}
+
+func (q *quarantineClient) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	if subnet, ok := obj.(*networkingv1.Subnet); ok && key.Name == q.subnet.Name {
+		q.subnet.DeepCopyInto(subnet)
+		return nil
+	}
+	return apierrors.NewNotFound(schema.GroupResource{}, key.Name)
+}
+
+func (q *quarantineClient) Patch(_ context.Context, obj client.Object, patch client.Patch, _ ...client.PatchOption) error {
+	switch o := obj.(type) {
+	case *networkingv1.Subnet:
+		q.subnetPatched = true
+		o.DeepCopyInto(q.subnet)
+	case *networkingv1.IPInstance:
+		data, err := patch.Data(obj)
+		if err != nil {
+			return err
+		}
+		q.ipInstancePatches = append(q.ipInstancePatches, string(data))
+	}
+	return nil
+}
+
+func TestQuarantineConflictedIP(t *testing.T) {
+	tests := []struct {
+		name                  string
+		conflictedIPs         []string
+		expectedConflictedIPs []string
+		expectSubnetPatched   bool
+	}{
+		{
+			name:                  "new conflict",
+			conflictedIPs:         []string{"192.168.0.9"},
+			expectedConflictedIPs: []string{"192.168.0.9", "192.168.0.10"},
+			expectSubnetPatched:   true,
+		},
+		{
+			name:                  "already quarantined",
+			conflictedIPs:         []string{"192.168.0.10"},
+			expectedConflictedIPs: []string{"192.168.0.10"},
+			expectSubnetPatched:   false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &quarantineClient{
+				subnet: &networkingv1.Subnet{
+					ObjectMeta: metav1.ObjectMeta{Name: "subnet1"},
+					Spec:       networkingv1.SubnetSpec{ConflictedIPs: test.conflictedIPs},
+				},
+			}
+			recorder := record.NewFakeRecorder(2)
+			r := &IPInstanceReconciler{Client: c, Recorder: recorder}
+
+			ipInstance := &networkingv1.IPInstance{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "default",
+					Name:        "192-168-0-10",
+					Annotations: map[string]string{constants.AnnotationIPConflicted: "answered by hw addr"},
+				},
+				Spec:   networkingv1.IPInstanceSpec{Subnet: "subnet1"},
+				Status: networkingv1.IPInstanceStatus{PodName: "pod1"},
+			}
+			if err := r.quarantineConflictedIP(context.Background(), ipInstance, "answered by hw addr"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if c.subnetPatched != test.expectSubnetPatched {
+				t.Errorf("expected subnet patched %v, got %v", test.expectSubnetPatched, c.subnetPatched)
+			}
+			if !reflect.DeepEqual(c.subnet.Spec.ConflictedIPs, test.expectedConflictedIPs) {
+				t.Errorf("expected conflicted ips %v, got %v", test.expectedConflictedIPs, c.subnet.Spec.ConflictedIPs)
+			}
+
+			// the annotation is consumed, otherwise the address is added back once cleared by operator
+			if len(c.ipInstancePatches) != 1 || !strings.Contains(c.ipInstancePatches[0], constants.AnnotationIPConflicted+`":null`) {
+				t.Errorf("expected conflict annotation removed, got patches %v", c.ipInstancePatches)
+			}
+
+			if len(recorder.Events) != 2 {
+				t.Fatalf("expected events on both ip instance and subnet, got %d", len(recorder.Events))
+			}
+			for i := 0; i < 2; i++ {
+				if event := <-recorder.Events; !strings.Contains(event, ReasonIPConflicted) {
+					t.Errorf("unexpected event %q", event)
+				}
+			}
+		})
+	}
+}
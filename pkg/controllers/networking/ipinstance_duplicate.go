@@ -0,0 +1,187 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/feature"
+	"github.com/alibaba/hybridnet/pkg/metrics"
+)
+
+const ReconcilerIPInstanceDuplicate = "IPInstanceDuplicateReconciler"
+
+const ReasonIPInstanceDuplicated = "IPInstanceDuplicated"
+
+var _ manager.Runnable = &IPInstanceDuplicateReconciler{}
+
+// IPInstanceDuplicateReconciler periodically detects the IP instances claiming the same address in the
+// same network, which can be caused by races or manual edits and will let two pods believe they own the
+// same IP. For each address, the IP instance bound to a live pod is kept, and the others are deleted
+type IPInstanceDuplicateReconciler struct {
+	client.Client
+	APIReader client.Reader
+	Logger    logr.Logger
+	Recorder  record.EventRecorder
+
+	IPAMStore IPAMStore
+
+	// Period is the interval of reconciling, five minutes by default
+	Period time.Duration
+}
+
+func (r *IPInstanceDuplicateReconciler) Start(ctx context.Context) error {
+	r.Logger.Info("ip instance duplicate reconciler is starting")
+
+	if r.Period <= 0 {
+		r.Period = 5 * time.Minute
+	}
+
+	wait.UntilWithContext(ctx, func(c context.Context) {
+		ipInstanceList, err := utils.ListIPInstances(r)
+		if err != nil {
+			r.Logger.Error(err, "unable to list ip instances")
+			return
+		}
+
+		for _, group := range utils.GroupDuplicateIPInstances(ipInstanceList.Items) {
+			if err = r.resolve(c, group); err != nil {
+				r.Logger.Error(err, "unable to resolve duplicate ip instances", "network", group[0].Spec.Network,
+					"ip", utils.IPInstanceAddress(group[0]))
+			}
+		}
+	}, r.Period)
+
+	r.Logger.Info("ip instance duplicate reconciler is stopping")
+	return nil
+}
+
+// resolve keeps the ip instance with the best claim on address and deletes the others
+func (r *IPInstanceDuplicateReconciler) resolve(ctx context.Context, group []*networkingv1.IPInstance) error {
+	var address = utils.IPInstanceAddress(group[0])
+	var pods = make([]*corev1.Pod, len(group))
+	var keeper = -1
+	var keeperScore = -1
+	for i, ipInstance := range group {
+		pod, err := r.podOf(ctx, ipInstance)
+		if err != nil {
+			return err
+		}
+		pods[i] = pod
+
+		score := claimScoreOf(ipInstance, pod, address)
+		if score > keeperScore || (score == keeperScore &&
+			ipInstance.CreationTimestamp.Before(&group[keeper].CreationTimestamp)) {
+			keeper, keeperScore = i, score
+		}
+	}
+
+	for i, duplicate := range group {
+		if i == keeper {
+			continue
+		}
+
+		r.Logger.Error(nil, "duplicate ip instance found, deleting it", "network", duplicate.Spec.Network, "ip", address,
+			"namespace", duplicate.Namespace, "name", duplicate.Name, "pod", duplicate.Status.PodName,
+			"keeperNamespace", group[keeper].Namespace, "keeperName", group[keeper].Name,
+			"keeperPod", group[keeper].Status.PodName)
+		metrics.IPInstanceDuplicateCounter.WithLabelValues(duplicate.Spec.Network).Inc()
+
+		if err := r.deleteDuplicate(ctx, duplicate, address); err != nil {
+			return fmt.Errorf("unable to delete duplicate ip instance %s/%s: %v", duplicate.Namespace, duplicate.Name, err)
+		}
+
+		r.Recorder.Eventf(group[keeper], corev1.EventTypeWarning, ReasonIPInstanceDuplicated,
+			"ip %s of network %s was also claimed by ip instance %s/%s of pod %s, which is deleted", address,
+			duplicate.Spec.Network, duplicate.Namespace, duplicate.Name, duplicate.Status.PodName)
+		if pods[i] != nil {
+			r.Recorder.Eventf(pods[i], corev1.EventTypeWarning, ReasonIPInstanceDuplicated,
+				"ip %s of network %s is kept by pod %s/%s, ip instance %s is deleted and pod should be recreated",
+				address, duplicate.Spec.Network, group[keeper].Status.PodNamespace, group[keeper].Status.PodName,
+				duplicate.Name)
+		}
+	}
+	return nil
+}
+
+// deleteDuplicate deletes the duplicate ip instance. If its name refers to the address as well, its
+// allocation in IPAM is shared with the kept one, so the finalizer is removed in advance to delete it
+// without releasing the address
+func (r *IPInstanceDuplicateReconciler) deleteDuplicate(ctx context.Context, ipInstance *networkingv1.IPInstance, address string) (err error) {
+	if utils.ToIPFormat(ipInstance.Name) == address {
+		if feature.DualStackEnabled() {
+			err = r.IPAMStore.DualStack().IPUnBind(ipInstance.Namespace, ipInstance.Name)
+		} else {
+			err = r.IPAMStore.IPUnBind(ipInstance.Namespace, ipInstance.Name)
+		}
+		if err != nil {
+			return client.IgnoreNotFound(err)
+		}
+	}
+
+	return client.IgnoreNotFound(r.Delete(ctx, ipInstance, client.Preconditions{UID: &ipInstance.UID}))
+}
+
+// podOf returns the pod of ip instance, nil if ip instance is not in use or pod is gone
+func (r *IPInstanceDuplicateReconciler) podOf(ctx context.Context, ipInstance *networkingv1.IPInstance) (*corev1.Pod, error) {
+	if !networkingv1.IsUsingPhase(ipInstance.Status.Phase) || len(ipInstance.Status.PodName) == 0 {
+		return nil, nil
+	}
+
+	podKey := apitypes.NamespacedName{Namespace: ipInstance.Status.PodNamespace, Name: ipInstance.Status.PodName}
+	pod := &corev1.Pod{}
+	err := r.Get(ctx, podKey, pod)
+	if apierrors.IsNotFound(err) {
+		// a missing pod in cache is not reliable, confirm it with apiserver
+		err = r.APIReader.Get(ctx, podKey, pod)
+	}
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to get pod %s: %v", podKey, err)
+	}
+	return pod, nil
+}
+
+// claimScoreOf rates the claim of ip instance on address, a live pod on the recorded node is the best,
+// then a live pod, and ip instance whose name refers to the address is preferred on each level
+func claimScoreOf(ipInstance *networkingv1.IPInstance, pod *corev1.Pod, address string) (score int) {
+	if pod != nil && pod.DeletionTimestamp.IsZero() {
+		score += 2
+		if pod.Spec.NodeName == ipInstance.Status.NodeName {
+			score += 2
+		}
+	}
+	if utils.ToIPFormat(ipInstance.Name) == address {
+		score++
+	}
+	return score
+}
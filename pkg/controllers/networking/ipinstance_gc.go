@@ -0,0 +1,131 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/feature"
+	"github.com/alibaba/hybridnet/pkg/ipam/store"
+	"github.com/alibaba/hybridnet/pkg/ipam/strategy"
+	"github.com/alibaba/hybridnet/pkg/utils/transform"
+)
+
+const GarbageCollectionIPInstance = "IPInstanceGarbageCollection"
+
+var _ manager.Runnable = &IPInstanceGarbageCollection{}
+
+// IPInstanceGarbageCollection runs once on startup to handle the IP instances whose pods were
+// deleted while manager was down, because the deletion events of pods had been missed
+type IPInstanceGarbageCollection struct {
+	client.Client
+	APIReader client.Reader
+	Logger    logr.Logger
+
+	IPAMStore IPAMStore
+}
+
+func (r *IPInstanceGarbageCollection) Start(ctx context.Context) error {
+	r.Logger.Info("ip instance garbage collection is starting")
+
+	ipInstanceList, err := utils.ListUsingIPInstances(r)
+	if err != nil {
+		r.Logger.Error(err, "unable to list ip instances")
+		return nil
+	}
+
+	for i := range ipInstanceList.Items {
+		var ipInstance = &ipInstanceList.Items[i]
+		if !ipInstance.DeletionTimestamp.IsZero() || !networkingv1.IsUsingPhase(ipInstance.Status.Phase) ||
+			len(ipInstance.Status.PodName) == 0 {
+			continue
+		}
+
+		if err = r.collect(ctx, ipInstance); err != nil {
+			r.Logger.Error(err, "unable to collect ip instance", "namespace", ipInstance.Namespace, "name", ipInstance.Name)
+		}
+	}
+
+	r.Logger.Info("ip instance garbage collection is finished")
+	return nil
+}
+
+func (r *IPInstanceGarbageCollection) collect(ctx context.Context, ipInstance *networkingv1.IPInstance) error {
+	// fetch pod from apiserver directly, because a missing pod in cache is not reliable
+	pod := &corev1.Pod{}
+	err := r.APIReader.Get(ctx, apitypes.NamespacedName{Namespace: ipInstance.Status.PodNamespace, Name: ipInstance.Status.PodName}, pod)
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to get pod %s/%s: %v", ipInstance.Status.PodNamespace, ipInstance.Status.PodName, err)
+	}
+
+	_, err = collectIPInstanceOfDeletedPod(ctx, r, r.IPAMStore, r.Logger, ipInstance)
+	return err
+}
+
+// collectIPInstanceOfDeletedPod recycles the IP instance whose pod has been deleted, or reserves
+// it if it belongs to a stateful pod, returns whether it is reserved
+func collectIPInstanceOfDeletedPod(ctx context.Context, c client.Client, ipamStore IPAMStore, logger logr.Logger,
+	ipInstance *networkingv1.IPInstance) (reserved bool, err error) {
+	// ip of stateful pod should be reserved for retaining, pod annotation is
+	// missing along with pod, so only the global retain strategy works here
+	if ref := metav1.GetControllerOf(ipInstance); ref != nil && strategy.IsStatefulWorkloadKind(ref.Kind) && strategy.DefaultIPRetain {
+		logger.Info("reserve ip instance of deleted pod", "namespace", ipInstance.Namespace, "name", ipInstance.Name,
+			"pod", ipInstance.Status.PodName)
+		return true, reserveIPInstance(ctx, c, ipInstance)
+	}
+
+	// ip reserved for ip binding is kept for the next pod of the same name
+	if store.IsBoundIPInstance(ipInstance) {
+		logger.Info("reserve bound ip instance of deleted pod", "namespace", ipInstance.Namespace, "name", ipInstance.Name,
+			"pod", ipInstance.Status.PodName)
+		return true, reserveIPInstance(ctx, c, ipInstance)
+	}
+
+	logger.Info("recycle ip instance of deleted pod", "namespace", ipInstance.Namespace, "name", ipInstance.Name,
+		"pod", ipInstance.Status.PodName)
+	if feature.DualStackEnabled() {
+		return false, ipamStore.DualStack().IPRecycle(ipInstance.Namespace, transform.TransferIPInstanceForIPAM(ipInstance))
+	}
+	return false, ipamStore.IPRecycle(ipInstance.Namespace, transform.TransferIPInstanceForIPAM(ipInstance))
+}
+
+func reserveIPInstance(ctx context.Context, c client.Client, ipInstance *networkingv1.IPInstance) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return c.Status().Patch(ctx,
+			ipInstance,
+			client.RawPatch(
+				apitypes.MergePatchType,
+				[]byte(fmt.Sprintf(`{"status":{"nodeName":"","phase":%q}}`, networkingv1.IPPhaseReserved)),
+			),
+		)
+	})
+}
@@ -0,0 +1,101 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"fmt"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/controllers/concurrency"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+)
+
+const ControllerIPInstanceNodeLabel = "IPInstanceNodeLabel"
+
+// IPInstanceNodeLabelReconciler keeps node label of bound IPInstance in sync with its status,
+// so IPInstances can always be listed by node label correctly
+type IPInstanceNodeLabelReconciler struct {
+	client.Client
+
+	concurrency.ControllerConcurrency
+}
+
+func (r *IPInstanceNodeLabelReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	log := ctrllog.FromContext(ctx)
+
+	defer func() {
+		if err != nil {
+			log.Error(err, "reconciliation fails")
+		}
+	}()
+
+	var ipInstance = &networkingv1.IPInstance{}
+	if err = r.Get(ctx, req.NamespacedName, ipInstance); err != nil {
+		return ctrl.Result{}, wrapError("unable to fetch IPInstance", client.IgnoreNotFound(err))
+	}
+
+	if !ipInstance.DeletionTimestamp.IsZero() || !nodeLabelDrifted(ipInstance) {
+		return ctrl.Result{}, nil
+	}
+
+	oldNodeLabel := ipInstance.Labels[constants.LabelNode]
+	ipInstancePatch := client.MergeFrom(ipInstance.DeepCopy())
+	if ipInstance.Labels == nil {
+		ipInstance.Labels = map[string]string{}
+	}
+	ipInstance.Labels[constants.LabelNode] = ipInstance.Status.NodeName
+	if err = r.Patch(ctx, ipInstance, ipInstancePatch); err != nil {
+		return ctrl.Result{}, wrapError("unable to patch node label", client.IgnoreNotFound(err))
+	}
+
+	log.V(4).Info(fmt.Sprintf("correct node label from %q to %q", oldNodeLabel, ipInstance.Status.NodeName))
+	return ctrl.Result{}, nil
+}
+
+// nodeLabelDrifted checks whether the node label of a bound IPInstance mismatches its status
+func nodeLabelDrifted(ipInstance *networkingv1.IPInstance) bool {
+	if !networkingv1.IsUsingPhase(ipInstance.Status.Phase) || len(ipInstance.Status.NodeName) == 0 {
+		return false
+	}
+	return ipInstance.Labels[constants.LabelNode] != ipInstance.Status.NodeName
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IPInstanceNodeLabelReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(ControllerIPInstanceNodeLabel).
+		For(&networkingv1.IPInstance{}, builder.WithPredicates(
+			&utils.IgnoreDeletePredicate{},
+			predicate.NewPredicateFuncs(func(obj client.Object) bool {
+				ipInstance, ok := obj.(*networkingv1.IPInstance)
+				return ok && nodeLabelDrifted(ipInstance)
+			}),
+		)).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.Max(),
+		}).
+		Complete(r)
+}
@@ -0,0 +1,129 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/ipam/store"
+)
+
+const ReconcilerIPInstanceOwner = "IPInstanceOwnerReconciler"
+
+var _ manager.Runnable = &IPInstanceOwnerReconciler{}
+
+// IPInstanceOwnerReconciler periodically makes sure every IP instance in use is owned by its pod, or by
+// the stateful workload of its pod, so that it is garbage-collected along with the owner. Controller
+// references can be missing, e.g., removed by an orphan deletion of workload, and the IP instances whose
+// pods are gone are recycled, or reserved if they belong to stateful pods
+type IPInstanceOwnerReconciler struct {
+	client.Client
+	APIReader client.Reader
+	Logger    logr.Logger
+
+	IPAMStore IPAMStore
+
+	// Period is the interval of reconciling, ten minutes by default
+	Period time.Duration
+}
+
+func (r *IPInstanceOwnerReconciler) Start(ctx context.Context) error {
+	r.Logger.Info("ip instance owner reconciler is starting")
+
+	if r.Period <= 0 {
+		r.Period = 10 * time.Minute
+	}
+
+	wait.UntilWithContext(ctx, func(c context.Context) {
+		ipInstanceList, err := utils.ListUsingIPInstances(r)
+		if err != nil {
+			r.Logger.Error(err, "unable to list ip instances")
+			return
+		}
+
+		for i := range ipInstanceList.Items {
+			var ipInstance = &ipInstanceList.Items[i]
+			if !ipInstance.DeletionTimestamp.IsZero() || !networkingv1.IsUsingPhase(ipInstance.Status.Phase) ||
+				len(ipInstance.Status.PodName) == 0 {
+				continue
+			}
+
+			if err = r.reconcile(c, ipInstance); err != nil {
+				r.Logger.Error(err, "unable to reconcile owner of ip instance", "namespace", ipInstance.Namespace,
+					"name", ipInstance.Name)
+			}
+		}
+	}, r.Period)
+
+	r.Logger.Info("ip instance owner reconciler is stopping")
+	return nil
+}
+
+func (r *IPInstanceOwnerReconciler) reconcile(ctx context.Context, ipInstance *networkingv1.IPInstance) error {
+	podKey := apitypes.NamespacedName{Namespace: ipInstance.Status.PodNamespace, Name: ipInstance.Status.PodName}
+
+	pod := &corev1.Pod{}
+	err := r.Get(ctx, podKey, pod)
+	if apierrors.IsNotFound(err) {
+		// a missing pod in cache is not reliable, confirm it with apiserver
+		err = r.APIReader.Get(ctx, podKey, pod)
+	}
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			_, err = collectIPInstanceOfDeletedPod(ctx, r, r.IPAMStore, r.Logger, ipInstance)
+			return err
+		}
+		return fmt.Errorf("unable to get pod %s: %v", podKey, err)
+	}
+
+	// ip instances of externally addressed pods are managed by others
+	if utils.PodIsExternallyAddressed(pod) || !pod.DeletionTimestamp.IsZero() {
+		return nil
+	}
+
+	owner := store.IPInstanceOwnerOf(pod)
+	if !store.IPInstanceOwnerIsStale(ipInstance, owner) {
+		return nil
+	}
+
+	r.Logger.Info("adopt ip instance", "namespace", ipInstance.Namespace, "name", ipInstance.Name,
+		"pod", pod.Name, "ownerKind", owner.Kind, "ownerName", owner.Name)
+
+	patch := client.MergeFromWithOptions(ipInstance.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	var ownerReferences []metav1.OwnerReference
+	for _, ref := range ipInstance.OwnerReferences {
+		if ref.Controller == nil || !*ref.Controller {
+			ownerReferences = append(ownerReferences, ref)
+		}
+	}
+	ipInstance.OwnerReferences = append(ownerReferences, *owner)
+
+	return r.Patch(ctx, ipInstance, patch)
+}
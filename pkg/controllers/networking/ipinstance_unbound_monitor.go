@@ -0,0 +1,226 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/metrics"
+)
+
+const MonitorUnboundIPInstance = "UnboundIPInstanceMonitor"
+
+const ReasonIPUnboundTooLong = "IPUnboundTooLong"
+
+var _ manager.Runnable = &UnboundIPInstanceMonitor{}
+
+// UnboundIPInstanceMonitor periodically exposes the age of the oldest IP instance which has been
+// allocated to pod but not bound to nic yet on every node and subnet, and warns on the IP instances
+// staying unbound longer than threshold, which usually means the cni calls of pods are failing
+type UnboundIPInstanceMonitor struct {
+	client.Client
+	Recorder record.EventRecorder
+	Logger   logr.Logger
+
+	// Threshold is the age for an unbound IP instance to be warned, zero means never
+	Threshold time.Duration
+	// Period is the interval of checking, one minute by default
+	Period time.Duration
+
+	// unboundSince records when an IP instance is observed to be unbound for its current pod,
+	// because it may have been created long before re-coupled with a stateful pod
+	unboundSince map[unboundIPKey]time.Time
+	// warned records the IP instances which have already been warned to avoid event flooding
+	warned map[unboundIPKey]bool
+	// gaugeLabels records the label values exposed in last round to reset the stale ones
+	gaugeLabels map[[2]string]bool
+}
+
+type unboundIPKey struct {
+	apitypes.NamespacedName
+	podName string
+}
+
+func (r *UnboundIPInstanceMonitor) Start(ctx context.Context) error {
+	r.Logger.Info("unbound ip instance monitor is starting")
+
+	if r.Period <= 0 {
+		r.Period = time.Minute
+	}
+	r.unboundSince = map[unboundIPKey]time.Time{}
+	r.warned = map[unboundIPKey]bool{}
+	r.gaugeLabels = map[[2]string]bool{}
+
+	var firstRound = true
+	wait.UntilWithContext(ctx, func(c context.Context) {
+		// only ip instances in using or binding phase can be unbound
+		ipInstanceList := &networkingv1.IPInstanceList{}
+		for _, phase := range []networkingv1.IPPhase{networkingv1.IPPhaseUsing, networkingv1.IPPhaseBinding} {
+			phaseIPInstanceList, err := utils.ListIPInstancesByPhase(r, phase)
+			if err != nil {
+				r.Logger.Error(err, "unable to list ip instances", "phase", phase)
+				return
+			}
+			for i := range phaseIPInstanceList.Items {
+				unbound, err := r.unbound(c, &phaseIPInstanceList.Items[i])
+				if err != nil {
+					r.Logger.Error(err, "unable to check ip instance", "namespace", phaseIPInstanceList.Items[i].Namespace,
+						"name", phaseIPInstanceList.Items[i].Name)
+					return
+				}
+				if unbound {
+					ipInstanceList.Items = append(ipInstanceList.Items, phaseIPInstanceList.Items[i])
+				}
+			}
+		}
+
+		r.check(ipInstanceList, time.Now(), firstRound)
+		firstRound = false
+	}, r.Period)
+
+	r.Logger.Info("unbound ip instance monitor is stopping")
+	return nil
+}
+
+// unbound checks whether the nic of pod is still waiting to be configured with ip instance
+func (r *UnboundIPInstanceMonitor) unbound(ctx context.Context, ipInstance *networkingv1.IPInstance) (bool, error) {
+	if !ipInstance.DeletionTimestamp.IsZero() || len(ipInstance.Status.PodName) == 0 {
+		return false, nil
+	}
+
+	pod := &corev1.Pod{}
+	if err := r.Get(ctx, apitypes.NamespacedName{Namespace: ipInstance.Namespace, Name: ipInstance.Status.PodName}, pod); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return ipInstanceUnbound(ipInstance, pod), nil
+}
+
+// ipInstanceUnbound checks ip instance against its pod. An ip instance in binding phase is unbound as long as
+// its pod is on the recorded node, while one in using phase is only unbound if no container of pod has ever
+// started, because ip instances allocated by previous versions stay in using phase even for running pods
+func ipInstanceUnbound(ipInstance *networkingv1.IPInstance, pod *corev1.Pod) bool {
+	if !pod.DeletionTimestamp.IsZero() || pod.Spec.NodeName != ipInstance.Status.NodeName {
+		return false
+	}
+
+	switch ipInstance.Status.Phase {
+	case networkingv1.IPPhaseBinding:
+		return true
+	case networkingv1.IPPhaseUsing:
+		return !containerStarted(pod.Status.InitContainerStatuses) && !containerStarted(pod.Status.ContainerStatuses)
+	default:
+		return false
+	}
+}
+
+// containerStarted checks whether any container has ever started, which means the sandbox has got its nic
+func containerStarted(statuses []corev1.ContainerStatus) bool {
+	for _, status := range statuses {
+		if status.State.Running != nil || status.State.Terminated != nil || status.RestartCount > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *UnboundIPInstanceMonitor) check(ipInstanceList *networkingv1.IPInstanceList, now time.Time, firstRound bool) {
+	var (
+		currentKeys = map[unboundIPKey]bool{}
+		oldestAges  = map[[2]string]time.Duration{}
+	)
+
+	for i := range ipInstanceList.Items {
+		var ipInstance = &ipInstanceList.Items[i]
+		if !ipInstance.DeletionTimestamp.IsZero() || len(ipInstance.Status.PodName) == 0 {
+			continue
+		}
+		if ipInstance.Status.Phase != networkingv1.IPPhaseUsing && ipInstance.Status.Phase != networkingv1.IPPhaseBinding {
+			continue
+		}
+
+		key := unboundIPKey{
+			NamespacedName: apitypes.NamespacedName{Namespace: ipInstance.Namespace, Name: ipInstance.Name},
+			podName:        ipInstance.Status.PodName,
+		}
+		currentKeys[key] = true
+
+		since, exist := r.unboundSince[key]
+		if !exist {
+			// nothing is known about the history before manager starts, creation time is the best guess
+			since = now
+			if firstRound {
+				since = ipInstance.CreationTimestamp.Time
+			}
+			r.unboundSince[key] = since
+		}
+
+		age := now.Sub(since)
+		labels := [2]string{ipInstance.Status.NodeName, ipInstance.Spec.Subnet}
+		if oldest, exist := oldestAges[labels]; !exist || age > oldest {
+			oldestAges[labels] = age
+		}
+
+		if r.Threshold > 0 && age >= r.Threshold && !r.warned[key] {
+			r.warned[key] = true
+			r.Logger.Info("ip instance stays unbound too long", "namespace", ipInstance.Namespace, "name", ipInstance.Name,
+				"pod", ipInstance.Status.PodName, "node", ipInstance.Status.NodeName, "age", age.Round(time.Second))
+			if r.Recorder != nil {
+				r.Recorder.Eventf(ipInstance, corev1.EventTypeWarning, ReasonIPUnboundTooLong,
+					"ip %s has been allocated to pod %s but not bound for %s", ipInstance.Spec.Address.IP,
+					ipInstance.Status.PodName, age.Round(time.Second))
+			}
+		}
+	}
+
+	// forget the ip instances which have been bound or released
+	for key := range r.unboundSince {
+		if !currentKeys[key] {
+			delete(r.unboundSince, key)
+			delete(r.warned, key)
+		}
+	}
+
+	for labels, age := range oldestAges {
+		metrics.IPUnboundOldestAgeGauge.WithLabelValues(labels[0], labels[1]).Set(age.Seconds())
+	}
+	for labels := range r.gaugeLabels {
+		if _, exist := oldestAges[labels]; !exist {
+			metrics.IPUnboundOldestAgeGauge.DeleteLabelValues(labels[0], labels[1])
+		}
+	}
+
+	r.gaugeLabels = map[[2]string]bool{}
+	for labels := range oldestAges {
+		r.gaugeLabels[labels] = true
+	}
+}
@@ -0,0 +1,165 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+)
+
+func unboundTestIPInstance(phase networkingv1.IPPhase, created time.Time) *networkingv1.IPInstance {
+	return &networkingv1.IPInstance{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "default",
+			Name:              "10-0-0-1",
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Spec: networkingv1.IPInstanceSpec{
+			Subnet:  "subnet1",
+			Address: networkingv1.Address{IP: "10.0.0.1/24"},
+		},
+		Status: networkingv1.IPInstanceStatus{
+			PodName:  "pod1",
+			NodeName: "node1",
+			Phase:    phase,
+		},
+	}
+}
+
+func TestIPInstanceUnbound(t *testing.T) {
+	now := metav1.Now()
+	running := []corev1.ContainerStatus{{State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}}
+	waiting := []corev1.ContainerStatus{{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{}}}}
+	restarted := []corev1.ContainerStatus{{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{}}, RestartCount: 1}}
+
+	tests := []struct {
+		name     string
+		phase    networkingv1.IPPhase
+		pod      *corev1.Pod
+		expected bool
+	}{
+		{
+			"using ip of pod waiting for sandbox",
+			networkingv1.IPPhaseUsing,
+			&corev1.Pod{Spec: corev1.PodSpec{NodeName: "node1"}, Status: corev1.PodStatus{ContainerStatuses: waiting}},
+			true,
+		},
+		{
+			"using ip of running pod",
+			networkingv1.IPPhaseUsing,
+			&corev1.Pod{Spec: corev1.PodSpec{NodeName: "node1"}, Status: corev1.PodStatus{ContainerStatuses: running}},
+			false,
+		},
+		{
+			"using ip of pod running init containers",
+			networkingv1.IPPhaseUsing,
+			&corev1.Pod{Spec: corev1.PodSpec{NodeName: "node1"}, Status: corev1.PodStatus{InitContainerStatuses: running}},
+			false,
+		},
+		{
+			"using ip of crash looping pod",
+			networkingv1.IPPhaseUsing,
+			&corev1.Pod{Spec: corev1.PodSpec{NodeName: "node1"}, Status: corev1.PodStatus{ContainerStatuses: restarted}},
+			false,
+		},
+		{
+			"binding ip of running pod",
+			networkingv1.IPPhaseBinding,
+			&corev1.Pod{Spec: corev1.PodSpec{NodeName: "node1"}, Status: corev1.PodStatus{ContainerStatuses: running}},
+			true,
+		},
+		{
+			"bound ip",
+			networkingv1.IPPhaseBound,
+			&corev1.Pod{Spec: corev1.PodSpec{NodeName: "node1"}},
+			false,
+		},
+		{
+			"pod on another node",
+			networkingv1.IPPhaseBinding,
+			&corev1.Pod{Spec: corev1.PodSpec{NodeName: "node2"}},
+			false,
+		},
+		{
+			"terminating pod",
+			networkingv1.IPPhaseBinding,
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: &now}, Spec: corev1.PodSpec{NodeName: "node1"}},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if unbound := ipInstanceUnbound(unboundTestIPInstance(test.phase, now.Time), test.pod); unbound != test.expected {
+				t.Errorf("expected unbound %v, got %v", test.expected, unbound)
+			}
+		})
+	}
+}
+
+func TestUnboundIPInstanceMonitorCheck(t *testing.T) {
+	now := time.Now()
+	recorder := record.NewFakeRecorder(10)
+	monitor := &UnboundIPInstanceMonitor{
+		Recorder:     recorder,
+		Logger:       logr.Discard(),
+		Threshold:    5 * time.Minute,
+		unboundSince: map[unboundIPKey]time.Time{},
+		warned:       map[unboundIPKey]bool{},
+		gaugeLabels:  map[[2]string]bool{},
+	}
+
+	unboundList := &networkingv1.IPInstanceList{
+		Items: []networkingv1.IPInstance{*unboundTestIPInstance(networkingv1.IPPhaseUsing, now.Add(-10*time.Minute))},
+	}
+
+	// the creation time is taken in the first round, so the ip instance is warned at once
+	monitor.check(unboundList, now, true)
+	if len(recorder.Events) != 1 {
+		t.Fatalf("expected one warning, got %d", len(recorder.Events))
+	}
+	<-recorder.Events
+
+	// the ip instance is warned only once
+	monitor.check(unboundList, now.Add(time.Minute), false)
+	if len(recorder.Events) != 0 {
+		t.Fatalf("expected no more warnings, got %d", len(recorder.Events))
+	}
+
+	// the ip instance is forgotten once bound
+	monitor.check(&networkingv1.IPInstanceList{}, now.Add(2*time.Minute), false)
+	if len(monitor.unboundSince) != 0 || len(monitor.warned) != 0 || len(monitor.gaugeLabels) != 0 {
+		t.Errorf("expected bound ip instance to be forgotten")
+	}
+
+	// the ip instance observed unbound again after manager starts is timed from now on
+	monitor.check(unboundList, now.Add(3*time.Minute), false)
+	if len(recorder.Events) != 0 {
+		t.Fatalf("expected no warnings before threshold, got %d", len(recorder.Events))
+	}
+	monitor.check(unboundList, now.Add(8*time.Minute), false)
+	if len(recorder.Events) != 1 {
+		t.Fatalf("expected one warning after threshold, got %d", len(recorder.Events))
+	}
+}
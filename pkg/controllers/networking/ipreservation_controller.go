@@ -0,0 +1,137 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/controllers/concurrency"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+const ControllerIPReservation = "IPReservation"
+
+// MaxIPReservationSize is the max count of ips in the range of one ip reservation
+const MaxIPReservationSize = 4096
+
+// IPReservationReconciler reconciles a IPReservation object, it reserves the range in IPAM Manager
+// and activates the ip reservation, then the range will be reserved again from active ip reservations
+// whenever IPAM Manager is refreshed or restarted
+type IPReservationReconciler struct {
+	client.Client
+
+	IPAMManager IPAMManager
+
+	concurrency.ControllerConcurrency
+}
+
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=ipreservations,verbs=get;list;watch
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=ipreservations/status,verbs=get;update;patch
+
+func (r *IPReservationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var reservation = &networkingv1.IPReservation{}
+	if err := r.Get(ctx, req.NamespacedName, reservation); err != nil {
+		return ctrl.Result{}, wrapError("unable to fetch IPReservation", client.IgnoreNotFound(err))
+	}
+
+	// the range is released by refreshing IPAM Manager after deleted
+	if !reservation.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	// the phase is decided for current spec already, a rejected one will be checked again after changed
+	if reservation.Status.ObservedGeneration == reservation.Generation && len(reservation.Status.Phase) > 0 {
+		return ctrl.Result{}, nil
+	}
+
+	message, err := r.reserve(ctx, reservation)
+	if err != nil {
+		return ctrl.Result{}, wrapError("unable to reserve range", err)
+	}
+
+	var phase = networkingv1.IPReservationPhaseActive
+	if len(message) > 0 {
+		phase = networkingv1.IPReservationPhaseRejected
+	}
+
+	if err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		patch := client.MergeFrom(reservation.DeepCopy())
+		reservation.Status.Phase = phase
+		reservation.Status.ObservedGeneration = reservation.Generation
+		reservation.Status.Message = message
+		return r.Status().Patch(ctx, reservation, patch)
+	}); err != nil {
+		return ctrl.Result{}, wrapError("unable to update status of IPReservation", err)
+	}
+	return ctrl.Result{}, nil
+}
+
+// reserve reserves the range of ip reservation in IPAM Manager, the reason is returned if the
+// reservation is rejected
+func (r *IPReservationReconciler) reserve(ctx context.Context, reservation *networkingv1.IPReservation) (string, error) {
+	subnet, err := utils.GetSubnet(r, reservation.Spec.Subnet)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Sprintf("subnet %s not found", reservation.Spec.Subnet), nil
+		}
+		return "", err
+	}
+
+	_, cidr, err := net.ParseCIDR(subnet.Spec.Range.CIDR)
+	if err != nil {
+		return fmt.Sprintf("invalid cidr of subnet %s: %v", subnet.Name, err), nil
+	}
+
+	ips, err := utils.ExpandIPRange(reservation.Spec.Start, reservation.Spec.End, cidr, MaxIPReservationSize)
+	if err != nil {
+		return err.Error(), nil
+	}
+
+	if err = reserveRangeInManager(r.IPAMManager, subnet.Spec.Network, subnet.Name, ips); err != nil {
+		if errors.Is(err, ipamtypes.ErrReservedIPInUse) {
+			return err.Error(), nil
+		}
+		return "", err
+	}
+	return "", nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IPReservationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(ControllerIPReservation).
+		For(&networkingv1.IPReservation{}, builder.WithPredicates(
+			&predicate.GenerationChangedPredicate{},
+		)).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.Max(),
+		}).
+		Complete(r)
+}
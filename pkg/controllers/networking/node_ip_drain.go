@@ -0,0 +1,202 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/feature"
+)
+
+const DrainerNodeIP = "NodeIPDrainer"
+
+// NodeIPDrainPath is the http path of draining node ips, e.g., "POST /drain-node?node=node1"
+const NodeIPDrainPath = "/drain-node"
+
+var _ manager.Runnable = &NodeIPDrainer{}
+
+// NodeIPDrainer serves an endpoint for operators to drain the IP instances of a node before it is
+// decommissioned. IP instances of the pods which have been deleted, evicted or completed are recycled,
+// or reserved for the deleted stateful pods as garbage collection does, and the ones still used by pods
+// are reported, so that the node can be deleted after they are all gone. It only serves on leader,
+// because the IPAM store is only refreshed there.
+type NodeIPDrainer struct {
+	client.Client
+	APIReader client.Reader
+	Logger    logr.Logger
+
+	IPAMStore IPAMStore
+
+	// BindAddress is the address which drain endpoint listens on, it must be a loopback address
+	// unless TLS is set
+	BindAddress string
+	TLS         *EndpointTLSConfig
+}
+
+// NodeIPDrainResult is the response of draining node ips
+type NodeIPDrainResult struct {
+	Node     string              `json:"node"`
+	Recycled []DrainedIPInstance `json:"recycled,omitempty"`
+	Reserved []DrainedIPInstance `json:"reserved,omitempty"`
+	InUse    []DrainedIPInstance `json:"inUse,omitempty"`
+	Failed   []DrainedIPInstance `json:"failed,omitempty"`
+}
+
+// DrainedIPInstance describes an IP instance handled by draining
+type DrainedIPInstance struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	IP        string `json:"ip"`
+	Pod       string `json:"pod,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (r *NodeIPDrainer) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle(NodeIPDrainPath, r)
+
+	r.Logger.Info("node ip drainer is serving", "address", r.BindAddress, "path", NodeIPDrainPath,
+		"tls", r.TLS.Enabled())
+	if err := serveEndpoint(ctx, r.BindAddress, mux, r.TLS, true); err != nil {
+		return fmt.Errorf("unable to serve node ip drainer: %v", err)
+	}
+	return nil
+}
+
+func (r *NodeIPDrainer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("method %s is not allowed", req.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	nodeName := req.URL.Query().Get("node")
+	if len(nodeName) == 0 {
+		http.Error(w, "node must be specified", http.StatusBadRequest)
+		return
+	}
+
+	result, err := r.Drain(req.Context(), nodeName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// Drain recycles the IP instances of node whose pods are gone, and reports the ones still in use
+func (r *NodeIPDrainer) Drain(ctx context.Context, nodeName string) (*NodeIPDrainResult, error) {
+	r.Logger.Info("start draining node ips", "node", nodeName)
+
+	ipInstanceList, err := utils.ListUsingIPInstances(r, client.MatchingLabels{constants.LabelNode: nodeName})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list ip instances of node %s: %v", nodeName, err)
+	}
+
+	var (
+		result = &NodeIPDrainResult{Node: nodeName}
+		// ip instances of the same pod are decoupled together
+		decoupled = map[apitypes.NamespacedName]error{}
+	)
+	for i := range ipInstanceList.Items {
+		var ipInstance = &ipInstanceList.Items[i]
+		if !ipInstance.DeletionTimestamp.IsZero() || !networkingv1.IsUsingPhase(ipInstance.Status.Phase) ||
+			len(ipInstance.Status.PodName) == 0 {
+			continue
+		}
+
+		drained := DrainedIPInstance{
+			Namespace: ipInstance.Namespace,
+			Name:      ipInstance.Name,
+			IP:        ipInstance.Spec.Address.IP,
+			Pod:       ipInstance.Status.PodName,
+		}
+
+		podKey := apitypes.NamespacedName{Namespace: ipInstance.Status.PodNamespace, Name: ipInstance.Status.PodName}
+		pod := &corev1.Pod{}
+		// fetch pod from apiserver directly, because a missing pod in cache is not reliable
+		if err = r.APIReader.Get(ctx, podKey, pod); err != nil {
+			if !apierrors.IsNotFound(err) {
+				drained.Error = fmt.Sprintf("unable to get pod: %v", err)
+				result.Failed = append(result.Failed, drained)
+				continue
+			}
+
+			var reserved bool
+			if reserved, err = collectIPInstanceOfDeletedPod(ctx, r, r.IPAMStore, r.Logger, ipInstance); err != nil {
+				drained.Error = err.Error()
+				result.Failed = append(result.Failed, drained)
+			} else if reserved {
+				result.Reserved = append(result.Reserved, drained)
+			} else {
+				result.Recycled = append(result.Recycled, drained)
+			}
+			continue
+		}
+
+		// terminating pods are left to pod controller
+		if pod.DeletionTimestamp == nil && (utils.PodIsEvicted(pod) || utils.PodIsCompleted(pod)) {
+			decoupleErr, exist := decoupled[podKey]
+			if !exist {
+				r.Logger.Info("decouple ip instances of evicted or completed pod", "pod", podKey.String())
+				decoupleErr = r.decouple(pod)
+				decoupled[podKey] = decoupleErr
+			}
+			if decoupleErr != nil {
+				drained.Error = decoupleErr.Error()
+				result.Failed = append(result.Failed, drained)
+			} else {
+				result.Recycled = append(result.Recycled, drained)
+			}
+			continue
+		}
+
+		result.InUse = append(result.InUse, drained)
+	}
+
+	r.Logger.Info("node ips are drained", "node", nodeName, "recycled", len(result.Recycled),
+		"reserved", len(result.Reserved), "inUse", len(result.InUse), "failed", len(result.Failed))
+	return result, nil
+}
+
+func (r *NodeIPDrainer) decouple(pod *corev1.Pod) error {
+	var decoupleFunc func(pod *corev1.Pod) (err error)
+	if feature.DualStackEnabled() {
+		decoupleFunc = r.IPAMStore.DualStack().DeCouple
+	} else {
+		decoupleFunc = r.IPAMStore.DeCouple
+	}
+
+	if err := decoupleFunc(pod); err != nil {
+		return fmt.Errorf("unable to decouple ips for pod %s: %v", client.ObjectKeyFromObject(pod).String(), err)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+// drainClient serves ip instances filtered by labels and the phase indexer
+type drainClient struct {
+	client.Client
+	ipInstances []networkingv1.IPInstance
+}
+
+func (c *drainClient) List(_ context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOptions := &client.ListOptions{}
+	listOptions.ApplyOptions(opts)
+
+	ipInstanceList := list.(*networkingv1.IPInstanceList)
+	for i := range c.ipInstances {
+		if listOptions.LabelSelector != nil && !listOptions.LabelSelector.Matches(labels.Set(c.ipInstances[i].Labels)) {
+			continue
+		}
+		if listOptions.FieldSelector != nil {
+			phase, found := listOptions.FieldSelector.RequiresExactMatch(utils.IndexerFieldIPInstancePhase)
+			if found && phase != string(c.ipInstances[i].Status.Phase) {
+				continue
+			}
+		}
+		ipInstanceList.Items = append(ipInstanceList.Items, c.ipInstances[i])
+	}
+	return nil
+}
+
+// drainReader serves pods by name, pods missing are not found and the failing one returns error
+type drainReader struct {
+	client.Reader
+	pods    map[string]*corev1.Pod
+	failing string
+}
+
+func (r *drainReader) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	if key.Name == r.failing {
+		return fmt.Errorf("connection refused")
+	}
+	pod, exist := r.pods[key.Name]
+	if !exist {
+		return apierrors.NewNotFound(schema.GroupResource{}, key.Name)
+	}
+	pod.DeepCopyInto(obj.(*corev1.Pod))
+	return nil
+}
+
+// drainStore records the ips recycled and the pods decoupled
+type drainStore struct {
+	IPAMStore
+	recycled  []string
+	decoupled []string
+}
+
+func (s *drainStore) IPRecycle(_ string, ip *types.IP) error {
+	s.recycled = append(s.recycled, ip.Address.IP.String())
+	return nil
+}
+
+func (s *drainStore) DeCouple(pod *corev1.Pod) error {
+	s.decoupled = append(s.decoupled, pod.Name)
+	return nil
+}
+
+func drainIPInstance(name, address, node, podName string, phase networkingv1.IPPhase) networkingv1.IPInstance {
+	return networkingv1.IPInstance{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+			Labels:    map[string]string{constants.LabelNode: node},
+		},
+		Spec: networkingv1.IPInstanceSpec{
+			Address: networkingv1.Address{IP: address, Version: networkingv1.IPv4},
+		},
+		Status: networkingv1.IPInstanceStatus{
+			NodeName:     node,
+			Phase:        phase,
+			PodName:      podName,
+			PodNamespace: "default",
+		},
+	}
+}
+
+func newNodeIPDrainer() (*NodeIPDrainer, *drainStore) {
+	deleting := drainIPInstance("deleting", "192.168.0.6/24", "node1", "deleted-pod", networkingv1.IPPhaseUsing)
+	deleting.DeletionTimestamp = &metav1.Time{}
+
+	store := &drainStore{}
+	return &NodeIPDrainer{
+		Client: &drainClient{
+			ipInstances: []networkingv1.IPInstance{
+				drainIPInstance("running", "192.168.0.1/24", "node1", "running-pod", networkingv1.IPPhaseUsing),
+				drainIPInstance("deleted", "192.168.0.2/24", "node1", "deleted-pod", networkingv1.IPPhaseUsing),
+				drainIPInstance("evicted", "192.168.0.3/24", "node1", "evicted-pod", networkingv1.IPPhaseBound),
+				drainIPInstance("failing", "192.168.0.4/24", "node1", "failing-pod", networkingv1.IPPhaseUsing),
+				drainIPInstance("reserved", "192.168.0.5/24", "node1", "reserved-pod", networkingv1.IPPhaseReserved),
+				deleting,
+				drainIPInstance("other-node", "192.168.0.7/24", "node2", "deleted-pod", networkingv1.IPPhaseUsing),
+			},
+		},
+		APIReader: &drainReader{
+			pods: map[string]*corev1.Pod{
+				"running-pod": {
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "running-pod"},
+					Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+				},
+				"evicted-pod": {
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "evicted-pod"},
+					Status:     corev1.PodStatus{Phase: corev1.PodFailed, Reason: "Evicted"},
+				},
+			},
+			failing: "failing-pod",
+		},
+		Logger:    logr.Discard(),
+		IPAMStore: store,
+	}, store
+}
+
+func drainedNames(drained []DrainedIPInstance) string {
+	var names []string
+	for _, d := range drained {
+		names = append(names, d.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestNodeIPDrainerServeHTTP(t *testing.T) {
+	drainer, store := newNodeIPDrainer()
+
+	recorder := httptest.NewRecorder()
+	drainer.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, NodeIPDrainPath+"?node=node1", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expect code %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+
+	result := &NodeIPDrainResult{}
+	if err := json.NewDecoder(recorder.Body).Decode(result); err != nil {
+		t.Fatalf("unable to decode result: %v", err)
+	}
+
+	if result.Node != "node1" {
+		t.Errorf("expect node node1, got %s", result.Node)
+	}
+	if names := drainedNames(result.Recycled); names != "deleted,evicted" {
+		t.Errorf("expect recycled deleted,evicted, got %s", names)
+	}
+	if names := drainedNames(result.InUse); names != "running" {
+		t.Errorf("expect in use running, got %s", names)
+	}
+	if names := drainedNames(result.Failed); names != "failing" {
+		t.Errorf("expect failed failing, got %s", names)
+	}
+	if len(result.Reserved) != 0 {
+		t.Errorf("expect nothing reserved, got %s", drainedNames(result.Reserved))
+	}
+
+	if strings.Join(store.recycled, ",") != "192.168.0.2" {
+		t.Errorf("expect ip of deleted pod recycled, got %v", store.recycled)
+	}
+	if strings.Join(store.decoupled, ",") != "evicted-pod" {
+		t.Errorf("expect evicted pod decoupled, got %v", store.decoupled)
+	}
+}
+
+func TestNodeIPDrainerRejectsInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		expectCode int
+	}{
+		{
+			"get is not allowed",
+			http.MethodGet,
+			NodeIPDrainPath + "?node=node1",
+			http.StatusMethodNotAllowed,
+		},
+		{
+			"missing node",
+			http.MethodPost,
+			NodeIPDrainPath,
+			http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			drainer, store := newNodeIPDrainer()
+
+			recorder := httptest.NewRecorder()
+			drainer.ServeHTTP(recorder, httptest.NewRequest(test.method, test.url, nil))
+			if recorder.Code != test.expectCode {
+				t.Errorf("expect code %d, got %d", test.expectCode, recorder.Code)
+			}
+			if len(store.recycled) > 0 || len(store.decoupled) > 0 {
+				t.Errorf("expect nothing drained, got recycled %v decoupled %v", store.recycled, store.decoupled)
+			}
+		})
+	}
+}
+
+func TestNodeIPDrainerRefusesNonLoopbackAddressWithoutTLS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	drainer, _ := newNodeIPDrainer()
+	drainer.BindAddress = ":0"
+	if err := drainer.Start(ctx); err == nil {
+		t.Errorf("expect drainer refused on all interfaces without tls")
+	}
+}
@@ -18,15 +18,26 @@ package networking
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
+	"net"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/pointer"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -40,6 +51,7 @@ import (
 	"github.com/alibaba/hybridnet/pkg/controllers/concurrency"
 	"github.com/alibaba/hybridnet/pkg/controllers/utils"
 	"github.com/alibaba/hybridnet/pkg/feature"
+	"github.com/alibaba/hybridnet/pkg/ipam/store"
 	"github.com/alibaba/hybridnet/pkg/ipam/strategy"
 	"github.com/alibaba/hybridnet/pkg/ipam/types"
 	"github.com/alibaba/hybridnet/pkg/metrics"
@@ -52,8 +64,20 @@ const ControllerPod = "Pod"
 const (
 	ReasonIPAllocationSucceed = "IPAllocationSucceed"
 	ReasonIPAllocationFail    = "IPAllocationFail"
+	ReasonSubnetExhausted     = "SubnetExhausted"
 	ReasonIPReleaseSucceed    = "IPReleaseSucceed"
 	ReasonIPReserveSucceed    = "IPReserveSucceed"
+	ReasonExternalIPMissing   = "ExternalIPMissing"
+	ReasonIPAllocationPaused  = "IPAllocationPaused"
+	ReasonNetworkAmbiguous    = "NetworkAmbiguous"
+	ReasonIPFamilyMismatch    = "IPFamilyMismatch"
+
+	ReasonIPAllocationThrottled = "IPAllocationThrottled"
+
+	ReasonSubnetAntiAffinityUnsatisfied = "SubnetAntiAffinityUnsatisfied"
+	ReasonSubnetAffinityUnsatisfied     = "SubnetAffinityUnsatisfied"
+	ReasonSpecifiedSubnetUnavailable    = "SpecifiedSubnetUnavailable"
+	ReasonFinalizerRemovalFail          = "FinalizerRemovalFail"
 )
 
 const (
@@ -61,6 +85,31 @@ const (
 	OverlayNodeName  = "c3e6699d28e7"
 )
 
+// networkPausedRequeueInterval is how often pods on paused network will be retried
+const networkPausedRequeueInterval = 30 * time.Second
+
+// allocationThrottledRequeueInterval is how often pods over the allocation rate limit of network will be retried,
+// it is jittered up to allocationThrottledRequeueJitter times more, so that the throttled pods do not come back
+// all at once and are throttled again
+const (
+	allocationThrottledRequeueInterval = time.Second
+	allocationThrottledRequeueJitter   = 1.0
+)
+
+// defaultAllocationRequeueMaxDelay caps the allocation backoff if no max delay is specified
+const defaultAllocationRequeueMaxDelay = 5 * time.Minute
+
+// allocationHookMaxAttempts is the max attempts to allocate ips for pod if allocated
+// ips keep being rejected by allocation hook
+const allocationHookMaxAttempts = 3
+
+const (
+	permanentFailureEventCacheSize = 1024
+	permanentFailureEventInterval  = 5 * time.Minute
+
+	allocationFailureEventCacheSize = 4096
+)
+
 // PodReconciler reconciles a Pod object
 type PodReconciler struct {
 	APIReader client.Reader
@@ -68,15 +117,62 @@ type PodReconciler struct {
 
 	Recorder record.EventRecorder
 
+	// permanentFailureEvents records the pods which were warned with permanent failures recently
+	permanentFailureEvents *cache.LRUExpireCache
+
+	// AllocationFailureEventInterval is the min interval between the allocation failure events of a pod,
+	// the first failure is always warned at once, zero means every failure is warned
+	AllocationFailureEventInterval time.Duration
+
+	// allocationFailureEvents records the pods which were warned with allocation failures recently
+	allocationFailureEvents *cache.LRUExpireCache
+
 	IPAMStore   IPAMStore
 	IPAMManager IPAMManager
 
+	// ExternalIPTimeout is how long externally addressed pod can wait for its ip instances
+	ExternalIPTimeout time.Duration
+
+	// AllocationEventSink exports allocation events with full details, nil means disabled
+	AllocationEventSink AllocationEventSink
+
+	// AllocationRequeueBaseDelay and AllocationRequeueMaxDelay are the global backoff of requeuing
+	// pods failing to get ips, which can be overridden by network, zero base delay means the
+	// failures are left to the rate limiter of controller
+	AllocationRequeueBaseDelay time.Duration
+	AllocationRequeueMaxDelay  time.Duration
+
+	// StatefulAllocateTimeout is the deadline of allocation for stateful pods, zero means no deadline
+	StatefulAllocateTimeout time.Duration
+
+	// SubnetRebalanceHints leads the pods replacing the ones moved by rebalancer to target subnets
+	SubnetRebalanceHints *SubnetRebalanceHints
+
+	// RecycleOnNamespaceDeletion releases the ips of stateful pods instead of reserving them
+	// if their namespace is being deleted, since no workload can recreate them there
+	RecycleOnNamespaceDeletion bool
+
+	// LazyAllocation defers the allocation of pods until their cni add requests arrive, which are
+	// reported by daemons through AllocationRequester
+	LazyAllocation bool
+
+	// StaleNodeIPPolicy decides how to handle the ips of allocated pods left on another node
+	StaleNodeIPPolicy string
+
+	// allocationFailures counts the consecutive failures of pods requeued by backoff
+	allocationFailures map[apitypes.NamespacedName]int
+	// allocationAttempts counts the allocation attempts of pods for correlation ids
+	allocationAttempts     map[apitypes.NamespacedName]int
+	allocationFailuresLock sync.Mutex
+
 	concurrency.ControllerConcurrency
 }
 
 //+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="",resources=pods/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups="",resources=pods/finalizers,verbs=update
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get
 
 func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
 	log := ctrllog.FromContext(ctx)
@@ -84,17 +180,66 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result
 	var (
 		pod         = &corev1.Pod{}
 		networkName string
+		outcome     string
 	)
 
 	defer func() {
-		if err != nil {
+		if err == nil {
+			r.forgetAllocationFailure(req.NamespacedName)
+			r.forgetAllocationFailureEvent(pod, outcome)
+			return
+		}
+
+		// over-rate allocations are expected to be retried soon, they are neither failures
+		// to back off nor reported on the ip allocated condition of pod
+		if types.IsAllocationThrottled(err) {
+			requeueAfter := wait.Jitter(allocationThrottledRequeueInterval, allocationThrottledRequeueJitter)
+			log.V(4).Info(fmt.Sprintf("requeue after %v by allocation rate limit: %v", requeueAfter, err))
+			if len(pod.UID) > 0 && r.allowAllocationFailureEvent(pod) {
+				r.Recorder.Eventf(pod, corev1.EventTypeWarning, ReasonIPAllocationThrottled,
+					"allocation rate limit of network %s is exceeded, will retry later", networkName)
+			}
+			result, err = ctrl.Result{RequeueAfter: requeueAfter}, nil
+			return
+		}
+
+		if !IsPermanentError(err) {
 			log.Error(err, "reconciliation fails")
 			if len(pod.UID) > 0 {
-				r.Recorder.Event(pod, corev1.EventTypeWarning, ReasonIPAllocationFail, err.Error())
+				r.reportAllocationFailure(ctx, pod, networkName, err)
+			}
+			// requeue by backoff instead of returning error, so rate limiter of controller is reset
+			if delay := r.allocationRequeueDelay(networkName, req.NamespacedName); delay > 0 {
+				log.V(4).Info(fmt.Sprintf("requeue after %v by allocation backoff", delay))
+				result, err = ctrl.Result{RequeueAfter: delay}, nil
+			}
+			return
+		}
+
+		// permanent failures will not be requeued, and the warning events should be
+		// rate-limited to avoid event spam if pod keeps being updated
+		log.Error(err, "reconciliation fails permanently, skip requeue")
+		if len(pod.UID) > 0 {
+			r.reportPermanentFailure(ctx, pod, err)
+		}
+		result, err = ctrl.Result{}, nil
+	}()
+
+	// runs before the error handling above, which may swallow the error for requeue
+	defer func() {
+		if err != nil {
+			outcome = metrics.PodReconcileOutcomeFailed
+			if types.IsCapacityExhausted(err) {
+				outcome = metrics.PodReconcileOutcomeExhausted
+			} else if types.IsAllocationThrottled(err) {
+				outcome = metrics.PodReconcileOutcomeThrottled
+				metrics.NetworkIPAllocationThrottledCounter.WithLabelValues(networkName).Inc()
 			}
 		}
+		metrics.PodReconcileOutcomeCounter.WithLabelValues(outcome).Inc()
 	}()
 
+	outcome = metrics.PodReconcileOutcomeIgnored
 	if err = r.APIReader.Get(ctx, req.NamespacedName, pod); err != nil {
 		if err = client.IgnoreNotFound(err); err != nil {
 			return ctrl.Result{}, fmt.Errorf("unable to fetch Pod: %v", err)
@@ -102,8 +247,62 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result
 		return ctrl.Result{}, nil
 	}
 
+	// ip instances of externally addressed pod are managed by external controller,
+	// allocation is skipped and only the existence of ip instances will be checked
+	if utils.PodIsExternallyAddressed(pod) {
+		if pod.DeletionTimestamp != nil {
+			return ctrl.Result{}, nil
+		}
+		outcome = metrics.PodReconcileOutcomeExternal
+		return r.checkExternalIPInstances(ctx, pod)
+	}
+
 	if pod.DeletionTimestamp != nil {
+		// finalizer left on pod whose ips have been reserved or released, e.g., out-of-band,
+		// must not block the deletion of pod
+		var stale bool
+		if stale, err = r.finalizerIsStale(ctx, pod); err != nil {
+			return ctrl.Result{}, wrapError("unable to check finalizer of pod", err)
+		}
+		if stale {
+			log.V(4).Info("remove stale finalizer of pod without ip instances in use")
+			return ctrl.Result{}, wrapError("unable to remove finalizer", r.removeFinalizer(ctx, pod))
+		}
+
 		if strategy.OwnByStatefulWorkload(pod) {
+			// IPs must not be reserved for reusing until pod is truly gone, or else they
+			// might be bound to the old and new pods at the same time
+			if wait := utils.PodTerminationWait(pod, time.Now()); wait > 0 {
+				log.V(4).Info(fmt.Sprintf("pod is still terminating, wait %v for reservation", wait))
+				return ctrl.Result{RequeueAfter: wait}, nil
+			}
+			var scaledDown, namespaceDeleting bool
+			var releaseOnDeletion = globalutils.ParseBoolOrDefault(pod.Annotations[constants.AnnotationIPReleaseOnDeletion], false)
+			if !releaseOnDeletion {
+				if scaledDown, err = r.scaledDownToRelease(ctx, pod); err != nil {
+					return ctrl.Result{}, wrapError("unable to check scaling down of pod", err)
+				}
+			}
+			if !releaseOnDeletion && !scaledDown && r.RecycleOnNamespaceDeletion {
+				if namespaceDeleting, err = r.namespaceDeleting(ctx, pod.Namespace); err != nil {
+					return ctrl.Result{}, wrapError("unable to check deletion of namespace", err)
+				}
+			}
+			if releaseOnDeletion || scaledDown || namespaceDeleting {
+				outcome = metrics.PodReconcileOutcomeReleased
+				cause := "scaled-down"
+				switch {
+				case releaseOnDeletion:
+					cause = "release-on-deletion"
+				case namespaceDeleting:
+					cause = "namespace-deleting"
+				}
+				if err = r.releaseStateful(pod, cause); err != nil {
+					return ctrl.Result{}, wrapError(fmt.Sprintf("unable to release %s pod", cause), err)
+				}
+				return ctrl.Result{}, wrapError("unable to remove finalizer", r.removeFinalizer(ctx, pod))
+			}
+			outcome = metrics.PodReconcileOutcomeReserved
 			if err = r.reserve(pod); err != nil {
 				return ctrl.Result{}, wrapError("unable to reserve pod", err)
 			}
@@ -114,26 +313,173 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result
 
 	// Pre decouple ip instances for completed or evicted pods
 	if utils.PodIsEvicted(pod) || utils.PodIsCompleted(pod) {
+		outcome = metrics.PodReconcileOutcomeDecoupled
 		return ctrl.Result{}, wrapError("unable to decouple pod", r.decouple(pod))
 	}
 
 	// To avoid IP duplicate allocation in high-frequent pod updates scenario because of
-	// the fucking *delay* of informer
+	// the fucking *delay* of informer, only the ips left on another node are handled
 	if metav1.HasAnnotation(pod.ObjectMeta, constants.AnnotationIP) {
+		outcome = metrics.PodReconcileOutcomeSkipped
+		// a failed loopback ip allocation is retried here since the ips of pod have been allocated
+		if err = r.ensureLoopbackIP(ctx, pod); err != nil {
+			return ctrl.Result{}, wrapError("unable to allocate loopback ip", err)
+		}
+		// ips left on another node are not skipped, and counted by what is done to them
+		var staleNodeOutcome string
+		if staleNodeOutcome, err = r.handleIPsOnStaleNode(ctx, pod); len(staleNodeOutcome) > 0 {
+			outcome = staleNodeOutcome
+		}
+		return ctrl.Result{}, wrapError("unable to handle ips on stale node", err)
+	}
+
+	// pods will be requeued by the update of annotation once allocation is requested
+	if r.LazyAllocation && !metav1.HasAnnotation(pod.ObjectMeta, constants.AnnotationAllocationRequested) {
+		log.V(4).Info("allocation is deferred until requested")
+		outcome = metrics.PodReconcileOutcomeDeferred
 		return ctrl.Result{}, nil
 	}
 
-	networkName, err = r.selectNetwork(pod)
-	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("unable to select network: %v", err)
+	// fixed ips of ip binding take precedence over any other allocation, including the network
+	var binding *networkingv1.IPBinding
+	if binding, err = r.ipBindingOf(ctx, pod); err != nil {
+		return ctrl.Result{}, wrapError("unable to get ip binding", err)
+	}
+
+	if binding != nil {
+		networkName = binding.Spec.Network
+	} else if networkName, err = r.selectNetwork(ctx, pod); err != nil {
+		return ctrl.Result{}, wrapError("unable to select network", err)
+	}
+
+	// pods are left pending on paused network, and will be retried until network resumes
+	if paused, err := r.networkPaused(networkName); err != nil {
+		return ctrl.Result{}, wrapError("unable to check network", err)
+	} else if paused {
+		outcome = metrics.PodReconcileOutcomePaused
+		return r.waitForNetworkResuming(pod, networkName), nil
+	}
+
+	// correlation id is committed to pod along with ips or failures, so the logs of manager and
+	// daemon about the same allocation attempt can be tied together
+	correlationID := r.correlationIDOf(pod)
+	log = log.WithValues("correlationID", correlationID)
+	ctx = ctrllog.IntoContext(ctx, log)
+	metav1.SetMetaDataAnnotation(&pod.ObjectMeta, constants.AnnotationCorrelationID, correlationID)
+
+	if binding != nil {
+		log.V(4).Info("assign bound ips for pod", "binding", binding.Name)
+		outcome = metrics.PodReconcileOutcomeReassigned
+		if err = r.bindingAssign(ctx, pod, networkName, binding); err != nil {
+			return ctrl.Result{}, wrapError("unable to assign bound ips", err)
+		}
+		return ctrl.Result{}, wrapError("unable to allocate loopback ip", r.ensureLoopbackIP(ctx, pod))
 	}
 
 	if strategy.OwnByStatefulWorkload(pod) {
 		log.V(4).Info("strategic allocation for pod")
-		return ctrl.Result{}, wrapError("unable to stateful allocate", r.statefulAllocate(ctx, pod, networkName))
+		var allocatePath string
+		allocatePath, err = r.statefulAllocate(ctx, pod, networkName)
+		switch allocatePath {
+		case metrics.IPReuseAllocatePath:
+			outcome = metrics.PodReconcileOutcomeReused
+		case metrics.IPReassignAllocatePath:
+			outcome = metrics.PodReconcileOutcomeReassigned
+		default:
+			outcome = metrics.PodReconcileOutcomeAllocated
+		}
+		if err != nil {
+			return ctrl.Result{}, wrapError("unable to stateful allocate", err)
+		}
+		return ctrl.Result{}, wrapError("unable to allocate loopback ip", r.ensureLoopbackIP(ctx, pod))
+	}
+
+	outcome = metrics.PodReconcileOutcomeAllocated
+	if err = r.allocate(ctx, pod, networkName); err != nil {
+		return ctrl.Result{}, wrapError("unable to allocate", err)
+	}
+	return ctrl.Result{}, wrapError("unable to allocate loopback ip", r.ensureLoopbackIP(ctx, pod))
+}
+
+// allocationRequeueDelay returns the delay to requeue pod failing to get ips by backoff of network
+// or global backoff, zero means the failure should be left to rate limiter of controller
+func (r *PodReconciler) allocationRequeueDelay(networkName string, podKey apitypes.NamespacedName) time.Duration {
+	baseDelay, maxDelay := r.AllocationRequeueBaseDelay, r.AllocationRequeueMaxDelay
+	if len(networkName) > 0 {
+		if network, err := utils.GetNetwork(r, networkName); err == nil && network.Spec.AllocationRetry != nil {
+			baseDelay = network.Spec.AllocationRetry.BaseDelay.Duration
+			if network.Spec.AllocationRetry.MaxDelay.Duration > 0 {
+				maxDelay = network.Spec.AllocationRetry.MaxDelay.Duration
+			}
+		}
+	}
+	if baseDelay <= 0 {
+		return 0
+	}
+	if maxDelay <= 0 {
+		maxDelay = defaultAllocationRequeueMaxDelay
+	}
+	if maxDelay < baseDelay {
+		maxDelay = baseDelay
+	}
+
+	r.allocationFailuresLock.Lock()
+	defer r.allocationFailuresLock.Unlock()
+
+	failures := r.allocationFailures[podKey]
+	r.allocationFailures[podKey] = failures + 1
+
+	delay := baseDelay
+	for i := 0; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+func (r *PodReconciler) forgetAllocationFailure(podKey apitypes.NamespacedName) {
+	r.allocationFailuresLock.Lock()
+	defer r.allocationFailuresLock.Unlock()
+
+	delete(r.allocationFailures, podKey)
+	delete(r.allocationAttempts, podKey)
+}
+
+// correlationIDOf returns the correlation id of a new allocation attempt for pod
+func (r *PodReconciler) correlationIDOf(pod *corev1.Pod) string {
+	r.allocationFailuresLock.Lock()
+	defer r.allocationFailuresLock.Unlock()
+
+	podKey := apitypes.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+	r.allocationAttempts[podKey]++
+	return fmt.Sprintf("%s-%d", pod.UID, r.allocationAttempts[podKey])
+}
+
+// checkExternalIPInstances will wait for ip instances of externally addressed pod to be created,
+// pod without any ip instance after timeout will be warned
+func (r *PodReconciler) checkExternalIPInstances(ctx context.Context, pod *corev1.Pod) (ctrl.Result, error) {
+	ipList, err := utils.ListIPInstances(r, client.InNamespace(pod.Namespace), client.MatchingLabels{
+		constants.LabelPod: pod.Name,
+	})
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to list ip instances of externally addressed pod: %v", err)
+	}
+
+	for i := range ipList.Items {
+		if ipList.Items[i].DeletionTimestamp == nil {
+			return ctrl.Result{}, nil
+		}
+	}
+
+	if wait := pod.CreationTimestamp.Add(r.ExternalIPTimeout).Sub(time.Now()); wait > 0 {
+		return ctrl.Result{RequeueAfter: wait}, nil
 	}
 
-	return ctrl.Result{}, wrapError("unable to allocate", r.allocate(ctx, pod, networkName))
+	r.Recorder.Event(pod, corev1.EventTypeWarning, ReasonExternalIPMissing,
+		fmt.Sprintf("no ip instance is created for externally addressed pod in %v", r.ExternalIPTimeout))
+	return ctrl.Result{}, nil
 }
 
 // dedouple will unbind IP instance with Pod
@@ -145,11 +491,71 @@ func (r *PodReconciler) decouple(pod *corev1.Pod) (err error) {
 		decoupleFunc = r.IPAMStore.DeCouple
 	}
 
+	// ip instances are gone after decoupling, so they are listed ahead for allocation event
+	networkName, ips := r.allocatedIPsForEvent(pod)
 	if err = decoupleFunc(pod); err != nil {
 		return fmt.Errorf("unable to decouple ips for pod %s: %v", client.ObjectKeyFromObject(pod).String(), err)
 	}
 
 	r.Recorder.Event(pod, corev1.EventTypeNormal, ReasonIPReleaseSucceed, "pre decouple all IPs successfully")
+	r.recordAllocationEvent(pod, AllocationEventActionDecouple, networkName, ips, "", false)
+	return nil
+}
+
+// scaledDownToRelease checks whether the stateful pod is removed by scaling down of its StatefulSet
+// whose policy is to release ips of the removed ordinals
+func (r *PodReconciler) scaledDownToRelease(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil || ref.Kind != "StatefulSet" || ref.APIVersion != appsv1.SchemeGroupVersion.String() {
+		return false, nil
+	}
+
+	sts := &appsv1.StatefulSet{}
+	if err := r.APIReader.Get(ctx, apitypes.NamespacedName{Namespace: pod.Namespace, Name: ref.Name}, sts); err != nil {
+		// ips are kept reserved as before if StatefulSet is gone
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to get statefulset %s/%s: %v", pod.Namespace, ref.Name, err)
+	}
+	if sts.UID != ref.UID {
+		return false, nil
+	}
+
+	return utils.PodIsScaledDownToRelease(pod, sts), nil
+}
+
+// namespaceDeleting checks whether namespace is being deleted or gone, it is read from apiserver
+// directly to avoid acting on a stale cache, and deletion of namespace is never reverted once
+// it starts terminating
+func (r *PodReconciler) namespaceDeleting(ctx context.Context, name string) (bool, error) {
+	namespace := &corev1.Namespace{}
+	if err := r.APIReader.Get(ctx, apitypes.NamespacedName{Name: name}, namespace); err != nil {
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, fmt.Errorf("unable to get namespace %s: %v", name, err)
+	}
+	return !namespace.DeletionTimestamp.IsZero() && namespace.Status.Phase == corev1.NamespaceTerminating, nil
+}
+
+// releaseStateful will release IP instances of the stateful pod which will never be recreated,
+// e.g., removed by scaling down
+func (r *PodReconciler) releaseStateful(pod *corev1.Pod, cause string) (err error) {
+	var decoupleFunc func(pod *corev1.Pod) (err error)
+	if feature.DualStackEnabled() {
+		decoupleFunc = r.IPAMStore.DualStack().DeCouple
+	} else {
+		decoupleFunc = r.IPAMStore.DeCouple
+	}
+
+	networkName, ips := r.allocatedIPsForEvent(pod)
+	if err = decoupleFunc(pod); err != nil {
+		return fmt.Errorf("unable to release ips for %s pod: %v", cause, err)
+	}
+
+	r.Recorder.Eventf(pod, corev1.EventTypeNormal, ReasonIPReleaseSucceed, "release all IPs of %s pod successfully", cause)
+	r.recordAllocationEvent(pod, AllocationEventActionRelease, networkName, ips, "", false)
 	return nil
 }
 
@@ -162,277 +568,1144 @@ func (r *PodReconciler) reserve(pod *corev1.Pod) (err error) {
 		reserveFunc = r.IPAMStore.IPReserve
 	}
 
+	networkName, ips := r.allocatedIPsForEvent(pod)
 	if err = reserveFunc(pod); err != nil {
 		return fmt.Errorf("unable to reserve ips for pod: %v", err)
 	}
 
 	r.Recorder.Event(pod, corev1.EventTypeNormal, ReasonIPReserveSucceed, "reserve all IPs successfully")
+	r.recordAllocationEvent(pod, AllocationEventActionReserve, networkName, ips, "", false)
 	return nil
 }
 
+// ipFamilyOfPod returns the ip family of pod, which inherits from the default ip family of network
+// if it is not specified by pod
+func (r *PodReconciler) ipFamilyOfPod(pod *corev1.Pod, networkName string) types.IPFamilyMode {
+	var config = &utils.NetworkConfig{IPFamily: pod.Annotations[constants.AnnotationIPFamily]}
+	if len(config.IPFamily) > 0 {
+		return config.IPFamilyOf(nil)
+	}
+
+	// the global default is used if network is not found, which will fail the allocation later anyway
+	network, err := utils.GetNetwork(r, networkName)
+	if err != nil {
+		return config.IPFamilyOf(nil)
+	}
+	return config.IPFamilyOf(network)
+}
+
+// waitForNetworkResuming requeues pod on paused network, the events are throttled as allocation
+// failures since pod keeps being requeued until network resumes
+func (r *PodReconciler) waitForNetworkResuming(pod *corev1.Pod, networkName string) ctrl.Result {
+	if r.allowAllocationFailureEvent(pod) {
+		r.Recorder.Eventf(pod, corev1.EventTypeNormal, ReasonIPAllocationPaused,
+			"allocation is paused on network %s for maintenance, wait for resuming", networkName)
+	}
+	return ctrl.Result{RequeueAfter: networkPausedRequeueInterval}
+}
+
+// networkPaused checks whether allocation is paused on network
+func (r *PodReconciler) networkPaused(networkName string) (bool, error) {
+	network, err := utils.GetNetwork(r, networkName)
+	if err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return network.Spec.Paused, nil
+}
+
 // selectNetwork will pick the hit network by pod, taking the priority as below
 // 1. explicitly specify network in pod annotations/labels
-// 2. parse network type from pod and select a corresponding network binding on node
-func (r *PodReconciler) selectNetwork(pod *corev1.Pod) (string, error) {
-	var specifiedNetwork string
-	if specifiedNetwork = globalutils.PickFirstNonEmptyString(pod.Annotations[constants.AnnotationSpecifiedNetwork], pod.Labels[constants.LabelSpecifiedNetwork]); len(specifiedNetwork) > 0 {
-		return specifiedNetwork, nil
+// 2. parse network type from pod and select a corresponding network binding on node, the
+// network of the highest priority wins if node is bound to multiple networks
+func (r *PodReconciler) selectNetwork(ctx context.Context, pod *corev1.Pod) (string, error) {
+	networkName, ambiguous, err := selectNetworkForPod(ctx, r, r.IPAMManager, pod)
+	if ambiguous {
+		ctrllog.FromContext(ctx).Info("multiple underlay networks of the same priority match node, pick by name",
+			"node", pod.Spec.NodeName, "network", networkName)
+		r.Recorder.Eventf(pod, corev1.EventTypeWarning, ReasonNetworkAmbiguous,
+			"multiple underlay networks of the same priority match node %s, %s is picked by name, "+
+				"specify network on pod or set priority on networks to select explicitly", pod.Spec.NodeName, networkName)
+	}
+	return networkName, err
+}
+
+// selectNetworkForPod resolves the network of pod from its annotations, labels and node, ambiguous means
+// the underlay network is picked by name among multiple ones of the same priority
+func selectNetworkForPod(ctx context.Context, c client.Reader, ipamManager IPAMManager, pod *corev1.Pod) (networkName string, ambiguous bool, err error) {
+	// networking config is resolved in the same way as webhook, so that a pod without mutation
+	// gets the same network, but a specified subnet deleted since then is left to the specified
+	// subnet policy of network rather than failing the pod forever
+	networkConfig, err := utils.ResolveNetworkConfigOfObjectIgnoringMissingSubnets(ctx, c, pod)
+	if err != nil {
+		return "", false, err
+	}
+	if len(networkConfig.NetworkName) > 0 {
+		return networkConfig.NetworkName, false, nil
 	}
 
-	var networkType = types.ParseNetworkTypeFromString(globalutils.PickFirstNonEmptyString(pod.Annotations[constants.AnnotationNetworkType], pod.Labels[constants.LabelNetworkType]))
+	var networkType = networkConfig.NetworkTypeOf(nil)
 	switch networkType {
 	case types.Underlay:
 		// try to get underlay network by node indexer
 		var networkList *networkingv1.NetworkList
-		var err error
-		if networkList, err = utils.ListNetworks(r, client.MatchingFields{IndexerFieldNode: pod.Spec.NodeName}); err != nil {
-			return "", fmt.Errorf("unable to list underlay network by indexer node: %v", err)
+		if networkList, err = utils.ListNetworks(c, client.MatchingFields{IndexerFieldNode: pod.Spec.NodeName}); err != nil {
+			return "", false, fmt.Errorf("unable to list underlay network by indexer node: %v", err)
 		}
 		if len(networkList.Items) >= 1 {
-			return networkList.Items[0].GetName(), nil
+			network, ambiguous := networkingv1.PickNetworkByPriority(networkList.Items)
+			return network.GetName(), ambiguous, nil
 		}
 
 		// fall back to find underlay network by label selector
 		var underlayNetworkName string
-		if underlayNetworkName, err = utils.FindUnderlayNetworkForNodeName(r, pod.Spec.NodeName); err != nil {
-			return "", fmt.Errorf("unable to find underlay network for node %s", pod.Spec.NodeName)
+		if underlayNetworkName, err = utils.FindUnderlayNetworkForNodeName(c, pod.Spec.NodeName); err != nil {
+			return "", false, fmt.Errorf("unable to find underlay network for node %s", pod.Spec.NodeName)
 		}
 		if len(underlayNetworkName) == 0 {
-			return "", fmt.Errorf("no underlay network match node %s", pod.Spec.NodeName)
+			return "", false, fmt.Errorf("no underlay network match node %s", pod.Spec.NodeName)
 		}
-		if !r.matchNetworkTypeInManager(underlayNetworkName, types.Underlay) {
-			return "", fmt.Errorf("network %s does not match type %q in manager", underlayNetworkName, types.Underlay)
+		// the network may not be loaded into manager yet, e.g., just created or during startup,
+		// so it should be retried rather than treated as permanent
+		if !matchNetworkTypeInManager(ipamManager, underlayNetworkName, types.Underlay) {
+			return "", false, fmt.Errorf("network %s does not match type %q in manager", underlayNetworkName, types.Underlay)
 		}
-		return underlayNetworkName, nil
+		return underlayNetworkName, false, nil
 	case types.Overlay:
 		// try to get overlay network by special node name
 		var networkList *networkingv1.NetworkList
-		var err error
-		if networkList, err = utils.ListNetworks(r, client.MatchingFields{IndexerFieldNode: OverlayNodeName}); err != nil {
-			return "", fmt.Errorf("unable to list overlay network by indexer node: %v", err)
+		if networkList, err = utils.ListNetworks(c, client.MatchingFields{IndexerFieldNode: OverlayNodeName}); err != nil {
+			return "", false, fmt.Errorf("unable to list overlay network by indexer node: %v", err)
 		}
 		if len(networkList.Items) >= 1 {
-			return networkList.Items[0].GetName(), nil
+			return networkList.Items[0].GetName(), false, nil
 		}
 
 		// fall back to find overlay network in client cache
 		var overlayNetworkName string
-		if overlayNetworkName, err = utils.FindOverlayNetwork(r); err != nil {
-			return "", fmt.Errorf("unable to find overlay network")
+		if overlayNetworkName, err = utils.FindOverlayNetwork(c); err != nil {
+			return "", false, fmt.Errorf("unable to find overlay network")
 		}
 		if len(overlayNetworkName) == 0 {
-			return "", fmt.Errorf("no overlay network found")
+			return "", false, fmt.Errorf("no overlay network found")
 		}
-		if !r.matchNetworkTypeInManager(overlayNetworkName, types.Overlay) {
-			return "", fmt.Errorf("network %s does not match type %q in manager", overlayNetworkName, types.Overlay)
+		if !matchNetworkTypeInManager(ipamManager, overlayNetworkName, types.Overlay) {
+			return "", false, fmt.Errorf("network %s does not match type %q in manager", overlayNetworkName, types.Overlay)
 		}
-		return overlayNetworkName, nil
+		return overlayNetworkName, false, nil
 	default:
-		return "", fmt.Errorf("unknown network type %s from pod", networkType)
+		return "", false, newPermanentError("unknown network type %s from pod", networkType)
 	}
 }
 
-// matchNetworkTypeInManager will check the picked network from APIServer in manager on
-// existence and type
-// TODO: return error if non existing
-func (r *PodReconciler) matchNetworkTypeInManager(networkName string, networkType types.NetworkType) bool {
-	return (feature.DualStackEnabled() && r.IPAMManager.DualStack().MatchNetworkType(networkName, networkType)) ||
-		(!feature.DualStackEnabled() && r.IPAMManager.MatchNetworkType(networkName, networkType))
-}
+// checkSpecifiedSubnets checks whether the subnets specified by pod can be allocated from, an unavailable one
+// fails the allocation by default, or makes the specified subnets ignored if network falls back on it. The
+// warnings are throttled as allocation failures, since pods are requeued while the subnet is unavailable
+func (r *PodReconciler) checkSpecifiedSubnets(pod *corev1.Pod, networkName string, subnetNames []string) ([]string, error) {
+	for _, subnetName := range subnetNames {
+		reason, err := r.specifiedSubnetUnavailableReason(networkName, subnetName)
+		if err != nil {
+			return nil, err
+		}
+		if len(reason) == 0 {
+			continue
+		}
 
-func (r *PodReconciler) statefulAllocate(ctx context.Context, pod *corev1.Pod, networkName string) (err error) {
-	var (
-		preAssign     = len(pod.Annotations[constants.AnnotationIPPool]) > 0
-		shouldObserve = true
-		startTime     = time.Now()
-		// reallocate means that ip should not be retained
-		// 1. global retain and pod retain or unset, ip should be retained
-		// 2. global retain and pod not retain, ip should be reallocated
-		// 3. global not retain and pod not retain or unset, ip should be reallocated
-		// 4. global not retain and pod retain, ip should be retained
-		shouldReallocate = !globalutils.ParseBoolOrDefault(pod.Annotations[constants.AnnotationIPRetain], strategy.DefaultIPRetain)
-	)
+		network, err := utils.GetNetwork(r, networkName)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get network %s: %v", networkName, err)
+		}
 
-	defer func() {
-		if shouldObserve {
-			metrics.IPAllocationPeriodSummary.
-				WithLabelValues(metrics.IPStatefulAllocateType, strconv.FormatBool(err == nil)).
-				Observe(float64(time.Since(startTime).Nanoseconds()))
+		if network.Spec.SpecifiedSubnetPolicy == networkingv1.SpecifiedSubnetPolicyFallback {
+			if r.allowAllocationFailureEvent(pod) {
+				r.Recorder.Eventf(pod, corev1.EventTypeWarning, ReasonSpecifiedSubnetUnavailable,
+					"specified subnet %s %s, fall back to network %s", subnetName, reason, networkName)
+			}
+			return nil, nil
 		}
-	}()
 
-	if err = r.addFinalizer(ctx, pod); err != nil {
-		return wrapError("unable to add finalizer for stateful pod", err)
+		if r.allowAllocationFailureEvent(pod) {
+			r.Recorder.Eventf(pod, corev1.EventTypeWarning, ReasonSpecifiedSubnetUnavailable,
+				"specified subnet %s %s, set specifiedSubnetPolicy of network %s to Fallback to allocate from "+
+					"other subnets", subnetName, reason, networkName)
+		}
+		return nil, fmt.Errorf("specified subnet %s %s", subnetName, reason)
 	}
+	return subnetNames, nil
+}
 
-	if feature.DualStackEnabled() {
-		var ipCandidates []string
-		var ipFamilyMode = types.ParseIPFamilyFromString(pod.Annotations[constants.AnnotationIPFamily])
-
-		switch {
-		case preAssign:
-			ipPool := strings.Split(pod.Annotations[constants.AnnotationIPPool], ",")
-			if idx := utils.GetIndexFromName(pod.Name); idx < len(ipPool) {
-				ipCandidates = strings.Split(ipPool[idx], "/")
-				for i := range ipCandidates {
-					ipCandidates[i] = globalutils.NormalizedIP(ipCandidates[i])
-				}
-			} else {
-				err = fmt.Errorf("no available ip in ip-pool %s", pod.Annotations[constants.AnnotationIPPool])
-				return err
-			}
-		case shouldReallocate:
-			var allocatedIPs []*networkingv1.IPInstance
-			if allocatedIPs, err = utils.ListAllocatedIPInstancesOfPod(r, pod); err != nil {
-				return err
-			}
+// specifiedSubnetUnavailableReason returns why a specified subnet can not be allocated from, empty
+// means it is available
+func (r *PodReconciler) specifiedSubnetUnavailableReason(networkName, subnetName string) (string, error) {
+	subnet, err := utils.GetSubnet(r, subnetName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "is not found", nil
+		}
+		return "", fmt.Errorf("unable to get subnet %s: %v", subnetName, err)
+	}
 
-			// reallocate means that the allocated ones should be recycled firstly
-			if len(allocatedIPs) > 0 {
-				if err = r.release(ctx, pod, transform.TransferIPInstancesForIPAM(allocatedIPs)); err != nil {
-					return wrapError("unable to release before reallocate", err)
-				}
-			}
+	switch {
+	case subnet.Spec.Network != networkName:
+		return fmt.Sprintf("does not belong to network %s", networkName), nil
+	case !subnet.DeletionTimestamp.IsZero():
+		return "is being deleted", nil
+	}
 
-			// reallocate
-			return wrapError("unable to reallocate", r.allocate(ctx, pod, networkName))
-		default:
-			if ipCandidates, err = utils.ListIPsOfPod(r, pod); err != nil {
-				return err
-			}
+	usage, err := subnetUsageInManager(r.IPAMManager, networkName, subnetName)
+	if err != nil {
+		return "", fmt.Errorf("unable to get usage of subnet %s: %v", subnetName, err)
+	}
+	if usage == nil || usage.Available == 0 {
+		return "is exhausted", nil
+	}
+	return "", nil
+}
 
-			// when no valid ip found, it means that this is the first time of pod creation
-			if len(ipCandidates) == 0 {
-				// allocate has its own observation process, so just skip
-				shouldObserve = false
-				return wrapError("unable to allocate", r.allocate(ctx, pod, networkName))
-			}
-		}
+// selectSubnetsByNetIDRange will pick the first subnet which has available IPs and a net ID in range,
+// and for dual stack, a pair of IPv4/IPv6 subnets with the same net ID will be picked
+func (r *PodReconciler) selectSubnetsByNetIDRange(networkName string, ipFamily types.IPFamilyMode, netIDRangeStr string) ([]string, error) {
+	netIDRange, err := globalutils.ParseNetIDRange(netIDRangeStr)
+	if err != nil {
+		return nil, &PermanentError{Err: err}
+	}
 
-		// forced assign for using reserved ips
-		return wrapError("unable to multi-assign", r.multiAssign(ctx, pod, networkName, ipFamilyMode, ipCandidates, true))
+	network, err := utils.GetNetwork(r, networkName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get network %s: %v", networkName, err)
 	}
 
-	var ipCandidate string
+	subnetList, err := utils.ListSubnets(r, client.MatchingFields{IndexerFieldNetwork: networkName})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list subnets of network %s: %v", networkName, err)
+	}
 
-	switch {
-	case preAssign:
-		ipPool := strings.Split(pod.Annotations[constants.AnnotationIPPool], ",")
-		if idx := utils.GetIndexFromName(pod.Name); idx < len(ipPool) {
-			ipCandidate = globalutils.NormalizedIP(ipPool[idx])
-		}
-		if len(ipCandidate) == 0 {
-			err = fmt.Errorf("no available ip in ip-pool %s", pod.Annotations[constants.AnnotationIPPool])
-			return err
+	var (
+		matched                    bool
+		v4Candidates, v6Candidates []string
+		netIDs                     = map[string]int32{}
+		netIDOf                    = func(subnet *networkingv1.Subnet) *int32 {
+			// subnet inherits net ID from network if unset
+			if subnet.Spec.NetID != nil {
+				return subnet.Spec.NetID
+			}
+			return network.Spec.NetID
 		}
-	case shouldReallocate:
-		var allocatedIPs []*networkingv1.IPInstance
-		if allocatedIPs, err = utils.ListAllocatedIPInstancesOfPod(r, pod); err != nil {
-			return err
+	)
+	for i := range subnetList.Items {
+		var subnet = &subnetList.Items[i]
+		if !netIDRange.Contains(netIDOf(subnet)) {
+			continue
 		}
+		matched = true
 
-		// reallocate means that the allocated ones should be recycled firstly
-		if len(allocatedIPs) > 0 {
-			if err = r.release(ctx, pod, transform.TransferIPInstancesForIPAM(allocatedIPs)); err != nil {
-				return wrapError("unable to release before reallocate", err)
-			}
+		if networkingv1.IsPrivateSubnet(subnet) || !r.subnetHasAvailableIP(networkName, subnet.Name) {
+			continue
 		}
 
-		// reallocate
-		return wrapError("unable to reallocate", r.allocate(ctx, pod, networkName))
-	default:
-		ipCandidate, err = utils.GetIPOfPod(r, pod)
-		if err != nil {
-			return err
-		}
-		// when no valid ip found, it means that this is the first time of pod creation
-		if len(ipCandidate) == 0 {
-			// allocate has its own observation process, so just skip
-			shouldObserve = false
-			return wrapError("unable to allocate", r.allocate(ctx, pod, networkName))
+		netIDs[subnet.Name] = *netIDOf(subnet)
+		if networkingv1.IsIPv6Subnet(subnet) {
+			v6Candidates = append(v6Candidates, subnet.Name)
+		} else {
+			v4Candidates = append(v4Candidates, subnet.Name)
 		}
+	}
 
+	if !matched {
+		return nil, newPermanentError("no subnet of network %s has net ID in range %s", networkName, netIDRange)
 	}
 
-	// forced assign for using reserved ip
-	return wrapError("unable to assign", r.assign(ctx, pod, networkName, ipCandidate, true))
+	selected, err := pickSubnetsOfFamily(v4Candidates, v6Candidates, ipFamily, func(v4Subnet, v6Subnet string) bool {
+		return netIDs[v4Subnet] == netIDs[v6Subnet]
+	})
+	if err != nil || len(selected) > 0 {
+		return selected, err
+	}
+
+	return nil, fmt.Errorf("all %s subnets of network %s in net ID range %s are full", ipFamily, networkName, netIDRange)
 }
 
-// release will release IP instances of pod
-func (r *PodReconciler) release(ctx context.Context, pod *corev1.Pod, allocatedIPs []*types.IP) (err error) {
-	var recycleFunc func(namespace string, ip *types.IP) (err error)
-	if feature.DualStackEnabled() {
-		recycleFunc = r.IPAMStore.DualStack().IPRecycle
-	} else {
-		recycleFunc = r.IPAMStore.IPRecycle
+// selectSubnetsByTopology will pick the first subnet which has available IPs and a topology matching the
+// label of node where pod is scheduled, and for dual stack, a pair of IPv4/IPv6 subnets will be picked.
+// Matched subnets of higher topology weight are picked first, and if any topology of the network is
+// weighted, it is a soft preference which always falls back to any subnet.
+// Empty result means that allocation is not restricted by topology, the decision is used for event,
+// and local reports whether selected subnets are local to node, nil if topology is not involved.
+func (r *PodReconciler) selectSubnetsByTopology(pod *corev1.Pod, networkName string, ipFamily types.IPFamilyMode) (
+	selected []string, decision string, local *bool, err error) {
+	subnetList, err := utils.ListSubnets(r, client.MatchingFields{IndexerFieldNetwork: networkName})
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("unable to list subnets of network %s: %v", networkName, err)
 	}
 
-	for _, ip := range allocatedIPs {
-		if err = recycleFunc(pod.Namespace, ip); err != nil {
-			return fmt.Errorf("unable to recycle ip %v: %v", ip, err)
+	var (
+		topologySubnets []*networkingv1.Subnet
+		weighted        bool
+	)
+	for i := range subnetList.Items {
+		var subnet = &subnetList.Items[i]
+		if subnet.Spec.Config != nil && subnet.Spec.Config.Topology != nil {
+			topologySubnets = append(topologySubnets, subnet)
+			if _, ok := networkingv1.GetSubnetTopologyWeight(subnet); ok {
+				weighted = true
+			}
 		}
 	}
+	if len(topologySubnets) == 0 {
+		return nil, "", nil, nil
+	}
 
-	r.Recorder.Eventf(pod, corev1.EventTypeNormal, ReasonIPReleaseSucceed, "release IPs %v successfully", squashIPSliceToIPs(allocatedIPs))
-	return nil
-}
-
-// allocate will allocate new IPs for pod
+	node, err := utils.GetNode(r, pod.Spec.NodeName)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("unable to get node %s: %v", pod.Spec.NodeName, err)
+	}
+
+	// make selection stable if multiple subnets match the same topology
+	sort.Slice(topologySubnets, func(i, j int) bool {
+		weightI, _ := networkingv1.GetSubnetTopologyWeight(topologySubnets[i])
+		weightJ, _ := networkingv1.GetSubnetTopologyWeight(topologySubnets[j])
+		if weightI != weightJ {
+			return weightI > weightJ
+		}
+		return topologySubnets[i].Name < topologySubnets[j].Name
+	})
+
+	var (
+		matched                    bool
+		v4Candidates, v6Candidates []string
+	)
+	for _, subnet := range topologySubnets {
+		if !networkingv1.IsSubnetTopologyMatched(subnet, node.Labels) {
+			continue
+		}
+		matched = true
+
+		if networkingv1.IsPrivateSubnet(subnet) || !r.subnetHasAvailableIP(networkName, subnet.Name) {
+			continue
+		}
+
+		if networkingv1.IsIPv6Subnet(subnet) {
+			v6Candidates = append(v6Candidates, subnet.Name)
+		} else {
+			v4Candidates = append(v4Candidates, subnet.Name)
+		}
+	}
+
+	if selected, err = pickSubnetsOfFamily(v4Candidates, v6Candidates, ipFamily, nil); err != nil {
+		return nil, "", nil, err
+	}
+
+	if len(selected) > 0 {
+		return selected, fmt.Sprintf(", local subnets %v selected by topology of node %s", selected, node.Name), pointer.BoolPtr(true), nil
+	}
+
+	if !strategy.SubnetTopologyFallback && !weighted {
+		if !matched {
+			return nil, "", nil, newPermanentError("no subnet of network %s matches topology of node %s", networkName, node.Name)
+		}
+		return nil, "", nil, fmt.Errorf("all %s subnets of network %s matching topology of node %s are full", ipFamily, networkName, node.Name)
+	}
+
+	if !matched {
+		return nil, fmt.Sprintf(", no subnet matches topology of node %s, fall back to any non-local subnet", node.Name), pointer.BoolPtr(false), nil
+	}
+	return nil, fmt.Sprintf(", all %s subnets matching topology of node %s are full, fall back to any non-local subnet", ipFamily, node.Name), pointer.BoolPtr(false), nil
+}
+
+// pickSubnetsOfFamily picks the first candidate subnet for each family of ip family, and for dual stack, the
+// first pair of IPv4/IPv6 candidates accepted by paired, any pair is accepted if paired is nil.
+// Empty result means that candidates are not enough for ip family.
+func pickSubnetsOfFamily(v4Candidates, v6Candidates []string, ipFamily types.IPFamilyMode,
+	paired func(v4Subnet, v6Subnet string) bool) ([]string, error) {
+	switch ipFamily {
+	case types.IPv4Only:
+		if len(v4Candidates) > 0 {
+			return []string{v4Candidates[0]}, nil
+		}
+	case types.IPv6Only:
+		if len(v6Candidates) > 0 {
+			return []string{v6Candidates[0]}, nil
+		}
+	case types.DualStack:
+		for _, v4Subnet := range v4Candidates {
+			for _, v6Subnet := range v6Candidates {
+				if paired == nil || paired(v4Subnet, v6Subnet) {
+					return []string{v4Subnet, v6Subnet}, nil
+				}
+			}
+		}
+	default:
+		return nil, newPermanentError("unsupported ip family %s", ipFamily)
+	}
+	return nil, nil
+}
+
+// popSubnetRebalanceHint returns the subnet hinted by rebalancer for pod, it is ignored if pod
+// is dual-stack or the subnet has no available ip now
+func (r *PodReconciler) popSubnetRebalanceHint(pod *corev1.Pod, networkName string, ipFamily types.IPFamilyMode) string {
+	if ipFamily == types.DualStack {
+		return ""
+	}
+	if subnetName := r.SubnetRebalanceHints.Pop(pod); len(subnetName) > 0 && r.subnetHasAvailableIP(networkName, subnetName) {
+		return subnetName
+	}
+	return ""
+}
+
+func (r *PodReconciler) subnetHasAvailableIP(networkName, subnetName string) bool {
+	var (
+		usage *types.Usage
+		err   error
+	)
+	if feature.DualStackEnabled() {
+		usage, err = r.IPAMManager.DualStack().SubnetUsage(networkName, subnetName)
+	} else {
+		usage, err = r.IPAMManager.SubnetUsage(networkName, subnetName)
+	}
+	return err == nil && usage.Available > 0
+}
+
+// selectSubnetsByAntiAffinity will pick the first subnet which has available IPs and is not occupied by
+// any of the workloads in anti-affinity annotation of pod, for each ip family.
+// Selection is best-effort, empty result with a decision means that no subnet satisfies the anti-affinity
+// and allocation falls back to any subnet.
+func (r *PodReconciler) selectSubnetsByAntiAffinity(pod *corev1.Pod, networkName string, ipFamily types.IPFamilyMode, workloadsStr string) ([]string, string, error) {
+	workloads, err := globalutils.ParseWorkloadReferences(workloadsStr)
+	if err != nil {
+		return nil, "", newPermanentError("invalid subnet anti-affinity: %v", err)
+	}
+	if len(workloads) == 0 {
+		return nil, "", nil
+	}
+
+	occupiedSubnets := map[string]bool{}
+	for _, workload := range workloads {
+		ipList, err := utils.ListIPInstances(r, client.InNamespace(pod.Namespace), client.MatchingLabels{
+			constants.LabelOwnerKind: workload.Kind,
+			constants.LabelOwnerName: workload.Name,
+			constants.LabelNetwork:   networkName,
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to list ip instances of workload %s: %v", workload.String(), err)
+		}
+
+		for i := range ipList.Items {
+			var ipInstance = &ipList.Items[i]
+			// ips of pod itself and the ones being released do not occupy subnets
+			if !ipInstance.DeletionTimestamp.IsZero() || ipInstance.Labels[constants.LabelPod] == pod.Name {
+				continue
+			}
+			occupiedSubnets[ipInstance.Spec.Subnet] = true
+		}
+	}
+
+	subnetList, err := utils.ListSubnets(r, client.MatchingFields{IndexerFieldNetwork: networkName})
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to list subnets of network %s: %v", networkName, err)
+	}
+
+	// make selection stable if multiple subnets are not occupied
+	sort.Slice(subnetList.Items, func(i, j int) bool {
+		return subnetList.Items[i].Name < subnetList.Items[j].Name
+	})
+
+	var v4Candidates, v6Candidates []string
+	for i := range subnetList.Items {
+		var subnet = &subnetList.Items[i]
+		if occupiedSubnets[subnet.Name] || networkingv1.IsPrivateSubnet(subnet) || !r.subnetHasAvailableIP(networkName, subnet.Name) {
+			continue
+		}
+
+		if networkingv1.IsIPv6Subnet(subnet) {
+			v6Candidates = append(v6Candidates, subnet.Name)
+		} else {
+			v4Candidates = append(v4Candidates, subnet.Name)
+		}
+	}
+
+	selected, err := pickSubnetsOfFamily(v4Candidates, v6Candidates, ipFamily, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(selected) > 0 {
+		return selected, fmt.Sprintf(", subnets %v selected by anti-affinity to workloads %v", selected, workloads), nil
+	}
+
+	r.Recorder.Eventf(pod, corev1.EventTypeWarning, ReasonSubnetAntiAffinityUnsatisfied,
+		"no available %s subnet of network %s is free of workloads %v, fall back to any subnet", ipFamily, networkName, workloads)
+	return nil, fmt.Sprintf(", anti-affinity to workloads %v is unsatisfied, fall back to any subnet", workloads), nil
+}
+
+// selectSubnetsByAffinity will pick the subnets of the related pod in affinity annotation of pod, for each
+// ip family. If a label selector is used, the first pod by name which has ips in network is related.
+// Selection is best-effort, empty result with a decision means that the subnets of related pod are unknown
+// or have no available IPs, and allocation falls back to any subnet.
+func (r *PodReconciler) selectSubnetsByAffinity(pod *corev1.Pod, networkName string, ipFamily types.IPFamilyMode, affinityStr string) ([]string, string, error) {
+	affinity, err := globalutils.ParseSubnetAffinity(affinityStr)
+	if err != nil {
+		return nil, "", newPermanentError("invalid subnet affinity: %v", err)
+	}
+
+	var podNames []string
+	if affinity.Selector == nil {
+		podNames = []string{affinity.PodName}
+	} else {
+		podList := &corev1.PodList{}
+		if err = r.List(context.TODO(), podList, client.InNamespace(pod.Namespace),
+			client.MatchingLabelsSelector{Selector: affinity.Selector}); err != nil {
+			return nil, "", fmt.Errorf("unable to list pods of subnet affinity %s: %v", affinity.String(), err)
+		}
+		for i := range podList.Items {
+			podNames = append(podNames, podList.Items[i].Name)
+		}
+		// make selection stable if multiple pods are related
+		sort.Strings(podNames)
+	}
+
+	var v4Subnet, v6Subnet, relatedPod string
+	for _, podName := range podNames {
+		if podName == pod.Name {
+			continue
+		}
+
+		ipList, err := utils.ListIPInstances(r, client.InNamespace(pod.Namespace), client.MatchingLabels{
+			constants.LabelPod:     podName,
+			constants.LabelNetwork: networkName,
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to list ip instances of pod %s: %v", podName, err)
+		}
+
+		for i := range ipList.Items {
+			var ipInstance = &ipList.Items[i]
+			// only the ips in use reflect the current allocation of related pod
+			if !ipInstance.DeletionTimestamp.IsZero() || !networkingv1.IsUsingPhase(ipInstance.Status.Phase) {
+				continue
+			}
+			if networkingv1.IsIPv6IPInstance(ipInstance) {
+				v6Subnet = ipInstance.Spec.Subnet
+			} else {
+				v4Subnet = ipInstance.Spec.Subnet
+			}
+			relatedPod = podName
+		}
+
+		if len(relatedPod) > 0 {
+			break
+		}
+	}
+
+	var wanted []string
+	switch ipFamily {
+	case types.IPv4Only:
+		wanted = []string{v4Subnet}
+	case types.IPv6Only:
+		wanted = []string{v6Subnet}
+	case types.DualStack:
+		wanted = []string{v4Subnet, v6Subnet}
+	default:
+		return nil, "", newPermanentError("unsupported ip family %s", ipFamily)
+	}
+
+	var unsatisfied string
+	for _, subnetName := range wanted {
+		if len(subnetName) == 0 {
+			unsatisfied = fmt.Sprintf("no %s subnet of network %s is used by %s", ipFamily, networkName, affinity.String())
+			break
+		}
+		if !r.subnetHasAvailableIP(networkName, subnetName) {
+			unsatisfied = fmt.Sprintf("subnet %s of related pod %s has no available ip", subnetName, relatedPod)
+			break
+		}
+	}
+
+	if len(unsatisfied) == 0 {
+		return wanted, fmt.Sprintf(", subnets %v selected by affinity to pod %s", wanted, relatedPod), nil
+	}
+
+	r.Recorder.Eventf(pod, corev1.EventTypeWarning, ReasonSubnetAffinityUnsatisfied, "%s, fall back to any subnet", unsatisfied)
+	return nil, fmt.Sprintf(", affinity to %s is unsatisfied, fall back to any subnet", affinity.String()), nil
+}
+
+// matchNetworkTypeInManager will check the picked network from APIServer in manager on
+// existence and type
+// TODO: return error if non existing
+func matchNetworkTypeInManager(ipamManager IPAMManager, networkName string, networkType types.NetworkType) bool {
+	return (feature.DualStackEnabled() && ipamManager.DualStack().MatchNetworkType(networkName, networkType)) ||
+		(!feature.DualStackEnabled() && ipamManager.MatchNetworkType(networkName, networkType))
+}
+
+func (r *PodReconciler) statefulAllocate(ctx context.Context, pod *corev1.Pod, networkName string) (allocatePath string, err error) {
+	var (
+		preAssign = len(pod.Annotations[constants.AnnotationIPPool]) > 0
+		startTime = time.Now()
+		// reallocate means that ip should not be retained
+		// 1. global retain and pod retain or unset, ip should be retained
+		// 2. global retain and pod not retain, ip should be reallocated
+		// 3. global not retain and pod not retain or unset, ip should be reallocated
+		// 4. global not retain and pod retain, ip should be retained
+		shouldReallocate = !globalutils.ParseBoolOrDefault(pod.Annotations[constants.AnnotationIPRetain], strategy.DefaultIPRetain)
+	)
+
+	// every terminal path will override the allocate path for observation,
+	// failures before any path is taken are observed as unknown
+	allocatePath = metrics.IPUnknownAllocatePath
+
+	if r.StatefulAllocateTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.StatefulAllocateTimeout)
+		defer cancel()
+	}
+
+	defer func() {
+		observeIPAllocation(metrics.IPStatefulAllocateType, allocatePath, startTime, err)
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			metrics.IPAllocationTimeoutCounter.WithLabelValues(allocatePath).Inc()
+		}
+	}()
+
+	if err = r.addFinalizer(ctx, pod); err != nil {
+		return allocatePath, wrapError("unable to add finalizer for stateful pod", err)
+	}
+
+	if err = checkAllocationDeadline(ctx, "looking up ips"); err != nil {
+		return allocatePath, err
+	}
+
+	// per-family ip pool pins ips of each family independently, families not pinned
+	// will reuse retained ips or be allocated dynamically
+	if preAssign && globalutils.IsPerFamilyIPPool(pod.Annotations[constants.AnnotationIPPool]) {
+		allocatePath = metrics.IPReassignAllocatePath
+		return allocatePath, wrapError("unable to assign from per-family ip pool", r.perFamilyAssign(ctx, pod, networkName, shouldReallocate))
+	}
+
+	if feature.DualStackEnabled() {
+		var ipCandidates []string
+		var ipFamilyMode = r.ipFamilyOfPod(pod, networkName)
+
+		switch {
+		case preAssign:
+			allocatePath = metrics.IPReassignAllocatePath
+			ipPool := strings.Split(pod.Annotations[constants.AnnotationIPPool], ",")
+			if idx := utils.GetIndexFromName(pod.Name); idx < len(ipPool) {
+				ipCandidates = strings.Split(ipPool[idx], "/")
+				// ips of ipam are keyed in canonical form, which ip pool may not be written in
+				for i := range ipCandidates {
+					ipCandidates[i] = globalutils.CanonicalIP(ipCandidates[i])
+				}
+			} else {
+				err = newPermanentError("no available ip in ip-pool %s", pod.Annotations[constants.AnnotationIPPool])
+				return allocatePath, err
+			}
+		case shouldReallocate:
+			allocatePath = metrics.IPReallocateAllocatePath
+			// reallocate means that the allocated ones should be recycled firstly
+			return allocatePath, r.reallocateStateful(ctx, pod, networkName)
+		default:
+			if ipCandidates, err = utils.ListIPsOfPod(r, pod); err != nil {
+				return allocatePath, err
+			}
+
+			// when no valid ip found, it means that this is the first time of pod creation
+			if len(ipCandidates) == 0 {
+				allocatePath = metrics.IPAllocateAllocatePath
+				return allocatePath, wrapError("unable to allocate", r.allocateStateful(ctx, pod, networkName))
+			}
+
+			// ip family of pod might be changed since last incarnation, e.g., from ipv4-only to
+			// dual-stack, retained ips can not be reused then
+			if familyErr := globalutils.ValidateIPFamilies(ipCandidates, ipFamilyMode != types.IPv6Only,
+				ipFamilyMode != types.IPv4Only); familyErr != nil {
+				allocatePath = metrics.IPReallocateAllocatePath
+				r.Recorder.Eventf(pod, corev1.EventTypeWarning, ReasonIPFamilyMismatch,
+					"retained IPs mismatch ip family %s, reallocate: %v", ipFamilyMode, familyErr)
+				return allocatePath, wrapError("unable to reallocate for ip family", r.reallocateStateful(ctx, pod, networkName))
+			}
+			allocatePath = metrics.IPReuseAllocatePath
+		}
+
+		if err = checkAllocationDeadline(ctx, "assigning"); err != nil {
+			return allocatePath, err
+		}
+
+		// forced assign for using reserved ips
+		return allocatePath, wrapError("unable to multi-assign", r.multiAssign(ctx, pod, networkName, ipFamilyMode, ipCandidates, true))
+	}
+
+	var ipCandidate string
+
+	switch {
+	case preAssign:
+		allocatePath = metrics.IPReassignAllocatePath
+		ipPool := strings.Split(pod.Annotations[constants.AnnotationIPPool], ",")
+		if idx := utils.GetIndexFromName(pod.Name); idx < len(ipPool) {
+			ipCandidate = globalutils.CanonicalIP(ipPool[idx])
+		}
+		if len(ipCandidate) == 0 {
+			err = newPermanentError("no available ip in ip-pool %s", pod.Annotations[constants.AnnotationIPPool])
+			return allocatePath, err
+		}
+	case shouldReallocate:
+		allocatePath = metrics.IPReallocateAllocatePath
+		// reallocate means that the allocated ones should be recycled firstly
+		return allocatePath, r.reallocateStateful(ctx, pod, networkName)
+	default:
+		ipCandidate, err = utils.GetIPOfPod(r, pod)
+		if err != nil {
+			return allocatePath, err
+		}
+		// when no valid ip found, it means that this is the first time of pod creation
+		if len(ipCandidate) == 0 {
+			allocatePath = metrics.IPAllocateAllocatePath
+			return allocatePath, wrapError("unable to allocate", r.allocateStateful(ctx, pod, networkName))
+		}
+		allocatePath = metrics.IPReuseAllocatePath
+	}
+
+	if err = checkAllocationDeadline(ctx, "assigning"); err != nil {
+		return allocatePath, err
+	}
+
+	// forced assign for using reserved ip
+	return allocatePath, wrapError("unable to assign", r.assign(ctx, pod, networkName, ipCandidate, true))
+}
+
+// perFamilyAssign will assign IPs picked from per-family ip pool by pod ordinal, IPs of the
+// families not covered by ip pool are retained ones or dynamically allocated
+func (r *PodReconciler) perFamilyAssign(ctx context.Context, pod *corev1.Pod, networkName string, shouldReallocate bool) (err error) {
+	ipPool, err := globalutils.ParsePerFamilyIPPool(pod.Annotations[constants.AnnotationIPPool])
+	if err != nil {
+		return newPermanentError("invalid ip pool %s: %v", pod.Annotations[constants.AnnotationIPPool], err)
+	}
+	v4IP, v6IP := ipPool.Pick(utils.GetIndexFromName(pod.Name))
+
+	if shouldReallocate {
+		// pinned ips will be assigned again, only the others are truly reallocated
+		var allocatedIPs []*networkingv1.IPInstance
+		if allocatedIPs, err = utils.ListAllocatedIPInstancesOfPod(r, pod); err != nil {
+			return err
+		}
+		if len(allocatedIPs) > 0 {
+			if err = r.release(ctx, pod, transform.TransferIPInstancesForIPAM(allocatedIPs)); err != nil {
+				return wrapError("unable to release before reallocate", err)
+			}
+		}
+	} else if len(v4IP) == 0 || len(v6IP) == 0 {
+		var retainedIPs []string
+		if retainedIPs, err = utils.ListIPsOfPod(r, pod); err != nil {
+			return err
+		}
+		for _, retainedIP := range retainedIPs {
+			switch isIPv6 := net.ParseIP(retainedIP).To4() == nil; {
+			case isIPv6 && len(v6IP) == 0:
+				v6IP = retainedIP
+			case !isIPv6 && len(v4IP) == 0:
+				v4IP = retainedIP
+			}
+		}
+	}
+
+	if !feature.DualStackEnabled() {
+		if len(v4IP) > 0 {
+			return r.assign(ctx, pod, networkName, v4IP, true)
+		}
+		return r.doAllocate(ctx, pod, networkName)
+	}
+
+	switch ipFamily := r.ipFamilyOfPod(pod, networkName); {
+	case ipFamily == types.IPv4Only && len(v4IP) > 0:
+		return r.multiAssign(ctx, pod, networkName, ipFamily, []string{v4IP}, true)
+	case ipFamily == types.IPv6Only && len(v6IP) > 0:
+		return r.multiAssign(ctx, pod, networkName, ipFamily, []string{v6IP}, true)
+	case ipFamily == types.DualStack && len(v4IP) > 0 && len(v6IP) > 0:
+		return r.multiAssign(ctx, pod, networkName, ipFamily, []string{v4IP, v6IP}, true)
+	case ipFamily == types.DualStack && len(v4IP) > 0:
+		return r.assignAndAllocate(ctx, pod, networkName, types.IPv4Only, v4IP)
+	case ipFamily == types.DualStack && len(v6IP) > 0:
+		return r.assignAndAllocate(ctx, pod, networkName, types.IPv6Only, v6IP)
+	default:
+		return r.doAllocate(ctx, pod, networkName)
+	}
+}
+
+// assignAndAllocate will assign IP of one family and allocate IP of the other family for
+// dual-stack pod, both IPs are coupled with pod together
+func (r *PodReconciler) assignAndAllocate(ctx context.Context, pod *corev1.Pod, networkName string, assignFamily types.IPFamilyMode,
+	ipCandidate string) (err error) {
+	var (
+		allocateFamily = types.IPv6Only
+		subnetNames    []string
+		assignedIPs    []*types.IP
+		allocatedIPs   []*types.IP
+	)
+	if assignFamily == types.IPv6Only {
+		allocateFamily = types.IPv4Only
+	}
+
+	// specified subnets of dual-stack pod are in order of ipv4/ipv6
+	if subnetNameStr := globalutils.PickFirstNonEmptyString(pod.Annotations[constants.AnnotationSpecifiedSubnet], pod.Labels[constants.LabelSpecifiedSubnet]); len(subnetNameStr) > 0 {
+		if specifiedSubnets := strings.Split(subnetNameStr, "/"); len(specifiedSubnets) == 2 {
+			if allocateFamily == types.IPv4Only {
+				subnetNames = specifiedSubnets[:1]
+			} else {
+				subnetNames = specifiedSubnets[1:]
+			}
+		}
+	}
+
+	if assignedIPs, err = r.IPAMManager.DualStack().Assign(assignFamily, networkName, nil, []string{ipCandidate}, pod.Name, pod.Namespace, true); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = r.IPAMManager.DualStack().Release(assignFamily, networkName, squashIPSliceToSubnets(assignedIPs), squashIPSliceToIPs(assignedIPs))
+		}
+	}()
+
+	if allocatedIPs, err = r.IPAMManager.DualStack().Allocate(allocateFamily, networkName, subnetNames, pod.Name, pod.Namespace); err != nil {
+		return fmt.Errorf("unable to allocate %s ip: %w", allocateFamily, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = r.IPAMManager.DualStack().Release(allocateFamily, networkName, squashIPSliceToSubnets(allocatedIPs), squashIPSliceToIPs(allocatedIPs))
+		}
+	}()
+
+	IPs := append(assignedIPs, allocatedIPs...)
+	if err = r.IPAMStore.DualStack().ReCouple(pod, IPs); err != nil {
+		return fmt.Errorf("fail to force-couple ips %+v with pod: %v", IPs, err)
+	}
+
+	r.Recorder.Eventf(pod, corev1.EventTypeNormal, ReasonIPAllocationSucceed, "assign IP %v and allocate IP %v successfully",
+		squashIPSliceToIPs(assignedIPs), squashIPSliceToIPs(allocatedIPs))
+	r.recordAllocationEvent(pod, AllocationEventActionAssign, networkName, IPs, "", true)
+	return nil
+}
+
+// allocateStateful will allocate new IPs for stateful pod, the ordinal IPs computed from
+// stateful base IPs of subnets are preferred, observation should be done by callers
+func (r *PodReconciler) allocateStateful(ctx context.Context, pod *corev1.Pod, networkName string) (err error) {
+	var ipFamilyMode = r.ipFamilyOfPod(pod, networkName)
+
+	ordinalIPs, err := r.selectOrdinalIPs(pod, networkName, ipFamilyMode)
+	if err != nil {
+		return wrapError("unable to select ordinal ips", err)
+	}
+
+	switch {
+	case len(ordinalIPs) == 0:
+		return r.doAllocate(ctx, pod, networkName)
+	case feature.DualStackEnabled():
+		return r.multiAssign(ctx, pod, networkName, ipFamilyMode, ordinalIPs, false)
+	default:
+		return r.assign(ctx, pod, networkName, ordinalIPs[0], false)
+	}
+}
+
+// selectOrdinalIPs computes deterministic IPs for stateful pod as stateful base IP + pod ordinal,
+// empty result means that stateful base IPs are not configured on subnets for the ip family
+func (r *PodReconciler) selectOrdinalIPs(pod *corev1.Pod, networkName string, ipFamily types.IPFamilyMode) ([]string, error) {
+	idx := utils.GetIndexFromName(pod.Name)
+	if idx == math.MaxInt32 {
+		return nil, nil
+	}
+
+	subnetList, err := utils.ListSubnets(r, client.MatchingFields{IndexerFieldNetwork: networkName})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list subnets of network %s: %v", networkName, err)
+	}
+
+	// make selection stable if multiple subnets are configured with stateful base ip
+	sort.Slice(subnetList.Items, func(i, j int) bool {
+		return subnetList.Items[i].Name < subnetList.Items[j].Name
+	})
+
+	var (
+		v4IP, v6IP       string
+		specifiedSubnets map[string]struct{}
+	)
+	if subnetNameStr := globalutils.PickFirstNonEmptyString(pod.Annotations[constants.AnnotationSpecifiedSubnet], pod.Labels[constants.LabelSpecifiedSubnet]); len(subnetNameStr) > 0 {
+		specifiedSubnets = globalutils.StringSliceToMap(strings.Split(subnetNameStr, "/"))
+	}
+
+	for i := range subnetList.Items {
+		var subnet = &subnetList.Items[i]
+		if subnet.Spec.Config == nil || len(subnet.Spec.Config.StatefulBaseIP) == 0 {
+			continue
+		}
+		if _, specified := specifiedSubnets[subnet.Name]; specifiedSubnets != nil && !specified {
+			continue
+		}
+
+		ordinalIP := globalutils.AddIPOffset(net.ParseIP(subnet.Spec.Config.StatefulBaseIP), idx)
+		if _, cidr, _ := net.ParseCIDR(subnet.Spec.Range.CIDR); ordinalIP == nil || cidr == nil || !cidr.Contains(ordinalIP) {
+			return nil, newPermanentError("ordinal ip of pod %s exceeds subnet %s from stateful base ip %s",
+				pod.Name, subnet.Name, subnet.Spec.Config.StatefulBaseIP)
+		}
+
+		switch {
+		case networkingv1.IsIPv6Subnet(subnet) && len(v6IP) == 0:
+			v6IP = ordinalIP.String()
+		case !networkingv1.IsIPv6Subnet(subnet) && len(v4IP) == 0:
+			v4IP = ordinalIP.String()
+		}
+	}
+
+	switch {
+	case ipFamily == types.IPv4Only && len(v4IP) > 0:
+		return []string{v4IP}, nil
+	case ipFamily == types.IPv6Only && len(v6IP) > 0:
+		return []string{v6IP}, nil
+	case ipFamily == types.DualStack && len(v4IP) > 0 && len(v6IP) > 0:
+		return []string{v4IP, v6IP}, nil
+	}
+	return nil, nil
+}
+
+// release will release IP instances of pod
+func (r *PodReconciler) release(ctx context.Context, pod *corev1.Pod, allocatedIPs []*types.IP) (err error) {
+	var recycleFunc func(namespace string, ip *types.IP) (err error)
+	if feature.DualStackEnabled() {
+		recycleFunc = r.IPAMStore.DualStack().IPRecycle
+	} else {
+		recycleFunc = r.IPAMStore.IPRecycle
+	}
+
+	for _, ip := range allocatedIPs {
+		// the released ones will not be listed again by the next try
+		if err = checkAllocationDeadline(ctx, "releasing"); err != nil {
+			return err
+		}
+		if err = recycleFunc(pod.Namespace, ip); err != nil {
+			return fmt.Errorf("unable to recycle ip %v: %v", ip, err)
+		}
+	}
+
+	r.Recorder.Eventf(pod, corev1.EventTypeNormal, ReasonIPReleaseSucceed, "release IPs %v successfully", squashIPSliceToIPs(allocatedIPs))
+	r.recordAllocationEvent(pod, AllocationEventActionRelease, "", allocatedIPs, "", false)
+	return nil
+}
+
+// allocate will allocate new IPs for pod with observation
 func (r *PodReconciler) allocate(ctx context.Context, pod *corev1.Pod, networkName string) (err error) {
 	var startTime = time.Now()
 	defer func() {
-		metrics.IPAllocationPeriodSummary.
-			WithLabelValues(metrics.IPNormalAllocateType, strconv.FormatBool(err == nil)).
-			Observe(float64(time.Since(startTime).Nanoseconds()))
+		observeIPAllocation(metrics.IPNormalAllocateType, metrics.IPAllocateAllocatePath, startTime, err)
 	}()
 
+	return r.doAllocate(ctx, pod, networkName)
+}
+
+// reallocateStateful will release the retained IPs of stateful pod and allocate new ones
+func (r *PodReconciler) reallocateStateful(ctx context.Context, pod *corev1.Pod, networkName string) (err error) {
+	var allocatedIPs []*networkingv1.IPInstance
+	if allocatedIPs, err = utils.ListAllocatedIPInstancesOfPod(r, pod); err != nil {
+		return err
+	}
+
+	if len(allocatedIPs) > 0 {
+		if err = r.release(ctx, pod, transform.TransferIPInstancesForIPAM(allocatedIPs)); err != nil {
+			return wrapError("unable to release before reallocate", err)
+		}
+	}
+
+	if err = checkAllocationDeadline(ctx, "reallocating"); err != nil {
+		return err
+	}
+
+	return wrapError("unable to reallocate", r.allocateStateful(ctx, pod, networkName))
+}
+
+// selectSubnets picks the subnets to allocate from for pod, in order of specified subnets, net ID
+// range, rebalance hint, topology, affinity and anti-affinity. Empty result means allocating from
+// any subnet of network, and decision describes how subnets are selected for events
+func (r *PodReconciler) selectSubnets(pod *corev1.Pod, networkName string, ipFamily types.IPFamilyMode) (subnetNames []string, decision string, local *bool, err error) {
+	if subnetNameStr := globalutils.PickFirstNonEmptyString(pod.Annotations[constants.AnnotationSpecifiedSubnet], pod.Labels[constants.LabelSpecifiedSubnet]); len(subnetNameStr) > 0 {
+		// subnets of ip families are specified in format of "v4/v6" only on dual stack mode
+		specifiedSubnets := []string{subnetNameStr}
+		if feature.DualStackEnabled() {
+			specifiedSubnets = strings.Split(subnetNameStr, "/")
+		}
+		if subnetNames, err = r.checkSpecifiedSubnets(pod, networkName, specifiedSubnets); err != nil {
+			return nil, "", nil, wrapError("unable to use specified subnets", err)
+		}
+		return subnetNames, "", nil, nil
+	}
+
+	if netIDRangeStr := pod.Annotations[constants.AnnotationSpecifiedNetIDRange]; len(netIDRangeStr) > 0 {
+		if subnetNames, err = r.selectSubnetsByNetIDRange(networkName, ipFamily, netIDRangeStr); err != nil {
+			return nil, "", nil, wrapError("unable to select subnets by net ID range", err)
+		}
+		return subnetNames, "", nil, nil
+	}
+
+	if hintedSubnet := r.popSubnetRebalanceHint(pod, networkName, ipFamily); len(hintedSubnet) > 0 {
+		return []string{hintedSubnet}, fmt.Sprintf(", subnet %s selected by rebalancing", hintedSubnet), nil, nil
+	}
+
+	if subnetNames, decision, local, err = r.selectSubnetsByTopology(pod, networkName, ipFamily); err != nil {
+		return nil, "", nil, wrapError("unable to select subnets by topology", err)
+	}
+	if len(subnetNames) > 0 {
+		return subnetNames, decision, local, nil
+	}
+
+	var annotationDecision string
+	if affinityStr := pod.Annotations[constants.AnnotationSubnetAffinity]; len(affinityStr) > 0 {
+		if subnetNames, annotationDecision, err = r.selectSubnetsByAffinity(pod, networkName, ipFamily, affinityStr); err != nil {
+			return nil, "", nil, wrapError("unable to select subnets by affinity", err)
+		}
+	} else if antiAffinityStr := pod.Annotations[constants.AnnotationSubnetAntiAffinity]; len(antiAffinityStr) > 0 {
+		if subnetNames, annotationDecision, err = r.selectSubnetsByAntiAffinity(pod, networkName, ipFamily, antiAffinityStr); err != nil {
+			return nil, "", nil, wrapError("unable to select subnets by anti-affinity", err)
+		}
+	}
+	return subnetNames, decision + annotationDecision, local, nil
+}
+
+// doAllocate will allocate new IPs for pod, observation should be done by callers
+func (r *PodReconciler) doAllocate(ctx context.Context, pod *corev1.Pod, networkName string) (err error) {
 	if feature.DualStackEnabled() {
 		var (
 			subnetNames  []string
+			decision     string
+			local        *bool
 			ips          []*types.IP
-			ipFamilyMode = types.ParseIPFamilyFromString(pod.Annotations[constants.AnnotationIPFamily])
+			ipFamilyMode = r.ipFamilyOfPod(pod, networkName)
 		)
-		if subnetNameStr := globalutils.PickFirstNonEmptyString(pod.Annotations[constants.AnnotationSpecifiedSubnet], pod.Labels[constants.LabelSpecifiedSubnet]); len(subnetNameStr) > 0 {
-			subnetNames = strings.Split(subnetNameStr, "/")
-		}
-		if ips, err = r.IPAMManager.DualStack().Allocate(ipFamilyMode, networkName, subnetNames, pod.Name, pod.Namespace); err != nil {
-			return fmt.Errorf("unable to allocate %s ip: %v", ipFamilyMode, err)
+		if subnetNames, decision, local, err = r.selectSubnets(pod, networkName, ipFamilyMode); err != nil {
+			return err
 		}
+		// ips rejected by allocation hook are held until allocation ends, so that
+		// they will not be allocated again in the following attempts
+		var rejectedIPs []*types.IP
 		defer func() {
-			if err != nil {
-				_ = r.IPAMManager.DualStack().Release(ipFamilyMode, networkName, squashIPSliceToSubnets(ips), squashIPSliceToIPs(ips))
+			if len(rejectedIPs) > 0 {
+				_ = r.IPAMManager.DualStack().Release(ipFamilyMode, networkName, squashIPSliceToSubnets(rejectedIPs), squashIPSliceToIPs(rejectedIPs))
 			}
 		}()
 
-		if err = r.IPAMStore.DualStack().Couple(pod, ips); err != nil {
-			return fmt.Errorf("unable to couple IPs with pod: %v", err)
+		for attempt := 1; ; attempt++ {
+			if ips, err = r.IPAMManager.DualStack().Allocate(ipFamilyMode, networkName, subnetNames, pod.Name, pod.Namespace); err != nil {
+				return fmt.Errorf("unable to allocate %s ip: %w", ipFamilyMode, err)
+			}
+
+			if err = r.IPAMStore.DualStack().Couple(pod, ips); err == nil {
+				break
+			}
+
+			if !store.IsAllocationRejected(err) || attempt >= allocationHookMaxAttempts {
+				_ = r.IPAMManager.DualStack().Release(ipFamilyMode, networkName, squashIPSliceToSubnets(ips), squashIPSliceToIPs(ips))
+				return fmt.Errorf("unable to couple IPs with pod: %v", err)
+			}
+			rejectedIPs = append(rejectedIPs, ips...)
 		}
 
-		r.Recorder.Eventf(pod, corev1.EventTypeNormal, ReasonIPAllocationSucceed, "allocate IPs %v successfully", squashIPSliceToIPs(ips))
+		r.Recorder.Eventf(pod, corev1.EventTypeNormal, ReasonIPAllocationSucceed, "allocate IPs %v successfully%s", squashIPSliceToIPs(ips), decision)
+		r.recordAllocationEventWithLocality(pod, AllocationEventActionAllocate, networkName, ips, decision, false, local)
 		return nil
 	}
 
 	var (
-		subnetName = globalutils.PickFirstNonEmptyString(pod.Annotations[constants.AnnotationSpecifiedSubnet], pod.Labels[constants.LabelSpecifiedSubnet])
-		ip         *types.IP
+		subnetNames []string
+		subnetName  string
+		decision    string
+		local       *bool
+		ip          *types.IP
 	)
-	if ip, err = r.IPAMManager.Allocate(networkName, subnetName, pod.Name, pod.Namespace); err != nil {
-		return fmt.Errorf("unable to allocate ip: %v", err)
+	if subnetNames, decision, local, err = r.selectSubnets(pod, networkName, types.IPv4Only); err != nil {
+		return err
 	}
+	if len(subnetNames) > 0 {
+		subnetName = subnetNames[0]
+	}
+	// ips rejected by allocation hook are held until allocation ends, so that
+	// they will not be allocated again in the following attempts
+	var rejectedIPs []*types.IP
 	defer func() {
-		if err != nil {
-			_ = r.IPAMManager.Release(ip.Network, ip.Subnet, ip.Address.IP.String())
+		for _, rejectedIP := range rejectedIPs {
+			_ = r.IPAMManager.Release(rejectedIP.Network, rejectedIP.Subnet, rejectedIP.Address.IP.String())
 		}
 	}()
 
-	if err = r.IPAMStore.Couple(pod, ip); err != nil {
-		return fmt.Errorf("unable to couple ip with pod: %v", err)
+	for attempt := 1; ; attempt++ {
+		if ip, err = r.IPAMManager.Allocate(networkName, subnetName, pod.Name, pod.Namespace); err != nil {
+			return fmt.Errorf("unable to allocate ip: %w", err)
+		}
+
+		if err = r.IPAMStore.Couple(pod, ip); err == nil {
+			break
+		}
+
+		if !store.IsAllocationRejected(err) || attempt >= allocationHookMaxAttempts {
+			_ = r.IPAMManager.Release(ip.Network, ip.Subnet, ip.Address.IP.String())
+			return fmt.Errorf("unable to couple ip with pod: %v", err)
+		}
+		rejectedIPs = append(rejectedIPs, ip)
 	}
 
-	r.Recorder.Eventf(pod, corev1.EventTypeNormal, ReasonIPAllocationSucceed, "allocate IP %s successfully", ip.String())
+	r.Recorder.Eventf(pod, corev1.EventTypeNormal, ReasonIPAllocationSucceed, "allocate IP %s successfully%s", ip.String(), decision)
+	r.recordAllocationEventWithLocality(pod, AllocationEventActionAllocate, networkName, []*types.IP{ip}, decision, false, local)
 	return nil
 }
 
+// ipBindingOf returns the ip binding of pod if any, ip bindings being deleted are ignored
+func (r *PodReconciler) ipBindingOf(ctx context.Context, pod *corev1.Pod) (*networkingv1.IPBinding, error) {
+	bindingList := &networkingv1.IPBindingList{}
+	if err := r.List(ctx, bindingList, client.InNamespace(pod.Namespace),
+		client.MatchingFields{IndexerFieldIPBindingPod: pod.Name}); err != nil {
+		return nil, fmt.Errorf("unable to list ip bindings: %v", err)
+	}
+
+	var bindings []*networkingv1.IPBinding
+	for i := range bindingList.Items {
+		if bindingList.Items[i].DeletionTimestamp.IsZero() {
+			bindings = append(bindings, &bindingList.Items[i])
+		}
+	}
+
+	switch len(bindings) {
+	case 0:
+		return nil, nil
+	case 1:
+		return bindings[0], nil
+	default:
+		return nil, newPermanentError("pod is bound by %d ip bindings", len(bindings))
+	}
+}
+
+// bindingAssign will assign the fixed IPs of ip binding to Pod, which are usually reserved by ip binding
+// controller in advance, stateful pods keep the finalizer for reserving IPs on deletion as usual
+func (r *PodReconciler) bindingAssign(ctx context.Context, pod *corev1.Pod, networkName string, binding *networkingv1.IPBinding) (err error) {
+	var startTime = time.Now()
+	defer func() {
+		allocateType := metrics.IPNormalAllocateType
+		if strategy.OwnByStatefulWorkload(pod) {
+			allocateType = metrics.IPStatefulAllocateType
+		}
+		observeIPAllocation(allocateType, metrics.IPReassignAllocatePath, startTime, err)
+	}()
+
+	ips, ipFamily, err := utils.ParseBindingIPs(binding.Spec.IPs)
+	if err != nil {
+		return newPermanentError("invalid ip binding %s: %v", binding.Name, err)
+	}
+
+	if strategy.OwnByStatefulWorkload(pod) {
+		if err = r.addFinalizer(ctx, pod); err != nil {
+			return wrapError("unable to add finalizer for stateful pod", err)
+		}
+	}
+
+	if feature.DualStackEnabled() {
+		return r.multiAssign(ctx, pod, networkName, ipFamily, ips, false)
+	}
+	if ipFamily != types.IPv4Only {
+		return newPermanentError("ip family %s of ip binding %s is only supported in dual stack mode", ipFamily, binding.Name)
+	}
+	return r.assign(ctx, pod, networkName, ips[0], false)
+}
+
 // assign will reassign allocated IP to Pod
 func (r *PodReconciler) assign(ctx context.Context, pod *corev1.Pod, networkName string, ipCandidate string, forced bool) (err error) {
 	ip, err := r.IPAMManager.Assign(networkName, "", pod.Name, pod.Namespace, ipCandidate, forced)
@@ -450,6 +1723,7 @@ func (r *PodReconciler) assign(ctx context.Context, pod *corev1.Pod, networkName
 	}
 
 	r.Recorder.Eventf(pod, corev1.EventTypeNormal, ReasonIPAllocationSucceed, "assign IP %s successfully", ip.String())
+	r.recordAllocationEvent(pod, AllocationEventActionAssign, networkName, []*types.IP{ip}, "", forced)
 	return nil
 }
 
@@ -470,6 +1744,7 @@ func (r *PodReconciler) multiAssign(ctx context.Context, pod *corev1.Pod, networ
 	}
 
 	r.Recorder.Eventf(pod, corev1.EventTypeNormal, ReasonIPAllocationSucceed, "assign IPs %v successfully", squashIPSliceToIPs(IPs))
+	r.recordAllocationEvent(pod, AllocationEventActionAssign, networkName, IPs, "", forced)
 	return nil
 }
 
@@ -485,6 +1760,22 @@ func (r *PodReconciler) addFinalizer(ctx context.Context, pod *corev1.Pod) error
 	})
 }
 
+// finalizerIsStale checks whether the ip finalizer of pod is left without any ip instance in use,
+// ip instances are listed from apiserver directly because the ones missing in cache are not reliable
+func (r *PodReconciler) finalizerIsStale(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	if !controllerutil.ContainsFinalizer(pod, constants.FinalizerIPAllocated) {
+		return false, nil
+	}
+
+	ipList, err := utils.ListIPInstances(r.APIReader, client.InNamespace(pod.Namespace), client.MatchingLabels{
+		constants.LabelPod: pod.Name,
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to list ip instances of pod: %v", err)
+	}
+	return utils.PodFinalizerIsStale(pod, ipList.Items), nil
+}
+
 func (r *PodReconciler) removeFinalizer(ctx context.Context, pod *corev1.Pod) error {
 	if !controllerutil.ContainsFinalizer(pod, constants.FinalizerIPAllocated) {
 		return nil
@@ -492,9 +1783,191 @@ func (r *PodReconciler) removeFinalizer(ctx context.Context, pod *corev1.Pod) er
 
 	patch := client.StrategicMergeFrom(pod.DeepCopy())
 	controllerutil.RemoveFinalizer(pod, constants.FinalizerIPAllocated)
-	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		return r.Patch(ctx, pod, patch)
 	})
+	if err == nil || apierrors.IsNotFound(err) {
+		return err
+	}
+
+	// pod is kept terminating until the finalizer is removed, which must not be only visible in logs
+	if apierrors.IsConflict(err) {
+		r.Recorder.Eventf(pod, corev1.EventTypeWarning, ReasonFinalizerRemovalFail,
+			"unable to remove finalizer %s after retries on conflict: %v", constants.FinalizerIPAllocated, err)
+	} else {
+		r.Recorder.Eventf(pod, corev1.EventTypeWarning, ReasonFinalizerRemovalFail,
+			"unable to remove finalizer %s: %v", constants.FinalizerIPAllocated, err)
+	}
+	return err
+}
+
+// checkAllocationDeadline aborts stateful allocation between steps once it times out, steps in
+// progress are never interrupted, so what is left is the same as a failure of the next step
+// and will be recovered by requeue
+func checkAllocationDeadline(ctx context.Context, step string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("stateful allocation is aborted before %s: %v", step, err)
+	}
+	return nil
+}
+
+func observeIPAllocation(allocateType, allocatePath string, startTime time.Time, err error) {
+	metrics.IPAllocationPeriodSummary.
+		WithLabelValues(allocateType, strconv.FormatBool(err == nil), allocatePath).
+		Observe(float64(time.Since(startTime).Nanoseconds()))
+}
+
+// reportAllocationFailure warns the failure of allocation which keeps being retried, only the events of
+// repeated failures are throttled, while annotation and condition are always patched (skipped if unchanged)
+// to keep up with the latest failure
+func (r *PodReconciler) reportAllocationFailure(ctx context.Context, pod *corev1.Pod, networkName string, failure error) {
+	log := ctrllog.FromContext(ctx)
+	allowEvent := r.allowAllocationFailureEvent(pod)
+
+	if types.IsCapacityExhausted(failure) {
+		if allowEvent {
+			r.Recorder.Eventf(pod, corev1.EventTypeWarning, ReasonSubnetExhausted,
+				"network %s is full, no ip is available for pod: %v", networkName, failure)
+		}
+		// daemon will tell the exhaustion from pending allocation by annotation
+		if err := r.markNetworkExhausted(ctx, pod, networkName); err != nil {
+			log.Error(err, "unable to mark network exhaustion on pod")
+		}
+		if err := r.markIPAllocatedConditionFailed(ctx, pod, constants.ReasonNetworkExhausted, failure); err != nil {
+			log.Error(err, "unable to patch ip allocated condition of pod")
+		}
+		return
+	}
+
+	if allowEvent {
+		r.Recorder.Event(pod, corev1.EventTypeWarning, ReasonIPAllocationFail, failure.Error())
+	}
+	if err := r.markIPAllocatedConditionFailed(ctx, pod, constants.ReasonIPAllocationFailed, failure); err != nil {
+		log.Error(err, "unable to patch ip allocated condition of pod")
+	}
+}
+
+// reportPermanentFailure warns the permanent failure of allocation, only the events are throttled, while
+// annotation and condition are always patched (skipped if unchanged), so that daemon never reports a stale failure
+func (r *PodReconciler) reportPermanentFailure(ctx context.Context, pod *corev1.Pod, failure error) {
+	log := ctrllog.FromContext(ctx)
+
+	if r.allowPermanentFailureEvent(pod) {
+		r.Recorder.Event(pod, corev1.EventTypeWarning, ReasonIPAllocationFail, failure.Error())
+	}
+	// daemon will tell the permanent failure from pending allocation by annotation
+	if err := r.markAllocationFailure(ctx, pod, failure); err != nil {
+		log.Error(err, "unable to mark allocation failure on pod")
+	}
+	if err := r.markIPAllocatedConditionFailed(ctx, pod, constants.ReasonIPAllocationFailed, failure); err != nil {
+		log.Error(err, "unable to patch ip allocated condition of pod")
+	}
+}
+
+// markAllocationFailure records the permanent allocation failure on pod
+func (r *PodReconciler) markAllocationFailure(ctx context.Context, pod *corev1.Pod, failure error) error {
+	if pod.DeletionTimestamp != nil || pod.Annotations[constants.AnnotationAllocationFailure] == failure.Error() {
+		return nil
+	}
+
+	patchBody, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": withCorrelationID(pod, map[string]string{
+				constants.AnnotationAllocationFailure: failure.Error(),
+			}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return client.IgnoreNotFound(r.Patch(ctx, pod, client.RawPatch(apitypes.MergePatchType, patchBody)))
+}
+
+// markNetworkExhausted records the exhausted network on pod, it is removed once ips are allocated
+func (r *PodReconciler) markNetworkExhausted(ctx context.Context, pod *corev1.Pod, networkName string) error {
+	if pod.DeletionTimestamp != nil || pod.Annotations[constants.AnnotationNetworkExhausted] == networkName {
+		return nil
+	}
+
+	patchBody, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": withCorrelationID(pod, map[string]string{
+				constants.AnnotationNetworkExhausted: networkName,
+			}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return client.IgnoreNotFound(r.Patch(ctx, pod, client.RawPatch(apitypes.MergePatchType, patchBody)))
+}
+
+// markIPAllocatedConditionFailed reports the allocation failure on ip allocated condition of pod status, so
+// that it can be queried durably after events expire. It is set back to true once ips are coupled with pod
+func (r *PodReconciler) markIPAllocatedConditionFailed(ctx context.Context, pod *corev1.Pod, reason string, failure error) error {
+	if pod.DeletionTimestamp != nil || utils.PodIPAllocationFailureIsRecorded(pod, reason, failure.Error()) {
+		return nil
+	}
+
+	// conditions are merged by type with strategic merge patch, the others are kept
+	patchBody, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []corev1.PodCondition{
+				{
+					Type:               constants.PodConditionIPAllocated,
+					Status:             corev1.ConditionFalse,
+					Reason:             reason,
+					Message:            failure.Error(),
+					LastTransitionTime: metav1.Now(),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return client.IgnoreNotFound(r.Status().Patch(ctx, pod, client.RawPatch(apitypes.StrategicMergePatchType, patchBody)))
+}
+
+// withCorrelationID adds the correlation id of current allocation attempt to the annotations to patch
+func withCorrelationID(pod *corev1.Pod, annotations map[string]string) map[string]string {
+	if correlationID := pod.Annotations[constants.AnnotationCorrelationID]; len(correlationID) > 0 {
+		annotations[constants.AnnotationCorrelationID] = correlationID
+	}
+	return annotations
+}
+
+// allowAllocationFailureEvent will only allow one allocation failure event for each pod in the configured
+// interval, so that pods failing repeatedly during outages do not flood apiserver with events
+func (r *PodReconciler) allowAllocationFailureEvent(pod *corev1.Pod) bool {
+	if r.AllocationFailureEventInterval <= 0 {
+		return true
+	}
+	if _, recorded := r.allocationFailureEvents.Get(pod.UID); recorded {
+		return false
+	}
+	r.allocationFailureEvents.Add(pod.UID, struct{}{}, r.AllocationFailureEventInterval)
+	return true
+}
+
+// forgetAllocationFailureEvent allows the allocation failure events of pod again once it is reconciled
+// successfully, except that it is still waiting on paused network
+func (r *PodReconciler) forgetAllocationFailureEvent(pod *corev1.Pod, outcome string) {
+	if len(pod.UID) > 0 && outcome != metrics.PodReconcileOutcomePaused {
+		r.allocationFailureEvents.Remove(pod.UID)
+	}
+}
+
+// allowPermanentFailureEvent will only allow one permanent failure event for each pod in an interval
+func (r *PodReconciler) allowPermanentFailureEvent(pod *corev1.Pod) bool {
+	if _, recorded := r.permanentFailureEvents.Get(pod.UID); recorded {
+		return false
+	}
+	r.permanentFailureEvents.Add(pod.UID, struct{}{}, permanentFailureEventInterval)
+	return true
 }
 
 func squashIPSliceToIPs(ips []*types.IP) (ret []string) {
@@ -513,30 +1986,40 @@ func squashIPSliceToSubnets(ips []*types.IP) (ret []string) {
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *PodReconciler) SetupWithManager(mgr ctrl.Manager) (err error) {
+	r.permanentFailureEvents = cache.NewLRUExpireCache(permanentFailureEventCacheSize)
+	r.allocationFailureEvents = cache.NewLRUExpireCache(allocationFailureEventCacheSize)
+	r.allocationFailures = map[apitypes.NamespacedName]int{}
+	r.allocationAttempts = map[apitypes.NamespacedName]int{}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		Named(ControllerPod).
 		For(&corev1.Pod{},
 			builder.WithPredicates(
 				&utils.IgnoreDeletePredicate{},
 				&predicate.ResourceVersionChangedPredicate{},
-				predicate.NewPredicateFuncs(func(obj client.Object) bool {
-					pod, ok := obj.(*corev1.Pod)
-					if !ok {
-						return false
-					}
-					// ignore host networking pod
-					if pod.Spec.HostNetwork {
-						return false
-					}
-
-					if pod.DeletionTimestamp.IsZero() {
-						// only pod after scheduling and before IP-allocation should be processed
-						return len(pod.Spec.NodeName) > 0 && !metav1.HasAnnotation(pod.ObjectMeta, constants.AnnotationIP)
-					}
-
-					// terminating pods owned by stateful workloads should be processed for IP reservation
-					return strategy.OwnByStatefulWorkload(pod)
-				}),
+				predicate.Or(
+					predicate.NewPredicateFuncs(func(obj client.Object) bool {
+						pod, ok := obj.(*corev1.Pod)
+						if !ok {
+							return false
+						}
+						// ignore host networking pod
+						if pod.Spec.HostNetwork {
+							return false
+						}
+
+						if pod.DeletionTimestamp.IsZero() {
+							// only pod after scheduling and before IP-allocation should be processed
+							return len(pod.Spec.NodeName) > 0 && !metav1.HasAnnotation(pod.ObjectMeta, constants.AnnotationIP)
+						}
+
+						// terminating pods owned by stateful workloads should be processed for IP reservation
+						return strategy.OwnByStatefulWorkload(pod) && !utils.PodIsExternallyAddressed(pod)
+					}),
+					// pods scheduled, or reassigned to another node, with ip annotation should be checked
+					// for ips left on another node
+					&utils.PodScheduledPredicate{},
+				),
 			),
 		).
 		WithOptions(controller.Options{
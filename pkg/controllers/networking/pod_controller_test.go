@@ -0,0 +1,903 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/ipam/strategy"
+	"github.com/alibaba/hybridnet/pkg/ipam/types"
+	"github.com/alibaba/hybridnet/pkg/metrics"
+)
+
+// specifiedSubnetClient gets subnets, networks and nodes by name, and lists subnets and ip instances
+type specifiedSubnetClient struct {
+	client.Client
+	subnets     map[string]*networkingv1.Subnet
+	networks    map[string]*networkingv1.Network
+	nodes       map[string]*corev1.Node
+	ipInstances []networkingv1.IPInstance
+}
+
+func (s *specifiedSubnetClient) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	switch o := obj.(type) {
+	case *networkingv1.Subnet:
+		subnet, exist := s.subnets[key.Name]
+		if !exist {
+			return apierrors.NewNotFound(schema.GroupResource{}, key.Name)
+		}
+		subnet.DeepCopyInto(o)
+	case *networkingv1.Network:
+		network, exist := s.networks[key.Name]
+		if !exist {
+			return apierrors.NewNotFound(schema.GroupResource{}, key.Name)
+		}
+		network.DeepCopyInto(o)
+	case *corev1.Node:
+		node, exist := s.nodes[key.Name]
+		if !exist {
+			return apierrors.NewNotFound(schema.GroupResource{}, key.Name)
+		}
+		node.DeepCopyInto(o)
+	}
+	return nil
+}
+
+func (s *specifiedSubnetClient) List(_ context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOptions := &client.ListOptions{}
+	listOptions.ApplyOptions(opts)
+
+	switch l := list.(type) {
+	case *networkingv1.SubnetList:
+		for _, subnet := range s.subnets {
+			if listOptions.FieldSelector != nil &&
+				!listOptions.FieldSelector.Matches(fields.Set{IndexerFieldNetwork: subnet.Spec.Network}) {
+				continue
+			}
+			l.Items = append(l.Items, *subnet.DeepCopy())
+		}
+		// keep the order of listed subnets stable as apiserver does
+		sort.Slice(l.Items, func(i, j int) bool {
+			return l.Items[i].Name < l.Items[j].Name
+		})
+	case *networkingv1.IPInstanceList:
+		for i := range s.ipInstances {
+			var ipInstance = &s.ipInstances[i]
+			if len(listOptions.Namespace) > 0 && ipInstance.Namespace != listOptions.Namespace {
+				continue
+			}
+			if listOptions.LabelSelector != nil && !listOptions.LabelSelector.Matches(labels.Set(ipInstance.Labels)) {
+				continue
+			}
+			l.Items = append(l.Items, *ipInstance.DeepCopy())
+		}
+	}
+	return nil
+}
+
+// specifiedSubnetIPAMManager reports the available ips of subnets
+type specifiedSubnetIPAMManager struct {
+	IPAMManager
+	available map[string]uint32
+}
+
+func (s *specifiedSubnetIPAMManager) SubnetUsage(_, subnet string) (*types.Usage, error) {
+	return &types.Usage{Available: s.available[subnet]}, nil
+}
+
+func TestCheckSpecifiedSubnets(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1", UID: "pod1-uid"},
+	}
+	newSubnet := func(name, network string) *networkingv1.Subnet {
+		return &networkingv1.Subnet{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       networkingv1.SubnetSpec{Network: network},
+		}
+	}
+	deletingSubnet := newSubnet("deleting", "network1")
+	deletingSubnet.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	subnets := map[string]*networkingv1.Subnet{
+		"available": newSubnet("available", "network1"),
+		"exhausted": newSubnet("exhausted", "network1"),
+		"foreign":   newSubnet("foreign", "network2"),
+		"deleting":  deletingSubnet,
+	}
+	available := map[string]uint32{"available": 10, "deleting": 10, "foreign": 10}
+
+	tests := []struct {
+		name            string
+		policy          networkingv1.SpecifiedSubnetPolicy
+		subnetNames     []string
+		expectedSubnets []string
+		expectErr       bool
+		expectWarning   bool
+	}{
+		{
+			"available subnet",
+			networkingv1.SpecifiedSubnetPolicyStrict,
+			[]string{"available"},
+			[]string{"available"},
+			false,
+			false,
+		},
+		{
+			"exhausted subnet with strict policy",
+			networkingv1.SpecifiedSubnetPolicyStrict,
+			[]string{"exhausted"},
+			nil,
+			true,
+			true,
+		},
+		{
+			"missing subnet with strict policy",
+			networkingv1.SpecifiedSubnetPolicyStrict,
+			[]string{"missing"},
+			nil,
+			true,
+			true,
+		},
+		{
+			"subnet of another network with strict policy",
+			networkingv1.SpecifiedSubnetPolicyStrict,
+			[]string{"foreign"},
+			nil,
+			true,
+			true,
+		},
+		{
+			"deleting subnet with strict policy",
+			networkingv1.SpecifiedSubnetPolicyStrict,
+			[]string{"available", "deleting"},
+			nil,
+			true,
+			true,
+		},
+		{
+			"exhausted subnet with fallback policy",
+			networkingv1.SpecifiedSubnetPolicyFallback,
+			[]string{"exhausted"},
+			nil,
+			false,
+			true,
+		},
+		{
+			"deleting subnet with fallback policy",
+			networkingv1.SpecifiedSubnetPolicyFallback,
+			[]string{"deleting"},
+			nil,
+			false,
+			true,
+		},
+		{
+			"available subnet with fallback policy",
+			networkingv1.SpecifiedSubnetPolicyFallback,
+			[]string{"available"},
+			[]string{"available"},
+			false,
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			r := &PodReconciler{
+				Client: &specifiedSubnetClient{
+					subnets: subnets,
+					networks: map[string]*networkingv1.Network{
+						"network1": {
+							ObjectMeta: metav1.ObjectMeta{Name: "network1"},
+							Spec:       networkingv1.NetworkSpec{SpecifiedSubnetPolicy: test.policy},
+						},
+					},
+				},
+				Recorder:                       recorder,
+				IPAMManager:                    &specifiedSubnetIPAMManager{available: available},
+				AllocationFailureEventInterval: time.Minute,
+				allocationFailureEvents:        cache.NewLRUExpireCache(10),
+			}
+
+			// pods are requeued while specified subnet is unavailable, which should be warned only once
+			for i := 0; i < 3; i++ {
+				subnetNames, err := r.checkSpecifiedSubnets(pod, "network1", test.subnetNames)
+				if (err != nil) != test.expectErr {
+					t.Fatalf("test %s fails: expected error %v but got %v", test.name, test.expectErr, err)
+				}
+				if !reflect.DeepEqual(subnetNames, test.expectedSubnets) {
+					t.Errorf("test %s fails: expected subnets %v but got %v", test.name, test.expectedSubnets, subnetNames)
+				}
+			}
+
+			var warnings int
+			for len(recorder.Events) > 0 {
+				<-recorder.Events
+				warnings++
+			}
+			if expected := map[bool]int{true: 1}[test.expectWarning]; warnings != expected {
+				t.Errorf("test %s fails: expected %d warnings but got %d", test.name, expected, warnings)
+			}
+		})
+	}
+}
+
+// newSelectorSubnet returns a subnet of network1 in the ip family
+func newSelectorSubnet(name string, ipv6 bool) *networkingv1.Subnet {
+	subnet := &networkingv1.Subnet{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: networkingv1.SubnetSpec{
+			Network: "network1",
+			Range:   networkingv1.AddressRange{Version: networkingv1.IPv4, CIDR: "192.168.0.0/24"},
+		},
+	}
+	if ipv6 {
+		subnet.Spec.Range = networkingv1.AddressRange{Version: networkingv1.IPv6, CIDR: "fd00::/120"}
+	}
+	return subnet
+}
+
+func TestSelectSubnetsByNetIDRange(t *testing.T) {
+	subnetWithNetID := func(name string, ipv6 bool, netID *int32) *networkingv1.Subnet {
+		subnet := newSelectorSubnet(name, ipv6)
+		subnet.Spec.NetID = netID
+		return subnet
+	}
+	network := &networkingv1.Network{
+		ObjectMeta: metav1.ObjectMeta{Name: "network1"},
+		Spec:       networkingv1.NetworkSpec{NetID: pointer.Int32Ptr(105)},
+	}
+	subnets := map[string]*networkingv1.Subnet{
+		"v4-100":     subnetWithNetID("v4-100", false, pointer.Int32Ptr(100)),
+		"v4-101":     subnetWithNetID("v4-101", false, pointer.Int32Ptr(101)),
+		"v4-200":     subnetWithNetID("v4-200", false, pointer.Int32Ptr(200)),
+		"v6-101":     subnetWithNetID("v6-101", true, pointer.Int32Ptr(101)),
+		"v6-102":     subnetWithNetID("v6-102", true, pointer.Int32Ptr(102)),
+		"v4-inherit": subnetWithNetID("v4-inherit", false, nil),
+		"v6-inherit": subnetWithNetID("v6-inherit", true, nil),
+		"v4-private": subnetWithNetID("v4-private", false, pointer.Int32Ptr(300)),
+	}
+	subnets["v4-private"].Spec.Config = &networkingv1.SubnetConfig{Private: pointer.BoolPtr(true)}
+
+	tests := []struct {
+		name            string
+		netIDRange      string
+		ipFamily        types.IPFamilyMode
+		available       map[string]uint32
+		expectedSubnets []string
+		expectErr       bool
+		expectPermanent bool
+	}{
+		{
+			"invalid net ID range",
+			"110-100",
+			types.IPv4Only,
+			nil,
+			nil,
+			true,
+			true,
+		},
+		{
+			"no such net ID",
+			"400-410",
+			types.IPv4Only,
+			map[string]uint32{"v4-100": 10},
+			nil,
+			true,
+			true,
+		},
+		{
+			"range full",
+			"100-101",
+			types.IPv4Only,
+			map[string]uint32{"v4-200": 10, "v6-101": 10},
+			nil,
+			true,
+			false,
+		},
+		{
+			"private subnet is never selected",
+			"300",
+			types.IPv4Only,
+			map[string]uint32{"v4-private": 10},
+			nil,
+			true,
+			false,
+		},
+		{
+			"ipv4 subnet in range selected",
+			"100-200",
+			types.IPv4Only,
+			map[string]uint32{"v4-101": 10, "v4-200": 10},
+			[]string{"v4-101"},
+			false,
+			false,
+		},
+		{
+			"ipv6 subnet in range selected",
+			"100-110",
+			types.IPv6Only,
+			map[string]uint32{"v4-100": 10, "v6-102": 10},
+			[]string{"v6-102"},
+			false,
+			false,
+		},
+		{
+			"dual stack subnets paired by the same net ID",
+			"100-102",
+			types.DualStack,
+			map[string]uint32{"v4-100": 10, "v4-101": 10, "v6-101": 10, "v6-102": 10},
+			[]string{"v4-101", "v6-101"},
+			false,
+			false,
+		},
+		{
+			"dual stack subnets paired by net ID inherited from network",
+			"105",
+			types.DualStack,
+			map[string]uint32{"v4-inherit": 10, "v6-inherit": 10},
+			[]string{"v4-inherit", "v6-inherit"},
+			false,
+			false,
+		},
+		{
+			"dual stack subnets without the same net ID",
+			"100-102",
+			types.DualStack,
+			map[string]uint32{"v4-100": 10, "v6-102": 10},
+			nil,
+			true,
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &PodReconciler{
+				Client: &specifiedSubnetClient{
+					subnets:  subnets,
+					networks: map[string]*networkingv1.Network{"network1": network},
+				},
+				IPAMManager: &specifiedSubnetIPAMManager{available: test.available},
+			}
+
+			subnetNames, err := r.selectSubnetsByNetIDRange("network1", test.ipFamily, test.netIDRange)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v but got %v", test.expectErr, err)
+			}
+			if test.expectErr && IsPermanentError(err) != test.expectPermanent {
+				t.Errorf("expected permanent error %v but got %v", test.expectPermanent, err)
+			}
+			if !reflect.DeepEqual(subnetNames, test.expectedSubnets) {
+				t.Errorf("expected subnets %v but got %v", test.expectedSubnets, subnetNames)
+			}
+		})
+	}
+}
+
+func TestSelectSubnetsByTopology(t *testing.T) {
+	subnetWithTopology := func(name string, ipv6 bool, zone string, weight *int32) *networkingv1.Subnet {
+		subnet := newSelectorSubnet(name, ipv6)
+		subnet.Spec.Config = &networkingv1.SubnetConfig{
+			Topology: &networkingv1.SubnetTopology{Key: "zone", Value: zone, Weight: weight},
+		}
+		return subnet
+	}
+	nodes := map[string]*corev1.Node{
+		"node-a": {ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{"zone": "a"}}},
+		"node-c": {ObjectMeta: metav1.ObjectMeta{Name: "node-c", Labels: map[string]string{"zone": "c"}}},
+	}
+	plainSubnets := map[string]*networkingv1.Subnet{
+		"a-1":  subnetWithTopology("a-1", false, "a", nil),
+		"a-2":  subnetWithTopology("a-2", false, "a", nil),
+		"a-v6": subnetWithTopology("a-v6", true, "a", nil),
+		"b-1":  subnetWithTopology("b-1", false, "b", nil),
+		"any":  newSelectorSubnet("any", false),
+	}
+	weightedSubnets := map[string]*networkingv1.Subnet{
+		"w-1": subnetWithTopology("w-1", false, "a", pointer.Int32Ptr(10)),
+		"w-2": subnetWithTopology("w-2", false, "a", pointer.Int32Ptr(100)),
+		"w-3": subnetWithTopology("w-3", false, "a", pointer.Int32Ptr(100)),
+		"w-4": subnetWithTopology("w-4", false, "b", pointer.Int32Ptr(100)),
+	}
+
+	tests := []struct {
+		name            string
+		subnets         map[string]*networkingv1.Subnet
+		nodeName        string
+		ipFamily        types.IPFamilyMode
+		fallback        bool
+		available       map[string]uint32
+		expectedSubnets []string
+		expectedLocal   *bool
+		expectErr       bool
+		expectPermanent bool
+	}{
+		{
+			"no subnet has topology",
+			map[string]*networkingv1.Subnet{"any": plainSubnets["any"]},
+			"node-a",
+			types.IPv4Only,
+			false,
+			map[string]uint32{"any": 10},
+			nil,
+			nil,
+			false,
+			false,
+		},
+		{
+			"matched",
+			plainSubnets,
+			"node-a",
+			types.IPv4Only,
+			false,
+			map[string]uint32{"a-1": 10, "a-2": 10, "b-1": 10},
+			[]string{"a-1"},
+			pointer.BoolPtr(true),
+			false,
+			false,
+		},
+		{
+			"matched on dual stack",
+			plainSubnets,
+			"node-a",
+			types.DualStack,
+			false,
+			map[string]uint32{"a-2": 10, "a-v6": 10},
+			[]string{"a-2", "a-v6"},
+			pointer.BoolPtr(true),
+			false,
+			false,
+		},
+		{
+			"matched but full without fallback",
+			plainSubnets,
+			"node-a",
+			types.IPv4Only,
+			false,
+			map[string]uint32{"b-1": 10, "any": 10},
+			nil,
+			nil,
+			true,
+			false,
+		},
+		{
+			"matched but full with fallback",
+			plainSubnets,
+			"node-a",
+			types.IPv4Only,
+			true,
+			map[string]uint32{"b-1": 10, "any": 10},
+			nil,
+			pointer.BoolPtr(false),
+			false,
+			false,
+		},
+		{
+			"unmatched without fallback",
+			plainSubnets,
+			"node-c",
+			types.IPv4Only,
+			false,
+			map[string]uint32{"a-1": 10, "b-1": 10},
+			nil,
+			nil,
+			true,
+			true,
+		},
+		{
+			"unmatched with fallback",
+			plainSubnets,
+			"node-c",
+			types.IPv4Only,
+			true,
+			map[string]uint32{"a-1": 10, "b-1": 10},
+			nil,
+			pointer.BoolPtr(false),
+			false,
+			false,
+		},
+		{
+			"matched subnet of higher weight first",
+			weightedSubnets,
+			"node-a",
+			types.IPv4Only,
+			false,
+			map[string]uint32{"w-1": 10, "w-2": 10, "w-3": 10, "w-4": 10},
+			[]string{"w-2"},
+			pointer.BoolPtr(true),
+			false,
+			false,
+		},
+		{
+			"matched subnet of lower weight if higher ones are full",
+			weightedSubnets,
+			"node-a",
+			types.IPv4Only,
+			false,
+			map[string]uint32{"w-1": 10, "w-4": 10},
+			[]string{"w-1"},
+			pointer.BoolPtr(true),
+			false,
+			false,
+		},
+		{
+			"weighted topology always falls back",
+			weightedSubnets,
+			"node-a",
+			types.IPv4Only,
+			false,
+			map[string]uint32{"w-4": 10},
+			nil,
+			pointer.BoolPtr(false),
+			false,
+			false,
+		},
+	}
+
+	defer func(fallback bool) {
+		strategy.SubnetTopologyFallback = fallback
+	}(strategy.SubnetTopologyFallback)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy.SubnetTopologyFallback = test.fallback
+			r := &PodReconciler{
+				Client:      &specifiedSubnetClient{subnets: test.subnets, nodes: nodes},
+				IPAMManager: &specifiedSubnetIPAMManager{available: test.available},
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1"},
+				Spec:       corev1.PodSpec{NodeName: test.nodeName},
+			}
+
+			subnetNames, decision, local, err := r.selectSubnetsByTopology(pod, "network1", test.ipFamily)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v but got %v", test.expectErr, err)
+			}
+			if test.expectErr && IsPermanentError(err) != test.expectPermanent {
+				t.Errorf("expected permanent error %v but got %v", test.expectPermanent, err)
+			}
+			if !reflect.DeepEqual(subnetNames, test.expectedSubnets) {
+				t.Errorf("expected subnets %v but got %v", test.expectedSubnets, subnetNames)
+			}
+			if !reflect.DeepEqual(local, test.expectedLocal) {
+				t.Errorf("expected local %v but got %v", test.expectedLocal, local)
+			}
+			if (local != nil) != (len(decision) > 0) {
+				t.Errorf("expected decision only if topology is involved, but got %q", decision)
+			}
+		})
+	}
+}
+
+func TestSelectSubnetsByAntiAffinity(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1", UID: "pod1-uid"},
+	}
+	ipInstance := func(namespace, subnet, podName string, terminating bool) networkingv1.IPInstance {
+		ipInstance := networkingv1.IPInstance{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      subnet + "-" + podName,
+				Labels: map[string]string{
+					constants.LabelOwnerKind: "Deployment",
+					constants.LabelOwnerName: "web",
+					constants.LabelNetwork:   "network1",
+					constants.LabelPod:       podName,
+				},
+			},
+			Spec: networkingv1.IPInstanceSpec{Network: "network1", Subnet: subnet},
+		}
+		if terminating {
+			ipInstance.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+		}
+		return ipInstance
+	}
+
+	subnets := map[string]*networkingv1.Subnet{
+		"v4-a": newSelectorSubnet("v4-a", false),
+		"v4-b": newSelectorSubnet("v4-b", false),
+		"v4-c": newSelectorSubnet("v4-c", false),
+		"v6-a": newSelectorSubnet("v6-a", true),
+		"v6-b": newSelectorSubnet("v6-b", true),
+	}
+	ipInstances := []networkingv1.IPInstance{
+		ipInstance("default", "v4-a", "web-1", false),
+		ipInstance("default", "v6-a", "web-1", false),
+		// ips being released, of pod itself or in other namespaces do not occupy subnets
+		ipInstance("default", "v4-b", "web-2", true),
+		ipInstance("default", "v6-b", "pod1", false),
+		ipInstance("other", "v4-b", "web-3", false),
+	}
+
+	tests := []struct {
+		name            string
+		workloads       string
+		ipFamily        types.IPFamilyMode
+		available       map[string]uint32
+		expectedSubnets []string
+		expectErr       bool
+		expectWarning   bool
+	}{
+		{
+			"free of workload",
+			"StatefulSet/db",
+			types.IPv4Only,
+			map[string]uint32{"v4-a": 10, "v4-b": 10},
+			[]string{"v4-a"},
+			false,
+			false,
+		},
+		{
+			"skip occupied subnet",
+			"StatefulSet/db,Deployment/web",
+			types.IPv4Only,
+			map[string]uint32{"v4-a": 10, "v4-b": 10},
+			[]string{"v4-b"},
+			false,
+			false,
+		},
+		{
+			"skip occupied subnets of dual stack",
+			"Deployment/web",
+			types.DualStack,
+			map[string]uint32{"v4-a": 10, "v4-b": 10, "v6-a": 10, "v6-b": 10},
+			[]string{"v4-b", "v6-b"},
+			false,
+			false,
+		},
+		{
+			"skip exhausted subnet",
+			"Deployment/web",
+			types.IPv4Only,
+			map[string]uint32{"v4-a": 10, "v4-c": 10},
+			[]string{"v4-c"},
+			false,
+			false,
+		},
+		{
+			"fall back if all available subnets are occupied",
+			"Deployment/web",
+			types.IPv6Only,
+			map[string]uint32{"v6-a": 10},
+			nil,
+			false,
+			true,
+		},
+		{
+			"invalid workloads",
+			"web",
+			types.IPv4Only,
+			nil,
+			nil,
+			true,
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			r := &PodReconciler{
+				Client:      &specifiedSubnetClient{subnets: subnets, ipInstances: ipInstances},
+				Recorder:    recorder,
+				IPAMManager: &specifiedSubnetIPAMManager{available: test.available},
+			}
+
+			subnetNames, decision, err := r.selectSubnetsByAntiAffinity(pod, "network1", test.ipFamily, test.workloads)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v but got %v", test.expectErr, err)
+			}
+			if test.expectErr && !IsPermanentError(err) {
+				t.Errorf("expected permanent error but got %v", err)
+			}
+			if !reflect.DeepEqual(subnetNames, test.expectedSubnets) {
+				t.Errorf("expected subnets %v but got %v", test.expectedSubnets, subnetNames)
+			}
+			if !test.expectErr && len(decision) == 0 {
+				t.Errorf("expected decision of anti-affinity but got nothing")
+			}
+			if warned := len(recorder.Events) > 0; warned != test.expectWarning {
+				t.Errorf("expected warning %v but got %v", test.expectWarning, warned)
+			}
+		})
+	}
+}
+
+func TestWaitForNetworkResuming(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1", UID: "pod1-uid"},
+	}
+	recorder := record.NewFakeRecorder(10)
+	r := &PodReconciler{
+		Recorder:                       recorder,
+		AllocationFailureEventInterval: time.Minute,
+		allocationFailureEvents:        cache.NewLRUExpireCache(10),
+	}
+
+	countEvents := func() (count int) {
+		for len(recorder.Events) > 0 {
+			<-recorder.Events
+			count++
+		}
+		return
+	}
+
+	// pod is requeued while network is paused, which should be told only once
+	for i := 0; i < 3; i++ {
+		if result := r.waitForNetworkResuming(pod, "network1"); result.RequeueAfter != networkPausedRequeueInterval {
+			t.Fatalf("expected requeue after %v but got %v", networkPausedRequeueInterval, result.RequeueAfter)
+		}
+		r.forgetAllocationFailureEvent(pod, metrics.PodReconcileOutcomePaused)
+	}
+	if count := countEvents(); count != 1 {
+		t.Errorf("expected one event while network is paused but got %d", count)
+	}
+
+	// events are allowed again once pod is reconciled successfully
+	r.forgetAllocationFailureEvent(pod, metrics.PodReconcileOutcomeAllocated)
+	r.waitForNetworkResuming(pod, "network1")
+	if count := countEvents(); count != 1 {
+		t.Errorf("expected one event after pod is reconciled but got %d", count)
+	}
+}
+
+// failureReportClient applies the patches of annotations and status to pod in place, and counts them
+type failureReportClient struct {
+	client.Client
+	patches int
+}
+
+func (c *failureReportClient) Patch(_ context.Context, obj client.Object, patch client.Patch, _ ...client.PatchOption) error {
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	c.patches++
+	return json.Unmarshal(data, obj)
+}
+
+func (c *failureReportClient) Status() client.StatusWriter {
+	return &failureReportStatusWriter{client: c}
+}
+
+type failureReportStatusWriter struct {
+	client.StatusWriter
+	client *failureReportClient
+}
+
+func (w *failureReportStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return w.client.Patch(ctx, obj, patch, opts...)
+}
+
+func TestReportAllocationFailure(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1", UID: "pod1-uid"},
+	}
+	c := &failureReportClient{}
+	recorder := record.NewFakeRecorder(10)
+	r := &PodReconciler{
+		Client:                         c,
+		Recorder:                       recorder,
+		AllocationFailureEventInterval: time.Minute,
+		allocationFailureEvents:        cache.NewLRUExpireCache(10),
+	}
+
+	countEvents := func() (count int) {
+		for len(recorder.Events) > 0 {
+			<-recorder.Events
+			count++
+		}
+		return
+	}
+	conditionReason := func() string {
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == constants.PodConditionIPAllocated {
+				return condition.Reason
+			}
+		}
+		return ""
+	}
+
+	r.reportAllocationFailure(context.Background(), pod, "network1", errors.New("apiserver is unavailable"))
+	if count := countEvents(); count != 1 {
+		t.Errorf("expected one event of the first failure but got %d", count)
+	}
+	if reason := conditionReason(); reason != constants.ReasonIPAllocationFailed {
+		t.Errorf("expected condition reason %s but got %q", constants.ReasonIPAllocationFailed, reason)
+	}
+
+	// exhaustion following within interval is not warned again, but still marked on pod
+	exhausted := fmt.Errorf("unable to allocate ip: %w", types.ErrNoAvailableIP)
+	r.reportAllocationFailure(context.Background(), pod, "network1", exhausted)
+	if count := countEvents(); count != 0 {
+		t.Errorf("expected event throttled but got %d", count)
+	}
+	if network := pod.Annotations[constants.AnnotationNetworkExhausted]; network != "network1" {
+		t.Errorf("expected network exhausted annotation of network1 but got %q", network)
+	}
+	if reason := conditionReason(); reason != constants.ReasonNetworkExhausted {
+		t.Errorf("expected condition reason %s but got %q", constants.ReasonNetworkExhausted, reason)
+	}
+
+	// the same failure is not patched again
+	patches := c.patches
+	r.reportAllocationFailure(context.Background(), pod, "network1", exhausted)
+	if c.patches != patches {
+		t.Errorf("expected no patch for unchanged failure but got %d", c.patches-patches)
+	}
+}
+
+func TestReportPermanentFailure(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1", UID: "pod1-uid"},
+	}
+	c := &failureReportClient{}
+	recorder := record.NewFakeRecorder(10)
+	r := &PodReconciler{
+		Client:                 c,
+		Recorder:               recorder,
+		permanentFailureEvents: cache.NewLRUExpireCache(10),
+	}
+
+	countEvents := func() (count int) {
+		for len(recorder.Events) > 0 {
+			<-recorder.Events
+			count++
+		}
+		return
+	}
+
+	r.reportPermanentFailure(context.Background(), pod, newPermanentError("no available ip in ip-pool a"))
+	if count := countEvents(); count != 1 {
+		t.Errorf("expected one event of the first failure but got %d", count)
+	}
+
+	// changed failure within interval is not warned again, but the annotation follows it
+	changed := newPermanentError("no available ip in ip-pool b")
+	r.reportPermanentFailure(context.Background(), pod, changed)
+	if count := countEvents(); count != 0 {
+		t.Errorf("expected event throttled but got %d", count)
+	}
+	if failure := pod.Annotations[constants.AnnotationAllocationFailure]; failure != changed.Error() {
+		t.Errorf("expected allocation failure annotation %q but got %q", changed.Error(), failure)
+	}
+	if !utils.PodIPAllocationFailureIsRecorded(pod, constants.ReasonIPAllocationFailed, changed.Error()) {
+		t.Errorf("expected condition following the changed failure but got %+v", pod.Status.Conditions)
+	}
+}
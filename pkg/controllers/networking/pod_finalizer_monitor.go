@@ -0,0 +1,100 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/metrics"
+)
+
+const MonitorStuckFinalizer = "StuckFinalizerMonitor"
+
+var _ manager.Runnable = &StuckFinalizerMonitor{}
+
+// StuckFinalizerMonitor periodically exposes the number of terminating pods whose ip finalizer has not
+// been removed longer than threshold, which usually means the finalizer keeps failing to be removed
+type StuckFinalizerMonitor struct {
+	client.Client
+	Logger logr.Logger
+
+	// Threshold is the time for a pod to be terminating with ip finalizer before it is counted as stuck,
+	// it starts from the deletion timestamp, i.e., after grace period
+	Threshold time.Duration
+	// Period is the interval of checking, one minute by default
+	Period time.Duration
+
+	// stuck records the pods counted in last round to only log the new ones
+	stuck map[apitypes.NamespacedName]bool
+}
+
+func (r *StuckFinalizerMonitor) Start(ctx context.Context) error {
+	r.Logger.Info("stuck finalizer monitor is starting")
+
+	if r.Period <= 0 {
+		r.Period = time.Minute
+	}
+	r.stuck = map[apitypes.NamespacedName]bool{}
+
+	wait.UntilWithContext(ctx, func(c context.Context) {
+		podList := &corev1.PodList{}
+		if err := r.List(c, podList); err != nil {
+			r.Logger.Error(err, "unable to list pods")
+			return
+		}
+
+		r.check(podList, time.Now())
+	}, r.Period)
+
+	r.Logger.Info("stuck finalizer monitor is stopping")
+	return nil
+}
+
+func (r *StuckFinalizerMonitor) check(podList *corev1.PodList, now time.Time) {
+	var stuck = map[apitypes.NamespacedName]bool{}
+	for i := range podList.Items {
+		var pod = &podList.Items[i]
+		if pod.DeletionTimestamp.IsZero() || !controllerutil.ContainsFinalizer(pod, constants.FinalizerIPAllocated) {
+			continue
+		}
+
+		age := now.Sub(pod.DeletionTimestamp.Time)
+		if age < r.Threshold {
+			continue
+		}
+
+		key := apitypes.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+		stuck[key] = true
+		if !r.stuck[key] {
+			r.Logger.Info("pod is stuck terminating by ip finalizer", "namespace", pod.Namespace, "name", pod.Name,
+				"age", age.Round(time.Second))
+		}
+	}
+
+	r.stuck = stuck
+	metrics.PodFinalizerStuckGauge.Set(float64(len(stuck)))
+}
@@ -0,0 +1,170 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/controllers/concurrency"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+)
+
+const ControllerPodIPConfigMap = "PodIPConfigMap"
+
+const ReasonPodIPConfigMapConflicted = "PodIPConfigMapConflicted"
+
+// PodIPConfigMapReconciler maintains a ConfigMap in each namespace, which maps name of pod to its
+// ips joined by comma, for legacy consumers which can not read annotations of pods. The ConfigMap
+// is rebuilt from the bound ip instances of namespace, so pods gone are pruned and there is only
+// one ConfigMap for each namespace. Only the ConfigMaps created here, which are labeled by
+// constants.LabelPodIPConfigMap, are updated, the ones of the same name created by users are left alone.
+type PodIPConfigMapReconciler struct {
+	client.Client
+	APIReader client.Reader
+	Recorder  record.EventRecorder
+
+	// ConfigMapName is the name of ConfigMap in each namespace
+	ConfigMapName string
+
+	concurrency.ControllerConcurrency
+}
+
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;create;update
+
+func (r *PodIPConfigMapReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+
+	var namespace = &corev1.Namespace{}
+	if err := r.Get(ctx, req.NamespacedName, namespace); err != nil {
+		return ctrl.Result{}, wrapError("unable to fetch Namespace", client.IgnoreNotFound(err))
+	}
+	if !namespace.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	ipInstanceList, err := utils.ListIPInstances(r, client.InNamespace(namespace.Name))
+	if err != nil {
+		return ctrl.Result{}, wrapError("unable to list ip instances", err)
+	}
+
+	var podIPs = map[string][]string{}
+	for i := range ipInstanceList.Items {
+		var ipInstance = &ipInstanceList.Items[i]
+		if !ipInstance.DeletionTimestamp.IsZero() || !networkingv1.IsUsingPhase(ipInstance.Status.Phase) ||
+			len(ipInstance.Status.PodName) == 0 {
+			continue
+		}
+		podIPs[ipInstance.Status.PodName] = append(podIPs[ipInstance.Status.PodName], utils.ToIPFormat(ipInstance.Name))
+	}
+
+	var data = make(map[string]string, len(podIPs))
+	for podName, ips := range podIPs {
+		sort.Strings(ips)
+		data[podName] = strings.Join(ips, ",")
+	}
+
+	// ConfigMaps are not cached, because only few of them are managed here
+	var configMap = &corev1.ConfigMap{}
+	if err = r.APIReader.Get(ctx, types.NamespacedName{Namespace: namespace.Name, Name: r.ConfigMapName}, configMap); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, wrapError("unable to fetch ConfigMap", err)
+		}
+		if len(data) == 0 {
+			return ctrl.Result{}, nil
+		}
+
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace.Name,
+				Name:      r.ConfigMapName,
+				Labels:    map[string]string{constants.LabelPodIPConfigMap: "true"},
+			},
+			Data: data,
+		}
+		if err = r.Create(ctx, configMap); err != nil {
+			return ctrl.Result{}, wrapError("unable to create ConfigMap", err)
+		}
+		log.V(4).Info(fmt.Sprintf("create pod ip ConfigMap with %d pods", len(data)))
+		return ctrl.Result{}, nil
+	}
+
+	if !metav1.HasLabel(configMap.ObjectMeta, constants.LabelPodIPConfigMap) {
+		r.Recorder.Eventf(namespace, corev1.EventTypeWarning, ReasonPodIPConfigMapConflicted,
+			"ConfigMap %s is not maintained by hybridnet, pod ips are not written to it", r.ConfigMapName)
+		return ctrl.Result{}, nil
+	}
+
+	// diff for no-op
+	if reflect.DeepEqual(configMap.Data, data) || (len(configMap.Data) == 0 && len(data) == 0) {
+		return ctrl.Result{}, nil
+	}
+
+	configMap.Data = data
+	if err = r.Update(ctx, configMap); err != nil {
+		return ctrl.Result{}, wrapError("unable to update ConfigMap", err)
+	}
+
+	log.V(4).Info(fmt.Sprintf("update pod ip ConfigMap with %d pods", len(data)))
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *PodIPConfigMapReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(ControllerPodIPConfigMap).
+		For(&corev1.Namespace{},
+			builder.WithPredicates(
+				&utils.IgnoreDeletePredicate{},
+				&utils.IgnoreUpdatePredicate{},
+			)).
+		Watches(&source.Kind{Type: &networkingv1.IPInstance{}},
+			handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+				return []reconcile.Request{
+					{
+						NamespacedName: types.NamespacedName{
+							Name: object.GetNamespace(),
+						},
+					},
+				}
+			}),
+		).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.Max(),
+		}).
+		Complete(r)
+}
@@ -0,0 +1,176 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+)
+
+// configMapClient serves a namespace and its ip instances, and records the ConfigMaps written
+type configMapClient struct {
+	client.Client
+	ipInstances []networkingv1.IPInstance
+	created     *corev1.ConfigMap
+	updated     *corev1.ConfigMap
+}
+
+func (c *configMapClient) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	if namespace, ok := obj.(*corev1.Namespace); ok && key.Name == "default" {
+		namespace.Name = key.Name
+		return nil
+	}
+	return apierrors.NewNotFound(schema.GroupResource{}, key.Name)
+}
+
+func (c *configMapClient) List(_ context.Context, list client.ObjectList, _ ...client.ListOption) error {
+	list.(*networkingv1.IPInstanceList).Items = append([]networkingv1.IPInstance{}, c.ipInstances...)
+	return nil
+}
+
+func (c *configMapClient) Create(_ context.Context, obj client.Object, _ ...client.CreateOption) error {
+	c.created = obj.(*corev1.ConfigMap).DeepCopy()
+	return nil
+}
+
+func (c *configMapClient) Update(_ context.Context, obj client.Object, _ ...client.UpdateOption) error {
+	c.updated = obj.(*corev1.ConfigMap).DeepCopy()
+	return nil
+}
+
+// configMapReader serves the existing ConfigMap if any
+type configMapReader struct {
+	client.Reader
+	configMap *corev1.ConfigMap
+}
+
+func (r *configMapReader) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	if r.configMap == nil || key.Name != r.configMap.Name {
+		return apierrors.NewNotFound(schema.GroupResource{}, key.Name)
+	}
+	r.configMap.DeepCopyInto(obj.(*corev1.ConfigMap))
+	return nil
+}
+
+func TestPodIPConfigMapReconcile(t *testing.T) {
+	ipInstances := []networkingv1.IPInstance{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "192-168-0-1"},
+			Status:     networkingv1.IPInstanceStatus{Phase: networkingv1.IPPhaseUsing, PodName: "pod1"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "192-168-0-2"},
+			Status:     networkingv1.IPInstanceStatus{Phase: networkingv1.IPPhaseReserved, PodName: "pod2"},
+		},
+	}
+	expectedData := map[string]string{"pod1": "192.168.0.1"}
+
+	configMapOf := func(labels map[string]string, data map[string]string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod-ips", Labels: labels},
+			Data:       data,
+		}
+	}
+	managed := map[string]string{constants.LabelPodIPConfigMap: "true"}
+
+	tests := []struct {
+		name          string
+		existing      *corev1.ConfigMap
+		expectCreated bool
+		expectUpdated bool
+		expectEvent   bool
+	}{
+		{
+			"absent",
+			nil,
+			true,
+			false,
+			false,
+		},
+		{
+			"managed and outdated",
+			configMapOf(managed, map[string]string{"pod2": "192.168.0.2"}),
+			false,
+			true,
+			false,
+		},
+		{
+			"managed and up to date",
+			configMapOf(managed, expectedData),
+			false,
+			false,
+			false,
+		},
+		{
+			"managed by user",
+			configMapOf(nil, map[string]string{"user-key": "user-value"}),
+			false,
+			false,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &configMapClient{ipInstances: ipInstances}
+			recorder := record.NewFakeRecorder(10)
+			r := &PodIPConfigMapReconciler{
+				Client:        c,
+				APIReader:     &configMapReader{configMap: test.existing},
+				Recorder:      recorder,
+				ConfigMapName: "pod-ips",
+			}
+
+			if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "default"}}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if (c.created != nil) != test.expectCreated {
+				t.Errorf("expect created %v, got %v", test.expectCreated, c.created)
+			}
+			if c.created != nil {
+				if !reflect.DeepEqual(c.created.Data, expectedData) || c.created.Labels[constants.LabelPodIPConfigMap] != "true" {
+					t.Errorf("expect labeled ConfigMap with %v created, got %v", expectedData, c.created)
+				}
+			}
+
+			if (c.updated != nil) != test.expectUpdated {
+				t.Errorf("expect updated %v, got %v", test.expectUpdated, c.updated)
+			}
+			if c.updated != nil && !reflect.DeepEqual(c.updated.Data, expectedData) {
+				t.Errorf("expect ConfigMap updated with %v, got %v", expectedData, c.updated.Data)
+			}
+
+			if (len(recorder.Events) > 0) != test.expectEvent {
+				t.Errorf("expect event %v, got %d events", test.expectEvent, len(recorder.Events))
+			}
+		})
+	}
+}
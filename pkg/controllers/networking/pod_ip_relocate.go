@@ -0,0 +1,161 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/feature"
+	"github.com/alibaba/hybridnet/pkg/ipam/strategy"
+	"github.com/alibaba/hybridnet/pkg/metrics"
+	"github.com/alibaba/hybridnet/pkg/utils/transform"
+)
+
+const ReasonIPRelocated = "IPRelocated"
+
+// policies of ips left on another node by pods with ip annotation
+const (
+	// StaleNodeIPPolicyRelocate relocates ips to the current node of pod if they are still routable
+	// there, which are the ones of overlay network and of bgp network the node is in, the others
+	// are reallocated
+	StaleNodeIPPolicyRelocate = "relocate"
+	// StaleNodeIPPolicyReallocate always reallocates ips for pod
+	StaleNodeIPPolicyReallocate = "reallocate"
+)
+
+// handleIPsOnStaleNode takes care of pod which has ip annotation while its ip instances are still
+// bound to another node, e.g., pod is re-created with the same name and annotations on a different
+// node, or the node of an allocated pod is reassigned, otherwise daemon on the current node will never
+// find the ip instances. IPs are relocated to the current node if the policy allows, or else they are
+// released, or reserved for stateful pod, and will be allocated again. The outcome of what is done is
+// returned, empty means no ip is on a stale node.
+func (r *PodReconciler) handleIPsOnStaleNode(ctx context.Context, pod *corev1.Pod) (outcome string, err error) {
+	var ipInstances []*networkingv1.IPInstance
+	if ipInstances, err = listBoundIPInstancesOfPod(r, pod); err != nil {
+		return "", fmt.Errorf("unable to list ip instances of pod: %v", err)
+	}
+	if len(staleNodeOfIPInstances(pod, ipInstances)) == 0 {
+		return "", nil
+	}
+
+	// ip instances in cache might be out of date, check again before touching them
+	if ipInstances, err = listBoundIPInstancesOfPod(r.APIReader, pod); err != nil {
+		return "", fmt.Errorf("unable to list ip instances of pod: %v", err)
+	}
+	staleNode := staleNodeOfIPInstances(pod, ipInstances)
+	if len(staleNode) == 0 {
+		return "", nil
+	}
+
+	networkName := ipInstances[0].Spec.Network
+	network, err := utils.GetNetwork(r, networkName)
+	if err != nil {
+		return "", fmt.Errorf("unable to get network %s: %v", networkName, err)
+	}
+
+	var relocatable bool
+	if relocatable, err = r.ipsRelocatable(network, pod.Spec.NodeName); err != nil {
+		return "", fmt.Errorf("unable to check ips of network %s on node %s: %v", networkName, pod.Spec.NodeName, err)
+	}
+	if !relocatable {
+		if strategy.OwnByStatefulWorkload(pod) {
+			return metrics.PodReconcileOutcomeReserved, wrapError("unable to reserve ips on stale node", r.reserve(pod))
+		}
+		return metrics.PodReconcileOutcomeDecoupled, wrapError("unable to decouple ips on stale node", r.decouple(pod))
+	}
+
+	ips := transform.TransferIPInstancesForIPAM(ipInstances)
+	if feature.DualStackEnabled() {
+		err = r.IPAMStore.DualStack().ReCouple(pod, ips)
+	} else {
+		err = r.IPAMStore.ReCouple(pod, ips[0])
+	}
+	if err != nil {
+		return metrics.PodReconcileOutcomeRelocated, fmt.Errorf("unable to relocate ips from node %s: %v", staleNode, err)
+	}
+
+	r.Recorder.Eventf(pod, corev1.EventTypeNormal, ReasonIPRelocated, "relocate IPs %v from node %s successfully",
+		squashIPSliceToIPs(ips), staleNode)
+	r.recordAllocationEvent(pod, AllocationEventActionAssign, networkName, ips, fmt.Sprintf(", relocated from node %s", staleNode), false)
+	return metrics.PodReconcileOutcomeRelocated, nil
+}
+
+// ipsRelocatable returns whether the ips of network can be moved to node by policy, ips of underlay
+// vlan network are bound to the subnets of nodes and have to be reallocated
+func (r *PodReconciler) ipsRelocatable(network *networkingv1.Network, nodeName string) (bool, error) {
+	if r.StaleNodeIPPolicy == StaleNodeIPPolicyReallocate {
+		return false, nil
+	}
+
+	switch {
+	case networkingv1.GetNetworkType(network) == networkingv1.NetworkTypeOverlay:
+		return true, nil
+	case networkingv1.GetNetworkMode(network) == networkingv1.NetworkModeBGP:
+		// ips of bgp network are advertised by the node they are on, which must be in network
+		networkList, err := utils.ListNetworks(r, client.MatchingFields{IndexerFieldNode: nodeName})
+		if err != nil {
+			return false, err
+		}
+		for i := range networkList.Items {
+			if networkList.Items[i].Name == network.Name {
+				return true, nil
+			}
+		}
+		return false, nil
+	default:
+		return false, nil
+	}
+}
+
+// listBoundIPInstancesOfPod lists the ip instances bound to pod by pod label, which is cheaper
+// than listing all the ip instances in namespace of pod
+func listBoundIPInstancesOfPod(c client.Reader, pod *corev1.Pod) ([]*networkingv1.IPInstance, error) {
+	ipList, err := utils.ListIPInstances(c, client.InNamespace(pod.Namespace), client.MatchingLabels{
+		constants.LabelPod: pod.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var ipInstances []*networkingv1.IPInstance
+	for i := range ipList.Items {
+		var ipInstance = &ipList.Items[i]
+		if ipInstance.DeletionTimestamp.IsZero() && ipInstance.Status.PodName == pod.Name &&
+			networkingv1.IsUsingPhase(ipInstance.Status.Phase) {
+			ipInstances = append(ipInstances, ipInstance)
+		}
+	}
+	return ipInstances, nil
+}
+
+// staleNodeOfIPInstances returns the node which bound ip instances of pod are on if it is not the
+// node of pod, empty means no ip instance is on a stale node
+func staleNodeOfIPInstances(pod *corev1.Pod, ipInstances []*networkingv1.IPInstance) string {
+	for _, ipInstance := range ipInstances {
+		if len(ipInstance.Status.NodeName) > 0 && ipInstance.Status.NodeName != pod.Spec.NodeName {
+			return ipInstance.Status.NodeName
+		}
+	}
+	return ""
+}
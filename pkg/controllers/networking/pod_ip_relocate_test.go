@@ -0,0 +1,160 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/ipam/types"
+	"github.com/alibaba/hybridnet/pkg/metrics"
+)
+
+// relocateClient serves an overlay network and the ip instances of pod
+type relocateClient struct {
+	client.Client
+	ipInstances []networkingv1.IPInstance
+}
+
+func (c *relocateClient) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	if network, ok := obj.(*networkingv1.Network); ok && key.Name == "overlay" {
+		network.Name = key.Name
+		network.Spec.Type = networkingv1.NetworkTypeOverlay
+		return nil
+	}
+	return apierrors.NewNotFound(schema.GroupResource{}, key.Name)
+}
+
+func (c *relocateClient) List(_ context.Context, list client.ObjectList, _ ...client.ListOption) error {
+	list.(*networkingv1.IPInstanceList).Items = append([]networkingv1.IPInstance{}, c.ipInstances...)
+	return nil
+}
+
+// relocateStore records the pods recoupled and decoupled
+type relocateStore struct {
+	IPAMStore
+	recoupled []string
+	decoupled []string
+}
+
+func (s *relocateStore) ReCouple(pod *corev1.Pod, _ *types.IP) error {
+	s.recoupled = append(s.recoupled, pod.Name)
+	return nil
+}
+
+func (s *relocateStore) DeCouple(pod *corev1.Pod) error {
+	s.decoupled = append(s.decoupled, pod.Name)
+	return nil
+}
+
+func TestHandleIPsOnStaleNodeOutcome(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod"},
+		Spec:       corev1.PodSpec{NodeName: "node2"},
+	}
+	ipInstanceOn := func(node string) networkingv1.IPInstance {
+		return networkingv1.IPInstance{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "100-64-0-1",
+				Labels:    map[string]string{constants.LabelPod: "pod"},
+			},
+			Spec: networkingv1.IPInstanceSpec{
+				Network: "overlay",
+				Subnet:  "subnet",
+				Address: networkingv1.Address{IP: "100.64.0.1/16", Version: networkingv1.IPv4},
+			},
+			Status: networkingv1.IPInstanceStatus{
+				Phase:        networkingv1.IPPhaseUsing,
+				NodeName:     node,
+				PodName:      "pod",
+				PodNamespace: "default",
+			},
+		}
+	}
+
+	tests := []struct {
+		name            string
+		node            string
+		policy          string
+		expectOutcome   string
+		expectRecoupled bool
+		expectDecoupled bool
+	}{
+		{
+			"ips on node of pod",
+			"node2",
+			StaleNodeIPPolicyRelocate,
+			"",
+			false,
+			false,
+		},
+		{
+			"ips relocated",
+			"node1",
+			StaleNodeIPPolicyRelocate,
+			metrics.PodReconcileOutcomeRelocated,
+			true,
+			false,
+		},
+		{
+			"ips reallocated",
+			"node1",
+			StaleNodeIPPolicyReallocate,
+			metrics.PodReconcileOutcomeDecoupled,
+			false,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &relocateClient{ipInstances: []networkingv1.IPInstance{ipInstanceOn(test.node)}}
+			store := &relocateStore{}
+			r := &PodReconciler{
+				Client:            c,
+				APIReader:         c,
+				Recorder:          record.NewFakeRecorder(10),
+				IPAMStore:         store,
+				StaleNodeIPPolicy: test.policy,
+			}
+
+			outcome, err := r.handleIPsOnStaleNode(context.Background(), pod.DeepCopy())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if outcome != test.expectOutcome {
+				t.Errorf("expect outcome %q, got %q", test.expectOutcome, outcome)
+			}
+			if (len(store.recoupled) > 0) != test.expectRecoupled {
+				t.Errorf("expect recoupled %v, got %v", test.expectRecoupled, store.recoupled)
+			}
+			if (len(store.decoupled) > 0) != test.expectDecoupled {
+				t.Errorf("expect decoupled %v, got %v", test.expectDecoupled, store.decoupled)
+			}
+		})
+	}
+}
@@ -0,0 +1,380 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/controllers/concurrency"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/feature"
+	"github.com/alibaba/hybridnet/pkg/ipam/types"
+	"github.com/alibaba/hybridnet/pkg/utils/transform"
+)
+
+const ControllerIPSwap = "IPSwap"
+
+const (
+	ReasonIPSwapStarted   = "IPSwapStarted"
+	ReasonIPSwapSucceed   = "IPSwapSucceed"
+	ReasonIPSwapFail      = "IPSwapFail"
+	ReasonIPSwapCancelled = "IPSwapCancelled"
+)
+
+// ipSwapCleanupRequeueInterval is the interval of checking whether daemon has removed the original
+// address of the last swap, before a new swap starts
+const ipSwapCleanupRequeueInterval = 5 * time.Second
+
+// IPSwapReconciler swaps the ip of a running pod for a new one on request of pod annotation. The new ip
+// is allocated and bound by its own ip instance, which is configured on the nic of pod by daemon along with
+// the original ip, and advertised like other ips of node. After the dual-homed period, the new ip replaces
+// the original one on pod, and the original ip is released and removed from the nic by daemon.
+type IPSwapReconciler struct {
+	APIReader client.Reader
+	client.Client
+
+	Recorder record.EventRecorder
+
+	IPAMStore   IPAMStore
+	IPAMManager IPAMManager
+
+	// DualHomedPeriod is the min period that pod holds both ips, counted from the swap starts
+	DualHomedPeriod time.Duration
+
+	// BindTimeout is how long to wait for daemon to configure the new ip before the swap is cancelled
+	BindTimeout time.Duration
+
+	concurrency.ControllerConcurrency
+}
+
+func (r *IPSwapReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	log := ctrllog.FromContext(ctx)
+
+	defer func() {
+		if err != nil {
+			log.Error(err, "reconciliation fails")
+		}
+	}()
+
+	pod := &corev1.Pod{}
+	if err = r.APIReader.Get(ctx, req.NamespacedName, pod); err != nil {
+		return ctrl.Result{}, wrapError("unable to fetch Pod", client.IgnoreNotFound(err))
+	}
+
+	// ip instances swapped in are owned by pod and released by garbage collection
+	if !pod.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	ipInstances, err := r.listIPInstances(ctx, pod, constants.LabelPod)
+	if err != nil {
+		return ctrl.Result{}, wrapError("unable to list ip instances of pod", err)
+	}
+
+	// an interrupted commit must be finished no matter whether swap is still requested
+	if to, from := committingIPInstances(ipInstances); to != nil && from != nil {
+		return ctrl.Result{}, wrapError("unable to commit ip swap", r.commit(pod, to, from))
+	}
+
+	swapIPInstances, err := r.listIPInstances(ctx, pod, constants.LabelSwapPod)
+	if err != nil {
+		return ctrl.Result{}, wrapError("unable to list swapped ip instances of pod", err)
+	}
+
+	targetSubnet, requested := pod.Annotations[constants.AnnotationIPSwap]
+	if !requested {
+		if len(swapIPInstances) > 0 {
+			return ctrl.Result{}, wrapError("unable to cancel ip swap", r.cancel(pod, swapIPInstances, "swap is no longer requested"))
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if len(swapIPInstances) > 0 {
+		return r.progress(ctx, pod, swapIPInstances, ipInstances)
+	}
+
+	// pod will be reconciled again once its ips are allocated and it is running
+	if !metav1.HasAnnotation(pod.ObjectMeta, constants.AnnotationIP) || pod.Status.Phase != corev1.PodRunning {
+		return ctrl.Result{}, nil
+	}
+
+	for i := range ipInstances {
+		if metav1.HasAnnotation(ipInstances[i].ObjectMeta, constants.AnnotationIPSwapFrom) {
+			log.V(4).Info("wait for daemon to remove the original address of last swap", "ipInstance", ipInstances[i].Name)
+			return ctrl.Result{RequeueAfter: ipSwapCleanupRequeueInterval}, nil
+		}
+	}
+
+	return ctrl.Result{}, wrapError("unable to start ip swap", r.start(pod, targetSubnet, ipInstances))
+}
+
+// start allocates the new ip for pod and binds it, only pods with a single ip can be swapped
+func (r *IPSwapReconciler) start(pod *corev1.Pod, targetSubnet string, ipInstances []*networkingv1.IPInstance) error {
+	if reason := ipSwapUnsupportedReason(pod, ipInstances); len(reason) > 0 {
+		return r.reject(pod, reason)
+	}
+
+	from := ipInstances[0]
+	if len(targetSubnet) == 0 {
+		targetSubnet = from.Spec.Subnet
+	}
+
+	subnet, err := utils.GetSubnet(r, targetSubnet)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return r.reject(pod, fmt.Sprintf("subnet %s is not found", targetSubnet))
+		}
+		return fmt.Errorf("unable to get subnet %s: %v", targetSubnet, err)
+	}
+	if subnet.Spec.Network != from.Spec.Network {
+		return r.reject(pod, fmt.Sprintf("subnet %s is not in network %s of pod", subnet.Name, from.Spec.Network))
+	}
+	if networkingv1.IsIPv6Subnet(subnet) != networkingv1.IsIPv6IPInstance(from) {
+		return r.reject(pod, fmt.Sprintf("subnet %s is not of the same ip family as ip %s", subnet.Name, from.Spec.Address.IP))
+	}
+
+	var ip *types.IP
+	fromIP := transform.TransferIPInstanceForIPAM(from)
+	if feature.DualStackEnabled() {
+		var ips []*types.IP
+		ipFamily := utils.ToIPFamilyMode(networkingv1.IsIPv6Subnet(subnet))
+		if ips, err = r.IPAMManager.DualStack().Allocate(ipFamily, subnet.Spec.Network, []string{subnet.Name},
+			pod.Name, pod.Namespace); err != nil {
+			return fmt.Errorf("unable to allocate ip to swap in: %v", err)
+		}
+		ip = ips[0]
+		if err = r.IPAMStore.DualStack().IPSwapBind(pod, ip, fromIP); err != nil {
+			_ = r.IPAMManager.DualStack().Release(ipFamily, ip.Network, []string{ip.Subnet}, []string{ip.Address.IP.String()})
+			return fmt.Errorf("unable to bind ip %s to swap in: %v", ip.Address.IP, err)
+		}
+	} else {
+		if ip, err = r.IPAMManager.Allocate(subnet.Spec.Network, subnet.Name, pod.Name, pod.Namespace); err != nil {
+			return fmt.Errorf("unable to allocate ip to swap in: %v", err)
+		}
+		if err = r.IPAMStore.IPSwapBind(pod, ip, fromIP); err != nil {
+			_ = r.IPAMManager.Release(ip.Network, ip.Subnet, ip.Address.IP.String())
+			return fmt.Errorf("unable to bind ip %s to swap in: %v", ip.Address.IP, err)
+		}
+	}
+
+	r.Recorder.Eventf(pod, corev1.EventTypeNormal, ReasonIPSwapStarted, "start to swap ip %s for %s, wait for nic configured",
+		fromIP.Address.IP, ip.Address.IP)
+	return nil
+}
+
+// progress commits the swap once the new ip is bound and the dual-homed period passes, the swap is
+// cancelled if the new ip is not bound in time or the original ip is gone
+func (r *IPSwapReconciler) progress(ctx context.Context, pod *corev1.Pod, swapIPInstances, ipInstances []*networkingv1.IPInstance) (ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+
+	to := swapIPInstances[0]
+	elapsed := time.Since(to.CreationTimestamp.Time)
+	if to.Status.Phase != networkingv1.IPPhaseBound {
+		if elapsed >= r.BindTimeout {
+			return ctrl.Result{}, wrapError("unable to cancel ip swap", r.cancel(pod, swapIPInstances,
+				fmt.Sprintf("ip %s is not configured on nic in %v", to.Spec.Address.IP, r.BindTimeout)))
+		}
+		log.V(4).Info("wait for daemon to configure the swapped ip", "ipInstance", to.Name)
+		return ctrl.Result{RequeueAfter: r.BindTimeout - elapsed}, nil
+	}
+
+	var from *networkingv1.IPInstance
+	for i := range ipInstances {
+		if ipInstances[i].Spec.Address.IP == to.Annotations[constants.AnnotationIPSwapFrom] {
+			from = ipInstances[i]
+		}
+	}
+	if from == nil {
+		return ctrl.Result{}, wrapError("unable to cancel ip swap", r.cancel(pod, swapIPInstances,
+			fmt.Sprintf("original ip %s is gone", to.Annotations[constants.AnnotationIPSwapFrom])))
+	}
+
+	if elapsed < r.DualHomedPeriod {
+		return ctrl.Result{RequeueAfter: r.DualHomedPeriod - elapsed}, nil
+	}
+
+	return ctrl.Result{}, wrapError("unable to commit ip swap", r.commit(pod, to, from))
+}
+
+// commit replaces the original ip of pod with the swapped one, the original ip is released by
+// the deletion of its ip instance
+func (r *IPSwapReconciler) commit(pod *corev1.Pod, to, from *networkingv1.IPInstance) (err error) {
+	toIP, fromIP := transform.TransferIPInstanceForIPAM(to), transform.TransferIPInstanceForIPAM(from)
+	if feature.DualStackEnabled() {
+		err = r.IPAMStore.DualStack().IPSwapCommit(pod, toIP, fromIP)
+	} else {
+		err = r.IPAMStore.IPSwapCommit(pod, toIP, fromIP)
+	}
+	if err != nil {
+		return err
+	}
+
+	r.Recorder.Eventf(pod, corev1.EventTypeNormal, ReasonIPSwapSucceed, "swap ip %s for %s successfully",
+		fromIP.Address.IP, toIP.Address.IP)
+	return nil
+}
+
+// cancel deletes the ip instances swapped in, which releases their ips, and removes the swap request
+func (r *IPSwapReconciler) cancel(pod *corev1.Pod, swapIPInstances []*networkingv1.IPInstance, reason string) error {
+	for _, ipInstance := range swapIPInstances {
+		if err := r.Delete(context.TODO(), ipInstance); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("unable to delete ip instance %s: %v", ipInstance.Name, err)
+		}
+	}
+
+	r.Recorder.Eventf(pod, corev1.EventTypeWarning, ReasonIPSwapCancelled, "ip swap is cancelled: %s", reason)
+	return r.removeSwapRequest(pod)
+}
+
+// reject refuses the swap request which can never succeed
+func (r *IPSwapReconciler) reject(pod *corev1.Pod, reason string) error {
+	r.Recorder.Eventf(pod, corev1.EventTypeWarning, ReasonIPSwapFail, "unable to swap ip: %s", reason)
+	return r.removeSwapRequest(pod)
+}
+
+func (r *IPSwapReconciler) removeSwapRequest(pod *corev1.Pod) error {
+	if !metav1.HasAnnotation(pod.ObjectMeta, constants.AnnotationIPSwap) {
+		return nil
+	}
+	patchBody := fmt.Sprintf(`{"metadata":{"annotations":{%q:null}}}`, constants.AnnotationIPSwap)
+	return client.IgnoreNotFound(r.Patch(context.TODO(), pod, client.RawPatch(apitypes.MergePatchType, []byte(patchBody))))
+}
+
+// listIPInstances lists the ip instances of pod by label, the ones being deleted are ignored, and so are
+// the swapped ones of previous pod with the same name
+func (r *IPSwapReconciler) listIPInstances(ctx context.Context, pod *corev1.Pod, labelKey string) ([]*networkingv1.IPInstance, error) {
+	// read from apiserver to avoid allocating or committing twice with a stale cache
+	ipInstanceList := &networkingv1.IPInstanceList{}
+	if err := r.APIReader.List(ctx, ipInstanceList, client.InNamespace(pod.Namespace),
+		client.MatchingLabels{labelKey: pod.Name}); err != nil {
+		return nil, err
+	}
+
+	var ipInstances []*networkingv1.IPInstance
+	for i := range ipInstanceList.Items {
+		var ipInstance = &ipInstanceList.Items[i]
+		if !ipInstance.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if labelKey == constants.LabelSwapPod && !metav1.IsControlledBy(ipInstance, pod) {
+			continue
+		}
+		ipInstances = append(ipInstances, ipInstance)
+	}
+	return ipInstances, nil
+}
+
+// ipSwapUnsupportedReason tells why the ip of pod can not be swapped, empty means it can be. Swapping a
+// dual-stack pod or a pod with multiple ips would leave the pod half swapped if any ip fails, so it is
+// rejected rather than swapped ip by ip.
+func ipSwapUnsupportedReason(pod *corev1.Pod, ipInstances []*networkingv1.IPInstance) string {
+	var v4Count, v6Count int
+	for _, ipInstance := range ipInstances {
+		if networkingv1.IsIPv6IPInstance(ipInstance) {
+			v6Count++
+		} else {
+			v4Count++
+		}
+	}
+
+	switch {
+	case v4Count > 0 && v6Count > 0:
+		return "dual-stack pod can not be swapped"
+	case len(ipInstances) != 1:
+		return fmt.Sprintf("only pod with a single ip can be swapped, but it has %d", len(ipInstances))
+	case metav1.HasAnnotation(pod.ObjectMeta, constants.AnnotationRouteTable):
+		// routes of pod in the selected table are only configured on cni add
+		return "pod with a selected route table can not be swapped"
+	default:
+		return ""
+	}
+}
+
+// committingIPInstances returns the ip instance which has been handed over to pod by an interrupted
+// commit, and the original one left
+func committingIPInstances(ipInstances []*networkingv1.IPInstance) (to, from *networkingv1.IPInstance) {
+	for _, ipInstance := range ipInstances {
+		fromAddress, exist := ipInstance.Annotations[constants.AnnotationIPSwapFrom]
+		if !exist {
+			continue
+		}
+		for _, candidate := range ipInstances {
+			if candidate.Spec.Address.IP == fromAddress {
+				return ipInstance, candidate
+			}
+		}
+	}
+	return nil, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IPSwapReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(ControllerIPSwap).
+		For(&corev1.Pod{},
+			builder.WithPredicates(
+				&utils.IgnoreDeletePredicate{},
+				&predicate.ResourceVersionChangedPredicate{},
+				predicate.NewPredicateFuncs(func(obj client.Object) bool {
+					pod, ok := obj.(*corev1.Pod)
+					return ok && metav1.HasAnnotation(pod.ObjectMeta, constants.AnnotationIPSwap)
+				}),
+			)).
+		Watches(&source.Kind{Type: &networkingv1.IPInstance{}},
+			handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+				podName := object.GetLabels()[constants.LabelSwapPod]
+				if _, exist := object.GetAnnotations()[constants.AnnotationIPSwapFrom]; exist && len(podName) == 0 {
+					podName = object.GetLabels()[constants.LabelPod]
+				}
+				if len(podName) == 0 {
+					return nil
+				}
+				return []reconcile.Request{
+					{
+						NamespacedName: apitypes.NamespacedName{
+							Namespace: object.GetNamespace(),
+							Name:      podName,
+						},
+					},
+				}
+			}),
+			builder.WithPredicates(&utils.IgnoreDeletePredicate{}),
+		).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.Max(),
+		}).
+		Complete(r)
+}
@@ -0,0 +1,470 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/ipam/types"
+	globalutils "github.com/alibaba/hybridnet/pkg/utils"
+)
+
+const (
+	swapPodUID        = apitypes.UID("swap-pod-uid")
+	swapOriginalIP    = "192.168.0.10/24"
+	swapSwappedInIP   = "192.168.0.20/24"
+	swapOriginalIPv6  = "fe80::10/64"
+	swapTestSubnet    = "subnet1"
+	swapTestNetwork   = "network1"
+	swapBindTimeout   = time.Minute
+	swapDualHomedTime = 10 * time.Minute
+)
+
+func swapPod(annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "pod1",
+			UID:         swapPodUID,
+			Annotations: annotations,
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func swapIPInstance(name, address string, version networkingv1.IPVersion, labels, annotations map[string]string) *networkingv1.IPInstance {
+	return &networkingv1.IPInstance{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        name,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Spec: networkingv1.IPInstanceSpec{
+			Network: swapTestNetwork,
+			Subnet:  swapTestSubnet,
+			Address: networkingv1.Address{IP: address, Version: version},
+		},
+	}
+}
+
+// swappedInIPInstance builds the ip instance swapped in for pod, which is controlled by pod
+func swappedInIPInstance(phase networkingv1.IPPhase, age time.Duration) *networkingv1.IPInstance {
+	ipInstance := swapIPInstance("swapped-in", swapSwappedInIP, networkingv1.IPv4,
+		map[string]string{constants.LabelSwapPod: "pod1"},
+		map[string]string{constants.AnnotationIPSwapFrom: swapOriginalIP})
+	ipInstance.CreationTimestamp = metav1.NewTime(time.Now().Add(-age))
+	ipInstance.OwnerReferences = []metav1.OwnerReference{
+		{APIVersion: "v1", Kind: "Pod", Name: "pod1", UID: swapPodUID, Controller: pointer.BoolPtr(true)},
+	}
+	ipInstance.Status.Phase = phase
+	return ipInstance
+}
+
+func originalIPInstance() *networkingv1.IPInstance {
+	return swapIPInstance("original", swapOriginalIP, networkingv1.IPv4,
+		map[string]string{constants.LabelPod: "pod1"}, nil)
+}
+
+func TestIPSwapUnsupportedReason(t *testing.T) {
+	tests := []struct {
+		name        string
+		pod         *corev1.Pod
+		ipInstances []*networkingv1.IPInstance
+		expected    string
+	}{
+		{
+			"single ip",
+			swapPod(nil),
+			[]*networkingv1.IPInstance{originalIPInstance()},
+			"",
+		},
+		{
+			"dual stack",
+			swapPod(nil),
+			[]*networkingv1.IPInstance{
+				originalIPInstance(),
+				swapIPInstance("original-v6", swapOriginalIPv6, networkingv1.IPv6, nil, nil),
+			},
+			"dual-stack",
+		},
+		{
+			"multiple ipv4 ips",
+			swapPod(nil),
+			[]*networkingv1.IPInstance{
+				originalIPInstance(),
+				swapIPInstance("another", "192.168.0.11/24", networkingv1.IPv4, nil, nil),
+			},
+			"single ip",
+		},
+		{
+			"no ip",
+			swapPod(nil),
+			nil,
+			"single ip",
+		},
+		{
+			"selected route table",
+			swapPod(map[string]string{constants.AnnotationRouteTable: "100"}),
+			[]*networkingv1.IPInstance{originalIPInstance()},
+			"route table",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reason := ipSwapUnsupportedReason(test.pod, test.ipInstances)
+			if len(test.expected) == 0 && len(reason) > 0 {
+				t.Errorf("expected swap supported, got reason %q", reason)
+			}
+			if !strings.Contains(reason, test.expected) {
+				t.Errorf("expected reason containing %q, got %q", test.expected, reason)
+			}
+		})
+	}
+}
+
+func TestCommittingIPInstances(t *testing.T) {
+	original := originalIPInstance()
+	// the ip instance swapped in is labeled with pod once handed over by commit
+	handedOver := swapIPInstance("swapped-in", swapSwappedInIP, networkingv1.IPv4,
+		map[string]string{constants.LabelPod: "pod1"},
+		map[string]string{constants.AnnotationIPSwapFrom: swapOriginalIP})
+	// the original ip is already released, only daemon cleanup is left
+	committed := swapIPInstance("swapped-in", swapSwappedInIP, networkingv1.IPv4,
+		map[string]string{constants.LabelPod: "pod1"},
+		map[string]string{constants.AnnotationIPSwapFrom: "192.168.0.99/24"})
+
+	tests := []struct {
+		name         string
+		ipInstances  []*networkingv1.IPInstance
+		expectedTo   *networkingv1.IPInstance
+		expectedFrom *networkingv1.IPInstance
+	}{
+		{"no swap", []*networkingv1.IPInstance{original}, nil, nil},
+		{"interrupted commit", []*networkingv1.IPInstance{original, handedOver}, handedOver, original},
+		{"interrupted commit in reverse order", []*networkingv1.IPInstance{handedOver, original}, handedOver, original},
+		{"original ip released", []*networkingv1.IPInstance{committed}, nil, nil},
+		{"empty", nil, nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			to, from := committingIPInstances(test.ipInstances)
+			if to != test.expectedTo || from != test.expectedFrom {
+				t.Errorf("expected to %v from %v, got to %v from %v",
+					nameOf(test.expectedTo), nameOf(test.expectedFrom), nameOf(to), nameOf(from))
+			}
+		})
+	}
+}
+
+func nameOf(ipInstance *networkingv1.IPInstance) string {
+	if ipInstance == nil {
+		return "<nil>"
+	}
+	return ipInstance.Name
+}
+
+// swapReader serves pod and ip instances from apiserver, ip instances are filtered by namespace and labels
+type swapReader struct {
+	client.Reader
+	pod         *corev1.Pod
+	ipInstances []*networkingv1.IPInstance
+}
+
+func (r *swapReader) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	if pod, ok := obj.(*corev1.Pod); ok && r.pod != nil && r.pod.Name == key.Name {
+		r.pod.DeepCopyInto(pod)
+		return nil
+	}
+	return apierrors.NewNotFound(schema.GroupResource{}, key.Name)
+}
+
+func (r *swapReader) List(_ context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOptions := &client.ListOptions{}
+	listOptions.ApplyOptions(opts)
+
+	ipInstanceList := list.(*networkingv1.IPInstanceList)
+	for _, ipInstance := range r.ipInstances {
+		if ipInstance.Namespace != listOptions.Namespace {
+			continue
+		}
+		if listOptions.LabelSelector != nil && !listOptions.LabelSelector.Matches(labels.Set(ipInstance.Labels)) {
+			continue
+		}
+		ipInstanceList.Items = append(ipInstanceList.Items, *ipInstance.DeepCopy())
+	}
+	return nil
+}
+
+// swapClient serves subnets and records deletions of ip instances and patches of pod
+type swapClient struct {
+	client.Client
+	subnets    []networkingv1.Subnet
+	deleted    []string
+	podPatches []string
+}
+
+func (c *swapClient) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	if subnet, ok := obj.(*networkingv1.Subnet); ok {
+		for i := range c.subnets {
+			if c.subnets[i].Name == key.Name {
+				c.subnets[i].DeepCopyInto(subnet)
+				return nil
+			}
+		}
+	}
+	return apierrors.NewNotFound(schema.GroupResource{}, key.Name)
+}
+
+func (c *swapClient) Delete(_ context.Context, obj client.Object, _ ...client.DeleteOption) error {
+	c.deleted = append(c.deleted, obj.GetName())
+	return nil
+}
+
+func (c *swapClient) Patch(_ context.Context, obj client.Object, patch client.Patch, _ ...client.PatchOption) error {
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	c.podPatches = append(c.podPatches, string(data))
+	return nil
+}
+
+// swapStore records the binds and commits of ip swap
+type swapStore struct {
+	IPAMStore
+	bindErr   error
+	bound     []string
+	committed []string
+}
+
+func (s *swapStore) IPSwapBind(_ *corev1.Pod, ip, from *types.IP) error {
+	if s.bindErr != nil {
+		return s.bindErr
+	}
+	s.bound = append(s.bound, from.Address.IP.String()+"->"+ip.Address.IP.String())
+	return nil
+}
+
+func (s *swapStore) IPSwapCommit(_ *corev1.Pod, ip, from *types.IP) error {
+	s.committed = append(s.committed, from.Address.IP.String()+"->"+ip.Address.IP.String())
+	return nil
+}
+
+// swapIPAMManager allocates a fixed ip and records releases
+type swapIPAMManager struct {
+	IPAMManager
+	released []string
+}
+
+func (m *swapIPAMManager) Allocate(network, subnet, podName, podNamespace string) (*types.IP, error) {
+	return &types.IP{
+		Address:      globalutils.StringToIPNet(swapSwappedInIP),
+		Network:      network,
+		Subnet:       subnet,
+		PodName:      podName,
+		PodNamespace: podNamespace,
+	}, nil
+}
+
+func (m *swapIPAMManager) Release(_, _, ip string) error {
+	m.released = append(m.released, ip)
+	return nil
+}
+
+func TestIPSwapReconcile(t *testing.T) {
+	subnet := networkingv1.Subnet{
+		ObjectMeta: metav1.ObjectMeta{Name: swapTestSubnet},
+		Spec: networkingv1.SubnetSpec{
+			Network: swapTestNetwork,
+			Range:   networkingv1.AddressRange{Version: networkingv1.IPv4},
+		},
+	}
+	requested := map[string]string{
+		constants.AnnotationIPSwap: "",
+		constants.AnnotationIP:     `{"ip":"192.168.0.10/24"}`,
+	}
+
+	tests := []struct {
+		name              string
+		pod               *corev1.Pod
+		ipInstances       []*networkingv1.IPInstance
+		bindErr           error
+		expectedCommitted []string
+		expectedBound     []string
+		expectedDeleted   []string
+		expectedReleased  []string
+		expectedEvent     string
+		expectRequeue     bool
+		expectPodPatch    bool
+		expectErr         bool
+	}{
+		{
+			name: "interrupted commit is finished without request",
+			pod:  swapPod(nil),
+			ipInstances: []*networkingv1.IPInstance{
+				originalIPInstance(),
+				swapIPInstance("swapped-in", swapSwappedInIP, networkingv1.IPv4,
+					map[string]string{constants.LabelPod: "pod1"},
+					map[string]string{constants.AnnotationIPSwapFrom: swapOriginalIP}),
+			},
+			expectedCommitted: []string{"192.168.0.10->192.168.0.20"},
+			expectedEvent:     ReasonIPSwapSucceed,
+		},
+		{
+			name:            "swap is cancelled if no longer requested",
+			pod:             swapPod(nil),
+			ipInstances:     []*networkingv1.IPInstance{originalIPInstance(), swappedInIPInstance(networkingv1.IPPhaseBound, 0)},
+			expectedDeleted: []string{"swapped-in"},
+			expectedEvent:   ReasonIPSwapCancelled,
+		},
+		{
+			name:          "wait for nic configured before bind timeout",
+			pod:           swapPod(requested),
+			ipInstances:   []*networkingv1.IPInstance{originalIPInstance(), swappedInIPInstance("", time.Second)},
+			expectRequeue: true,
+		},
+		{
+			name:            "swap is cancelled after bind timeout",
+			pod:             swapPod(requested),
+			ipInstances:     []*networkingv1.IPInstance{originalIPInstance(), swappedInIPInstance("", 2*swapBindTimeout)},
+			expectedDeleted: []string{"swapped-in"},
+			expectedEvent:   ReasonIPSwapCancelled,
+			expectPodPatch:  true,
+		},
+		{
+			name:            "swap is cancelled if original ip is gone",
+			pod:             swapPod(requested),
+			ipInstances:     []*networkingv1.IPInstance{swappedInIPInstance(networkingv1.IPPhaseBound, time.Second)},
+			expectedDeleted: []string{"swapped-in"},
+			expectedEvent:   ReasonIPSwapCancelled,
+			expectPodPatch:  true,
+		},
+		{
+			name:          "wait for dual-homed period",
+			pod:           swapPod(requested),
+			ipInstances:   []*networkingv1.IPInstance{originalIPInstance(), swappedInIPInstance(networkingv1.IPPhaseBound, time.Second)},
+			expectRequeue: true,
+		},
+		{
+			name:              "swap is committed after dual-homed period",
+			pod:               swapPod(requested),
+			ipInstances:       []*networkingv1.IPInstance{originalIPInstance(), swappedInIPInstance(networkingv1.IPPhaseBound, 2*swapDualHomedTime)},
+			expectedCommitted: []string{"192.168.0.10->192.168.0.20"},
+			expectedEvent:     ReasonIPSwapSucceed,
+		},
+		{
+			name:          "swap of single ip is started",
+			pod:           swapPod(requested),
+			ipInstances:   []*networkingv1.IPInstance{originalIPInstance()},
+			expectedBound: []string{"192.168.0.10->192.168.0.20"},
+			expectedEvent: ReasonIPSwapStarted,
+		},
+		{
+			name: "swap of dual-stack pod is rejected",
+			pod:  swapPod(requested),
+			ipInstances: []*networkingv1.IPInstance{
+				originalIPInstance(),
+				swapIPInstance("original-v6", swapOriginalIPv6, networkingv1.IPv6,
+					map[string]string{constants.LabelPod: "pod1"}, nil),
+			},
+			expectedEvent:  ReasonIPSwapFail,
+			expectPodPatch: true,
+		},
+		{
+			name:             "ip allocated is released if bind fails",
+			pod:              swapPod(requested),
+			ipInstances:      []*networkingv1.IPInstance{originalIPInstance()},
+			bindErr:          apierrors.NewConflict(schema.GroupResource{}, "swapped-in", nil),
+			expectedReleased: []string{"192.168.0.20"},
+			expectErr:        true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &swapClient{subnets: []networkingv1.Subnet{subnet}}
+			store := &swapStore{bindErr: test.bindErr}
+			manager := &swapIPAMManager{}
+			recorder := record.NewFakeRecorder(10)
+			r := &IPSwapReconciler{
+				APIReader:       &swapReader{pod: test.pod, ipInstances: test.ipInstances},
+				Client:          c,
+				Recorder:        recorder,
+				IPAMStore:       store,
+				IPAMManager:     manager,
+				DualHomedPeriod: swapDualHomedTime,
+				BindTimeout:     swapBindTimeout,
+			}
+
+			result, err := r.Reconcile(context.Background(), ctrl.Request{
+				NamespacedName: apitypes.NamespacedName{Namespace: "default", Name: "pod1"},
+			})
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+			if (result.RequeueAfter > 0) != test.expectRequeue {
+				t.Errorf("expected requeue %v, got %v", test.expectRequeue, result.RequeueAfter)
+			}
+
+			assertStrings(t, "committed", test.expectedCommitted, store.committed)
+			assertStrings(t, "bound", test.expectedBound, store.bound)
+			assertStrings(t, "deleted", test.expectedDeleted, c.deleted)
+			assertStrings(t, "released", test.expectedReleased, manager.released)
+
+			if test.expectPodPatch != (len(c.podPatches) > 0) {
+				t.Errorf("expected swap request removed %v, got patches %v", test.expectPodPatch, c.podPatches)
+			}
+
+			select {
+			case event := <-recorder.Events:
+				if len(test.expectedEvent) == 0 || !strings.Contains(event, test.expectedEvent) {
+					t.Errorf("expected event %q, got %q", test.expectedEvent, event)
+				}
+			default:
+				if len(test.expectedEvent) > 0 {
+					t.Errorf("expected event %q, got none", test.expectedEvent)
+				}
+			}
+		})
+	}
+}
+
+func assertStrings(t *testing.T, name string, expected, actual []string) {
+	t.Helper()
+	if strings.Join(expected, ",") != strings.Join(actual, ",") {
+		t.Errorf("expected %s %v, got %v", name, expected, actual)
+	}
+}
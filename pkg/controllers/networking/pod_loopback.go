@@ -0,0 +1,89 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/feature"
+	"github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+// ensureLoopbackIP allocates the loopback ip asked by pod annotation after the ips of pod, it is owned by
+// pod and released by garbage collection after pod is deleted
+func (r *PodReconciler) ensureLoopbackIP(ctx context.Context, pod *corev1.Pod) error {
+	subnetName := pod.Annotations[constants.AnnotationLoopbackSubnet]
+	if len(subnetName) == 0 {
+		return nil
+	}
+
+	// read from apiserver to avoid allocating twice with a stale cache
+	ipInstanceList := &networkingv1.IPInstanceList{}
+	if err := r.APIReader.List(ctx, ipInstanceList, client.InNamespace(pod.Namespace),
+		client.MatchingLabels{constants.LabelLoopbackPod: pod.Name}); err != nil {
+		return fmt.Errorf("unable to list loopback ip instances: %v", err)
+	}
+	for i := range ipInstanceList.Items {
+		// the one of previous pod with the same name is left to garbage collection
+		if ipInstance := &ipInstanceList.Items[i]; ipInstance.DeletionTimestamp.IsZero() && metav1.IsControlledBy(ipInstance, pod) {
+			return nil
+		}
+	}
+
+	subnet, err := utils.GetSubnet(r, subnetName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return newPermanentError("loopback subnet %s is not found", subnetName)
+		}
+		return fmt.Errorf("unable to get loopback subnet %s: %v", subnetName, err)
+	}
+
+	var ip *types.IP
+	if feature.DualStackEnabled() {
+		var ips []*types.IP
+		ipFamily := utils.ToIPFamilyMode(subnet.Spec.Range.Version == networkingv1.IPv6)
+		if ips, err = r.IPAMManager.DualStack().Allocate(ipFamily, subnet.Spec.Network, []string{subnet.Name},
+			pod.Name, pod.Namespace); err != nil {
+			return fmt.Errorf("unable to allocate loopback ip: %v", err)
+		}
+		ip = ips[0]
+		if err = r.IPAMStore.DualStack().LoopbackIPBind(pod, ip); err != nil {
+			_ = r.IPAMManager.DualStack().Release(ipFamily, ip.Network, []string{ip.Subnet}, []string{ip.Address.IP.String()})
+			return fmt.Errorf("unable to bind loopback ip %s: %v", ip.Address.IP, err)
+		}
+	} else {
+		if ip, err = r.IPAMManager.Allocate(subnet.Spec.Network, subnet.Name, pod.Name, pod.Namespace); err != nil {
+			return fmt.Errorf("unable to allocate loopback ip: %v", err)
+		}
+		if err = r.IPAMStore.LoopbackIPBind(pod, ip); err != nil {
+			_ = r.IPAMManager.Release(ip.Network, ip.Subnet, ip.Address.IP.String())
+			return fmt.Errorf("unable to bind loopback ip %s: %v", ip.Address.IP, err)
+		}
+	}
+
+	r.Recorder.Eventf(pod, corev1.EventTypeNormal, ReasonIPAllocationSucceed, "allocate loopback IP %s successfully", ip.Address.IP)
+	return nil
+}
@@ -0,0 +1,172 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/controllers/concurrency"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+)
+
+const ControllerSubnet = "Subnet"
+
+const ReasonSubnetInUse = "SubnetInUse"
+
+// maxSubnetHoldersInEvent limits the pods listed in event of a deleting subnet
+const maxSubnetHoldersInEvent = 10
+
+// SubnetReconciler protects subnet from being deleted while its ips are still in use,
+// the deletion is held by finalizer until all the ip instances are recycled
+type SubnetReconciler struct {
+	client.Client
+
+	Recorder record.EventRecorder
+
+	concurrency.ControllerConcurrency
+}
+
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=subnets,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=subnets/finalizers,verbs=update
+
+func (r *SubnetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+
+	var subnet = &networkingv1.Subnet{}
+	if err := r.Get(ctx, req.NamespacedName, subnet); err != nil {
+		return ctrl.Result{}, wrapError("unable to fetch Subnet", client.IgnoreNotFound(err))
+	}
+
+	if subnet.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, wrapError("unable to add finalizer", r.addFinalizer(ctx, subnet))
+	}
+
+	if !controllerutil.ContainsFinalizer(subnet, constants.FinalizerSubnetProtection) {
+		return ctrl.Result{}, nil
+	}
+
+	ipInstanceList, err := utils.ListIPInstances(r, client.MatchingLabels{constants.LabelSubnet: subnet.Name})
+	if err != nil {
+		return ctrl.Result{}, wrapError("unable to list ip instances of subnet", err)
+	}
+
+	// deletion will be checked again once any ip instance is removed
+	if holders := subnetHolders(ipInstanceList); len(ipInstanceList.Items) > 0 {
+		log.Info("subnet is still in use, wait for ip instances to be recycled", "ips", len(ipInstanceList.Items), "pods", holders)
+		r.Recorder.Eventf(subnet, corev1.EventTypeWarning, ReasonSubnetInUse,
+			"subnet is being deleted but %d ips are still in use, pods: %s", len(ipInstanceList.Items), formatSubnetHolders(holders))
+		return ctrl.Result{}, nil
+	}
+
+	log.Info("all ip instances of subnet are recycled, release subnet")
+	return ctrl.Result{}, wrapError("unable to remove finalizer", r.removeFinalizer(ctx, subnet))
+}
+
+// subnetHolders returns the sorted pods still holding ips, reserved ips are held by nobody
+func subnetHolders(ipInstanceList *networkingv1.IPInstanceList) []string {
+	var holders []string
+	for i := range ipInstanceList.Items {
+		var ipInstance = &ipInstanceList.Items[i]
+		if len(ipInstance.Status.PodName) == 0 {
+			continue
+		}
+		holders = append(holders, fmt.Sprintf("%s/%s", ipInstance.Status.PodNamespace, ipInstance.Status.PodName))
+	}
+	sort.Strings(holders)
+	return holders
+}
+
+func formatSubnetHolders(holders []string) string {
+	if len(holders) == 0 {
+		return "none, ips are reserved"
+	}
+	if len(holders) > maxSubnetHoldersInEvent {
+		return fmt.Sprintf("%s and %d more", strings.Join(holders[:maxSubnetHoldersInEvent], ", "), len(holders)-maxSubnetHoldersInEvent)
+	}
+	return strings.Join(holders, ", ")
+}
+
+func (r *SubnetReconciler) addFinalizer(ctx context.Context, subnet *networkingv1.Subnet) error {
+	if controllerutil.ContainsFinalizer(subnet, constants.FinalizerSubnetProtection) {
+		return nil
+	}
+
+	patch := client.MergeFrom(subnet.DeepCopy())
+	controllerutil.AddFinalizer(subnet, constants.FinalizerSubnetProtection)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Patch(ctx, subnet, patch)
+	})
+}
+
+func (r *SubnetReconciler) removeFinalizer(ctx context.Context, subnet *networkingv1.Subnet) error {
+	patch := client.MergeFrom(subnet.DeepCopy())
+	controllerutil.RemoveFinalizer(subnet, constants.FinalizerSubnetProtection)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Patch(ctx, subnet, patch)
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *SubnetReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(ControllerSubnet).
+		For(&networkingv1.Subnet{},
+			builder.WithPredicates(
+				&utils.IgnoreDeletePredicate{},
+			)).
+		Watches(&source.Kind{Type: &networkingv1.IPInstance{}},
+			handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+				ipInstance, ok := object.(*networkingv1.IPInstance)
+				if !ok {
+					return nil
+				}
+				return []reconcile.Request{
+					{
+						NamespacedName: types.NamespacedName{
+							Name: ipInstance.Spec.Subnet,
+						},
+					},
+				}
+			}),
+			builder.WithPredicates(
+				&utils.IgnoreUpdatePredicate{},
+			),
+		).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.Max(),
+		}).
+		Complete(r)
+}
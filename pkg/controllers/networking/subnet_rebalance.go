@@ -0,0 +1,477 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	policyv1beta1client "k8s.io/client-go/kubernetes/typed/policy/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/feature"
+	"github.com/alibaba/hybridnet/pkg/ipam/types"
+	globalutils "github.com/alibaba/hybridnet/pkg/utils"
+)
+
+const RebalancerSubnet = "SubnetRebalancer"
+
+// SubnetRebalancePath is the http path of rebalancing subnets of a network,
+// e.g., "POST /rebalance-network?network=network1&dryRun=false&maxMoves=10"
+const SubnetRebalancePath = "/rebalance-network"
+
+const (
+	defaultSubnetRebalanceMaxMoves = 10
+
+	// subnetRebalanceHintTTL is how long a replacement pod is expected to be created
+	// after the pod is moved, expired hints will be dropped
+	subnetRebalanceHintTTL = 10 * time.Minute
+)
+
+var _ manager.Runnable = &SubnetRebalancer{}
+
+// SubnetRebalancer serves an endpoint for operators to rebalance the utilization of subnets in a
+// network, e.g., after a new subnet is added to a busy network. It plans to move the pods of
+// ReplicaSets from subnets above the average utilization to the ones below, and only reports the
+// plan in dry-run mode, which is the default. Otherwise, the planned pods are evicted and the
+// replacement pods created by the same ReplicaSets are allocated from the target subnets, through
+// the hints consumed by pod controller.
+type SubnetRebalancer struct {
+	client.Client
+	Logger    logr.Logger
+	Evictions policyv1beta1client.EvictionsGetter
+
+	IPAMManager IPAMManager
+	Hints       *SubnetRebalanceHints
+
+	// BindAddress is the address which rebalance endpoint listens on, it must be a loopback
+	// address unless TLS is set
+	BindAddress string
+	TLS         *EndpointTLSConfig
+}
+
+// SubnetRebalanceResult is the response of rebalancing subnets of a network
+type SubnetRebalanceResult struct {
+	Network string                `json:"network"`
+	DryRun  bool                  `json:"dryRun"`
+	Subnets []SubnetRebalanceInfo `json:"subnets,omitempty"`
+	Moves   []SubnetRebalanceMove `json:"moves,omitempty"`
+	Skipped []SubnetRebalanceMove `json:"skipped,omitempty"`
+}
+
+// SubnetRebalanceInfo describes the utilization of a subnet before and after rebalancing
+type SubnetRebalanceInfo struct {
+	Name     string `json:"name"`
+	Total    uint32 `json:"total"`
+	Used     uint32 `json:"used"`
+	Expected uint32 `json:"expected"`
+}
+
+// SubnetRebalanceMove describes a pod to be moved, or skipped with a reason
+type SubnetRebalanceMove struct {
+	Namespace  string `json:"namespace"`
+	Pod        string `json:"pod"`
+	IP         string `json:"ip"`
+	FromSubnet string `json:"fromSubnet"`
+	ToSubnet   string `json:"toSubnet,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+func (r *SubnetRebalancer) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle(SubnetRebalancePath, r)
+
+	r.Logger.Info("subnet rebalancer is serving", "address", r.BindAddress, "path", SubnetRebalancePath,
+		"tls", r.TLS.Enabled())
+	if err := serveEndpoint(ctx, r.BindAddress, mux, r.TLS, true); err != nil {
+		return fmt.Errorf("unable to serve subnet rebalancer: %v", err)
+	}
+	return nil
+}
+
+func (r *SubnetRebalancer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("method %s is not allowed", req.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	networkName := query.Get("network")
+	if len(networkName) == 0 {
+		http.Error(w, "network must be specified", http.StatusBadRequest)
+		return
+	}
+
+	maxMoves := defaultSubnetRebalanceMaxMoves
+	if maxMovesStr := query.Get("maxMoves"); len(maxMovesStr) > 0 {
+		var err error
+		if maxMoves, err = strconv.Atoi(maxMovesStr); err != nil || maxMoves <= 0 {
+			http.Error(w, fmt.Sprintf("invalid max moves %q", maxMovesStr), http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := r.Rebalance(req.Context(), networkName, globalutils.ParseBoolOrDefault(query.Get("dryRun"), true), maxMoves)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// Rebalance plans at most maxMoves pods to move from busy subnets of network to idle ones,
+// and moves them if not in dry-run mode
+func (r *SubnetRebalancer) Rebalance(ctx context.Context, networkName string, dryRun bool, maxMoves int) (*SubnetRebalanceResult, error) {
+	r.Logger.Info("start rebalancing subnets", "network", networkName, "dryRun", dryRun, "maxMoves", maxMoves)
+
+	result, err := r.plan(networkName, maxMoves)
+	if err != nil {
+		return nil, err
+	}
+	result.DryRun = dryRun
+
+	if !dryRun {
+		for i := range result.Moves {
+			if err = r.move(ctx, &result.Moves[i]); err != nil {
+				result.Moves[i].Error = err.Error()
+			}
+		}
+	}
+
+	r.Logger.Info("subnets are rebalanced", "network", networkName, "dryRun", dryRun,
+		"moves", len(result.Moves), "skipped", len(result.Skipped))
+	return result, nil
+}
+
+// plan distributes the used ips of each family in proportion to the capacity of subnets, subnets
+// with topology are left alone, because they are selected by node of pod
+func (r *SubnetRebalancer) plan(networkName string, maxMoves int) (*SubnetRebalanceResult, error) {
+	subnetList, err := utils.ListSubnets(r, client.MatchingFields{IndexerFieldNetwork: networkName})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list subnets of network %s: %v", networkName, err)
+	}
+
+	// make plan stable
+	sort.Slice(subnetList.Items, func(i, j int) bool {
+		return subnetList.Items[i].Name < subnetList.Items[j].Name
+	})
+
+	var (
+		result           = &SubnetRebalanceResult{Network: networkName}
+		v4Infos, v6Infos []*SubnetRebalanceInfo
+		v4Used, v4Total  uint64
+		v6Used, v6Total  uint64
+		isIPv6Subnet     = map[string]bool{}
+		remainingMoves   = maxMoves
+		// subnets below the balanced utilization, keyed by whether they are ipv6
+		deficits = map[bool][]*SubnetRebalanceInfo{}
+	)
+	for i := range subnetList.Items {
+		var subnet = &subnetList.Items[i]
+		if !subnet.DeletionTimestamp.IsZero() || networkingv1.IsPrivateSubnet(subnet) ||
+			(subnet.Spec.Config != nil && subnet.Spec.Config.Topology != nil) {
+			continue
+		}
+
+		usage, err := r.subnetUsage(networkName, subnet.Name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get usage of subnet %s: %v", subnet.Name, err)
+		}
+		if usage.Total == 0 {
+			continue
+		}
+
+		info := &SubnetRebalanceInfo{Name: subnet.Name, Total: usage.Total, Used: usage.Used, Expected: usage.Used}
+		if networkingv1.IsIPv6Subnet(subnet) {
+			isIPv6Subnet[subnet.Name] = true
+			v6Infos = append(v6Infos, info)
+			v6Used, v6Total = v6Used+uint64(usage.Used), v6Total+uint64(usage.Total)
+		} else {
+			v4Infos = append(v4Infos, info)
+			v4Used, v4Total = v4Used+uint64(usage.Used), v4Total+uint64(usage.Total)
+		}
+	}
+
+	var surpluses = map[string]uint32{}
+	for isIPv6, infos := range map[bool][]*SubnetRebalanceInfo{false: v4Infos, true: v6Infos} {
+		used, total := v4Used, v4Total
+		if isIPv6 {
+			used, total = v6Used, v6Total
+		}
+		deficits[isIPv6] = balanceSubnets(infos, used, total, surpluses)
+	}
+
+	var ipInstancesOfSubnet = map[string][]*networkingv1.IPInstance{}
+	if len(surpluses) > 0 {
+		ipInstanceList, err := utils.ListUsingIPInstancesOfNetwork(r, networkName)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list ip instances of network %s: %v", networkName, err)
+		}
+		for i := range ipInstanceList.Items {
+			var ipInstance = &ipInstanceList.Items[i]
+			ipInstancesOfSubnet[ipInstance.Spec.Subnet] = append(ipInstancesOfSubnet[ipInstance.Spec.Subnet], ipInstance)
+		}
+	}
+
+	for _, info := range append(v4Infos, v6Infos...) {
+		if surpluses[info.Name] == 0 || remainingMoves == 0 {
+			continue
+		}
+
+		for _, ipInstance := range ipInstancesOfSubnet[info.Name] {
+			if surpluses[info.Name] == 0 || remainingMoves == 0 {
+				break
+			}
+			if !ipInstance.DeletionTimestamp.IsZero() || !networkingv1.IsUsingPhase(ipInstance.Status.Phase) ||
+				len(ipInstance.Status.PodName) == 0 {
+				continue
+			}
+
+			move := SubnetRebalanceMove{
+				Namespace:  ipInstance.Status.PodNamespace,
+				Pod:        ipInstance.Status.PodName,
+				IP:         ipInstance.Spec.Address.IP,
+				FromSubnet: info.Name,
+			}
+			if move.Reason = r.unmovableReason(ipInstance); len(move.Reason) > 0 {
+				result.Skipped = append(result.Skipped, move)
+				continue
+			}
+
+			target := pickSubnetRebalanceTarget(deficits[isIPv6Subnet[info.Name]])
+			if target == nil {
+				break
+			}
+
+			move.ToSubnet = target.Name
+			target.Expected++
+			info.Expected--
+			surpluses[info.Name]--
+			remainingMoves--
+			result.Moves = append(result.Moves, move)
+		}
+	}
+
+	for _, info := range append(v4Infos, v6Infos...) {
+		result.Subnets = append(result.Subnets, *info)
+	}
+	return result, nil
+}
+
+// balanceSubnets records the surpluses of subnets above the balanced utilization of the same ip family,
+// whose used and total ips are given, and returns the subnets below it
+func balanceSubnets(infos []*SubnetRebalanceInfo, used, total uint64, surpluses map[string]uint32) []*SubnetRebalanceInfo {
+	var deficits []*SubnetRebalanceInfo
+	for _, info := range infos {
+		// round up to avoid moving pods back and forth
+		balanced := uint32((used*uint64(info.Total) + total - 1) / total)
+		if info.Used > balanced {
+			surpluses[info.Name] = info.Used - balanced
+		} else if info.Used < balanced {
+			deficits = append(deficits, info)
+		}
+	}
+	return deficits
+}
+
+// unmovableReason returns why the pod of ip instance can not be moved, empty means movable
+func (r *SubnetRebalancer) unmovableReason(ipInstance *networkingv1.IPInstance) string {
+	pod := &corev1.Pod{}
+	if err := r.Get(context.TODO(), apitypes.NamespacedName{Namespace: ipInstance.Status.PodNamespace, Name: ipInstance.Status.PodName}, pod); err != nil {
+		return fmt.Sprintf("unable to get pod: %v", err)
+	}
+
+	if reason := podUnmovableReason(pod); len(reason) > 0 {
+		return reason
+	}
+
+	// ips of the other families have to be moved together, which is not supported yet
+	ips, err := utils.ListAllocatedIPInstancesOfPod(r, pod)
+	if err != nil {
+		return fmt.Sprintf("unable to list ip instances of pod: %v", err)
+	}
+	if len(ips) != 1 {
+		return "pod has multiple ips"
+	}
+
+	return ""
+}
+
+// podUnmovableReason returns why pod can not be moved by its spec and status, empty means movable
+func podUnmovableReason(pod *corev1.Pod) string {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+		return "pod is not running"
+	}
+
+	// only pods of ReplicaSet are re-created with fresh ips and without any identity
+	if owner := metav1.GetControllerOf(pod); owner == nil || owner.Kind != "ReplicaSet" {
+		return "pod is not controlled by ReplicaSet"
+	}
+
+	for _, key := range []string{
+		constants.AnnotationIP,
+		constants.AnnotationIPPool,
+		constants.AnnotationSpecifiedSubnet,
+		constants.AnnotationSpecifiedNetIDRange,
+		constants.AnnotationExternalIP,
+	} {
+		if _, exist := pod.Annotations[key]; exist {
+			return fmt.Sprintf("pod has annotation %s", key)
+		}
+	}
+	if _, exist := pod.Labels[constants.LabelSpecifiedSubnet]; exist {
+		return fmt.Sprintf("pod has label %s", constants.LabelSpecifiedSubnet)
+	}
+	return ""
+}
+
+// move evicts the pod, and hints pod controller to allocate the replacement pod created by the same
+// ReplicaSet from the target subnet
+func (r *SubnetRebalancer) move(ctx context.Context, move *SubnetRebalanceMove) error {
+	pod := &corev1.Pod{}
+	if err := r.Get(ctx, apitypes.NamespacedName{Namespace: move.Namespace, Name: move.Pod}, pod); err != nil {
+		return fmt.Errorf("unable to get pod: %v", err)
+	}
+
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return fmt.Errorf("pod is not controlled")
+	}
+
+	// eviction respects pod disruption budgets, a refused one is reported as error of move
+	r.Hints.Add(pod.Namespace, owner.UID, move.ToSubnet)
+	if err := evictPod(ctx, r.Evictions, pod); err != nil {
+		r.Hints.Remove(pod.Namespace, owner.UID, move.ToSubnet)
+		return fmt.Errorf("unable to evict pod: %v", err)
+	}
+
+	r.Logger.Info("pod is moved for rebalancing", "pod", client.ObjectKeyFromObject(pod).String(),
+		"from", move.FromSubnet, "to", move.ToSubnet)
+	return nil
+}
+
+func (r *SubnetRebalancer) subnetUsage(networkName, subnetName string) (*types.Usage, error) {
+	if feature.DualStackEnabled() {
+		return r.IPAMManager.DualStack().SubnetUsage(networkName, subnetName)
+	}
+	return r.IPAMManager.SubnetUsage(networkName, subnetName)
+}
+
+// pickSubnetRebalanceTarget picks the subnet with the lowest expected utilization which is still
+// below the balanced one
+func pickSubnetRebalanceTarget(deficits []*SubnetRebalanceInfo) *SubnetRebalanceInfo {
+	var picked *SubnetRebalanceInfo
+	for _, info := range deficits {
+		if info.Expected >= info.Total {
+			continue
+		}
+		if picked == nil || uint64(info.Expected)*uint64(picked.Total) < uint64(picked.Expected)*uint64(info.Total) {
+			picked = info
+		}
+	}
+	return picked
+}
+
+type subnetRebalanceHint struct {
+	subnet   string
+	deadline time.Time
+}
+
+// SubnetRebalanceHints records the target subnets of the pods moved by rebalancer, keyed by
+// controller of pods, they are consumed by the next pods allocated from the same controller
+type SubnetRebalanceHints struct {
+	lock  sync.Mutex
+	hints map[apitypes.NamespacedName][]subnetRebalanceHint
+}
+
+func NewSubnetRebalanceHints() *SubnetRebalanceHints {
+	return &SubnetRebalanceHints{
+		hints: map[apitypes.NamespacedName][]subnetRebalanceHint{},
+	}
+}
+
+func (h *SubnetRebalanceHints) Add(namespace string, controllerUID apitypes.UID, subnet string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	key := apitypes.NamespacedName{Namespace: namespace, Name: string(controllerUID)}
+	h.hints[key] = append(h.hints[key], subnetRebalanceHint{subnet: subnet, deadline: time.Now().Add(subnetRebalanceHintTTL)})
+}
+
+func (h *SubnetRebalanceHints) Remove(namespace string, controllerUID apitypes.UID, subnet string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	key := apitypes.NamespacedName{Namespace: namespace, Name: string(controllerUID)}
+	for i := len(h.hints[key]) - 1; i >= 0; i-- {
+		if h.hints[key][i].subnet == subnet {
+			h.hints[key] = append(h.hints[key][:i], h.hints[key][i+1:]...)
+			break
+		}
+	}
+	if len(h.hints[key]) == 0 {
+		delete(h.hints, key)
+	}
+}
+
+// Pop returns the target subnet for pod and drops it, empty if nothing is hinted,
+// it is safe to be called on nil hints
+func (h *SubnetRebalanceHints) Pop(pod *corev1.Pod) string {
+	if h == nil {
+		return ""
+	}
+
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return ""
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	key := apitypes.NamespacedName{Namespace: pod.Namespace, Name: string(owner.UID)}
+	now := time.Now()
+	for len(h.hints[key]) > 0 {
+		hint := h.hints[key][0]
+		h.hints[key] = h.hints[key][1:]
+		if now.Before(hint.deadline) {
+			if len(h.hints[key]) == 0 {
+				delete(h.hints, key)
+			}
+			return hint.subnet
+		}
+	}
+	delete(h.hints, key)
+	return ""
+}
@@ -0,0 +1,222 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/alibaba/hybridnet/pkg/constants"
+)
+
+func TestBalanceSubnets(t *testing.T) {
+	tests := []struct {
+		name             string
+		infos            []*SubnetRebalanceInfo
+		expectSurpluses  map[string]uint32
+		expectedDeficits []string
+	}{
+		{
+			"new subnet added",
+			[]*SubnetRebalanceInfo{
+				{Name: "busy", Total: 100, Used: 90},
+				{Name: "new", Total: 100, Used: 0},
+			},
+			map[string]uint32{"busy": 45},
+			[]string{"new"},
+		},
+		{
+			"balanced in proportion to capacity",
+			[]*SubnetRebalanceInfo{
+				{Name: "large", Total: 200, Used: 40},
+				{Name: "small", Total: 100, Used: 20},
+			},
+			map[string]uint32{},
+			nil,
+		},
+		{
+			"rounded up to avoid moving back and forth",
+			[]*SubnetRebalanceInfo{
+				{Name: "a", Total: 100, Used: 2},
+				{Name: "b", Total: 100, Used: 1},
+			},
+			map[string]uint32{},
+			[]string{"b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var used, total uint64
+			for _, info := range test.infos {
+				used, total = used+uint64(info.Used), total+uint64(info.Total)
+			}
+
+			surpluses := map[string]uint32{}
+			var deficits []string
+			for _, info := range balanceSubnets(test.infos, used, total, surpluses) {
+				deficits = append(deficits, info.Name)
+			}
+			if !reflect.DeepEqual(surpluses, test.expectSurpluses) {
+				t.Errorf("expect surpluses %v, got %v", test.expectSurpluses, surpluses)
+			}
+			if !reflect.DeepEqual(deficits, test.expectedDeficits) {
+				t.Errorf("expect deficits %v, got %v", test.expectedDeficits, deficits)
+			}
+		})
+	}
+}
+
+func TestPickSubnetRebalanceTarget(t *testing.T) {
+	full := &SubnetRebalanceInfo{Name: "full", Total: 10, Used: 10, Expected: 10}
+	half := &SubnetRebalanceInfo{Name: "half", Total: 100, Used: 50, Expected: 50}
+	idle := &SubnetRebalanceInfo{Name: "idle", Total: 10, Used: 1, Expected: 1}
+
+	if picked := pickSubnetRebalanceTarget(nil); picked != nil {
+		t.Errorf("expect nothing picked, got %s", picked.Name)
+	}
+	if picked := pickSubnetRebalanceTarget([]*SubnetRebalanceInfo{full}); picked != nil {
+		t.Errorf("expect full subnet never picked, got %s", picked.Name)
+	}
+	if picked := pickSubnetRebalanceTarget([]*SubnetRebalanceInfo{full, half, idle}); picked != idle {
+		t.Errorf("expect the lowest utilization picked, got %v", picked)
+	}
+
+	// expected utilization grows with planned moves
+	idle.Expected = 6
+	if picked := pickSubnetRebalanceTarget([]*SubnetRebalanceInfo{full, half, idle}); picked != half {
+		t.Errorf("expect the lowest expected utilization picked, got %v", picked)
+	}
+}
+
+func TestPodUnmovableReason(t *testing.T) {
+	isController := true
+	newPod := func(mutate func(pod *corev1.Pod)) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web-abc-xyz",
+				Namespace: "default",
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-abc", UID: "rs-uid", Controller: &isController},
+				},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+		if mutate != nil {
+			mutate(pod)
+		}
+		return pod
+	}
+
+	now := metav1.Now()
+	tests := []struct {
+		name    string
+		pod     *corev1.Pod
+		movable bool
+	}{
+		{
+			"running pod of replica set",
+			newPod(nil),
+			true,
+		},
+		{
+			"pending",
+			newPod(func(pod *corev1.Pod) { pod.Status.Phase = corev1.PodPending }),
+			false,
+		},
+		{
+			"deleting",
+			newPod(func(pod *corev1.Pod) { pod.DeletionTimestamp = &now }),
+			false,
+		},
+		{
+			"bare pod",
+			newPod(func(pod *corev1.Pod) { pod.OwnerReferences = nil }),
+			false,
+		},
+		{
+			"stateful pod",
+			newPod(func(pod *corev1.Pod) { pod.OwnerReferences[0].Kind = "StatefulSet" }),
+			false,
+		},
+		{
+			"specified ip",
+			newPod(func(pod *corev1.Pod) {
+				pod.Annotations = map[string]string{constants.AnnotationIPPool: "192.168.0.10"}
+			}),
+			false,
+		},
+		{
+			"specified subnet by label",
+			newPod(func(pod *corev1.Pod) {
+				pod.Labels = map[string]string{constants.LabelSpecifiedSubnet: "subnet1"}
+			}),
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if reason := podUnmovableReason(test.pod); (len(reason) == 0) != test.movable {
+				t.Errorf("expect movable %v, got reason %q", test.movable, reason)
+			}
+		})
+	}
+}
+
+func TestSubnetRebalancerRejectsInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		expectCode int
+	}{
+		{
+			"get is not allowed",
+			http.MethodGet,
+			SubnetRebalancePath + "?network=network1",
+			http.StatusMethodNotAllowed,
+		},
+		{
+			"missing network",
+			http.MethodPost,
+			SubnetRebalancePath,
+			http.StatusBadRequest,
+		},
+		{
+			"invalid max moves",
+			http.MethodPost,
+			SubnetRebalancePath + "?network=network1&maxMoves=0",
+			http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			(&SubnetRebalancer{}).ServeHTTP(recorder, httptest.NewRequest(test.method, test.url, nil))
+			if recorder.Code != test.expectCode {
+				t.Errorf("expect code %d, got %d", test.expectCode, recorder.Code)
+			}
+		})
+	}
+}
@@ -39,7 +39,9 @@ import (
 	"github.com/alibaba/hybridnet/pkg/controllers/concurrency"
 	"github.com/alibaba/hybridnet/pkg/controllers/utils"
 	"github.com/alibaba/hybridnet/pkg/feature"
+	"github.com/alibaba/hybridnet/pkg/ipam/allocator"
 	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
+	"github.com/alibaba/hybridnet/pkg/metrics"
 )
 
 const ControllerSubnetStatus = "SubnetStatus"
@@ -88,6 +90,22 @@ func (r *SubnetStatusReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		}
 	}
 
+	// selectors are registered in code, so the unknown ones can only be warned here
+	if selectorName := networkingv1.GetSubnetAddressSelector(subnet); len(selectorName) > 0 {
+		if _, exist := ipamtypes.GetAddressSelector(selectorName); !exist {
+			r.Recorder.Eventf(subnet, corev1.EventTypeWarning, "UnknownAddressSelector",
+				"address selector %s is not registered, fall back to %s", selectorName, ipamtypes.DefaultAddressSelectorName)
+		}
+	}
+
+	// quarantined IPs will be available after expiring, so check it again later
+	metrics.IPQuarantinedGauge.WithLabelValues(subnet.Name).Set(float64(usage.Quarantined))
+	metrics.IPConflictedGauge.WithLabelValues(subnet.Name).Set(float64(usage.Conflicted))
+	metrics.IPRangeReservedGauge.WithLabelValues(subnet.Name).Set(float64(usage.RangeReserved))
+	if usage.Quarantined > 0 {
+		result = ctrl.Result{RequeueAfter: allocator.QuarantineDuration}
+	}
+
 	var subnetStatus = &networkingv1.SubnetStatus{
 		Count: networkingv1.Count{
 			Total:     int32(usage.Total),
@@ -100,7 +118,7 @@ func (r *SubnetStatusReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	// diff for no-op
 	if reflect.DeepEqual(&subnet.Status, subnetStatus) {
 		log.V(10).Info("subnet status is up-to-date, skip updating")
-		return ctrl.Result{}, nil
+		return result, nil
 	}
 
 	// patch subnet status
@@ -115,7 +133,7 @@ func (r *SubnetStatusReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	}
 
 	log.V(8).Info(fmt.Sprintf("sync subnet status to %+v", subnetStatus))
-	return ctrl.Result{}, nil
+	return result, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -146,6 +164,24 @@ func (r *SubnetStatusReconciler) SetupWithManager(mgr ctrl.Manager) error {
 				&utils.IgnoreUpdatePredicate{},
 			),
 		).
+		Watches(&source.Kind{Type: &networkingv1.IPReservation{}},
+			handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+				reservation, ok := object.(*networkingv1.IPReservation)
+				if !ok {
+					return nil
+				}
+				return []reconcile.Request{
+					{
+						NamespacedName: types.NamespacedName{
+							Name: reservation.Spec.Subnet,
+						},
+					},
+				}
+			}),
+			builder.WithPredicates(
+				&utils.IPReservationActiveChangePredicate{},
+			),
+		).
 		WithOptions(controller.Options{
 			MaxConcurrentReconciles: r.Max(),
 		}).
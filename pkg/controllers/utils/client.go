@@ -27,6 +27,7 @@ import (
 
 	multiclusterv1 "github.com/alibaba/hybridnet/pkg/apis/multicluster/v1"
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
 )
 
 func ListNetworks(client client.Reader, opts ...client.ListOption) (*networkingv1.NetworkList, error) {
@@ -61,6 +62,14 @@ func GetSubnet(client client.Reader, name string) (*networkingv1.Subnet, error)
 	return &subnet, nil
 }
 
+func GetNode(client client.Reader, name string) (*corev1.Node, error) {
+	var node = corev1.Node{}
+	if err := client.Get(context.TODO(), types.NamespacedName{Name: name}, &node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
 func ListIPInstances(client client.Reader, opts ...client.ListOption) (*networkingv1.IPInstanceList, error) {
 	var ipList = networkingv1.IPInstanceList{}
 	if err := client.List(context.TODO(), &ipList, opts...); err != nil {
@@ -69,6 +78,60 @@ func ListIPInstances(client client.Reader, opts ...client.ListOption) (*networki
 	return &ipList, nil
 }
 
+// IndexerFieldIPInstancePhase is the field indexer of IPInstance on status.phase, it must be
+// registered before listing ip instances by phase from cache
+const IndexerFieldIPInstancePhase = "status.phase"
+
+// IndexIPInstanceByPhase is the index function of IndexerFieldIPInstancePhase
+func IndexIPInstanceByPhase(obj client.Object) []string {
+	ipInstance, ok := obj.(*networkingv1.IPInstance)
+	if !ok || len(ipInstance.Status.Phase) == 0 {
+		return nil
+	}
+	return []string{string(ipInstance.Status.Phase)}
+}
+
+// ListIPInstancesByPhase lists ip instances in specified phase, the reader must have
+// IndexerFieldIPInstancePhase registered if it reads from cache
+func ListIPInstancesByPhase(c client.Reader, phase networkingv1.IPPhase, opts ...client.ListOption) (*networkingv1.IPInstanceList, error) {
+	return ListIPInstances(c, append(opts, client.MatchingFields{IndexerFieldIPInstancePhase: string(phase)})...)
+}
+
+// ListIPInstancesOfNetworkByPhase lists ip instances of network in specified phase
+func ListIPInstancesOfNetworkByPhase(c client.Reader, networkName string, phase networkingv1.IPPhase, opts ...client.ListOption) (*networkingv1.IPInstanceList, error) {
+	return ListIPInstancesByPhase(c, phase, append(opts, client.MatchingLabels{constants.LabelNetwork: networkName})...)
+}
+
+// usingIPPhases are the phases of ip instances being used by pods, see networkingv1.IsUsingPhase
+var usingIPPhases = []networkingv1.IPPhase{networkingv1.IPPhaseUsing, networkingv1.IPPhaseBinding, networkingv1.IPPhaseBound}
+
+// ListUsingIPInstances lists ip instances being used by pods, no matter whether their nics are configured,
+// the reader must have IndexerFieldIPInstancePhase registered if it reads from cache
+func ListUsingIPInstances(c client.Reader, opts ...client.ListOption) (*networkingv1.IPInstanceList, error) {
+	var ipList = &networkingv1.IPInstanceList{}
+	for _, phase := range usingIPPhases {
+		phaseIPList, err := ListIPInstancesByPhase(c, phase, opts...)
+		if err != nil {
+			return nil, err
+		}
+		ipList.Items = append(ipList.Items, phaseIPList.Items...)
+	}
+	return ipList, nil
+}
+
+// ListUsingIPInstancesOfNetwork lists ip instances of network being used by pods
+func ListUsingIPInstancesOfNetwork(c client.Reader, networkName string, opts ...client.ListOption) (*networkingv1.IPInstanceList, error) {
+	var ipList = &networkingv1.IPInstanceList{}
+	for _, phase := range usingIPPhases {
+		phaseIPList, err := ListIPInstancesOfNetworkByPhase(c, networkName, phase, opts...)
+		if err != nil {
+			return nil, err
+		}
+		ipList.Items = append(ipList.Items, phaseIPList.Items...)
+	}
+	return ipList, nil
+}
+
 func ListNodesToNames(client client.Reader, opts ...client.ListOption) ([]string, error) {
 	var nodeList = corev1.NodeList{}
 	if err := client.List(context.TODO(), &nodeList, opts...); err != nil {
@@ -142,15 +205,20 @@ func FindUnderlayNetworkForNode(client client.Reader, nodeLabels map[string]stri
 		return "", err
 	}
 
+	var matchedNetworks []networkingv1.Network
 	for i := range networkList.Items {
 		var network = networkList.Items[i]
 		// TODO: explicit network type
 		if network.Spec.Type != networkingv1.NetworkTypeOverlay && len(network.Spec.NodeSelector) > 0 {
 			if labels.SelectorFromSet(network.Spec.NodeSelector).Matches(labels.Set(nodeLabels)) {
-				return network.Name, nil
+				matchedNetworks = append(matchedNetworks, network)
 			}
 		}
 	}
+
+	if network, _ := networkingv1.PickNetworkByPriority(matchedNetworks); network != nil {
+		return network.Name, nil
+	}
 	return "", nil
 }
 
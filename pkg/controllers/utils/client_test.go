@@ -0,0 +1,149 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+)
+
+// indexedReader lists ip instances filtered by labels and the phase indexer, as the cache does
+type indexedReader struct {
+	client.Reader
+	ipInstances []networkingv1.IPInstance
+}
+
+func (r *indexedReader) List(_ context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOptions := &client.ListOptions{}
+	listOptions.ApplyOptions(opts)
+
+	ipList := list.(*networkingv1.IPInstanceList)
+	for i := range r.ipInstances {
+		ipInstance := &r.ipInstances[i]
+		if listOptions.LabelSelector != nil && !listOptions.LabelSelector.Matches(labels.Set(ipInstance.Labels)) {
+			continue
+		}
+		if listOptions.FieldSelector != nil {
+			indexed := IndexIPInstanceByPhase(ipInstance)
+			phase, found := listOptions.FieldSelector.RequiresExactMatch(IndexerFieldIPInstancePhase)
+			if found && (len(indexed) == 0 || indexed[0] != phase) {
+				continue
+			}
+		}
+		ipList.Items = append(ipList.Items, *ipInstance)
+	}
+	return nil
+}
+
+func newIndexedReader() *indexedReader {
+	ipInstanceOf := func(name, network string, phase networkingv1.IPPhase) networkingv1.IPInstance {
+		return networkingv1.IPInstance{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{constants.LabelNetwork: network},
+			},
+			Status: networkingv1.IPInstanceStatus{Phase: phase},
+		}
+	}
+
+	return &indexedReader{
+		ipInstances: []networkingv1.IPInstance{
+			ipInstanceOf("network1-bound", "network1", networkingv1.IPPhaseBound),
+			ipInstanceOf("network1-reserved", "network1", networkingv1.IPPhaseReserved),
+			ipInstanceOf("network1-using", "network1", networkingv1.IPPhaseUsing),
+			ipInstanceOf("network1-binding", "network1", networkingv1.IPPhaseBinding),
+			ipInstanceOf("network1-no-phase", "network1", ""),
+			ipInstanceOf("network2-reserved", "network2", networkingv1.IPPhaseReserved),
+			ipInstanceOf("network2-using", "network2", networkingv1.IPPhaseUsing),
+		},
+	}
+}
+
+func ipInstanceNames(ipList *networkingv1.IPInstanceList) []string {
+	var names []string
+	for i := range ipList.Items {
+		names = append(names, ipList.Items[i].Name)
+	}
+	return names
+}
+
+func TestListIPInstancesOfNetworkByPhase(t *testing.T) {
+	tests := []struct {
+		name     string
+		network  string
+		phase    networkingv1.IPPhase
+		expected []string
+	}{
+		{"reserved of network1", "network1", networkingv1.IPPhaseReserved, []string{"network1-reserved"}},
+		{"reserved of network2", "network2", networkingv1.IPPhaseReserved, []string{"network2-reserved"}},
+		{"using of network1", "network1", networkingv1.IPPhaseUsing, []string{"network1-using"}},
+		{"bound of network2", "network2", networkingv1.IPPhaseBound, nil},
+		{"unknown network", "network3", networkingv1.IPPhaseUsing, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ipList, err := ListIPInstancesOfNetworkByPhase(newIndexedReader(), test.network, test.phase)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if names := ipInstanceNames(ipList); !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, names)
+			}
+		})
+	}
+}
+
+func TestListUsingIPInstances(t *testing.T) {
+	ipList, err := ListUsingIPInstances(newIndexedReader())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"network1-using", "network2-using", "network1-binding", "network1-bound"}
+	if names := ipInstanceNames(ipList); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+
+	if ipList, err = ListUsingIPInstancesOfNetwork(newIndexedReader(), "network1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = []string{"network1-using", "network1-binding", "network1-bound"}
+	if names := ipInstanceNames(ipList); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
+
+func TestIndexIPInstanceByPhase(t *testing.T) {
+	if indexed := IndexIPInstanceByPhase(&networkingv1.IPInstance{}); indexed != nil {
+		t.Errorf("expected ip instance without phase not indexed, got %v", indexed)
+	}
+	if indexed := IndexIPInstanceByPhase(&networkingv1.IPBinding{}); indexed != nil {
+		t.Errorf("expected other object not indexed, got %v", indexed)
+	}
+	indexed := IndexIPInstanceByPhase(&networkingv1.IPInstance{Status: networkingv1.IPInstanceStatus{Phase: networkingv1.IPPhaseReserved}})
+	if !reflect.DeepEqual(indexed, []string{string(networkingv1.IPPhaseReserved)}) {
+		t.Errorf("expected indexed by reserved phase, got %v", indexed)
+	}
+}
@@ -0,0 +1,74 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"net"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+// ParseBindingIPs normalizes the ips of ip binding, at most one for each ip family, and orders them
+// with ipv4 first as dual-stack assignment expects, the ip family of them is returned as well
+func ParseBindingIPs(ips []string) ([]string, types.IPFamilyMode, error) {
+	var v4, v6 string
+	for _, ipStr := range ips {
+		ip := net.ParseIP(ipStr)
+		switch {
+		case ip == nil:
+			return nil, "", fmt.Errorf("invalid ip %q", ipStr)
+		case ip.To4() != nil && len(v4) == 0:
+			v4 = ip.String()
+		case ip.To4() == nil && len(v6) == 0:
+			v6 = ip.String()
+		default:
+			return nil, "", fmt.Errorf("at most one ip of each family can be bound, got %v", ips)
+		}
+	}
+
+	switch {
+	case len(v4) > 0 && len(v6) > 0:
+		return []string{v4, v6}, types.DualStack, nil
+	case len(v4) > 0:
+		return []string{v4}, types.IPv4Only, nil
+	case len(v6) > 0:
+		return []string{v6}, types.IPv6Only, nil
+	default:
+		return nil, "", fmt.Errorf("no ip is bound")
+	}
+}
+
+// IPInstancesInUseOfBinding returns the ip instances owned by ip binding and still in use by pod, which
+// must not be garbage-collected along with ip binding
+func IPInstancesInUseOfBinding(binding *networkingv1.IPBinding, ipInstances []networkingv1.IPInstance) []*networkingv1.IPInstance {
+	var inUse []*networkingv1.IPInstance
+	for i := range ipInstances {
+		var ipInstance = &ipInstances[i]
+		if !metav1.IsControlledBy(ipInstance, binding) || !ipInstance.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if !networkingv1.IsUsingPhase(ipInstance.Status.Phase) || len(ipInstance.Status.PodName) == 0 {
+			continue
+		}
+		inUse = append(inUse, ipInstance)
+	}
+	return inUse
+}
@@ -0,0 +1,131 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+func TestParseBindingIPs(t *testing.T) {
+	tests := []struct {
+		name             string
+		ips              []string
+		expectedIPs      []string
+		expectedIPFamily types.IPFamilyMode
+		expectErr        bool
+	}{
+		{
+			"ipv4 only",
+			[]string{"192.168.0.10"},
+			[]string{"192.168.0.10"},
+			types.IPv4Only,
+			false,
+		},
+		{
+			"ipv6 only normalized",
+			[]string{"fe80:0:0:0:0:0:0:10"},
+			[]string{"fe80::10"},
+			types.IPv6Only,
+			false,
+		},
+		{
+			"dual stack ordered with ipv4 first",
+			[]string{"fe80::10", "192.168.0.10"},
+			[]string{"192.168.0.10", "fe80::10"},
+			types.DualStack,
+			false,
+		},
+		{
+			"invalid ip",
+			[]string{"192.168.0.256"},
+			nil,
+			"",
+			true,
+		},
+		{
+			"duplicated family",
+			[]string{"192.168.0.10", "192.168.0.11"},
+			nil,
+			"",
+			true,
+		},
+		{
+			"empty",
+			nil,
+			nil,
+			"",
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ips, ipFamily, err := ParseBindingIPs(test.ips)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expect error %v, got %v", test.expectErr, err)
+			}
+			if !reflect.DeepEqual(ips, test.expectedIPs) || ipFamily != test.expectedIPFamily {
+				t.Errorf("expect %v/%s, got %v/%s", test.expectedIPs, test.expectedIPFamily, ips, ipFamily)
+			}
+		})
+	}
+}
+
+func TestIPInstancesInUseOfBinding(t *testing.T) {
+	isController := true
+	binding := &networkingv1.IPBinding{ObjectMeta: metav1.ObjectMeta{Name: "db-0", UID: "binding-uid"}}
+	ipInstance := func(name string, ownerUID string, phase networkingv1.IPPhase, podName string) networkingv1.IPInstance {
+		return networkingv1.IPInstance{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: networkingv1.GroupVersion.String(), Kind: "IPBinding", Name: "db-0",
+						UID: apitypes.UID(ownerUID), Controller: &isController},
+				},
+			},
+			Status: networkingv1.IPInstanceStatus{Phase: phase, PodName: podName},
+		}
+	}
+
+	now := metav1.Now()
+	deleting := ipInstance("deleting", "binding-uid", networkingv1.IPPhaseUsing, "db-0")
+	deleting.DeletionTimestamp = &now
+
+	ipInstances := []networkingv1.IPInstance{
+		ipInstance("using", "binding-uid", networkingv1.IPPhaseUsing, "db-0"),
+		ipInstance("bound", "binding-uid", networkingv1.IPPhaseBound, "db-0"),
+		ipInstance("reserved", "binding-uid", networkingv1.IPPhaseReserved, "db-0"),
+		ipInstance("no-pod", "binding-uid", networkingv1.IPPhaseUsing, ""),
+		ipInstance("other-binding", "other-uid", networkingv1.IPPhaseUsing, "db-0"),
+		deleting,
+	}
+
+	var names []string
+	for _, ipInstance := range IPInstancesInUseOfBinding(binding, ipInstances) {
+		names = append(names, ipInstance.Name)
+	}
+	if expected := []string{"using", "bound"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expect %v, got %v", expected, names)
+	}
+}
@@ -0,0 +1,87 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"net"
+	"sort"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+)
+
+// IPInstanceAddress returns the normalized ip of address of ip instance, empty if invalid
+func IPInstanceAddress(ipInstance *networkingv1.IPInstance) string {
+	ip, _, err := net.ParseCIDR(ipInstance.Spec.Address.IP)
+	if err != nil {
+		if ip = net.ParseIP(ipInstance.Spec.Address.IP); ip == nil {
+			return ""
+		}
+	}
+	return ip.String()
+}
+
+// GroupDuplicateIPInstances returns the groups of ip instances claiming the same address in the
+// same network, ip instances being deleted are ignored. Groups are sorted by network and address,
+// and ip instances of a group are sorted by namespace and name
+func GroupDuplicateIPInstances(ipInstances []networkingv1.IPInstance) [][]*networkingv1.IPInstance {
+	type addressKey struct {
+		network string
+		address string
+	}
+
+	var claims = map[addressKey][]*networkingv1.IPInstance{}
+	for i := range ipInstances {
+		var ipInstance = &ipInstances[i]
+		if !ipInstance.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		address := IPInstanceAddress(ipInstance)
+		if len(address) == 0 {
+			continue
+		}
+
+		key := addressKey{network: ipInstance.Spec.Network, address: address}
+		claims[key] = append(claims[key], ipInstance)
+	}
+
+	var keys []addressKey
+	for key, group := range claims {
+		if len(group) > 1 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].network != keys[j].network {
+			return keys[i].network < keys[j].network
+		}
+		return keys[i].address < keys[j].address
+	})
+
+	var groups [][]*networkingv1.IPInstance
+	for _, key := range keys {
+		group := claims[key]
+		sort.Slice(group, func(i, j int) bool {
+			if group[i].Namespace != group[j].Namespace {
+				return group[i].Namespace < group[j].Namespace
+			}
+			return group[i].Name < group[j].Name
+		})
+		groups = append(groups, group)
+	}
+	return groups
+}
@@ -0,0 +1,123 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+)
+
+func TestGroupDuplicateIPInstances(t *testing.T) {
+	now := metav1.Now()
+	ipInstanceOf := func(namespace, name, network, ip string, deleting bool) networkingv1.IPInstance {
+		ipInstance := networkingv1.IPInstance{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      name,
+			},
+			Spec: networkingv1.IPInstanceSpec{
+				Network: network,
+				Address: networkingv1.Address{
+					IP: ip,
+				},
+			},
+		}
+		if deleting {
+			ipInstance.DeletionTimestamp = &now
+		}
+		return ipInstance
+	}
+
+	namesOf := func(groups [][]*networkingv1.IPInstance) [][]string {
+		var names [][]string
+		for _, group := range groups {
+			var groupNames []string
+			for _, ipInstance := range group {
+				groupNames = append(groupNames, ipInstance.Namespace+"/"+ipInstance.Name)
+			}
+			names = append(names, groupNames)
+		}
+		return names
+	}
+
+	tests := []struct {
+		name        string
+		ipInstances []networkingv1.IPInstance
+		expected    [][]string
+	}{
+		{
+			"no duplicates",
+			[]networkingv1.IPInstance{
+				ipInstanceOf("ns1", "192-168-0-1", "net1", "192.168.0.1/24", false),
+				ipInstanceOf("ns1", "192-168-0-2", "net1", "192.168.0.2/24", false),
+			},
+			nil,
+		},
+		{
+			"same address in different namespaces",
+			[]networkingv1.IPInstance{
+				ipInstanceOf("ns2", "192-168-0-1", "net1", "192.168.0.1/24", false),
+				ipInstanceOf("ns1", "192-168-0-1", "net1", "192.168.0.1/24", false),
+			},
+			[][]string{{"ns1/192-168-0-1", "ns2/192-168-0-1"}},
+		},
+		{
+			"same address with different names and ipv6 formats",
+			[]networkingv1.IPInstance{
+				ipInstanceOf("ns1", "fe80-0-0-0-0-0-0-1", "net1", "fe80::1/64", false),
+				ipInstanceOf("ns1", "fe80-0-0-0-0-0-0-2", "net1", "fe80:0:0:0:0:0:0:1/64", false),
+			},
+			[][]string{{"ns1/fe80-0-0-0-0-0-0-1", "ns1/fe80-0-0-0-0-0-0-2"}},
+		},
+		{
+			"same address in different networks",
+			[]networkingv1.IPInstance{
+				ipInstanceOf("ns1", "192-168-0-1", "net1", "192.168.0.1/24", false),
+				ipInstanceOf("ns2", "192-168-0-1", "net2", "192.168.0.1/24", false),
+			},
+			nil,
+		},
+		{
+			"duplicate being deleted",
+			[]networkingv1.IPInstance{
+				ipInstanceOf("ns1", "192-168-0-1", "net1", "192.168.0.1/24", false),
+				ipInstanceOf("ns2", "192-168-0-1", "net1", "192.168.0.1/24", true),
+			},
+			nil,
+		},
+		{
+			"invalid address",
+			[]networkingv1.IPInstance{
+				ipInstanceOf("ns1", "a", "net1", "", false),
+				ipInstanceOf("ns2", "b", "net1", "", false),
+			},
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if names := namesOf(GroupDuplicateIPInstances(test.ipInstances)); !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, names)
+			}
+		})
+	}
+}
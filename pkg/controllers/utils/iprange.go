@@ -0,0 +1,53 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/containernetworking/plugins/pkg/ip"
+)
+
+// ExpandIPRange lists the ips from start to end in cidr, end is the same as start if empty, a range
+// larger than maxSize is rejected to keep the listed ips bounded
+func ExpandIPRange(start, end string, cidr *net.IPNet, maxSize int) ([]string, error) {
+	if len(end) == 0 {
+		end = start
+	}
+
+	startIP, endIP := net.ParseIP(start), net.ParseIP(end)
+	switch {
+	case startIP == nil:
+		return nil, fmt.Errorf("invalid start ip %q", start)
+	case endIP == nil:
+		return nil, fmt.Errorf("invalid end ip %q", end)
+	case !cidr.Contains(startIP) || !cidr.Contains(endIP):
+		return nil, fmt.Errorf("range %v-%v is not in cidr %v", startIP, endIP, cidr)
+	case ip.Cmp(startIP, endIP) > 0:
+		return nil, fmt.Errorf("start ip %v is larger than end ip %v", startIP, endIP)
+	}
+
+	var ips []string
+	for i := startIP; ip.Cmp(i, endIP) <= 0; i = ip.NextIP(i) {
+		if len(ips) == maxSize {
+			return nil, fmt.Errorf("range %v-%v is larger than %d ips", startIP, endIP, maxSize)
+		}
+		ips = append(ips, i.String())
+	}
+	return ips, nil
+}
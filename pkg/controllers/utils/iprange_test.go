@@ -0,0 +1,58 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestExpandIPRange(t *testing.T) {
+	_, v4CIDR, _ := net.ParseCIDR("192.168.0.0/24")
+	_, v6CIDR, _ := net.ParseCIDR("fd00::/120")
+
+	tests := []struct {
+		name       string
+		start, end string
+		cidr       *net.IPNet
+		expected   []string
+		expectErr  bool
+	}{
+		{"single ip", "192.168.0.10", "", v4CIDR, []string{"192.168.0.10"}, false},
+		{"ipv4 range", "192.168.0.10", "192.168.0.12", v4CIDR, []string{"192.168.0.10", "192.168.0.11", "192.168.0.12"}, false},
+		{"ipv6 range", "fd00::fe", "fd00::ff", v6CIDR, []string{"fd00::fe", "fd00::ff"}, false},
+		{"max size", "192.168.0.10", "192.168.0.13", v4CIDR, []string{"192.168.0.10", "192.168.0.11", "192.168.0.12", "192.168.0.13"}, false},
+		{"too large", "192.168.0.10", "192.168.0.14", v4CIDR, nil, true},
+		{"invalid start", "192.168.0", "", v4CIDR, nil, true},
+		{"invalid end", "192.168.0.10", "fd00::", v4CIDR, nil, true},
+		{"out of cidr", "192.168.0.255", "192.168.1.1", v4CIDR, nil, true},
+		{"reversed", "192.168.0.12", "192.168.0.10", v4CIDR, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ips, err := ExpandIPRange(test.start, test.end, test.cidr, 4)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expect error %v, got %v", test.expectErr, err)
+			}
+			if !reflect.DeepEqual(ips, test.expected) {
+				t.Errorf("expect %v, got %v", test.expected, ips)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/feature"
+	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
+	globalutils "github.com/alibaba/hybridnet/pkg/utils"
+)
+
+// NetworkConfig is the networking config specified on object, e.g., pod or namespace, it is the
+// only place to resolve networking config from annotations and labels, which makes webhook and
+// manager agree on the network of pod
+type NetworkConfig struct {
+	NetworkName string
+	// SubnetNames is the raw specified subnet string, which is "<ipv4 subnet>/<ipv6 subnet>" in dual stack mode
+	SubnetNames string
+	NetworkType string
+	IPFamily    string
+	NetIDRange  string
+}
+
+// ParseNetworkConfigOfObject parses networking config from annotations and labels of object, annotations
+// take precedence over labels, ip family and net ID range are only specified by annotations
+func ParseNetworkConfigOfObject(obj client.Object) *NetworkConfig {
+	annotations, labels := obj.GetAnnotations(), obj.GetLabels()
+	return &NetworkConfig{
+		NetworkName: globalutils.PickFirstNonEmptyString(annotations[constants.AnnotationSpecifiedNetwork],
+			labels[constants.LabelSpecifiedNetwork]),
+		SubnetNames: globalutils.PickFirstNonEmptyString(annotations[constants.AnnotationSpecifiedSubnet],
+			labels[constants.LabelSpecifiedSubnet]),
+		NetworkType: globalutils.PickFirstNonEmptyString(annotations[constants.AnnotationNetworkType],
+			labels[constants.LabelNetworkType]),
+		IPFamily:   annotations[constants.AnnotationIPFamily],
+		NetIDRange: annotations[constants.AnnotationSpecifiedNetIDRange],
+	}
+}
+
+// ResolveNetworkConfigOfObject parses networking config of object and validates the specified subnets, the
+// network is determined by the subnets if not specified
+func ResolveNetworkConfigOfObject(ctx context.Context, c client.Reader, obj client.Object) (*NetworkConfig, error) {
+	return resolveNetworkConfigOfObject(ctx, c, obj, false)
+}
+
+// ResolveNetworkConfigOfObjectIgnoringMissingSubnets resolves networking config as ResolveNetworkConfigOfObject,
+// except that the specified subnets which are not found never determine the network, and the specified network
+// is kept, so that the missing subnets are left to the specified subnet policy of network on allocation
+func ResolveNetworkConfigOfObjectIgnoringMissingSubnets(ctx context.Context, c client.Reader, obj client.Object) (*NetworkConfig, error) {
+	return resolveNetworkConfigOfObject(ctx, c, obj, true)
+}
+
+func resolveNetworkConfigOfObject(ctx context.Context, c client.Reader, obj client.Object, ignoreMissingSubnets bool) (*NetworkConfig, error) {
+	config := ParseNetworkConfigOfObject(obj)
+
+	subnetNames := SplitSpecifiedSubnets(config.SubnetNames)
+	if len(subnetNames) > 2 {
+		return nil, fmt.Errorf("cannot have more than two specified subnet in dualstack")
+	}
+
+	var networkNameFromSubnet string
+	for index, subnetName := range subnetNames {
+		subnet := &networkingv1.Subnet{}
+		if err := c.Get(ctx, types.NamespacedName{Name: subnetName}, subnet); err != nil {
+			if ignoreMissingSubnets {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return nil, fmt.Errorf("unable to get specified subnet %s: %v", subnetName, err)
+			}
+			return nil, fmt.Errorf("specified subnet %s not found", subnetName)
+		}
+
+		if len(subnetNames) == 2 {
+			if index == 0 {
+				if subnet.Spec.Range.Version != networkingv1.IPv4 {
+					return nil, fmt.Errorf("when both ipv4/ipv6 subnets are specified, " +
+						"the subnet name in front of the \"/\" should be an ipv4 subnet")
+				}
+			} else {
+				if subnet.Spec.Range.Version != networkingv1.IPv6 {
+					return nil, fmt.Errorf("when both ipv4/ipv6 subnets are specified, " +
+						"the subnet name after the \"/\" should be an ipv6 subnet")
+				}
+			}
+		}
+
+		if len(networkNameFromSubnet) == 0 {
+			networkNameFromSubnet = subnet.Spec.Network
+		} else if networkNameFromSubnet != subnet.Spec.Network {
+			return nil, fmt.Errorf("the networks of ipv4/ipv6 subnets need to be the same")
+		}
+	}
+
+	if len(networkNameFromSubnet) != 0 {
+		if len(config.NetworkName) == 0 {
+			// subnet can also determine the specified network
+			config.NetworkName = networkNameFromSubnet
+		}
+
+		if config.NetworkName != networkNameFromSubnet {
+			return nil, fmt.Errorf("specified network and subnet conflict in %s %s/%s",
+				obj.GetObjectKind().GroupVersionKind().String(),
+				obj.GetNamespace(),
+				obj.GetName(),
+			)
+		}
+	}
+
+	return config, nil
+}
+
+// IsEmpty checks whether no networking config is specified
+func (n *NetworkConfig) IsEmpty() bool {
+	return len(n.NetworkName) == 0 && len(n.SubnetNames) == 0 && len(n.NetworkType) == 0 && len(n.IPFamily) == 0 &&
+		len(n.NetIDRange) == 0
+}
+
+// NetworkTypeOf returns the network type, which inherits from the specified network if not specified
+// explicitly, and falls back on the default network type if neither is specified, network can be nil
+func (n *NetworkConfig) NetworkTypeOf(network *networkingv1.Network) ipamtypes.NetworkType {
+	if len(n.NetworkType) == 0 && network != nil {
+		return ipamtypes.ParseNetworkTypeFromString(string(networkingv1.GetNetworkType(network)))
+	}
+	return ipamtypes.ParseNetworkTypeFromString(n.NetworkType)
+}
+
+// IPFamilyOf returns the ip family, which inherits from the default ip family of network if not specified
+// explicitly, and falls back on the global default ip family if neither is specified, network can be nil
+func (n *NetworkConfig) IPFamilyOf(network *networkingv1.Network) ipamtypes.IPFamilyMode {
+	if len(n.IPFamily) == 0 && network != nil && len(network.Spec.DefaultIPFamily) > 0 {
+		return ipamtypes.ParseIPFamilyFromString(string(network.Spec.DefaultIPFamily))
+	}
+	return ipamtypes.ParseIPFamilyFromString(n.IPFamily)
+}
+
+// SplitSpecifiedSubnets splits the specified subnet string into subnet names, only one subnet can be
+// specified if dual stack mode is not enabled
+func SplitSpecifiedSubnets(specifiedSubnetString string) (subnetNames []string) {
+	if len(specifiedSubnetString) > 0 {
+		if feature.DualStackEnabled() {
+			subnetNames = strings.Split(specifiedSubnetString, "/")
+		} else {
+			subnetNames = []string{specifiedSubnetString}
+		}
+	}
+	return
+}
@@ -0,0 +1,365 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+func TestParseNetworkConfigOfObject(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		labels      map[string]string
+		expected    NetworkConfig
+	}{
+		{
+			"nothing specified",
+			nil,
+			nil,
+			NetworkConfig{},
+		},
+		{
+			"specified by labels",
+			nil,
+			map[string]string{
+				constants.LabelSpecifiedNetwork: "network1",
+				constants.LabelSpecifiedSubnet:  "subnet1",
+				constants.LabelNetworkType:      "Underlay",
+			},
+			NetworkConfig{NetworkName: "network1", SubnetNames: "subnet1", NetworkType: "Underlay"},
+		},
+		{
+			"annotations take precedence over labels",
+			map[string]string{
+				constants.AnnotationSpecifiedNetwork: "network2",
+				constants.AnnotationNetworkType:      "Overlay",
+				constants.AnnotationIPFamily:         "DualStack",
+			},
+			map[string]string{
+				constants.LabelSpecifiedNetwork: "network1",
+				constants.LabelSpecifiedSubnet:  "subnet1",
+				constants.LabelNetworkType:      "Underlay",
+			},
+			NetworkConfig{NetworkName: "network2", SubnetNames: "subnet1", NetworkType: "Overlay", IPFamily: "DualStack"},
+		},
+		{
+			"ip family and net id range are only specified by annotations",
+			map[string]string{
+				constants.AnnotationSpecifiedNetIDRange: "100-200",
+			},
+			map[string]string{
+				constants.AnnotationIPFamily:            "IPv6",
+				constants.AnnotationSpecifiedNetIDRange: "300-400",
+			},
+			NetworkConfig{NetIDRange: "100-200"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			meta := metav1.ObjectMeta{Name: "test", Annotations: test.annotations, Labels: test.labels}
+			// pod and namespace must be parsed in the same way
+			for _, config := range []*NetworkConfig{
+				ParseNetworkConfigOfObject(&v1.Pod{ObjectMeta: meta}),
+				ParseNetworkConfigOfObject(&v1.Namespace{ObjectMeta: meta}),
+			} {
+				if !reflect.DeepEqual(*config, test.expected) {
+					t.Errorf("test %s fails: expected %+v but got %+v", test.name, test.expected, *config)
+				}
+				if config.IsEmpty() != reflect.DeepEqual(test.expected, NetworkConfig{}) {
+					t.Errorf("test %s fails: unexpected emptiness of %+v", test.name, *config)
+				}
+			}
+		})
+	}
+}
+
+func TestNetworkConfigNetworkTypeOf(t *testing.T) {
+	overlayNetwork := &networkingv1.Network{
+		Spec: networkingv1.NetworkSpec{
+			Type: networkingv1.NetworkTypeOverlay,
+		},
+	}
+
+	tests := []struct {
+		name        string
+		networkType string
+		network     *networkingv1.Network
+		expected    ipamtypes.NetworkType
+	}{
+		{
+			"specified type",
+			"underlay",
+			nil,
+			ipamtypes.Underlay,
+		},
+		{
+			"specified type takes precedence over network",
+			"Underlay",
+			overlayNetwork,
+			ipamtypes.Underlay,
+		},
+		{
+			"inherit from network",
+			"",
+			overlayNetwork,
+			ipamtypes.Overlay,
+		},
+		{
+			"inherit from network without type",
+			"",
+			&networkingv1.Network{},
+			ipamtypes.Underlay,
+		},
+		{
+			"default type",
+			"",
+			nil,
+			ipamtypes.ParseNetworkTypeFromString(""),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &NetworkConfig{NetworkType: test.networkType}
+			if actual := config.NetworkTypeOf(test.network); actual != test.expected {
+				t.Errorf("test %s fails: expected %s but got %s", test.name, test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestNetworkConfigIPFamilyOf(t *testing.T) {
+	defer func() {
+		_ = utilfeature.DefaultMutableFeatureGate.Set("DualStack=false")
+	}()
+	if err := utilfeature.DefaultMutableFeatureGate.Set("DualStack=true"); err != nil {
+		t.Fatalf("fail to set feature gates: %v", err)
+	}
+
+	dualStackNetwork := &networkingv1.Network{
+		Spec: networkingv1.NetworkSpec{
+			DefaultIPFamily: networkingv1.IPFamilyDualStack,
+		},
+	}
+
+	tests := []struct {
+		name     string
+		ipFamily string
+		network  *networkingv1.Network
+		expected ipamtypes.IPFamilyMode
+	}{
+		{
+			"specified family",
+			"ipv6",
+			nil,
+			ipamtypes.IPv6Only,
+		},
+		{
+			"specified family takes precedence over network",
+			"IPv4Only",
+			dualStackNetwork,
+			ipamtypes.IPv4Only,
+		},
+		{
+			"inherit from network",
+			"",
+			dualStackNetwork,
+			ipamtypes.DualStack,
+		},
+		{
+			"network without default family",
+			"",
+			&networkingv1.Network{},
+			ipamtypes.ParseIPFamilyFromString(""),
+		},
+		{
+			"default family",
+			"",
+			nil,
+			ipamtypes.ParseIPFamilyFromString(""),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &NetworkConfig{IPFamily: test.ipFamily}
+			if actual := config.IPFamilyOf(test.network); actual != test.expected {
+				t.Errorf("test %s fails: expected %s but got %s", test.name, test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSplitSpecifiedSubnets(t *testing.T) {
+	tests := []struct {
+		name         string
+		featureGates string
+		in           string
+		expected     []string
+	}{
+		{
+			"empty",
+			"DualStack=true",
+			"",
+			nil,
+		},
+		{
+			"dual stack subnets",
+			"DualStack=true",
+			"subnet1/subnet2",
+			[]string{"subnet1", "subnet2"},
+		},
+		{
+			"single stack",
+			"DualStack=false",
+			"subnet1/subnet2",
+			[]string{"subnet1/subnet2"},
+		},
+	}
+
+	defer func() {
+		_ = utilfeature.DefaultMutableFeatureGate.Set("DualStack=false")
+	}()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := utilfeature.DefaultMutableFeatureGate.Set(test.featureGates); err != nil {
+				t.Fatalf("fail to set feature gates %s: %v", test.featureGates, err)
+			}
+			if actual := SplitSpecifiedSubnets(test.in); !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("test %s fails: expected %v but got %v", test.name, test.expected, actual)
+			}
+		})
+	}
+}
+
+// subnetReader serves subnets by name
+type subnetReader struct {
+	client.Reader
+	subnets map[string]*networkingv1.Subnet
+}
+
+func (s *subnetReader) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	subnet, exist := s.subnets[key.Name]
+	if !exist {
+		return apierrors.NewNotFound(networkingv1.GroupVersion.WithResource("subnets").GroupResource(), key.Name)
+	}
+	subnet.DeepCopyInto(obj.(*networkingv1.Subnet))
+	return nil
+}
+
+func TestResolveNetworkConfigOfObject(t *testing.T) {
+	reader := &subnetReader{
+		subnets: map[string]*networkingv1.Subnet{
+			"subnet1": {
+				ObjectMeta: metav1.ObjectMeta{Name: "subnet1"},
+				Spec:       networkingv1.SubnetSpec{Network: "network1"},
+			},
+		},
+	}
+	podWith := func(annotations map[string]string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "default", Annotations: annotations}}
+	}
+
+	tests := []struct {
+		name                  string
+		pod                   *v1.Pod
+		expectedNetwork       string
+		expectErr             bool
+		expectedLenientNet    string
+		expectLenientErr      bool
+		expectedLenientSubnet string
+	}{
+		{
+			"network determined by subnet",
+			podWith(map[string]string{constants.AnnotationSpecifiedSubnet: "subnet1"}),
+			"network1",
+			false,
+			"network1",
+			false,
+			"subnet1",
+		},
+		{
+			"missing subnet with network",
+			podWith(map[string]string{
+				constants.AnnotationSpecifiedNetwork: "network1",
+				constants.AnnotationSpecifiedSubnet:  "deleted",
+			}),
+			"",
+			true,
+			"network1",
+			false,
+			"deleted",
+		},
+		{
+			"missing subnet without network",
+			podWith(map[string]string{constants.AnnotationSpecifiedSubnet: "deleted"}),
+			"",
+			true,
+			"",
+			false,
+			"deleted",
+		},
+		{
+			"conflicted network and subnet",
+			podWith(map[string]string{
+				constants.AnnotationSpecifiedNetwork: "network2",
+				constants.AnnotationSpecifiedSubnet:  "subnet1",
+			}),
+			"",
+			true,
+			"",
+			true,
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := ResolveNetworkConfigOfObject(context.Background(), reader, test.pod)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expect error %v, got %v", test.expectErr, err)
+			}
+			if err == nil && config.NetworkName != test.expectedNetwork {
+				t.Errorf("expect network %q, got %q", test.expectedNetwork, config.NetworkName)
+			}
+
+			config, err = ResolveNetworkConfigOfObjectIgnoringMissingSubnets(context.Background(), reader, test.pod)
+			if (err != nil) != test.expectLenientErr {
+				t.Fatalf("expect lenient error %v, got %v", test.expectLenientErr, err)
+			}
+			if err == nil && (config.NetworkName != test.expectedLenientNet || config.SubnetNames != test.expectedLenientSubnet) {
+				t.Errorf("expect network %q and subnets %q, got %q and %q", test.expectedLenientNet,
+					test.expectedLenientSubnet, config.NetworkName, config.SubnetNames)
+			}
+		})
+	}
+}
@@ -16,7 +16,24 @@
 
 package utils
 
-import v1 "k8s.io/api/core/v1"
+import (
+	"math"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	globalutils "github.com/alibaba/hybridnet/pkg/utils"
+)
+
+// PodIsExternallyAddressed returns whether ip instances of pod are created by an external controller
+func PodIsExternallyAddressed(pod *v1.Pod) bool {
+	return globalutils.ParseBoolOrDefault(pod.Annotations[constants.AnnotationExternalIP], false)
+}
 
 func PodIsEvicted(pod *v1.Pod) bool {
 	return pod.Status.Phase == v1.PodFailed && pod.Status.Reason == "Evicted"
@@ -42,3 +59,117 @@ func PodIsCompleted(pod *v1.Pod) bool {
 
 	return pod.Status.Phase == v1.PodSucceeded && unknownContainerCount == 0
 }
+
+// PodContainersStopped checks whether all the containers of pod are terminated, containers
+// which are waiting to start are not stopped because pod sandbox may be still alive
+func PodContainersStopped(pod *v1.Pod) bool {
+	if len(pod.Spec.NodeName) == 0 || pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return true
+	}
+
+	if len(pod.Status.ContainerStatuses) < len(pod.Spec.Containers) {
+		return false
+	}
+
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].State.Terminated == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// PodTerminationWait returns how long a terminating pod should be waited until it is truly gone,
+// which is either all the containers are stopped or the deletion grace period has passed
+func PodTerminationWait(pod *v1.Pod, now time.Time) time.Duration {
+	if pod.DeletionTimestamp == nil || PodContainersStopped(pod) {
+		return 0
+	}
+
+	// deletion timestamp is the deadline of graceful termination
+	if wait := pod.DeletionTimestamp.Time.Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// PodFinalizerIsStale checks whether the ip finalizer of pod is left after its ips have been reserved
+// or released, e.g., out-of-band, which means none of the ip instances is still used by pod
+func PodFinalizerIsStale(pod *v1.Pod, ipInstances []networkingv1.IPInstance) bool {
+	if !controllerutil.ContainsFinalizer(pod, constants.FinalizerIPAllocated) {
+		return false
+	}
+
+	for i := range ipInstances {
+		if ipInstances[i].DeletionTimestamp.IsZero() && ipInstances[i].Status.PodName == pod.Name &&
+			networkingv1.IsUsingPhase(ipInstances[i].Status.Phase) {
+			return false
+		}
+	}
+	return true
+}
+
+// PodIsScaledDownToRelease checks whether ips of stateful pod should be released rather than reserved,
+// which requires the pod to be removed by scaling down of its StatefulSet with release policy
+func PodIsScaledDownToRelease(pod *v1.Pod, sts *appsv1.StatefulSet) bool {
+	if sts == nil || !strings.EqualFold(sts.Annotations[constants.AnnotationIPScaleDownPolicy], constants.IPScaleDownPolicyRelease) {
+		return false
+	}
+
+	idx := GetIndexFromName(pod.Name)
+	if idx == math.MaxInt32 {
+		return false
+	}
+
+	var replicas int32 = 1
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	return idx >= int(replicas)
+}
+
+// PodCrashLoopingSince returns since when pod has been crash-looping, which means none of its containers
+// is running and some are backing off from crashes. The start is approximated by the last transition of
+// ready condition, so false is returned if pod is ready or the condition is missing
+func PodCrashLoopingSince(pod *v1.Pod) (time.Time, bool) {
+	if pod.Status.Phase != v1.PodRunning && pod.Status.Phase != v1.PodPending {
+		return time.Time{}, false
+	}
+
+	var backingOff bool
+	for i := range pod.Status.ContainerStatuses {
+		state := pod.Status.ContainerStatuses[i].State
+		if state.Running != nil {
+			return time.Time{}, false
+		}
+		if state.Waiting != nil && state.Waiting.Reason == "CrashLoopBackOff" {
+			backingOff = true
+		}
+	}
+	if !backingOff {
+		return time.Time{}, false
+	}
+
+	for i := range pod.Status.Conditions {
+		if condition := pod.Status.Conditions[i]; condition.Type == v1.PodReady {
+			if condition.Status == v1.ConditionTrue || condition.LastTransitionTime.IsZero() {
+				return time.Time{}, false
+			}
+			return condition.LastTransitionTime.Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// PodIPAllocationFailureIsRecorded checks whether the allocation failure should not be reported on ip allocated
+// condition of pod, which is either the same failure has been reported or ips of pod are already committed
+// and a later failure must not hide the allocation in use
+func PodIPAllocationFailureIsRecorded(pod *v1.Pod, reason, message string) bool {
+	for i := range pod.Status.Conditions {
+		if condition := pod.Status.Conditions[i]; condition.Type == constants.PodConditionIPAllocated {
+			return condition.Status == v1.ConditionTrue ||
+				(condition.Reason == reason && condition.Message == message)
+		}
+	}
+	return false
+}
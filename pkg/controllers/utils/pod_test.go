@@ -0,0 +1,416 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+)
+
+func TestPodTerminationWait(t *testing.T) {
+	now := time.Now()
+	longGracePeriodDeadline := metav1.NewTime(now.Add(10 * time.Minute))
+	passedDeadline := metav1.NewTime(now.Add(-time.Second))
+
+	runningStatus := []v1.ContainerStatus{
+		{
+			Name: "app",
+			State: v1.ContainerState{
+				Running: &v1.ContainerStateRunning{},
+			},
+		},
+	}
+	terminatedStatus := []v1.ContainerStatus{
+		{
+			Name: "app",
+			State: v1.ContainerState{
+				Terminated: &v1.ContainerStateTerminated{},
+			},
+		},
+	}
+
+	newPod := func(deletionTimestamp *metav1.Time, statuses []v1.ContainerStatus) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				DeletionTimestamp: deletionTimestamp,
+			},
+			Spec: v1.PodSpec{
+				NodeName: "node1",
+				Containers: []v1.Container{
+					{
+						Name: "app",
+					},
+				},
+			},
+			Status: v1.PodStatus{
+				Phase:             v1.PodRunning,
+				ContainerStatuses: statuses,
+			},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		pod        *v1.Pod
+		expectWait bool
+	}{
+		{
+			"not terminating",
+			newPod(nil, runningStatus),
+			false,
+		},
+		{
+			"running in long grace period",
+			newPod(&longGracePeriodDeadline, runningStatus),
+			true,
+		},
+		{
+			"no container status in long grace period",
+			newPod(&longGracePeriodDeadline, nil),
+			true,
+		},
+		{
+			"terminated in long grace period",
+			newPod(&longGracePeriodDeadline, terminatedStatus),
+			false,
+		},
+		{
+			"running after grace period",
+			newPod(&passedDeadline, runningStatus),
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wait := PodTerminationWait(test.pod, now)
+			if (wait > 0) != test.expectWait {
+				t.Errorf("test %s fails: expected waiting %v but got %v", test.name, test.expectWait, wait)
+			}
+			if test.expectWait && wait != longGracePeriodDeadline.Sub(now) {
+				t.Errorf("test %s fails: expected waiting until grace period ends but got %v", test.name, wait)
+			}
+		})
+	}
+}
+
+func TestPodIsExternallyAddressed(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    bool
+	}{
+		{
+			"no annotation",
+			nil,
+			false,
+		},
+		{
+			"externally addressed",
+			map[string]string{constants.AnnotationExternalIP: "true"},
+			true,
+		},
+		{
+			"explicitly not externally addressed",
+			map[string]string{constants.AnnotationExternalIP: "false"},
+			false,
+		},
+		{
+			"invalid annotation",
+			map[string]string{constants.AnnotationExternalIP: "yes"},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: test.annotations}}
+			if got := PodIsExternallyAddressed(pod); got != test.expected {
+				t.Errorf("test %s fails: expected %v but got %v", test.name, test.expected, got)
+			}
+		})
+	}
+}
+
+func TestPodIsScaledDownToRelease(t *testing.T) {
+	var three int32 = 3
+
+	newStatefulSet := func(policy string, replicas *int32) *appsv1.StatefulSet {
+		sts := &appsv1.StatefulSet{
+			Spec: appsv1.StatefulSetSpec{
+				Replicas: replicas,
+			},
+		}
+		if len(policy) > 0 {
+			sts.Annotations = map[string]string{constants.AnnotationIPScaleDownPolicy: policy}
+		}
+		return sts
+	}
+
+	tests := []struct {
+		name     string
+		podName  string
+		sts      *appsv1.StatefulSet
+		expected bool
+	}{
+		{
+			"no statefulset",
+			"sts-3",
+			nil,
+			false,
+		},
+		{
+			"default policy",
+			"sts-3",
+			newStatefulSet("", &three),
+			false,
+		},
+		{
+			"reserve policy",
+			"sts-3",
+			newStatefulSet(constants.IPScaleDownPolicyReserve, &three),
+			false,
+		},
+		{
+			"ordinal above replicas",
+			"sts-4",
+			newStatefulSet(constants.IPScaleDownPolicyRelease, &three),
+			true,
+		},
+		{
+			"ordinal equal to replicas",
+			"sts-3",
+			newStatefulSet("release", &three),
+			true,
+		},
+		{
+			"ordinal below replicas",
+			"sts-2",
+			newStatefulSet(constants.IPScaleDownPolicyRelease, &three),
+			false,
+		},
+		{
+			"default replicas",
+			"sts-1",
+			newStatefulSet(constants.IPScaleDownPolicyRelease, nil),
+			true,
+		},
+		{
+			"invalid ordinal",
+			"sts-x",
+			newStatefulSet(constants.IPScaleDownPolicyRelease, &three),
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: test.podName}}
+			if got := PodIsScaledDownToRelease(pod, test.sts); got != test.expected {
+				t.Errorf("test %s fails: expected %v but got %v", test.name, test.expected, got)
+			}
+		})
+	}
+}
+
+func TestPodFinalizerIsStale(t *testing.T) {
+	now := metav1.Now()
+
+	newIPInstance := func(podName string, phase networkingv1.IPPhase, deleting bool) networkingv1.IPInstance {
+		ipInstance := networkingv1.IPInstance{
+			Status: networkingv1.IPInstanceStatus{
+				PodName: podName,
+				Phase:   phase,
+			},
+		}
+		if deleting {
+			ipInstance.DeletionTimestamp = &now
+		}
+		return ipInstance
+	}
+
+	tests := []struct {
+		name        string
+		finalizers  []string
+		ipInstances []networkingv1.IPInstance
+		expected    bool
+	}{
+		{
+			"no finalizer",
+			nil,
+			nil,
+			false,
+		},
+		{
+			"ip instances are gone but finalizer persists",
+			[]string{constants.FinalizerIPAllocated},
+			nil,
+			true,
+		},
+		{
+			"ip instance still used",
+			[]string{constants.FinalizerIPAllocated},
+			[]networkingv1.IPInstance{
+				newIPInstance("pod-0", networkingv1.IPPhaseUsing, false),
+			},
+			false,
+		},
+		{
+			"ip instance reserved",
+			[]string{constants.FinalizerIPAllocated},
+			[]networkingv1.IPInstance{
+				newIPInstance("pod-0", networkingv1.IPPhaseReserved, false),
+			},
+			true,
+		},
+		{
+			"ip instance being deleted",
+			[]string{constants.FinalizerIPAllocated},
+			[]networkingv1.IPInstance{
+				newIPInstance("pod-0", networkingv1.IPPhaseUsing, true),
+			},
+			true,
+		},
+		{
+			"ip instance used by another pod",
+			[]string{constants.FinalizerIPAllocated},
+			[]networkingv1.IPInstance{
+				newIPInstance("pod-1", networkingv1.IPPhaseUsing, false),
+			},
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-0", Finalizers: test.finalizers}}
+			if got := PodFinalizerIsStale(pod, test.ipInstances); got != test.expected {
+				t.Errorf("test %s fails: expected %v but got %v", test.name, test.expected, got)
+			}
+		})
+	}
+}
+
+func TestPodCrashLoopingSince(t *testing.T) {
+	since := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+
+	backingOff := v1.ContainerStatus{
+		Name: "app",
+		State: v1.ContainerState{
+			Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"},
+		},
+	}
+	running := v1.ContainerStatus{
+		Name: "sidecar",
+		State: v1.ContainerState{
+			Running: &v1.ContainerStateRunning{},
+		},
+	}
+	completed := v1.ContainerStatus{
+		Name: "sidecar",
+		State: v1.ContainerState{
+			Terminated: &v1.ContainerStateTerminated{Reason: "Completed"},
+		},
+	}
+	notReady := []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionFalse, LastTransitionTime: since}}
+	ready := []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue, LastTransitionTime: since}}
+
+	tests := []struct {
+		name       string
+		phase      v1.PodPhase
+		statuses   []v1.ContainerStatus
+		conditions []v1.PodCondition
+		expected   bool
+	}{
+		{"crash-looping", v1.PodRunning, []v1.ContainerStatus{backingOff}, notReady, true},
+		{"crash-looping with completed container", v1.PodRunning, []v1.ContainerStatus{backingOff, completed}, notReady, true},
+		{"crash-looping with running container", v1.PodRunning, []v1.ContainerStatus{backingOff, running}, notReady, false},
+		{"running", v1.PodRunning, []v1.ContainerStatus{running}, ready, false},
+		{"ready condition missing", v1.PodRunning, []v1.ContainerStatus{backingOff}, nil, false},
+		{"ready", v1.PodRunning, []v1.ContainerStatus{backingOff}, ready, false},
+		{"failed", v1.PodFailed, []v1.ContainerStatus{backingOff}, notReady, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &v1.Pod{
+				Status: v1.PodStatus{
+					Phase:             test.phase,
+					ContainerStatuses: test.statuses,
+					Conditions:        test.conditions,
+				},
+			}
+
+			got, crashLooping := PodCrashLoopingSince(pod)
+			if crashLooping != test.expected {
+				t.Fatalf("expected crash-looping %v, got %v", test.expected, crashLooping)
+			}
+			if crashLooping && !got.Equal(since.Time) {
+				t.Errorf("expected since %v, got %v", since.Time, got)
+			}
+		})
+	}
+}
+
+func TestPodIPAllocationFailureIsRecorded(t *testing.T) {
+	const (
+		reason  = constants.ReasonIPAllocationFailed
+		message = "no available subnet"
+	)
+
+	tests := []struct {
+		name       string
+		conditions []v1.PodCondition
+		expected   bool
+	}{
+		{"condition missing", nil, false},
+		{"ip allocated", []v1.PodCondition{
+			{Type: constants.PodConditionIPAllocated, Status: v1.ConditionTrue, Reason: constants.ReasonIPAllocated},
+		}, true},
+		{"ip released", []v1.PodCondition{
+			{Type: constants.PodConditionIPAllocated, Status: v1.ConditionFalse, Reason: constants.ReasonIPReleased},
+		}, false},
+		{"same failure", []v1.PodCondition{
+			{Type: constants.PodConditionIPAllocated, Status: v1.ConditionFalse, Reason: reason, Message: message},
+		}, true},
+		{"different failure", []v1.PodCondition{
+			{Type: constants.PodConditionIPAllocated, Status: v1.ConditionFalse, Reason: reason, Message: "subnet is full"},
+		}, false},
+		{"exhausted", []v1.PodCondition{
+			{Type: constants.PodConditionIPAllocated, Status: v1.ConditionFalse, Reason: constants.ReasonNetworkExhausted, Message: message},
+		}, false},
+		{"other condition", []v1.PodCondition{
+			{Type: v1.PodReady, Status: v1.ConditionTrue},
+		}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &v1.Pod{Status: v1.PodStatus{Conditions: test.conditions}}
+			if got := PodIPAllocationFailureIsRecorded(pod, reason, message); got != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
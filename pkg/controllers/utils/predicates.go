@@ -19,6 +19,7 @@ package utils
 import (
 	"reflect"
 
+	corev1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
@@ -46,6 +47,42 @@ func (IgnoreUpdatePredicate) Update(e event.UpdateEvent) bool {
 	return false
 }
 
+// PodScheduledPredicate only passes the non-terminating pod which is just bound to a node or
+// reassigned to another node, or already bound but not networked yet when it is created
+type PodScheduledPredicate struct {
+	predicate.Funcs
+}
+
+// Create is called for every existing pod on startup, the pods which have got pod ip or finished are
+// skipped because their nics have been configured by daemon on the current node with the ips there
+func (PodScheduledPredicate) Create(e event.CreateEvent) bool {
+	pod, ok := e.Object.(*corev1.Pod)
+	return ok && pod.DeletionTimestamp.IsZero() && !pod.Spec.HostNetwork && len(pod.Spec.NodeName) > 0 &&
+		len(pod.Status.PodIP) == 0 && pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed
+}
+
+func (PodScheduledPredicate) Update(e event.UpdateEvent) bool {
+	oldPod, ok := e.ObjectOld.(*corev1.Pod)
+	if !ok {
+		return false
+	}
+	newPod, ok := e.ObjectNew.(*corev1.Pod)
+	if !ok {
+		return false
+	}
+	// node of pod might be reassigned after allocation besides the first scheduling
+	return newPod.DeletionTimestamp.IsZero() && !newPod.Spec.HostNetwork &&
+		len(newPod.Spec.NodeName) > 0 && oldPod.Spec.NodeName != newPod.Spec.NodeName
+}
+
+func (PodScheduledPredicate) Delete(e event.DeleteEvent) bool {
+	return false
+}
+
+func (PodScheduledPredicate) Generic(e event.GenericEvent) bool {
+	return false
+}
+
 type NetworkSpecChangePredicate struct {
 	predicate.Funcs
 }
@@ -117,7 +154,14 @@ func (SubnetSpecChangePredicate) Update(e event.UpdateEvent) bool {
 	// change indicators
 	// 1. address range
 	// 2. private
-	return !reflect.DeepEqual(oldSubnet.Spec.Range, newSubnet.Spec.Range) || networkingv1.IsPrivateSubnet(oldSubnet) != networkingv1.IsPrivateSubnet(newSubnet)
+	// 3. address selector
+	// 4. deletion, which stops allocation from subnet
+	// 5. conflicted ips
+	return !reflect.DeepEqual(oldSubnet.Spec.Range, newSubnet.Spec.Range) ||
+		!reflect.DeepEqual(oldSubnet.Spec.ConflictedIPs, newSubnet.Spec.ConflictedIPs) ||
+		networkingv1.IsPrivateSubnet(oldSubnet) != networkingv1.IsPrivateSubnet(newSubnet) ||
+		networkingv1.GetSubnetAddressSelector(oldSubnet) != networkingv1.GetSubnetAddressSelector(newSubnet) ||
+		oldSubnet.DeletionTimestamp.IsZero() != newSubnet.DeletionTimestamp.IsZero()
 }
 
 type NetworkOfNodeChangePredicate struct {
@@ -202,6 +246,29 @@ func (IPInstancePhaseChangePredicate) Update(e event.UpdateEvent) bool {
 	return oldIPInstance.Status.Phase != newIPInstance.Status.Phase
 }
 
+type IPReservationActiveChangePredicate struct {
+	predicate.Funcs
+}
+
+// Update implements default UpdateEvent filter for checking whether IPReservation gets active or inactive,
+// or the spec of an active IPReservation is changed, whose previous range has to be released
+func (IPReservationActiveChangePredicate) Update(e event.UpdateEvent) bool {
+	oldReservation, ok := e.ObjectOld.(*networkingv1.IPReservation)
+	if !ok {
+		return false
+	}
+	newReservation, ok := e.ObjectNew.(*networkingv1.IPReservation)
+	if !ok {
+		return false
+	}
+
+	if networkingv1.IsIPReservationActive(oldReservation) != networkingv1.IsIPReservationActive(newReservation) {
+		return true
+	}
+	return oldReservation.Status.Phase == networkingv1.IPReservationPhaseActive &&
+		oldReservation.Generation != newReservation.Generation
+}
+
 type RemoteClusterUUIDChangePredicate struct {
 	predicate.Funcs
 }
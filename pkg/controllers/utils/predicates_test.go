@@ -0,0 +1,174 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+)
+
+func TestPodScheduledPredicateUpdate(t *testing.T) {
+	allocatedPod := func(nodeName string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "pod",
+				Namespace:   "default",
+				Annotations: map[string]string{constants.AnnotationIP: `{"ip":"192.168.0.10/24"}`},
+			},
+			Spec: v1.PodSpec{NodeName: nodeName},
+		}
+	}
+	deletingPod := allocatedPod("node2")
+	now := metav1.Now()
+	deletingPod.DeletionTimestamp = &now
+	hostNetworkPod := allocatedPod("node2")
+	hostNetworkPod.Spec.HostNetwork = true
+
+	tests := []struct {
+		name     string
+		oldPod   *v1.Pod
+		newPod   *v1.Pod
+		expected bool
+	}{
+		{"scheduled", allocatedPod(""), allocatedPod("node1"), true},
+		{"node reassigned", allocatedPod("node1"), allocatedPod("node2"), true},
+		{"node unchanged", allocatedPod("node1"), allocatedPod("node1"), false},
+		{"node cleared", allocatedPod("node1"), allocatedPod(""), false},
+		{"deleting pod reassigned", allocatedPod("node1"), deletingPod, false},
+		{"host network pod reassigned", allocatedPod("node1"), hostNetworkPod, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			passed := PodScheduledPredicate{}.Update(event.UpdateEvent{ObjectOld: test.oldPod, ObjectNew: test.newPod})
+			if passed != test.expected {
+				t.Errorf("expect %v, but got %v", test.expected, passed)
+			}
+		})
+	}
+}
+
+func TestPodScheduledPredicateCreate(t *testing.T) {
+	scheduledPod := func(nodeName string, phase v1.PodPhase, podIP string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "pod",
+				Namespace:   "default",
+				Annotations: map[string]string{constants.AnnotationIP: `{"ip":"192.168.0.10/24"}`},
+			},
+			Spec:   v1.PodSpec{NodeName: nodeName},
+			Status: v1.PodStatus{Phase: phase, PodIP: podIP},
+		}
+	}
+	deletingPod := scheduledPod("node1", v1.PodPending, "")
+	now := metav1.Now()
+	deletingPod.DeletionTimestamp = &now
+	hostNetworkPod := scheduledPod("node1", v1.PodPending, "")
+	hostNetworkPod.Spec.HostNetwork = true
+
+	tests := []struct {
+		name     string
+		pod      *v1.Pod
+		expected bool
+	}{
+		{"pending without pod ip", scheduledPod("node1", v1.PodPending, ""), true},
+		{"unscheduled", scheduledPod("", v1.PodPending, ""), false},
+		{"pending with pod ip", scheduledPod("node1", v1.PodPending, "192.168.0.10"), false},
+		{"running", scheduledPod("node1", v1.PodRunning, "192.168.0.10"), false},
+		{"succeeded", scheduledPod("node1", v1.PodSucceeded, ""), false},
+		{"failed", scheduledPod("node1", v1.PodFailed, ""), false},
+		{"deleting", deletingPod, false},
+		{"host network", hostNetworkPod, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			passed := PodScheduledPredicate{}.Create(event.CreateEvent{Object: test.pod})
+			if passed != test.expected {
+				t.Errorf("expect %v, but got %v", test.expected, passed)
+			}
+		})
+	}
+}
+
+func TestIPReservationActiveChangePredicateUpdate(t *testing.T) {
+	reservation := func(generation, observedGeneration int64, phase networkingv1.IPReservationPhase) *networkingv1.IPReservation {
+		return &networkingv1.IPReservation{
+			ObjectMeta: metav1.ObjectMeta{Name: "reservation", Generation: generation},
+			Status:     networkingv1.IPReservationStatus{Phase: phase, ObservedGeneration: observedGeneration},
+		}
+	}
+
+	tests := []struct {
+		name           string
+		oldReservation *networkingv1.IPReservation
+		newReservation *networkingv1.IPReservation
+		expected       bool
+	}{
+		{
+			"activated",
+			reservation(1, 0, ""),
+			reservation(1, 1, networkingv1.IPReservationPhaseActive),
+			true,
+		},
+		{
+			"range of active reservation changed",
+			reservation(1, 1, networkingv1.IPReservationPhaseActive),
+			reservation(2, 1, networkingv1.IPReservationPhaseActive),
+			true,
+		},
+		{
+			"changed range activated",
+			reservation(2, 1, networkingv1.IPReservationPhaseActive),
+			reservation(2, 2, networkingv1.IPReservationPhaseActive),
+			true,
+		},
+		{
+			"range changed again before reserved",
+			reservation(2, 1, networkingv1.IPReservationPhaseActive),
+			reservation(3, 1, networkingv1.IPReservationPhaseActive),
+			true,
+		},
+		{
+			"range of rejected reservation changed",
+			reservation(1, 1, networkingv1.IPReservationPhaseRejected),
+			reservation(2, 1, networkingv1.IPReservationPhaseRejected),
+			false,
+		},
+		{
+			"nothing changed",
+			reservation(1, 1, networkingv1.IPReservationPhaseActive),
+			reservation(1, 1, networkingv1.IPReservationPhaseActive),
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			passed := IPReservationActiveChangePredicate{}.Update(event.UpdateEvent{ObjectOld: test.oldReservation, ObjectNew: test.newReservation})
+			if passed != test.expected {
+				t.Errorf("expect %v, but got %v", test.expected, passed)
+			}
+		})
+	}
+}
@@ -22,6 +22,8 @@ import (
 	"time"
 
 	"github.com/mdlayher/ethernet"
+
+	"github.com/alibaba/hybridnet/pkg/daemon/utils"
 )
 
 // CheckWithTimeout checks vlan network environment and duplicate ip problems,
@@ -37,9 +39,7 @@ func CheckWithTimeout(ifi *net.Interface, srcPod, gateway net.IP, timeout time.D
 	// Resolve src pod ip for duplicate ip check and send gratuitous arp.
 	// Src ip should be 0.0.0.0 for arp probe.
 	if duplicatedHw, err := pingOverInterface(net.ParseIP("0.0.0.0"), srcPod, ifi, timeout); err == nil {
-		return fmt.Errorf("pod ip %v duplicated"+
-			", please check if ip %v is occupied by other machines or containers, another hw addr is %v",
-			srcPod.String(), srcPod.String(), duplicatedHw.String())
+		return &utils.DuplicateIPError{IP: srcPod, HardwareAddr: duplicatedHw}
 	}
 
 	// Send gratuitous arp to ensure remote neigh cache flushed.
@@ -20,9 +20,12 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sort"
 	"sync"
 
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
 	daemonutils "github.com/alibaba/hybridnet/pkg/daemon/utils"
+	"github.com/alibaba/hybridnet/pkg/metrics"
 
 	"github.com/vishvananda/netlink"
 
@@ -32,8 +35,6 @@ import (
 	"github.com/osrg/gobgp/v3/pkg/server"
 )
 
-// Multiple bgp peers is not supported right now.
-
 type Manager struct {
 	localASN             uint32
 	peeringInterfaceName string
@@ -52,7 +53,20 @@ type Manager struct {
 	subnetMap map[string]*net.IPNet
 	ipMap     map[string]net.IP
 
+	// peer policies applied to bgp server, every peer has independent policies
+	appliedPeerPolicies map[string]peerPolicy
+
 	startMutex *sync.RWMutex
+	peerMutex  *sync.RWMutex
+}
+
+// PeerStatus is the state of a recorded bgp peer
+type PeerStatus struct {
+	Address      string
+	ASN          int
+	State        string
+	ExportPolicy networkingv1.BGPExportPolicy
+	ImportPolicy networkingv1.BGPImportPolicy
 }
 
 func NewManager(peeringInterfaceName, grpcListenAddress string, logger logr.Logger) (*Manager, error) {
@@ -71,6 +85,7 @@ func NewManager(peeringInterfaceName, grpcListenAddress string, logger logr.Logg
 		ipMap:     map[string]net.IP{},
 
 		startMutex: &sync.RWMutex{},
+		peerMutex:  &sync.RWMutex{},
 	}
 
 	peeringLink, err := netlink.LinkByName(peeringInterfaceName)
@@ -148,16 +163,28 @@ func NewManager(peeringInterfaceName, grpcListenAddress string, logger logr.Logg
 	return manager, nil
 }
 
-func (m *Manager) RecordPeer(address, password string, asn int, gracefulRestartTime int32) {
+func (m *Manager) RecordPeer(address, password string, asn int, gracefulRestartTime int32,
+	exportPolicy networkingv1.BGPExportPolicy, importPolicy networkingv1.BGPImportPolicy) {
 	if gracefulRestartTime == 0 {
 		gracefulRestartTime = 300
 	}
+	if len(exportPolicy) == 0 {
+		exportPolicy = networkingv1.BGPExportPolicyAll
+	}
+	if len(importPolicy) == 0 {
+		importPolicy = networkingv1.BGPImportPolicyAccept
+	}
+
+	m.peerMutex.Lock()
+	defer m.peerMutex.Unlock()
 
 	m.peerMap[address] = &peerInfo{
 		address:                address,
 		asn:                    asn,
 		gracefulRestartSeconds: uint32(gracefulRestartTime),
 		password:               password,
+		exportPolicy:           exportPolicy,
+		importPolicy:           importPolicy,
 	}
 }
 
@@ -174,6 +201,9 @@ func (m *Manager) ResetSubnetInfos() {
 }
 
 func (m *Manager) ResetPeerInfos() {
+	m.peerMutex.Lock()
+	defer m.peerMutex.Unlock()
+
 	m.peerMap = map[string]*peerInfo{}
 }
 
@@ -234,6 +264,21 @@ func (m *Manager) SyncPeerInfos() error {
 		return nil
 	}
 
+	m.peerMutex.RLock()
+	defer m.peerMutex.RUnlock()
+
+	// Policies should be ready before new peers are added, or else routes may be leaked.
+	peerPolicies := map[string]peerPolicy{}
+	for address, peer := range m.peerMap {
+		peerPolicies[address] = peerPolicy{
+			exportPolicy: peer.exportPolicy,
+			importPolicy: peer.importPolicy,
+		}
+	}
+	if err := m.syncPeerPolicies(peerPolicies, existPeerMap); err != nil {
+		return fmt.Errorf("failed to sync bgp peer policies: %v", err)
+	}
+
 	for _, peer := range m.peerMap {
 		if _, exist := existPeerMap[peer.address]; !exist {
 			if err := m.bgpServer.AddPeer(context.Background(), &api.AddPeerRequest{
@@ -364,6 +409,75 @@ func (m *Manager) SyncIPInfos() error {
 	return nil
 }
 
+// ReAdvertise re-announces all the local paths to every bgp peer by soft resetting
+// the outbound direction, returns the count of advertised paths
+func (m *Manager) ReAdvertise() (int, error) {
+	if !m.CheckIfStart() {
+		return 0, fmt.Errorf("bgp manager is not started")
+	}
+
+	existSubnetPathMap := map[string]*net.IPNet{}
+	existIPPathMap := map[string]net.IP{}
+	if err := m.listExistPath(existSubnetPathMap, existIPPathMap); err != nil {
+		return 0, fmt.Errorf("failed to list exist paths: %v", err)
+	}
+
+	existPeerMap := map[string]struct{}{}
+	if err := m.listRemoteBGPPeers(existPeerMap, func(peer *api.Peer) bool {
+		return true
+	}); err != nil {
+		return 0, fmt.Errorf("failed to list all bgp peers: %v", err)
+	}
+
+	for peerAddress := range existPeerMap {
+		if err := m.bgpServer.ResetPeer(context.Background(), &api.ResetPeerRequest{
+			Address:   peerAddress,
+			Soft:      true,
+			Direction: api.ResetPeerRequest_OUT,
+		}); err != nil {
+			return 0, fmt.Errorf("failed to re-advertise paths to bgp peer %v: %v", peerAddress, err)
+		}
+		metrics.BGPPeerLastAdvertisementTimestamp.WithLabelValues(peerAddress).SetToCurrentTime()
+	}
+
+	return len(existSubnetPathMap) + len(existIPPathMap), nil
+}
+
+// ListPeerStatuses returns the states of all the recorded bgp peers, sorted by address
+func (m *Manager) ListPeerStatuses() ([]PeerStatus, error) {
+	sessionStates := map[string]string{}
+	if err := m.bgpServer.ListPeer(context.Background(), &api.ListPeerRequest{},
+		func(peer *api.Peer) {
+			sessionStates[peer.GetConf().GetNeighborAddress()] = peer.GetState().GetSessionState().String()
+		}); err != nil {
+		return nil, fmt.Errorf("failed to list bgp peers: %v", err)
+	}
+
+	m.peerMutex.RLock()
+	defer m.peerMutex.RUnlock()
+
+	var peerStatuses []PeerStatus
+	for address, peer := range m.peerMap {
+		state, exist := sessionStates[address]
+		if !exist {
+			state = "NOT_CONFIGURED"
+		}
+
+		peerStatuses = append(peerStatuses, PeerStatus{
+			Address:      address,
+			ASN:          peer.asn,
+			State:        state,
+			ExportPolicy: peer.exportPolicy,
+			ImportPolicy: peer.importPolicy,
+		})
+	}
+
+	sort.Slice(peerStatuses, func(i, j int) bool {
+		return peerStatuses[i].Address < peerStatuses[j].Address
+	})
+	return peerStatuses, nil
+}
+
 func (m *Manager) CheckIfIPInfoPathAdded(ipAddr net.IP) (bool, error) {
 	existIPPathMap := map[string]net.IP{}
 	if err := m.listExistPath(nil, existIPPathMap); err != nil {
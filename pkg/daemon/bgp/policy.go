@@ -0,0 +1,297 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package bgp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+
+	api "github.com/osrg/gobgp/v3/api"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+)
+
+// Export and import policies of bgp peers are implemented by global policies of gobgp,
+// every statement matches the neighbor set of a single peer, so that routes are filtered
+// for each peer independently.
+
+const (
+	policyAssignmentName = "global"
+
+	hostRoutesV4PrefixSetName   = "hybridnet-host-routes-v4"
+	hostRoutesV6PrefixSetName   = "hybridnet-host-routes-v6"
+	subnetRoutesV4PrefixSetName = "hybridnet-subnet-routes-v4"
+	subnetRoutesV6PrefixSetName = "hybridnet-subnet-routes-v6"
+
+	neighborSetNamePrefix  = "hybridnet-peer-"
+	exportPolicyNamePrefix = "hybridnet-export-"
+	importPolicyNamePrefix = "hybridnet-import-"
+)
+
+type peerPolicy struct {
+	exportPolicy networkingv1.BGPExportPolicy
+	importPolicy networkingv1.BGPImportPolicy
+}
+
+// prefixSets are the sets to distinguish pod ip routes from subnet routes
+var prefixSets = []*api.DefinedSet{
+	{
+		DefinedType: api.DefinedType_PREFIX,
+		Name:        hostRoutesV4PrefixSetName,
+		Prefixes:    []*api.Prefix{{IpPrefix: "0.0.0.0/0", MaskLengthMin: 32, MaskLengthMax: 32}},
+	},
+	{
+		DefinedType: api.DefinedType_PREFIX,
+		Name:        hostRoutesV6PrefixSetName,
+		Prefixes:    []*api.Prefix{{IpPrefix: "::/0", MaskLengthMin: 128, MaskLengthMax: 128}},
+	},
+	{
+		DefinedType: api.DefinedType_PREFIX,
+		Name:        subnetRoutesV4PrefixSetName,
+		Prefixes:    []*api.Prefix{{IpPrefix: "0.0.0.0/0", MaskLengthMin: 0, MaskLengthMax: 31}},
+	},
+	{
+		DefinedType: api.DefinedType_PREFIX,
+		Name:        subnetRoutesV6PrefixSetName,
+		Prefixes:    []*api.Prefix{{IpPrefix: "::/0", MaskLengthMin: 0, MaskLengthMax: 127}},
+	},
+}
+
+// rejectStatement generates a statement rejecting routes from/to the neighbor set,
+// routes will be also matched by the prefix set if it is not empty
+func rejectStatement(name, neighborSetName, prefixSetName string) *api.Statement {
+	statement := &api.Statement{
+		Name: name,
+		Conditions: &api.Conditions{
+			NeighborSet: &api.MatchSet{
+				Type: api.MatchSet_ANY,
+				Name: neighborSetName,
+			},
+		},
+		Actions: &api.Actions{
+			RouteAction: api.RouteAction_REJECT,
+		},
+	}
+
+	if len(prefixSetName) > 0 {
+		statement.Conditions.PrefixSet = &api.MatchSet{
+			Type: api.MatchSet_ANY,
+			Name: prefixSetName,
+		}
+	}
+	return statement
+}
+
+// generatePeerPolicies generates the neighbor sets and policies for peers, peers with the
+// default policies need nothing
+func generatePeerPolicies(peerPolicies map[string]peerPolicy) (neighborSets []*api.DefinedSet,
+	exportPolicies, importPolicies []*api.Policy) {
+	var addresses []string
+	for address := range peerPolicies {
+		addresses = append(addresses, address)
+	}
+	// keep the order of policies stable
+	sort.Strings(addresses)
+
+	for _, address := range addresses {
+		policy := peerPolicies[address]
+		neighborSetName := neighborSetNamePrefix + address
+		exportPolicyName := exportPolicyNamePrefix + address
+		importPolicyName := importPolicyNamePrefix + address
+
+		var exportStatements []*api.Statement
+		switch policy.exportPolicy {
+		case networkingv1.BGPExportPolicySubnets:
+			exportStatements = []*api.Statement{
+				rejectStatement(exportPolicyName+"-v4", neighborSetName, hostRoutesV4PrefixSetName),
+				rejectStatement(exportPolicyName+"-v6", neighborSetName, hostRoutesV6PrefixSetName),
+			}
+		case networkingv1.BGPExportPolicyIPs:
+			exportStatements = []*api.Statement{
+				rejectStatement(exportPolicyName+"-v4", neighborSetName, subnetRoutesV4PrefixSetName),
+				rejectStatement(exportPolicyName+"-v6", neighborSetName, subnetRoutesV6PrefixSetName),
+			}
+		case networkingv1.BGPExportPolicyNone:
+			exportStatements = []*api.Statement{
+				rejectStatement(exportPolicyName, neighborSetName, ""),
+			}
+		}
+
+		var importStatements []*api.Statement
+		if policy.importPolicy == networkingv1.BGPImportPolicyReject {
+			importStatements = []*api.Statement{
+				rejectStatement(importPolicyName, neighborSetName, ""),
+			}
+		}
+
+		if len(exportStatements) == 0 && len(importStatements) == 0 {
+			continue
+		}
+
+		neighborSets = append(neighborSets, &api.DefinedSet{
+			DefinedType: api.DefinedType_NEIGHBOR,
+			Name:        neighborSetName,
+			List:        []string{toHostCIDR(address)},
+		})
+
+		if len(exportStatements) > 0 {
+			exportPolicies = append(exportPolicies, &api.Policy{
+				Name:       exportPolicyName,
+				Statements: exportStatements,
+			})
+		}
+
+		if len(importStatements) > 0 {
+			importPolicies = append(importPolicies, &api.Policy{
+				Name:       importPolicyName,
+				Statements: importStatements,
+			})
+		}
+	}
+
+	return
+}
+
+// syncPeerPolicies rebuilds all the peer policies if any of them changes, and resets
+// existing peers softly to make routes filtered by the new policies
+func (m *Manager) syncPeerPolicies(peerPolicies map[string]peerPolicy, existPeerMap map[string]struct{}) error {
+	if reflect.DeepEqual(m.appliedPeerPolicies, peerPolicies) {
+		return nil
+	}
+
+	ctx := context.Background()
+
+	// policies can not be deleted while assigned, so clean assignments first
+	for _, direction := range []api.PolicyDirection{api.PolicyDirection_EXPORT, api.PolicyDirection_IMPORT} {
+		if err := m.bgpServer.SetPolicyAssignment(ctx, &api.SetPolicyAssignmentRequest{
+			Assignment: &api.PolicyAssignment{
+				Name:          policyAssignmentName,
+				Direction:     direction,
+				DefaultAction: api.RouteAction_ACCEPT,
+			},
+		}); err != nil {
+			return fmt.Errorf("failed to clean %v policy assignment: %v", direction, err)
+		}
+	}
+
+	// policies and neighbor sets are listed rather than calculated from the applied ones,
+	// in case that last sync failed halfway
+	var existPolicyNames, existNeighborSetNames []string
+	if err := m.bgpServer.ListPolicy(ctx, &api.ListPolicyRequest{}, func(policy *api.Policy) {
+		if strings.HasPrefix(policy.Name, exportPolicyNamePrefix) || strings.HasPrefix(policy.Name, importPolicyNamePrefix) {
+			existPolicyNames = append(existPolicyNames, policy.Name)
+		}
+	}); err != nil {
+		return fmt.Errorf("failed to list bgp policies: %v", err)
+	}
+
+	if err := m.bgpServer.ListDefinedSet(ctx, &api.ListDefinedSetRequest{DefinedType: api.DefinedType_NEIGHBOR},
+		func(definedSet *api.DefinedSet) {
+			if strings.HasPrefix(definedSet.Name, neighborSetNamePrefix) {
+				existNeighborSetNames = append(existNeighborSetNames, definedSet.Name)
+			}
+		}); err != nil {
+		return fmt.Errorf("failed to list bgp neighbor sets: %v", err)
+	}
+
+	for _, name := range existPolicyNames {
+		if err := m.bgpServer.DeletePolicy(ctx, &api.DeletePolicyRequest{
+			Policy: &api.Policy{Name: name},
+			All:    true,
+		}); err != nil {
+			return fmt.Errorf("failed to delete bgp policy %v: %v", name, err)
+		}
+	}
+
+	for _, name := range existNeighborSetNames {
+		if err := m.bgpServer.DeleteDefinedSet(ctx, &api.DeleteDefinedSetRequest{
+			DefinedSet: &api.DefinedSet{DefinedType: api.DefinedType_NEIGHBOR, Name: name},
+			All:        true,
+		}); err != nil {
+			return fmt.Errorf("failed to delete bgp neighbor set %v: %v", name, err)
+		}
+	}
+
+	neighborSets, exportPolicies, importPolicies := generatePeerPolicies(peerPolicies)
+
+	// adding an existing prefix set only merges the same prefixes
+	for _, prefixSet := range prefixSets {
+		if err := m.bgpServer.AddDefinedSet(ctx, &api.AddDefinedSetRequest{DefinedSet: prefixSet}); err != nil {
+			return fmt.Errorf("failed to add bgp prefix set %v: %v", prefixSet.Name, err)
+		}
+	}
+
+	for _, neighborSet := range neighborSets {
+		if err := m.bgpServer.AddDefinedSet(ctx, &api.AddDefinedSetRequest{DefinedSet: neighborSet}); err != nil {
+			return fmt.Errorf("failed to add bgp neighbor set %v: %v", neighborSet.Name, err)
+		}
+	}
+
+	for direction, policies := range map[api.PolicyDirection][]*api.Policy{
+		api.PolicyDirection_EXPORT: exportPolicies,
+		api.PolicyDirection_IMPORT: importPolicies,
+	} {
+		if len(policies) == 0 {
+			continue
+		}
+
+		for _, policy := range policies {
+			if err := m.bgpServer.AddPolicy(ctx, &api.AddPolicyRequest{Policy: policy}); err != nil {
+				return fmt.Errorf("failed to add bgp policy %v: %v", policy.Name, err)
+			}
+		}
+
+		if err := m.bgpServer.SetPolicyAssignment(ctx, &api.SetPolicyAssignmentRequest{
+			Assignment: &api.PolicyAssignment{
+				Name:          policyAssignmentName,
+				Direction:     direction,
+				Policies:      policies,
+				DefaultAction: api.RouteAction_ACCEPT,
+			},
+		}); err != nil {
+			return fmt.Errorf("failed to assign %v policies: %v", direction, err)
+		}
+	}
+
+	// re-evaluate routes of existing peers with new policies
+	for address := range existPeerMap {
+		if _, exist := peerPolicies[address]; !exist {
+			continue
+		}
+		if err := m.bgpServer.ResetPeer(ctx, &api.ResetPeerRequest{
+			Address:   address,
+			Soft:      true,
+			Direction: api.ResetPeerRequest_BOTH,
+		}); err != nil {
+			return fmt.Errorf("failed to reset bgp peer %v softly: %v", address, err)
+		}
+	}
+
+	m.appliedPeerPolicies = peerPolicies
+	return nil
+}
+
+func toHostCIDR(address string) string {
+	if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+		return address + "/128"
+	}
+	return address + "/32"
+}
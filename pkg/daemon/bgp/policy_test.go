@@ -0,0 +1,163 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package bgp
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	api "github.com/osrg/gobgp/v3/api"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+)
+
+// describeNeighborSets describes neighbor sets as "name=members"
+func describeNeighborSets(neighborSets []*api.DefinedSet) (ret []string) {
+	for _, neighborSet := range neighborSets {
+		ret = append(ret, fmt.Sprintf("%s=%s", neighborSet.Name, strings.Join(neighborSet.List, ",")))
+	}
+	return
+}
+
+// describePolicies describes policies as "name:statement(neighbor set,prefix set,action);..."
+func describePolicies(policies []*api.Policy) (ret []string) {
+	for _, policy := range policies {
+		var statements []string
+		for _, statement := range policy.Statements {
+			var prefixSetName string
+			if statement.Conditions.PrefixSet != nil {
+				prefixSetName = statement.Conditions.PrefixSet.Name
+			}
+			statements = append(statements, fmt.Sprintf("%s(%s,%s,%s)", statement.Name,
+				statement.Conditions.NeighborSet.Name, prefixSetName, statement.Actions.RouteAction))
+		}
+		ret = append(ret, policy.Name+":"+strings.Join(statements, ";"))
+	}
+	return
+}
+
+func TestGeneratePeerPolicies(t *testing.T) {
+	tests := []struct {
+		name                   string
+		peerPolicies           map[string]peerPolicy
+		expectedNeighborSets   []string
+		expectedExportPolicies []string
+		expectedImportPolicies []string
+	}{
+		{
+			"no peer",
+			nil,
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"default policies",
+			map[string]peerPolicy{
+				"10.0.0.1": {},
+				"10.0.0.2": {exportPolicy: networkingv1.BGPExportPolicyAll, importPolicy: networkingv1.BGPImportPolicyAccept},
+			},
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"export subnets",
+			map[string]peerPolicy{
+				"10.0.0.1": {exportPolicy: networkingv1.BGPExportPolicySubnets},
+			},
+			[]string{"hybridnet-peer-10.0.0.1=10.0.0.1/32"},
+			[]string{"hybridnet-export-10.0.0.1:" +
+				"hybridnet-export-10.0.0.1-v4(hybridnet-peer-10.0.0.1,hybridnet-host-routes-v4,REJECT);" +
+				"hybridnet-export-10.0.0.1-v6(hybridnet-peer-10.0.0.1,hybridnet-host-routes-v6,REJECT)"},
+			nil,
+		},
+		{
+			"export ips",
+			map[string]peerPolicy{
+				"10.0.0.1": {exportPolicy: networkingv1.BGPExportPolicyIPs},
+			},
+			[]string{"hybridnet-peer-10.0.0.1=10.0.0.1/32"},
+			[]string{"hybridnet-export-10.0.0.1:" +
+				"hybridnet-export-10.0.0.1-v4(hybridnet-peer-10.0.0.1,hybridnet-subnet-routes-v4,REJECT);" +
+				"hybridnet-export-10.0.0.1-v6(hybridnet-peer-10.0.0.1,hybridnet-subnet-routes-v6,REJECT)"},
+			nil,
+		},
+		{
+			"import rejected only",
+			map[string]peerPolicy{
+				"10.0.0.1": {exportPolicy: networkingv1.BGPExportPolicyAll, importPolicy: networkingv1.BGPImportPolicyReject},
+			},
+			[]string{"hybridnet-peer-10.0.0.1=10.0.0.1/32"},
+			nil,
+			[]string{"hybridnet-import-10.0.0.1:hybridnet-import-10.0.0.1(hybridnet-peer-10.0.0.1,,REJECT)"},
+		},
+		{
+			"ipv6 peer exports and imports nothing",
+			map[string]peerPolicy{
+				"fd00::1": {exportPolicy: networkingv1.BGPExportPolicyNone, importPolicy: networkingv1.BGPImportPolicyReject},
+			},
+			[]string{"hybridnet-peer-fd00::1=fd00::1/128"},
+			[]string{"hybridnet-export-fd00::1:hybridnet-export-fd00::1(hybridnet-peer-fd00::1,,REJECT)"},
+			[]string{"hybridnet-import-fd00::1:hybridnet-import-fd00::1(hybridnet-peer-fd00::1,,REJECT)"},
+		},
+		{
+			"multiple peers in stable order",
+			map[string]peerPolicy{
+				"10.0.0.3": {exportPolicy: networkingv1.BGPExportPolicyNone},
+				"10.0.0.2": {},
+				"10.0.0.1": {importPolicy: networkingv1.BGPImportPolicyReject},
+			},
+			[]string{"hybridnet-peer-10.0.0.1=10.0.0.1/32", "hybridnet-peer-10.0.0.3=10.0.0.3/32"},
+			[]string{"hybridnet-export-10.0.0.3:hybridnet-export-10.0.0.3(hybridnet-peer-10.0.0.3,,REJECT)"},
+			[]string{"hybridnet-import-10.0.0.1:hybridnet-import-10.0.0.1(hybridnet-peer-10.0.0.1,,REJECT)"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			neighborSets, exportPolicies, importPolicies := generatePeerPolicies(test.peerPolicies)
+			if got := describeNeighborSets(neighborSets); !reflect.DeepEqual(got, test.expectedNeighborSets) {
+				t.Errorf("test %s fails: expected neighbor sets %v but got %v", test.name, test.expectedNeighborSets, got)
+			}
+			if got := describePolicies(exportPolicies); !reflect.DeepEqual(got, test.expectedExportPolicies) {
+				t.Errorf("test %s fails: expected export policies %v but got %v", test.name, test.expectedExportPolicies, got)
+			}
+			if got := describePolicies(importPolicies); !reflect.DeepEqual(got, test.expectedImportPolicies) {
+				t.Errorf("test %s fails: expected import policies %v but got %v", test.name, test.expectedImportPolicies, got)
+			}
+		})
+	}
+}
+
+func TestToHostCIDR(t *testing.T) {
+	tests := []struct {
+		address  string
+		expected string
+	}{
+		{"10.0.0.1", "10.0.0.1/32"},
+		{"fd00::1", "fd00::1/128"},
+	}
+
+	for _, test := range tests {
+		if got := toHostCIDR(test.address); got != test.expected {
+			t.Errorf("expected %s for %s but got %s", test.expected, test.address, got)
+		}
+	}
+}
@@ -26,6 +26,8 @@ import (
 	apb "google.golang.org/protobuf/types/known/anypb"
 
 	api "github.com/osrg/gobgp/v3/api"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
 )
 
 var (
@@ -55,6 +57,8 @@ type peerInfo struct {
 	asn                    int
 	gracefulRestartSeconds uint32
 	password               string
+	exportPolicy           networkingv1.BGPExportPolicy
+	importPolicy           networkingv1.BGPImportPolicy
 }
 
 func generatePeerConfig(p *peerInfo) *api.Peer {
@@ -21,10 +21,13 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/alibaba/hybridnet/pkg/constants"
 	daemonutils "github.com/alibaba/hybridnet/pkg/daemon/utils"
+	"github.com/alibaba/hybridnet/pkg/feature"
 	"github.com/alibaba/hybridnet/pkg/utils"
 
 	"github.com/sirupsen/logrus"
@@ -39,7 +42,10 @@ const (
 
 	DefaultVxlanUDPPort = 8472
 
+	DefaultBindSocketMode = "0600"
+
 	DefaultVlanCheckTimeout                     = 3 * time.Second
+	DefaultPodGetRetryInterval                  = 100 * time.Millisecond
 	DefaultIPtablesCheckDuration                = 5 * time.Second
 	DefaultVxlanBaseReachableTime               = 5 * time.Second
 	DefaultVxlanExpiredNeighCachesClearInterval = 1 * time.Hour
@@ -48,6 +54,8 @@ const (
 	DefaultNeighGCThresh2 = 2048
 	DefaultNeighGCThresh3 = 4096
 
+	DefaultPodGetRetries = 3
+
 	DefaultLocalDirectTableNum     = 39999
 	DefaultToOverlaySubnetTableNum = 40000
 	DefaultOverlayMarkTableNum     = 40001
@@ -55,8 +63,9 @@ const (
 
 // Configuration is the daemon conf
 type Configuration struct {
-	BindSocket string
-	NodeName   string
+	BindSocket     string
+	BindSocketMode os.FileMode
+	NodeName       string
 
 	VlanMTU  int
 	VxlanMTU int
@@ -93,6 +102,50 @@ type Configuration struct {
 	NeighGCThresh1 int
 	NeighGCThresh2 int
 	NeighGCThresh3 int
+
+	// Annotate pod with the name of its host veth
+	AnnotateHostInterface bool
+
+	// Annotate pod with its ips for Calico interop, used if network does not specify it
+	PatchCalicoPodIPsAnnotation bool
+
+	// Retry the initial pod fetch of cni requests if pod is not found yet
+	PodGetRetries       int
+	PodGetRetryInterval time.Duration
+
+	// Return ipv6 address before ipv4 address for dual-stack pods
+	PreferIPv6Address bool
+
+	// Shape pod traffic by bandwidth annotations
+	EnableBandwidthShaping bool
+
+	// Name of the default interface of pods, used if network does not specify one
+	DefaultInterfaceName string
+
+	// Limit the concurrency of cni handlers, requests exceeding the queue are rejected
+	// as retryable, zero means no limit
+	MaxConcurrentHandlers int
+	HandlerQueueSize      int
+
+	// Request allocation from manager running in lazy allocation mode when cni add requests
+	// arrive, empty means pods are allocated by manager on scheduling
+	AllocationRequestURL string
+	// Authenticate to the allocation request endpoint of manager with the client certificate,
+	// and verify manager by the ca, system roots are used if the ca is empty
+	AllocationRequestTLSCertFile string
+	AllocationRequestTLSKeyFile  string
+	AllocationRequestTLSCAFile   string
+
+	// Serve the read-only endpoints over tcp with mutual tls for remote diagnostics, empty means
+	// all the endpoints are only served on unix socket
+	DebugServerAddress   string
+	DebugTLSCertFile     string
+	DebugTLSKeyFile      string
+	DebugTLSClientCAFile string
+
+	// Serve the endpoint dumping nic configuration in netns of pods, which exposes the network
+	// internals of pods to whoever can reach the read-only endpoints
+	EnablePodDiagnostics bool
 }
 
 // ParseFlags will parse cmd args then init kubeClient and configuration
@@ -102,7 +155,8 @@ func ParseFlags() (*Configuration, error) {
 		argPreferVlanInterfaces                 = pflag.String("prefer-vlan-interfaces", "", "The preferred vlan interfaces used to inter-host pod communication, default: the default route interface")
 		argPreferVxlanInterfaces                = pflag.String("prefer-vxlan-interfaces", "", "The preferred vxlan interfaces used to inter-host pod communication, default: the default route interface")
 		argPreferBGPInterfaces                  = pflag.String("prefer-bgp-interfaces", "", "The preferred bgp interfaces used to inter-host pod communication, default: the default route interface")
-		argBindSocket                           = pflag.String("bind-socket", "/var/run/hybridnet.sock", "The socket daemon bind to.")
+		argBindSocket                           = pflag.String("bind-socket", "/var/run/hybridnet.sock", "The socket daemon bind to, it must be on a writable and node-local filesystem.")
+		argBindSocketMode                       = pflag.String("bind-socket-mode", DefaultBindSocketMode, "The octal file mode of the socket daemon bind to.")
 		argHealthyServerAddress                 = pflag.String("health-probe-addr", DefaultHealthyServerBindAddress, "The address which daemon healthy server bind")
 		argMetricsServerAddress                 = pflag.String("metrics-addr", DefaultMetricsServerBindAddress, "The address which daemon metrics server bind")
 		argBGPgRPCServerAddress                 = pflag.String("bgp-grpc-server-addr", DefaultBGPgRPCServerBindAddress, "The address which daemon bgp grpc server bind, for using gobgp command to debug")
@@ -118,6 +172,24 @@ func ParseFlags() (*Configuration, error) {
 		argNeighGCThresh1                       = pflag.Int("neigh-gc-thresh1", DefaultNeighGCThresh1, "Value to set net.ipv4/ipv6.neigh.default.gc_thresh1")
 		argNeighGCThresh2                       = pflag.Int("neigh-gc-thresh2", DefaultNeighGCThresh2, "Value to set net.ipv4/ipv6.neigh.default.gc_thresh2")
 		argNeighGCThresh3                       = pflag.Int("neigh-gc-thresh3", DefaultNeighGCThresh3, "Value to set net.ipv4/ipv6.neigh.default.gc_thresh3")
+		argAnnotateHostInterface                = pflag.Bool("annotate-host-interface", false, "Whether to annotate pod with the name of its host veth interface")
+		argPatchCalicoPodIPsAnnotation          = pflag.Bool("patch-calico-pod-ips-annotation", false, "Whether to annotate pod with its ips for Calico interop, which can be overridden by network")
+		argPodGetRetries                        = pflag.Int("pod-get-retries", DefaultPodGetRetries, "The max retries to get pod of cni requests if pod is not found, keep it small to avoid masking missing pods")
+		argPodGetRetryInterval                  = pflag.Duration("pod-get-retry-interval", DefaultPodGetRetryInterval, "The interval between retries to get pod of cni requests")
+		argPreferIPv6Address                    = pflag.Bool("prefer-ipv6-address", false, "Whether ipv6 address will be returned as the first address of dual-stack pods, ipv4 address is the first by default")
+		argEnableBandwidthShaping               = pflag.Bool("enable-bandwidth-shaping", false, "Whether to limit the rate of pod traffic by ingress/egress bandwidth annotations with tbf qdiscs")
+		argDefaultInterfaceName                 = pflag.String("default-interface-name", constants.ContainerNicName, "The name of the default interface of pods, which can be overridden by network")
+		argMaxConcurrentHandlers                = pflag.Int("max-concurrent-handlers", 0, "The max number of cni requests handled concurrently, no limit if zero")
+		argHandlerQueueSize                     = pflag.Int("handler-queue-size", 0, "The max number of cni requests waiting for handling if max concurrent handlers is set, the others are rejected as retryable")
+		argAllocationRequestURL                 = pflag.String("allocation-request-url", "", "The url of manager endpoint to request allocation for pods when their cni add requests arrive, e.g., \"https://192.168.0.10:9903/request-allocation\", required if manager runs in lazy allocation mode")
+		argAllocationRequestTLSCertFile         = pflag.String("allocation-request-tls-cert-file", "", "The client certificate file to authenticate to the allocation request endpoint of manager served with mutual tls")
+		argAllocationRequestTLSKeyFile          = pflag.String("allocation-request-tls-key-file", "", "The private key file of the client certificate to authenticate to the allocation request endpoint of manager")
+		argAllocationRequestTLSCAFile           = pflag.String("allocation-request-tls-ca-file", "", "The ca file to verify the certificate of the allocation request endpoint of manager, system roots are used if empty")
+		argDebugServerAddress                   = pflag.String("debug-server-addr", "", "The tcp address to serve the read-only endpoints on with mutual tls for remote diagnostics, mutating endpoints are never served on it, disabled if empty")
+		argDebugTLSCertFile                     = pflag.String("debug-tls-cert-file", "", "The certificate file of debug server, required if debug server is enabled")
+		argDebugTLSKeyFile                      = pflag.String("debug-tls-key-file", "", "The private key file of debug server, required if debug server is enabled")
+		argDebugTLSClientCAFile                 = pflag.String("debug-tls-client-ca-file", "", "The ca file to verify the client certificates of debug server, required if debug server is enabled")
+		argEnablePodDiagnostics                 = pflag.Bool("enable-pod-diagnostics", false, "Whether to serve the read-only endpoint dumping interfaces, addresses, routes and neighbors in netns of pods")
 		argExtraNodeLocalVxlanIPCidrs           = pflag.String("extra-node-local-vxlan-ip-cidrs", "", "The cidr list to select node extra local vxlan ip, e.g., \"192.168.10.0/24,10.2.3.0/24\"")
 	)
 
@@ -152,12 +224,58 @@ func ParseFlags() (*Configuration, error) {
 		NeighGCThresh2:                       *argNeighGCThresh2,
 		NeighGCThresh3:                       *argNeighGCThresh3,
 		VxlanExpiredNeighCachesClearInterval: *argVxlanExpiredNeighCachesClearInterval,
+		AnnotateHostInterface:                *argAnnotateHostInterface,
+		PatchCalicoPodIPsAnnotation:          *argPatchCalicoPodIPsAnnotation,
+		PodGetRetries:                        *argPodGetRetries,
+		PodGetRetryInterval:                  *argPodGetRetryInterval,
+		PreferIPv6Address:                    *argPreferIPv6Address,
+		EnableBandwidthShaping:               *argEnableBandwidthShaping,
+		DefaultInterfaceName:                 *argDefaultInterfaceName,
+		MaxConcurrentHandlers:                *argMaxConcurrentHandlers,
+		HandlerQueueSize:                     *argHandlerQueueSize,
+		AllocationRequestURL:                 *argAllocationRequestURL,
+		AllocationRequestTLSCertFile:         *argAllocationRequestTLSCertFile,
+		AllocationRequestTLSKeyFile:          *argAllocationRequestTLSKeyFile,
+		AllocationRequestTLSCAFile:           *argAllocationRequestTLSCAFile,
+		DebugServerAddress:                   *argDebugServerAddress,
+		DebugTLSCertFile:                     *argDebugTLSCertFile,
+		DebugTLSKeyFile:                      *argDebugTLSKeyFile,
+		DebugTLSClientCAFile:                 *argDebugTLSClientCAFile,
+		EnablePodDiagnostics:                 *argEnablePodDiagnostics,
 	}
 
 	if *argPreferVlanInterfaces == "" {
 		config.NodeVlanIfName = *argPreferInterfaces
 	}
 
+	bindSocketMode, err := strconv.ParseUint(*argBindSocketMode, 8, 32)
+	if err != nil || bindSocketMode&^uint64(os.ModePerm) != 0 {
+		return nil, fmt.Errorf("invalid bind socket mode %v", *argBindSocketMode)
+	}
+	config.BindSocketMode = os.FileMode(bindSocketMode)
+
+	if err = validateBindSocket(config.BindSocket); err != nil {
+		return nil, err
+	}
+
+	if !utils.IsValidInterfaceName(config.DefaultInterfaceName) {
+		return nil, fmt.Errorf("invalid default interface name %q", config.DefaultInterfaceName)
+	}
+
+	if config.MaxConcurrentHandlers < 0 || config.HandlerQueueSize < 0 {
+		return nil, fmt.Errorf("max concurrent handlers and handler queue size must not be negative")
+	}
+
+	if (len(config.AllocationRequestTLSCertFile) == 0) != (len(config.AllocationRequestTLSKeyFile) == 0) {
+		return nil, fmt.Errorf("tls cert and key files of allocation request must be set together")
+	}
+
+	// debug server is never exposed without verifying clients
+	if len(config.DebugServerAddress) > 0 && (len(config.DebugTLSCertFile) == 0 ||
+		len(config.DebugTLSKeyFile) == 0 || len(config.DebugTLSClientCAFile) == 0) {
+		return nil, fmt.Errorf("tls cert, key and client ca files are required by debug server")
+	}
+
 	if *argExtraNodeLocalVxlanIPCidrs != "" {
 		var err error
 		config.ExtraNodeLocalVxlanIPCidrs, err = parseCidrString(*argExtraNodeLocalVxlanIPCidrs)
@@ -182,7 +300,18 @@ func ParseFlags() (*Configuration, error) {
 }
 
 func (config *Configuration) initNicConfig() error {
-	defaultGatewayIf, err := daemonutils.GetDefaultInterface(netlink.FAMILY_V4)
+	var (
+		defaultGatewayIf *net.Interface
+		err              error
+	)
+
+	// ipv4 default gateway is never required in ipv6-only cluster
+	if !feature.IPv6OnlyEnabled() {
+		defaultGatewayIf, err = daemonutils.GetDefaultInterface(netlink.FAMILY_V4)
+	} else {
+		err = daemonutils.NotExist
+	}
+
 	if err != nil && err != daemonutils.NotExist {
 		return fmt.Errorf("failed to get ipv4 default gateway interface: %v", err)
 	} else if err == daemonutils.NotExist {
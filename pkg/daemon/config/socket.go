@@ -0,0 +1,90 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// magic numbers of remote or fuse filesystems, on which the bind socket should never be
+const (
+	nfsSuperMagic   = 0x6969
+	smbSuperMagic   = 0x517b
+	cifsSuperMagic  = 0xff534d42
+	smb2SuperMagic  = 0xfe534d42
+	fuseSuperMagic  = 0x65735546
+	cephSuperMagic  = 0x00c36400
+	afsSuperMagic   = 0x5346414f
+	codaSuperMagic  = 0x73757245
+	ncpSuperMagic   = 0x564c
+	v9fsSuperMagic  = 0x01021997
+	gfs2SuperMagic  = 0x01161970
+	ocfs2SuperMagic = 0x7461636f
+)
+
+var nonLocalFilesystems = map[int64]string{
+	nfsSuperMagic:   "nfs",
+	smbSuperMagic:   "smb",
+	cifsSuperMagic:  "cifs",
+	smb2SuperMagic:  "smb2",
+	fuseSuperMagic:  "fuse",
+	cephSuperMagic:  "ceph",
+	afsSuperMagic:   "afs",
+	codaSuperMagic:  "coda",
+	ncpSuperMagic:   "ncp",
+	v9fsSuperMagic:  "9p",
+	gfs2SuperMagic:  "gfs2",
+	ocfs2SuperMagic: "ocfs2",
+}
+
+// validateBindSocket checks that bind socket can be created on a writable and node-local filesystem,
+// an existing file on the path must be a socket which will be cleaned up as a stale one
+func validateBindSocket(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("bind socket path %v must be absolute", path)
+	}
+
+	dir := filepath.Dir(path)
+	dirInfo, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to stat directory of bind socket %v: %v", path, err)
+	}
+	if !dirInfo.IsDir() {
+		return fmt.Errorf("parent of bind socket %v is not a directory", path)
+	}
+
+	if err = unix.Access(dir, unix.W_OK); err != nil {
+		return fmt.Errorf("directory of bind socket %v is not writable: %v", path, err)
+	}
+
+	var statfs unix.Statfs_t
+	if err = unix.Statfs(dir, &statfs); err != nil {
+		return fmt.Errorf("failed to statfs directory of bind socket %v: %v", path, err)
+	}
+	if fsName, nonLocal := nonLocalFilesystems[int64(statfs.Type)]; nonLocal {
+		return fmt.Errorf("bind socket %v must be on a node-local filesystem, but found %v", path, fsName)
+	}
+
+	if fileInfo, err := os.Lstat(path); err == nil && fileInfo.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("bind socket %v exists but is not a socket", path)
+	}
+	return nil
+}
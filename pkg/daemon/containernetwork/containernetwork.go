@@ -154,7 +154,7 @@ func ConfigureHostNic(nicName string, allocatedIPs map[networkingv1.IPVersion]*d
 }
 
 func ConfigureContainerNic(containerNicName, hostNicName, nodeIfName string, allocatedIPs map[networkingv1.IPVersion]*daemonutils.IPInfo,
-	macAddr net.HardwareAddr, netID *int32, netns ns.NetNS, mtu int, vlanCheckTimeout time.Duration,
+	primaryIPVersion networkingv1.IPVersion, macAddr net.HardwareAddr, netID *int32, netns ns.NetNS, mtu int, vlanCheckTimeout time.Duration,
 	networkMode networkingv1.NetworkMode, neighGCThresh1, neighGCThresh2, neighGCThresh3 int, bgpManager *bgp.Manager) error {
 
 	var defaultRouteNets []*types.Route
@@ -224,7 +224,7 @@ func ConfigureContainerNic(containerNicName, hostNicName, nodeIfName string, all
 
 			if err := arp.CheckWithTimeout(forwardNodeIf, podIP,
 				allocatedIPs[networkingv1.IPv4].Gw, vlanCheckTimeout); err != nil {
-				return fmt.Errorf("failed to check ipv4 vlan environment: %v", err)
+				return fmt.Errorf("failed to check ipv4 vlan environment: %w", err)
 			}
 		}
 
@@ -270,7 +270,7 @@ func ConfigureContainerNic(containerNicName, hostNicName, nodeIfName string, all
 
 			if err := ndp.CheckWithTimeout(forwardNodeIf, podIP,
 				allocatedIPs[networkingv1.IPv6].Gw, vlanCheckTimeout); err != nil {
-				return fmt.Errorf("failed to check ipv6 vlan environment: %v", err)
+				return fmt.Errorf("failed to check ipv6 vlan environment: %w", err)
 			}
 		}
 
@@ -280,20 +280,18 @@ func ConfigureContainerNic(containerNicName, hostNicName, nodeIfName string, all
 		}
 	}
 
+	// address and default route of primary ip version are configured first for dual-stack pods
+	if primaryIPVersion == networkingv1.IPv6 && len(ipConfigs) == 2 {
+		ipConfigs[0], ipConfigs[1] = ipConfigs[1], ipConfigs[0]
+		defaultRouteNets[0], defaultRouteNets[1] = defaultRouteNets[1], defaultRouteNets[0]
+	}
+
 	if err := ns.WithNetNSPath(netns.Path(), func(_ ns.NetNS) error {
-		containerLink, err := netlink.LinkByName(containerNicName)
+		link, err := netlink.LinkByName(containerNicName)
 		if err != nil {
 			return fmt.Errorf("can not find container nic %s %v", containerNicName, err)
 		}
 
-		if err = netlink.LinkSetName(containerLink, constants.ContainerNicName); err != nil {
-			return err
-		}
-
-		link, err := netlink.LinkByName(constants.ContainerNicName)
-		if err != nil {
-			return err
-		}
 		containerInterface := &current.Interface{
 			Name:    link.Attrs().Name,
 			Mac:     link.Attrs().HardwareAddr.String(),
@@ -310,13 +308,13 @@ func ConfigureContainerNic(containerNicName, hostNicName, nodeIfName string, all
 		//
 		// This must be done before we set the links UP.
 		if ipv6AddressAllocated {
-			sysctlPath := fmt.Sprintf(constants.AcceptDADSysctl, constants.ContainerNicName)
+			sysctlPath := fmt.Sprintf(constants.AcceptDADSysctl, containerNicName)
 			if err := daemonutils.SetSysctl(sysctlPath, 0); err != nil {
 				return fmt.Errorf("failed to set sysctl parameter %s to %v: %v", sysctlPath, 0, err)
 			}
 		}
 
-		if err := daemonutils.ConfigureIface(constants.ContainerNicName, result); err != nil {
+		if err := daemonutils.ConfigureIface(containerNicName, result); err != nil {
 			return fmt.Errorf("failed to config container nic: %v", err)
 		}
 
@@ -0,0 +1,118 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package containernetwork
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	daemonutils "github.com/alibaba/hybridnet/pkg/daemon/utils"
+)
+
+// ConfigurePodRouteTable installs the routes of pod ips into the route table selected by pod, and steers
+// the traffic from pod to the table by a rule of host nic. The rule shares the priority of local-pod-direct
+// rule and is added after it, so traffic to local pods is not affected, and destinations missing in the
+// selected table fall through to the rules of hybridnet.
+func ConfigurePodRouteTable(nicName string, allocatedIPs map[networkingv1.IPVersion]*daemonutils.IPInfo,
+	table, localDirectTableNum int) error {
+	hostLink, err := netlink.LinkByName(nicName)
+	if err != nil {
+		return fmt.Errorf("can not find host nic %s %v", nicName, err)
+	}
+
+	for version, ipInfo := range allocatedIPs {
+		family, maskLen := netlink.FAMILY_V4, 32
+		if version == networkingv1.IPv6 {
+			family, maskLen = netlink.FAMILY_V6, 128
+		}
+
+		podRoute := &netlink.Route{
+			LinkIndex: hostLink.Attrs().Index,
+			Dst: &net.IPNet{
+				IP:   ipInfo.Addr,
+				Mask: net.CIDRMask(maskLen, maskLen),
+			},
+			Table: table,
+		}
+		if err = netlink.RouteReplace(podRoute); err != nil {
+			return fmt.Errorf("failed to add route %v: %v", podRoute.String(), err)
+		}
+
+		if err = ensurePodRouteTableRule(nicName, table, localDirectTableNum, family); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeletePodRouteTableRules deletes the rules of host nic, which are left in kernel after host nic is deleted
+func DeletePodRouteTableRules(nicName string) error {
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		rules, err := netlink.RuleList(family)
+		if err != nil {
+			return fmt.Errorf("failed to list rules: %v", err)
+		}
+		for i := range rules {
+			if rules[i].IifName != nicName {
+				continue
+			}
+			if err = netlink.RuleDel(&rules[i]); err != nil {
+				return fmt.Errorf("failed to delete rule %v: %v", rules[i].String(), err)
+			}
+		}
+	}
+	return nil
+}
+
+func ensurePodRouteTableRule(nicName string, table, localDirectTableNum, family int) error {
+	rules, err := netlink.RuleList(family)
+	if err != nil {
+		return fmt.Errorf("failed to list rules: %v", err)
+	}
+
+	priority := -1
+	for i := range rules {
+		if rules[i].IifName == nicName {
+			if rules[i].Table == table {
+				return nil
+			}
+			// pod is reconfigured with another table
+			if err = netlink.RuleDel(&rules[i]); err != nil {
+				return fmt.Errorf("failed to delete rule %v: %v", rules[i].String(), err)
+			}
+		}
+		if rules[i].Table == localDirectTableNum && rules[i].Src == nil {
+			priority = rules[i].Priority
+		}
+	}
+	if priority < 0 {
+		return fmt.Errorf("local-pod-direct rule of table %v is not found", localDirectTableNum)
+	}
+
+	rule := netlink.NewRule()
+	rule.IifName = nicName
+	rule.Table = table
+	rule.Priority = priority
+	rule.Family = family
+	if err = netlink.RuleAdd(rule); err != nil {
+		return fmt.Errorf("failed to add policy rule %v: %v", rule.String(), err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package containernetwork
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/alibaba/hybridnet/pkg/constants"
+	daemonutils "github.com/alibaba/hybridnet/pkg/daemon/utils"
+)
+
+// AddPodSwapAddress adds the address swapped in to the container side of host nic in netns of pod alongside
+// the original one, and routes it to pod in local direct table. The original address is kept as primary, so
+// that connections from pod keep using it until it is removed.
+func AddPodSwapAddress(hostNicName, netnsPath string, address *net.IPNet, localDirectTableNum int) error {
+	hostLink, err := netlink.LinkByName(hostNicName)
+	if err != nil {
+		return fmt.Errorf("can not find host nic %s %v", hostNicName, err)
+	}
+
+	if err = ns.WithNetNSPath(netnsPath, func(_ ns.NetNS) error {
+		containerLink, err := netlink.LinkByIndex(hostLink.Attrs().ParentIndex)
+		if err != nil {
+			return fmt.Errorf("can not find peer of host nic %s: %v", hostNicName, err)
+		}
+
+		addr := &netlink.Addr{IPNet: address}
+		if address.IP.To4() == nil {
+			// the same as nic configuration, no duplicate address detection on a point-to-point link
+			addr.Flags = unix.IFA_F_NODAD
+		} else {
+			// the secondary address swapped in must survive the removal of the original primary one
+			sysctlPath := fmt.Sprintf(constants.PromoteSecondariesSysctl, containerLink.Attrs().Name)
+			if err = daemonutils.SetSysctl(sysctlPath, 1); err != nil {
+				return fmt.Errorf("failed to set sysctl parameter %v: %v", sysctlPath, err)
+			}
+		}
+
+		if err = netlink.AddrReplace(containerLink, addr); err != nil {
+			return fmt.Errorf("failed to add address %v to %v: %v", address, containerLink.Attrs().Name, err)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	podRoute := swapAddressRoute(hostLink, address, localDirectTableNum)
+	if err = netlink.RouteReplace(podRoute); err != nil {
+		return fmt.Errorf("failed to add route %v: %v", podRoute.String(), err)
+	}
+	return nil
+}
+
+// DelPodSwapAddress removes the address swapped out from the container side of host nic in netns of pod,
+// and its route in local direct table, addresses or routes missing are ignored
+func DelPodSwapAddress(hostNicName, netnsPath string, address *net.IPNet, localDirectTableNum int) error {
+	hostLink, err := netlink.LinkByName(hostNicName)
+	if err != nil {
+		return fmt.Errorf("can not find host nic %s %v", hostNicName, err)
+	}
+
+	podRoute := swapAddressRoute(hostLink, address, localDirectTableNum)
+	if err = netlink.RouteDel(podRoute); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("failed to delete route %v: %v", podRoute.String(), err)
+	}
+
+	return ns.WithNetNSPath(netnsPath, func(_ ns.NetNS) error {
+		containerLink, err := netlink.LinkByIndex(hostLink.Attrs().ParentIndex)
+		if err != nil {
+			return fmt.Errorf("can not find peer of host nic %s: %v", hostNicName, err)
+		}
+
+		if err = netlink.AddrDel(containerLink, &netlink.Addr{IPNet: address}); err != nil && err != syscall.EADDRNOTAVAIL {
+			return fmt.Errorf("failed to delete address %v from %v: %v", address, containerLink.Attrs().Name, err)
+		}
+		return nil
+	})
+}
+
+func swapAddressRoute(hostLink netlink.Link, address *net.IPNet, localDirectTableNum int) *netlink.Route {
+	maskLen := 128
+	if address.IP.To4() != nil {
+		maskLen = 32
+	}
+	return &netlink.Route{
+		LinkIndex: hostLink.Attrs().Index,
+		Dst: &net.IPNet{
+			IP:   address.IP,
+			Mask: net.CIDRMask(maskLen, maskLen),
+		},
+		Table: localDirectTableNum,
+	}
+}
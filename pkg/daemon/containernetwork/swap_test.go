@@ -0,0 +1,51 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package containernetwork
+
+import (
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestSwapAddressRoute(t *testing.T) {
+	hostLink := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Index: 10}}
+
+	tests := []struct {
+		name        string
+		address     string
+		expectedDst string
+	}{
+		{"ipv4", "192.168.0.20/24", "192.168.0.20/32"},
+		{"ipv6", "fe80::20/64", "fe80::20/128"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ip, cidr, _ := net.ParseCIDR(test.address)
+			route := swapAddressRoute(hostLink, &net.IPNet{IP: ip, Mask: cidr.Mask}, 39999)
+
+			if route.Dst.String() != test.expectedDst {
+				t.Errorf("expected dst %v, got %v", test.expectedDst, route.Dst)
+			}
+			if route.LinkIndex != 10 || route.Table != 39999 {
+				t.Errorf("expected route via link 10 in table 39999, got link %d table %d", route.LinkIndex, route.Table)
+			}
+		})
+	}
+}
@@ -32,13 +32,15 @@ import (
 	"github.com/vishvananda/netlink"
 )
 
-func GenerateContainerVethPair(podNamespace, podName string) (string, string) {
+// GenerateHostNicName generates the name of host side veth of pod, the container side
+// is named by the default interface name of network
+func GenerateHostNicName(podNamespace, podName string) string {
 	// A SHA1 is always 20 bytes long, and so is sufficient for generating the
 	// veth name and mac addr.
 	h := sha1.New()
 	h.Write([]byte(fmt.Sprintf("%s.%s", podNamespace, podName)))
 
-	return fmt.Sprintf("%s%s", constants.ContainerHostLinkPrefix, hex.EncodeToString(h.Sum(nil))[:11]), constants.ContainerNicName
+	return fmt.Sprintf("%s%s", constants.ContainerHostLinkPrefix, hex.EncodeToString(h.Sum(nil))[:11])
 }
 
 func CheckIfContainerNetworkLink(linkName string) bool {
@@ -131,9 +131,12 @@ func NewCtrlHub(config *daemonconfig.Configuration, mgr ctrl.Manager, logger log
 
 	addrV4Manager := addr.CreateAddrManager(netlink.FAMILY_V4, config.NodeName)
 
+	// bgp manager is only required by bgp networks, failing to create it, e.g., the peering interface
+	// is missing on a node without bgp networks, should not break pods of the other networks
 	bgpManager, err := bgp.NewManager(config.NodeBGPIfName, config.BGPgRPCServerAddress, logger.WithName("bgp-server"))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create bgp manager: %v", err)
+		logger.Error(err, "failed to create bgp manager, bgp networks will be unavailable on this node")
+		bgpManager = nil
 	}
 
 	ctrlHub := &CtrlHub{
@@ -236,6 +239,7 @@ func (c *CtrlHub) GetMgrAPIReader() client.Reader {
 	return c.mgr.GetAPIReader()
 }
 
+// GetBGPManager returns the bgp manager, it is nil if bgp manager failed to be created
 func (c *CtrlHub) GetBGPManager() *bgp.Manager {
 	return c.bgpManager
 }
@@ -535,7 +539,7 @@ func (c *CtrlHub) iptablesSyncLoop() {
 
 		for _, ipInstance := range ipInstanceList.Items {
 			// if this ip instance is not actually being used, ignore
-			if ipInstance.Status.Phase != networkingv1.IPPhaseUsing {
+			if !networkingv1.IsUsingPhase(ipInstance.Status.Phase) {
 				continue
 			}
 
@@ -48,6 +48,7 @@ import (
 	"github.com/alibaba/hybridnet/pkg/constants"
 	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
 	daemonutils "github.com/alibaba/hybridnet/pkg/daemon/utils"
+	"github.com/alibaba/hybridnet/pkg/feature"
 )
 
 type ipInstanceReconciler struct {
@@ -88,6 +89,11 @@ func (r *ipInstanceReconciler) Reconcile(ctx context.Context, request reconcile.
 				continue
 			}
 
+			if r.ctrlHubRef.bgpManager == nil {
+				logger.Info("skip bgp network because bgp manager is unavailable", "network", network.Name)
+				continue
+			}
+
 			if network.Spec.NetID == nil {
 				return reconcile.Result{Requeue: true},
 					fmt.Errorf("the net id of network %v must to be set", network.Name)
@@ -119,11 +125,18 @@ func (r *ipInstanceReconciler) Reconcile(ctx context.Context, request reconcile.
 	r.ctrlHubRef.neighV6Manager.ResetInfos()
 
 	r.ctrlHubRef.addrV4Manager.ResetInfos()
-	r.ctrlHubRef.bgpManager.ResetIPInfos()
+	if r.ctrlHubRef.bgpManager != nil {
+		r.ctrlHubRef.bgpManager.ResetIPInfos()
+	}
 
 	for _, ipInstance := range ipInstanceList.Items {
 		// if this ip instance is not actually being used, ignore
-		if ipInstance.Status.Phase != networkingv1.IPPhaseUsing {
+		if !networkingv1.IsUsingPhase(ipInstance.Status.Phase) {
+			continue
+		}
+
+		// the ip swapped in is not advertised until it is configured on nic of pod
+		if len(ipInstance.Labels[constants.LabelSwapPod]) > 0 && ipInstance.Status.Phase != networkingv1.IPPhaseBound {
 			continue
 		}
 
@@ -160,7 +173,9 @@ func (r *ipInstanceReconciler) Reconcile(ctx context.Context, request reconcile.
 				return reconcile.Result{Requeue: true}, fmt.Errorf("failed to generate vxlan forward node interface name: %v", err)
 			}
 		case networkingv1.NetworkModeBGP:
-			r.ctrlHubRef.bgpManager.RecordIP(podIP)
+			if r.ctrlHubRef.bgpManager != nil {
+				r.ctrlHubRef.bgpManager.RecordIP(podIP)
+			}
 		}
 
 		// create proxy neigh
@@ -197,8 +212,16 @@ func (r *ipInstanceReconciler) Reconcile(ctx context.Context, request reconcile.
 		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to sync ipv4 addresses: %v", err)
 	}
 
-	if err := r.ctrlHubRef.bgpManager.SyncIPInfos(); err != nil {
-		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to sync bgp ip paths: %v", err)
+	if r.ctrlHubRef.bgpManager != nil {
+		if err := r.ctrlHubRef.bgpManager.SyncIPInfos(); err != nil {
+			return reconcile.Result{Requeue: true}, fmt.Errorf("failed to sync bgp ip paths: %v", err)
+		}
+	}
+
+	if feature.IPSwapEnabled() {
+		if err := r.syncIPSwaps(ctx, ipInstanceList.Items); err != nil {
+			return reconcile.Result{Requeue: true}, fmt.Errorf("failed to sync ip swaps: %v", err)
+		}
 	}
 
 	r.ctrlHubRef.iptablesSyncTrigger()
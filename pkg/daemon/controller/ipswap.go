@@ -0,0 +1,135 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
+	daemonutils "github.com/alibaba/hybridnet/pkg/daemon/utils"
+)
+
+// syncIPSwaps configures the nics of local pods whose ips are being swapped. The ip swapped in is added to
+// nic alongside the original one and then marked as bound, which makes it advertised like other ips; once
+// the swap is committed, the original ip is removed from nic.
+func (r *ipInstanceReconciler) syncIPSwaps(ctx context.Context, ipInstances []networkingv1.IPInstance) error {
+	var netnsPaths map[int]string
+	for i := range ipInstances {
+		var ipInstance = &ipInstances[i]
+		fromAddress, swapping := ipInstance.Annotations[constants.AnnotationIPSwapFrom]
+		if !swapping || !ipInstance.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		if netnsPaths == nil {
+			var err error
+			if netnsPaths, err = daemonutils.NetnsPathsByID(); err != nil {
+				return fmt.Errorf("failed to list netns: %v", err)
+			}
+		}
+
+		if err := r.syncIPSwap(ctx, ipInstance, fromAddress, netnsPaths); err != nil {
+			return fmt.Errorf("failed to sync ip swap of ip instance %v: %v", ipInstance.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *ipInstanceReconciler) syncIPSwap(ctx context.Context, ipInstance *networkingv1.IPInstance, fromAddress string,
+	netnsPaths map[int]string) error {
+	logger := log.FromContext(ctx)
+
+	podName, committed := ipInstance.Labels[constants.LabelSwapPod], false
+	if len(podName) == 0 {
+		podName, committed = ipInstance.Labels[constants.LabelPod], true
+	}
+
+	var netnsPath string
+	hostNicName := containernetwork.GenerateHostNicName(ipInstance.Namespace, podName)
+	hostLink, err := netlink.LinkByName(hostNicName)
+	if err == nil {
+		netnsPath = netnsPaths[hostLink.Attrs().NetNsID]
+	} else if _, ok := err.(netlink.LinkNotFoundError); !ok {
+		return fmt.Errorf("failed to get host nic %v: %v", hostNicName, err)
+	}
+
+	if !committed {
+		// the swap will be cancelled by manager if nic of pod is never found
+		if len(netnsPath) == 0 {
+			logger.Info("skip ip swap because nic of pod is not found", "ipInstance", ipInstance.Name, "hostNic", hostNicName)
+			return nil
+		}
+
+		// the address is ensured until commit in case the sandbox of pod is recreated
+		address, err := ipNetOf(ipInstance.Spec.Address.IP)
+		if err != nil {
+			return err
+		}
+		if err = containernetwork.AddPodSwapAddress(hostNicName, netnsPath, address,
+			r.ctrlHubRef.config.LocalDirectTableNum); err != nil {
+			return err
+		}
+
+		if ipInstance.Status.Phase == networkingv1.IPPhaseBound {
+			return nil
+		}
+		patchBody := fmt.Sprintf(`{"status":{"nodeName":%q,"phase":%q,"sandboxID":%q}}`,
+			r.ctrlHubRef.config.NodeName, networkingv1.IPPhaseBound, hostLink.Attrs().Alias)
+		if err = r.Status().Patch(ctx, ipInstance, client.RawPatch(types.MergePatchType, []byte(patchBody))); err != nil {
+			return fmt.Errorf("failed to update status: %v", err)
+		}
+		logger.Info("ip swapped in is configured on nic", "ipInstance", ipInstance.Name, "from", fromAddress)
+		return nil
+	}
+
+	// nothing to remove if nic of pod is gone
+	if len(netnsPath) > 0 {
+		address, err := ipNetOf(fromAddress)
+		if err != nil {
+			return err
+		}
+		if err = containernetwork.DelPodSwapAddress(hostNicName, netnsPath, address,
+			r.ctrlHubRef.config.LocalDirectTableNum); err != nil {
+			return err
+		}
+	}
+
+	patchBody := fmt.Sprintf(`{"metadata":{"annotations":{%q:null}}}`, constants.AnnotationIPSwapFrom)
+	if err = r.Patch(ctx, ipInstance, client.RawPatch(types.MergePatchType, []byte(patchBody))); err != nil {
+		return fmt.Errorf("failed to remove annotation: %v", err)
+	}
+	logger.Info("ip swapped out is removed from nic", "ipInstance", ipInstance.Name, "from", fromAddress)
+	return nil
+}
+
+// ipNetOf parses the address of ip instance into ip with the mask of subnet
+func ipNetOf(address string) (*net.IPNet, error) {
+	ip, cidr, err := net.ParseCIDR(address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse address %v: %v", address, err)
+	}
+	return &net.IPNet{IP: ip, Mask: cidr.Mask}, nil
+}
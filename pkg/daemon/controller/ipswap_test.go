@@ -0,0 +1,47 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+)
+
+func TestIPNetOf(t *testing.T) {
+	tests := []struct {
+		name      string
+		address   string
+		expected  string
+		expectErr bool
+	}{
+		{"ipv4", "192.168.0.20/24", "192.168.0.20/24", false},
+		{"ipv6", "fe80::20/64", "fe80::20/64", false},
+		{"without mask", "192.168.0.20", "", true},
+		{"invalid", "invalid", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ipNet, err := ipNetOf(test.address)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+			if err == nil && ipNet.String() != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, ipNet)
+			}
+		})
+	}
+}
@@ -64,7 +64,9 @@ func (r *subnetReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 	r.ctrlHubRef.routeV4Manager.ResetInfos()
 	r.ctrlHubRef.routeV6Manager.ResetInfos()
 
-	r.ctrlHubRef.bgpManager.ResetPeerAndSubnetInfos()
+	if r.ctrlHubRef.bgpManager != nil {
+		r.ctrlHubRef.bgpManager.ResetPeerAndSubnetInfos()
+	}
 
 	for _, subnet := range subnetList.Items {
 		network := &networkingv1.Network{}
@@ -109,6 +111,11 @@ func (r *subnetReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 			autoNatOutgoing = networkingv1.IsSubnetAutoNatOutgoing(&subnet.Spec)
 		case networkingv1.NetworkModeBGP:
 			if isUnderlayOnHost {
+				if r.ctrlHubRef.bgpManager == nil {
+					logger.Info("skip bgp subnet because bgp manager is unavailable", "subnet", subnet.Name)
+					continue
+				}
+
 				forwardNodeIfName = r.ctrlHubRef.config.NodeBGPIfName
 
 				localAS := uint32(*network.Spec.NetID)
@@ -117,15 +124,18 @@ func (r *subnetReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 						fmt.Errorf("try start bgp manager for network %v failed: %v", network.Name, err)
 				}
 
-				if len(network.Spec.Config.BGPPeers) != 1 {
+				if len(network.Spec.Config.BGPPeers) == 0 {
 					return reconcile.Result{Requeue: true},
-						fmt.Errorf("no bgp peer or multiple bgp peers are not supported for network %v", network.Name)
+						fmt.Errorf("no bgp peer is found for network %v", network.Name)
 				}
 
 				for _, peer := range network.Spec.Config.BGPPeers {
-					r.ctrlHubRef.bgpManager.RecordPeer(peer.Address, peer.Password, int(peer.ASN), peer.GracefulRestartSeconds)
+					r.ctrlHubRef.bgpManager.RecordPeer(peer.Address, peer.Password, int(peer.ASN), peer.GracefulRestartSeconds,
+						peer.ExportPolicy, peer.ImportPolicy)
+				}
+				if bgpSubnetAdvertised(&subnet) {
+					r.ctrlHubRef.bgpManager.RecordSubnet(subnetCidr)
 				}
-				r.ctrlHubRef.bgpManager.RecordSubnet(subnetCidr)
 
 				peerAddr := net.ParseIP(network.Spec.Config.BGPPeers[0].Address)
 				if peerAddr == nil {
@@ -134,7 +144,7 @@ func (r *subnetReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 							network.Spec.Config.BGPPeers[0].Address, network.Name)
 				}
 
-				// use peer ip as gateway
+				// use the first peer ip as gateway
 				gatewayIP = peerAddr
 			}
 		default:
@@ -189,8 +199,10 @@ func (r *subnetReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 		}
 	}
 
-	if err := r.ctrlHubRef.bgpManager.SyncPeerAndSubnetInfos(); err != nil {
-		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to sync bgp peers and subnet paths: %v", err)
+	if r.ctrlHubRef.bgpManager != nil {
+		if err := r.ctrlHubRef.bgpManager.SyncPeerAndSubnetInfos(); err != nil {
+			return reconcile.Result{Requeue: true}, fmt.Errorf("failed to sync bgp peers and subnet paths: %v", err)
+		}
 	}
 
 	r.ctrlHubRef.iptablesSyncTrigger()
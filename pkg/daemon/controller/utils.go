@@ -267,3 +267,10 @@ func nodeBelongsToNetwork(nodeName string, network *networkingv1.Network) bool {
 	}
 	return isUnderlayOnHost
 }
+
+// bgpSubnetAdvertised checks whether the cidr of bgp subnet is advertised as a whole by node, pods of
+// gateway-less subnet are routed purely by the /32 or /128 paths of their ips, so that no node attracts
+// the traffic to the whole subnet
+func bgpSubnetAdvertised(subnet *networkingv1.Subnet) bool {
+	return !networkingv1.IsGatewayLessSubnet(subnet)
+}
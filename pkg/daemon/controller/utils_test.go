@@ -0,0 +1,69 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+)
+
+func TestBGPSubnetAdvertised(t *testing.T) {
+	gatewayLess := true
+	tests := []struct {
+		name     string
+		subnet   *networkingv1.Subnet
+		expected bool
+	}{
+		{
+			"subnet with gateway",
+			&networkingv1.Subnet{
+				Spec: networkingv1.SubnetSpec{
+					Range: networkingv1.AddressRange{Version: networkingv1.IPv4, CIDR: "192.168.0.0/24", Gateway: "192.168.0.1"},
+				},
+			},
+			true,
+		},
+		{
+			"subnet without gateway",
+			&networkingv1.Subnet{
+				Spec: networkingv1.SubnetSpec{
+					Range: networkingv1.AddressRange{Version: networkingv1.IPv4, CIDR: "192.168.0.0/24"},
+				},
+			},
+			true,
+		},
+		{
+			"gateway-less subnet",
+			&networkingv1.Subnet{
+				Spec: networkingv1.SubnetSpec{
+					Range:  networkingv1.AddressRange{Version: networkingv1.IPv4, CIDR: "192.168.0.0/24"},
+					Config: &networkingv1.SubnetConfig{GatewayLess: &gatewayLess},
+				},
+			},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if advertised := bgpSubnetAdvertised(test.subnet); advertised != test.expected {
+				t.Errorf("expected advertised %v, got %v", test.expected, advertised)
+			}
+		})
+	}
+}
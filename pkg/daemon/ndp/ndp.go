@@ -22,6 +22,8 @@ import (
 	"time"
 
 	"github.com/mdlayher/ndp"
+
+	"github.com/alibaba/hybridnet/pkg/daemon/utils"
 )
 
 // CheckWithTimeout checks vlan network environment and duplicate ip problems,
@@ -44,9 +46,7 @@ func CheckWithTimeout(ifi *net.Interface, srcPod, gateway net.IP, timeout time.D
 	}
 
 	if duplicatedHw, err := doNS(ndpConn, srcPod, ifi.HardwareAddr, timeout); err == nil {
-		return fmt.Errorf("pod ip %v duplicated"+
-			", please check if ip %v is occupied by other machines or containers, another hw addr is %v",
-			srcPod.String(), srcPod.String(), duplicatedHw.String())
+		return &utils.DuplicateIPError{IP: srcPod, HardwareAddr: duplicatedHw}
 	}
 
 	if err := doGratuitous(ndpConn, srcPod, ifi.HardwareAddr); err != nil {
@@ -0,0 +1,65 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/daemon/utils"
+)
+
+// reportConflictedIP annotates the ip instance of duplicated ip as conflicted, so that manager quarantines
+// the address in subnet. Reporting is best-effort, the cni add request fails anyway.
+func (cdh *cniDaemonHandler) reportConflictedIP(ipInstances []*networkingv1.IPInstance, duplicateIPErr *utils.DuplicateIPError) {
+	ipInstance := conflictedIPInstanceOf(ipInstances, duplicateIPErr)
+	if ipInstance == nil {
+		return
+	}
+
+	reason := fmt.Sprintf("answered by hw addr %v on node %v", duplicateIPErr.HardwareAddr.String(), cdh.config.NodeName)
+	patchBody := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, constants.AnnotationIPConflicted, reason)
+	if err := cdh.mgrClient.Patch(context.TODO(), ipInstance, client.RawPatch(types.MergePatchType, []byte(patchBody))); err != nil {
+		cdh.logger.Error(err, "failed to report conflicted ip", "ipInstance", ipInstance.Namespace+"/"+ipInstance.Name)
+		return
+	}
+	cdh.logger.Info("conflicted ip reported", "ipInstance", ipInstance.Namespace+"/"+ipInstance.Name, "reason", reason)
+}
+
+// conflictedIPInstanceOf picks the ip instance of duplicated ip. An answer from the MAC address of ip instance
+// itself is not a conflict, because it comes from the previous incarnation of a stateful pod, which keeps
+// both ip and MAC address, and will be gone soon.
+func conflictedIPInstanceOf(ipInstances []*networkingv1.IPInstance, duplicateIPErr *utils.DuplicateIPError) *networkingv1.IPInstance {
+	for _, ipInstance := range ipInstances {
+		ip, _, err := net.ParseCIDR(ipInstance.Spec.Address.IP)
+		if err != nil || !ip.Equal(duplicateIPErr.IP) {
+			continue
+		}
+		if strings.EqualFold(ipInstance.Spec.Address.MAC, duplicateIPErr.HardwareAddr.String()) {
+			return nil
+		}
+		return ipInstance
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/daemon/utils"
+)
+
+func TestConflictedIPInstanceOf(t *testing.T) {
+	newIPInstance := func(name, ip, mac string) *networkingv1.IPInstance {
+		return &networkingv1.IPInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: networkingv1.IPInstanceSpec{
+				Address: networkingv1.Address{IP: ip, MAC: mac},
+			},
+		}
+	}
+	ipInstances := []*networkingv1.IPInstance{
+		newIPInstance("192-168-0-10", "192.168.0.10/24", "00:00:5e:00:53:01"),
+		newIPInstance("fd00-0-0-0-0-0-0-10", "fd00::10/64", "00:00:5e:00:53:01"),
+	}
+	hwAddr, _ := net.ParseMAC("00:00:5e:00:53:ff")
+	selfHwAddr, _ := net.ParseMAC("00:00:5E:00:53:01")
+
+	tests := []struct {
+		name     string
+		err      *utils.DuplicateIPError
+		expected string
+	}{
+		{
+			"ipv4 answered by another hw addr",
+			&utils.DuplicateIPError{IP: net.ParseIP("192.168.0.10"), HardwareAddr: hwAddr},
+			"192-168-0-10",
+		},
+		{
+			"ipv6 answered by another hw addr",
+			&utils.DuplicateIPError{IP: net.ParseIP("fd00::10"), HardwareAddr: hwAddr},
+			"fd00-0-0-0-0-0-0-10",
+		},
+		{
+			"answered by previous incarnation of pod",
+			&utils.DuplicateIPError{IP: net.ParseIP("192.168.0.10"), HardwareAddr: selfHwAddr},
+			"",
+		},
+		{
+			"ip of other pod",
+			&utils.DuplicateIPError{IP: net.ParseIP("192.168.0.11"), HardwareAddr: hwAddr},
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ipInstance := conflictedIPInstanceOf(ipInstances, test.err)
+			var name string
+			if ipInstance != nil {
+				name = ipInstance.Name
+			}
+			if name != test.expected {
+				t.Errorf("expected ip instance %q, got %q", test.expected, name)
+			}
+		})
+	}
+}
@@ -18,6 +18,7 @@ package server
 
 import (
 	"fmt"
+	"math"
 	"net"
 
 	"github.com/containernetworking/plugins/pkg/ip"
@@ -28,30 +29,29 @@ import (
 
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
 	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
+	globalutils "github.com/alibaba/hybridnet/pkg/utils"
+)
+
+const (
+	// burst of tbf qdisc is the amount of bytes sent in 10ms at the configured rate
+	tbfBurstDivisor = 100
+	// packets will be dropped if they wait longer than this in tbf qdisc
+	tbfLatencyInMs = 25
 )
 
 // ipAddr is a CIDR notation IP address and prefix length
-func (cdh cniDaemonHandler) configureNic(podName, podNamespace, netns, containerID, mac string,
-	netID *int32, allocatedIPs map[networkingv1.IPVersion]*utils.IPInfo,
-	networkMode networkingv1.NetworkMode) (string, error) {
-
-	var err error
-	var nodeIfName string
-	var mtu int
-
-	switch networkMode {
-	case networkingv1.NetworkModeVlan:
-		mtu = cdh.config.VlanMTU
-		nodeIfName = cdh.config.NodeVlanIfName
-	case networkingv1.NetworkModeVxlan:
-		mtu = cdh.config.VxlanMTU
-		nodeIfName = cdh.config.NodeVxlanIfName
-	case networkingv1.NetworkModeBGP:
-		mtu = cdh.config.BGPMTU
-		nodeIfName = cdh.config.NodeBGPIfName
+func (cdh cniDaemonHandler) configureNic(podName, podNamespace, netns, containerID, ifName, mac string,
+	netID *int32, allocatedIPs map[networkingv1.IPVersion]*utils.IPInfo, primaryIPVersion networkingv1.IPVersion,
+	networkMode networkingv1.NetworkMode, interfaceSysctls []globalutils.InterfaceSysctl,
+	bandwidth *globalutils.Bandwidth, routeTable int) (string, error) {
+
+	handler, exist := cdh.networkModeHandlers[networkMode]
+	if !exist {
+		return "", fmt.Errorf("unsupported network mode %s", networkMode)
 	}
 
 	macAddr, err := net.ParseMAC(mac)
@@ -59,36 +59,232 @@ func (cdh cniDaemonHandler) configureNic(podName, podNamespace, netns, container
 		return "", fmt.Errorf("failed to parse mac %s %v", macAddr, err)
 	}
 
-	containerNicName, hostNicName, podNS, err := initContainerNic(podName, podNamespace, netns, mtu)
+	hostNicName, err := handler.Configure(&NicConfig{
+		PodName:          podName,
+		PodNamespace:     podNamespace,
+		NetNS:            netns,
+		ContainerID:      containerID,
+		IfName:           ifName,
+		MacAddr:          macAddr,
+		NetID:            netID,
+		AllocatedIPs:     allocatedIPs,
+		PrimaryIPVersion: primaryIPVersion,
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to init container nic for pod %v: %v", podName, err)
+		return "", err
+	}
+
+	if err = configureContainerNicSysctls(netns, ifName, interfaceSysctls); err != nil {
+		// clean the container nic
+		_ = deleteContainerNic(netns, ifName)
+		return "", fmt.Errorf("failed to configure container nic sysctls for %v.%v: %v", podName, podNamespace, err)
+	}
+
+	if err = configureBandwidth(netns, hostNicName, ifName, bandwidth); err != nil {
+		// clean the container nic
+		_ = deleteContainerNic(netns, ifName)
+		return "", fmt.Errorf("failed to configure bandwidth for %v.%v: %v", podName, podNamespace, err)
+	}
+
+	if routeTable > 0 {
+		if err = containernetwork.ConfigurePodRouteTable(hostNicName, allocatedIPs, routeTable, cdh.config.LocalDirectTableNum); err != nil {
+			// clean the container nic and the rules left by it
+			_ = deleteContainerNic(netns, ifName)
+			_ = containernetwork.DeletePodRouteTableRules(hostNicName)
+			return "", fmt.Errorf("failed to configure route table %v for %v.%v: %v", routeTable, podName, podNamespace, err)
+		}
 	}
 
-	defer func() {
+	// sandbox recorded on host nic is used to heal ip instance status if daemon restarts before persisting it
+	if err = recordSandboxOnHostNic(hostNicName, containerID); err != nil {
+		cdh.logger.Error(err, "failed to record sandbox on host nic", "hostNic", hostNicName, "sandbox", containerID)
+	}
+
+	return hostNicName, nil
+}
+
+// configureLoopbackNic puts loopback ips on a dummy nic of pod, and routes them to pod through
+// host nic the same way as the ips of container nic
+func configureLoopbackNic(netns, hostNicName string, loopbackIPs []*net.IPNet, localDirectTableNum int) error {
+	if len(loopbackIPs) == 0 {
+		return nil
+	}
+
+	if err := ns.WithNetNSPath(netns, func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(constants.ContainerLoopbackNicName)
 		if err != nil {
-			// clean the veth pair
-			_ = deleteContainerNic(netns)
+			if _, ok := err.(netlink.LinkNotFoundError); !ok {
+				return fmt.Errorf("failed to get loopback nic: %v", err)
+			}
+			if err = netlink.LinkAdd(&netlink.Dummy{
+				LinkAttrs: netlink.LinkAttrs{Name: constants.ContainerLoopbackNicName},
+			}); err != nil {
+				return fmt.Errorf("failed to add loopback nic: %v", err)
+			}
+			if link, err = netlink.LinkByName(constants.ContainerLoopbackNicName); err != nil {
+				return fmt.Errorf("failed to get loopback nic: %v", err)
+			}
 		}
-	}()
 
-	if err = containernetwork.ConfigureHostNic(hostNicName, allocatedIPs, cdh.config.LocalDirectTableNum); err != nil {
-		return "", fmt.Errorf("failed to configure host nic for %v.%v: %v", podName, podNamespace, err)
+		for _, loopbackIP := range loopbackIPs {
+			if err = netlink.AddrReplace(link, &netlink.Addr{
+				IPNet: loopbackIP,
+				Flags: unix.IFA_F_NODAD,
+			}); err != nil {
+				return fmt.Errorf("failed to add loopback ip %v: %v", loopbackIP.String(), err)
+			}
+		}
+		return netlink.LinkSetUp(link)
+	}); err != nil {
+		return err
 	}
 
-	if err = containernetwork.ConfigureContainerNic(containerNicName, hostNicName, nodeIfName,
-		allocatedIPs, macAddr, netID, podNS, mtu, cdh.config.VlanCheckTimeout, networkMode,
-		cdh.config.NeighGCThresh1, cdh.config.NeighGCThresh2, cdh.config.NeighGCThresh3, cdh.bgpManager); err != nil {
-		return "", fmt.Errorf("failed to configure container nic for %v.%v: %v", podName, podNamespace, err)
+	hostLink, err := netlink.LinkByName(hostNicName)
+	if err != nil {
+		return fmt.Errorf("failed to get host nic %v: %v", hostNicName, err)
 	}
+	for _, loopbackIP := range loopbackIPs {
+		loopbackRoute := &netlink.Route{
+			LinkIndex: hostLink.Attrs().Index,
+			Dst:       loopbackIP,
+			Table:     localDirectTableNum,
+		}
+		if err = netlink.RouteReplace(loopbackRoute); err != nil {
+			return fmt.Errorf("failed to add route %v: %v", loopbackRoute.String(), err)
+		}
+	}
+	return nil
+}
 
-	return hostNicName, nil
+// recordSandboxOnHostNic records the sandbox id as alias of host nic
+func recordSandboxOnHostNic(hostNicName, containerID string) error {
+	hostLink, err := netlink.LinkByName(hostNicName)
+	if err != nil {
+		return fmt.Errorf("failed to get host nic %v: %v", hostNicName, err)
+	}
+	return netlink.LinkSetAlias(hostLink, containerID)
+}
+
+// configureContainerNicSysctls sets sysctls on container nic after addresses are configured, so
+// disable_ipv6=0 can be used to enable ipv6 link-local address for an ipv4-only pod, and ipv6-only
+// or dual-stack pods are prevented from disabling ipv6 before coming here
+func configureContainerNicSysctls(netns, containerNicName string, interfaceSysctls []globalutils.InterfaceSysctl) error {
+	if len(interfaceSysctls) == 0 {
+		return nil
+	}
+
+	return ns.WithNetNSPath(netns, func(_ ns.NetNS) error {
+		for _, sysctl := range interfaceSysctls {
+			sysctlPath := sysctl.Path(containerNicName)
+			if err := utils.SetSysctl(sysctlPath, sysctl.Value); err != nil {
+				return fmt.Errorf("failed to set sysctl parameter %s to %v: %v", sysctlPath, sysctl.Value, err)
+			}
+		}
+		return nil
+	})
+}
+
+// configureBandwidth limits the rate of pod traffic with tbf qdiscs on both ends of veth pair,
+// ingress traffic of pod is shaped on host nic and egress traffic is shaped on container nic
+func configureBandwidth(netns, hostNicName, containerNicName string, bandwidth *globalutils.Bandwidth) error {
+	if bandwidth == nil {
+		return nil
+	}
+
+	if bandwidth.Ingress > 0 {
+		hostLink, err := netlink.LinkByName(hostNicName)
+		if err != nil {
+			return fmt.Errorf("failed to get host nic %v: %v", hostNicName, err)
+		}
+		if err = replaceTbfQdisc(hostLink, bandwidth.Ingress); err != nil {
+			return fmt.Errorf("failed to shape ingress traffic on host nic %v: %v", hostNicName, err)
+		}
+	}
+
+	if bandwidth.Egress > 0 {
+		return ns.WithNetNSPath(netns, func(_ ns.NetNS) error {
+			containerLink, err := netlink.LinkByName(containerNicName)
+			if err != nil {
+				return fmt.Errorf("failed to get container nic %v: %v", containerNicName, err)
+			}
+			if err = replaceTbfQdisc(containerLink, bandwidth.Egress); err != nil {
+				return fmt.Errorf("failed to shape egress traffic on container nic: %v", err)
+			}
+			return nil
+		})
+	}
+
+	return nil
 }
 
-func (cdh cniDaemonHandler) deleteNic(netns string) error {
-	return deleteContainerNic(netns)
+func replaceTbfQdisc(link netlink.Link, rateInBits uint64) error {
+	rateInBytes := rateInBits / 8
+
+	burstInBytes, limitInBytes, err := tbfBurstAndLimit(rateInBytes, link.Attrs().MTU)
+	if err != nil {
+		return err
+	}
+
+	qdisc := &netlink.Tbf{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(1, 0),
+			Parent:    netlink.HANDLE_ROOT,
+		},
+		Rate:   rateInBytes,
+		Limit:  limitInBytes,
+		Buffer: netlink.Xmittime(rateInBytes, burstInBytes),
+	}
+	return netlink.QdiscReplace(qdisc)
+}
+
+// tbfBurstAndLimit calculates burst and limit of tbf qdisc in bytes, which are carried
+// by 32-bit netlink attributes and must not overflow
+func tbfBurstAndLimit(rateInBytes uint64, mtu int) (uint32, uint32, error) {
+	// burst should hold at least one packet, and a larger burst is needed by a higher rate
+	burstInBytes := rateInBytes / tbfBurstDivisor
+	if minBurst := uint64(mtu) * 2; burstInBytes < minBurst {
+		burstInBytes = minBurst
+	}
+
+	limitInBytes := rateInBytes*tbfLatencyInMs/1000 + burstInBytes
+	if limitInBytes > math.MaxUint32 {
+		return 0, 0, fmt.Errorf("rate %v bytes/s is too large for tbf qdisc", rateInBytes)
+	}
+	return uint32(burstInBytes), uint32(limitInBytes), nil
+}
+
+// deleteNic deletes the veth pair of pod by its host side if it is recorded with the same sandbox,
+// or else falls back to deleting the container side by name, since the default interface name
+// of network might have been changed and nics configured before sandbox recording have no alias
+func (cdh cniDaemonHandler) deleteNic(podName, podNamespace, netns, containerID string) error {
+	hostNicName := containernetwork.GenerateHostNicName(podNamespace, podName)
+	hostLink, err := netlink.LinkByName(hostNicName)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); !ok {
+			return fmt.Errorf("failed to get host nic %v: %v", hostNicName, err)
+		}
+		// rules of the route table selected by pod are not deleted along with host nic
+		if err = containernetwork.DeletePodRouteTableRules(hostNicName); err != nil {
+			return err
+		}
+	} else if len(containerID) != 0 && hostLink.Attrs().Alias == containerID {
+		if err = netlink.LinkDel(hostLink); err != nil {
+			return fmt.Errorf("failed to delete host nic %v: %v", hostNicName, err)
+		}
+		return containernetwork.DeletePodRouteTableRules(hostNicName)
+	}
+
+	if err = deleteContainerNic(netns, cdh.config.DefaultInterfaceName); err != nil {
+		return err
+	}
+	if cdh.config.DefaultInterfaceName != constants.ContainerNicName {
+		return deleteContainerNic(netns, constants.ContainerNicName)
+	}
+	return nil
 }
 
-func deleteContainerNic(netns string) error {
+func deleteContainerNic(netns, containerNicName string) error {
 	nsHandler, err := ns.GetNS(netns)
 	if err != nil {
 		return fmt.Errorf("get ns error: %v", err)
@@ -96,14 +292,14 @@ func deleteContainerNic(netns string) error {
 	defer nsHandler.Close()
 
 	return nsHandler.Do(func(netNS ns.NetNS) error {
-		if err := ip.DelLinkByName(constants.ContainerNicName); err != nil && err != ip.ErrLinkNotFound {
+		if err := ip.DelLinkByName(containerNicName); err != nil && err != ip.ErrLinkNotFound {
 			return err
 		}
 		return nil
 	})
 }
 
-func initContainerNic(podName, podNamespace, netns string, mtu int) (string, string, ns.NetNS, error) {
+func initContainerNic(podName, podNamespace, netns, containerNicName string, mtu int) (string, string, ns.NetNS, error) {
 	podNS, err := ns.GetNS(netns)
 	if err != nil {
 		return "", "", nil, fmt.Errorf("failed to open netns %q: %v", netns, err)
@@ -116,7 +312,7 @@ func initContainerNic(podName, podNamespace, netns string, mtu int) (string, str
 	}
 	defer hostNS.Close()
 
-	hostNicName, containerNicName := containernetwork.GenerateContainerVethPair(podNamespace, podName)
+	hostNicName := containernetwork.GenerateHostNicName(podNamespace, podName)
 
 	if err := ns.WithNetNSPath(podNS.Path(), func(_ ns.NetNS) error {
 		veth := netlink.Veth{
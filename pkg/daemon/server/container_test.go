@@ -0,0 +1,76 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package server
+
+import "testing"
+
+func TestTbfBurstAndLimit(t *testing.T) {
+	tests := []struct {
+		name          string
+		rateInBytes   uint64
+		mtu           int
+		expectedBurst uint32
+		expectedLimit uint32
+		expectErr     bool
+	}{
+		{
+			"burst of two packets at low rate",
+			125000,
+			1500,
+			3000,
+			6125,
+			false,
+		},
+		{
+			"burst of 10ms at high rate",
+			125000000,
+			1500,
+			1250000,
+			4375000,
+			false,
+		},
+		{
+			"maximum bandwidth",
+			12500000000,
+			1500,
+			125000000,
+			437500000,
+			false,
+		},
+		{
+			"overflowing limit",
+			125000000000,
+			1500,
+			0,
+			0,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			burst, limit, err := tbfBurstAndLimit(test.rateInBytes, test.mtu)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("test %s fails: expected error %v but got %v", test.name, test.expectErr, err)
+			}
+			if burst != test.expectedBurst || limit != test.expectedLimit {
+				t.Errorf("test %s fails: expected burst %d limit %d but got burst %d limit %d",
+					test.name, test.expectedBurst, test.expectedLimit, burst, limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/emicklei/go-restful"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
+	"github.com/alibaba/hybridnet/pkg/daemon/utils"
+	"github.com/alibaba/hybridnet/pkg/request"
+)
+
+// handlePodDiagnostics dumps the interfaces, addresses, routes and neighbors in netns of pod, which is found
+// by the netns id of the peer of its host veth. Nothing but dumping is done in netns of pod
+func (cdh *cniDaemonHandler) handlePodDiagnostics(req *restful.Request, resp *restful.Response) {
+	podNamespace, podName := req.QueryParameter("namespace"), req.QueryParameter("name")
+	if len(podNamespace) == 0 || len(podName) == 0 {
+		cdh.podDiagnosticsErrorWrapper(fmt.Errorf("namespace and name of pod are required"), http.StatusBadRequest, resp)
+		return
+	}
+
+	hostNicName := containernetwork.GenerateHostNicName(podNamespace, podName)
+	hostLink, err := netlink.LinkByName(hostNicName)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			errMsg := fmt.Errorf("host nic %v of pod %v/%v is not found on node %v", hostNicName, podNamespace, podName, cdh.config.NodeName)
+			cdh.podDiagnosticsErrorWrapper(errMsg, http.StatusNotFound, resp)
+			return
+		}
+		cdh.podDiagnosticsErrorWrapper(fmt.Errorf("failed to get host nic %v: %v", hostNicName, err), http.StatusInternalServerError, resp)
+		return
+	}
+
+	netnsPaths, err := utils.NetnsPathsByID()
+	if err != nil {
+		cdh.podDiagnosticsErrorWrapper(fmt.Errorf("failed to list netns: %v", err), http.StatusInternalServerError, resp)
+		return
+	}
+	netnsPath, exist := netnsPaths[hostLink.Attrs().NetNsID]
+	if !exist {
+		errMsg := fmt.Errorf("netns of the peer of host nic %v is not found", hostNicName)
+		cdh.podDiagnosticsErrorWrapper(errMsg, http.StatusNotFound, resp)
+		return
+	}
+
+	response := request.PodDiagnosticsResponse{
+		PodName:       podName,
+		PodNamespace:  podNamespace,
+		HostInterface: hostNicName,
+		Netns:         netnsPath,
+	}
+	if err = ns.WithNetNSPath(netnsPath, func(_ ns.NetNS) error {
+		return dumpNetnsDiagnostics(&response)
+	}); err != nil {
+		errMsg := fmt.Errorf("failed to dump netns %v of pod %v/%v: %v", netnsPath, podNamespace, podName, err)
+		cdh.podDiagnosticsErrorWrapper(errMsg, http.StatusInternalServerError, resp)
+		return
+	}
+
+	_ = resp.WriteHeaderAndEntity(http.StatusOK, response)
+}
+
+func (cdh *cniDaemonHandler) podDiagnosticsErrorWrapper(err error, status int, resp *restful.Response) {
+	cdh.logger.Error(err, "pod diagnostics handler error")
+	_ = resp.WriteHeaderAndEntity(status, request.PodDiagnosticsResponse{
+		Err: err.Error(),
+	})
+}
+
+// dumpNetnsDiagnostics dumps the nic configuration of current netns, routes of all the tables are included
+func dumpNetnsDiagnostics(response *request.PodDiagnosticsResponse) error {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return fmt.Errorf("failed to list links: %v", err)
+	}
+
+	var linkNames = map[int]string{}
+	response.Interfaces = make([]request.PodDiagnosticsInterface, 0, len(links))
+	for _, link := range links {
+		attrs := link.Attrs()
+		linkNames[attrs.Index] = attrs.Name
+
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			return fmt.Errorf("failed to list addresses of %v: %v", attrs.Name, err)
+		}
+		var addresses = make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			addresses = append(addresses, addr.IPNet.String())
+		}
+
+		response.Interfaces = append(response.Interfaces, request.PodDiagnosticsInterface{
+			Name:      attrs.Name,
+			Index:     attrs.Index,
+			Type:      link.Type(),
+			MAC:       attrs.HardwareAddr.String(),
+			MTU:       attrs.MTU,
+			State:     attrs.OperState.String(),
+			Flags:     attrs.Flags.String(),
+			Addresses: addresses,
+		})
+	}
+
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: unix.RT_TABLE_UNSPEC}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return fmt.Errorf("failed to list routes: %v", err)
+	}
+	response.Routes = make([]request.PodDiagnosticsRoute, 0, len(routes))
+	for _, route := range routes {
+		response.Routes = append(response.Routes, request.PodDiagnosticsRoute{
+			Table:       route.Table,
+			Destination: routeDestination(route.Dst),
+			Gateway:     ipString(route.Gw),
+			Source:      ipString(route.Src),
+			Interface:   linkNames[route.LinkIndex],
+			Scope:       route.Scope.String(),
+			Metric:      route.Priority,
+		})
+	}
+
+	neighs, err := netlink.NeighList(0, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("failed to list neighbors: %v", err)
+	}
+	response.Neighbors = make([]request.PodDiagnosticsNeighbor, 0, len(neighs))
+	for _, neigh := range neighs {
+		response.Neighbors = append(response.Neighbors, request.PodDiagnosticsNeighbor{
+			IP:        ipString(neigh.IP),
+			MAC:       neigh.HardwareAddr.String(),
+			Interface: linkNames[neigh.LinkIndex],
+			State:     neighStateString(neigh.State),
+		})
+	}
+	return nil
+}
+
+// routeDestination formats the destination of route as ip route does, nil destination is the default route
+func routeDestination(dst *net.IPNet) string {
+	if dst == nil {
+		return "default"
+	}
+	return dst.String()
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+var neighStates = []struct {
+	state int
+	name  string
+}{
+	{netlink.NUD_INCOMPLETE, "INCOMPLETE"},
+	{netlink.NUD_REACHABLE, "REACHABLE"},
+	{netlink.NUD_STALE, "STALE"},
+	{netlink.NUD_DELAY, "DELAY"},
+	{netlink.NUD_PROBE, "PROBE"},
+	{netlink.NUD_FAILED, "FAILED"},
+	{netlink.NUD_NOARP, "NOARP"},
+	{netlink.NUD_PERMANENT, "PERMANENT"},
+}
+
+// neighStateString formats the state flags of neighbor as ip neigh does
+func neighStateString(state int) string {
+	var names []string
+	for _, s := range neighStates {
+		if state&s.state != 0 {
+			names = append(names, s.name)
+		}
+	}
+	if len(names) == 0 {
+		return "NONE"
+	}
+	return strings.Join(names, ",")
+}
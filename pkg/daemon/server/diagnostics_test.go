@@ -0,0 +1,68 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestRouteDestination(t *testing.T) {
+	_, ipv4Net, _ := net.ParseCIDR("192.168.0.0/24")
+	_, ipv6Net, _ := net.ParseCIDR("fe80::/64")
+
+	tests := []struct {
+		name     string
+		dst      *net.IPNet
+		expected string
+	}{
+		{"default", nil, "default"},
+		{"ipv4", ipv4Net, "192.168.0.0/24"},
+		{"ipv6", ipv6Net, "fe80::/64"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := routeDestination(test.dst); got != test.expected {
+				t.Errorf("expect %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestNeighStateString(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    int
+		expected string
+	}{
+		{"none", netlink.NUD_NONE, "NONE"},
+		{"reachable", netlink.NUD_REACHABLE, "REACHABLE"},
+		{"permanent", netlink.NUD_PERMANENT, "PERMANENT"},
+		{"multiple", netlink.NUD_STALE | netlink.NUD_NOARP, "STALE,NOARP"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := neighStateString(test.state); got != test.expected {
+				t.Errorf("expect %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
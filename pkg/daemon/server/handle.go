@@ -18,9 +18,12 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/alibaba/hybridnet/pkg/daemon/utils"
@@ -30,6 +33,8 @@ import (
 	"github.com/go-logr/logr"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"k8s.io/apimachinery/pkg/types"
 
@@ -39,7 +44,12 @@ import (
 	"github.com/alibaba/hybridnet/pkg/constants"
 	daemonconfig "github.com/alibaba/hybridnet/pkg/daemon/config"
 	"github.com/alibaba/hybridnet/pkg/daemon/controller"
+	"github.com/alibaba/hybridnet/pkg/daemon/route"
+	"github.com/alibaba/hybridnet/pkg/feature"
+	"github.com/alibaba/hybridnet/pkg/ipam/store"
 	"github.com/alibaba/hybridnet/pkg/request"
+	globalutils "github.com/alibaba/hybridnet/pkg/utils"
+	"github.com/alibaba/hybridnet/pkg/utils/transform"
 
 	"github.com/emicklei/go-restful"
 )
@@ -49,6 +59,12 @@ type cniDaemonHandler struct {
 	mgrClient    client.Client
 	mgrAPIReader client.Reader
 	bgpManager   *bgp.Manager
+	ipamStore    *store.Worker
+
+	// allocationRequestClient is shared by requests of allocation to manager
+	allocationRequestClient *http.Client
+
+	networkModeHandlers map[networkingv1.NetworkMode]NetworkModeHandler
 
 	logger logr.Logger
 }
@@ -60,7 +76,16 @@ func createCniDaemonHandler(ctx context.Context, config *daemonconfig.Configurat
 		mgrClient:    ctrlRef.GetMgrClient(),
 		mgrAPIReader: ctrlRef.GetMgrAPIReader(),
 		bgpManager:   ctrlRef.GetBGPManager(),
+		ipamStore:    store.NewWorker(ctrlRef.GetMgrClient()),
 		logger:       logger,
+
+		networkModeHandlers: newNetworkModeHandlers(config, ctrlRef.GetBGPManager()),
+	}
+
+	var err error
+	if cdh.allocationRequestClient, err = request.NewAllocationRequestClient(config.AllocationRequestTLSCertFile,
+		config.AllocationRequestTLSKeyFile, config.AllocationRequestTLSCAFile); err != nil {
+		return nil, fmt.Errorf("failed to create allocation request client: %v", err)
 	}
 
 	if ok := ctrlRef.CacheSynced(ctx); !ok {
@@ -84,12 +109,11 @@ func (cdh *cniDaemonHandler) handleAdd(req *restful.Request, resp *restful.Respo
 	var netID *int32
 	var affectedIPInstances []*networkingv1.IPInstance
 
-	allocatedIPs := map[networkingv1.IPVersion]*utils.IPInfo{
-		networkingv1.IPv4: nil,
-		networkingv1.IPv6: nil,
-	}
+	// only slots of allocated ip families will be filled
+	allocatedIPs := map[networkingv1.IPVersion]*utils.IPInfo{}
 
 	var returnIPAddress []request.IPAddress
+	var pod *corev1.Pod
 
 	backOffBase := 5 * time.Microsecond
 	retries := 11
@@ -98,42 +122,87 @@ func (cdh *cniDaemonHandler) handleAdd(req *restful.Request, resp *restful.Respo
 		time.Sleep(backOffBase)
 		backOffBase = backOffBase * 2
 
-		pod := &corev1.Pod{}
-		if err := cdh.mgrAPIReader.Get(context.TODO(), types.NamespacedName{
+		// only the initial fetch will be retried if pod is not found
+		podGetRetries := 0
+		if i == 0 {
+			podGetRetries = cdh.config.PodGetRetries
+		}
+
+		if pod, err = cdh.getPod(types.NamespacedName{
 			Name:      podRequest.PodName,
 			Namespace: podRequest.PodNamespace,
-		}, pod); err != nil {
+		}, podGetRetries); err != nil {
 			errMsg := fmt.Errorf("failed to get pod %v/%v: %v", podRequest.PodName, podRequest.PodNamespace, err)
-			cdh.errorWrapper(errMsg, http.StatusBadRequest, resp)
+			cdh.errorWrapper(errMsg, apiErrorStatusCode(err), resp)
 			return
 		}
 
 		// wait for ip instance to be coupled
-		annotation := pod.GetAnnotations()
-		_, exist := annotation[constants.AnnotationIP]
-		if exist {
+		coupled, err := cdh.podCoupled(pod)
+		if err != nil {
+			errMsg := fmt.Errorf("failed to check ip instances of pod %v/%v: %v", podRequest.PodName, podRequest.PodNamespace, err)
+			cdh.errorWrapper(errMsg, http.StatusServiceUnavailable, resp)
+			return
+		}
+		if coupled {
 			break
+		} else if i == 0 && len(cdh.config.AllocationRequestURL) > 0 {
+			// failure of request is left to the waiting below, the cni request will be retried
+			// by plugin and the allocation will be requested again
+			if err = request.RequestAllocation(cdh.allocationRequestClient, cdh.config.AllocationRequestURL,
+				pod.Namespace, pod.Name); err != nil {
+				cdh.logger.Error(err, "failed to request allocation", "namespace", pod.Namespace, "name", pod.Name)
+			}
 		} else if i == retries-1 {
-			errMsg := fmt.Errorf("failed to wait for pod %v/%v be coupled with ip: %v", podRequest.PodName, podRequest.PodNamespace, err)
-			cdh.errorWrapper(errMsg, http.StatusBadRequest, resp)
+			reason, status, errMsg := classifyUncoupledPod(pod)
+			cdh.withCorrelationID(pod).errorWrapperWithReason(errMsg, status, reason, resp)
 			return
 		}
 	}
 
+	// logs below are tied to the allocation attempt of manager which pod is coupled by
+	cdh = cdh.withCorrelationID(pod)
+
 	ipInstanceList := &networkingv1.IPInstanceList{}
 	if err := cdh.mgrClient.List(context.TODO(), ipInstanceList, client.MatchingLabels{
 		constants.LabelNode: cdh.config.NodeName,
 		constants.LabelPod:  podRequest.PodName,
 	}); err != nil {
 		errMsg := fmt.Errorf("failed to list ip instance for pod %v: %v", cdh.config.NodeName, err)
-		cdh.errorWrapper(errMsg, http.StatusBadRequest, resp)
+		cdh.errorWrapper(errMsg, http.StatusServiceUnavailable, resp)
+		return
+	}
+
+	// refuse to configure pod coupled with too many ip instances by a runaway allocation
+	var coupledCount int
+	for i := range ipInstanceList.Items {
+		var ipInstance = &ipInstanceList.Items[i]
+		if ipInstance.Status.Phase != networkingv1.IPPhaseReserved &&
+			ipInstance.Status.PodName == podRequest.PodName && ipInstance.Status.PodNamespace == podRequest.PodNamespace {
+			coupledCount++
+		}
+	}
+	if err := store.CheckIPInstanceLimit(podRequest.PodNamespace, podRequest.PodName, coupledCount); err != nil {
+		cdh.errorWrapper(err, http.StatusInternalServerError, resp)
 		return
 	}
 
 	var networkName string
-	for _, ipInstance := range ipInstanceList.Items {
+	for i := range ipInstanceList.Items {
+		var ipInstance = &ipInstanceList.Items[i]
+		// reserved ip is not coupled with current pod
+		if ipInstance.Status.Phase == networkingv1.IPPhaseReserved {
+			continue
+		}
+
 		// IPv4 and IPv6 ip will exist at the same time
 		if ipInstance.Status.PodName == podRequest.PodName && ipInstance.Status.PodNamespace == podRequest.PodNamespace {
+			// a malformed ip instance must fail the request before anything is touched
+			if err := validateIPInstanceAddress(ipInstance); err != nil {
+				errMsg := fmt.Errorf("invalid ip instance %v of pod %v/%v: %v", ipInstance.Name, podRequest.PodNamespace, podRequest.PodName, err)
+				cdh.errorWrapper(errMsg, http.StatusInternalServerError, resp)
+				return
+			}
 
 			if netID == nil && macAddr == "" {
 				netID = ipInstance.Spec.Address.NetID
@@ -159,6 +228,11 @@ func (cdh *cniDaemonHandler) handleAdd(req *restful.Request, resp *restful.Respo
 			ipVersion := networkingv1.IPv4
 			switch ipInstance.Spec.Address.Version {
 			case networkingv1.IPv4:
+				if feature.IPv6OnlyEnabled() {
+					errMsg := fmt.Errorf("ipv4 address is not supported in ipv6-only cluster, %v/%v", podRequest.PodNamespace, podRequest.PodName)
+					cdh.errorWrapper(errMsg, http.StatusInternalServerError, resp)
+					return
+				}
 				if allocatedIPs[networkingv1.IPv4] != nil {
 					errMsg := fmt.Errorf("only one ipv4 address for each pod are supported, %v/%v", podRequest.PodNamespace, podRequest.PodName)
 					cdh.errorWrapper(errMsg, http.StatusInternalServerError, resp)
@@ -206,26 +280,93 @@ func (cdh *cniDaemonHandler) handleAdd(req *restful.Request, resp *restful.Respo
 				Mac:      ipInstance.Spec.Address.MAC,
 				Gateway:  ipInstance.Spec.Address.Gateway,
 				Protocol: ipVersion,
+				NetID:    ipInstance.Spec.Address.NetID,
 			})
 
-			affectedIPInstances = append(affectedIPInstances, &ipInstance)
+			affectedIPInstances = append(affectedIPInstances, ipInstance)
 		}
 	}
 
 	// check valid ip information second time
 	if macAddr == "" || netID == nil {
+		// ip instances might be not synced into cache yet
 		errMsg := fmt.Errorf("no available ip for pod %s/%s", podRequest.PodNamespace, podRequest.PodName)
-		cdh.errorWrapper(errMsg, http.StatusInternalServerError, resp)
+		cdh.errorWrapper(errMsg, http.StatusServiceUnavailable, resp)
 		return
 	}
 
 	network := &networkingv1.Network{}
 	if err := cdh.mgrClient.Get(context.TODO(), types.NamespacedName{Name: networkName}, network); err != nil {
-		errMsg := fmt.Errorf("cannot get network %v", networkName)
-		cdh.errorWrapper(errMsg, http.StatusInternalServerError, resp)
+		errMsg := fmt.Errorf("cannot get network %v: %v", networkName, err)
+		cdh.errorWrapper(errMsg, apiErrorStatusCode(err), resp)
 		return
 	}
 
+	// check expected interfaces before any configuration, so a multi-nic pod will never be
+	// partially configured by add request
+	defaultIfName := networkingv1.GetNetworkDefaultInterfaceName(network, cdh.config.DefaultInterfaceName)
+	primaryIPVersion := networkingv1.GetNetworkPrimaryIPVersion(network, cdh.config.PreferIPv6Address)
+	delegatedIfNames, err := delegatedInterfaces(pod, defaultIfName)
+	if err != nil {
+		errMsg := fmt.Errorf("invalid interfaces of pod %v/%v: %v", podRequest.PodNamespace, podRequest.PodName, err)
+		cdh.errorWrapper(errMsg, http.StatusBadRequest, resp)
+		return
+	}
+
+	interfaceSysctls, err := globalutils.ParseInterfaceSysctls(pod.Annotations[constants.AnnotationInterfaceSysctls])
+	if err != nil {
+		errMsg := fmt.Errorf("failed to parse interface sysctls for pod %v/%v: %v", podRequest.PodNamespace, podRequest.PodName, err)
+		cdh.errorWrapper(errMsg, http.StatusBadRequest, resp)
+		return
+	}
+
+	// ipv6 address can not be configured on an interface which disables ipv6
+	if allocatedIPs[networkingv1.IPv6] != nil && globalutils.IPv6DisabledByInterfaceSysctls(interfaceSysctls) {
+		errMsg := fmt.Errorf("ipv6 can not be disabled by interface sysctls for pod %v/%v with ipv6 address",
+			podRequest.PodNamespace, podRequest.PodName)
+		cdh.errorWrapper(errMsg, http.StatusBadRequest, resp)
+		return
+	}
+
+	// bandwidth annotations will be ignored if bandwidth shaping is not enabled
+	var bandwidth *globalutils.Bandwidth
+	if cdh.config.EnableBandwidthShaping {
+		if bandwidth, err = globalutils.ParseBandwidth(pod.Annotations[constants.AnnotationIngressBandwidth],
+			pod.Annotations[constants.AnnotationEgressBandwidth]); err != nil {
+			errMsg := fmt.Errorf("failed to parse bandwidth for pod %v/%v: %v", podRequest.PodNamespace, podRequest.PodName, err)
+			cdh.errorWrapper(errMsg, http.StatusBadRequest, resp)
+			return
+		}
+	}
+
+	routeTable, err := globalutils.ParseRouteTable(pod.Annotations[constants.AnnotationRouteTable])
+	if err == nil {
+		err = cdh.validateRouteTable(routeTable)
+	}
+	if err != nil {
+		errMsg := fmt.Errorf("invalid route table for pod %v/%v: %v", podRequest.PodNamespace, podRequest.PodName, err)
+		cdh.errorWrapper(errMsg, http.StatusBadRequest, resp)
+		return
+	}
+
+	// loopback ips are allocated after the ips of pod, wait for them before any configuration
+	loopbackIPs, err := cdh.loopbackIPsOf(pod)
+	if err != nil {
+		errMsg := fmt.Errorf("failed to get loopback ips of pod %v/%v: %v", podRequest.PodNamespace, podRequest.PodName, err)
+		cdh.errorWrapper(errMsg, http.StatusServiceUnavailable, resp)
+		return
+	}
+
+	// mark ip instances as binding, so a pod stuck in nic configuration can be distinguished
+	for _, ip := range affectedIPInstances {
+		ip.Status.Phase = networkingv1.IPPhaseBinding
+		if err = cdh.mgrClient.Status().Update(context.TODO(), ip); err != nil {
+			errMsg := fmt.Errorf("failed to update IPInstance crd %s to phase %s: %v", ip.Name, networkingv1.IPPhaseBinding, err)
+			cdh.errorWrapper(errMsg, apiErrorStatusCode(err), resp)
+			return
+		}
+	}
+
 	cdh.logger.Info("Create container",
 		"podName", podRequest.PodName,
 		"podNamespace", podRequest.PodNamespace,
@@ -233,12 +374,24 @@ func (cdh *cniDaemonHandler) handleAdd(req *restful.Request, resp *restful.Respo
 		"macAddr", macAddr,
 		"netID", *netID)
 	hostInterface, err := cdh.configureNic(podRequest.PodName, podRequest.PodNamespace, podRequest.NetNs, podRequest.ContainerID,
-		macAddr, netID, allocatedIPs, networkingv1.GetNetworkMode(network))
+		defaultIfName, macAddr, netID, allocatedIPs, primaryIPVersion, networkingv1.GetNetworkMode(network), interfaceSysctls, bandwidth,
+		routeTable)
 	if err != nil {
+		var duplicateIPErr *utils.DuplicateIPError
+		if errors.As(err, &duplicateIPErr) {
+			cdh.reportConflictedIP(affectedIPInstances, duplicateIPErr)
+		}
 		errMsg := fmt.Errorf("failed to configure nic: %v", err)
 		cdh.errorWrapper(errMsg, http.StatusInternalServerError, resp)
 		return
 	}
+	if err = configureLoopbackNic(podRequest.NetNs, hostInterface, loopbackIPs, cdh.config.LocalDirectTableNum); err != nil {
+		// clean the container nic
+		_ = deleteContainerNic(podRequest.NetNs, defaultIfName)
+		errMsg := fmt.Errorf("failed to configure loopback nic: %v", err)
+		cdh.errorWrapper(errMsg, http.StatusInternalServerError, resp)
+		return
+	}
 	cdh.logger.Info("Container network created",
 		"podName", podRequest.PodName,
 		"podNamespace", podRequest.PodNamespace,
@@ -256,6 +409,7 @@ func (cdh *cniDaemonHandler) handleAdd(req *restful.Request, resp *restful.Respo
 		}
 
 		newIPInstance.Status.SandboxID = podRequest.ContainerID
+		newIPInstance.Status.Phase = networkingv1.IPPhaseBound
 		if err = cdh.mgrClient.Status().Update(context.TODO(), newIPInstance); err != nil {
 			errMsg := fmt.Errorf("failed to update IPInstance crd for %s, %v", newIPInstance.Name, err)
 			cdh.errorWrapper(errMsg, http.StatusInternalServerError, resp)
@@ -263,9 +417,47 @@ func (cdh *cniDaemonHandler) handleAdd(req *restful.Request, resp *restful.Respo
 		}
 	}
 
+	if ipReadinessGated(pod) {
+		if err = cdh.patchIPBoundCondition(pod, printAllocatedIPs(allocatedIPs)); err != nil {
+			errMsg := fmt.Errorf("failed to patch ip bound condition for pod %v/%v: %v",
+				podRequest.PodNamespace, podRequest.PodName, err)
+			cdh.errorWrapper(errMsg, apiErrorStatusCode(err), resp)
+			return
+		}
+	}
+
+	// host interface will be overridden if sandbox is recreated
+	if cdh.config.AnnotateHostInterface {
+		if err = cdh.patchHostInterfaceAnnotation(podRequest.PodName, podRequest.PodNamespace, hostInterface); err != nil {
+			cdh.logger.Error(err, "failed to annotate host interface",
+				"podName", podRequest.PodName,
+				"podNamespace", podRequest.PodNamespace)
+		}
+	}
+
+	// only networks interoperating with calico need the annotation, on nodes running mixed networks
+	if podIPs, ok := calicoPodIPsAnnotation(network, cdh.config.PatchCalicoPodIPsAnnotation, returnIPAddress); ok {
+		if err = cdh.patchCalicoPodIPsAnnotation(podRequest.PodName, podRequest.PodNamespace, podIPs); err != nil {
+			cdh.logger.Error(err, "failed to annotate calico pod ips",
+				"podName", podRequest.PodName,
+				"podNamespace", podRequest.PodNamespace)
+		}
+	}
+
+	if len(delegatedIfNames) > 0 {
+		cdh.logger.Info("Non-default interfaces are delegated to ipam path",
+			"podName", podRequest.PodName,
+			"podNamespace", podRequest.PodNamespace,
+			"interfaces", delegatedIfNames)
+	}
+
+	sortIPAddresses(returnIPAddress, primaryIPVersion == networkingv1.IPv6)
+
 	_ = resp.WriteHeaderAndEntity(http.StatusOK, request.PodResponse{
-		IPAddress:     returnIPAddress,
-		HostInterface: hostInterface,
+		IPAddress:           returnIPAddress,
+		HostInterface:       hostInterface,
+		NetworkMode:         networkingv1.GetNetworkMode(network),
+		DelegatedInterfaces: delegatedIfNames,
 	})
 }
 
@@ -285,7 +477,7 @@ func (cdh *cniDaemonHandler) handleDel(req *restful.Request, resp *restful.Respo
 
 	cdh.logger.V(5).Info("handle del request", "content", podRequest)
 
-	err = cdh.deleteNic(podRequest.NetNs)
+	err = cdh.deleteNic(podRequest.PodName, podRequest.PodNamespace, podRequest.NetNs, podRequest.ContainerID)
 	if err != nil {
 		errMsg := fmt.Errorf("failed to del container nic for %s: %v",
 			fmt.Sprintf("%s.%s", podRequest.PodName, podRequest.PodNamespace), err)
@@ -293,6 +485,22 @@ func (cdh *cniDaemonHandler) handleDel(req *restful.Request, resp *restful.Respo
 		return
 	}
 
+	// netns might have been destroyed, the route of loopback ips is removed with host nic anyway
+	if len(podRequest.NetNs) != 0 {
+		if err = deleteContainerNic(podRequest.NetNs, constants.ContainerLoopbackNicName); err != nil {
+			cdh.logger.V(5).Info("failed to delete loopback nic", "podName", podRequest.PodName,
+				"podNamespace", podRequest.PodNamespace, "error", err.Error())
+		}
+	}
+
+	if cdh.config.AnnotateHostInterface {
+		if err = cdh.patchHostInterfaceAnnotation(podRequest.PodName, podRequest.PodNamespace, ""); err != nil {
+			cdh.logger.Error(err, "failed to clear host interface annotation",
+				"podName", podRequest.PodName,
+				"podNamespace", podRequest.PodNamespace)
+		}
+	}
+
 	cdh.logger.Info("Container deleted",
 		"podName", podRequest.PodName,
 		"podNamespace", podRequest.PodNamespace,
@@ -301,13 +509,336 @@ func (cdh *cniDaemonHandler) handleDel(req *restful.Request, resp *restful.Respo
 	resp.WriteHeader(http.StatusNoContent)
 }
 
+// handleRelease recycles all the ip instances of a pod out-of-band, it only works when
+// the pod does not exist anymore, unless the release is forced explicitly
+func (cdh *cniDaemonHandler) handleRelease(req *restful.Request, resp *restful.Response) {
+	podRequest := request.PodRequest{}
+	err := req.ReadEntity(&podRequest)
+	if err != nil {
+		errMsg := fmt.Errorf("failed to parse release request: %v", err)
+		cdh.errorWrapper(errMsg, http.StatusBadRequest, resp)
+		return
+	}
+
+	cdh.logger.V(5).Info("handle release request", "content", podRequest)
+
+	podExist := true
+	pod := &corev1.Pod{}
+	if err = cdh.mgrAPIReader.Get(context.TODO(), types.NamespacedName{
+		Name:      podRequest.PodName,
+		Namespace: podRequest.PodNamespace,
+	}, pod); err != nil {
+		if !apierrors.IsNotFound(err) {
+			errMsg := fmt.Errorf("failed to get pod %v/%v: %v", podRequest.PodNamespace, podRequest.PodName, err)
+			cdh.errorWrapper(errMsg, apiErrorStatusCode(err), resp)
+			return
+		}
+		podExist = false
+	}
+
+	if podExist && !podRequest.Force {
+		errMsg := fmt.Errorf("pod %v/%v still exists, ips can only be released by force", podRequest.PodNamespace, podRequest.PodName)
+		cdh.errorWrapper(errMsg, http.StatusConflict, resp)
+		return
+	}
+
+	cdh.logger.Info("Release pod ips",
+		"podName", podRequest.PodName,
+		"podNamespace", podRequest.PodNamespace,
+		"force", podRequest.Force,
+	)
+
+	// ip annotation of an existing pod should be removed together with ip instances
+	if podExist && metav1.HasAnnotation(pod.ObjectMeta, constants.AnnotationIP) {
+		if err = cdh.ipamStore.DeCouple(pod); err != nil {
+			errMsg := fmt.Errorf("failed to decouple ips of pod %v/%v: %v", podRequest.PodNamespace, podRequest.PodName, err)
+			cdh.errorWrapper(errMsg, http.StatusServiceUnavailable, resp)
+			return
+		}
+	} else {
+		ipInstanceList := &networkingv1.IPInstanceList{}
+		if err = cdh.mgrClient.List(context.TODO(), ipInstanceList,
+			client.InNamespace(podRequest.PodNamespace),
+			client.MatchingLabels{
+				constants.LabelPod: podRequest.PodName,
+			}); err != nil {
+			errMsg := fmt.Errorf("failed to list ip instance for pod %v/%v: %v", podRequest.PodNamespace, podRequest.PodName, err)
+			cdh.errorWrapper(errMsg, http.StatusServiceUnavailable, resp)
+			return
+		}
+
+		for i := range ipInstanceList.Items {
+			var ipInstance = &ipInstanceList.Items[i]
+			// terminating ip instance is being recycled
+			if ipInstance.DeletionTimestamp != nil {
+				continue
+			}
+
+			if err = cdh.ipamStore.IPRecycle(podRequest.PodNamespace, transform.TransferIPInstanceForIPAM(ipInstance)); client.IgnoreNotFound(err) != nil {
+				errMsg := fmt.Errorf("failed to recycle ip instance %v for pod %v/%v: %v", ipInstance.Name, podRequest.PodNamespace, podRequest.PodName, err)
+				cdh.errorWrapper(errMsg, http.StatusServiceUnavailable, resp)
+				return
+			}
+		}
+	}
+
+	cdh.logger.Info("Pod ips released",
+		"podName", podRequest.PodName,
+		"podNamespace", podRequest.PodNamespace,
+	)
+
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// handleBGPReAdvertise re-announces all the local bgp paths, usually after bgp session flaps
+func (cdh *cniDaemonHandler) handleBGPReAdvertise(req *restful.Request, resp *restful.Response) {
+	if cdh.bgpManager == nil || !cdh.bgpManager.CheckIfStart() {
+		errMsg := fmt.Errorf("bgp manager is not running on node %v", cdh.config.NodeName)
+		cdh.errorWrapper(errMsg, http.StatusConflict, resp)
+		return
+	}
+
+	count, err := cdh.bgpManager.ReAdvertise()
+	if err != nil {
+		errMsg := fmt.Errorf("failed to re-advertise bgp paths: %v", err)
+		cdh.errorWrapper(errMsg, http.StatusInternalServerError, resp)
+		return
+	}
+
+	cdh.logger.Info("BGP paths re-advertised", "count", count)
+
+	_ = resp.WriteHeaderAndEntity(http.StatusOK, request.BGPReAdvertiseResponse{
+		Count: count,
+	})
+}
+
+// handleBGPPeers returns the states of all the bgp peers, every peer has an independent session
+func (cdh *cniDaemonHandler) handleBGPPeers(req *restful.Request, resp *restful.Response) {
+	if cdh.bgpManager == nil || !cdh.bgpManager.CheckIfStart() {
+		errMsg := fmt.Errorf("bgp manager is not running on node %v", cdh.config.NodeName)
+		cdh.errorWrapper(errMsg, http.StatusConflict, resp)
+		return
+	}
+
+	peerStatuses, err := cdh.bgpManager.ListPeerStatuses()
+	if err != nil {
+		errMsg := fmt.Errorf("failed to list bgp peers: %v", err)
+		cdh.errorWrapper(errMsg, http.StatusInternalServerError, resp)
+		return
+	}
+
+	peers := make([]request.BGPPeerStatus, 0, len(peerStatuses))
+	for _, peerStatus := range peerStatuses {
+		peers = append(peers, request.BGPPeerStatus{
+			Address:      peerStatus.Address,
+			ASN:          peerStatus.ASN,
+			State:        peerStatus.State,
+			ExportPolicy: string(peerStatus.ExportPolicy),
+			ImportPolicy: string(peerStatus.ImportPolicy),
+		})
+	}
+
+	_ = resp.WriteHeaderAndEntity(http.StatusOK, request.BGPPeersResponse{
+		Peers: peers,
+	})
+}
+
+// patchHostInterfaceAnnotation sets host interface annotation on pod, empty host interface
+// means the annotation should be removed
+func (cdh *cniDaemonHandler) patchHostInterfaceAnnotation(podName, podNamespace, hostInterface string) error {
+	var patchBody string
+	if len(hostInterface) == 0 {
+		patchBody = fmt.Sprintf(`{"metadata":{"annotations":{%q:null}}}`, constants.AnnotationHostInterface)
+	} else {
+		patchBody = fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, constants.AnnotationHostInterface, hostInterface)
+	}
+
+	return client.IgnoreNotFound(cdh.mgrClient.Patch(context.TODO(),
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: podNamespace}},
+		client.RawPatch(types.MergePatchType, []byte(patchBody)),
+	))
+}
+
+// calicoPodIPsAnnotation returns the value of calico pod ips annotation, and whether it should be
+// patched for pods in network, the global default is used if network does not specify it, ips are
+// in host prefixes as calico does, e.g. "192.168.0.2/32,fd00::2/128"
+func calicoPodIPsAnnotation(network *networkingv1.Network, globalDefault bool, addresses []request.IPAddress) (string, bool) {
+	if !networkingv1.GetNetworkPatchCalicoPodIPsAnnotation(network, globalDefault) || len(addresses) == 0 {
+		return "", false
+	}
+
+	ips := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		ip, _, err := net.ParseCIDR(address.IP)
+		if err != nil {
+			return "", false
+		}
+		if ip.To4() != nil {
+			ips = append(ips, ip.String()+"/32")
+		} else {
+			ips = append(ips, ip.String()+"/128")
+		}
+	}
+	return strings.Join(ips, ","), true
+}
+
+// patchCalicoPodIPsAnnotation sets calico pod ips annotation on pod
+func (cdh *cniDaemonHandler) patchCalicoPodIPsAnnotation(podName, podNamespace, podIPs string) error {
+	patchBody := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, constants.AnnotationCalicoPodIPs, podIPs)
+
+	return client.IgnoreNotFound(cdh.mgrClient.Patch(context.TODO(),
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: podNamespace}},
+		client.RawPatch(types.MergePatchType, []byte(patchBody)),
+	))
+}
+
+// ipReadinessGated checks whether the pod opts in ip readiness gating, both the annotation and
+// the readiness gate declaration are required, the condition is useless without the gate
+func ipReadinessGated(pod *corev1.Pod) bool {
+	if pod.Annotations[constants.AnnotationIPReadinessGate] != "true" {
+		return false
+	}
+	for _, gate := range pod.Spec.ReadinessGates {
+		if gate.ConditionType == constants.PodConditionIPBound {
+			return true
+		}
+	}
+	return false
+}
+
+// patchIPBoundCondition sets the ip bound condition of pod status to true, the other conditions
+// are kept because conditions are merged by type with strategic merge patch
+func (cdh *cniDaemonHandler) patchIPBoundCondition(pod *corev1.Pod, message string) error {
+	patchBody, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []corev1.PodCondition{
+				{
+					Type:               constants.PodConditionIPBound,
+					Status:             corev1.ConditionTrue,
+					Reason:             constants.ReasonIPBound,
+					Message:            message,
+					LastTransitionTime: metav1.Now(),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return cdh.mgrClient.Status().Patch(context.TODO(), pod, client.RawPatch(types.StrategicMergePatchType, patchBody))
+}
+
+// apiErrorStatusCode maps errors from apiserver to response status code, missing objects will
+// never show up by retrying, but other errors are treated as transient
+func apiErrorStatusCode(err error) int {
+	if apierrors.IsNotFound(err) {
+		return http.StatusNotFound
+	}
+	return http.StatusServiceUnavailable
+}
+
 func (cdh *cniDaemonHandler) errorWrapper(err error, status int, resp *restful.Response) {
-	cdh.logger.Error(err, "handler error")
+	cdh.errorWrapperWithReason(err, status, "", resp)
+}
+
+// validateIPInstanceAddress checks the address fields of ip instance which are used to configure nic
+func validateIPInstanceAddress(ipInstance *networkingv1.IPInstance) error {
+	address := ipInstance.Spec.Address
+
+	ip, _, err := net.ParseCIDR(address.IP)
+	if err != nil {
+		return fmt.Errorf("failed to parse ip address %q to cidr: %v", address.IP, err)
+	}
+
+	var isIPv6 bool
+	switch address.Version {
+	case networkingv1.IPv4:
+	case networkingv1.IPv6:
+		isIPv6 = true
+	default:
+		return fmt.Errorf("unsupported ip version %q", address.Version)
+	}
+	if (ip.To4() == nil) != isIPv6 {
+		return fmt.Errorf("ip address %v does not match ip version %v", address.IP, address.Version)
+	}
+
+	// gateway is absent for gateway-less subnets
+	if len(address.Gateway) > 0 {
+		gateway := net.ParseIP(address.Gateway)
+		if gateway == nil {
+			return fmt.Errorf("failed to parse gateway %q", address.Gateway)
+		}
+		if (gateway.To4() == nil) != isIPv6 {
+			return fmt.Errorf("gateway %v does not match ip version %v", address.Gateway, address.Version)
+		}
+	}
+
+	if len(address.MAC) == 0 {
+		return fmt.Errorf("mac address is empty")
+	}
+	if _, err = net.ParseMAC(address.MAC); err != nil {
+		return fmt.Errorf("failed to parse mac address %q: %v", address.MAC, err)
+	}
+
+	if address.NetID == nil {
+		return fmt.Errorf("net id is missing")
+	}
+	return nil
+}
+
+// validateRouteTable refuses the route tables selected by pod if they are managed by daemon
+func (cdh *cniDaemonHandler) validateRouteTable(table int) error {
+	switch {
+	case table == 0:
+		return nil
+	case table == cdh.config.LocalDirectTableNum || table == cdh.config.ToOverlaySubnetTableNum ||
+		table == cdh.config.OverlayMarkTableNum:
+		return fmt.Errorf("route table %d is managed by hybridnet", table)
+	case table >= route.MinRouteTableNum && table <= route.MaxRouteTableNum:
+		return fmt.Errorf("route table %d is in the range of subnet route tables of hybridnet, %d-%d",
+			table, route.MinRouteTableNum, route.MaxRouteTableNum)
+	}
+	return nil
+}
+
+// withCorrelationID returns a handler whose logs carry the correlation id stamped on pod by manager
+func (cdh *cniDaemonHandler) withCorrelationID(pod *corev1.Pod) *cniDaemonHandler {
+	correlationID := pod.Annotations[constants.AnnotationCorrelationID]
+	if len(correlationID) == 0 {
+		return cdh
+	}
+
+	handler := *cdh
+	handler.logger = cdh.logger.WithValues("correlationID", correlationID)
+	return &handler
+}
+
+// errorWrapperWithReason responds error with a machine-parseable reason for cni plugin
+func (cdh *cniDaemonHandler) errorWrapperWithReason(err error, status int, reason string, resp *restful.Response) {
+	cdh.logger.Error(err, "handler error", "reason", reason)
 	_ = resp.WriteHeaderAndEntity(status, request.PodResponse{
-		Err: err.Error(),
+		Err:       err.Error(),
+		ErrReason: reason,
 	})
 }
 
+// classifyUncoupledPod tells why pod is still not coupled with ip after waiting, a permanent
+// allocation failure recorded by manager will not be recovered by retrying sandbox at once,
+// while pending allocation, and exhausted network whose ips may be released later, is worth retrying
+func classifyUncoupledPod(pod *corev1.Pod) (string, int, error) {
+	if failure := pod.Annotations[constants.AnnotationAllocationFailure]; len(failure) > 0 {
+		return request.ErrReasonAllocationFailed, http.StatusUnprocessableEntity,
+			fmt.Errorf("allocation of pod %v/%v failed permanently: %v", pod.Namespace, pod.Name, failure)
+	}
+	if network := pod.Annotations[constants.AnnotationNetworkExhausted]; len(network) > 0 {
+		return request.ErrReasonNetworkExhausted, http.StatusServiceUnavailable,
+			fmt.Errorf("network %v is full, no ip is available for pod %v/%v", network, pod.Namespace, pod.Name)
+	}
+	return request.ErrReasonAllocationPending, http.StatusServiceUnavailable,
+		fmt.Errorf("failed to wait for pod %v/%v be coupled with ip", pod.Name, pod.Namespace)
+}
+
 func printAllocatedIPs(allocatedIPs map[networkingv1.IPVersion]*utils.IPInfo) string {
 	ipAddresseString := ""
 	if allocatedIPs[networkingv1.IPv4] != nil && allocatedIPs[networkingv1.IPv4].Addr != nil {
@@ -323,3 +854,126 @@ func printAllocatedIPs(allocatedIPs map[networkingv1.IPVersion]*utils.IPInfo) st
 
 	return ipAddresseString
 }
+
+// getPod fetches pod from api server, and retries for at most the specified times if pod is
+// not found, because pod of a cni request may be not visible yet right after it is scheduled
+func (cdh *cniDaemonHandler) getPod(podKey types.NamespacedName, retries int) (*corev1.Pod, error) {
+	for i := 0; ; i++ {
+		pod := &corev1.Pod{}
+		err := cdh.mgrAPIReader.Get(context.TODO(), podKey, pod)
+		if err == nil {
+			return pod, nil
+		}
+		if !apierrors.IsNotFound(err) || i >= retries {
+			return nil, err
+		}
+		time.Sleep(cdh.config.PodGetRetryInterval)
+	}
+}
+
+// delegatedInterfaces returns the non-default interfaces expected by pod, only the default
+// interface is configured by add request, and the others are left to cni plugins which
+// resolve ip addresses through ipam path, e.g., chained by multus
+func delegatedInterfaces(pod *corev1.Pod, defaultIfName string) ([]string, error) {
+	ifNames, err := globalutils.ParseInterfaceNames(pod.Annotations[constants.AnnotationInterfaces])
+	if err != nil || len(ifNames) == 0 {
+		return nil, err
+	}
+
+	var (
+		delegated       []string
+		defaultExpected bool
+	)
+	for _, ifName := range ifNames {
+		if ifName == defaultIfName {
+			defaultExpected = true
+			continue
+		}
+		delegated = append(delegated, ifName)
+	}
+
+	if !defaultExpected {
+		return nil, fmt.Errorf("default interface %v is not in expected interfaces %v", defaultIfName, ifNames)
+	}
+	return delegated, nil
+}
+
+// podCoupled returns whether pod has been coupled with ip instances, ip instances of externally
+// addressed pod are created by external controller without ip annotation on pod
+func (cdh *cniDaemonHandler) podCoupled(pod *corev1.Pod) (bool, error) {
+	if metav1.HasAnnotation(pod.ObjectMeta, constants.AnnotationIP) {
+		return true, nil
+	}
+
+	if !globalutils.ParseBoolOrDefault(pod.Annotations[constants.AnnotationExternalIP], false) {
+		return false, nil
+	}
+
+	ipInstanceList := &networkingv1.IPInstanceList{}
+	if err := cdh.mgrClient.List(context.TODO(), ipInstanceList,
+		client.InNamespace(pod.Namespace),
+		client.MatchingLabels{
+			constants.LabelNode: cdh.config.NodeName,
+			constants.LabelPod:  pod.Name,
+		}); err != nil {
+		return false, err
+	}
+
+	for i := range ipInstanceList.Items {
+		if ipInstanceList.Items[i].DeletionTimestamp == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// loopbackIPsOf returns the loopback ips of pod on current node if they are asked by annotation, the
+// add request fails to be retried if loopback ips have not been allocated yet
+func (cdh *cniDaemonHandler) loopbackIPsOf(pod *corev1.Pod) ([]*net.IPNet, error) {
+	if len(pod.Annotations[constants.AnnotationLoopbackSubnet]) == 0 {
+		return nil, nil
+	}
+
+	ipInstanceList := &networkingv1.IPInstanceList{}
+	if err := cdh.mgrClient.List(context.TODO(), ipInstanceList,
+		client.InNamespace(pod.Namespace),
+		client.MatchingLabels{
+			constants.LabelNode:        cdh.config.NodeName,
+			constants.LabelLoopbackPod: pod.Name,
+		}); err != nil {
+		return nil, err
+	}
+
+	loopbackIPs, err := loopbackIPNets(pod, ipInstanceList.Items)
+	if err != nil {
+		return nil, err
+	}
+	if len(loopbackIPs) == 0 {
+		return nil, fmt.Errorf("loopback ip of pod %v/%v is not allocated yet", pod.Namespace, pod.Name)
+	}
+	return loopbackIPs, nil
+}
+
+// loopbackIPNets converts the loopback ip instances controlled by pod into host prefixes, ip instances
+// left by a former pod with the same name are ignored
+func loopbackIPNets(pod *corev1.Pod, ipInstances []networkingv1.IPInstance) ([]*net.IPNet, error) {
+	var loopbackIPs []*net.IPNet
+	for i := range ipInstances {
+		var ipInstance = &ipInstances[i]
+		if ipInstance.DeletionTimestamp != nil || !metav1.IsControlledBy(ipInstance, pod) {
+			continue
+		}
+
+		loopbackIP, _, err := net.ParseCIDR(ipInstance.Spec.Address.IP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse loopback ip address %v: %v", ipInstance.Spec.Address.IP, err)
+		}
+
+		if loopbackIP.To4() != nil {
+			loopbackIPs = append(loopbackIPs, &net.IPNet{IP: loopbackIP.To4(), Mask: net.CIDRMask(32, 32)})
+		} else {
+			loopbackIPs = append(loopbackIPs, &net.IPNet{IP: loopbackIP, Mask: net.CIDRMask(128, 128)})
+		}
+	}
+	return loopbackIPs, nil
+}
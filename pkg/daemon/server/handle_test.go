@@ -0,0 +1,685 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	daemonconfig "github.com/alibaba/hybridnet/pkg/daemon/config"
+	"github.com/alibaba/hybridnet/pkg/ipam/store"
+	"github.com/alibaba/hybridnet/pkg/request"
+)
+
+// flakyPodReader returns the specified error for the first failures gets
+type flakyPodReader struct {
+	client.Reader
+	failures int
+	err      error
+	gets     int
+}
+
+func (f *flakyPodReader) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	f.gets++
+	if f.gets <= f.failures {
+		return f.err
+	}
+	obj.(*corev1.Pod).Name = key.Name
+	return nil
+}
+
+func TestGetPod(t *testing.T) {
+	notFound := apierrors.NewNotFound(corev1.Resource("pods"), "pod")
+	tests := []struct {
+		name     string
+		failures int
+		err      error
+		retries  int
+		wantErr  bool
+		wantGets int
+	}{
+		{
+			"found at once",
+			0,
+			nil,
+			3,
+			false,
+			1,
+		},
+		{
+			"found after retries",
+			2,
+			notFound,
+			3,
+			false,
+			3,
+		},
+		{
+			"not found after all retries",
+			5,
+			notFound,
+			3,
+			true,
+			4,
+		},
+		{
+			"not found without retries",
+			1,
+			notFound,
+			0,
+			true,
+			1,
+		},
+		{
+			"other errors are not retried",
+			1,
+			fmt.Errorf("connection refused"),
+			3,
+			true,
+			1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := &flakyPodReader{failures: test.failures, err: test.err}
+			cdh := &cniDaemonHandler{
+				config:       &daemonconfig.Configuration{},
+				mgrAPIReader: reader,
+			}
+
+			pod, err := cdh.getPod(types.NamespacedName{Name: "pod", Namespace: "ns"}, test.retries)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !test.wantErr && pod.Name != "pod" {
+				t.Errorf("unexpected pod %v", pod.Name)
+			}
+			if reader.gets != test.wantGets {
+				t.Errorf("expect %d gets, got %d", test.wantGets, reader.gets)
+			}
+		})
+	}
+}
+
+func TestDelegatedInterfaces(t *testing.T) {
+	tests := []struct {
+		name       string
+		interfaces string
+		// default interface name of network, constants.ContainerNicName if empty
+		defaultIfName string
+		expected      []string
+		wantErr       bool
+	}{
+		{
+			"single nic pod",
+			"",
+			"",
+			nil,
+			false,
+		},
+		{
+			"only default interface",
+			"eth0",
+			"",
+			nil,
+			false,
+		},
+		{
+			"default interface with a second interface",
+			"eth0,net1",
+			"",
+			[]string{"net1"},
+			false,
+		},
+		{
+			"default interface is not the first",
+			"net1, eth0, net2",
+			"",
+			[]string{"net1", "net2"},
+			false,
+		},
+		{
+			"default interface is not expected",
+			"net1",
+			"",
+			nil,
+			true,
+		},
+		{
+			"duplicated interfaces",
+			"eth0,net1,net1",
+			"",
+			nil,
+			true,
+		},
+		{
+			"invalid interface name",
+			"eth0,net/1",
+			"",
+			nil,
+			true,
+		},
+		{
+			"customized default interface",
+			"net0,eth0",
+			"net0",
+			[]string{"eth0"},
+			false,
+		},
+		{
+			"customized default interface is not expected",
+			"eth0,net1",
+			"net0",
+			nil,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "pod",
+					Namespace: "ns",
+				},
+			}
+			if len(test.interfaces) > 0 {
+				pod.Annotations = map[string]string{
+					constants.AnnotationInterfaces: test.interfaces,
+				}
+			}
+
+			defaultIfName := test.defaultIfName
+			if len(defaultIfName) == 0 {
+				defaultIfName = constants.ContainerNicName
+			}
+
+			delegated, err := delegatedInterfaces(pod, defaultIfName)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(delegated, test.expected) {
+				t.Errorf("expect delegated interfaces %v, got %v", test.expected, delegated)
+			}
+		})
+	}
+}
+
+func TestClassifyUncoupledPod(t *testing.T) {
+	tests := []struct {
+		name           string
+		annotations    map[string]string
+		expectedReason string
+		expectedStatus int
+	}{
+		{
+			"allocation pending",
+			nil,
+			request.ErrReasonAllocationPending,
+			http.StatusServiceUnavailable,
+		},
+		{
+			"allocation failed",
+			map[string]string{
+				constants.AnnotationAllocationFailure: "no available ip in ip-pool",
+			},
+			request.ErrReasonAllocationFailed,
+			http.StatusUnprocessableEntity,
+		},
+		{
+			"network exhausted",
+			map[string]string{
+				constants.AnnotationNetworkExhausted: "network1",
+			},
+			request.ErrReasonNetworkExhausted,
+			http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "pod",
+					Namespace:   "ns",
+					Annotations: test.annotations,
+				},
+			}
+
+			reason, status, err := classifyUncoupledPod(pod)
+			if err == nil {
+				t.Fatalf("expect error but got nil")
+			}
+			if reason != test.expectedReason || status != test.expectedStatus {
+				t.Errorf("expect %s/%d, got %s/%d", test.expectedReason, test.expectedStatus, reason, status)
+			}
+		})
+	}
+}
+
+func TestIPReadinessGated(t *testing.T) {
+	gate := []corev1.PodReadinessGate{{ConditionType: constants.PodConditionIPBound}}
+	optIn := map[string]string{constants.AnnotationIPReadinessGate: "true"}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		gates       []corev1.PodReadinessGate
+		expected    bool
+	}{
+		{"not opted in", nil, nil, false},
+		{"annotation only", optIn, nil, false},
+		{"readiness gate only", nil, gate, false},
+		{"annotation and readiness gate", optIn, gate, true},
+		{"other readiness gate", optIn, []corev1.PodReadinessGate{{ConditionType: "example.com/Ready"}}, false},
+		{"annotation disabled", map[string]string{constants.AnnotationIPReadinessGate: "false"}, gate, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: test.annotations},
+				Spec:       corev1.PodSpec{ReadinessGates: test.gates},
+			}
+			if gated := ipReadinessGated(pod); gated != test.expected {
+				t.Errorf("expect %v, got %v", test.expected, gated)
+			}
+		})
+	}
+}
+
+func TestCalicoPodIPsAnnotation(t *testing.T) {
+	enabled, disabled := true, false
+	network := func(patch *bool) *networkingv1.Network {
+		return &networkingv1.Network{Spec: networkingv1.NetworkSpec{PatchCalicoPodIPsAnnotation: patch}}
+	}
+	dualStack := []request.IPAddress{{IP: "192.168.0.2/24"}, {IP: "fd00::2/64"}}
+
+	// networks on the same node interoperate with calico or not
+	tests := []struct {
+		name          string
+		network       *networkingv1.Network
+		globalDefault bool
+		addresses     []request.IPAddress
+		expected      string
+		expectedPatch bool
+	}{
+		{"enabled by network", network(&enabled), false, dualStack, "192.168.0.2/32,fd00::2/128", true},
+		{"disabled by network", network(&disabled), true, dualStack, "", false},
+		{"fallback to enabled default", network(nil), true, dualStack[:1], "192.168.0.2/32", true},
+		{"fallback to disabled default", network(nil), false, dualStack, "", false},
+		{"no addresses", network(&enabled), false, nil, "", false},
+		{"invalid address", network(&enabled), false, []request.IPAddress{{IP: "192.168.0.2"}}, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			podIPs, patch := calicoPodIPsAnnotation(test.network, test.globalDefault, test.addresses)
+			if podIPs != test.expected || patch != test.expectedPatch {
+				t.Errorf("expect %q/%v, got %q/%v", test.expected, test.expectedPatch, podIPs, patch)
+			}
+		})
+	}
+}
+
+func TestLoopbackIPNets(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", UID: "current"}}
+	loopbackIPInstance := func(ip string, uid types.UID, deleting bool) networkingv1.IPInstance {
+		controller := true
+		ipInstance := networkingv1.IPInstance{
+			ObjectMeta: metav1.ObjectMeta{
+				OwnerReferences: []metav1.OwnerReference{{Kind: "Pod", Name: "pod", UID: uid, Controller: &controller}},
+			},
+			Spec: networkingv1.IPInstanceSpec{Address: networkingv1.Address{IP: ip}},
+		}
+		if deleting {
+			now := metav1.Now()
+			ipInstance.DeletionTimestamp = &now
+		}
+		return ipInstance
+	}
+
+	tests := []struct {
+		name        string
+		ipInstances []networkingv1.IPInstance
+		expected    []string
+		expectedErr bool
+	}{
+		{"no ip instance", nil, nil, false},
+		{"ipv4", []networkingv1.IPInstance{loopbackIPInstance("10.0.0.5/24", "current", false)}, []string{"10.0.0.5/32"}, false},
+		{"dual stack", []networkingv1.IPInstance{
+			loopbackIPInstance("10.0.0.5/24", "current", false),
+			loopbackIPInstance("fd00::5/64", "current", false),
+		}, []string{"10.0.0.5/32", "fd00::5/128"}, false},
+		{"former pod", []networkingv1.IPInstance{loopbackIPInstance("10.0.0.5/24", "former", false)}, nil, false},
+		{"deleting", []networkingv1.IPInstance{loopbackIPInstance("10.0.0.5/24", "current", true)}, nil, false},
+		{"invalid ip", []networkingv1.IPInstance{loopbackIPInstance("10.0.0.5", "current", false)}, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			loopbackIPs, err := loopbackIPNets(pod, test.ipInstances)
+			if (err != nil) != test.expectedErr {
+				t.Fatalf("expect error %v, got %v", test.expectedErr, err)
+			}
+			var actual []string
+			for _, loopbackIP := range loopbackIPs {
+				actual = append(actual, loopbackIP.String())
+			}
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expect %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestValidateRouteTable(t *testing.T) {
+	cdh := &cniDaemonHandler{config: &daemonconfig.Configuration{
+		LocalDirectTableNum:     daemonconfig.DefaultLocalDirectTableNum,
+		ToOverlaySubnetTableNum: daemonconfig.DefaultToOverlaySubnetTableNum,
+		OverlayMarkTableNum:     daemonconfig.DefaultOverlayMarkTableNum,
+	}}
+
+	tests := []struct {
+		name        string
+		table       int
+		expectedErr bool
+	}{
+		{"not specified", 0, false},
+		{"user table", 100, false},
+		{"user table above daemon tables", 50000, false},
+		{"local direct table", daemonconfig.DefaultLocalDirectTableNum, true},
+		{"overlay mark table", daemonconfig.DefaultOverlayMarkTableNum, true},
+		{"subnet route table", 10001, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := cdh.validateRouteTable(test.table); (err != nil) != test.expectedErr {
+				t.Errorf("expect error %v, got %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateIPInstanceAddress(t *testing.T) {
+	netID := int32(100)
+	ipInstance := func(version networkingv1.IPVersion, ip, gateway, mac string, netID *int32) *networkingv1.IPInstance {
+		return &networkingv1.IPInstance{
+			Spec: networkingv1.IPInstanceSpec{
+				Address: networkingv1.Address{
+					Version: version,
+					IP:      ip,
+					Gateway: gateway,
+					MAC:     mac,
+					NetID:   netID,
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		ipInstance  *networkingv1.IPInstance
+		expectedErr bool
+	}{
+		{"valid ipv4", ipInstance(networkingv1.IPv4, "192.168.0.10/24", "192.168.0.1", "02:00:00:00:00:01", &netID), false},
+		{"valid ipv6", ipInstance(networkingv1.IPv6, "fd00::10/64", "fd00::1", "02:00:00:00:00:01", &netID), false},
+		{"gateway-less", ipInstance(networkingv1.IPv4, "192.168.0.10/24", "", "02:00:00:00:00:01", &netID), false},
+		{"empty mac", ipInstance(networkingv1.IPv4, "192.168.0.10/24", "192.168.0.1", "", &netID), true},
+		{"bad mac", ipInstance(networkingv1.IPv4, "192.168.0.10/24", "192.168.0.1", "02:00:00", &netID), true},
+		{"bad cidr", ipInstance(networkingv1.IPv4, "192.168.0.10", "192.168.0.1", "02:00:00:00:00:01", &netID), true},
+		{"bad gateway", ipInstance(networkingv1.IPv4, "192.168.0.10/24", "192.168.0.256", "02:00:00:00:00:01", &netID), true},
+		{"gateway of another family", ipInstance(networkingv1.IPv4, "192.168.0.10/24", "fd00::1", "02:00:00:00:00:01", &netID), true},
+		{"ip of another family", ipInstance(networkingv1.IPv6, "192.168.0.10/24", "", "02:00:00:00:00:01", &netID), true},
+		{"unknown version", ipInstance("IPv5", "192.168.0.10/24", "", "02:00:00:00:00:01", &netID), true},
+		{"missing net id", ipInstance(networkingv1.IPv4, "192.168.0.10/24", "192.168.0.1", "02:00:00:00:00:01", nil), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validateIPInstanceAddress(test.ipInstance); (err != nil) != test.expectedErr {
+				t.Errorf("expect error %v, got %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+// releasePodReader returns the pod if exists, or the specified error
+type releasePodReader struct {
+	client.Reader
+	pod *corev1.Pod
+	err error
+}
+
+func (r *releasePodReader) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.pod == nil {
+		return apierrors.NewNotFound(schema.GroupResource{}, key.Name)
+	}
+	r.pod.DeepCopyInto(obj.(*corev1.Pod))
+	return nil
+}
+
+// releaseClient lists the ip instances and records the deleted ones and patched pods
+type releaseClient struct {
+	client.Client
+	ipInstances []networkingv1.IPInstance
+	deleteErr   error
+	deleted     []string
+	patchedPods int
+}
+
+func (c *releaseClient) List(_ context.Context, list client.ObjectList, _ ...client.ListOption) error {
+	list.(*networkingv1.IPInstanceList).Items = c.ipInstances
+	return nil
+}
+
+func (c *releaseClient) Delete(_ context.Context, obj client.Object, _ ...client.DeleteOption) error {
+	if c.deleteErr != nil {
+		return c.deleteErr
+	}
+	c.deleted = append(c.deleted, obj.GetName())
+	return nil
+}
+
+func (c *releaseClient) Patch(_ context.Context, obj client.Object, _ client.Patch, _ ...client.PatchOption) error {
+	if _, ok := obj.(*corev1.Pod); ok {
+		c.patchedPods++
+	}
+	return nil
+}
+
+func (c *releaseClient) Status() client.StatusWriter {
+	return &releaseStatusWriter{}
+}
+
+type releaseStatusWriter struct {
+	client.StatusWriter
+}
+
+func (w *releaseStatusWriter) Patch(_ context.Context, _ client.Object, _ client.Patch, _ ...client.PatchOption) error {
+	return nil
+}
+
+func TestHandleRelease(t *testing.T) {
+	ipInstance := func(ip string, terminating bool) networkingv1.IPInstance {
+		instance := newTestIPInstance("network1", ip+"/24", networkingv1.IPv4)
+		instance.Name = strings.ReplaceAll(ip, ".", "-")
+		instance.Namespace = "ns"
+		if terminating {
+			now := metav1.Now()
+			instance.DeletionTimestamp = &now
+		}
+		return *instance
+	}
+
+	existingPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod",
+			Namespace: "ns",
+			Annotations: map[string]string{
+				constants.AnnotationIP: `{"ip":"192.168.0.2/24"}`,
+			},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		body           string
+		pod            *corev1.Pod
+		getErr         error
+		deleteErr      error
+		ipInstances    []networkingv1.IPInstance
+		expectedStatus int
+		expectedDelete []string
+		expectedPatch  int
+	}{
+		{
+			"invalid request",
+			`{"pod_name":`,
+			nil,
+			nil,
+			nil,
+			nil,
+			http.StatusBadRequest,
+			nil,
+			0,
+		},
+		{
+			"fail to get pod",
+			`{"pod_name":"pod","pod_namespace":"ns"}`,
+			nil,
+			fmt.Errorf("connection refused"),
+			nil,
+			nil,
+			http.StatusServiceUnavailable,
+			nil,
+			0,
+		},
+		{
+			"existing pod without force",
+			`{"pod_name":"pod","pod_namespace":"ns"}`,
+			existingPod,
+			nil,
+			nil,
+			[]networkingv1.IPInstance{ipInstance("192.168.0.2", false)},
+			http.StatusConflict,
+			nil,
+			0,
+		},
+		{
+			"existing pod by force",
+			`{"pod_name":"pod","pod_namespace":"ns","force":true}`,
+			existingPod,
+			nil,
+			nil,
+			[]networkingv1.IPInstance{ipInstance("192.168.0.2", false)},
+			http.StatusNoContent,
+			[]string{"192-168-0-2"},
+			1,
+		},
+		{
+			"deleted pod skips terminating ip instances",
+			`{"pod_name":"pod","pod_namespace":"ns"}`,
+			nil,
+			nil,
+			nil,
+			[]networkingv1.IPInstance{ipInstance("192.168.0.2", false), ipInstance("192.168.0.3", true),
+				ipInstance("192.168.0.4", false)},
+			http.StatusNoContent,
+			[]string{"192-168-0-2", "192-168-0-4"},
+			0,
+		},
+		{
+			"recycled ip instance not found",
+			`{"pod_name":"pod","pod_namespace":"ns"}`,
+			nil,
+			nil,
+			apierrors.NewNotFound(schema.GroupResource{}, "192-168-0-2"),
+			[]networkingv1.IPInstance{ipInstance("192.168.0.2", false)},
+			http.StatusNoContent,
+			nil,
+			0,
+		},
+		{
+			"fail to recycle ip instance",
+			`{"pod_name":"pod","pod_namespace":"ns"}`,
+			nil,
+			nil,
+			fmt.Errorf("connection refused"),
+			[]networkingv1.IPInstance{ipInstance("192.168.0.2", false)},
+			http.StatusServiceUnavailable,
+			nil,
+			0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &releaseClient{
+				ipInstances: test.ipInstances,
+				deleteErr:   test.deleteErr,
+			}
+			cdh := &cniDaemonHandler{
+				mgrClient:    c,
+				mgrAPIReader: &releasePodReader{pod: test.pod, err: test.getErr},
+				ipamStore:    store.NewWorker(c),
+				logger:       logr.Discard(),
+			}
+
+			httpReq := httptest.NewRequest(http.MethodPost, "/api/v1/release", strings.NewReader(test.body))
+			httpReq.Header.Set(restful.HEADER_ContentType, restful.MIME_JSON)
+			recorder := httptest.NewRecorder()
+			resp := restful.NewResponse(recorder)
+			resp.SetRequestAccepts(restful.MIME_JSON)
+
+			cdh.handleRelease(restful.NewRequest(httpReq), resp)
+
+			if recorder.Code != test.expectedStatus {
+				t.Errorf("expect status %d, got %d: %s", test.expectedStatus, recorder.Code, recorder.Body.String())
+			}
+			sort.Strings(c.deleted)
+			if !reflect.DeepEqual(c.deleted, test.expectedDelete) {
+				t.Errorf("expect deleted ip instances %v, got %v", test.expectedDelete, c.deleted)
+			}
+			if c.patchedPods != test.expectedPatch {
+				t.Errorf("expect pod patched %d times, got %d", test.expectedPatch, c.patchedPods)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/ipam/store"
+	"github.com/alibaba/hybridnet/pkg/request"
+)
+
+// handleIPAMAddMulti resolves ip addresses for multiple interfaces in one request. All the pods
+// are waited to be coupled with ip in a single backoff loop, and ip instances on the node are
+// listed only once. IP instances are allocated for pod, so interfaces of the same pod will be
+// resolved with the same addresses.
+func (cdh *cniDaemonHandler) handleIPAMAddMulti(req *restful.Request, resp *restful.Response) {
+	multiRequest := request.IPAMMultiRequest{}
+	err := req.ReadEntity(&multiRequest)
+	if err != nil {
+		errMsg := fmt.Errorf("failed to parse ipam add multi request: %v", err)
+		cdh.errorWrapper(errMsg, http.StatusBadRequest, resp)
+		return
+	}
+	cdh.logger.V(5).Info("handle ipam add multi request", "content", multiRequest)
+
+	// pod errors will be returned by all the interfaces of pod
+	var podErrors = map[types.NamespacedName]error{}
+	// pending pods are mapped to their latest fetched objects
+	var pendingPods = map[types.NamespacedName]*corev1.Pod{}
+	for _, ipamRequest := range multiRequest.Requests {
+		pendingPods[types.NamespacedName{Name: ipamRequest.PodName, Namespace: ipamRequest.PodNamespace}] = nil
+	}
+
+	backOffBase := 5 * time.Microsecond
+	retries := 11
+
+	for i := 0; i < retries && len(pendingPods) > 0; i++ {
+		time.Sleep(backOffBase)
+		backOffBase = backOffBase * 2
+
+		// only the initial fetch will be retried if pod is not found
+		podGetRetries := 0
+		if i == 0 {
+			podGetRetries = cdh.config.PodGetRetries
+		}
+
+		for podKey := range pendingPods {
+			pod, err := cdh.getPod(podKey, podGetRetries)
+			if err != nil {
+				podErrors[podKey] = fmt.Errorf("failed to get pod %v/%v: %v", podKey.Namespace, podKey.Name, err)
+				delete(pendingPods, podKey)
+				continue
+			}
+
+			// wait for ip instance to be coupled
+			coupled, err := cdh.podCoupled(pod)
+			if err != nil {
+				podErrors[podKey] = fmt.Errorf("failed to check ip instances of pod %v/%v: %v", podKey.Namespace, podKey.Name, err)
+				delete(pendingPods, podKey)
+				continue
+			}
+			if coupled {
+				delete(pendingPods, podKey)
+			} else {
+				pendingPods[podKey] = pod
+			}
+		}
+	}
+
+	for podKey, pod := range pendingPods {
+		if pod == nil {
+			podErrors[podKey] = fmt.Errorf("failed to wait for pod %v/%v be coupled with ip", podKey.Namespace, podKey.Name)
+			continue
+		}
+		_, _, podErrors[podKey] = classifyUncoupledPod(pod)
+	}
+
+	ipInstanceList := &networkingv1.IPInstanceList{}
+	if err = cdh.mgrClient.List(context.TODO(), ipInstanceList, client.MatchingLabels{
+		constants.LabelNode: cdh.config.NodeName,
+	}); err != nil {
+		errMsg := fmt.Errorf("failed to list ip instance for node %v: %v", cdh.config.NodeName, err)
+		cdh.errorWrapper(errMsg, http.StatusServiceUnavailable, resp)
+		return
+	}
+
+	var podIPInstances = map[types.NamespacedName][]*networkingv1.IPInstance{}
+	for i := range ipInstanceList.Items {
+		var ipInstance = &ipInstanceList.Items[i]
+		// reserved ip is not coupled with any pod
+		if ipInstance.Status.Phase == networkingv1.IPPhaseReserved {
+			continue
+		}
+		podKey := types.NamespacedName{Name: ipInstance.Status.PodName, Namespace: ipInstance.Status.PodNamespace}
+		podIPInstances[podKey] = append(podIPInstances[podKey], ipInstance)
+	}
+
+	var podAddresses = map[types.NamespacedName][]request.IPAddress{}
+	var results = make([]request.IPAMResult, len(multiRequest.Requests))
+	for i, ipamRequest := range multiRequest.Requests {
+		podKey := types.NamespacedName{Name: ipamRequest.PodName, Namespace: ipamRequest.PodNamespace}
+		results[i].IfName = ipamRequest.IfName
+
+		if _, resolved := podAddresses[podKey]; !resolved && podErrors[podKey] == nil {
+			var preferIPv6 bool
+			if preferIPv6, err = cdh.preferIPv6Of(podIPInstances[podKey]); err != nil {
+				podErrors[podKey] = err
+			} else if podAddresses[podKey], err = resolveIPAddresses(podKey, podIPInstances[podKey], preferIPv6); err != nil {
+				podErrors[podKey] = err
+			}
+		}
+
+		if podErrors[podKey] != nil {
+			results[i].Err = podErrors[podKey].Error()
+			cdh.logger.Error(podErrors[podKey], "failed to resolve ip addresses",
+				"podName", ipamRequest.PodName,
+				"podNamespace", ipamRequest.PodNamespace,
+				"ifName", ipamRequest.IfName)
+			continue
+		}
+		results[i].IPAddress = podAddresses[podKey]
+	}
+
+	_ = resp.WriteHeaderAndEntity(http.StatusOK, request.IPAMMultiResponse{
+		Results: results,
+	})
+}
+
+// preferIPv6Of checks whether ipv6 address is the primary one of pod by the network of its ip instances,
+// the global preference of daemon is used if network does not specify it
+func (cdh *cniDaemonHandler) preferIPv6Of(ipInstances []*networkingv1.IPInstance) (bool, error) {
+	if len(ipInstances) == 0 {
+		return cdh.config.PreferIPv6Address, nil
+	}
+
+	network := &networkingv1.Network{}
+	if err := cdh.mgrClient.Get(context.TODO(), types.NamespacedName{Name: ipInstances[0].Spec.Network}, network); err != nil {
+		return false, fmt.Errorf("cannot get network %v: %v", ipInstances[0].Spec.Network, err)
+	}
+	return networkingv1.GetNetworkPrimaryIPVersion(network, cdh.config.PreferIPv6Address) == networkingv1.IPv6, nil
+}
+
+// resolveIPAddresses returns the ip addresses of pod, at most one ipv4 address and one ipv6
+// address from the same network are expected.
+func resolveIPAddresses(podKey types.NamespacedName, ipInstances []*networkingv1.IPInstance, preferIPv6 bool) ([]request.IPAddress, error) {
+	var (
+		addresses   []request.IPAddress
+		networkName string
+		versions    = map[networkingv1.IPVersion]bool{}
+	)
+
+	if err := store.CheckIPInstanceLimit(podKey.Namespace, podKey.Name, len(ipInstances)); err != nil {
+		return nil, err
+	}
+
+	for _, ipInstance := range ipInstances {
+		switch ipInstance.Spec.Address.Version {
+		case networkingv1.IPv4, networkingv1.IPv6:
+		default:
+			return nil, fmt.Errorf("unsupported ip version %v for pod %v/%v", ipInstance.Spec.Address.Version, podKey.Namespace, podKey.Name)
+		}
+
+		if versions[ipInstance.Spec.Address.Version] {
+			return nil, fmt.Errorf("only one ipv%v address for each pod are supported, %v/%v", ipInstance.Spec.Address.Version, podKey.Namespace, podKey.Name)
+		}
+		versions[ipInstance.Spec.Address.Version] = true
+
+		if len(networkName) == 0 {
+			networkName = ipInstance.Spec.Network
+		} else if networkName != ipInstance.Spec.Network {
+			return nil, fmt.Errorf("found different networks %v/%v for pod %v/%v", ipInstance.Spec.Network, networkName, podKey.Namespace, podKey.Name)
+		}
+
+		addresses = append(addresses, request.IPAddress{
+			IP:       ipInstance.Spec.Address.IP,
+			Mac:      ipInstance.Spec.Address.MAC,
+			Gateway:  ipInstance.Spec.Address.Gateway,
+			Protocol: ipInstance.Spec.Address.Version,
+			NetID:    ipInstance.Spec.Address.NetID,
+		})
+	}
+
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no available ip for pod %s/%s", podKey.Namespace, podKey.Name)
+	}
+
+	sortIPAddresses(addresses, preferIPv6)
+	return addresses, nil
+}
+
+// sortIPAddresses sorts addresses in place to make the order independent of ip instance
+// listing, the first address of preferred ip version will be treated as primary by cni chains
+func sortIPAddresses(addresses []request.IPAddress, preferIPv6 bool) {
+	preferredVersion := networkingv1.IPv4
+	if preferIPv6 {
+		preferredVersion = networkingv1.IPv6
+	}
+
+	sort.SliceStable(addresses, func(i, j int) bool {
+		if addresses[i].Protocol != addresses[j].Protocol {
+			return addresses[i].Protocol == preferredVersion
+		}
+		return addresses[i].IP < addresses[j].IP
+	})
+}
@@ -0,0 +1,216 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/ipam/store"
+	"github.com/alibaba/hybridnet/pkg/request"
+)
+
+func newTestIPInstance(network, ip string, version networkingv1.IPVersion) *networkingv1.IPInstance {
+	return &networkingv1.IPInstance{
+		Spec: networkingv1.IPInstanceSpec{
+			Network: network,
+			Address: networkingv1.Address{
+				IP:      ip,
+				Version: version,
+			},
+		},
+	}
+}
+
+func TestResolveIPAddresses(t *testing.T) {
+	podKey := types.NamespacedName{Name: "pod", Namespace: "ns"}
+
+	tests := []struct {
+		name          string
+		ipInstances   []*networkingv1.IPInstance
+		expectedCount int
+		expectedError bool
+	}{
+		{
+			"single stack",
+			[]*networkingv1.IPInstance{
+				newTestIPInstance("net1", "192.168.0.2/24", networkingv1.IPv4),
+			},
+			1,
+			false,
+		},
+		{
+			"dual stack",
+			[]*networkingv1.IPInstance{
+				newTestIPInstance("net1", "192.168.0.2/24", networkingv1.IPv4),
+				newTestIPInstance("net1", "fe80::2/64", networkingv1.IPv6),
+			},
+			2,
+			false,
+		},
+		{
+			"no ip instance",
+			nil,
+			0,
+			true,
+		},
+		{
+			"duplicated ip version",
+			[]*networkingv1.IPInstance{
+				newTestIPInstance("net1", "192.168.0.2/24", networkingv1.IPv4),
+				newTestIPInstance("net1", "192.168.0.3/24", networkingv1.IPv4),
+			},
+			0,
+			true,
+		},
+		{
+			"different networks",
+			[]*networkingv1.IPInstance{
+				newTestIPInstance("net1", "192.168.0.2/24", networkingv1.IPv4),
+				newTestIPInstance("net2", "fe80::2/64", networkingv1.IPv6),
+			},
+			0,
+			true,
+		},
+		{
+			"unsupported ip version",
+			[]*networkingv1.IPInstance{
+				newTestIPInstance("net1", "192.168.0.2/24", networkingv1.IPVersion("5")),
+			},
+			0,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addresses, err := resolveIPAddresses(podKey, test.ipInstances, false)
+			if (err != nil) != test.expectedError {
+				t.Fatalf("test %s fails: expected error %v but got %v", test.name, test.expectedError, err)
+			}
+			if len(addresses) != test.expectedCount {
+				t.Errorf("test %s fails: expected %d addresses but got %d", test.name, test.expectedCount, len(addresses))
+			}
+		})
+	}
+}
+
+func TestResolveIPAddressesWithLimit(t *testing.T) {
+	podKey := types.NamespacedName{Name: "pod", Namespace: "ns"}
+	ipInstances := []*networkingv1.IPInstance{
+		newTestIPInstance("net1", "192.168.0.2/24", networkingv1.IPv4),
+		newTestIPInstance("net1", "fe80::2/64", networkingv1.IPv6),
+	}
+
+	defer func(limit int) {
+		store.MaxIPInstancesPerPod = limit
+	}(store.MaxIPInstancesPerPod)
+
+	store.MaxIPInstancesPerPod = 1
+	if _, err := resolveIPAddresses(podKey, ipInstances, false); err == nil {
+		t.Errorf("expected error of exceeding limit but got nil")
+	}
+
+	store.MaxIPInstancesPerPod = 2
+	if _, err := resolveIPAddresses(podKey, ipInstances, false); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestResolveIPAddressesWithNetID(t *testing.T) {
+	podKey := types.NamespacedName{Name: "pod", Namespace: "ns"}
+
+	var netID int32 = 100
+	withNetID := newTestIPInstance("net1", "192.168.0.2/24", networkingv1.IPv4)
+	withNetID.Spec.Address.NetID = &netID
+
+	addresses, err := resolveIPAddresses(podKey, []*networkingv1.IPInstance{
+		withNetID,
+		newTestIPInstance("net1", "fe80::2/64", networkingv1.IPv6),
+	}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(addresses) != 2 {
+		t.Fatalf("expected 2 addresses but got %d", len(addresses))
+	}
+	if addresses[0].NetID == nil || *addresses[0].NetID != netID {
+		t.Errorf("expected net id %d of ipv4 address but got %v", netID, addresses[0].NetID)
+	}
+	if addresses[1].NetID != nil {
+		t.Errorf("expected no net id of ipv6 address but got %d", *addresses[1].NetID)
+	}
+}
+
+func TestSortIPAddresses(t *testing.T) {
+	v4 := request.IPAddress{IP: "192.168.0.2/24", Protocol: networkingv1.IPv4}
+	v6 := request.IPAddress{IP: "fe80::2/64", Protocol: networkingv1.IPv6}
+
+	tests := []struct {
+		name       string
+		addresses  []request.IPAddress
+		preferIPv6 bool
+		expected   []request.IPAddress
+	}{
+		{
+			"ipv4 first in order",
+			[]request.IPAddress{v4, v6},
+			false,
+			[]request.IPAddress{v4, v6},
+		},
+		{
+			"ipv4 first in reverse order",
+			[]request.IPAddress{v6, v4},
+			false,
+			[]request.IPAddress{v4, v6},
+		},
+		{
+			"ipv6 first in order",
+			[]request.IPAddress{v6, v4},
+			true,
+			[]request.IPAddress{v6, v4},
+		},
+		{
+			"ipv6 first in reverse order",
+			[]request.IPAddress{v4, v6},
+			true,
+			[]request.IPAddress{v6, v4},
+		},
+		{
+			"single stack",
+			[]request.IPAddress{v6},
+			false,
+			[]request.IPAddress{v6},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// ordering must be stable across runs
+			for i := 0; i < 10; i++ {
+				addresses := append([]request.IPAddress{}, test.addresses...)
+				sortIPAddresses(addresses, test.preferIPv6)
+				if !reflect.DeepEqual(addresses, test.expected) {
+					t.Fatalf("test %s fails: expected %v but got %v", test.name, test.expected, addresses)
+				}
+			}
+		})
+	}
+}
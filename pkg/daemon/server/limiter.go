@@ -0,0 +1,97 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+
+	"github.com/alibaba/hybridnet/pkg/metrics"
+	"github.com/alibaba/hybridnet/pkg/request"
+)
+
+// handlerLimiter limits the concurrency of cni handlers, requests beyond max concurrency wait
+// in a bounded queue, and the ones beyond the queue are rejected at once, a nil limiter means
+// no limit
+type handlerLimiter struct {
+	// running holds a token for every request being handled
+	running chan struct{}
+	// admitted holds a token for every request being handled or waiting
+	admitted chan struct{}
+}
+
+func newHandlerLimiter(maxConcurrency, queueSize int) *handlerLimiter {
+	if maxConcurrency <= 0 {
+		return nil
+	}
+	return &handlerLimiter{
+		running:  make(chan struct{}, maxConcurrency),
+		admitted: make(chan struct{}, maxConcurrency+queueSize),
+	}
+}
+
+// acquire returns false if the queue is full or ctx is done while waiting,
+// release must be called if it returns true
+func (l *handlerLimiter) acquire(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+
+	select {
+	case l.admitted <- struct{}{}:
+	default:
+		return false
+	}
+
+	select {
+	case l.running <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		<-l.admitted
+		return false
+	}
+}
+
+func (l *handlerLimiter) release() {
+	if l == nil {
+		return
+	}
+
+	<-l.running
+	<-l.admitted
+}
+
+// filter rejects requests with retryable status if daemon is saturated
+func (l *handlerLimiter) filter(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	if !l.acquire(req.Request.Context()) {
+		metrics.DaemonHandlerRejectedCounter.WithLabelValues(req.Request.URL.Path).Inc()
+		_ = resp.WriteHeaderAndEntity(http.StatusServiceUnavailable, request.PodResponse{
+			Err:       fmt.Sprintf("too many concurrent requests, retry %s later", req.Request.URL.Path),
+			ErrReason: request.ErrReasonDaemonBusy,
+		})
+		return
+	}
+	defer l.release()
+
+	metrics.DaemonHandlerInFlightGauge.Inc()
+	defer metrics.DaemonHandlerInFlightGauge.Dec()
+
+	chain.ProcessFilter(req, resp)
+}
@@ -0,0 +1,68 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHandlerLimiter(t *testing.T) {
+	limiter := newHandlerLimiter(1, 1)
+
+	if !limiter.acquire(context.Background()) {
+		t.Fatalf("expected the first request to be handled")
+	}
+
+	// the second request waits in queue until the first one is released
+	acquired := make(chan bool)
+	go func() {
+		acquired <- limiter.acquire(context.Background())
+	}()
+
+	// wait until the second request is admitted into queue
+	for len(limiter.admitted) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if limiter.acquire(context.Background()) {
+		t.Fatalf("expected the third request to be rejected if queue is full")
+	}
+
+	limiter.release()
+	if !<-acquired {
+		t.Fatalf("expected the queued request to be handled after release")
+	}
+
+	// waiting request gives up if its context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if limiter.acquire(ctx) {
+		t.Fatalf("expected the request with done context to be rejected")
+	}
+	if len(limiter.admitted) != 1 {
+		t.Fatalf("expected the rejected request to leave queue, got %d admitted", len(limiter.admitted))
+	}
+	limiter.release()
+
+	var unlimited *handlerLimiter
+	if !unlimited.acquire(context.Background()) {
+		t.Fatalf("expected nil limiter to never reject")
+	}
+	unlimited.release()
+}
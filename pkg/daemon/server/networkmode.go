@@ -0,0 +1,146 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/daemon/bgp"
+	daemonconfig "github.com/alibaba/hybridnet/pkg/daemon/config"
+	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
+	"github.com/alibaba/hybridnet/pkg/daemon/utils"
+)
+
+// NicConfig contains the information of pod which network mode handlers need to configure nic
+type NicConfig struct {
+	PodName      string
+	PodNamespace string
+	NetNS        string
+	ContainerID  string
+	IfName       string
+	MacAddr      net.HardwareAddr
+	NetID        *int32
+	AllocatedIPs map[networkingv1.IPVersion]*utils.IPInfo
+	// PrimaryIPVersion is the ip version configured first for dual-stack pods
+	PrimaryIPVersion networkingv1.IPVersion
+}
+
+// NetworkModeHandler configures nic of pod for a specified network mode
+type NetworkModeHandler interface {
+	// Configure creates and configures nic of pod, returns the name of host side interface
+	Configure(nicConfig *NicConfig) (hostIf string, err error)
+}
+
+// NetworkModeHandlerFactory creates a network mode handler from daemon configuration
+type NetworkModeHandlerFactory func(config *daemonconfig.Configuration, bgpManager *bgp.Manager) NetworkModeHandler
+
+var networkModeHandlerFactories = map[networkingv1.NetworkMode]NetworkModeHandlerFactory{}
+
+// RegisterNetworkModeHandler registers handler factory for a network mode, it is supposed
+// to be called in init functions, and will override the registered one of the same mode
+func RegisterNetworkModeHandler(networkMode networkingv1.NetworkMode, factory NetworkModeHandlerFactory) {
+	networkModeHandlerFactories[networkMode] = factory
+}
+
+func init() {
+	RegisterNetworkModeHandler(networkingv1.NetworkModeVlan, func(config *daemonconfig.Configuration, bgpManager *bgp.Manager) NetworkModeHandler {
+		return &vethHandler{
+			networkMode: networkingv1.NetworkModeVlan,
+			mtu:         config.VlanMTU,
+			nodeIfName:  config.NodeVlanIfName,
+			config:      config,
+			bgpManager:  bgpManager,
+		}
+	})
+	RegisterNetworkModeHandler(networkingv1.NetworkModeVxlan, func(config *daemonconfig.Configuration, bgpManager *bgp.Manager) NetworkModeHandler {
+		return &vethHandler{
+			networkMode: networkingv1.NetworkModeVxlan,
+			mtu:         config.VxlanMTU,
+			nodeIfName:  config.NodeVxlanIfName,
+			config:      config,
+			bgpManager:  bgpManager,
+		}
+	})
+	RegisterNetworkModeHandler(networkingv1.NetworkModeBGP, func(config *daemonconfig.Configuration, bgpManager *bgp.Manager) NetworkModeHandler {
+		return &vethHandler{
+			networkMode: networkingv1.NetworkModeBGP,
+			mtu:         config.BGPMTU,
+			nodeIfName:  config.NodeBGPIfName,
+			config:      config,
+			bgpManager:  bgpManager,
+		}
+	})
+}
+
+func newNetworkModeHandlers(config *daemonconfig.Configuration, bgpManager *bgp.Manager) map[networkingv1.NetworkMode]NetworkModeHandler {
+	handlers := make(map[networkingv1.NetworkMode]NetworkModeHandler, len(networkModeHandlerFactories))
+	for networkMode, factory := range networkModeHandlerFactories {
+		handlers[networkMode] = factory(config, bgpManager)
+	}
+	return handlers
+}
+
+// vethHandler connects pod to node by a veth pair, which is used by vlan, vxlan and bgp modes
+type vethHandler struct {
+	networkMode networkingv1.NetworkMode
+	mtu         int
+	nodeIfName  string
+
+	config     *daemonconfig.Configuration
+	bgpManager *bgp.Manager
+}
+
+// precheck fails the configuration before node is touched if anything required by network mode is missing,
+// bgp manager is only required by bgp mode
+func (v *vethHandler) precheck() error {
+	if v.networkMode == networkingv1.NetworkModeBGP && v.bgpManager == nil {
+		return fmt.Errorf("bgp manager is unavailable on node %v", v.config.NodeName)
+	}
+	return nil
+}
+
+func (v *vethHandler) Configure(nicConfig *NicConfig) (hostIf string, err error) {
+	if err = v.precheck(); err != nil {
+		return "", err
+	}
+
+	containerNicName, hostNicName, podNS, err := initContainerNic(nicConfig.PodName, nicConfig.PodNamespace, nicConfig.NetNS, nicConfig.IfName, v.mtu)
+	if err != nil {
+		return "", fmt.Errorf("failed to init container nic for pod %v: %v", nicConfig.PodName, err)
+	}
+
+	defer func() {
+		if err != nil {
+			// clean the veth pair
+			_ = deleteContainerNic(nicConfig.NetNS, nicConfig.IfName)
+		}
+	}()
+
+	if err = containernetwork.ConfigureHostNic(hostNicName, nicConfig.AllocatedIPs, v.config.LocalDirectTableNum); err != nil {
+		return "", fmt.Errorf("failed to configure host nic for %v.%v: %v", nicConfig.PodName, nicConfig.PodNamespace, err)
+	}
+
+	if err = containernetwork.ConfigureContainerNic(containerNicName, hostNicName, v.nodeIfName,
+		nicConfig.AllocatedIPs, nicConfig.PrimaryIPVersion, nicConfig.MacAddr, nicConfig.NetID, podNS, v.mtu, v.config.VlanCheckTimeout, v.networkMode,
+		v.config.NeighGCThresh1, v.config.NeighGCThresh2, v.config.NeighGCThresh3, v.bgpManager); err != nil {
+		return "", fmt.Errorf("failed to configure container nic for %v.%v: %w", nicConfig.PodName, nicConfig.PodNamespace, err)
+	}
+
+	return hostNicName, nil
+}
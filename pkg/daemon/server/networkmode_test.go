@@ -0,0 +1,121 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	daemonconfig "github.com/alibaba/hybridnet/pkg/daemon/config"
+)
+
+func TestNewNetworkModeHandlers(t *testing.T) {
+	config := &daemonconfig.Configuration{
+		VlanMTU:         1500,
+		VxlanMTU:        1450,
+		BGPMTU:          1480,
+		NodeVlanIfName:  "eth0",
+		NodeVxlanIfName: "eth1",
+		NodeBGPIfName:   "eth2",
+	}
+	handlers := newNetworkModeHandlers(config, nil)
+
+	tests := []struct {
+		name               string
+		networkMode        networkingv1.NetworkMode
+		expectedMTU        int
+		expectedNodeIfName string
+	}{
+		{
+			"vlan",
+			networkingv1.NetworkModeVlan,
+			1500,
+			"eth0",
+		},
+		{
+			"vxlan",
+			networkingv1.NetworkModeVxlan,
+			1450,
+			"eth1",
+		},
+		{
+			"bgp",
+			networkingv1.NetworkModeBGP,
+			1480,
+			"eth2",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler, exist := handlers[test.networkMode]
+			if !exist {
+				t.Fatalf("test %s fails, handler of network mode %s not found", test.name, test.networkMode)
+			}
+			veth, ok := handler.(*vethHandler)
+			if !ok {
+				t.Fatalf("test %s fails, unexpected handler type %T", test.name, handler)
+			}
+			if veth.networkMode != test.networkMode || veth.mtu != test.expectedMTU || veth.nodeIfName != test.expectedNodeIfName {
+				t.Fatalf("test %s fails, unexpected handler %+v", test.name, veth)
+			}
+		})
+	}
+
+	if _, exist := handlers[networkingv1.NetworkMode("unknown")]; exist {
+		t.Fatalf("handler of unknown network mode should not exist")
+	}
+}
+
+func TestVethHandlerPrecheckWithoutBGPManager(t *testing.T) {
+	// bgp manager is nil if it failed to be created on daemon startup
+	handlers := newNetworkModeHandlers(&daemonconfig.Configuration{NodeName: "node1"}, nil)
+
+	tests := []struct {
+		name        string
+		networkMode networkingv1.NetworkMode
+		expectErr   bool
+	}{
+		{
+			"underlay vlan",
+			networkingv1.NetworkModeVlan,
+			false,
+		},
+		{
+			"overlay vxlan",
+			networkingv1.NetworkModeVxlan,
+			false,
+		},
+		{
+			"underlay bgp",
+			networkingv1.NetworkModeBGP,
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := handlers[test.networkMode].(*vethHandler).precheck()
+			if (err != nil) != test.expectErr {
+				t.Fatalf("test %s fails, expect error %v, got %v", test.name, test.expectErr, err)
+			}
+		})
+	}
+
+	// bgp mode fails before touching the node
+	if _, err := handlers[networkingv1.NetworkModeBGP].Configure(&NicConfig{PodName: "pod", PodNamespace: "ns"}); err == nil {
+		t.Fatalf("configuring bgp mode without bgp manager should fail")
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/vishvananda/netlink"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
+	globalutils "github.com/alibaba/hybridnet/pkg/utils"
+)
+
+// resyncIPInstanceStatus heals the status of ip instances whose nics were configured but the status
+// failed to be persisted, e.g., daemon restarted in between. It must run before serving cni requests,
+// and the nic of a pod is discovered by its host veth which is named after the pod.
+func (cdh *cniDaemonHandler) resyncIPInstanceStatus(ctx context.Context) error {
+	ipInstanceList := &networkingv1.IPInstanceList{}
+	if err := cdh.mgrClient.List(ctx, ipInstanceList, client.MatchingLabels{
+		constants.LabelNode: cdh.config.NodeName,
+	}); err != nil {
+		return fmt.Errorf("failed to list ip instances of node %v: %v", cdh.config.NodeName, err)
+	}
+
+	for i := range ipInstanceList.Items {
+		var ipInstance = &ipInstanceList.Items[i]
+		if !ipInstanceStatusStale(ipInstance, cdh.config.NodeName) {
+			continue
+		}
+
+		pod := &corev1.Pod{}
+		if err := cdh.mgrAPIReader.Get(ctx, types.NamespacedName{
+			Namespace: ipInstance.Status.PodNamespace,
+			Name:      ipInstance.Status.PodName,
+		}, pod); err != nil {
+			if !apierrors.IsNotFound(err) {
+				cdh.logger.Error(err, "failed to get pod of ip instance", "ipInstance", ipInstance.Name)
+			}
+			continue
+		}
+		if pod.DeletionTimestamp != nil || pod.Spec.NodeName != cdh.config.NodeName || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+
+		hostNicName := containernetwork.GenerateHostNicName(pod.Namespace, pod.Name)
+		hostLink, err := netlink.LinkByName(hostNicName)
+		if err != nil {
+			// nic is not configured, pod will be handled by the retried add request
+			continue
+		}
+
+		sandboxID := globalutils.PickFirstNonEmptyString(hostLink.Attrs().Alias, ipInstance.Status.SandboxID)
+		if err = cdh.patchIPInstanceBound(ctx, ipInstance, sandboxID); err != nil {
+			cdh.logger.Error(err, "failed to resync ip instance status", "ipInstance", ipInstance.Name)
+			continue
+		}
+		cdh.logger.Info("ip instance status is resynced",
+			"ipInstance", ipInstance.Name,
+			"podName", pod.Name,
+			"podNamespace", pod.Namespace,
+			"sandbox", sandboxID)
+	}
+
+	return nil
+}
+
+// ipInstanceStatusStale checks whether ip instance might have a configured nic without status persisted,
+// which is left in binding phase, or bound without sandbox or node recorded
+func ipInstanceStatusStale(ipInstance *networkingv1.IPInstance, nodeName string) bool {
+	if ipInstance.DeletionTimestamp != nil || len(ipInstance.Status.PodName) == 0 {
+		return false
+	}
+
+	switch ipInstance.Status.Phase {
+	case networkingv1.IPPhaseBinding:
+		return true
+	case networkingv1.IPPhaseBound:
+		return len(ipInstance.Status.SandboxID) == 0 || ipInstance.Status.NodeName != nodeName
+	default:
+		return false
+	}
+}
+
+func (cdh *cniDaemonHandler) patchIPInstanceBound(ctx context.Context, ipInstance *networkingv1.IPInstance, sandboxID string) error {
+	patchBody := fmt.Sprintf(`{"status":{"nodeName":%q,"phase":%q,"sandboxID":%q}}`,
+		cdh.config.NodeName, networkingv1.IPPhaseBound, sandboxID)
+	return cdh.mgrClient.Status().Patch(ctx, ipInstance, client.RawPatch(types.MergePatchType, []byte(patchBody)))
+}
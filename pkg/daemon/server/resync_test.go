@@ -0,0 +1,94 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+)
+
+func TestIPInstanceStatusStale(t *testing.T) {
+	now := metav1.Now()
+
+	newIPInstance := func(phase networkingv1.IPPhase, podName, nodeName, sandboxID string) *networkingv1.IPInstance {
+		return &networkingv1.IPInstance{
+			Status: networkingv1.IPInstanceStatus{
+				Phase:        phase,
+				PodName:      podName,
+				PodNamespace: "ns",
+				NodeName:     nodeName,
+				SandboxID:    sandboxID,
+			},
+		}
+	}
+
+	deleting := newIPInstance(networkingv1.IPPhaseBinding, "pod", "node1", "")
+	deleting.DeletionTimestamp = &now
+
+	tests := []struct {
+		name       string
+		ipInstance *networkingv1.IPInstance
+		expected   bool
+	}{
+		{
+			"binding",
+			newIPInstance(networkingv1.IPPhaseBinding, "pod", "node1", "sandbox"),
+			true,
+		},
+		{
+			"bound",
+			newIPInstance(networkingv1.IPPhaseBound, "pod", "node1", "sandbox"),
+			false,
+		},
+		{
+			"bound without sandbox",
+			newIPInstance(networkingv1.IPPhaseBound, "pod", "node1", ""),
+			true,
+		},
+		{
+			"bound with stale node",
+			newIPInstance(networkingv1.IPPhaseBound, "pod", "node2", "sandbox"),
+			true,
+		},
+		{
+			"using",
+			newIPInstance(networkingv1.IPPhaseUsing, "pod", "node1", ""),
+			false,
+		},
+		{
+			"reserved",
+			newIPInstance(networkingv1.IPPhaseReserved, "", "", ""),
+			false,
+		},
+		{
+			"deleting",
+			deleting,
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ipInstanceStatusStale(test.ipInstance, "node1"); got != test.expected {
+				t.Errorf("test %s fails: expected %v but got %v", test.name, test.expected, got)
+			}
+		})
+	}
+}
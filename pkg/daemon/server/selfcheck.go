@@ -0,0 +1,157 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/emicklei/go-restful"
+	"github.com/vishvananda/netlink"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
+	"github.com/alibaba/hybridnet/pkg/daemon/utils"
+	"github.com/alibaba/hybridnet/pkg/request"
+)
+
+// handleIPSelfCheck verifies every ip instance bound on node against the kernel state, the ip is expected
+// to be configured on the container side of the host veth of pod, mismatches mean that nic configuration
+// drifted or pod network was modified out-of-band
+func (cdh *cniDaemonHandler) handleIPSelfCheck(req *restful.Request, resp *restful.Response) {
+	ipInstanceList := &networkingv1.IPInstanceList{}
+	if err := cdh.mgrClient.List(req.Request.Context(), ipInstanceList, client.MatchingLabels{
+		constants.LabelNode: cdh.config.NodeName,
+	}); err != nil {
+		errMsg := fmt.Errorf("failed to list ip instances of node %v: %v", cdh.config.NodeName, err)
+		cdh.selfCheckErrorWrapper(errMsg, apiErrorStatusCode(err), resp)
+		return
+	}
+
+	netnsPaths, err := utils.NetnsPathsByID()
+	if err != nil {
+		errMsg := fmt.Errorf("failed to list netns: %v", err)
+		cdh.selfCheckErrorWrapper(errMsg, http.StatusInternalServerError, resp)
+		return
+	}
+
+	response := request.IPSelfCheckResponse{Mismatches: []request.IPSelfCheckMismatch{}}
+	for i := range ipInstanceList.Items {
+		var ipInstance = &ipInstanceList.Items[i]
+		if !ipInstanceBoundLocally(ipInstance, cdh.config.NodeName) {
+			continue
+		}
+
+		response.Checked++
+		if reason, message := checkIPInstanceNic(ipInstance, netnsPaths); len(reason) > 0 {
+			response.Mismatches = append(response.Mismatches, request.IPSelfCheckMismatch{
+				IPInstance:   ipInstance.Name,
+				PodName:      ipInstance.Status.PodName,
+				PodNamespace: ipInstance.Status.PodNamespace,
+				IP:           ipInstance.Spec.Address.IP,
+				Reason:       reason,
+				Message:      message,
+			})
+		}
+	}
+
+	if len(response.Mismatches) > 0 {
+		cdh.logger.Info("ip self-check found mismatches", "checked", response.Checked, "mismatches", response.Mismatches)
+	}
+	_ = resp.WriteHeaderAndEntity(http.StatusOK, response)
+}
+
+func (cdh *cniDaemonHandler) selfCheckErrorWrapper(err error, status int, resp *restful.Response) {
+	cdh.logger.Error(err, "self-check handler error")
+	_ = resp.WriteHeaderAndEntity(status, request.IPSelfCheckResponse{
+		Err: err.Error(),
+	})
+}
+
+// ipInstanceBoundLocally checks whether ip instance is believed to be configured in a pod on node
+func ipInstanceBoundLocally(ipInstance *networkingv1.IPInstance, nodeName string) bool {
+	return ipInstance.DeletionTimestamp == nil &&
+		len(ipInstance.Status.PodName) > 0 &&
+		ipInstance.Status.Phase == networkingv1.IPPhaseBound &&
+		ipInstance.Status.NodeName == nodeName
+}
+
+// checkIPInstanceNic returns the reason and message if the ip of ip instance is not configured as expected,
+// the netns of pod is found by the netns id of the peer of its host veth
+func checkIPInstanceNic(ipInstance *networkingv1.IPInstance, netnsPaths map[int]string) (string, string) {
+	hostNicName := containernetwork.GenerateHostNicName(ipInstance.Status.PodNamespace, ipInstance.Status.PodName)
+	hostLink, err := netlink.LinkByName(hostNicName)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return request.SelfCheckReasonHostInterfaceMissing, fmt.Sprintf("host nic %v is not found", hostNicName)
+		}
+		return request.SelfCheckReasonCheckFailed, fmt.Sprintf("failed to get host nic %v: %v", hostNicName, err)
+	}
+
+	// sandbox is recorded as alias of host veth, which is empty if configured by old daemons
+	if alias := hostLink.Attrs().Alias; len(alias) > 0 && len(ipInstance.Status.SandboxID) > 0 && alias != ipInstance.Status.SandboxID {
+		return request.SelfCheckReasonSandboxMismatch, fmt.Sprintf("host nic %v belongs to sandbox %v instead of %v",
+			hostNicName, alias, ipInstance.Status.SandboxID)
+	}
+
+	netnsPath, exist := netnsPaths[hostLink.Attrs().NetNsID]
+	if !exist {
+		return request.SelfCheckReasonNetnsMissing, fmt.Sprintf("netns of the peer of host nic %v is not found", hostNicName)
+	}
+
+	podIP, _, err := net.ParseCIDR(ipInstance.Spec.Address.IP)
+	if err != nil {
+		return request.SelfCheckReasonCheckFailed, fmt.Sprintf("invalid ip %v: %v", ipInstance.Spec.Address.IP, err)
+	}
+
+	var reason, message string
+	if err = ns.WithNetNSPath(netnsPath, func(_ ns.NetNS) error {
+		containerLink, err := netlink.LinkByIndex(hostLink.Attrs().ParentIndex)
+		if err != nil {
+			reason = request.SelfCheckReasonContainerInterfaceMissing
+			message = fmt.Sprintf("peer of host nic %v is not found in netns %v: %v", hostNicName, netnsPath, err)
+			return nil
+		}
+
+		addrs, err := netlink.AddrList(containerLink, netlink.FAMILY_ALL)
+		if err != nil {
+			return fmt.Errorf("failed to list addresses of %v: %v", containerLink.Attrs().Name, err)
+		}
+		if !addressConfigured(addrs, podIP) {
+			reason = request.SelfCheckReasonAddressMissing
+			message = fmt.Sprintf("ip %v is not configured on container nic %v in netns %v", podIP, containerLink.Attrs().Name, netnsPath)
+		}
+		return nil
+	}); err != nil {
+		return request.SelfCheckReasonCheckFailed, fmt.Sprintf("failed to check netns %v: %v", netnsPath, err)
+	}
+	return reason, message
+}
+
+// addressConfigured checks whether ip is in the addresses of a link
+func addressConfigured(addrs []netlink.Addr, ip net.IP) bool {
+	for _, addr := range addrs {
+		if addr.IPNet != nil && addr.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+)
+
+func TestIPInstanceBoundLocally(t *testing.T) {
+	now := metav1.NewTime(time.Now())
+	tests := []struct {
+		name              string
+		deletionTimestamp *metav1.Time
+		status            networkingv1.IPInstanceStatus
+		expected          bool
+	}{
+		{"bound locally", nil, networkingv1.IPInstanceStatus{PodName: "pod", NodeName: "node", Phase: networkingv1.IPPhaseBound}, true},
+		{"bound on other node", nil, networkingv1.IPInstanceStatus{PodName: "pod", NodeName: "other", Phase: networkingv1.IPPhaseBound}, false},
+		{"binding", nil, networkingv1.IPInstanceStatus{PodName: "pod", NodeName: "node", Phase: networkingv1.IPPhaseBinding}, false},
+		{"reserved", nil, networkingv1.IPInstanceStatus{NodeName: "node", Phase: networkingv1.IPPhaseReserved}, false},
+		{"being deleted", &now, networkingv1.IPInstanceStatus{PodName: "pod", NodeName: "node", Phase: networkingv1.IPPhaseBound}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ipInstance := &networkingv1.IPInstance{
+				ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: test.deletionTimestamp},
+				Status:     test.status,
+			}
+			if bound := ipInstanceBoundLocally(ipInstance, "node"); bound != test.expected {
+				t.Errorf("expect %v, got %v", test.expected, bound)
+			}
+		})
+	}
+}
+
+func TestAddressConfigured(t *testing.T) {
+	addrOf := func(cidr string) netlink.Addr {
+		ip, ipNet, _ := net.ParseCIDR(cidr)
+		ipNet.IP = ip
+		return netlink.Addr{IPNet: ipNet}
+	}
+	addrs := []netlink.Addr{addrOf("192.168.0.2/24"), addrOf("fd00::2/64"), {}}
+
+	tests := []struct {
+		name     string
+		ip       string
+		expected bool
+	}{
+		{"ipv4 configured", "192.168.0.2", true},
+		{"ipv6 configured", "fd00::2", true},
+		{"ipv4 missing", "192.168.0.3", false},
+		{"ipv6 missing", "fd00::3", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if configured := addressConfigured(addrs, net.ParseIP(test.ip)); configured != test.expected {
+				t.Errorf("expect %v, got %v", test.expected, configured)
+			}
+		})
+	}
+}
@@ -18,9 +18,15 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/go-logr/logr"
 
@@ -38,22 +44,125 @@ func RunServer(ctx context.Context, config *config.Configuration, ctrlRef *contr
 		logger.Error(err, "failed to create cni daemon handler", "socket path", config.BindSocket)
 		return
 	}
+
+	// failure of resync is tolerable, the stale ip instances will be healed by next add requests
+	if err = cdh.resyncIPInstanceStatus(ctx); err != nil {
+		logger.Error(err, "failed to resync ip instance status")
+	}
+
+	if len(config.DebugServerAddress) > 0 {
+		go runDebugServer(ctx, cdh, logger)
+	}
+
 	server := http.Server{
 		Handler: createHandler(cdh),
 	}
-	unixListener, err := net.Listen("unix", config.BindSocket)
+	unixListener, err := listenUnixSocket(config.BindSocket, config.BindSocketMode)
 	if err != nil {
 		logger.Error(err, "failed to bind socket", "socket path", config.BindSocket)
 		return
 	}
 	defer os.Remove(config.BindSocket)
-	logger.Info("server started", "socket path", config.BindSocket)
+	logger.Info("server started", "socket path", config.BindSocket, "socket mode", config.BindSocketMode.String())
 
 	err = server.Serve(unixListener)
 	logger.Error(err, "server exist unexpected")
 }
 
+// listenUnixSocket listens on unix socket with the specified file mode, a stale socket left by
+// the last daemon will be cleaned up, but a socket still in service will not
+func listenUnixSocket(path string, mode os.FileMode) (net.Listener, error) {
+	if fileInfo, err := os.Lstat(path); err == nil {
+		if fileInfo.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%v exists but is not a socket", path)
+		}
+
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("socket %v is still in service", path)
+		}
+
+		if err = os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %v: %v", path, err)
+		}
+	}
+
+	// socket is created in a private directory and only moved to the target path after its
+	// permissions are fixed, so it is never accessible with a looser mode, the process-wide
+	// umask is left untouched because other goroutines may be creating files concurrently
+	tmpDir, err := ioutil.TempDir(filepath.Dir(path), ".socket-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir for socket %v: %v", path, err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpPath := filepath.Join(tmpDir, filepath.Base(path))
+	listener, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	// the socket file will be renamed, unlinking on close is meaningless
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	if err = os.Chmod(tmpPath, mode); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to chmod socket %v: %v", path, err)
+	}
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to move socket to %v: %v", path, err)
+	}
+	return listener, nil
+}
+
+// runDebugServer serves the read-only endpoints over tcp for remote diagnostics, clients must present
+// certificates signed by the configured ca, and mutating endpoints are only served on unix socket
+func runDebugServer(ctx context.Context, cdh *cniDaemonHandler, logger logr.Logger) {
+	tlsConfig, err := newDebugTLSConfig(cdh.config.DebugTLSClientCAFile)
+	if err != nil {
+		logger.Error(err, "failed to create tls config of debug server")
+		return
+	}
+
+	server := &http.Server{
+		Addr:      cdh.config.DebugServerAddress,
+		Handler:   createDebugHandler(cdh),
+		TLSConfig: tlsConfig,
+	}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	logger.Info("debug server started", "address", cdh.config.DebugServerAddress)
+	if err = server.ListenAndServeTLS(cdh.config.DebugTLSCertFile, cdh.config.DebugTLSKeyFile); err != http.ErrServerClosed {
+		logger.Error(err, "debug server exits unexpectedly")
+	}
+}
+
+// newDebugTLSConfig requires and verifies client certificates by the ca file
+func newDebugTLSConfig(clientCAFile string) (*tls.Config, error) {
+	caPEM, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client ca file %v: %v", clientCAFile, err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificate is found in client ca file %v", clientCAFile)
+	}
+
+	return &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
 func createHandler(cdh *cniDaemonHandler) http.Handler {
+	limiter := newHandlerLimiter(cdh.config.MaxConcurrentHandlers, cdh.config.HandlerQueueSize)
+
 	wsContainer := restful.NewContainer()
 	wsContainer.EnableContentEncoding(true)
 
@@ -65,12 +174,65 @@ func createHandler(cdh *cniDaemonHandler) http.Handler {
 
 	ws.Route(
 		ws.POST("/add").
+			Filter(limiter.filter).
 			To(cdh.handleAdd).
 			Reads(request.PodRequest{}))
 	ws.Route(
 		ws.POST("/del").
+			Filter(limiter.filter).
 			To(cdh.handleDel).
 			Reads(request.PodRequest{}))
+	ws.Route(
+		ws.POST("/release").
+			Filter(limiter.filter).
+			To(cdh.handleRelease).
+			Reads(request.PodRequest{}))
+	ws.Route(
+		ws.POST("/ipam/add-multi").
+			Filter(limiter.filter).
+			To(cdh.handleIPAMAddMulti).
+			Reads(request.IPAMMultiRequest{}).
+			Writes(request.IPAMMultiResponse{}))
+	ws.Route(
+		ws.POST("/bgp/readvertise").
+			To(cdh.handleBGPReAdvertise).
+			Writes(request.BGPReAdvertiseResponse{}))
+	addReadOnlyRoutes(ws, cdh)
 
 	return wsContainer
 }
+
+// createDebugHandler only serves the read-only endpoints
+func createDebugHandler(cdh *cniDaemonHandler) http.Handler {
+	wsContainer := restful.NewContainer()
+	wsContainer.EnableContentEncoding(true)
+
+	ws := new(restful.WebService)
+	ws.Path("/api/v1").
+		Produces(restful.MIME_JSON)
+	wsContainer.Add(ws)
+
+	addReadOnlyRoutes(ws, cdh)
+
+	return wsContainer
+}
+
+// addReadOnlyRoutes adds the endpoints which never change node or cluster state
+func addReadOnlyRoutes(ws *restful.WebService, cdh *cniDaemonHandler) {
+	ws.Route(
+		ws.GET("/bgp/peers").
+			To(cdh.handleBGPPeers).
+			Writes(request.BGPPeersResponse{}))
+	ws.Route(
+		ws.GET("/ipam/self-check").
+			To(cdh.handleIPSelfCheck).
+			Writes(request.IPSelfCheckResponse{}))
+	if cdh.config.EnablePodDiagnostics {
+		ws.Route(
+			ws.GET("/pod/diagnostics").
+				To(cdh.handlePodDiagnostics).
+				Param(ws.QueryParameter("namespace", "namespace of pod").Required(true)).
+				Param(ws.QueryParameter("name", "name of pod").Required(true)).
+				Writes(request.PodDiagnosticsResponse{}))
+	}
+}
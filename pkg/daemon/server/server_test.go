@@ -0,0 +1,195 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alibaba/hybridnet/pkg/daemon/config"
+)
+
+func TestListenUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hybridnet-socket")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name        string
+		prepare     func(path string) func()
+		expectError bool
+	}{
+		{
+			"no existing file",
+			func(path string) func() { return func() {} },
+			false,
+		},
+		{
+			"stale socket",
+			func(path string) func() {
+				listener, err := net.Listen("unix", path)
+				if err != nil {
+					t.Fatalf("failed to create stale socket: %v", err)
+				}
+				listener.(*net.UnixListener).SetUnlinkOnClose(false)
+				_ = listener.Close()
+				return func() {}
+			},
+			false,
+		},
+		{
+			"socket in service",
+			func(path string) func() {
+				listener, err := net.Listen("unix", path)
+				if err != nil {
+					t.Fatalf("failed to create socket: %v", err)
+				}
+				return func() { _ = listener.Close() }
+			},
+			true,
+		},
+		{
+			"regular file",
+			func(path string) func() {
+				if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+					t.Fatalf("failed to create regular file: %v", err)
+				}
+				return func() { _ = os.Remove(path) }
+			},
+			true,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, string(rune('a'+i))+".sock")
+			cleanup := test.prepare(path)
+			defer cleanup()
+
+			listener, err := listenUnixSocket(path, 0600)
+			if (err != nil) != test.expectError {
+				t.Fatalf("test %s fails: expected error %v but got %v", test.name, test.expectError, err)
+			}
+			if err != nil {
+				return
+			}
+			defer listener.Close()
+
+			fileInfo, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("test %s fails: failed to stat socket: %v", test.name, err)
+			}
+			if fileInfo.Mode().Perm() != 0600 {
+				t.Errorf("test %s fails: expected mode 0600 but got %v", test.name, fileInfo.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestCreateDebugHandler(t *testing.T) {
+	handler := createDebugHandler(&cniDaemonHandler{config: &config.Configuration{}})
+
+	// mutating endpoints must never be served by debug server
+	for _, path := range []string{
+		"/api/v1/add",
+		"/api/v1/del",
+		"/api/v1/release",
+		"/api/v1/ipam/add-multi",
+		"/api/v1/bgp/readvertise",
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, nil))
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("expected %s not found on debug server but got status %d", path, recorder.Code)
+		}
+	}
+}
+
+func TestNewDebugTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hybridnet-debug-tls")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "hybridnet-debug-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	validCAFile := filepath.Join(dir, "ca.crt")
+	if err = ioutil.WriteFile(validCAFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600); err != nil {
+		t.Fatalf("failed to write ca file: %v", err)
+	}
+	invalidCAFile := filepath.Join(dir, "invalid.crt")
+	if err = ioutil.WriteFile(invalidCAFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("failed to write ca file: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		caFile      string
+		expectError bool
+	}{
+		{"valid ca", validCAFile, false},
+		{"invalid ca", invalidCAFile, true},
+		{"missing ca", filepath.Join(dir, "missing.crt"), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tlsConfig, err := newDebugTLSConfig(test.caFile)
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error %v but got %v", test.expectError, err)
+			}
+			if err != nil {
+				return
+			}
+			if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+				t.Errorf("expected client certificates required and verified but got %v", tlsConfig.ClientAuth)
+			}
+		})
+	}
+}
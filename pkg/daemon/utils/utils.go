@@ -17,13 +17,20 @@
 package utils
 
 import (
+	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
 
 	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+
+	"github.com/alibaba/hybridnet/pkg/constants"
 )
 
 type HybridnetDaemonError string
@@ -32,6 +39,18 @@ func (e HybridnetDaemonError) Error() string {
 	return "error info: " + string(e)
 }
 
+// DuplicateIPError means the ip of pod is answered by another hardware address before the nic of pod
+// is configured, which is found by arp probe or ndp neighbor solicitation
+type DuplicateIPError struct {
+	IP           net.IP
+	HardwareAddr net.HardwareAddr
+}
+
+func (e *DuplicateIPError) Error() string {
+	return fmt.Sprintf("pod ip %v duplicated, please check if ip %v is occupied by other machines or containers, "+
+		"another hw addr is %v", e.IP.String(), e.IP.String(), e.HardwareAddr.String())
+}
+
 const (
 	NotExist = HybridnetDaemonError("not exist")
 )
@@ -95,3 +114,37 @@ func GetSysctl(sysctlPath string) (int, error) {
 
 	return val, nil
 }
+
+// NetnsPathsByID indexes the netns files of container runtimes by their ids in host netns, which are
+// referred by the host veths of pods
+func NetnsPathsByID() (map[int]string, error) {
+	var netnsPaths = map[int]string{}
+	for _, netnsDir := range []string{constants.DockerNetnsDir, constants.ContainerdNetnsDir} {
+		files, err := ioutil.ReadDir(netnsDir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		for _, f := range files {
+			path := filepath.Join(netnsDir, f.Name())
+			if f.Name() == "default" || !(IsProcFS(path) || IsNsFS(path)) {
+				continue
+			}
+
+			nsHandle, err := netns.GetFromPath(path)
+			if err != nil {
+				continue
+			}
+			id, err := netlink.GetNetNsIdByFd(int(nsHandle))
+			_ = nsHandle.Close()
+			if err != nil || id < 0 {
+				continue
+			}
+			netnsPaths[id] = path
+		}
+	}
+	return netnsPaths, nil
+}
@@ -44,7 +44,15 @@ const (
 
 	DualStack featuregate.Feature = "DualStack"
 
+	// Enable ipv6-only cluster, only ipv6 addresses will be allocated and configured,
+	// it implies DualStack because ipv6 allocation relies on dual stack IPAM.
+	IPv6Only featuregate.Feature = "IPv6Only"
+
 	MultiCluster featuregate.Feature = "MultiCluster"
+
+	// Enable swapping the ip of running pods on request, with a dual-homed window in between.
+	// It should be enabled on both manager and daemon.
+	IPSwap featuregate.Feature = "IPSwap"
 )
 
 var DefaultHybridnetFeatureGates = map[featuregate.Feature]featuregate.FeatureSpec{
@@ -52,20 +60,36 @@ var DefaultHybridnetFeatureGates = map[featuregate.Feature]featuregate.FeatureSp
 		Default:    false,
 		PreRelease: featuregate.Alpha,
 	},
+	IPv6Only: {
+		Default:    false,
+		PreRelease: featuregate.Alpha,
+	},
 	MultiCluster: {
 		Default:    false,
 		PreRelease: featuregate.Alpha,
 	},
+	IPSwap: {
+		Default:    false,
+		PreRelease: featuregate.Alpha,
+	},
 }
 
 func DualStackEnabled() bool {
-	return feature.DefaultMutableFeatureGate.Enabled(DualStack)
+	return feature.DefaultMutableFeatureGate.Enabled(DualStack) || IPv6OnlyEnabled()
+}
+
+func IPv6OnlyEnabled() bool {
+	return feature.DefaultMutableFeatureGate.Enabled(IPv6Only)
 }
 
 func MultiClusterEnabled() bool {
 	return feature.DefaultMutableFeatureGate.Enabled(MultiCluster)
 }
 
+func IPSwapEnabled() bool {
+	return feature.DefaultMutableFeatureGate.Enabled(IPSwap)
+}
+
 func KnownFeatures() []string {
 	return feature.DefaultMutableFeatureGate.KnownFeatures()
 }
@@ -19,6 +19,7 @@ package allocator
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/alibaba/hybridnet/pkg/ipam/types"
 )
@@ -31,6 +32,10 @@ type Allocator struct {
 	NetworkGetter NetworkGetter
 	SubnetGetter  SubnetGetter
 	IPSetGetter   IPSetGetter
+
+	Quarantines  *types.QuarantineSet
+	AddressPools *types.AddressPoolSet
+	RateLimiters *types.AllocationRateLimiterSet
 }
 
 func NewAllocator(networks []string, nGetter NetworkGetter, sGetter SubnetGetter, iGetter IPSetGetter) (*Allocator, error) {
@@ -40,6 +45,9 @@ func NewAllocator(networks []string, nGetter NetworkGetter, sGetter SubnetGetter
 		NetworkGetter: nGetter,
 		SubnetGetter:  sGetter,
 		IPSetGetter:   iGetter,
+		Quarantines:   types.NewQuarantineSet(QuarantineDuration),
+		AddressPools:  types.NewAddressPoolSet(),
+		RateLimiters:  types.NewAllocationRateLimiterSet(time.Now),
 	}
 
 	if err := allocator.Refresh(networks); err != nil {
@@ -87,11 +95,14 @@ func (a *Allocator) refreshNetwork(name string) error {
 		if err != nil {
 			return err
 		}
+		subnet.Quarantine = a.Quarantines.Get(subnet.Name)
+		subnet.AddressPool = a.AddressPools.Get(network.AddressPool)
 		if err = network.AddSubnet(subnet, ips); err != nil {
 			return err
 		}
 	}
 
+	network.RateLimiter = a.RateLimiters.Get(name, network.AllocationQPS, network.AllocationBurst)
 	a.Networks.RefreshNetwork(name, network)
 
 	return nil
@@ -108,12 +119,17 @@ func (a *Allocator) Allocate(networkName, subnetName, podName, podNamespace stri
 
 	subnet, err := network.GetSubnet(subnetName)
 	if err != nil {
-		return nil, fmt.Errorf("fail to get subnet %s: %v", subnetName, err)
+		return nil, fmt.Errorf("fail to get subnet %s: %w", subnetName, err)
+	}
+
+	if !network.RateLimiter.Allow() {
+		return nil, fmt.Errorf("fail to allocate from network %s: %w", networkName, types.ErrAllocationThrottled)
 	}
 
 	availableIP := subnet.AllocateNext(podName, podNamespace)
 	if availableIP == nil {
-		return nil, fmt.Errorf("fail to get available ip from subnet %s", subnet.Name)
+		network.RateLimiter.Return()
+		return nil, fmt.Errorf("fail to get available ip from subnet %s: %w", subnet.Name, types.ErrNoAvailableIP)
 	}
 
 	return availableIP, nil
@@ -190,6 +206,28 @@ func (a *Allocator) SubnetUsage(networkName, subnetName string) (*types.Usage, e
 	return subnet.Usage(), nil
 }
 
+// ReserveRange reserves ips of subnet for infrastructure, none of them is reserved if any is in use
+func (a *Allocator) ReserveRange(networkName, subnetName string, ips []string) error {
+	a.Lock()
+	defer a.Unlock()
+
+	network, err := a.Networks.GetNetwork(networkName)
+	if err != nil {
+		return fmt.Errorf("fail to get network %s: %v", networkName, err)
+	}
+
+	subnet, err := network.GetSubnet(subnetName)
+	if err != nil {
+		return fmt.Errorf("fail to get subnet %s: %v", subnetName, err)
+	}
+
+	if err = subnet.ReserveRange(ips); err != nil {
+		return fmt.Errorf("fail to reserve ips in subnet %s: %w", subnetName, err)
+	}
+
+	return nil
+}
+
 func (a *Allocator) GetNetworksByType(networkType types.NetworkType) []string {
 	a.RLock()
 	defer a.RUnlock()
@@ -17,6 +17,7 @@
 package allocator_test
 
 import (
+	"errors"
 	"net"
 	"testing"
 
@@ -92,3 +93,100 @@ func TestAllocator(t *testing.T) {
 	}
 
 }
+
+func TestAllocatorRateLimit(t *testing.T) {
+	var networkGetter = func(network string) (*types.Network, error) {
+		return &types.Network{
+			Name:            network,
+			Subnets:         types.NewSubnetSlice(),
+			Type:            types.Underlay,
+			AllocationQPS:   1,
+			AllocationBurst: 2,
+		}, nil
+	}
+
+	var subnetGetter = func(networkName string) ([]*types.Subnet, error) {
+		_, cidr, _ := net.ParseCIDR("192.168.0.0/24")
+		return []*types.Subnet{
+			{
+				Name:          "subnet1",
+				ParentNetwork: networkName,
+				CIDR:          cidr,
+				Gateway:       net.ParseIP("192.168.0.254"),
+			},
+		}, nil
+	}
+
+	var ipSetGetter = func(subnet string) (types.IPSet, error) {
+		return types.NewIPSet(), nil
+	}
+
+	networkTest := "network-test-1"
+	allocator, err := allocator.NewAllocator([]string{networkTest}, networkGetter, subnetGetter, ipSetGetter)
+	if err != nil {
+		t.Fatalf("fail to new allocator: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err = allocator.Allocate(networkTest, "", "pod", "default"); err != nil {
+			t.Fatalf("fail to allocate ip within burst: %v", err)
+		}
+	}
+	if _, err = allocator.Allocate(networkTest, "", "pod", "default"); !types.IsAllocationThrottled(err) {
+		t.Fatalf("expected allocation throttled, got %v", err)
+	}
+
+	// tokens are kept across refreshing
+	if err = allocator.Refresh([]string{networkTest}); err != nil {
+		t.Fatalf("fail to refresh allocator: %v", err)
+	}
+	if _, err = allocator.Allocate(networkTest, "", "pod", "default"); !types.IsAllocationThrottled(err) {
+		t.Errorf("expected allocation still throttled after refreshing, got %v", err)
+	}
+}
+
+func TestAllocatorRateLimitReturnsTokenOnFailure(t *testing.T) {
+	var networkGetter = func(network string) (*types.Network, error) {
+		return &types.Network{
+			Name:            network,
+			Subnets:         types.NewSubnetSlice(),
+			Type:            types.Underlay,
+			AllocationQPS:   1,
+			AllocationBurst: 2,
+		}, nil
+	}
+
+	// only 192.168.0.1 is available
+	var subnetGetter = func(networkName string) ([]*types.Subnet, error) {
+		_, cidr, _ := net.ParseCIDR("192.168.0.0/30")
+		return []*types.Subnet{
+			{
+				Name:          "subnet1",
+				ParentNetwork: networkName,
+				CIDR:          cidr,
+				Gateway:       net.ParseIP("192.168.0.2"),
+			},
+		}, nil
+	}
+
+	var ipSetGetter = func(subnet string) (types.IPSet, error) {
+		return types.NewIPSet(), nil
+	}
+
+	networkTest := "network-test-1"
+	allocator, err := allocator.NewAllocator([]string{networkTest}, networkGetter, subnetGetter, ipSetGetter)
+	if err != nil {
+		t.Fatalf("fail to new allocator: %v", err)
+	}
+
+	if _, err = allocator.Allocate(networkTest, "subnet1", "pod", "default"); err != nil {
+		t.Fatalf("fail to allocate the only available ip: %v", err)
+	}
+
+	// failures of exhausted specified subnet are not throttled, since their tokens are given back
+	for i := 0; i < 3; i++ {
+		if _, err = allocator.Allocate(networkTest, "subnet1", "pod", "default"); !errors.Is(err, types.ErrNoAvailableIP) {
+			t.Fatalf("expected allocation %d failed by exhausted subnet, got %v", i, err)
+		}
+	}
+}
@@ -19,6 +19,7 @@ package allocator
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/alibaba/hybridnet/pkg/ipam/types"
 )
@@ -31,6 +32,10 @@ type DualStackAllocator struct {
 	NetworkGetter NetworkGetter
 	SubnetGetter  SubnetGetter
 	IPSetGetter   IPSetGetter
+
+	Quarantines  *types.QuarantineSet
+	AddressPools *types.AddressPoolSet
+	RateLimiters *types.AllocationRateLimiterSet
 }
 
 func NewDualStackAllocator(networks []string, nGetter NetworkGetter, sGetter SubnetGetter, iGetter IPSetGetter) (*DualStackAllocator, error) {
@@ -40,6 +45,9 @@ func NewDualStackAllocator(networks []string, nGetter NetworkGetter, sGetter Sub
 		NetworkGetter: nGetter,
 		SubnetGetter:  sGetter,
 		IPSetGetter:   iGetter,
+		Quarantines:   types.NewQuarantineSet(QuarantineDuration),
+		AddressPools:  types.NewAddressPoolSet(),
+		RateLimiters:  types.NewAllocationRateLimiterSet(time.Now),
 	}
 
 	if err := allocator.Refresh(networks); err != nil {
@@ -95,6 +103,20 @@ func (d *DualStackAllocator) Allocate(ipFamilyMode types.IPFamilyMode, network s
 	d.Lock()
 	defer d.Unlock()
 
+	// ips of all families are allocated with one token, which is taken only if network exists,
+	// and given back if allocation fails
+	var rateLimiter *types.AllocationRateLimiter
+	if n, err := d.Networks.GetNetwork(network); err == nil {
+		if rateLimiter = n.RateLimiter; !rateLimiter.Allow() {
+			return nil, fmt.Errorf("fail to allocate from network %s: %w", network, types.ErrAllocationThrottled)
+		}
+	}
+	defer func() {
+		if err != nil {
+			rateLimiter.Return()
+		}
+	}()
+
 	switch ipFamilyMode {
 	case types.IPv4Only:
 		return d.allocateIPv4Only(network, subnets, podName, podNamespace)
@@ -125,12 +147,12 @@ func (d *DualStackAllocator) allocateIPv4Only(networkName string, subnets []stri
 
 	var subnet *types.Subnet
 	if subnet, err = network.GetIPv4Subnet(subnetName); err != nil {
-		return nil, fmt.Errorf("fail to get ipv4 subnet: %v", err)
+		return nil, fmt.Errorf("fail to get ipv4 subnet: %w", err)
 	}
 
 	var ipv4Candidate *types.IP
 	if ipv4Candidate = subnet.AllocateNext(podName, podNamespace); ipv4Candidate == nil {
-		return nil, fmt.Errorf("fail to get available ipv4 from subnet %s", subnet.Name)
+		return nil, fmt.Errorf("fail to get available ipv4 from subnet %s: %w", subnet.Name, types.ErrNoAvailableIP)
 	}
 
 	IPs = append(IPs, ipv4Candidate)
@@ -155,12 +177,12 @@ func (d *DualStackAllocator) allocateIPv6Only(networkName string, subnets []stri
 
 	var subnet *types.Subnet
 	if subnet, err = network.GetIPv6Subnet(subnetName); err != nil {
-		return nil, fmt.Errorf("fail to get ipv6 subnet: %v", err)
+		return nil, fmt.Errorf("fail to get ipv6 subnet: %w", err)
 	}
 
 	var ipv6Candidate *types.IP
 	if ipv6Candidate = subnet.AllocateNext(podName, podNamespace); ipv6Candidate == nil {
-		return nil, fmt.Errorf("fail to get available ipv6 from subnet %s", subnet.Name)
+		return nil, fmt.Errorf("fail to get available ipv6 from subnet %s: %w", subnet.Name, types.ErrNoAvailableIP)
 	}
 
 	IPs = append(IPs, ipv6Candidate)
@@ -185,17 +207,17 @@ func (d *DualStackAllocator) allocateDualStack(networkName string, subnets []str
 
 	var v4Subnet, v6Subnet *types.Subnet
 	if v4Subnet, v6Subnet, err = network.GetPairedDualStackSubnets(v4Name, v6Name); err != nil {
-		return nil, fmt.Errorf("fail to get paired subnets: %v", err)
+		return nil, fmt.Errorf("fail to get paired subnets: %w", err)
 	}
 
 	var ipv4Candidate, ipv6Candidate *types.IP
 	if ipv4Candidate = v4Subnet.AllocateNext(podName, podNamespace); ipv4Candidate == nil {
-		return nil, fmt.Errorf("fail to get paired ipv4 from subnet %s", v4Subnet.Name)
+		return nil, fmt.Errorf("fail to get paired ipv4 from subnet %s: %w", v4Subnet.Name, types.ErrNoAvailableIP)
 	}
 	if ipv6Candidate = v6Subnet.AllocateNext(podName, podNamespace); ipv6Candidate == nil {
 		// recycle IPv4 address if IPv6 allocation fails
 		v4Subnet.Release(ipv4Candidate.Address.IP.String())
-		return nil, fmt.Errorf("fail to get paired ipv6 from subnet %s", v6Subnet.Name)
+		return nil, fmt.Errorf("fail to get paired ipv6 from subnet %s: %w", v6Subnet.Name, types.ErrNoAvailableIP)
 	}
 
 	IPs = append(IPs, ipv4Candidate, ipv6Candidate)
@@ -425,16 +447,41 @@ func (d *DualStackAllocator) refreshNetwork(name string) error {
 		if err != nil {
 			return err
 		}
+		subnet.Quarantine = d.Quarantines.Get(subnet.Name)
+		subnet.AddressPool = d.AddressPools.Get(network.AddressPool)
 		if err = network.AddSubnet(subnet, ips); err != nil {
 			return err
 		}
 	}
 
+	network.RateLimiter = d.RateLimiters.Get(name, network.AllocationQPS, network.AllocationBurst)
 	d.Networks.RefreshNetwork(name, network)
 
 	return nil
 }
 
+// ReserveRange reserves ips of subnet for infrastructure, none of them is reserved if any is in use
+func (d *DualStackAllocator) ReserveRange(networkName, subnetName string, ips []string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	network, err := d.Networks.GetNetwork(networkName)
+	if err != nil {
+		return fmt.Errorf("fail to get network %s: %v", networkName, err)
+	}
+
+	subnet, err := network.GetSubnet(subnetName)
+	if err != nil {
+		return fmt.Errorf("fail to get subnet %s: %v", subnetName, err)
+	}
+
+	if err = subnet.ReserveRange(ips); err != nil {
+		return fmt.Errorf("fail to reserve ips in subnet %s: %w", subnetName, err)
+	}
+
+	return nil
+}
+
 func (d *DualStackAllocator) GetNetworksByType(networkType types.NetworkType) []string {
 	d.RLock()
 	defer d.RUnlock()
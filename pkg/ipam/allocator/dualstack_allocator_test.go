@@ -104,3 +104,45 @@ func TestDualStackAllocator(t *testing.T) {
 	}
 
 }
+
+func TestDualStackAllocatorRateLimitReturnsTokenOnFailure(t *testing.T) {
+	var networkGetter = func(network string) (*types.Network, error) {
+		return &types.Network{
+			Name:            network,
+			Subnets:         types.NewSubnetSlice(),
+			Type:            types.Underlay,
+			AllocationQPS:   1,
+			AllocationBurst: 1,
+		}, nil
+	}
+
+	var subnetGetter = func(networkName string) ([]*types.Subnet, error) {
+		_, cidr, _ := net.ParseCIDR("192.168.0.0/24")
+		return []*types.Subnet{
+			types.NewSubnet("subnet1", networkName, nil, nil, nil, net.ParseIP("192.168.0.254"), cidr,
+				nil, nil, nil, false, false),
+		}, nil
+	}
+
+	var ipSetGetter = func(subnet string) (types.IPSet, error) {
+		return types.NewIPSet(), nil
+	}
+
+	networkTest := "network-test-1"
+	allocator, err := allocator.NewDualStackAllocator([]string{networkTest}, networkGetter, subnetGetter, ipSetGetter)
+	if err != nil {
+		t.Fatalf("fail to new allocator: %v", err)
+	}
+
+	// no ipv6 subnet in network, the only token is given back
+	if _, err = allocator.Allocate(types.IPv6Only, networkTest, nil, "pod", "default"); err == nil || types.IsAllocationThrottled(err) {
+		t.Fatalf("expected allocation failed without ipv6 subnet, got %v", err)
+	}
+
+	if _, err = allocator.Allocate(types.IPv4Only, networkTest, nil, "pod", "default"); err != nil {
+		t.Fatalf("expected allocation allowed by returned token, got %v", err)
+	}
+	if _, err = allocator.Allocate(types.IPv4Only, networkTest, nil, "pod", "default"); !types.IsAllocationThrottled(err) {
+		t.Errorf("expected allocation throttled after token is taken, got %v", err)
+	}
+}
@@ -17,9 +17,21 @@
 package allocator
 
 import (
+	"time"
+
+	"github.com/spf13/pflag"
+
 	"github.com/alibaba/hybridnet/pkg/ipam/types"
 )
 
+// QuarantineDuration is the period that a released IP is held out of allocation
+var QuarantineDuration time.Duration
+
+func init() {
+	pflag.DurationVar(&QuarantineDuration, "ip-quarantine-duration", 0, "The duration that a released IP will not be allocated again, "+
+		"0 means quarantine is disabled.")
+}
+
 type NetworkGetter func(network string) (*types.Network, error)
 
 type SubnetGetter func(network string) ([]*types.Subnet, error)
@@ -18,6 +18,7 @@ package ipam
 
 import (
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/alibaba/hybridnet/pkg/ipam/types"
 )
@@ -26,6 +27,7 @@ type Interface interface {
 	Refresh
 	Usage
 	NetworkInterface
+	RangeReservation
 
 	Allocate(network, subnet, podName, podNamespace string) (*types.IP, error)
 	Assign(network, subnet, podname, podNamespace, ip string, forced bool) (*types.IP, error)
@@ -36,6 +38,12 @@ type Refresh interface {
 	Refresh(networks []string) error
 }
 
+// RangeReservation reserves ips of subnet for infrastructure at runtime, the reservations must be
+// persisted to be kept by refreshing
+type RangeReservation interface {
+	ReserveRange(network, subnet string, ips []string) error
+}
+
 type Usage interface {
 	Usage(network string) (*types.Usage, map[string]*types.Usage, error)
 	SubnetUsage(network, subnet string) (*types.Usage, error)
@@ -45,6 +53,7 @@ type DualStackInterface interface {
 	Refresh
 	DualStackUsage
 	NetworkInterface
+	RangeReservation
 
 	Allocate(ipFamilyMode types.IPFamilyMode, network string, subnets []string,
 		podName, podNamespace string) (IPs []*types.IP, err error)
@@ -65,6 +74,10 @@ type Store interface {
 	IPReserve(pod *v1.Pod) (err error)
 	IPRecycle(namespace string, ip *types.IP) (err error)
 	IPUnBind(namespace, ip string) (err error)
+	IPBind(namespace, podName string, ip *types.IP, owner *metav1.OwnerReference) (err error)
+	LoopbackIPBind(pod *v1.Pod, ip *types.IP) (err error)
+	IPSwapBind(pod *v1.Pod, ip, from *types.IP) (err error)
+	IPSwapCommit(pod *v1.Pod, ip, from *types.IP) (err error)
 	SyncNetworkUsage(name string, usage *types.Usage) (err error)
 	SyncSubnetUsage(name string, usage *types.Usage) (err error)
 	SyncNetworkStatus(name, nodes, subnets string) (err error)
@@ -77,6 +90,10 @@ type DualStackStore interface {
 	IPReserve(pod *v1.Pod) (err error)
 	IPRecycle(namespace string, ip *types.IP) (err error)
 	IPUnBind(namespace, ip string) (err error)
+	IPBind(namespace, podName string, IPs []*types.IP, owner *metav1.OwnerReference) (err error)
+	LoopbackIPBind(pod *v1.Pod, ip *types.IP) (err error)
+	IPSwapBind(pod *v1.Pod, ip, from *types.IP) (err error)
+	IPSwapCommit(pod *v1.Pod, ip, from *types.IP) (err error)
 	SyncNetworkUsage(name string, usages [3]*types.Usage) (err error)
 	SyncSubnetUsage(name string, usage *types.Usage) (err error)
 	SyncNetworkStatus(name, nodes, subnets string) (err error)
@@ -0,0 +1,152 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/alibaba/hybridnet/pkg/constants"
+	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+// PreserveIPAnnotations makes the ip annotations of the last allocation restored rather than removed
+// if new ips fail to be committed to pod, e.g., when a stateful pod is reallocated, it is set by flag
+// of manager
+var PreserveIPAnnotations bool
+
+// ipAnnotationKeys are the correlated annotations which are always patched together
+var ipAnnotationKeys = []string{
+	constants.AnnotationIP,
+	constants.AnnotationNetwork,
+	constants.AnnotationSubnet,
+}
+
+// ipAnnotationsOf returns a snapshot of ip annotations of pod, it must be taken before patching
+// because patching will overwrite the annotations of pod object
+func ipAnnotationsOf(pod *corev1.Pod) map[string]string {
+	annotations := make(map[string]string, len(ipAnnotationKeys))
+	for _, key := range ipAnnotationKeys {
+		if value, exist := pod.Annotations[key]; exist {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
+
+// rollbackIPAnnotations reverts the ip annotations of pod after new ips fail to be committed, the
+// previous ones are restored as a whole if preserved and the previous ips are still held by pod,
+// otherwise all of them are removed
+func (w *Worker) rollbackIPAnnotations(pod *corev1.Pod, previous map[string]string) error {
+	if !PreserveIPAnnotations || len(previous[constants.AnnotationIP]) == 0 {
+		return w.releaseIPFromPod(pod)
+	}
+
+	held, err := w.ipsHeldByPod(pod, previous[constants.AnnotationIP])
+	if err != nil {
+		return err
+	}
+	if !held {
+		// restoring released ips would make pod use ips which may have been allocated to others
+		return w.releaseIPFromPod(pod)
+	}
+
+	annotations := make(map[string]interface{}, len(ipAnnotationKeys))
+	for _, key := range ipAnnotationKeys {
+		if value, exist := previous[key]; exist {
+			annotations[key] = value
+		} else {
+			annotations[key] = nil
+		}
+	}
+
+	patchBody, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return w.Patch(context.TODO(), pod, client.RawPatch(types.MergePatchType, patchBody))
+	})
+}
+
+// ipsHeldByPod checks whether every ip in the ip annotation is still held by pod, i.e., its ip instance
+// is not released and still records the pod, an unparsable annotation is taken as not held
+func (w *Worker) ipsHeldByPod(pod *corev1.Pod, ipAnnotation string) (bool, error) {
+	ips, err := parseIPAnnotation(ipAnnotation)
+	if err != nil || len(ips) == 0 {
+		return false, nil
+	}
+
+	for _, ip := range ips {
+		ipInstance, err := w.getIP(pod.Namespace, ip)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		if !ipInstance.DeletionTimestamp.IsZero() || ipInstance.Status.PodName != pod.Name {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// parseIPAnnotation parses the ip annotation, which is a single ip or a list of ips for dual stack
+func parseIPAnnotation(ipAnnotation string) ([]*ipamtypes.IP, error) {
+	var ips []*ipamtypes.IP
+	if strings.HasPrefix(strings.TrimSpace(ipAnnotation), "[") {
+		if err := json.Unmarshal([]byte(ipAnnotation), &ips); err != nil {
+			return nil, err
+		}
+	} else {
+		ip := &ipamtypes.IP{}
+		if err := json.Unmarshal([]byte(ipAnnotation), ip); err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+
+	for _, ip := range ips {
+		if ip == nil || ip.Address == nil || ip.Address.IP == nil {
+			return nil, fmt.Errorf("no address in ip annotation %s", ipAnnotation)
+		}
+	}
+	return ips, nil
+}
+
+// correlationIDValueOf returns the json value of correlation id to patch along with ips, a stale one
+// is removed if pod is not coupled by an allocation attempt of manager
+func correlationIDValueOf(pod *corev1.Pod) string {
+	if correlationID := pod.Annotations[constants.AnnotationCorrelationID]; len(correlationID) > 0 {
+		return fmt.Sprintf("%q", correlationID)
+	}
+	return "null"
+}
@@ -0,0 +1,233 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+func TestIPAnnotationsOf(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    map[string]string
+	}{
+		{
+			name:        "no annotations",
+			annotations: nil,
+			expected:    map[string]string{},
+		},
+		{
+			name: "ip annotations",
+			annotations: map[string]string{
+				constants.AnnotationIP:      `{"ip":"192.168.0.10/24"}`,
+				constants.AnnotationNetwork: "network",
+				constants.AnnotationSubnet:  "subnet",
+				constants.AnnotationIPPool:  "192.168.0.10",
+			},
+			expected: map[string]string{
+				constants.AnnotationIP:      `{"ip":"192.168.0.10/24"}`,
+				constants.AnnotationNetwork: "network",
+				constants.AnnotationSubnet:  "subnet",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: test.annotations},
+			}
+			snapshot := ipAnnotationsOf(pod)
+			if !reflect.DeepEqual(snapshot, test.expected) {
+				t.Fatalf("expect %v, but got %v", test.expected, snapshot)
+			}
+
+			// snapshot must not change with pod
+			pod.Annotations = map[string]string{constants.AnnotationIP: "changed"}
+			if !reflect.DeepEqual(snapshot, test.expected) {
+				t.Fatalf("snapshot changed to %v", snapshot)
+			}
+		})
+	}
+}
+
+func TestCorrelationIDValueOf(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    string
+	}{
+		{"no correlation id", nil, "null"},
+		{"empty correlation id", map[string]string{constants.AnnotationCorrelationID: ""}, "null"},
+		{"correlation id", map[string]string{constants.AnnotationCorrelationID: "5f2c-3"}, `"5f2c-3"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: test.annotations}}
+			if value := correlationIDValueOf(pod); value != test.expected {
+				t.Fatalf("expect %v, but got %v", test.expected, value)
+			}
+		})
+	}
+}
+
+func annotationTestIP(cidr string) *ipamtypes.IP {
+	ip, ipNet, _ := net.ParseCIDR(cidr)
+	ipNet.IP = ip
+	return &ipamtypes.IP{Address: ipNet, Network: "network", Subnet: "subnet"}
+}
+
+func TestParseIPAnnotation(t *testing.T) {
+	v4, v6 := annotationTestIP("192.168.0.10/24"), annotationTestIP("fd00::10/64")
+
+	tests := []struct {
+		name      string
+		value     string
+		expected  []string
+		expectErr bool
+	}{
+		{"single ip", marshal(v4), []string{"192.168.0.10"}, false},
+		{"dual stack ips", marshalIPs([]*ipamtypes.IP{v4, v6}), []string{"192.168.0.10", "fd00::10"}, false},
+		{"invalid json", "{", nil, true},
+		{"no address", `{"Network":"network"}`, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ips, err := parseIPAnnotation(test.value)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expect error %v, but got %v", test.expectErr, err)
+			}
+			var addresses []string
+			for _, ip := range ips {
+				addresses = append(addresses, ip.Address.IP.String())
+			}
+			if !reflect.DeepEqual(addresses, test.expected) {
+				t.Fatalf("expect %v, but got %v", test.expected, addresses)
+			}
+		})
+	}
+}
+
+// rollbackClient serves ip instances and records the patches of pod
+type rollbackClient struct {
+	client.Client
+	ipInstances map[string]*networkingv1.IPInstance
+	podPatches  []string
+}
+
+func (r *rollbackClient) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	if ipInstance, exist := r.ipInstances[key.Name]; exist {
+		ipInstance.DeepCopyInto(obj.(*networkingv1.IPInstance))
+		return nil
+	}
+	return apierrors.NewNotFound(schema.GroupResource{}, key.Name)
+}
+
+func (r *rollbackClient) Patch(_ context.Context, obj client.Object, patch client.Patch, _ ...client.PatchOption) error {
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	r.podPatches = append(r.podPatches, string(data))
+	return nil
+}
+
+func (r *rollbackClient) Status() client.StatusWriter {
+	return rollbackStatusWriter{}
+}
+
+type rollbackStatusWriter struct {
+	client.StatusWriter
+}
+
+func (rollbackStatusWriter) Patch(_ context.Context, _ client.Object, _ client.Patch, _ ...client.PatchOption) error {
+	return nil
+}
+
+func TestRollbackIPAnnotations(t *testing.T) {
+	previousIP := annotationTestIP("192.168.0.10/24")
+	previous := map[string]string{
+		constants.AnnotationIP:      marshal(previousIP),
+		constants.AnnotationNetwork: "network",
+		constants.AnnotationSubnet:  "subnet",
+	}
+	ipInstanceOf := func(podName string, deleting bool) *networkingv1.IPInstance {
+		ipInstance := &networkingv1.IPInstance{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "192-168-0-10"},
+			Status:     networkingv1.IPInstanceStatus{PodName: podName},
+		}
+		if deleting {
+			now := metav1.Now()
+			ipInstance.DeletionTimestamp = &now
+		}
+		return ipInstance
+	}
+
+	tests := []struct {
+		name          string
+		preserve      bool
+		ipInstance    *networkingv1.IPInstance
+		expectRestore bool
+	}{
+		{"not preserved", false, ipInstanceOf("pod1", false), false},
+		{"previous ip held by pod", true, ipInstanceOf("pod1", false), true},
+		{"previous ip released", true, nil, false},
+		{"previous ip being released", true, ipInstanceOf("pod1", true), false},
+		{"previous ip taken by another pod", true, ipInstanceOf("pod2", false), false},
+	}
+
+	defer func(preserve bool) { PreserveIPAnnotations = preserve }(PreserveIPAnnotations)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			PreserveIPAnnotations = test.preserve
+			c := &rollbackClient{ipInstances: map[string]*networkingv1.IPInstance{}}
+			if test.ipInstance != nil {
+				c.ipInstances[test.ipInstance.Name] = test.ipInstance
+			}
+			w := &Worker{Client: c}
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1"}}
+
+			if err := w.rollbackIPAnnotations(pod, previous); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(c.podPatches) != 1 {
+				t.Fatalf("expect a single patch of pod, but got %v", c.podPatches)
+			}
+			restored := strings.Contains(c.podPatches[0], "192.168.0.10")
+			if restored != test.expectRestore {
+				t.Fatalf("expect restored %v, but got patch %s", test.expectRestore, c.podPatches[0])
+			}
+		})
+	}
+}
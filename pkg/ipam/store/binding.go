@@ -0,0 +1,111 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"net"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+// IPBindingOwnerOf returns the controller reference to ip binding, which owns the ip instances
+// reserved for it
+func IPBindingOwnerOf(binding *networkingv1.IPBinding) *metav1.OwnerReference {
+	return newControllerRef(binding, networkingv1.GroupVersion.WithKind("IPBinding"))
+}
+
+// IPImportOwnerOf returns the controller reference to ip import, which owns the ip instances
+// imported by it until they are used by pods
+func IPImportOwnerOf(ipImport *networkingv1.IPImport) *metav1.OwnerReference {
+	return newControllerRef(ipImport, networkingv1.GroupVersion.WithKind("IPImport"))
+}
+
+// IPBind creates the ip instance of ip reserved for pod by name, the pod may not exist yet
+func (w *Worker) IPBind(namespace, podName string, ip *ipamtypes.IP, owner *metav1.OwnerReference) error {
+	return w.bindIPs(namespace, podName, []*ipamtypes.IP{ip}, owner)
+}
+
+// IPBind creates the ip instances of ips reserved for pod by name, the pod may not exist yet
+func (d *DualStackWorker) IPBind(namespace, podName string, IPs []*ipamtypes.IP, owner *metav1.OwnerReference) error {
+	return d.worker.bindIPs(namespace, podName, IPs, owner)
+}
+
+// bindIPs creates reserved ip instances sharing the same MAC address, they are owned by the
+// ip binding or ip import instead of pod so that they are kept across incarnations of pod
+func (w *Worker) bindIPs(namespace, podName string, IPs []*ipamtypes.IP, owner *metav1.OwnerReference) (err error) {
+	// pod may not exist yet, so ips are reviewed and claimed for pod by name
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      podName,
+		},
+	}
+	if err = reviewAllocation(pod, IPs); err != nil {
+		return err
+	}
+
+	var releaseClaims func()
+	if releaseClaims, err = claimIPs(pod, IPs); err != nil {
+		return err
+	}
+
+	var ipInstances []*networkingv1.IPInstance
+	defer func() {
+		if err != nil {
+			for _, ipi := range ipInstances {
+				_ = w.deleteIP(ipi.Namespace, ipi.Name)
+			}
+			releaseClaims()
+		}
+	}()
+
+	var macAddr string
+	if macAddr, err = w.generateMAC(networkOfIPs(IPs), IPs); err != nil {
+		return err
+	}
+
+	for _, ip := range IPs {
+		ipInstance := newIPInstance(namespace, podName, "", ip, macAddr, owner)
+		if err = w.Create(context.TODO(), ipInstance); err != nil {
+			return err
+		}
+		ipInstances = append(ipInstances, ipInstance)
+	}
+
+	for _, ipi := range ipInstances {
+		if err = w.updateIPStatus(ipi, "", podName, namespace, string(networkingv1.IPPhaseReserved)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IPInstanceNameOf returns the name of ip instance for the address
+func IPInstanceNameOf(address net.IP) string {
+	return toDNSLabelFormat(&ipamtypes.IP{Address: &net.IPNet{IP: address}})
+}
+
+// IsBoundIPInstance checks whether ip instance is reserved for an ip binding
+func IsBoundIPInstance(ipInstance *networkingv1.IPInstance) bool {
+	ref := metav1.GetControllerOf(ipInstance)
+	return ref != nil && ref.Kind == "IPBinding" && ref.APIVersion == networkingv1.GroupVersion.String()
+}
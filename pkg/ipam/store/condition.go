@@ -0,0 +1,113 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/alibaba/hybridnet/pkg/constants"
+	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+const ReasonPodConditionPatchFailed = "PodConditionPatchFailed"
+
+var (
+	eventRecorderLock sync.RWMutex
+	eventRecorder     record.EventRecorder
+)
+
+// SetEventRecorder makes failures of patching pod conditions recorded as events of pod, it is expected
+// to be called on initialization of manager, nil means only logging
+func SetEventRecorder(recorder record.EventRecorder) {
+	eventRecorderLock.Lock()
+	defer eventRecorderLock.Unlock()
+
+	eventRecorder = recorder
+}
+
+func getEventRecorder() record.EventRecorder {
+	eventRecorderLock.RLock()
+	defer eventRecorderLock.RUnlock()
+
+	return eventRecorder
+}
+
+// patchIPAllocatedCondition will set the ip allocated condition of pod status with where the ips come
+// from, it is patched after annotations so that observers of the condition see the committed placement
+func (w *Worker) patchIPAllocatedCondition(pod *corev1.Pod, IPs []*ipamtypes.IP) {
+	var addresses []string
+	for _, ip := range IPs {
+		addresses = append(addresses, ip.Address.IP.String())
+	}
+
+	w.patchPodCondition(pod, corev1.PodCondition{
+		Type:   constants.PodConditionIPAllocated,
+		Status: corev1.ConditionTrue,
+		Reason: constants.ReasonIPAllocated,
+		Message: fmt.Sprintf("network %s, subnet %s, ip %s",
+			networkOfIPs(IPs), joinSubnetsOfIPs(IPs), strings.Join(addresses, ",")),
+	})
+}
+
+// patchIPReleasedCondition will set the ip allocated condition of pod status to false
+func (w *Worker) patchIPReleasedCondition(pod *corev1.Pod) {
+	w.patchPodCondition(pod, corev1.PodCondition{
+		Type:   constants.PodConditionIPAllocated,
+		Status: corev1.ConditionFalse,
+		Reason: constants.ReasonIPReleased,
+	})
+}
+
+// patchPodCondition is best-effort, because the condition only reports the ip annotations which have
+// been committed, and failing the whole allocation for it would roll back ips in use
+func (w *Worker) patchPodCondition(pod *corev1.Pod, condition corev1.PodCondition) {
+	if err := w.doPatchPodCondition(pod, condition); err != nil {
+		log.Log.WithName("pod-condition").Error(err, "unable to patch pod condition, ignore it",
+			"pod", pod.Namespace+"/"+pod.Name, "condition", condition.Type)
+		if recorder := getEventRecorder(); recorder != nil {
+			recorder.Eventf(pod, corev1.EventTypeWarning, ReasonPodConditionPatchFailed,
+				"unable to set condition %s to %s: %v", condition.Type, condition.Status, err)
+		}
+	}
+}
+
+func (w *Worker) doPatchPodCondition(pod *corev1.Pod, condition corev1.PodCondition) error {
+	condition.LastTransitionTime = metav1.Now()
+
+	// conditions are merged by type with strategic merge patch, the others are kept
+	patchBody, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []corev1.PodCondition{condition},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.Status().Patch(context.TODO(), pod, client.RawPatch(types.StrategicMergePatchType, patchBody))
+}
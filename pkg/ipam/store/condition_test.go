@@ -0,0 +1,66 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type statusFailingClient struct {
+	client.Client
+}
+
+func (statusFailingClient) Status() client.StatusWriter {
+	return failingStatusWriter{}
+}
+
+type failingStatusWriter struct {
+	client.StatusWriter
+}
+
+func (failingStatusWriter) Patch(_ context.Context, _ client.Object, _ client.Patch, _ ...client.PatchOption) error {
+	return fmt.Errorf("forbidden")
+}
+
+func TestPatchPodConditionIsBestEffort(t *testing.T) {
+	recorder := record.NewFakeRecorder(1)
+	SetEventRecorder(recorder)
+	defer SetEventRecorder(nil)
+
+	w := &Worker{Client: statusFailingClient{}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1"}}
+
+	// failure of patching condition must not panic or be returned, but recorded instead
+	w.patchIPReleasedCondition(pod)
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, ReasonPodConditionPatchFailed) {
+			t.Errorf("unexpected event %q", event)
+		}
+	default:
+		t.Errorf("expected an event of patch failure")
+	}
+}
@@ -0,0 +1,92 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package store
+
+import (
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+// IPCoordinator guarantees the uniqueness of ips across clusters sharing the same address space,
+// e.g., by claiming ips against a store shared by the clusters
+type IPCoordinator interface {
+	// Claim is called right before ips are committed to pod, and ips will not be committed if it fails.
+	// Claiming ips already claimed by the same pod must succeed. Returning an *AllocationRejectedError
+	// means ips are claimed by another cluster, so other ips will be tried.
+	Claim(pod *corev1.Pod, ips []*ipamtypes.IP) error
+	// Release is called once ips are recycled by this cluster, or fail to be committed after claimed.
+	Release(network string, ips []string) error
+}
+
+// noopIPCoordinator is used if no coordinator is set, every ip is unique in cluster scope
+type noopIPCoordinator struct{}
+
+func (noopIPCoordinator) Claim(_ *corev1.Pod, _ []*ipamtypes.IP) error { return nil }
+
+func (noopIPCoordinator) Release(_ string, _ []string) error { return nil }
+
+var (
+	ipCoordinatorLock sync.RWMutex
+	ipCoordinator     IPCoordinator = noopIPCoordinator{}
+)
+
+// SetIPCoordinator makes ips claimed by coordinator before committed, it is expected to be
+// called on initialization of manager, nil means no coordination
+func SetIPCoordinator(coordinator IPCoordinator) {
+	ipCoordinatorLock.Lock()
+	defer ipCoordinatorLock.Unlock()
+
+	if coordinator == nil {
+		coordinator = noopIPCoordinator{}
+	}
+	ipCoordinator = coordinator
+}
+
+func getIPCoordinator() IPCoordinator {
+	ipCoordinatorLock.RLock()
+	defer ipCoordinatorLock.RUnlock()
+
+	return ipCoordinator
+}
+
+// ReleaseClaimedIPs gives back the claims of recycled ips to coordinator
+func ReleaseClaimedIPs(network string, ips []string) error {
+	return getIPCoordinator().Release(network, ips)
+}
+
+// claimIPs claims ips for pod from coordinator, the returned function releases the claims
+// and should be called if ips fail to be committed
+func claimIPs(pod *corev1.Pod, ips []*ipamtypes.IP) (func(), error) {
+	coordinator := getIPCoordinator()
+	if err := coordinator.Claim(pod, ips); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if len(ips) == 0 {
+			return
+		}
+		addresses := make([]string, 0, len(ips))
+		for _, ip := range ips {
+			addresses = append(addresses, ip.Address.IP.String())
+		}
+		_ = coordinator.Release(ips[0].Network, addresses)
+	}, nil
+}
@@ -0,0 +1,133 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package store
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+type fakeIPCoordinator struct {
+	claimErr error
+	claimed  map[string]string
+}
+
+func (f *fakeIPCoordinator) Claim(pod *corev1.Pod, ips []*ipamtypes.IP) error {
+	if f.claimErr != nil {
+		return f.claimErr
+	}
+	for _, ip := range ips {
+		f.claimed[ip.Address.IP.String()] = pod.Namespace + "/" + pod.Name
+	}
+	return nil
+}
+
+func (f *fakeIPCoordinator) Release(_ string, ips []string) error {
+	for _, ip := range ips {
+		delete(f.claimed, ip)
+	}
+	return nil
+}
+
+func TestClaimIPs(t *testing.T) {
+	defer SetIPCoordinator(nil)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "ns"},
+	}
+	_, cidr, _ := net.ParseCIDR("192.168.0.0/24")
+	ips := []*ipamtypes.IP{
+		{
+			Address: &net.IPNet{IP: net.ParseIP("192.168.0.10"), Mask: cidr.Mask},
+			Network: "network",
+			Subnet:  "subnet",
+		},
+	}
+
+	tests := []struct {
+		name           string
+		coordinator    *fakeIPCoordinator
+		expectErr      bool
+		expectClaimed  map[string]string
+		expectRollback map[string]string
+	}{
+		{
+			name:           "claimed",
+			coordinator:    &fakeIPCoordinator{claimed: map[string]string{}},
+			expectClaimed:  map[string]string{"192.168.0.10": "ns/pod"},
+			expectRollback: map[string]string{},
+		},
+		{
+			name: "claimed by another cluster",
+			coordinator: &fakeIPCoordinator{
+				claimErr: &AllocationRejectedError{IPs: []string{"192.168.0.10"}, Reason: "in use"},
+				claimed:  map[string]string{},
+			},
+			expectErr:     true,
+			expectClaimed: map[string]string{},
+		},
+		{
+			name: "coordinator fails",
+			coordinator: &fakeIPCoordinator{
+				claimErr: fmt.Errorf("unavailable"),
+				claimed:  map[string]string{},
+			},
+			expectErr:     true,
+			expectClaimed: map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			SetIPCoordinator(test.coordinator)
+
+			rollback, err := claimIPs(pod, ips)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expect error %v, but got %v", test.expectErr, err)
+			}
+			if !reflect.DeepEqual(test.coordinator.claimed, test.expectClaimed) {
+				t.Fatalf("expect claimed %v, but got %v", test.expectClaimed, test.coordinator.claimed)
+			}
+			if err != nil {
+				return
+			}
+
+			rollback()
+			if !reflect.DeepEqual(test.coordinator.claimed, test.expectRollback) {
+				t.Fatalf("expect claimed %v after rollback, but got %v", test.expectRollback, test.coordinator.claimed)
+			}
+		})
+	}
+}
+
+func TestNoopIPCoordinator(t *testing.T) {
+	SetIPCoordinator(nil)
+
+	if _, err := claimIPs(&corev1.Pod{}, nil); err != nil {
+		t.Fatalf("expect no error, but got %v", err)
+	}
+	if err := ReleaseClaimedIPs("network", []string{"192.168.0.10"}); err != nil {
+		t.Fatalf("expect no error, but got %v", err)
+	}
+}
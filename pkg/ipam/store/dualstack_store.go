@@ -20,6 +20,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -30,7 +31,6 @@ import (
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
 	"github.com/alibaba/hybridnet/pkg/constants"
 	"github.com/alibaba/hybridnet/pkg/ipam/types"
-	"github.com/alibaba/hybridnet/pkg/utils/mac"
 )
 
 type DualStackWorker struct {
@@ -50,18 +50,36 @@ func NewDualStackWorker(c client.Client) *DualStackWorker {
 func (d *DualStackWorker) Couple(pod *v1.Pod, IPs []*types.IP) (err error) {
 	var ipInstances []*networkingv1.IPInstance
 
+	if err = d.worker.checkIPInstanceLimit(pod, IPs); err != nil {
+		return err
+	}
+
+	if err = reviewAllocation(pod, IPs); err != nil {
+		return err
+	}
+
+	var releaseClaims func()
+	if releaseClaims, err = claimIPs(pod, IPs); err != nil {
+		return err
+	}
+
 	defer func() {
 		if err != nil {
 			for _, ipi := range ipInstances {
 				_ = d.worker.deleteIP(ipi.Namespace, ipi.Name)
 			}
+			releaseClaims()
 		}
 	}()
 
-	var globalMac = mac.GenerateMAC().String()
+	var globalMac string
+	if globalMac, err = d.worker.generateMAC(networkOfIPs(IPs), IPs); err != nil {
+		return err
+	}
+	var workload = d.worker.workloadOwnerOf(pod)
 	for _, ip := range IPs {
 		var ipIns *networkingv1.IPInstance
-		if ipIns, err = d.worker.createIPWithMAC(pod, ip, globalMac); err != nil {
+		if ipIns, err = d.worker.createIPWithMAC(pod, ip, globalMac, workload); err != nil {
 			return err
 		}
 		ipInstances = append(ipInstances, ipIns)
@@ -73,20 +91,39 @@ func (d *DualStackWorker) Couple(pod *v1.Pod, IPs []*types.IP) (err error) {
 		}
 	}
 
+	previousAnnotations := ipAnnotationsOf(pod)
 	defer func() {
 		if err != nil {
-			_ = d.worker.releaseIPFromPod(pod)
+			_ = d.worker.rollbackIPAnnotations(pod, previousAnnotations)
 		}
 	}()
 
-	return d.patchIPsToPod(pod, IPs)
+	if err = d.patchIPsToPod(pod, IPs); err != nil {
+		return err
+	}
+
+	countAllocatedIPs(IPs)
+	return nil
 }
 
 func (d *DualStackWorker) ReCouple(pod *v1.Pod, IPs []*types.IP) (err error) {
 	var ipInstances []*networkingv1.IPInstance
 	var missingIPs []*types.IP
 
-	var globalMac = mac.GenerateMAC().String()
+	if err = d.worker.checkIPInstanceLimit(pod, IPs); err != nil {
+		return err
+	}
+
+	// both reused and missing ips are committed to pod, which are reviewed as a new allocation
+	if err = reviewAllocation(pod, IPs); err != nil {
+		return err
+	}
+
+	var globalMac string
+	if globalMac, err = d.worker.generateMAC(networkOfIPs(IPs), IPs); err != nil {
+		return err
+	}
+	var workload = d.worker.workloadOwnerOf(pod)
 	for _, ip := range IPs {
 		var ipIns *networkingv1.IPInstance
 		if ipIns, err = d.worker.getIP(pod.Namespace, ip); err != nil {
@@ -104,16 +141,34 @@ func (d *DualStackWorker) ReCouple(pod *v1.Pod, IPs []*types.IP) (err error) {
 		globalMac = ipIns.Spec.Address.MAC
 	}
 
-	for _, ip := range missingIPs {
-		var ipIns *networkingv1.IPInstance
-		if ipIns, err = d.worker.createIPWithMAC(pod, ip, globalMac); err != nil {
-			return
+	if len(missingIPs) > 0 {
+		var releaseClaims func()
+		if releaseClaims, err = claimIPs(pod, missingIPs); err != nil {
+			return err
+		}
+
+		var createdIPInstances []*networkingv1.IPInstance
+		defer func() {
+			if err != nil {
+				for _, ipi := range createdIPInstances {
+					_ = d.worker.deleteIP(ipi.Namespace, ipi.Name)
+				}
+				releaseClaims()
+			}
+		}()
+
+		for _, ip := range missingIPs {
+			var ipIns *networkingv1.IPInstance
+			if ipIns, err = d.worker.createIPWithMAC(pod, ip, globalMac, workload); err != nil {
+				return
+			}
+			createdIPInstances = append(createdIPInstances, ipIns)
+			ipInstances = append(ipInstances, ipIns)
 		}
-		ipInstances = append(ipInstances, ipIns)
 	}
 
 	for _, ipi := range ipInstances {
-		if err = d.worker.patchIPLabels(ipi, pod.Name, pod.Spec.NodeName); err != nil {
+		if err = d.worker.patchIPLabels(ipi, pod.Name, pod.Spec.NodeName, workload); err != nil {
 			return err
 		}
 	}
@@ -179,19 +234,53 @@ func (d *DualStackWorker) SyncNetworkStatus(name, nodes, subnets string) (err er
 }
 
 func (d *DualStackWorker) patchIPsToPod(pod *v1.Pod, IPs []*types.IP) error {
-	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		return d.Patch(context.TODO(),
 			pod,
 			client.RawPatch(
 				apitypes.MergePatchType,
 				[]byte(fmt.Sprintf(
-					`{"metadata":{"annotations":{%q:%q}}}`,
+					`{"metadata":{"annotations":{%q:%q,%q:%q,%q:%q,%q:null,%q:null,%q:%s}}}`,
 					constants.AnnotationIP,
 					marshalIPs(IPs),
+					constants.AnnotationNetwork,
+					networkOfIPs(IPs),
+					constants.AnnotationSubnet,
+					joinSubnetsOfIPs(IPs),
+					constants.AnnotationAllocationFailure,
+					constants.AnnotationNetworkExhausted,
+					constants.AnnotationCorrelationID,
+					correlationIDValueOf(pod),
 				)),
 			),
 		)
-	})
+	}); err != nil {
+		return err
+	}
+
+	d.worker.patchIPAllocatedCondition(pod, IPs)
+	return nil
+}
+
+func networkOfIPs(IPs []*types.IP) string {
+	if len(IPs) == 0 {
+		return ""
+	}
+	return IPs[0].Network
+}
+
+// joinSubnetsOfIPs joins subnets of IPs with ipv4 first, which is the same
+// format with specified-subnet annotation
+func joinSubnetsOfIPs(IPs []*types.IP) string {
+	var v4Subnets, v6Subnets []string
+	for _, ip := range IPs {
+		if ip.IsIPv6() {
+			v6Subnets = append(v6Subnets, ip.Subnet)
+		} else {
+			v4Subnets = append(v4Subnets, ip.Subnet)
+		}
+	}
+	return strings.Join(append(v4Subnets, v6Subnets...), "/")
 }
 
 func marshalIPs(IPs []*types.IP) string {
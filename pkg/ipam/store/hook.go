@@ -0,0 +1,175 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+const (
+	// AllocationHookAPIVersion is the version of allocation hook request/response schema
+	AllocationHookAPIVersion = "v1"
+
+	// AllocationHookFailurePolicyIgnore means ips are committed if allocation hook fails to answer
+	AllocationHookFailurePolicyIgnore = "Ignore"
+	// AllocationHookFailurePolicyFail means allocation fails if allocation hook fails to answer
+	AllocationHookFailurePolicyFail = "Fail"
+)
+
+var (
+	// AllocationHookURL is the url of allocation hook, empty means allocation hook is disabled
+	AllocationHookURL string
+	// AllocationHookTimeout is the timeout of calling allocation hook
+	AllocationHookTimeout = 3 * time.Second
+	// AllocationHookFailurePolicy decides what to do if allocation hook fails to answer
+	AllocationHookFailurePolicy = AllocationHookFailurePolicyIgnore
+)
+
+// AllocationHookRequest is posted to allocation hook as json with the proposed binding of pod and ips
+type AllocationHookRequest struct {
+	APIVersion   string             `json:"apiVersion"`
+	PodName      string             `json:"podName"`
+	PodNamespace string             `json:"podNamespace"`
+	PodUID       string             `json:"podUID"`
+	NodeName     string             `json:"nodeName"`
+	IPs          []AllocationHookIP `json:"ips"`
+}
+
+type AllocationHookIP struct {
+	Address string `json:"address"`
+	Gateway string `json:"gateway,omitempty"`
+	Version string `json:"version"`
+	Network string `json:"network"`
+	Subnet  string `json:"subnet"`
+}
+
+// AllocationHookResponse is expected from allocation hook with status code 200
+type AllocationHookResponse struct {
+	APIVersion string `json:"apiVersion"`
+	Allowed    bool   `json:"allowed"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// AllocationRejectedError means the proposed ips are rejected by allocation hook,
+// so other ips can be tried
+type AllocationRejectedError struct {
+	IPs    []string
+	Reason string
+}
+
+func (a *AllocationRejectedError) Error() string {
+	return fmt.Sprintf("ips %v are rejected by allocation hook: %s", a.IPs, a.Reason)
+}
+
+// IsAllocationRejected checks whether an allocation rejection exists in error chain
+func IsAllocationRejected(err error) bool {
+	var rejectedError *AllocationRejectedError
+	return errors.As(err, &rejectedError)
+}
+
+func newAllocationHookRequest(pod *corev1.Pod, ips []*ipamtypes.IP) *AllocationHookRequest {
+	hookRequest := &AllocationHookRequest{
+		APIVersion:   AllocationHookAPIVersion,
+		PodName:      pod.Name,
+		PodNamespace: pod.Namespace,
+		PodUID:       string(pod.UID),
+		NodeName:     pod.Spec.NodeName,
+		IPs:          make([]AllocationHookIP, 0, len(ips)),
+	}
+
+	for _, ip := range ips {
+		hookIP := AllocationHookIP{
+			Address: ip.Address.String(),
+			Version: string(extractIPVersion(ip)),
+			Network: ip.Network,
+			Subnet:  ip.Subnet,
+		}
+		if ip.Gateway != nil {
+			hookIP.Gateway = ip.Gateway.String()
+		}
+		hookRequest.IPs = append(hookRequest.IPs, hookIP)
+	}
+	return hookRequest
+}
+
+// reviewAllocation asks allocation hook whether ips can be committed to pod, an AllocationRejectedError
+// will be returned if rejected, failures of allocation hook are handled by failure policy
+func reviewAllocation(pod *corev1.Pod, ips []*ipamtypes.IP) error {
+	if len(AllocationHookURL) == 0 {
+		return nil
+	}
+
+	hookResponse, err := callAllocationHook(AllocationHookURL, AllocationHookTimeout, newAllocationHookRequest(pod, ips))
+	if err != nil {
+		if AllocationHookFailurePolicy == AllocationHookFailurePolicyFail {
+			return fmt.Errorf("allocation hook fails: %v", err)
+		}
+		log.Log.WithName("allocation-hook").Error(err, "allocation hook fails, ignore it",
+			"pod", pod.Namespace+"/"+pod.Name)
+		return nil
+	}
+
+	if !hookResponse.Allowed {
+		rejectedError := &AllocationRejectedError{
+			Reason: hookResponse.Reason,
+		}
+		for _, ip := range ips {
+			rejectedError.IPs = append(rejectedError.IPs, ip.Address.IP.String())
+		}
+		return rejectedError
+	}
+	return nil
+}
+
+func callAllocationHook(url string, timeout time.Duration, hookRequest *AllocationHookRequest) (*AllocationHookResponse, error) {
+	body, err := json.Marshal(hookRequest)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal allocation hook request: %v", err)
+	}
+
+	httpClient := &http.Client{Timeout: timeout}
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	hookResponse := &AllocationHookResponse{}
+	if err = json.NewDecoder(resp.Body).Decode(hookResponse); err != nil {
+		return nil, fmt.Errorf("unable to decode allocation hook response: %v", err)
+	}
+
+	if !strings.EqualFold(hookResponse.APIVersion, AllocationHookAPIVersion) {
+		return nil, fmt.Errorf("unsupported api version %q of allocation hook response", hookResponse.APIVersion)
+	}
+	return hookResponse, nil
+}
@@ -0,0 +1,227 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+func TestReviewAllocation(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "ns"},
+		Spec:       corev1.PodSpec{NodeName: "node1"},
+	}
+	_, cidr, _ := net.ParseCIDR("192.168.0.0/24")
+	ips := []*ipamtypes.IP{
+		{
+			Address: &net.IPNet{IP: net.ParseIP("192.168.0.2").To4(), Mask: cidr.Mask},
+			Gateway: net.ParseIP("192.168.0.1"),
+			Subnet:  "subnet1",
+			Network: "net1",
+		},
+	}
+
+	tests := []struct {
+		name          string
+		statusCode    int
+		response      interface{}
+		failurePolicy string
+		expectReject  bool
+		expectError   bool
+	}{
+		{
+			"allowed",
+			http.StatusOK,
+			AllocationHookResponse{APIVersion: AllocationHookAPIVersion, Allowed: true},
+			AllocationHookFailurePolicyIgnore,
+			false,
+			false,
+		},
+		{
+			"rejected",
+			http.StatusOK,
+			AllocationHookResponse{APIVersion: AllocationHookAPIVersion, Allowed: false, Reason: "in use"},
+			AllocationHookFailurePolicyIgnore,
+			true,
+			true,
+		},
+		{
+			"hook fails with ignore policy",
+			http.StatusInternalServerError,
+			nil,
+			AllocationHookFailurePolicyIgnore,
+			false,
+			false,
+		},
+		{
+			"hook fails with fail policy",
+			http.StatusInternalServerError,
+			nil,
+			AllocationHookFailurePolicyFail,
+			false,
+			true,
+		},
+		{
+			"unsupported api version with fail policy",
+			http.StatusOK,
+			AllocationHookResponse{APIVersion: "v2", Allowed: true},
+			AllocationHookFailurePolicyFail,
+			false,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hookRequest := &AllocationHookRequest{}
+				if err := json.NewDecoder(r.Body).Decode(hookRequest); err != nil {
+					t.Errorf("unable to decode request: %v", err)
+				}
+				if hookRequest.PodName != pod.Name || len(hookRequest.IPs) != 1 || hookRequest.IPs[0].Address != "192.168.0.2/24" {
+					t.Errorf("unexpected request %+v", hookRequest)
+				}
+
+				w.WriteHeader(test.statusCode)
+				if test.response != nil {
+					_ = json.NewEncoder(w).Encode(test.response)
+				}
+			}))
+			defer server.Close()
+
+			AllocationHookURL, AllocationHookTimeout, AllocationHookFailurePolicy = server.URL, time.Second, test.failurePolicy
+			defer func() {
+				AllocationHookURL = ""
+			}()
+
+			err := reviewAllocation(pod, ips)
+			if (err != nil) != test.expectError {
+				t.Fatalf("test %s fails: expected error %v but got %v", test.name, test.expectError, err)
+			}
+			if IsAllocationRejected(err) != test.expectReject {
+				t.Errorf("test %s fails: expected rejection %v but got %v", test.name, test.expectReject, err)
+			}
+		})
+	}
+}
+
+// existingIPClient finds every ip instance, so that ips are taken as reused ones
+type existingIPClient struct {
+	client.Client
+}
+
+func (existingIPClient) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	ipInstance := obj.(*networkingv1.IPInstance)
+	ipInstance.Namespace, ipInstance.Name = key.Namespace, key.Name
+	ipInstance.Spec.Address.MAC = "00:00:00:00:00:01"
+	return nil
+}
+
+func TestAllocationReviewedOnEveryCommit(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "ns"},
+		Spec:       corev1.PodSpec{NodeName: "node1"},
+	}
+	_, cidr, _ := net.ParseCIDR("192.168.0.0/24")
+	ip := &ipamtypes.IP{
+		Address: &net.IPNet{IP: net.ParseIP("192.168.0.2").To4(), Mask: cidr.Mask},
+		Gateway: net.ParseIP("192.168.0.1"),
+		Subnet:  "subnet1",
+		Network: "net1",
+	}
+	from := &ipamtypes.IP{
+		Address: &net.IPNet{IP: net.ParseIP("192.168.0.3").To4(), Mask: cidr.Mask},
+		Gateway: net.ParseIP("192.168.0.1"),
+		Subnet:  "subnet1",
+		Network: "net1",
+	}
+
+	worker := NewWorker(existingIPClient{})
+	dualStackWorker := NewDualStackWorker(existingIPClient{})
+
+	tests := []struct {
+		name   string
+		commit func() error
+	}{
+		{
+			"recouple with reused ip",
+			func() error { return worker.ReCouple(pod, ip) },
+		},
+		{
+			"dual stack recouple with reused ips",
+			func() error { return dualStackWorker.ReCouple(pod, []*ipamtypes.IP{ip}) },
+		},
+		{
+			"bind ips for pod by name",
+			func() error { return dualStackWorker.IPBind(pod.Namespace, pod.Name, []*ipamtypes.IP{ip}, nil) },
+		},
+		{
+			"bind loopback ip",
+			func() error { return dualStackWorker.LoopbackIPBind(pod, ip) },
+		},
+		{
+			"bind swap ip",
+			func() error { return worker.bindSwapIP(pod, ip, from) },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var reviewed bool
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hookRequest := &AllocationHookRequest{}
+				if err := json.NewDecoder(r.Body).Decode(hookRequest); err != nil {
+					t.Errorf("unable to decode request: %v", err)
+				}
+				if hookRequest.PodName != pod.Name || hookRequest.PodNamespace != pod.Namespace ||
+					len(hookRequest.IPs) != 1 || hookRequest.IPs[0].Address != "192.168.0.2/24" {
+					t.Errorf("unexpected request %+v", hookRequest)
+				}
+				reviewed = true
+
+				_ = json.NewEncoder(w).Encode(AllocationHookResponse{APIVersion: AllocationHookAPIVersion, Allowed: false, Reason: "in use"})
+			}))
+			defer server.Close()
+
+			AllocationHookURL, AllocationHookTimeout, AllocationHookFailurePolicy = server.URL, time.Second, AllocationHookFailurePolicyIgnore
+			defer func() {
+				AllocationHookURL = ""
+			}()
+
+			err := test.commit()
+			if !reviewed {
+				t.Fatalf("test %s fails: allocation hook is not called", test.name)
+			}
+			if !IsAllocationRejected(err) {
+				t.Errorf("test %s fails: expected rejection but got %v", test.name, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+// MaxIPInstancesPerPod is the hard limit of ip instances coupled with a pod, which bounds the damage
+// of a runaway allocation loop, zero means no limit
+var MaxIPInstancesPerPod int
+
+func init() {
+	pflag.IntVar(&MaxIPInstancesPerPod, "max-ip-instances-per-pod", 0, "The max count of ip instances coupled with a pod, "+
+		"coupling more ips will be rejected, zero means no limit.")
+}
+
+// CheckIPInstanceLimit returns error if count of ip instances coupled with pod exceeds the limit
+func CheckIPInstanceLimit(namespace, name string, count int) error {
+	if MaxIPInstancesPerPod > 0 && count > MaxIPInstancesPerPod {
+		return fmt.Errorf("pod %s/%s has %d ip instances, exceeding the limit %d", namespace, name, count, MaxIPInstancesPerPod)
+	}
+	return nil
+}
+
+// checkIPInstanceLimit checks whether the ip instances of pod will exceed the limit after ips are
+// coupled, reserved ones are not counted because they are not coupled with pod
+func (w *Worker) checkIPInstanceLimit(pod *corev1.Pod, ips []*ipamtypes.IP) error {
+	if MaxIPInstancesPerPod <= 0 {
+		return nil
+	}
+
+	var coupling = map[string]bool{}
+	for _, ip := range ips {
+		coupling[toDNSLabelFormat(ip)] = true
+	}
+
+	var ipInstanceList = &networkingv1.IPInstanceList{}
+	if err := w.List(context.TODO(),
+		ipInstanceList,
+		client.MatchingLabels{
+			constants.LabelPod: pod.Name,
+		},
+		client.InNamespace(pod.Namespace),
+	); err != nil {
+		return err
+	}
+
+	var count = len(coupling)
+	for i := range ipInstanceList.Items {
+		var ipInstance = &ipInstanceList.Items[i]
+		if coupling[ipInstance.Name] || !ipInstance.DeletionTimestamp.IsZero() ||
+			ipInstance.Status.Phase == networkingv1.IPPhaseReserved {
+			continue
+		}
+		count++
+	}
+
+	return CheckIPInstanceLimit(pod.Namespace, pod.Name, count)
+}
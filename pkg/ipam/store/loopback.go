@@ -0,0 +1,79 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+// LoopbackIPBind creates the ip instance of loopback ip for pod
+func (w *Worker) LoopbackIPBind(pod *corev1.Pod, ip *ipamtypes.IP) error {
+	return w.bindLoopbackIP(pod, ip)
+}
+
+// LoopbackIPBind creates the ip instance of loopback ip for pod
+func (d *DualStackWorker) LoopbackIPBind(pod *corev1.Pod, ip *ipamtypes.IP) error {
+	return d.worker.bindLoopbackIP(pod, ip)
+}
+
+// bindLoopbackIP creates the ip instance of loopback ip, which is owned by pod itself even for stateful pods
+// so that it is released along with pod. It is labeled with LabelLoopbackPod and has no pod recorded in
+// status, so that it is never taken as an ip of pod nic, while it is still in use on the node of pod
+func (w *Worker) bindLoopbackIP(pod *corev1.Pod, ip *ipamtypes.IP) (err error) {
+	if err = reviewAllocation(pod, []*ipamtypes.IP{ip}); err != nil {
+		return err
+	}
+
+	var releaseClaims func()
+	if releaseClaims, err = claimIPs(pod, []*ipamtypes.IP{ip}); err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			releaseClaims()
+		}
+	}()
+
+	ipInstance := newIPInstance(pod.Namespace, pod.Name, pod.Spec.NodeName, ip, "",
+		newControllerRef(pod, corev1.SchemeGroupVersion.WithKind("Pod")))
+	delete(ipInstance.Labels, constants.LabelPod)
+	ipInstance.Labels[constants.LabelLoopbackPod] = pod.Name
+
+	if err = w.Create(context.TODO(), ipInstance); err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = w.deleteIP(ipInstance.Namespace, ipInstance.Name)
+		}
+	}()
+
+	if err = w.updateIPStatus(ipInstance, pod.Spec.NodeName, "", "", string(networkingv1.IPPhaseUsing)); err != nil {
+		return err
+	}
+
+	countAllocatedIPs([]*ipamtypes.IP{ip})
+	return nil
+}
@@ -0,0 +1,146 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/ipam/strategy"
+)
+
+// maxOwnerDepth limits how many levels of controller references will be followed
+// when resolving the workload of a pod, e.g. Pod -> ReplicaSet -> Deployment
+const maxOwnerDepth = 5
+
+// ownerLookupTimeout bounds every lookup of owner, so that an inaccessible owner never blocks allocation
+const ownerLookupTimeout = 3 * time.Second
+
+// resolvableOwnerKinds are the kinds of owners which will be looked up for their controllers, only
+// built-in workloads are resolved because the others may be neither cached nor accessible by manager
+var resolvableOwnerKinds = map[schema.GroupKind]struct{}{
+	{Group: "apps", Kind: "ReplicaSet"}:  {},
+	{Group: "apps", Kind: "Deployment"}:  {},
+	{Group: "apps", Kind: "StatefulSet"}: {},
+	{Group: "apps", Kind: "DaemonSet"}:   {},
+	{Group: "batch", Kind: "Job"}:        {},
+	{Group: "batch", Kind: "CronJob"}:    {},
+}
+
+// workloadOwnerOf resolves the top-level controller of pod by walking up the controller
+// references. Resolving is best-effort, the deepest reference which can be resolved will
+// be returned if any owner is missing or not accessible, nil if pod is not controlled.
+func (w *Worker) workloadOwnerOf(pod *corev1.Pod) *metav1.OwnerReference {
+	return resolveWorkloadOwner(w, pod)
+}
+
+func resolveWorkloadOwner(reader client.Reader, pod *corev1.Pod) *metav1.OwnerReference {
+	ref := metav1.GetControllerOf(pod)
+	for depth := 0; ref != nil && depth < maxOwnerDepth; depth++ {
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err != nil {
+			return ref
+		}
+
+		if _, ok := resolvableOwnerKinds[schema.GroupKind{Group: gv.Group, Kind: ref.Kind}]; !ok {
+			return ref
+		}
+
+		owner := &metav1.PartialObjectMetadata{}
+		owner.SetGroupVersionKind(gv.WithKind(ref.Kind))
+		if err = getWithTimeout(reader, types.NamespacedName{Namespace: pod.Namespace, Name: ref.Name}, owner); err != nil {
+			return ref
+		}
+
+		// owner has been recreated with the same name, stop here
+		if owner.UID != ref.UID {
+			return ref
+		}
+
+		next := metav1.GetControllerOf(owner)
+		if next == nil {
+			return ref
+		}
+		ref = next
+	}
+	return ref
+}
+
+func getWithTimeout(reader client.Reader, key types.NamespacedName, obj client.Object) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ownerLookupTimeout)
+	defer cancel()
+	return reader.Get(ctx, key, obj)
+}
+
+// IPInstanceOwnerOf returns the controller reference to be set on ip instances of pod, ip instances
+// of stateful pods are owned by their workloads to survive pod recreation, the others by pods
+func IPInstanceOwnerOf(pod *corev1.Pod) *metav1.OwnerReference {
+	if owner := strategy.GetKnownOwnReference(pod); owner != nil {
+		return owner
+	}
+	return newControllerRef(pod, corev1.SchemeGroupVersion.WithKind("Pod"))
+}
+
+// IPInstanceOwnerIsStale checks whether the controller reference of ip instance should be replaced by
+// the expected one. A missing reference is stale, and so is a reference to a recreated stateful workload
+// of the same name, e.g., orphaned and recreated, but a reference to another pod of the same name is not,
+// because the ip instance belongs to the previous pod and is left to garbage collection. An imported ip
+// instance is adopted once used by pod, so that it is no longer released along with the ip import
+func IPInstanceOwnerIsStale(ipInstance *networkingv1.IPInstance, expected *metav1.OwnerReference) bool {
+	current := metav1.GetControllerOf(ipInstance)
+	if current == nil {
+		return true
+	}
+	if current.Kind == "IPImport" && current.APIVersion == networkingv1.GroupVersion.String() {
+		return true
+	}
+	if current.UID == expected.UID {
+		return false
+	}
+	return current.Kind == expected.Kind && current.Name == expected.Name && strategy.IsStatefulWorkloadKind(current.Kind)
+}
+
+// workloadOwnerLabels returns the owner labels of workload to be stamped on ip instances,
+// values will be nil if workload is nil or the name is not a valid label value, which
+// means the labels should be removed
+func workloadOwnerLabels(workload *metav1.OwnerReference) map[string]*string {
+	labels := map[string]*string{
+		constants.LabelOwnerKind: nil,
+		constants.LabelOwnerName: nil,
+	}
+	if workload == nil {
+		return labels
+	}
+
+	kind, name := workload.Kind, workload.Name
+	if len(validation.IsValidLabelValue(kind)) > 0 || len(validation.IsValidLabelValue(name)) > 0 {
+		return labels
+	}
+
+	labels[constants.LabelOwnerKind] = &kind
+	labels[constants.LabelOwnerName] = &name
+	return labels
+}
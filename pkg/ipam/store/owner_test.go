@@ -0,0 +1,223 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+)
+
+func TestIPInstanceOwnerOf(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "nginx", UID: "pod-uid"}}
+	if owner := IPInstanceOwnerOf(pod); owner.Kind != "Pod" || owner.UID != "pod-uid" {
+		t.Errorf("expect pod owned, got %s/%s", owner.Kind, owner.UID)
+	}
+
+	isController := true
+	pod.OwnerReferences = []metav1.OwnerReference{
+		{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "web", UID: "sts-uid", Controller: &isController},
+	}
+	if owner := IPInstanceOwnerOf(pod); owner.Kind != "StatefulSet" || owner.UID != "sts-uid" {
+		t.Errorf("expect workload owned, got %s/%s", owner.Kind, owner.UID)
+	}
+}
+
+func TestIPInstanceOwnerIsStale(t *testing.T) {
+	isController := true
+	ref := func(kind, name string, uid types.UID) metav1.OwnerReference {
+		return metav1.OwnerReference{APIVersion: "v1", Kind: kind, Name: name, UID: uid, Controller: &isController}
+	}
+
+	tests := []struct {
+		name     string
+		current  []metav1.OwnerReference
+		expected metav1.OwnerReference
+		stale    bool
+	}{
+		{
+			"missing owner",
+			nil,
+			ref("Pod", "nginx", "uid-1"),
+			true,
+		},
+		{
+			"non-controller owner only",
+			[]metav1.OwnerReference{{APIVersion: "v1", Kind: "ConfigMap", Name: "cm", UID: "uid-0"}},
+			ref("Pod", "nginx", "uid-1"),
+			true,
+		},
+		{
+			"same owner",
+			[]metav1.OwnerReference{ref("Pod", "nginx", "uid-1")},
+			ref("Pod", "nginx", "uid-1"),
+			false,
+		},
+		{
+			"previous pod of the same name",
+			[]metav1.OwnerReference{ref("Pod", "nginx", "uid-0")},
+			ref("Pod", "nginx", "uid-1"),
+			false,
+		},
+		{
+			"recreated stateful workload",
+			[]metav1.OwnerReference{ref("StatefulSet", "web", "uid-0")},
+			ref("StatefulSet", "web", "uid-1"),
+			true,
+		},
+		{
+			"another stateful workload",
+			[]metav1.OwnerReference{ref("StatefulSet", "db", "uid-0")},
+			ref("StatefulSet", "web", "uid-1"),
+			false,
+		},
+		{
+			"ip import",
+			[]metav1.OwnerReference{{APIVersion: networkingv1.GroupVersion.String(), Kind: "IPImport", Name: "legacy",
+				UID: "uid-0", Controller: &isController}},
+			ref("StatefulSet", "web", "uid-1"),
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ipInstance := &networkingv1.IPInstance{ObjectMeta: metav1.ObjectMeta{OwnerReferences: test.current}}
+			if stale := IPInstanceOwnerIsStale(ipInstance, &test.expected); stale != test.stale {
+				t.Errorf("expect stale %v, got %v", test.stale, stale)
+			}
+		})
+	}
+}
+
+// ownerReader serves owners by kind/name, and records every lookup
+type ownerReader struct {
+	client.Reader
+	owners  map[string]metav1.ObjectMeta
+	lookups []string
+}
+
+func (o *ownerReader) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	owner := obj.(*metav1.PartialObjectMetadata)
+	lookup := owner.Kind + "/" + key.Name
+	o.lookups = append(o.lookups, lookup)
+
+	objectMeta, exist := o.owners[lookup]
+	if !exist {
+		return fmt.Errorf("%s not found", lookup)
+	}
+	owner.ObjectMeta = objectMeta
+	owner.Name = key.Name
+	if len(owner.UID) == 0 {
+		owner.UID = types.UID(owner.Kind + "-" + key.Name)
+	}
+	return nil
+}
+
+func TestResolveWorkloadOwner(t *testing.T) {
+	isController := true
+	ref := func(apiVersion, kind, name string) metav1.OwnerReference {
+		return metav1.OwnerReference{APIVersion: apiVersion, Kind: kind, Name: name, UID: types.UID(kind + "-" + name),
+			Controller: &isController}
+	}
+	refOf := func(apiVersion, kind, name string) *metav1.OwnerReference {
+		r := ref(apiVersion, kind, name)
+		return &r
+	}
+	meta := func(owners ...metav1.OwnerReference) metav1.ObjectMeta {
+		return metav1.ObjectMeta{OwnerReferences: owners}
+	}
+
+	tests := []struct {
+		name          string
+		podOwner      *metav1.OwnerReference
+		owners        map[string]metav1.ObjectMeta
+		expected      *metav1.OwnerReference
+		expectLookups []string
+	}{
+		{
+			"not controlled",
+			nil,
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"deployment",
+			refOf("apps/v1", "ReplicaSet", "web-abc"),
+			map[string]metav1.ObjectMeta{
+				"ReplicaSet/web-abc": meta(ref("apps/v1", "Deployment", "web")),
+				"Deployment/web":     meta(),
+			},
+			refOf("apps/v1", "Deployment", "web"),
+			[]string{"ReplicaSet/web-abc", "Deployment/web"},
+		},
+		{
+			"missing owner",
+			refOf("apps/v1", "ReplicaSet", "web-abc"),
+			map[string]metav1.ObjectMeta{},
+			refOf("apps/v1", "ReplicaSet", "web-abc"),
+			[]string{"ReplicaSet/web-abc"},
+		},
+		{
+			"unknown kind is never looked up",
+			refOf("apps.kruise.io/v1alpha1", "CloneSet", "web"),
+			map[string]metav1.ObjectMeta{
+				"CloneSet/web": meta(ref("apps/v1", "Deployment", "web")),
+			},
+			refOf("apps.kruise.io/v1alpha1", "CloneSet", "web"),
+			nil,
+		},
+		{
+			"recreated owner",
+			refOf("batch/v1", "Job", "backup"),
+			map[string]metav1.ObjectMeta{
+				"Job/backup": {UID: "recreated", OwnerReferences: []metav1.OwnerReference{ref("batch/v1beta1", "CronJob", "backup")}},
+			},
+			refOf("batch/v1", "Job", "backup"),
+			[]string{"Job/backup"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-abc-0", Namespace: "default"}}
+			if test.podOwner != nil {
+				pod.OwnerReferences = []metav1.OwnerReference{*test.podOwner}
+			}
+
+			reader := &ownerReader{owners: test.owners}
+			owner := resolveWorkloadOwner(reader, pod)
+			switch {
+			case test.expected == nil && owner != nil:
+				t.Errorf("expect no owner, got %s/%s", owner.Kind, owner.Name)
+			case test.expected != nil && (owner == nil || owner.UID != test.expected.UID):
+				t.Errorf("expect owner %s/%s, got %v", test.expected.Kind, test.expected.Name, owner)
+			}
+			if fmt.Sprint(reader.lookups) != fmt.Sprint(test.expectLookups) {
+				t.Errorf("expect lookups %v, got %v", test.expectLookups, reader.lookups)
+			}
+		})
+	}
+}
@@ -32,8 +32,8 @@ import (
 
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
 	"github.com/alibaba/hybridnet/pkg/constants"
-	"github.com/alibaba/hybridnet/pkg/ipam/strategy"
 	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
+	"github.com/alibaba/hybridnet/pkg/metrics"
 	"github.com/alibaba/hybridnet/pkg/utils/mac"
 )
 
@@ -50,7 +50,26 @@ func NewWorker(client client.Client) *Worker {
 func (w *Worker) Couple(pod *corev1.Pod, ip *ipamtypes.IP) (err error) {
 	var ipInstance *networkingv1.IPInstance
 
-	ipInstance, err = w.createIP(pod, ip)
+	if err = w.checkIPInstanceLimit(pod, []*ipamtypes.IP{ip}); err != nil {
+		return err
+	}
+
+	if err = reviewAllocation(pod, []*ipamtypes.IP{ip}); err != nil {
+		return err
+	}
+
+	var releaseClaims func()
+	if releaseClaims, err = claimIPs(pod, []*ipamtypes.IP{ip}); err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			releaseClaims()
+		}
+	}()
+
+	ipInstance, err = w.createIP(pod, ip, w.workloadOwnerOf(pod))
 	if err != nil {
 		return err
 	}
@@ -65,18 +84,28 @@ func (w *Worker) Couple(pod *corev1.Pod, ip *ipamtypes.IP) (err error) {
 		return err
 	}
 
+	previousAnnotations := ipAnnotationsOf(pod)
 	defer func() {
 		if err != nil {
-			_ = w.releaseIPFromPod(pod)
+			_ = w.rollbackIPAnnotations(pod, previousAnnotations)
 		}
 	}()
 
-	return w.patchIPtoPod(pod, ip)
+	if err = w.patchIPtoPod(pod, ip); err != nil {
+		return err
+	}
+
+	countAllocatedIPs([]*ipamtypes.IP{ip})
+	return nil
 }
 
 func (w *Worker) ReCouple(pod *corev1.Pod, ip *ipamtypes.IP) (err error) {
 	var ipInstance *networkingv1.IPInstance
 
+	if err = w.checkIPInstanceLimit(pod, []*ipamtypes.IP{ip}); err != nil {
+		return err
+	}
+
 	ipInstance, err = w.getIP(pod.Namespace, ip)
 	if err != nil {
 		if errors.IsNotFound(err) {
@@ -85,7 +114,12 @@ func (w *Worker) ReCouple(pod *corev1.Pod, ip *ipamtypes.IP) (err error) {
 		return
 	}
 
-	if err = w.patchIPLabels(ipInstance, pod.Name, pod.Spec.NodeName); err != nil {
+	// reused ip is committed to pod again, which is reviewed as a new allocation
+	if err = reviewAllocation(pod, []*ipamtypes.IP{ip}); err != nil {
+		return err
+	}
+
+	if err = w.patchIPLabels(ipInstance, pod.Name, pod.Spec.NodeName, w.workloadOwnerOf(pod)); err != nil {
 		return err
 	}
 
@@ -116,6 +150,7 @@ func (w *Worker) DeCouple(pod *corev1.Pod) (err error) {
 		if err = w.deleteIP(pod.Namespace, ipInstanceList.Items[i].Name); err != nil {
 			return err
 		}
+		countReleasedIP(ipInstanceList.Items[i].Spec.Subnet, networkingv1.IsIPv6IPInstance(&ipInstanceList.Items[i]))
 	}
 
 	return w.releaseIPFromPod(pod)
@@ -138,6 +173,10 @@ func (w *Worker) IPReserve(pod *corev1.Pod) (err error) {
 	}
 
 	for i := range ipInstanceList.Items {
+		// IP in any phase of using, even stuck in binding, should be reserved
+		if ipInstanceList.Items[i].Status.Phase == networkingv1.IPPhaseReserved {
+			continue
+		}
 		if err = w.updateIPStatus(&ipInstanceList.Items[i], "", pod.Name, pod.Namespace, string(networkingv1.IPPhaseReserved)); err != nil {
 			return err
 		}
@@ -146,7 +185,12 @@ func (w *Worker) IPReserve(pod *corev1.Pod) (err error) {
 }
 
 func (w *Worker) IPRecycle(namespace string, ip *ipamtypes.IP) (err error) {
-	return w.deleteIP(namespace, toDNSLabelFormat(ip))
+	if err = w.deleteIP(namespace, toDNSLabelFormat(ip)); err != nil {
+		return err
+	}
+
+	countReleasedIP(ip.Subnet, ip.IsIPv6())
+	return nil
 }
 
 func (w *Worker) IPUnBind(namespace, ip string) (err error) {
@@ -255,26 +299,62 @@ func (w *Worker) updateIPStatus(ip *networkingv1.IPInstance, nodeName, podName,
 	})
 }
 
-func (w *Worker) createIP(pod *corev1.Pod, ip *ipamtypes.IP) (ipIns *networkingv1.IPInstance, err error) {
-	return w.createIPWithMAC(pod, ip, mac.GenerateMAC().String())
+func (w *Worker) createIP(pod *corev1.Pod, ip *ipamtypes.IP, workload *metav1.OwnerReference) (ipIns *networkingv1.IPInstance, err error) {
+	var macAddr string
+	if macAddr, err = w.generateMAC(ip.Network, []*ipamtypes.IP{ip}); err != nil {
+		return nil, err
+	}
+	return w.createIPWithMAC(pod, ip, macAddr, workload)
 }
 
-func (w *Worker) createIPWithMAC(pod *corev1.Pod, ip *ipamtypes.IP, macAddr string) (ipIns *networkingv1.IPInstance, err error) {
-	owner := strategy.GetKnownOwnReference(pod)
-	if owner == nil {
-		owner = newControllerRef(pod, corev1.SchemeGroupVersion.WithKind("Pod"))
+// generateMAC generates the MAC address shared by ips of a pod by the MAC address mode of network,
+// a derived MAC address comes from the IPv4 address if any
+func (w *Worker) generateMAC(networkName string, ips []*ipamtypes.IP) (string, error) {
+	network := &networkingv1.Network{}
+	if err := w.Get(context.TODO(), types.NamespacedName{Name: networkName}, network); err != nil {
+		if errors.IsNotFound(err) {
+			return mac.GenerateMAC().String(), nil
+		}
+		return "", fmt.Errorf("unable to get network %s: %v", networkName, err)
+	}
+
+	if network.Spec.MACAddressMode != networkingv1.MACAddressModeIPDerived || len(ips) == 0 {
+		return mac.GenerateMAC().String(), nil
 	}
 
+	source := ips[0]
+	for _, ip := range ips {
+		if ip.Address.IP.To4() != nil {
+			source = ip
+			break
+		}
+	}
+	return mac.DeriveMAC(source.Address.IP).String(), nil
+}
+
+func (w *Worker) createIPWithMAC(pod *corev1.Pod, ip *ipamtypes.IP, macAddr string, workload *metav1.OwnerReference) (ipIns *networkingv1.IPInstance, err error) {
+	ipInstance := newIPInstance(pod.Namespace, pod.Name, pod.Spec.NodeName, ip, macAddr, IPInstanceOwnerOf(pod))
+
+	for key, value := range workloadOwnerLabels(workload) {
+		if value != nil {
+			ipInstance.Labels[key] = *value
+		}
+	}
+
+	return ipInstance, w.Create(context.TODO(), ipInstance)
+}
+
+func newIPInstance(namespace, podName, nodeName string, ip *ipamtypes.IP, macAddr string, owner *metav1.OwnerReference) *networkingv1.IPInstance {
 	ipInstance := &networkingv1.IPInstance{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:       toDNSLabelFormat(ip),
-			Namespace:  pod.Namespace,
+			Namespace:  namespace,
 			Finalizers: []string{constants.FinalizerIPAllocated},
 			Labels: map[string]string{
 				constants.LabelSubnet:  ip.Subnet,
 				constants.LabelNetwork: ip.Network,
-				constants.LabelNode:    pod.Spec.NodeName,
-				constants.LabelPod:     pod.Name,
+				constants.LabelNode:    nodeName,
+				constants.LabelPod:     podName,
 			},
 			OwnerReferences: []metav1.OwnerReference{*owner},
 		},
@@ -297,7 +377,7 @@ func (w *Worker) createIPWithMAC(pod *corev1.Pod, ip *ipamtypes.IP, macAddr stri
 		ipInstance.Spec.Address.Gateway = ip.Gateway.String()
 	}
 
-	return ipInstance, w.Create(context.TODO(), ipInstance)
+	return ipInstance
 }
 
 func (w *Worker) deleteIP(namespace, name string) error {
@@ -319,55 +399,102 @@ func (w *Worker) getIP(namespace string, ip *ipamtypes.IP) (*networkingv1.IPInst
 
 // patchIPtoPod will patch a specified IP annotation into pod
 func (w *Worker) patchIPtoPod(pod *corev1.Pod, ip *ipamtypes.IP) error {
-	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		return w.Patch(context.TODO(),
 			pod,
 			client.RawPatch(
 				types.MergePatchType,
 				[]byte(fmt.Sprintf(
-					`{"metadata":{"annotations":{%q:%q}}}`,
+					`{"metadata":{"annotations":{%q:%q,%q:%q,%q:%q,%q:null,%q:null,%q:%s}}}`,
 					constants.AnnotationIP,
 					marshal(ip),
+					constants.AnnotationNetwork,
+					ip.Network,
+					constants.AnnotationSubnet,
+					ip.Subnet,
+					constants.AnnotationAllocationFailure,
+					constants.AnnotationNetworkExhausted,
+					constants.AnnotationCorrelationID,
+					correlationIDValueOf(pod),
 				)),
 			),
 		)
-	})
+	}); err != nil {
+		return err
+	}
+
+	w.patchIPAllocatedCondition(pod, []*ipamtypes.IP{ip})
+	return nil
 }
 
-// releaseIPFromPod will remove the specified IP annotation from pod
+// releaseIPFromPod will remove the specified IP annotations from pod
 func (w *Worker) releaseIPFromPod(pod *corev1.Pod) error {
-	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		return w.Patch(context.TODO(),
 			pod,
 			client.RawPatch(
 				types.MergePatchType,
 				[]byte(fmt.Sprintf(
-					`{"metadata":{"annotations":{%q:null}}}`,
+					`{"metadata":{"annotations":{%q:null,%q:null,%q:null}}}`,
 					constants.AnnotationIP,
+					constants.AnnotationNetwork,
+					constants.AnnotationSubnet,
 				)),
 			),
 		)
-	})
+	}); err != nil {
+		return err
+	}
+
+	w.patchIPReleasedCondition(pod)
+	return nil
 }
 
-func (w *Worker) patchIPLabels(ip *networkingv1.IPInstance, podName, nodeName string) error {
+func (w *Worker) patchIPLabels(ip *networkingv1.IPInstance, podName, nodeName string, workload *metav1.OwnerReference) error {
+	labels := workloadOwnerLabels(workload)
+	labels[constants.LabelNode] = &nodeName
+	labels[constants.LabelPod] = &podName
+
+	patchBody, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": labels,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
 	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		return w.Patch(context.TODO(),
 			ip,
 			client.RawPatch(
 				types.MergePatchType,
-				[]byte(fmt.Sprintf(
-					`{"metadata":{"labels":{"%s":%q,"%s":%q}}}`,
-					constants.LabelNode,
-					nodeName,
-					constants.LabelPod,
-					podName,
-				)),
+				patchBody,
 			),
 		)
 	})
 }
 
+// countAllocatedIPs counts ips committed to pod by subnet and family for capacity planning,
+// pod is never a label to keep cardinality bounded
+func countAllocatedIPs(ips []*ipamtypes.IP) {
+	for _, ip := range ips {
+		metrics.SubnetIPAllocationCounter.WithLabelValues(ip.Subnet, ipFamilyLabel(ip.IsIPv6())).Inc()
+	}
+}
+
+// countReleasedIP counts ip released from pod by subnet and family
+func countReleasedIP(subnet string, isIPv6 bool) {
+	metrics.SubnetIPReleaseCounter.WithLabelValues(subnet, ipFamilyLabel(isIPv6)).Inc()
+}
+
+func ipFamilyLabel(isIPv6 bool) string {
+	if isIPv6 {
+		return metrics.IPv6
+	}
+	return metrics.IPv4
+}
+
 func marshal(ip *ipamtypes.IP) string {
 	bytes, _ := json.Marshal(ip)
 	return string(bytes)
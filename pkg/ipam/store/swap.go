@@ -0,0 +1,168 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+// IPSwapBind creates the ip instance of the ip swapped in for the original ip of pod
+func (w *Worker) IPSwapBind(pod *corev1.Pod, ip, from *ipamtypes.IP) error {
+	return w.bindSwapIP(pod, ip, from)
+}
+
+// IPSwapBind creates the ip instance of the ip swapped in for the original ip of pod
+func (d *DualStackWorker) IPSwapBind(pod *corev1.Pod, ip, from *ipamtypes.IP) error {
+	return d.worker.bindSwapIP(pod, ip, from)
+}
+
+// IPSwapCommit replaces the original ip of pod with the ip swapped in
+func (w *Worker) IPSwapCommit(pod *corev1.Pod, ip, from *ipamtypes.IP) error {
+	return w.commitSwapIP(pod, ip, from, marshal(ip))
+}
+
+// IPSwapCommit replaces the original ip of pod with the ip swapped in
+func (d *DualStackWorker) IPSwapCommit(pod *corev1.Pod, ip, from *ipamtypes.IP) error {
+	return d.worker.commitSwapIP(pod, ip, from, marshalIPs([]*ipamtypes.IP{ip}))
+}
+
+// bindSwapIP creates the ip instance of the ip swapped in, which shares the MAC address with the original one
+// since the nic of pod is kept. Like a loopback ip, it is owned by pod itself and labeled with LabelSwapPod
+// without pod recorded in status, so that it is released along with pod if the swap is never committed
+func (w *Worker) bindSwapIP(pod *corev1.Pod, ip, from *ipamtypes.IP) (err error) {
+	fromIPInstance, err := w.getIP(pod.Namespace, from)
+	if err != nil {
+		return fmt.Errorf("unable to get ip instance of original ip %s: %v", from.Address.IP, err)
+	}
+
+	if err = reviewAllocation(pod, []*ipamtypes.IP{ip}); err != nil {
+		return err
+	}
+
+	var releaseClaims func()
+	if releaseClaims, err = claimIPs(pod, []*ipamtypes.IP{ip}); err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			releaseClaims()
+		}
+	}()
+
+	ipInstance := newIPInstance(pod.Namespace, pod.Name, pod.Spec.NodeName, ip, fromIPInstance.Spec.Address.MAC,
+		newControllerRef(pod, corev1.SchemeGroupVersion.WithKind("Pod")))
+	delete(ipInstance.Labels, constants.LabelPod)
+	ipInstance.Labels[constants.LabelSwapPod] = pod.Name
+	ipInstance.Annotations = map[string]string{
+		constants.AnnotationIPSwapFrom: fromIPInstance.Spec.Address.IP,
+	}
+
+	if err = w.Create(context.TODO(), ipInstance); err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = w.deleteIP(ipInstance.Namespace, ipInstance.Name)
+		}
+	}()
+
+	if err = w.updateIPStatus(ipInstance, pod.Spec.NodeName, "", "", string(networkingv1.IPPhaseUsing)); err != nil {
+		return err
+	}
+
+	countAllocatedIPs([]*ipamtypes.IP{ip})
+	return nil
+}
+
+// commitSwapIP hands the ip instance swapped in over to pod and deletes the original one, the swap request
+// is removed from pod along with the ip annotation. Every step is idempotent so that an interrupted commit
+// can be retried, as long as the original ip instance is still there.
+func (w *Worker) commitSwapIP(pod *corev1.Pod, ip, from *ipamtypes.IP, ipAnnotation string) error {
+	ipInstance, err := w.getIP(pod.Namespace, ip)
+	if err != nil {
+		return fmt.Errorf("unable to get ip instance of swapped ip %s: %v", ip.Address.IP, err)
+	}
+
+	labels := workloadOwnerLabels(w.workloadOwnerOf(pod))
+	labels[constants.LabelPod] = &pod.Name
+	labels[constants.LabelSwapPod] = nil
+	patchBody, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":          labels,
+			"ownerReferences": []metav1.OwnerReference{*IPInstanceOwnerOf(pod)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return w.Patch(context.TODO(), ipInstance, client.RawPatch(types.MergePatchType, patchBody))
+	}); err != nil {
+		return fmt.Errorf("unable to hand over ip instance %s: %v", ipInstance.Name, err)
+	}
+
+	// the nic has been configured with the ip swapped in, so it is bound already
+	if err = w.updateIPStatus(ipInstance, pod.Spec.NodeName, pod.Name, pod.Namespace, string(networkingv1.IPPhaseBound)); err != nil {
+		return fmt.Errorf("unable to update status of ip instance %s: %v", ipInstance.Name, err)
+	}
+
+	if err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return w.Patch(context.TODO(),
+			pod,
+			client.RawPatch(
+				types.MergePatchType,
+				[]byte(fmt.Sprintf(
+					`{"metadata":{"annotations":{%q:%q,%q:%q,%q:null}}}`,
+					constants.AnnotationIP,
+					ipAnnotation,
+					constants.AnnotationSubnet,
+					ip.Subnet,
+					constants.AnnotationIPSwap,
+				)),
+			),
+		)
+	}); err != nil {
+		return fmt.Errorf("unable to patch ip to pod: %v", err)
+	}
+
+	w.patchIPAllocatedCondition(pod, []*ipamtypes.IP{ip})
+
+	if err = w.deleteIP(pod.Namespace, toDNSLabelFormat(from)); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to delete ip instance of original ip %s: %v", from.Address.IP, err)
+	}
+	countReleasedIP(from.Subnet, from.IsIPv6())
+	return nil
+}
@@ -27,8 +27,9 @@ import (
 )
 
 var (
-	StatefulWorkloadKinds []string
-	DefaultIPRetain       bool
+	StatefulWorkloadKinds  []string
+	DefaultIPRetain        bool
+	SubnetTopologyFallback bool
 )
 
 var (
@@ -38,6 +39,8 @@ var (
 
 func init() {
 	pflag.BoolVar(&DefaultIPRetain, "default-ip-retain", true, "Whether pod IP of stateful workloads will be retained by default.")
+	pflag.BoolVar(&SubnetTopologyFallback, "subnet-topology-fallback", true, "Whether pod can get IP from any subnet of network "+
+		"if no subnet matches the topology of its node.")
 	pflag.StringSliceVar(&StatefulWorkloadKinds, "stateful-workload-kinds", []string{"StatefulSet"}, `stateful workload kinds to use strategic IP allocation,`+
 		`eg: "StatefulSet,AdvancedStatefulSet", default: "StatefulSet"`)
 }
@@ -48,13 +51,17 @@ func OwnByStatefulWorkload(pod *v1.Pod) bool {
 		return false
 	}
 
+	return IsStatefulWorkloadKind(ref.Kind)
+}
+
+func IsStatefulWorkloadKind(kind string) bool {
 	statefulOnce.Do(func() {
 		statefulWorkloadKindSet = sets.NewString(StatefulWorkloadKinds...)
 		logger := log.Log.WithName("strategy")
 		logger.Info("Adding known stateful workloads", "Kinds", StatefulWorkloadKinds)
 	})
 
-	return statefulWorkloadKindSet.Has(ref.Kind)
+	return statefulWorkloadKindSet.Has(kind)
 }
 
 func GetKnownOwnReference(pod *v1.Pod) *metav1.OwnerReference {
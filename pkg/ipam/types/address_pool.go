@@ -0,0 +1,140 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package types
+
+import "sync"
+
+// AddressPoolSet keeps the address pools shared by networks across network refreshing.
+type AddressPoolSet struct {
+	lock  sync.Mutex
+	pools map[string]*AddressPool
+}
+
+func NewAddressPoolSet() *AddressPoolSet {
+	return &AddressPoolSet{
+		pools: make(map[string]*AddressPool),
+	}
+}
+
+// Get returns the address pool with name, it will be created if not exists,
+// an empty name means the network does not share its addresses.
+func (a *AddressPoolSet) Get(name string) *AddressPool {
+	if a == nil || len(name) == 0 {
+		return nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	pool, exist := a.pools[name]
+	if !exist {
+		pool = NewAddressPool()
+		a.pools[name] = pool
+	}
+	return pool
+}
+
+// AddressPool tracks the occupancy of an address space shared by the overlapped subnets
+// of multiple networks, so that an address is never allocated through two subnets at the
+// same time. Every occupied IP is recorded with the subnet holding it.
+type AddressPool struct {
+	lock   sync.Mutex
+	owners map[string]string
+}
+
+func NewAddressPool() *AddressPool {
+	return &AddressPool{
+		owners: make(map[string]string),
+	}
+}
+
+// OccupiedByOthers checks whether ip is held by a subnet other than the given one.
+func (a *AddressPool) OccupiedByOthers(ip, subnet string) bool {
+	if a == nil {
+		return false
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	owner, exist := a.owners[ip]
+	return exist && owner != subnet
+}
+
+// Occupy records ip as held by subnet.
+func (a *AddressPool) Occupy(ip, subnet string) {
+	if a == nil {
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.owners[ip] = subnet
+}
+
+// Free gives ip back to pool if it is held by subnet.
+func (a *AddressPool) Free(ip, subnet string) {
+	if a == nil {
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.owners[ip] == subnet {
+		delete(a.owners, ip)
+	}
+}
+
+// Reset replaces all the IPs held by subnet with the given ones, it is called
+// when subnet is synced from its using IPs.
+func (a *AddressPool) Reset(subnet string, ips IPSet) {
+	if a == nil {
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for ip, owner := range a.owners {
+		if owner == subnet {
+			delete(a.owners, ip)
+		}
+	}
+	for ip := range ips {
+		a.owners[ip] = subnet
+	}
+}
+
+// Remove gives back all the IPs held by subnet, it is called when subnet is
+// removed from pool.
+func (a *AddressPool) Remove(subnet string) {
+	a.Reset(subnet, nil)
+}
+
+// Count returns the number of IPs occupied in pool.
+func (a *AddressPool) Count() int {
+	if a == nil {
+		return 0
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return len(a.owners)
+}
@@ -0,0 +1,139 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestAddressPool_SharedBySubnets(t *testing.T) {
+	pools := NewAddressPoolSet()
+	pool := pools.Get("shared")
+	if pools.Get("shared") != pool {
+		t.Fatalf("expected the same pool for the same name")
+	}
+
+	newSubnet := func(name, network string, ips IPSet) *Subnet {
+		_, cidr, _ := net.ParseCIDR("192.168.0.0/29")
+		subnet := NewSubnet(name, network, nil, nil, nil, net.ParseIP("192.168.0.1"), cidr, nil, nil, nil, false, false)
+		subnet.AddressPool = pool
+		if err := subnet.Canonicalize(); err != nil {
+			t.Fatalf("fail to canonicalize: %v", err)
+		}
+		if err := subnet.Sync(nil, ips); err != nil {
+			t.Fatalf("fail to sync: %v", err)
+		}
+		return subnet
+	}
+
+	usingIPs := NewIPSet()
+	usingIPs.Add("192.168.0.2", &IP{
+		Address: &net.IPNet{IP: net.ParseIP("192.168.0.2"), Mask: net.CIDRMask(29, 32)},
+		Subnet:  "a",
+		Network: "network-a",
+		Status:  IPStatusUsing,
+	})
+	a := newSubnet("a", "network-a", usingIPs)
+	b := newSubnet("b", "network-b", NewIPSet())
+
+	if _, err := b.Assign("pod", "ns", "192.168.0.2", false); err != ErrNotAvailableAssignedIP {
+		t.Fatalf("expected ip occupied by another network not assignable, got %v", err)
+	}
+
+	allocated := map[string]bool{"192.168.0.2": true}
+	for i := 0; i < 4; i++ {
+		subnet := a
+		if i%2 == 1 {
+			subnet = b
+		}
+		ip := subnet.AllocateNext("pod", "ns")
+		if ip == nil {
+			t.Fatalf("fail to allocate the %d ip", i)
+		}
+		if allocated[ip.Address.IP.String()] {
+			t.Fatalf("ip %s is allocated twice", ip.Address.IP.String())
+		}
+		allocated[ip.Address.IP.String()] = true
+	}
+
+	if ip := b.AllocateNext("pod", "ns"); ip != nil {
+		t.Fatalf("expected shared pool exhausted, got %s", ip.Address.IP.String())
+	}
+	if pool.Count() != 5 {
+		t.Fatalf("expected 5 ips occupied in pool, got %d", pool.Count())
+	}
+
+	a.Release("192.168.0.2")
+	if _, err := b.Assign("pod", "ns", "192.168.0.2", false); err != nil {
+		t.Fatalf("expected released ip assignable through another network, got %v", err)
+	}
+}
+
+func TestAddressPool_Disabled(t *testing.T) {
+	var pool *AddressPool
+	pool.Occupy("192.168.0.1", "a")
+	if pool.OccupiedByOthers("192.168.0.1", "b") || pool.Count() != 0 {
+		t.Errorf("expected nil pool occupies nothing")
+	}
+
+	if NewAddressPoolSet().Get("") != nil {
+		t.Errorf("expected nil pool for empty name")
+	}
+}
+
+func TestAddressPool_ReleasedWithStaleSubnets(t *testing.T) {
+	pool := NewAddressPool()
+	networks := NewNetworkSet()
+
+	newNetwork := func(subnets ...string) *Network {
+		network := NewNetwork("network", nil, "", Underlay)
+		for i, name := range subnets {
+			_, cidr, _ := net.ParseCIDR(fmt.Sprintf("192.168.%d.0/29", i))
+			ip := net.ParseIP(fmt.Sprintf("192.168.%d.2", i))
+			usingIPs := NewIPSet()
+			usingIPs.Add(ip.String(), &IP{
+				Address: &net.IPNet{IP: ip, Mask: cidr.Mask},
+				Subnet:  name,
+				Network: "network",
+				Status:  IPStatusUsing,
+			})
+			subnet := NewSubnet(name, "network", nil, nil, nil, nil, cidr, nil, nil, nil, false, false)
+			subnet.AddressPool = pool
+			if err := network.AddSubnet(subnet, usingIPs); err != nil {
+				t.Fatalf("fail to add subnet %s: %v", name, err)
+			}
+		}
+		return network
+	}
+
+	networks.RefreshNetwork("network", newNetwork("a", "b"))
+	if pool.Count() != 2 {
+		t.Fatalf("expected 2 ips occupied in pool, got %d", pool.Count())
+	}
+
+	networks.RefreshNetwork("network", newNetwork("a"))
+	if pool.Count() != 1 {
+		t.Fatalf("expected ips of removed subnet released, got %d ips occupied", pool.Count())
+	}
+
+	networks.RemoveNetwork("network")
+	if pool.Count() != 0 {
+		t.Fatalf("expected ips of removed network released, got %d ips occupied", pool.Count())
+	}
+}
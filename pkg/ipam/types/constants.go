@@ -45,6 +45,11 @@ func ParseIPFamilyFromString(in string) IPFamilyMode {
 		return IPv4Only
 	}
 
+	// if ipv6-only enabled, ipv4 addresses will never be allocated
+	if feature.IPv6OnlyEnabled() {
+		return IPv6Only
+	}
+
 	switch strings.ToLower(in) {
 	case "":
 		return ParseIPFamilyFromEnvOnce()
@@ -0,0 +1,84 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package types
+
+import (
+	"testing"
+
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+)
+
+func TestParseIPFamilyFromString(t *testing.T) {
+	tests := []struct {
+		name         string
+		featureGates string
+		in           string
+		expected     IPFamilyMode
+	}{
+		{
+			"ipv4 on dual stack",
+			"DualStack=true,IPv6Only=false",
+			"IPv4",
+			IPv4Only,
+		},
+		{
+			"dual stack on dual stack",
+			"DualStack=true,IPv6Only=false",
+			"DualStack",
+			DualStack,
+		},
+		{
+			"ipv6 on ipv6-only",
+			"DualStack=false,IPv6Only=true",
+			"IPv6",
+			IPv6Only,
+		},
+		{
+			"ipv4 on ipv6-only",
+			"DualStack=false,IPv6Only=true",
+			"IPv4",
+			IPv6Only,
+		},
+		{
+			"dual stack on ipv6-only",
+			"DualStack=true,IPv6Only=true",
+			"DualStack",
+			IPv6Only,
+		},
+		{
+			"ipv6 on single stack",
+			"DualStack=false,IPv6Only=false",
+			"IPv6",
+			IPv4Only,
+		},
+	}
+
+	defer func() {
+		_ = utilfeature.DefaultMutableFeatureGate.Set("DualStack=false,IPv6Only=false")
+	}()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := utilfeature.DefaultMutableFeatureGate.Set(test.featureGates); err != nil {
+				t.Fatalf("fail to set feature gates %s: %v", test.featureGates, err)
+			}
+			if actual := ParseIPFamilyFromString(test.in); actual != test.expected {
+				t.Errorf("test %s fails: expected %s but got %s", test.name, test.expected, actual)
+			}
+		})
+	}
+}
@@ -30,13 +30,37 @@ func NewNetworkSet() NetworkSet {
 }
 
 func (n NetworkSet) RefreshNetwork(name string, network *Network) {
+	releaseStaleAddresses(n[name], network)
 	n[name] = network
 }
 
 func (n NetworkSet) RemoveNetwork(name string) {
+	releaseStaleAddresses(n[name], nil)
 	delete(n, name)
 }
 
+// releaseStaleAddresses gives back the IPs held in address pools by subnets of previous network
+// which are removed or moved to another pool in current network, nil current means the network
+// is removed
+func releaseStaleAddresses(previous, current *Network) {
+	if previous == nil || previous.Subnets == nil {
+		return
+	}
+
+	for _, subnet := range previous.Subnets.Subnets {
+		if subnet.AddressPool == nil {
+			continue
+		}
+		if current != nil && current.Subnets != nil {
+			if index, exist := current.Subnets.SubnetIndexMap[subnet.Name]; exist &&
+				current.Subnets.Subnets[index].AddressPool == subnet.AddressPool {
+				continue
+			}
+		}
+		subnet.AddressPool.Remove(subnet.Name)
+	}
+}
+
 func (n NetworkSet) GetNetwork(name string) (*Network, error) {
 	if network, exist := n[name]; exist {
 		return network, nil
@@ -0,0 +1,195 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package types
+
+import (
+	"sync"
+	"time"
+)
+
+// QuarantineSet keeps the ip quarantines of subnets across network refreshing,
+// a nil set means quarantine is disabled.
+type QuarantineSet struct {
+	lock        sync.Mutex
+	duration    time.Duration
+	quarantines map[string]*IPQuarantine
+}
+
+func NewQuarantineSet(duration time.Duration) *QuarantineSet {
+	if duration <= 0 {
+		return nil
+	}
+	return &QuarantineSet{
+		duration:    duration,
+		quarantines: make(map[string]*IPQuarantine),
+	}
+}
+
+// Get returns the quarantine of subnet, it will be created if not exists.
+func (q *QuarantineSet) Get(subnet string) *IPQuarantine {
+	if q == nil {
+		return nil
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	quarantine, exist := q.quarantines[subnet]
+	if !exist {
+		quarantine = NewIPQuarantine(q.duration, time.Now)
+		q.quarantines[subnet] = quarantine
+	}
+	return quarantine
+}
+
+type quarantinedIP struct {
+	ip         string
+	releasedAt time.Time
+}
+
+// IPQuarantine holds recently released IPs of a subnet out of allocation until
+// quarantine duration passes. Released time is monotonic, so a FIFO queue is
+// enough to expire IPs in order, the index only keeps the latest released time
+// of every quarantined IP.
+type IPQuarantine struct {
+	lock     sync.Mutex
+	duration time.Duration
+	now      func() time.Time
+	queue    []quarantinedIP
+	head     int
+	index    map[string]time.Time
+	// committed is the IPs committed to store at last tracking, nil means never tracked
+	committed map[string]struct{}
+}
+
+func NewIPQuarantine(duration time.Duration, now func() time.Time) *IPQuarantine {
+	return &IPQuarantine{
+		duration: duration,
+		now:      now,
+		index:    make(map[string]time.Time),
+	}
+}
+
+// Add puts ip into quarantine from now on.
+func (q *IPQuarantine) Add(ip string) {
+	if q == nil {
+		return
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	now := q.now()
+	q.expire(now)
+	q.add(ip, now)
+}
+
+// Track records the committed IPs of subnet loaded from store, and puts the ones
+// committed at last tracking but not any more into quarantine, as they have been
+// recycled. IPs rolled back before committed are never tracked, so that they will
+// not be quarantined.
+func (q *IPQuarantine) Track(committed IPSet) {
+	if q == nil {
+		return
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	now := q.now()
+	q.expire(now)
+
+	current := make(map[string]struct{}, len(committed))
+	for ip := range committed {
+		current[ip] = struct{}{}
+	}
+	// nothing is recycled at the first tracking
+	if q.committed != nil {
+		for ip := range q.committed {
+			if _, exist := current[ip]; !exist {
+				q.add(ip, now)
+			}
+		}
+	}
+	q.committed = current
+}
+
+func (q *IPQuarantine) add(ip string, now time.Time) {
+	q.queue = append(q.queue, quarantinedIP{ip: ip, releasedAt: now})
+	q.index[ip] = now
+}
+
+// Remove takes ip out of quarantine, e.g. ip is assigned explicitly.
+func (q *IPQuarantine) Remove(ip string) {
+	if q == nil {
+		return
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	// stale entry in queue will be skipped while expiring
+	delete(q.index, ip)
+}
+
+// Has checks whether ip is still in quarantine.
+func (q *IPQuarantine) Has(ip string) bool {
+	if q == nil {
+		return false
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.expire(q.now())
+	_, exist := q.index[ip]
+	return exist
+}
+
+// Count returns the number of IPs in quarantine.
+func (q *IPQuarantine) Count() int {
+	if q == nil {
+		return 0
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.expire(q.now())
+	return len(q.index)
+}
+
+func (q *IPQuarantine) expire(now time.Time) {
+	for q.head < len(q.queue) {
+		entry := q.queue[q.head]
+		if now.Sub(entry.releasedAt) < q.duration {
+			break
+		}
+		// ip may be quarantined again after this entry, only the latest one counts
+		if releasedAt, exist := q.index[entry.ip]; exist && releasedAt.Equal(entry.releasedAt) {
+			delete(q.index, entry.ip)
+		}
+		q.queue[q.head] = quarantinedIP{}
+		q.head++
+	}
+
+	// compact queue to release memory of expired entries
+	if q.head > 0 && q.head*2 >= len(q.queue) {
+		q.queue = append(q.queue[:0:0], q.queue[q.head:]...)
+		q.head = 0
+	}
+}
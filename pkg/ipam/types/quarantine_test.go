@@ -0,0 +1,162 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package types
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func (f *fakeClock) Step(d time.Duration) {
+	f.now = f.now.Add(d)
+}
+
+func TestIPQuarantine(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	q := NewIPQuarantine(10*time.Second, clock.Now)
+
+	q.Add("192.168.0.1")
+	clock.Step(5 * time.Second)
+	q.Add("192.168.0.2")
+
+	if !q.Has("192.168.0.1") || !q.Has("192.168.0.2") || q.Count() != 2 {
+		t.Fatalf("expected both IPs quarantined, got count %d", q.Count())
+	}
+
+	// quarantine again will refresh the released time
+	clock.Step(3 * time.Second)
+	q.Add("192.168.0.1")
+
+	clock.Step(7 * time.Second)
+	if !q.Has("192.168.0.1") {
+		t.Errorf("expected 192.168.0.1 still quarantined after quarantined again")
+	}
+	if q.Has("192.168.0.2") {
+		t.Errorf("expected 192.168.0.2 expired")
+	}
+
+	q.Remove("192.168.0.1")
+	if q.Count() != 0 {
+		t.Errorf("expected no IP quarantined after removing, got %d", q.Count())
+	}
+
+	clock.Step(10 * time.Second)
+	if q.Count() != 0 || len(q.queue)-q.head != 0 {
+		t.Errorf("expected quarantine queue drained, got %d entries", len(q.queue)-q.head)
+	}
+}
+
+func TestIPQuarantine_Disabled(t *testing.T) {
+	var q *IPQuarantine
+	q.Add("192.168.0.1")
+	if q.Has("192.168.0.1") || q.Count() != 0 {
+		t.Errorf("expected nil quarantine holds nothing")
+	}
+
+	if set := NewQuarantineSet(0); set != nil || set.Get("test") != nil {
+		t.Errorf("expected quarantine disabled with zero duration")
+	}
+}
+
+func TestSubnet_AllocateWithQuarantine(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+
+	ip, cidr, _ := net.ParseCIDR("192.168.0.0/29")
+	subnet := NewSubnet("test", "fake", nil, nil, nil, ip, cidr, nil, nil, nil, false, false)
+	if err := subnet.Canonicalize(); err != nil {
+		t.Fatalf("fail to canonicalize: %v", err)
+	}
+	subnet.Quarantine = NewIPQuarantine(time.Minute, clock.Now)
+	if err := subnet.Sync(nil, NewIPSet()); err != nil {
+		t.Fatalf("fail to sync: %v", err)
+	}
+
+	committed := NewIPSet()
+	for allocatedIP := subnet.AllocateNext("", ""); allocatedIP != nil; allocatedIP = subnet.AllocateNext("", "") {
+		committed.Add(allocatedIP.Address.IP.String(), allocatedIP)
+	}
+	if committed.Count() == 0 {
+		t.Fatalf("fail to allocate any ip")
+	}
+
+	// rolling back an allocation never committed will not quarantine ip
+	var released string
+	for ip := range committed {
+		released = ip
+		break
+	}
+	subnet.Release(released)
+	if subnet.Quarantine.Has(released) {
+		t.Errorf("expected ip %s rolled back before committed not quarantined", released)
+	}
+	if allocatedIP := subnet.AllocateNext("", ""); allocatedIP == nil || allocatedIP.Address.IP.String() != released {
+		t.Fatalf("expected ip %s allocated again after rolled back, got %v", released, allocatedIP)
+	}
+
+	// ip is quarantined once recycled after committed
+	if err := subnet.Sync(nil, committed); err != nil {
+		t.Fatalf("fail to sync: %v", err)
+	}
+	committed.Delete(released)
+	if err := subnet.Sync(nil, committed); err != nil {
+		t.Fatalf("fail to sync: %v", err)
+	}
+
+	if subnet.IsAvailable() {
+		t.Errorf("expected subnet unavailable while released ip is quarantined")
+	}
+	if usage := subnet.Usage(); usage.Available != 0 || usage.Quarantined != 1 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+	if allocatedIP := subnet.AllocateNext("", ""); allocatedIP != nil {
+		t.Errorf("expected quarantined ip %s not allocated, got %s", released, allocatedIP.Address.IP)
+	}
+
+	clock.Step(time.Minute)
+	allocatedIP := subnet.AllocateNext("", "")
+	if allocatedIP == nil || allocatedIP.Address.IP.String() != released {
+		t.Errorf("expected ip %s allocated after quarantine expired, got %v", released, allocatedIP)
+	}
+
+	// explicit assignment is never blocked by quarantine
+	committed.Add(released, allocatedIP)
+	if err := subnet.Sync(nil, committed); err != nil {
+		t.Fatalf("fail to sync: %v", err)
+	}
+	committed.Delete(released)
+	if err := subnet.Sync(nil, committed); err != nil {
+		t.Fatalf("fail to sync: %v", err)
+	}
+	if !subnet.Quarantine.Has(released) {
+		t.Fatalf("expected ip %s quarantined again after recycled", released)
+	}
+	if _, err := subnet.Assign("pod", "ns", released, false); err != nil {
+		t.Errorf("fail to assign quarantined ip %s: %v", released, err)
+	}
+	if subnet.Quarantine.Count() != 0 {
+		t.Errorf("expected assigned ip removed from quarantine")
+	}
+}
@@ -0,0 +1,128 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package types
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+var ErrAllocationThrottled = errors.New("allocation rate limit of network exceeded")
+
+// IsAllocationThrottled checks whether allocation fails because of the rate limit of network,
+// which will be recovered after tokens are refilled
+func IsAllocationThrottled(err error) bool {
+	return errors.Is(err, ErrAllocationThrottled)
+}
+
+// AllocationRateLimiterSet keeps the allocation rate limiters of networks across network refreshing,
+// so that tokens are not refilled by every refresh.
+type AllocationRateLimiterSet struct {
+	lock     sync.Mutex
+	now      func() time.Time
+	limiters map[string]*AllocationRateLimiter
+}
+
+func NewAllocationRateLimiterSet(now func() time.Time) *AllocationRateLimiterSet {
+	return &AllocationRateLimiterSet{
+		now:      now,
+		limiters: make(map[string]*AllocationRateLimiter),
+	}
+}
+
+// Get returns the rate limiter of network, it will be re-created if the limit changes, and nil
+// is returned if qps is not positive which means allocations of network are not limited.
+func (a *AllocationRateLimiterSet) Get(network string, qps, burst int32) *AllocationRateLimiter {
+	if a == nil {
+		return nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if qps <= 0 {
+		delete(a.limiters, network)
+		return nil
+	}
+	if burst <= 0 {
+		burst = qps
+	}
+
+	limiter, exist := a.limiters[network]
+	if !exist || limiter.qps != float64(qps) || limiter.burst != float64(burst) {
+		limiter = NewAllocationRateLimiter(qps, burst, a.now)
+		a.limiters[network] = limiter
+	}
+	return limiter
+}
+
+// AllocationRateLimiter is a token bucket of allocations, which starts full.
+type AllocationRateLimiter struct {
+	lock   sync.Mutex
+	qps    float64
+	burst  float64
+	now    func() time.Time
+	tokens float64
+	last   time.Time
+}
+
+func NewAllocationRateLimiter(qps, burst int32, now func() time.Time) *AllocationRateLimiter {
+	return &AllocationRateLimiter{
+		qps:    float64(qps),
+		burst:  float64(burst),
+		now:    now,
+		tokens: float64(burst),
+		last:   now(),
+	}
+}
+
+// Allow takes a token if any is left, a nil limiter always allows.
+func (l *AllocationRateLimiter) Allow() bool {
+	if l == nil {
+		return true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens = math.Min(l.burst, l.tokens+elapsed.Seconds()*l.qps)
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// Return gives back a token taken by Allow when the allocation fails, so that failures like
+// exhausted subnets do not drain the budget of network, a nil limiter does nothing.
+func (l *AllocationRateLimiter) Return() {
+	if l == nil {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.tokens = math.Min(l.burst, l.tokens+1)
+}
@@ -0,0 +1,133 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAllocationRateLimiter(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	l := NewAllocationRateLimiter(2, 3, clock.Now)
+
+	for i := 0; i < 3; i++ {
+		if !l.Allow() {
+			t.Fatalf("expected allocation %d allowed by burst", i)
+		}
+	}
+	if l.Allow() {
+		t.Fatalf("expected allocation throttled after burst")
+	}
+
+	// two tokens per second
+	clock.Step(500 * time.Millisecond)
+	if !l.Allow() {
+		t.Errorf("expected allocation allowed after one token refilled")
+	}
+	if l.Allow() {
+		t.Errorf("expected allocation throttled after refilled token is taken")
+	}
+
+	// tokens are capped by burst
+	clock.Step(time.Minute)
+	for i := 0; i < 3; i++ {
+		if !l.Allow() {
+			t.Fatalf("expected allocation %d allowed after refilled", i)
+		}
+	}
+	if l.Allow() {
+		t.Errorf("expected allocation throttled after refilled burst")
+	}
+
+	var nilLimiter *AllocationRateLimiter
+	if !nilLimiter.Allow() {
+		t.Errorf("expected nil limiter always allows")
+	}
+}
+
+func TestAllocationRateLimiterReturn(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	l := NewAllocationRateLimiter(1, 2, clock.Now)
+
+	if !l.Allow() || !l.Allow() {
+		t.Fatalf("expected allocations allowed by burst")
+	}
+
+	// a failed allocation gives back its token
+	l.Return()
+	if !l.Allow() {
+		t.Errorf("expected allocation allowed by returned token")
+	}
+	if l.Allow() {
+		t.Errorf("expected allocation throttled after returned token is taken")
+	}
+
+	// returned tokens are capped by burst
+	clock.Step(time.Minute)
+	l.Return()
+	for i := 0; i < 2; i++ {
+		if !l.Allow() {
+			t.Fatalf("expected allocation %d allowed after refilled", i)
+		}
+	}
+	if l.Allow() {
+		t.Errorf("expected returned token not to exceed burst")
+	}
+
+	var nilLimiter *AllocationRateLimiter
+	nilLimiter.Return()
+}
+
+func TestAllocationRateLimiterSet(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	s := NewAllocationRateLimiterSet(clock.Now)
+
+	if s.Get("net1", 0, 10) != nil {
+		t.Fatalf("expected no limiter without qps")
+	}
+
+	l := s.Get("net1", 1, 0)
+	if !l.Allow() || l.Allow() {
+		t.Fatalf("expected burst defaults to qps")
+	}
+	if s.Get("net1", 1, 1) != l {
+		t.Errorf("expected limiter kept if limit is unchanged")
+	}
+	if s.Get("net2", 1, 1) == l {
+		t.Errorf("expected limiter of different network")
+	}
+
+	changed := s.Get("net1", 1, 2)
+	if changed == l || !changed.Allow() || !changed.Allow() || changed.Allow() {
+		t.Errorf("expected limiter re-created if limit changes")
+	}
+
+	if s.Get("net1", 0, 0) != nil {
+		t.Errorf("expected limiter removed if qps is cleared")
+	}
+}
+
+func TestIsAllocationThrottled(t *testing.T) {
+	if !IsAllocationThrottled(fmt.Errorf("unable to allocate: %w", ErrAllocationThrottled)) {
+		t.Errorf("expected wrapped throttled error")
+	}
+	if IsAllocationThrottled(ErrNoAvailableIP) {
+		t.Errorf("expected exhausted error not throttled")
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package types
+
+import "sync"
+
+// DefaultAddressSelectorName is the selector used if subnet specifies none
+const DefaultAddressSelectorName = "default"
+
+// AddressSelector picks the next address to allocate from the available addresses of subnet,
+// it is called with allocator locked so it must not block
+type AddressSelector interface {
+	// Select returns the picked address, or empty string if none of the addresses is acceptable,
+	// isFree tells whether an address is neither used nor quarantined
+	Select(subnet *Subnet, isFree func(ip string) bool) string
+}
+
+// AddressSelectorFunc adapts an ordinary function to AddressSelector
+type AddressSelectorFunc func(subnet *Subnet, isFree func(ip string) bool) string
+
+func (f AddressSelectorFunc) Select(subnet *Subnet, isFree func(ip string) bool) string {
+	return f(subnet, isFree)
+}
+
+var (
+	addressSelectorLock sync.RWMutex
+	addressSelectors    = map[string]AddressSelector{
+		DefaultAddressSelectorName: AddressSelectorFunc(roundRobinSelect),
+	}
+)
+
+// RegisterAddressSelector makes a selector available to subnets by name, it is expected
+// to be called on initialization of manager and will overwrite the existing one with
+// the same name
+func RegisterAddressSelector(name string, selector AddressSelector) {
+	addressSelectorLock.Lock()
+	defer addressSelectorLock.Unlock()
+
+	addressSelectors[name] = selector
+}
+
+// GetAddressSelector returns the registered selector by name, empty name means the default one
+func GetAddressSelector(name string) (AddressSelector, bool) {
+	if len(name) == 0 {
+		name = DefaultAddressSelectorName
+	}
+
+	addressSelectorLock.RLock()
+	defer addressSelectorLock.RUnlock()
+
+	selector, exist := addressSelectors[name]
+	return selector, exist
+}
+
+// roundRobinSelect picks the next free address after the last allocated one
+func roundRobinSelect(subnet *Subnet, isFree func(ip string) bool) string {
+	for i := 0; i < subnet.AvailableIPs.Count(); i++ {
+		if ipCandidate := subnet.AvailableIPs.Next(); isFree(ipCandidate) {
+			return ipCandidate
+		}
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package types
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestSubnet_AllocateNextWithSelector(t *testing.T) {
+	// prefer the even addresses and skip the first ten ones
+	RegisterAddressSelector("test-even", AddressSelectorFunc(func(subnet *Subnet, isFree func(ip string) bool) string {
+		for _, ip := range subnet.AvailableIPs.IPs {
+			if last := net.ParseIP(ip).To4()[3]; last > 10 && last%2 == 0 && isFree(ip) {
+				return ip
+			}
+		}
+		return ""
+	}))
+	// always pick an address out of range
+	RegisterAddressSelector("test-invalid", AddressSelectorFunc(func(subnet *Subnet, isFree func(ip string) bool) string {
+		return "10.0.0.1"
+	}))
+
+	tests := []struct {
+		name        string
+		selector    string
+		expectedIPs []string
+	}{
+		{
+			"default selector",
+			"",
+			[]string{"192.168.0.1", "192.168.0.2", "192.168.0.3"},
+		},
+		{
+			"unknown selector",
+			"not-exist",
+			[]string{"192.168.0.1", "192.168.0.2", "192.168.0.3"},
+		},
+		{
+			"custom selector",
+			"test-even",
+			[]string{"192.168.0.12", "192.168.0.14", "192.168.0.16"},
+		},
+		{
+			"invalid address selected",
+			"test-invalid",
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, cidr, _ := net.ParseCIDR("192.168.0.0/24")
+			subnet := NewSubnet("test", "fake", nil, nil, nil, nil, cidr, nil, nil, nil, false, false)
+			subnet.AddressSelector = test.selector
+			if err := subnet.Canonicalize(); err != nil {
+				t.Fatalf("test %s fails: fail to canonicalize: %v", test.name, err)
+			}
+			if err := subnet.Sync(nil, NewIPSet()); err != nil {
+				t.Fatalf("test %s fails: fail to sync: %v", test.name, err)
+			}
+
+			var allocatedIPs []string
+			for i := 0; i < 3; i++ {
+				if allocatedIP := subnet.AllocateNext("", ""); allocatedIP != nil {
+					allocatedIPs = append(allocatedIPs, allocatedIP.Address.IP.String())
+				}
+			}
+			if !reflect.DeepEqual(allocatedIPs, test.expectedIPs) {
+				t.Fatalf("test %s fails: expected %v but got %v", test.name, test.expectedIPs, allocatedIPs)
+			}
+		})
+	}
+}
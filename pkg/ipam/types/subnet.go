@@ -26,12 +26,22 @@ import (
 )
 
 var (
-	ErrNoAvailableSubnet      = errors.New("no available subnet")
-	ErrNotFoundSubnet         = errors.New("subnet not found")
-	ErrNotFoundAssignedIP     = errors.New("assigned ip not found")
-	ErrNotAvailableAssignedIP = errors.New("assigned ip is not available")
+	ErrNoAvailableSubnet       = errors.New("no available subnet")
+	ErrNoAvailableIP           = errors.New("no available ip in subnet")
+	ErrNotFoundSubnet          = errors.New("subnet not found")
+	ErrNotFoundAssignedIP      = errors.New("assigned ip not found")
+	ErrNotAvailableAssignedIP  = errors.New("assigned ip is not available")
+	ErrConflictedAssignedIP    = errors.New("assigned ip is conflicted with address outside of cluster")
+	ErrRangeReservedAssignedIP = errors.New("assigned ip is reserved for infrastructure")
+	ErrReservedIPInUse         = errors.New("ip to reserve is in use")
 )
 
+// IsCapacityExhausted checks whether allocation fails because no ip is left in the subnets
+// to allocate from, which will only be recovered after ips are released or subnets are added
+func IsCapacityExhausted(err error) bool {
+	return errors.Is(err, ErrNoAvailableSubnet) || errors.Is(err, ErrNoAvailableIP)
+}
+
 func NewSubnetSlice() *SubnetSlice {
 	return &SubnetSlice{
 		Subnets:        make([]*Subnet, 0),
@@ -411,6 +421,10 @@ func (s *Subnet) Contains(addr net.IP) bool {
 		return false
 	}
 
+	if s.isNetworkOrBroadcastIP(addr) {
+		return false
+	}
+
 	if s.IsBlackIP(addr.String()) {
 		return false
 	}
@@ -418,6 +432,21 @@ func (s *Subnet) Contains(addr net.IP) bool {
 	return true
 }
 
+// isNetworkOrBroadcastIP checks if an ip is the network address of CIDR, which is also
+// the subnet-router anycast address for ipv6, or the broadcast address of an ipv4 CIDR,
+// these addresses must never be allocated even if start or end is explicitly set to them
+func (s *Subnet) isNetworkOrBroadcastIP(addr net.IP) bool {
+	if addr.Equal(s.CIDR.IP.Mask(s.CIDR.Mask)) {
+		return true
+	}
+
+	if s.CIDR.IP.To4() == nil {
+		return false
+	}
+
+	return addr.Equal(ip.NextIP(utils.LastIP(s.CIDR)))
+}
+
 // Sync will generate netID, filtered Reserved List, Available IP Slice
 // and Using IP Set based on subnet spec and input
 func (s *Subnet) Sync(parentNetID *uint32, ipSet IPSet) error {
@@ -436,6 +465,15 @@ func (s *Subnet) Sync(parentNetID *uint32, ipSet IPSet) error {
 	s.ReservedList = filteredReservedList
 	s.ReservedIPCount = len(s.ReservedList)
 
+	// filter range reserved ips
+	filteredRangeReservedIPs := make(map[string]struct{}, len(s.RangeReservedIPs))
+	for rip := range s.RangeReservedIPs {
+		if s.Contains(net.ParseIP(rip)) {
+			filteredRangeReservedIPs[rip] = struct{}{}
+		}
+	}
+	s.RangeReservedIPs = filteredRangeReservedIPs
+
 	// generate valid Using IP Set
 	s.UsingIPs = NewIPSet()
 	for ip, content := range ipSet {
@@ -443,6 +481,7 @@ func (s *Subnet) Sync(parentNetID *uint32, ipSet IPSet) error {
 			s.UsingIPs.Add(ip, content)
 		}
 	}
+	s.Quarantine.Track(s.UsingIPs)
 
 	// pre-assign reserved ip
 	for rip := range s.ReservedList {
@@ -462,6 +501,7 @@ func (s *Subnet) Sync(parentNetID *uint32, ipSet IPSet) error {
 			})
 		}
 	}
+	s.AddressPool.Reset(s.Name, s.UsingIPs)
 
 	// generate valid Available IP Slice
 	s.AvailableIPs = NewIPSlice()
@@ -492,7 +532,46 @@ func (s *Subnet) Overlap(s1 *Subnet) bool {
 }
 
 func (s *Subnet) IsAvailable() bool {
-	return s.AvailableIPs.Count() > s.UsingIPCount() && !s.Private
+	return s.AvailableIPCount() > 0 && !s.Private
+}
+
+// AvailableIPCount will count the IP which can be allocated, the
+// quarantined, conflicted and range reserved IPs will be excluded
+func (s *Subnet) AvailableIPCount() int {
+	if count := s.AvailableIPs.Count() - s.UsingIPCount() - s.Quarantine.Count() - s.idleConflictedIPCount() -
+		s.idleRangeReservedIPCount(); count > 0 {
+		return count
+	}
+	return 0
+}
+
+// idleConflictedIPCount will count the conflicted IPs which would be
+// available otherwise
+func (s *Subnet) idleConflictedIPCount() int {
+	var count int
+	for ip := range s.ConflictedIPs {
+		if s.Contains(net.ParseIP(ip)) && !s.IsBlackIP(ip) && !s.UsingIPs.Has(ip) && !s.Quarantine.Has(ip) {
+			count++
+		}
+	}
+	return count
+}
+
+// idleRangeReservedIPCount will count the range reserved IPs which would be
+// available otherwise, the conflicted ones have been counted already
+func (s *Subnet) idleRangeReservedIPCount() int {
+	var count int
+	for ip := range s.RangeReservedIPs {
+		if s.Contains(net.ParseIP(ip)) && !s.UsingIPs.Has(ip) && !s.Quarantine.Has(ip) && !s.IsConflictedIP(ip) {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *Subnet) IsConflictedIP(ip string) bool {
+	_, found := s.ConflictedIPs[ip]
+	return found
 }
 
 // UsingIPCount will count the IP which are being used, but
@@ -505,18 +584,26 @@ func (s *Subnet) Usage() *Usage {
 	return &Usage{
 		Total:          uint32(s.AvailableIPs.Count()),
 		Used:           uint32(s.UsingIPCount()),
-		Available:      uint32(s.AvailableIPs.Count() - s.UsingIPCount()),
+		Available:      uint32(s.AvailableIPCount()),
+		Quarantined:    uint32(s.Quarantine.Count()),
+		Conflicted:     uint32(len(s.ConflictedIPs)),
+		RangeReserved:  uint32(len(s.RangeReservedIPs)),
 		LastAllocation: s.AvailableIPs.Current(),
 	}
 }
 
 func (s *Subnet) AllocateNext(podName, podNamespace string) *IP {
-	for i := 0; i < s.AvailableIPs.Count(); i++ {
-		ipCandidate := s.AvailableIPs.Next()
-		if s.UsingIPs.Has(ipCandidate) {
-			continue
-		}
+	selector, exist := GetAddressSelector(s.AddressSelector)
+	if !exist {
+		selector, _ = GetAddressSelector(DefaultAddressSelectorName)
+	}
 
+	isFree := func(ip string) bool {
+		return !s.UsingIPs.Has(ip) && !s.Quarantine.Has(ip) && !s.IsReservedIP(ip) && !s.IsConflictedIP(ip) &&
+			!s.IsRangeReservedIP(ip) && !s.AddressPool.OccupiedByOthers(ip, s.Name)
+	}
+
+	if ipCandidate := selector.Select(s, isFree); len(ipCandidate) > 0 && isFree(ipCandidate) && s.Contains(net.ParseIP(ipCandidate)) {
 		availableIP := &IP{
 			Address: &net.IPNet{
 				IP:   net.ParseIP(ipCandidate),
@@ -532,6 +619,7 @@ func (s *Subnet) AllocateNext(podName, podNamespace string) *IP {
 		}
 
 		s.UsingIPs.Add(ipCandidate, availableIP)
+		s.AddressPool.Occupy(ipCandidate, s.Name)
 
 		return availableIP
 	}
@@ -543,7 +631,10 @@ func (s *Subnet) Release(ip string) {
 	if s.IsReservedIP(ip) {
 		s.UsingIPs.Update(ip, "", "", IPStatusReserved)
 	} else {
+		// ip released here is rolled back before committed, recycled ip
+		// will be quarantined while syncing committed ips instead
 		s.UsingIPs.Delete(ip)
+		s.AddressPool.Free(ip, s.Name)
 	}
 }
 
@@ -553,7 +644,18 @@ func (s *Subnet) Assign(podName, podNamespace, ip string, forced bool) (*IP, err
 	}
 
 	switch {
+	case !s.UsingIPs.Has(ip) && s.AddressPool.OccupiedByOthers(ip, s.Name):
+		return nil, ErrNotAvailableAssignedIP
+	case s.IsConflictedIP(ip) && (!s.UsingIPs.Has(ip) || s.UsingIPs.Get(ip).PodNamespace != podNamespace ||
+		s.UsingIPs.Get(ip).PodName != podName):
+		// conflicted ip is only kept by the pod using it
+		return nil, ErrConflictedAssignedIP
+	case s.IsRangeReservedIP(ip) && !s.UsingIPs.Has(ip):
+		return nil, ErrRangeReservedAssignedIP
 	case !s.UsingIPs.Has(ip):
+		// explicitly assigned ip is never held by quarantine
+		s.Quarantine.Remove(ip)
+		s.AddressPool.Occupy(ip, s.Name)
 		s.UsingIPs.Add(ip, &IP{
 			Address: &net.IPNet{
 				IP:   net.ParseIP(ip),
@@ -583,6 +685,40 @@ func (s *Subnet) IsReservedIP(ip string) bool {
 	return found
 }
 
+func (s *Subnet) IsRangeReservedIP(ip string) bool {
+	_, found := s.RangeReservedIPs[ip]
+	return found
+}
+
+// ReserveRange reserves ips for infrastructure, which will never be allocated or assigned, the ips
+// not allocatable anyway are skipped. None of the ips is reserved if any of them is in use
+func (s *Subnet) ReserveRange(ips []string) error {
+	var toReserve []string
+	for _, ip := range ips {
+		if !s.Contains(net.ParseIP(ip)) {
+			continue
+		}
+
+		// reserved ips of spec are not in use until assigned to pods
+		if using := s.UsingIPs.Get(ip); using != nil && (!s.IsReservedIP(ip) || using.Status != IPStatusReserved ||
+			len(using.PodName) > 0) {
+			return fmt.Errorf("%w: %s", ErrReservedIPInUse, ip)
+		}
+		if s.AddressPool.OccupiedByOthers(ip, s.Name) {
+			return fmt.Errorf("%w: %s is occupied by other networks", ErrReservedIPInUse, ip)
+		}
+		toReserve = append(toReserve, ip)
+	}
+
+	if s.RangeReservedIPs == nil {
+		s.RangeReservedIPs = make(map[string]struct{}, len(toReserve))
+	}
+	for _, ip := range toReserve {
+		s.RangeReservedIPs[ip] = struct{}{}
+	}
+	return nil
+}
+
 func (s *Subnet) IsBlackIP(ip string) bool {
 	_, found := s.BlackList[ip]
 	return found
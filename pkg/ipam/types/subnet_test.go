@@ -17,7 +17,10 @@
 package types
 
 import (
+	"errors"
+	"fmt"
 	"net"
+	"reflect"
 	"testing"
 )
 
@@ -83,3 +86,189 @@ func TestSubnet_AllocateNext(t *testing.T) {
 		t.Logf("the %d ip is %s", i, allocatedIP)
 	}
 }
+
+func TestSubnet_SmallCIDR(t *testing.T) {
+	tests := []struct {
+		name          string
+		cidr          string
+		start         string
+		end           string
+		expectInvalid bool
+		expectedIPs   []string
+	}{
+		{
+			"ipv4 /30",
+			"192.168.0.0/30",
+			"",
+			"",
+			false,
+			[]string{"192.168.0.1", "192.168.0.2"},
+		},
+		{
+			"ipv4 /30 with start and end at network and broadcast address",
+			"192.168.0.0/30",
+			"192.168.0.0",
+			"192.168.0.3",
+			false,
+			[]string{"192.168.0.1", "192.168.0.2"},
+		},
+		{
+			"ipv4 /31",
+			"192.168.0.0/31",
+			"",
+			"",
+			true,
+			nil,
+		},
+		{
+			"ipv6 /126",
+			"fe80::/126",
+			"",
+			"",
+			false,
+			[]string{"fe80::1", "fe80::2", "fe80::3"},
+		},
+		{
+			"ipv6 /126 with start at subnet-router anycast address",
+			"fe80::/126",
+			"fe80::",
+			"",
+			false,
+			[]string{"fe80::1", "fe80::2", "fe80::3"},
+		},
+		{
+			"ipv6 /127",
+			"fe80::/127",
+			"",
+			"",
+			true,
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, cidr, _ := net.ParseCIDR(test.cidr)
+			subnet := NewSubnet("test", "fake", nil, net.ParseIP(test.start), net.ParseIP(test.end), nil, cidr,
+				nil, nil, nil, false, cidr.IP.To4() == nil)
+			if err := subnet.Canonicalize(); (err != nil) != test.expectInvalid {
+				t.Fatalf("test %s fails: unexpected canonicalization error %v", test.name, err)
+			}
+			if test.expectInvalid {
+				return
+			}
+			if err := subnet.Sync(nil, NewIPSet()); err != nil {
+				t.Fatalf("test %s fails: fail to sync: %v", test.name, err)
+			}
+
+			var allocatedIPs []string
+			for allocatedIP := subnet.AllocateNext("", ""); allocatedIP != nil; allocatedIP = subnet.AllocateNext("", "") {
+				allocatedIPs = append(allocatedIPs, allocatedIP.Address.IP.String())
+			}
+			if !reflect.DeepEqual(allocatedIPs, test.expectedIPs) {
+				t.Fatalf("test %s fails: expected %v but got %v", test.name, test.expectedIPs, allocatedIPs)
+			}
+		})
+	}
+}
+
+func TestSubnet_ConflictedIPs(t *testing.T) {
+	ip, cidr, _ := net.ParseCIDR("192.168.0.0/29")
+	subnet := NewSubnet("test", "fake", nil, nil, nil, ip, cidr, nil, nil, nil, false, false)
+	if err := subnet.Canonicalize(); err != nil {
+		t.Fatalf("fail to canonicalize: %v", err)
+	}
+	if err := subnet.Sync(nil, NewIPSet()); err != nil {
+		t.Fatalf("fail to sync: %v", err)
+	}
+
+	total := subnet.AvailableIPCount()
+	subnet.ConflictedIPs = map[string]struct{}{"192.168.0.2": {}}
+	if usage := subnet.Usage(); int(usage.Available) != total-1 || usage.Conflicted != 1 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+
+	for allocatedIP := subnet.AllocateNext("", ""); allocatedIP != nil; allocatedIP = subnet.AllocateNext("", "") {
+		if allocatedIP.Address.IP.String() == "192.168.0.2" {
+			t.Fatalf("expected conflicted ip never allocated")
+		}
+	}
+	if subnet.IsAvailable() {
+		t.Errorf("expected subnet unavailable with only conflicted ip left")
+	}
+
+	if _, err := subnet.Assign("pod", "ns", "192.168.0.2", false); err != ErrConflictedAssignedIP {
+		t.Errorf("expected conflicted ip not assigned, got %v", err)
+	}
+
+	// clearing conflict makes ip allocatable again
+	subnet.ConflictedIPs = nil
+	if allocatedIP := subnet.AllocateNext("", ""); allocatedIP == nil || allocatedIP.Address.IP.String() != "192.168.0.2" {
+		t.Errorf("expected cleared ip allocated, got %v", allocatedIP)
+	}
+}
+
+func TestIsCapacityExhausted(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"nil", nil, false},
+		{"no available subnet", ErrNoAvailableSubnet, true},
+		{"wrapped no available ip", fmt.Errorf("fail to get available ip from subnet s1: %w", ErrNoAvailableIP), true},
+		{"flattened no available ip", fmt.Errorf("fail: %v", ErrNoAvailableIP), false},
+		{"subnet not found", ErrNotFoundSubnet, false},
+		{"other error", errors.New("unknown"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IsCapacityExhausted(test.err); got != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestSubnet_ReserveRange(t *testing.T) {
+	_, cidr, _ := net.ParseCIDR("192.168.0.0/29")
+	subnet := NewSubnet("test", "fake", nil, nil, nil, net.ParseIP("192.168.0.1"), cidr,
+		map[string]struct{}{"192.168.0.6": {}}, nil, nil, false, false)
+	if err := subnet.Canonicalize(); err != nil {
+		t.Fatalf("fail to canonicalize: %v", err)
+	}
+	if err := subnet.Sync(nil, NewIPSet()); err != nil {
+		t.Fatalf("fail to sync: %v", err)
+	}
+	if allocatedIP := subnet.AllocateNext("pod", "ns"); allocatedIP == nil || allocatedIP.Address.IP.String() != "192.168.0.2" {
+		t.Fatalf("expected 192.168.0.2 allocated, got %v", allocatedIP)
+	}
+
+	total := subnet.AvailableIPCount()
+	if err := subnet.ReserveRange([]string{"192.168.0.2", "192.168.0.3"}); !errors.Is(err, ErrReservedIPInUse) {
+		t.Fatalf("expected range with allocated ip rejected, got %v", err)
+	}
+	if subnet.IsRangeReservedIP("192.168.0.3") || subnet.AvailableIPCount() != total {
+		t.Fatalf("expected no ip reserved by rejected range")
+	}
+
+	// gateway is skipped and idle reserved ip of spec is allowed
+	if err := subnet.ReserveRange([]string{"192.168.0.1", "192.168.0.3", "192.168.0.4", "192.168.0.6"}); err != nil {
+		t.Fatalf("fail to reserve range: %v", err)
+	}
+	if usage := subnet.Usage(); int(usage.Available) != total-2 || usage.RangeReserved != 3 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+
+	for allocatedIP := subnet.AllocateNext("", ""); allocatedIP != nil; allocatedIP = subnet.AllocateNext("", "") {
+		if subnet.IsRangeReservedIP(allocatedIP.Address.IP.String()) {
+			t.Fatalf("expected range reserved ip %v never allocated", allocatedIP.Address.IP)
+		}
+	}
+	if _, err := subnet.Assign("pod", "ns", "192.168.0.3", false); err != ErrRangeReservedAssignedIP {
+		t.Errorf("expected range reserved ip not assigned, got %v", err)
+	}
+	if _, err := subnet.Assign("pod", "ns", "192.168.0.6", true); err != nil {
+		t.Errorf("expected reserved ip of spec still assigned by force, got %v", err)
+	}
+}
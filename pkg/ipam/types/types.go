@@ -29,8 +29,17 @@ type Network struct {
 	NetID               *uint32
 	LastAllocatedSubnet string
 	Type                NetworkType
+	// AddressPool is the name of address pool shared with other networks,
+	// empty means addresses of this network are not shared
+	AddressPool string
+	// AllocationQPS and AllocationBurst limit the rate of allocations,
+	// zero QPS means allocations are not limited
+	AllocationQPS   int32
+	AllocationBurst int32
 
 	Subnets *SubnetSlice
+	// RateLimiter is kept across refreshing by allocator, nil means no limit
+	RateLimiter *AllocationRateLimiter
 }
 
 type NetworkSet map[string]*Network
@@ -50,12 +59,28 @@ type Subnet struct {
 	LastAllocatedIP net.IP
 	Private         bool
 	IPv6            bool
+	// AddressSelector is the name of registered selector to pick addresses,
+	// unknown selector falls back to the default one
+	AddressSelector string
+	// ConflictedIPs are found in use outside of cluster, they are never
+	// allocated until cleared
+	ConflictedIPs map[string]struct{}
+	// RangeReservedIPs are reserved for infrastructure by ip reservations, they
+	// are never allocated or assigned until the reservations are removed
+	RangeReservedIPs map[string]struct{}
 
 	// Status fields
 	// `Sync` method will initialize these
 	AvailableIPs    *IPSlice
 	UsingIPs        IPSet
 	ReservedIPCount int
+	// Quarantine holds recently recycled IPs out of allocation,
+	// nil means quarantine is disabled
+	Quarantine *IPQuarantine
+	// AddressPool tracks the IPs occupied by overlapped subnets of other networks,
+	// nil means addresses are not shared. IPs occupied by others are not excluded
+	// from available count, so the count might be larger than the real one
+	AddressPool *AddressPool
 }
 
 type SubnetSlice struct {
@@ -92,5 +117,8 @@ type Usage struct {
 	Total          uint32
 	Used           uint32
 	Available      uint32
+	Quarantined    uint32
+	Conflicted     uint32
+	RangeReserved  uint32
 	LastAllocation string
 }
@@ -20,6 +20,9 @@ func (u *Usage) Add(in *Usage) {
 	u.Total += in.Total
 	u.Used += in.Used
 	u.Available += in.Available
+	u.Quarantined += in.Quarantined
+	u.Conflicted += in.Conflicted
+	u.RangeReserved += in.RangeReserved
 	if len(u.LastAllocation) == 0 {
 		u.LastAllocation = in.LastAllocation
 	}
@@ -25,6 +25,20 @@ func init() {
 	metrics.Registry.MustRegister(IPUsageGauge,
 		IPAllocationPeriodSummary,
 		RemoteClusterStatusCheckDuration,
+		BGPPeerLastAdvertisementTimestamp,
+		IPQuarantinedGauge,
+		IPConflictedGauge,
+		IPRangeReservedGauge,
+		IPUnboundOldestAgeGauge,
+		PodFinalizerStuckGauge,
+		IPAllocationTimeoutCounter,
+		PodReconcileOutcomeCounter,
+		DaemonHandlerInFlightGauge,
+		DaemonHandlerRejectedCounter,
+		SubnetIPAllocationCounter,
+		SubnetIPReleaseCounter,
+		IPInstanceDuplicateCounter,
+		NetworkIPAllocationThrottledCounter,
 	)
 }
 
@@ -57,6 +71,17 @@ const (
 	IPNormalAllocateType   = "normal"
 )
 
+// allocate paths of IP allocation, every terminal path of allocation
+// will be observed with its own path alongside the allocate type, and
+// the unknown path means allocation fails before any path is taken
+const (
+	IPUnknownAllocatePath    = "unknown"
+	IPAllocateAllocatePath   = "allocate"
+	IPReuseAllocatePath      = "reuse"
+	IPReassignAllocatePath   = "reassign"
+	IPReallocateAllocatePath = "reallocate"
+)
+
 var IPAllocationPeriodSummary = prometheus.NewSummaryVec(
 	prometheus.SummaryOpts{
 		Name:       "ip_allocation_period",
@@ -66,6 +91,89 @@ var IPAllocationPeriodSummary = prometheus.NewSummaryVec(
 	[]string{
 		"allocateType",
 		"success",
+		"allocatePath",
+	},
+)
+
+var IPAllocationTimeoutCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ip_allocation_timeout_total",
+		Help: "the count of ip allocations for pod aborted by timeout",
+	},
+	[]string{
+		"allocatePath",
+	},
+)
+
+// outcomes of pod reconciliation, every terminal branch of pod controller is counted
+// with its own outcome
+const (
+	PodReconcileOutcomeAllocated  = "allocated"
+	PodReconcileOutcomeReused     = "reused"
+	PodReconcileOutcomeReassigned = "reassigned"
+	PodReconcileOutcomeReserved   = "reserved"
+	PodReconcileOutcomeReleased   = "released"
+	PodReconcileOutcomeDecoupled  = "decoupled"
+	PodReconcileOutcomeSkipped    = "skipped"
+	PodReconcileOutcomeRelocated  = "relocated"
+	PodReconcileOutcomePaused     = "paused"
+	PodReconcileOutcomeExternal   = "external"
+	PodReconcileOutcomeIgnored    = "ignored"
+	PodReconcileOutcomeFailed     = "failed"
+	PodReconcileOutcomeExhausted  = "exhausted"
+	PodReconcileOutcomeDeferred   = "deferred"
+	PodReconcileOutcomeThrottled  = "throttled"
+)
+
+var PodReconcileOutcomeCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "pod_reconcile_outcome_total",
+		Help: "the count of pod reconciliations by outcome",
+	},
+	[]string{
+		"outcome",
+	},
+)
+
+var NetworkIPAllocationThrottledCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "network_ip_allocation_throttled_total",
+		Help: "the count of ip allocations rejected by the allocation rate limit of networks",
+	},
+	[]string{
+		"networkName",
+	},
+)
+
+var SubnetIPAllocationCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "subnet_ip_allocations_total",
+		Help: "the count of IPs committed to pods in different subnets",
+	},
+	[]string{
+		"subnetName",
+		"ipFamily",
+	},
+)
+
+var SubnetIPReleaseCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "subnet_ip_releases_total",
+		Help: "the count of IPs released from pods in different subnets",
+	},
+	[]string{
+		"subnetName",
+		"ipFamily",
+	},
+)
+
+var IPInstanceDuplicateCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ip_instance_duplicates_total",
+		Help: "the count of IP instances deleted for claiming the same address with others in different networks",
+	},
+	[]string{
+		"networkName",
 	},
 )
 
@@ -79,3 +187,78 @@ var RemoteClusterStatusCheckDuration = prometheus.NewHistogramVec(
 		"clusterName",
 	},
 )
+
+var IPQuarantinedGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "ip_quarantined",
+		Help: "the count of recently released IPs which are held out of allocation in different subnets",
+	},
+	[]string{
+		"subnetName",
+	},
+)
+
+var IPConflictedGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "ip_conflicted",
+		Help: "the count of IPs found in use outside of cluster which are held out of allocation in different subnets",
+	},
+	[]string{
+		"subnetName",
+	},
+)
+
+var IPRangeReservedGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "ip_range_reserved",
+		Help: "the count of IPs reserved by active ip reservations which are held out of allocation in different subnets",
+	},
+	[]string{
+		"subnetName",
+	},
+)
+
+var IPUnboundOldestAgeGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "ip_unbound_oldest_age_seconds",
+		Help: "the age of the oldest IP which is allocated to pod but not bound to nic yet on different nodes and subnets",
+	},
+	[]string{
+		"nodeName",
+		"subnetName",
+	},
+)
+
+var PodFinalizerStuckGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "pod_finalizer_stuck",
+		Help: "the number of terminating pods whose ip finalizer is not removed longer than threshold",
+	},
+)
+
+var DaemonHandlerInFlightGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "daemon_handler_inflight_requests",
+		Help: "the number of cni requests being handled by daemon",
+	},
+)
+
+var DaemonHandlerRejectedCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "daemon_handler_rejected_requests_total",
+		Help: "the count of cni requests rejected by daemon because of too many concurrent requests",
+	},
+	[]string{
+		"path",
+	},
+)
+
+var BGPPeerLastAdvertisementTimestamp = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "bgp_peer_last_advertisement_timestamp_seconds",
+		Help: "the unix timestamp of last time that local paths were re-advertised to bgp peer",
+	},
+	[]string{
+		"peer",
+	},
+)
@@ -21,6 +21,8 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
+	"time"
 
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
 
@@ -38,6 +40,10 @@ type PodRequest struct {
 	PodNamespace string `json:"pod_namespace"`
 	ContainerID  string `json:"container_id"`
 	NetNs        string `json:"net_ns"`
+
+	// Force is only used by release request, to recycle the ip instances
+	// of a pod which still exists
+	Force bool `json:"force,omitempty"`
 }
 
 type IPAddress struct {
@@ -47,13 +53,180 @@ type IPAddress struct {
 	Mac      string                 `json:"mac"`
 	Gateway  string                 `json:"gateway"`
 	Protocol networkingv1.IPVersion `json:"protocol"`
+	// NetID is the vlan id of underlay network or vni of overlay network,
+	// omitted if ip instance has no net id
+	NetID *int32 `json:"net_id,omitempty"`
 }
 
 // PodResponse is the cnidaemon response format
 type PodResponse struct {
 	IPAddress     []IPAddress `json:"address"`
 	HostInterface string      `json:"host_interface"`
-	Err           string      `json:"error"`
+	// NetworkMode is the mode of network which ips of pod come from, e.g. VLAN, BGP or VXLAN
+	NetworkMode networkingv1.NetworkMode `json:"network_mode,omitempty"`
+	// DelegatedInterfaces are the non-default interfaces expected by pod, which are not
+	// configured by add request but left to cni plugins using hybridnet ipam
+	DelegatedInterfaces []string `json:"delegated_interfaces,omitempty"`
+	Err                 string   `json:"error"`
+	// ErrReason is the machine-parseable reason of Err, empty if not classified
+	ErrReason string `json:"error_reason,omitempty"`
+}
+
+// IPAMRequest is the request format of resolving ip addresses for one interface of pod
+type IPAMRequest struct {
+	PodName      string `json:"pod_name"`
+	PodNamespace string `json:"pod_namespace"`
+	ContainerID  string `json:"container_id"`
+	IfName       string `json:"if_name"`
+}
+
+// IPAMMultiRequest is the request format of resolving ip addresses for multiple interfaces
+type IPAMMultiRequest struct {
+	Requests []IPAMRequest `json:"requests"`
+}
+
+// IPAMResult is the result of resolving ip addresses for one interface, Err is not
+// empty if ip addresses of this interface fail to be resolved
+type IPAMResult struct {
+	IfName    string      `json:"if_name"`
+	IPAddress []IPAddress `json:"address"`
+	Err       string      `json:"error"`
+}
+
+// IPAMMultiResponse is the response format of resolving ip addresses for multiple interfaces,
+// results are in the same order of requests
+type IPAMMultiResponse struct {
+	Results []IPAMResult `json:"results"`
+	Err     string       `json:"error"`
+}
+
+// BGPReAdvertiseResponse is the response format of bgp re-advertisement
+type BGPReAdvertiseResponse struct {
+	Count int    `json:"count"`
+	Err   string `json:"error"`
+}
+
+// BGPPeerStatus is the state of a bgp peer on node
+type BGPPeerStatus struct {
+	Address      string `json:"address"`
+	ASN          int    `json:"asn"`
+	State        string `json:"state"`
+	ExportPolicy string `json:"exportPolicy"`
+	ImportPolicy string `json:"importPolicy"`
+}
+
+// BGPPeersResponse is the response format of bgp peer states
+type BGPPeersResponse struct {
+	Peers []BGPPeerStatus `json:"peers"`
+	Err   string          `json:"error"`
+}
+
+// Reasons of ips whose kernel state on node does not match ip instances
+const (
+	// SelfCheckReasonHostInterfaceMissing means the host veth of pod is not found
+	SelfCheckReasonHostInterfaceMissing = "HostInterfaceMissing"
+	// SelfCheckReasonSandboxMismatch means the host veth of pod belongs to another sandbox
+	SelfCheckReasonSandboxMismatch = "SandboxMismatch"
+	// SelfCheckReasonNetnsMissing means the netns of pod is not found by its host veth
+	SelfCheckReasonNetnsMissing = "NetnsMissing"
+	// SelfCheckReasonContainerInterfaceMissing means the container side of host veth is not found in netns of pod
+	SelfCheckReasonContainerInterfaceMissing = "ContainerInterfaceMissing"
+	// SelfCheckReasonAddressMissing means the ip is not configured on the container side of host veth
+	SelfCheckReasonAddressMissing = "AddressMissing"
+	// SelfCheckReasonCheckFailed means the kernel state can not be inspected
+	SelfCheckReasonCheckFailed = "CheckFailed"
+)
+
+// IPSelfCheckMismatch is an ip bound on node whose kernel state does not match
+type IPSelfCheckMismatch struct {
+	IPInstance   string `json:"ipInstance"`
+	PodName      string `json:"podName"`
+	PodNamespace string `json:"podNamespace"`
+	IP           string `json:"ip"`
+	Reason       string `json:"reason"`
+	Message      string `json:"message"`
+}
+
+// IPSelfCheckResponse is the response format of ip self-check, Checked is the count of ips bound on node
+type IPSelfCheckResponse struct {
+	Checked    int                   `json:"checked"`
+	Mismatches []IPSelfCheckMismatch `json:"mismatches"`
+	Err        string                `json:"error"`
+}
+
+// PodDiagnosticsInterface is a link in netns of pod
+type PodDiagnosticsInterface struct {
+	Name      string   `json:"name"`
+	Index     int      `json:"index"`
+	Type      string   `json:"type"`
+	MAC       string   `json:"mac"`
+	MTU       int      `json:"mtu"`
+	State     string   `json:"state"`
+	Flags     string   `json:"flags"`
+	Addresses []string `json:"addresses"`
+}
+
+// PodDiagnosticsRoute is a route of any table in netns of pod
+type PodDiagnosticsRoute struct {
+	Table       int    `json:"table"`
+	Destination string `json:"destination"`
+	Gateway     string `json:"gateway,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Interface   string `json:"interface,omitempty"`
+	Scope       string `json:"scope"`
+	Metric      int    `json:"metric,omitempty"`
+}
+
+// PodDiagnosticsNeighbor is a neighbor entry in netns of pod
+type PodDiagnosticsNeighbor struct {
+	IP        string `json:"ip"`
+	MAC       string `json:"mac"`
+	Interface string `json:"interface"`
+	State     string `json:"state"`
+}
+
+// PodDiagnosticsResponse is the response format of pod diagnostics, which dumps the nic configuration in
+// netns of pod found by its host veth
+type PodDiagnosticsResponse struct {
+	PodName       string                    `json:"podName"`
+	PodNamespace  string                    `json:"podNamespace"`
+	HostInterface string                    `json:"hostInterface"`
+	Netns         string                    `json:"netns"`
+	Interfaces    []PodDiagnosticsInterface `json:"interfaces"`
+	Routes        []PodDiagnosticsRoute     `json:"routes"`
+	Neighbors     []PodDiagnosticsNeighbor  `json:"neighbors"`
+	Err           string                    `json:"error"`
+}
+
+// Status codes of cnidaemon responses are the contract with cni plugin:
+//   - 2xx means the request succeeds.
+//   - 503 means the failure is transient, e.g. ip is not coupled with pod yet or apiserver
+//     is unreachable, the same request can be retried by cni plugin.
+//   - Other 4xx means the request will never succeed without changes, e.g. malformed request,
+//     pod not found or invalid pod annotations, it must not be retried.
+//   - 500 means an unexpected failure after the node is touched, e.g. nic configuration fails,
+//     cni plugin should return error and leave retry to kubelet by recreating sandbox.
+
+// Reasons of failed add requests whose pod is not coupled with ip
+const (
+	// ErrReasonAllocationPending means ip is not allocated by manager yet, retrying is worthwhile
+	ErrReasonAllocationPending = "AllocationPending"
+	// ErrReasonAllocationFailed means allocation fails permanently, pod or network needs changes
+	ErrReasonAllocationFailed = "AllocationFailed"
+	// ErrReasonNetworkExhausted means no ip is left in network, retrying is worthwhile after ips are released
+	ErrReasonNetworkExhausted = "NetworkExhausted"
+	// ErrReasonDaemonBusy means daemon is handling too many requests, retrying later is worthwhile
+	ErrReasonDaemonBusy = "DaemonBusy"
+)
+
+const (
+	addRetries     = 5
+	addBackOffBase = 100 * time.Millisecond
+)
+
+// IsRetryableStatusCode checks whether a failed request can be retried as is by cni plugin
+func IsRetryableStatusCode(statusCode int) bool {
+	return statusCode == http.StatusServiceUnavailable
 }
 
 // NewCniDaemonClient return a new cnidaemonclient
@@ -65,17 +238,35 @@ func NewCniDaemonClient(socketAddress string) CniDaemonClient {
 	return CniDaemonClient{request}
 }
 
-// Add pod request
+// Add pod request, transient failures will be retried with backoff
 func (cdc CniDaemonClient) Add(podRequest PodRequest) (*PodResponse, error) {
-	resp := PodResponse{}
-	res, _, errors := cdc.Post("http://dummy/api/v1/add").Send(podRequest).EndStruct(&resp)
-	if len(errors) != 0 {
-		return nil, errors[0]
-	}
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("request ip return %d %s", res.StatusCode, resp.Err)
+	var err error
+	backOff := addBackOffBase
+	for i := 0; i < addRetries; i++ {
+		if i > 0 {
+			time.Sleep(backOff)
+			backOff = backOff * 2
+		}
+
+		resp := PodResponse{}
+		res, _, errors := cdc.Post("http://dummy/api/v1/add").Send(podRequest).EndStruct(&resp)
+		if len(errors) != 0 {
+			return nil, errors[0]
+		}
+		if res.StatusCode == 200 {
+			return &resp, nil
+		}
+
+		if len(resp.ErrReason) > 0 {
+			err = fmt.Errorf("request ip return %d %s: %s", res.StatusCode, resp.ErrReason, resp.Err)
+		} else {
+			err = fmt.Errorf("request ip return %d %s", res.StatusCode, resp.Err)
+		}
+		if !IsRetryableStatusCode(res.StatusCode) {
+			return nil, err
+		}
 	}
-	return &resp, nil
+	return nil, err
 }
 
 // Del pod request
@@ -89,3 +280,87 @@ func (cdc CniDaemonClient) Del(podRequest PodRequest) error {
 	}
 	return nil
 }
+
+// Release pod ip request, ip instances of pod will be recycled
+func (cdc CniDaemonClient) Release(podRequest PodRequest) error {
+	res, body, errors := cdc.Post("http://dummy/api/v1/release").Send(podRequest).End()
+	if len(errors) != 0 {
+		return errors[0]
+	}
+	if res.StatusCode != 204 {
+		return fmt.Errorf("release ip return %d %s", res.StatusCode, body)
+	}
+	return nil
+}
+
+// IPAMAddMulti resolves ip addresses for multiple interfaces in one request, partial failures
+// are returned by the Err of every result
+func (cdc CniDaemonClient) IPAMAddMulti(requests []IPAMRequest) ([]IPAMResult, error) {
+	resp := IPAMMultiResponse{}
+	res, _, errors := cdc.Post("http://dummy/api/v1/ipam/add-multi").Send(IPAMMultiRequest{Requests: requests}).EndStruct(&resp)
+	if len(errors) != 0 {
+		return nil, errors[0]
+	}
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("request ips return %d %s", res.StatusCode, resp.Err)
+	}
+	if len(resp.Results) != len(requests) {
+		return nil, fmt.Errorf("unexpected results count %d for %d requests", len(resp.Results), len(requests))
+	}
+	return resp.Results, nil
+}
+
+// ReAdvertiseBGPRoutes asks daemon to re-announce all the local bgp paths, returns the count of paths
+func (cdc CniDaemonClient) ReAdvertiseBGPRoutes() (int, error) {
+	resp := BGPReAdvertiseResponse{}
+	res, _, errors := cdc.Post("http://dummy/api/v1/bgp/readvertise").EndStruct(&resp)
+	if len(errors) != 0 {
+		return 0, errors[0]
+	}
+	if res.StatusCode != 200 {
+		return 0, fmt.Errorf("re-advertise bgp routes return %d %s", res.StatusCode, resp.Err)
+	}
+	return resp.Count, nil
+}
+
+// GetBGPPeers returns the states of all the bgp peers on node
+func (cdc CniDaemonClient) GetBGPPeers() ([]BGPPeerStatus, error) {
+	resp := BGPPeersResponse{}
+	res, _, errors := cdc.Get("http://dummy/api/v1/bgp/peers").EndStruct(&resp)
+	if len(errors) != 0 {
+		return nil, errors[0]
+	}
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("get bgp peers return %d %s", res.StatusCode, resp.Err)
+	}
+	return resp.Peers, nil
+}
+
+// GetPodDiagnostics returns the nic configuration in netns of pod on node
+func (cdc CniDaemonClient) GetPodDiagnostics(podNamespace, podName string) (*PodDiagnosticsResponse, error) {
+	resp := PodDiagnosticsResponse{}
+	res, _, errors := cdc.Get("http://dummy/api/v1/pod/diagnostics").
+		Query(url.Values{"namespace": {podNamespace}, "name": {podName}}.Encode()).
+		EndStruct(&resp)
+	if len(errors) != 0 {
+		return nil, errors[0]
+	}
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("get diagnostics of pod %s/%s return %d %s", podNamespace, podName, res.StatusCode, resp.Err)
+	}
+	return &resp, nil
+}
+
+// SelfCheckIPs verifies the ips bound on node against the kernel state, returns the count of checked ips
+// and the mismatches
+func (cdc CniDaemonClient) SelfCheckIPs() (int, []IPSelfCheckMismatch, error) {
+	resp := IPSelfCheckResponse{}
+	res, _, errors := cdc.Get("http://dummy/api/v1/ipam/self-check").EndStruct(&resp)
+	if len(errors) != 0 {
+		return 0, nil, errors[0]
+	}
+	if res.StatusCode != 200 {
+		return 0, nil, fmt.Errorf("self-check ips return %d %s", res.StatusCode, resp.Err)
+	}
+	return resp.Checked, resp.Mismatches, nil
+}
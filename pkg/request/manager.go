@@ -0,0 +1,97 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package request
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// allocationRequestTimeout is the timeout of a request of allocation, which should be done
+// well before the waiting of cni add request ends
+const allocationRequestTimeout = 3 * time.Second
+
+// AllocationRequest is the request format of requesting allocation for a pod from manager
+// running in lazy allocation mode
+type AllocationRequest struct {
+	PodName      string `json:"pod_name"`
+	PodNamespace string `json:"pod_namespace"`
+}
+
+// NewAllocationRequestClient creates the client requesting allocation from manager, which presents
+// the certificate if it is set, and verifies manager by the ca if it is set rather than system roots
+func NewAllocationRequestClient(certFile, keyFile, caFile string) (*http.Client, error) {
+	if len(certFile) == 0 && len(caFile) == 0 {
+		return &http.Client{Timeout: allocationRequestTimeout}, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if len(certFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate %v: %v", certFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(caFile) > 0 {
+		caPEM, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca file %v: %v", caFile, err)
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificate is found in ca file %v", caFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	return &http.Client{
+		Timeout:   allocationRequestTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
+
+// RequestAllocation asks manager to allocate ips for pod through the endpoint of url, it returns
+// once the request is accepted rather than ips are allocated
+func RequestAllocation(client *http.Client, url, podNamespace, podName string) error {
+	body, err := json.Marshal(AllocationRequest{PodName: podName, PodNamespace: podNamespace})
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("request allocation return %d: %s", res.StatusCode, bytes.TrimSpace(message))
+	}
+	return nil
+}
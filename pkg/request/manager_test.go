@@ -0,0 +1,74 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package request
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestAllocation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		allocationRequest := &AllocationRequest{}
+		if err := json.NewDecoder(req.Body).Decode(allocationRequest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if allocationRequest.PodNamespace != "default" || allocationRequest.PodName != "pod" {
+			http.Error(w, "pod not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	client, err := NewAllocationRequestClient("", "", "")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	if err = RequestAllocation(client, server.URL, "default", "pod"); err != nil {
+		t.Errorf("expect request accepted, got %v", err)
+	}
+	if err = RequestAllocation(client, server.URL, "default", "missing"); err == nil {
+		t.Errorf("expect request of missing pod failed")
+	}
+}
+
+func TestNewAllocationRequestClient(t *testing.T) {
+	tests := []struct {
+		name        string
+		certFile    string
+		keyFile     string
+		caFile      string
+		expectError bool
+	}{
+		{"plain", "", "", "", false},
+		{"missing certificate", "not-exist.crt", "not-exist.key", "", true},
+		{"missing ca", "", "", "not-exist.crt", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewAllocationRequestClient(test.certFile, test.keyFile, test.caFile); (err != nil) != test.expectError {
+				t.Errorf("expect error %v, got %v", test.expectError, err)
+			}
+		})
+	}
+}
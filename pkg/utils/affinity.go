@@ -0,0 +1,61 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// SubnetAffinity refers to the related pods in the same namespace whose subnets are preferred,
+// either a single pod by name or the pods matching a label selector
+type SubnetAffinity struct {
+	PodName  string
+	Selector labels.Selector
+}
+
+func (s SubnetAffinity) String() string {
+	if s.Selector != nil {
+		return "pods " + s.Selector.String()
+	}
+	return "pod " + s.PodName
+}
+
+// ParseSubnetAffinity parses subnet affinity from string, it is a label selector if any operator
+// shows up, e.g. "app=db,role=leader" or "tier in (db)", otherwise the name of a pod, e.g. "db-0"
+func ParseSubnetAffinity(in string) (*SubnetAffinity, error) {
+	in = strings.TrimSpace(in)
+	if len(in) == 0 {
+		return nil, fmt.Errorf("subnet affinity must not be empty")
+	}
+
+	if strings.ContainsAny(in, "=!(") {
+		selector, err := labels.Parse(in)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector %q of subnet affinity: %v", in, err)
+		}
+		return &SubnetAffinity{Selector: selector}, nil
+	}
+
+	if errs := validation.IsDNS1123Subdomain(in); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid pod name %q of subnet affinity: %s", in, strings.Join(errs, "; "))
+	}
+	return &SubnetAffinity{PodName: in}, nil
+}
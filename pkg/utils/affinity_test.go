@@ -0,0 +1,107 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"testing"
+)
+
+func TestParseSubnetAffinity(t *testing.T) {
+	tests := []struct {
+		name             string
+		in               string
+		expectedPod      string
+		expectedSelector string
+		expectErr        bool
+	}{
+		{
+			"pod name",
+			"db-0",
+			"db-0",
+			"",
+			false,
+		},
+		{
+			"pod name with spaces",
+			" db-0 ",
+			"db-0",
+			"",
+			false,
+		},
+		{
+			"equality selector",
+			"app=db,role=leader",
+			"",
+			"app=db,role=leader",
+			false,
+		},
+		{
+			"set based selector",
+			"tier in (db)",
+			"",
+			"tier in (db)",
+			false,
+		},
+		{
+			"empty string",
+			"",
+			"",
+			"",
+			true,
+		},
+		{
+			"invalid pod name",
+			"DB_0",
+			"",
+			"",
+			true,
+		},
+		{
+			"invalid selector",
+			"app=db=leader",
+			"",
+			"",
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			affinity, err := ParseSubnetAffinity(test.in)
+			if test.expectErr {
+				if err == nil {
+					t.Fatalf("expect error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if affinity.PodName != test.expectedPod {
+				t.Errorf("expect pod %q, got %q", test.expectedPod, affinity.PodName)
+			}
+			var selector string
+			if affinity.Selector != nil {
+				selector = affinity.Selector.String()
+			}
+			if selector != test.expectedSelector {
+				t.Errorf("expect selector %q, got %q", test.expectedSelector, selector)
+			}
+		})
+	}
+}
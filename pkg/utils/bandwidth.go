@@ -0,0 +1,72 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var (
+	minBandwidth = resource.MustParse("1k")
+	// the limit of tbf qdisc in bytes is a 32-bit attribute, which overflows
+	// around 1T bits per second, so a bandwidth far beyond any nic is refused
+	maxBandwidth = resource.MustParse("100G")
+)
+
+// Bandwidth is the rate limits of pod traffic in bits per second, zero means unlimited
+type Bandwidth struct {
+	Ingress uint64
+	Egress  uint64
+}
+
+// ParseBandwidth parses ingress and egress rate limits from quantity strings, e.g. "10M",
+// nil will be returned if neither of them is specified
+func ParseBandwidth(ingress, egress string) (*Bandwidth, error) {
+	if len(ingress) == 0 && len(egress) == 0 {
+		return nil, nil
+	}
+
+	var (
+		bandwidth = &Bandwidth{}
+		err       error
+	)
+	if bandwidth.Ingress, err = parseBandwidthQuantity(ingress); err != nil {
+		return nil, fmt.Errorf("invalid ingress bandwidth %q: %v", ingress, err)
+	}
+	if bandwidth.Egress, err = parseBandwidthQuantity(egress); err != nil {
+		return nil, fmt.Errorf("invalid egress bandwidth %q: %v", egress, err)
+	}
+	return bandwidth, nil
+}
+
+func parseBandwidthQuantity(in string) (uint64, error) {
+	if len(in) == 0 {
+		return 0, nil
+	}
+
+	quantity, err := resource.ParseQuantity(in)
+	if err != nil {
+		return 0, err
+	}
+
+	if quantity.Cmp(minBandwidth) < 0 || quantity.Cmp(maxBandwidth) > 0 {
+		return 0, fmt.Errorf("bandwidth must be between %v and %v", minBandwidth.String(), maxBandwidth.String())
+	}
+	return uint64(quantity.Value()), nil
+}
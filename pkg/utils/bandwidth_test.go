@@ -0,0 +1,101 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	tests := []struct {
+		name      string
+		ingress   string
+		egress    string
+		expected  *Bandwidth
+		expectErr bool
+	}{
+		{
+			"not specified",
+			"",
+			"",
+			nil,
+			false,
+		},
+		{
+			"both specified",
+			"10M",
+			"1G",
+			&Bandwidth{Ingress: 10000000, Egress: 1000000000},
+			false,
+		},
+		{
+			"only ingress",
+			"500k",
+			"",
+			&Bandwidth{Ingress: 500000},
+			false,
+		},
+		{
+			"invalid quantity",
+			"10Mbps",
+			"",
+			nil,
+			true,
+		},
+		{
+			"too small",
+			"",
+			"100",
+			nil,
+			true,
+		},
+		{
+			"maximum",
+			"100G",
+			"",
+			&Bandwidth{Ingress: 100000000000},
+			false,
+		},
+		{
+			"overflowing tbf limit",
+			"1T",
+			"",
+			nil,
+			true,
+		},
+		{
+			"too large",
+			"2P",
+			"",
+			nil,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bandwidth, err := ParseBandwidth(test.ingress, test.egress)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("test %s fails: expected error %v but got %v", test.name, test.expectErr, err)
+			}
+			if !reflect.DeepEqual(bandwidth, test.expected) {
+				t.Errorf("test %s fails: expected %+v but got %+v", test.name, test.expected, bandwidth)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var interfaceNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,14}$`)
+
+// IsValidInterfaceName checks whether a string can be used as the name of a container interface
+func IsValidInterfaceName(ifName string) bool {
+	return interfaceNameRegexp.MatchString(ifName)
+}
+
+// ParseInterfaceNames parses interface names from string in format of "eth0,net1",
+// the order of names is kept
+func ParseInterfaceNames(in string) ([]string, error) {
+	var (
+		ifNames []string
+		names   = map[string]bool{}
+	)
+
+	for _, ifName := range strings.Split(in, ",") {
+		ifName = strings.TrimSpace(ifName)
+		if len(ifName) == 0 {
+			continue
+		}
+
+		if !IsValidInterfaceName(ifName) {
+			return nil, fmt.Errorf("invalid interface name %q", ifName)
+		}
+		if names[ifName] {
+			return nil, fmt.Errorf("duplicated interface name %q", ifName)
+		}
+		names[ifName] = true
+
+		ifNames = append(ifNames, ifName)
+	}
+
+	return ifNames, nil
+}
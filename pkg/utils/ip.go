@@ -17,6 +17,7 @@
 package utils
 
 import (
+	"math/big"
 	"net"
 
 	"github.com/containernetworking/plugins/pkg/ip"
@@ -39,6 +40,15 @@ func NormalizedIP(ip string) string {
 	return ""
 }
 
+// CanonicalIP returns IP in canonical form if it is valid, e.g., "fd00::1" for "fd00:0:0::1",
+// which ips are keyed by in ipam, otherwise empty string
+func CanonicalIP(in string) string {
+	if parsed := net.ParseIP(in); parsed != nil {
+		return parsed.String()
+	}
+	return ""
+}
+
 // Intersect returns if ip address range of rangeA is overlapped with rangeB.
 func Intersect(rangeA *networkingv1.AddressRange, rangeB *networkingv1.AddressRange) bool {
 	if rangeA.Version != rangeB.Version {
@@ -94,6 +104,48 @@ func Intersect(rangeA *networkingv1.AddressRange, rangeB *networkingv1.AddressRa
 	return false
 }
 
+// InAddressRange returns if ip can be allocated in address range, network address and ipv4
+// broadcast address of CIDR are never allocated, excluded ips are not considered.
+func InAddressRange(addressRange *networkingv1.AddressRange, target net.IP) bool {
+	_, cidr, err := net.ParseCIDR(addressRange.CIDR)
+	if err != nil || target == nil || !cidr.Contains(target) {
+		return false
+	}
+
+	var (
+		start = net.ParseIP(addressRange.Start)
+		end   = net.ParseIP(addressRange.End)
+	)
+	if first := ip.NextIP(cidr.IP); start == nil || ip.Cmp(start, first) < 0 {
+		start = first
+	}
+	if last := LastIP(cidr); end == nil || ip.Cmp(end, last) > 0 {
+		end = last
+	}
+	return ip.Cmp(target, start) >= 0 && ip.Cmp(target, end) <= 0
+}
+
+// AddIPOffset returns the ip which is offset after base ip, nil will be returned if overflow
+func AddIPOffset(base net.IP, offset int) net.IP {
+	if v4 := base.To4(); v4 != nil {
+		base = v4
+	}
+
+	sum := new(big.Int).Add(new(big.Int).SetBytes(base), big.NewInt(int64(offset)))
+	if sum.Sign() < 0 {
+		return nil
+	}
+
+	sumBytes := sum.Bytes()
+	if len(sumBytes) > len(base) {
+		return nil
+	}
+
+	result := make(net.IP, len(base))
+	copy(result[len(result)-len(sumBytes):], sumBytes)
+	return result
+}
+
 // LastIP Determine the last IP of a subnet, excluding the broadcast if IPv4
 func LastIP(subnet *net.IPNet) net.IP {
 	var end net.IP
@@ -70,6 +70,84 @@ func TestNormalizedIP(t *testing.T) {
 	}
 }
 
+func TestCanonicalIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		expected string
+	}{
+		{
+			"ipv4",
+			"192.168.0.1",
+			"192.168.0.1",
+		},
+		{
+			"ipv6",
+			"2001:0410:0000:0001:0000:0000:0000:45ff",
+			"2001:410:0:1::45ff",
+		},
+		{
+			"canonical ipv6",
+			"fd00::1",
+			"fd00::1",
+		},
+		{
+			"bad ip",
+			"192.168.0",
+			"",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if out := CanonicalIP(test.in); out != test.expected {
+				t.Errorf("test %s fails: expected %s but got %s", test.name, test.expected, out)
+			}
+		})
+	}
+}
+
+func TestAddIPOffset(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     string
+		offset   int
+		expected string
+	}{
+		{
+			"ipv4",
+			"192.168.0.10",
+			5,
+			"192.168.0.15",
+		},
+		{
+			"ipv4 with carry",
+			"192.168.0.250",
+			10,
+			"192.168.1.4",
+		},
+		{
+			"ipv6",
+			"fe80::ff",
+			1,
+			"fe80::100",
+		},
+		{
+			"ipv4 overflow",
+			"255.255.255.255",
+			1,
+			"<nil>",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if out := AddIPOffset(net.ParseIP(test.base), test.offset); out.String() != test.expected {
+				t.Errorf("test %s fails: expected %s but got %s", test.name, test.expected, out)
+				return
+			}
+		})
+	}
+}
+
 func TestIntersect(t *testing.T) {
 	testCase := []struct {
 		name     string
@@ -267,3 +345,75 @@ func TestIntersect(t *testing.T) {
 	}
 
 }
+
+func TestInAddressRange(t *testing.T) {
+	testCase := []struct {
+		name     string
+		in       v1.AddressRange
+		ip       string
+		expected bool
+	}{
+		{
+			"in cidr",
+			v1.AddressRange{Version: "4", CIDR: "192.168.1.0/24"},
+			"192.168.1.100",
+			true,
+		},
+		{
+			"out of cidr",
+			v1.AddressRange{Version: "4", CIDR: "192.168.1.0/25"},
+			"192.168.1.200",
+			false,
+		},
+		{
+			"network address",
+			v1.AddressRange{Version: "4", CIDR: "192.168.1.128/25"},
+			"192.168.1.128",
+			false,
+		},
+		{
+			"broadcast address",
+			v1.AddressRange{Version: "4", CIDR: "192.168.1.0/24"},
+			"192.168.1.255",
+			false,
+		},
+		{
+			"before start",
+			v1.AddressRange{Version: "4", CIDR: "192.168.1.0/24", Start: "192.168.1.50", End: "192.168.1.100"},
+			"192.168.1.49",
+			false,
+		},
+		{
+			"between start and end",
+			v1.AddressRange{Version: "4", CIDR: "192.168.1.0/24", Start: "192.168.1.50", End: "192.168.1.100"},
+			"192.168.1.100",
+			true,
+		},
+		{
+			"after end",
+			v1.AddressRange{Version: "4", CIDR: "192.168.1.0/24", End: "192.168.1.100"},
+			"192.168.1.101",
+			false,
+		},
+		{
+			"ipv6 in cidr",
+			v1.AddressRange{Version: "6", CIDR: "fe80::/120"},
+			"fe80::ff",
+			true,
+		},
+		{
+			"ipv6 out of cidr",
+			v1.AddressRange{Version: "6", CIDR: "fe80::/120"},
+			"fe80::100",
+			false,
+		},
+	}
+
+	for _, test := range testCase {
+		t.Run(test.name, func(t *testing.T) {
+			if out := InAddressRange(&test.in, net.ParseIP(test.ip)); out != test.expected {
+				t.Errorf("test %s fails: expected %v but got %v", test.name, test.expected, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	ipPoolFamilyIPv4 = "ipv4"
+	ipPoolFamilyIPv6 = "ipv6"
+)
+
+// PerFamilyIPPool is the ip pool with independent ipv4 and ipv6 ips, ips of each family
+// are picked by pod ordinal separately
+type PerFamilyIPPool struct {
+	IPv4 []string
+	IPv6 []string
+}
+
+// IsPerFamilyIPPool checks whether ip pool is in per-family format, e.g.
+// "ipv4=10.0.0.1,10.0.0.2;ipv6=fd00::1", rather than the "v4/v6" format of each ordinal
+func IsPerFamilyIPPool(in string) bool {
+	return strings.Contains(in, "=")
+}
+
+// ParsePerFamilyIPPool parses ip pool in format of "ipv4=ip1,ip2;ipv6=ip3", either family
+// can be omitted
+func ParsePerFamilyIPPool(in string) (*PerFamilyIPPool, error) {
+	var (
+		ipPool   = &PerFamilyIPPool{}
+		families = map[string]bool{}
+		ips      = map[string]bool{}
+	)
+
+	for _, section := range strings.Split(in, ";") {
+		section = strings.TrimSpace(section)
+		if len(section) == 0 {
+			continue
+		}
+
+		parts := strings.Split(section, "=")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid ip pool section %q, should be in format of family=ip1,ip2", section)
+		}
+
+		family := strings.TrimSpace(parts[0])
+		if family != ipPoolFamilyIPv4 && family != ipPoolFamilyIPv6 {
+			return nil, fmt.Errorf("unsupported ip family %q of ip pool, should be %s or %s", family, ipPoolFamilyIPv4, ipPoolFamilyIPv6)
+		}
+		if families[family] {
+			return nil, fmt.Errorf("duplicated ip family %q of ip pool", family)
+		}
+		families[family] = true
+
+		var familyIPs []string
+		for _, ipStr := range strings.Split(parts[1], ",") {
+			ipStr = strings.TrimSpace(ipStr)
+			ip := net.ParseIP(ipStr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %q in %s pool", ipStr, family)
+			}
+			if isIPv6 := ip.To4() == nil; isIPv6 != (family == ipPoolFamilyIPv6) {
+				return nil, fmt.Errorf("ip %q mismatches family of %s pool", ipStr, family)
+			}
+			if ips[ip.String()] {
+				return nil, fmt.Errorf("duplicated ip %q in ip pool", ipStr)
+			}
+			ips[ip.String()] = true
+
+			familyIPs = append(familyIPs, ip.String())
+		}
+
+		if family == ipPoolFamilyIPv4 {
+			ipPool.IPv4 = familyIPs
+		} else {
+			ipPool.IPv6 = familyIPs
+		}
+	}
+
+	if len(ipPool.IPv4) == 0 && len(ipPool.IPv6) == 0 {
+		return nil, fmt.Errorf("empty ip pool")
+	}
+	return ipPool, nil
+}
+
+// ValidateOrdinalIPPool validates dual-stack ip pool in format of "v4/v6,v4/v6", whose ips are picked
+// by pod ordinal, every ordinal must have exactly one ip of each expected family and none of the others
+func ValidateOrdinalIPPool(in string, expectIPv4, expectIPv6 bool) error {
+	for ordinal, ordinalIPs := range strings.Split(in, ",") {
+		if err := ValidateIPFamilies(strings.Split(ordinalIPs, "/"), expectIPv4, expectIPv6); err != nil {
+			return fmt.Errorf("ordinal %d of ip pool is invalid: %v", ordinal, err)
+		}
+	}
+	return nil
+}
+
+// ValidateIPFamilies checks that ips of a pod have exactly one ip of each expected family and
+// none of the others
+func ValidateIPFamilies(ips []string, expectIPv4, expectIPv6 bool) error {
+	expectedCount := func(expected bool) int {
+		if expected {
+			return 1
+		}
+		return 0
+	}
+
+	var v4Count, v6Count int
+	for _, ipStr := range ips {
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			return fmt.Errorf("invalid ip %q", ipStr)
+		}
+		if ip.To4() != nil {
+			v4Count++
+		} else {
+			v6Count++
+		}
+	}
+
+	if v4Count != expectedCount(expectIPv4) || v6Count != expectedCount(expectIPv6) {
+		return fmt.Errorf("ips %v have %d ipv4 and %d ipv6 ips, expect %d ipv4 and %d ipv6 ips",
+			ips, v4Count, v6Count, expectedCount(expectIPv4), expectedCount(expectIPv6))
+	}
+	return nil
+}
+
+// Pick returns the ipv4 and ipv6 ip of ordinal, empty string will be returned for the family
+// whose pool does not cover the ordinal
+func (p *PerFamilyIPPool) Pick(ordinal int) (v4IP, v6IP string) {
+	if ordinal >= 0 && ordinal < len(p.IPv4) {
+		v4IP = p.IPv4[ordinal]
+	}
+	if ordinal >= 0 && ordinal < len(p.IPv6) {
+		v6IP = p.IPv6[ordinal]
+	}
+	return
+}
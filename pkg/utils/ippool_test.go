@@ -0,0 +1,247 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePerFamilyIPPool(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        string
+		expected  *PerFamilyIPPool
+		expectErr bool
+	}{
+		{
+			"both families with different lengths",
+			"ipv4=10.0.0.1,10.0.0.2;ipv6=fd00::1",
+			&PerFamilyIPPool{IPv4: []string{"10.0.0.1", "10.0.0.2"}, IPv6: []string{"fd00::1"}},
+			false,
+		},
+		{
+			"only ipv4",
+			" ipv4 = 10.0.0.1 , 10.0.0.2 ",
+			&PerFamilyIPPool{IPv4: []string{"10.0.0.1", "10.0.0.2"}},
+			false,
+		},
+		{
+			"only ipv6 with trailing separator",
+			"ipv6=fd00::1;",
+			&PerFamilyIPPool{IPv6: []string{"fd00::1"}},
+			false,
+		},
+		{
+			"unsupported family",
+			"ipv5=10.0.0.1",
+			nil,
+			true,
+		},
+		{
+			"duplicated family",
+			"ipv4=10.0.0.1;ipv4=10.0.0.2",
+			nil,
+			true,
+		},
+		{
+			"family mismatch",
+			"ipv4=fd00::1",
+			nil,
+			true,
+		},
+		{
+			"duplicated ip",
+			"ipv4=10.0.0.1,10.0.0.1",
+			nil,
+			true,
+		},
+		{
+			"invalid ip",
+			"ipv4=10.0.0.1,",
+			nil,
+			true,
+		},
+		{
+			"empty",
+			";",
+			nil,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ipPool, err := ParsePerFamilyIPPool(test.in)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("test %s fails: expected error %v but got %v", test.name, test.expectErr, err)
+			}
+			if !reflect.DeepEqual(ipPool, test.expected) {
+				t.Errorf("test %s fails: expected %+v but got %+v", test.name, test.expected, ipPool)
+			}
+		})
+	}
+}
+
+func TestPerFamilyIPPoolPick(t *testing.T) {
+	ipPool := &PerFamilyIPPool{IPv4: []string{"10.0.0.1", "10.0.0.2"}, IPv6: []string{"fd00::1"}}
+	tests := []struct {
+		ordinal      int
+		expectedIPv4 string
+		expectedIPv6 string
+	}{
+		{0, "10.0.0.1", "fd00::1"},
+		{1, "10.0.0.2", ""},
+		{2, "", ""},
+		{-1, "", ""},
+	}
+
+	for _, test := range tests {
+		if v4IP, v6IP := ipPool.Pick(test.ordinal); v4IP != test.expectedIPv4 || v6IP != test.expectedIPv6 {
+			t.Errorf("ordinal %d: expected %q/%q but got %q/%q", test.ordinal, test.expectedIPv4, test.expectedIPv6, v4IP, v6IP)
+		}
+	}
+}
+
+func TestValidateOrdinalIPPool(t *testing.T) {
+	tests := []struct {
+		name       string
+		in         string
+		expectIPv4 bool
+		expectIPv6 bool
+		expectErr  bool
+	}{
+		{
+			"dual-stack ordinals",
+			"10.0.0.1/fd00::1,fd00::2/10.0.0.2",
+			true,
+			true,
+			false,
+		},
+		{
+			"ipv4-only ordinals",
+			"10.0.0.1,10.0.0.2",
+			true,
+			false,
+			false,
+		},
+		{
+			"ipv6-only ordinals",
+			"fd00::1,fd00::2",
+			false,
+			true,
+			false,
+		},
+		{
+			"two ipv4 ips of one ordinal",
+			"10.0.0.1/fd00::1,10.0.0.2/10.0.0.3",
+			true,
+			true,
+			true,
+		},
+		{
+			"missing ipv6 ip of one ordinal",
+			"10.0.0.1/fd00::1,10.0.0.2",
+			true,
+			true,
+			true,
+		},
+		{
+			"unexpected ipv6 ip of ipv4-only ordinal",
+			"10.0.0.1/fd00::1",
+			true,
+			false,
+			true,
+		},
+		{
+			"empty ordinal",
+			"10.0.0.1/fd00::1,",
+			true,
+			true,
+			true,
+		},
+		{
+			"invalid ip",
+			"10.0.0.1/fd00::x",
+			true,
+			true,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := ValidateOrdinalIPPool(test.in, test.expectIPv4, test.expectIPv6); (err != nil) != test.expectErr {
+				t.Errorf("test %s fails: expected error %v but got %v", test.name, test.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateIPFamilies(t *testing.T) {
+	tests := []struct {
+		name       string
+		ips        []string
+		expectIPv4 bool
+		expectIPv6 bool
+		expectErr  bool
+	}{
+		{
+			"dual-stack ips",
+			[]string{"10.0.0.1", "fd00::1"},
+			true,
+			true,
+			false,
+		},
+		{
+			"ipv4-only ip",
+			[]string{"10.0.0.1"},
+			true,
+			false,
+			false,
+		},
+		{
+			"ipv4-only ip for dual-stack",
+			[]string{"10.0.0.1"},
+			true,
+			true,
+			true,
+		},
+		{
+			"dual-stack ips for ipv6-only",
+			[]string{"10.0.0.1", "fd00::1"},
+			false,
+			true,
+			true,
+		},
+		{
+			"no ip",
+			nil,
+			true,
+			false,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := ValidateIPFamilies(test.ips, test.expectIPv4, test.expectIPv6); (err != nil) != test.expectErr {
+				t.Errorf("test %s fails: expected error %v but got %v", test.name, test.expectErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package mac
+
+import "net"
+
+// derivedMACPrefix is the first 16 bits of MAC addresses derived from IP addresses, the two
+// LSb of MSB are 10 for a locally administered unicast address as the generated ones, and it
+// is different from hybridnet OUI to avoid conflicts with them.
+var derivedMACPrefix = []byte{0x0a, 0x58}
+
+// DeriveMAC will derive MAC address deterministically from ip, which is the fixed 16 bits
+// prefix followed by the 32 bits of IPv4 address, or the last 32 bits of IPv6 address.
+// So MAC addresses are unique within the L2 domain as long as IPv4 addresses are, and IPv6
+// subnets should not be larger than /96 to keep the last 32 bits unique.
+func DeriveMAC(ip net.IP) net.HardwareAddr {
+	hw := make(net.HardwareAddr, 6)
+	copy(hw[:2], derivedMACPrefix)
+	if ipv4 := ip.To4(); ipv4 != nil {
+		copy(hw[2:], ipv4)
+	} else {
+		copy(hw[2:], ip.To16()[12:])
+	}
+	return hw
+}
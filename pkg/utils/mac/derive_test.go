@@ -0,0 +1,53 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package mac
+
+import (
+	"net"
+	"testing"
+)
+
+func TestDeriveMAC(t *testing.T) {
+	tests := []struct {
+		name     string
+		ip       string
+		expected string
+	}{
+		{
+			"ipv4",
+			"192.168.1.10",
+			"0a:58:c0:a8:01:0a",
+		},
+		{
+			"ipv6",
+			"fd00::c0a8:10a",
+			"0a:58:c0:a8:01:0a",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mac := DeriveMAC(net.ParseIP(test.ip))
+			if mac.String() != test.expected {
+				t.Errorf("test %s fails: expected %s but got %s", test.name, test.expected, mac.String())
+			}
+			// locally administered and unicast
+			if mac[0]&0x03 != 0x02 {
+				t.Errorf("test %s fails: bad mac %s", test.name, mac.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NetIDRange is a closed interval of net IDs, e.g. "100-110"
+type NetIDRange struct {
+	Start int32
+	End   int32
+}
+
+// ParseNetIDRange parses net ID range from string in format of "start-end",
+// a single net ID is also accepted as a range with only one member
+func ParseNetIDRange(in string) (*NetIDRange, error) {
+	var (
+		parts = strings.Split(strings.TrimSpace(in), "-")
+		nums  = make([]int32, len(parts))
+	)
+
+	if len(parts) > 2 {
+		return nil, fmt.Errorf("invalid net ID range %q, should be in format of start-end", in)
+	}
+
+	for i := range parts {
+		num, err := strconv.ParseInt(strings.TrimSpace(parts[i]), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid net ID %q in range %q: %v", parts[i], in, err)
+		}
+		if num < 0 {
+			return nil, fmt.Errorf("invalid net ID %q in range %q, should not be negative", parts[i], in)
+		}
+		nums[i] = int32(num)
+	}
+
+	netIDRange := &NetIDRange{
+		Start: nums[0],
+		End:   nums[len(nums)-1],
+	}
+	if netIDRange.Start > netIDRange.End {
+		return nil, fmt.Errorf("invalid net ID range %q, start is larger than end", in)
+	}
+
+	return netIDRange, nil
+}
+
+// Contains checks whether a net ID is in range, nil net ID will never match
+func (n *NetIDRange) Contains(netID *int32) bool {
+	if netID == nil {
+		return false
+	}
+	return *netID >= n.Start && *netID <= n.End
+}
+
+func (n *NetIDRange) String() string {
+	return fmt.Sprintf("%d-%d", n.Start, n.End)
+}
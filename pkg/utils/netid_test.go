@@ -0,0 +1,100 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import "testing"
+
+func TestParseNetIDRange(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        string
+		expected  *NetIDRange
+		expectErr bool
+	}{
+		{
+			"normal range",
+			"100-110",
+			&NetIDRange{Start: 100, End: 110},
+			false,
+		},
+		{
+			"single net ID",
+			"100",
+			&NetIDRange{Start: 100, End: 100},
+			false,
+		},
+		{
+			"range with spaces",
+			" 0 - 10 ",
+			&NetIDRange{Start: 0, End: 10},
+			false,
+		},
+		{
+			"reversed range",
+			"110-100",
+			nil,
+			true,
+		},
+		{
+			"negative net ID",
+			"-1",
+			nil,
+			true,
+		},
+		{
+			"too many parts",
+			"1-2-3",
+			nil,
+			true,
+		},
+		{
+			"empty input",
+			"",
+			nil,
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			netIDRange, err := ParseNetIDRange(test.in)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("test %s fails, unexpected error %v", test.name, err)
+			}
+			if test.expected != nil && *test.expected != *netIDRange {
+				t.Fatalf("test %s fails, expected %v but got %v", test.name, test.expected, netIDRange)
+			}
+		})
+	}
+}
+
+func TestNetIDRangeContains(t *testing.T) {
+	var (
+		netIDRange = &NetIDRange{Start: 100, End: 110}
+		in         = int32(105)
+		out        = int32(111)
+	)
+
+	if !netIDRange.Contains(&in) {
+		t.Fatalf("net ID %d should be in range %s", in, netIDRange)
+	}
+	if netIDRange.Contains(&out) {
+		t.Fatalf("net ID %d should not be in range %s", out, netIDRange)
+	}
+	if netIDRange.Contains(nil) {
+		t.Fatalf("nil net ID should not be in range %s", netIDRange)
+	}
+}
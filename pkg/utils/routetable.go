@@ -0,0 +1,47 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// reservedRouteTables are the route tables reserved by kernel, i.e., unspec, default, main and local
+var reservedRouteTables = map[uint64]bool{
+	0:   true,
+	253: true,
+	254: true,
+	255: true,
+}
+
+// ParseRouteTable parses the id of route table selected by pod, e.g. "100", zero will be returned
+// if it is not specified
+func ParseRouteTable(in string) (int, error) {
+	if len(in) == 0 {
+		return 0, nil
+	}
+
+	table, err := strconv.ParseUint(in, 10, 31)
+	if err != nil {
+		return 0, fmt.Errorf("invalid route table %q: %v", in, err)
+	}
+	if reservedRouteTables[table] {
+		return 0, fmt.Errorf("route table %d is reserved by kernel", table)
+	}
+	return int(table), nil
+}
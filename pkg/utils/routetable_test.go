@@ -0,0 +1,50 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import "testing"
+
+func TestParseRouteTable(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        string
+		expected  int
+		expectErr bool
+	}{
+		{"not specified", "", 0, false},
+		{"valid table", "100", 100, false},
+		{"max table", "2147483647", 2147483647, false},
+		{"unspec table", "0", 0, true},
+		{"main table", "254", 0, true},
+		{"local table", "255", 0, true},
+		{"negative", "-1", 0, true},
+		{"out of range", "2147483648", 0, true},
+		{"not a number", "main", 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table, err := ParseRouteTable(test.in)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("test %s fails: expected error %v but got %v", test.name, test.expectErr, err)
+			}
+			if table != test.expected {
+				t.Errorf("test %s fails: expected %v but got %v", test.name, test.expected, table)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const IPv6DisableInterfaceSysctl = "net.ipv6.conf.disable_ipv6"
+
+// interfaceSysctlAllowlist contains the per-interface sysctls which are safe to be
+// set by pods, keys are sysctl names without interface segment, e.g. net.ipv4.conf.rp_filter
+// stands for net.ipv4.conf.<if>.rp_filter, values are the path formats in procfs
+var interfaceSysctlAllowlist = map[string]string{
+	"net.ipv4.conf.rp_filter":    "/proc/sys/net/ipv4/conf/%s/rp_filter",
+	"net.ipv4.conf.arp_ignore":   "/proc/sys/net/ipv4/conf/%s/arp_ignore",
+	"net.ipv4.conf.arp_announce": "/proc/sys/net/ipv4/conf/%s/arp_announce",
+	"net.ipv4.conf.accept_local": "/proc/sys/net/ipv4/conf/%s/accept_local",
+	IPv6DisableInterfaceSysctl:   "/proc/sys/net/ipv6/conf/%s/disable_ipv6",
+	"net.ipv6.conf.accept_ra":    "/proc/sys/net/ipv6/conf/%s/accept_ra",
+	"net.ipv6.conf.autoconf":     "/proc/sys/net/ipv6/conf/%s/autoconf",
+}
+
+// InterfaceSysctl is a sysctl which will be set on a specified interface
+type InterfaceSysctl struct {
+	Name  string
+	Value int
+}
+
+// Path returns the procfs path of sysctl on interface
+func (i InterfaceSysctl) Path(ifName string) string {
+	return fmt.Sprintf(interfaceSysctlAllowlist[i.Name], ifName)
+}
+
+// ParseInterfaceSysctls parses sysctls from string in format of "name1=value1,name2=value2",
+// only the names in allowlist are accepted, result is sorted by name
+func ParseInterfaceSysctls(in string) ([]InterfaceSysctl, error) {
+	var (
+		sysctls []InterfaceSysctl
+		names   = map[string]bool{}
+	)
+
+	for _, item := range strings.Split(in, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+
+		parts := strings.Split(item, "=")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid interface sysctl %q, should be in format of name=value", item)
+		}
+
+		name := strings.TrimSpace(parts[0])
+		if _, allowed := interfaceSysctlAllowlist[name]; !allowed {
+			return nil, fmt.Errorf("interface sysctl %q is not allowed", name)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicated interface sysctl %q", name)
+		}
+		names[name] = true
+
+		value, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of interface sysctl %q: %v", name, err)
+		}
+		if value < 0 {
+			return nil, fmt.Errorf("invalid value of interface sysctl %q, should not be negative", name)
+		}
+
+		sysctls = append(sysctls, InterfaceSysctl{
+			Name:  name,
+			Value: value,
+		})
+	}
+
+	sort.Slice(sysctls, func(i, j int) bool {
+		return sysctls[i].Name < sysctls[j].Name
+	})
+
+	return sysctls, nil
+}
+
+// IPv6DisabledByInterfaceSysctls checks whether ipv6 will be disabled on interface by sysctls
+func IPv6DisabledByInterfaceSysctls(sysctls []InterfaceSysctl) bool {
+	for _, sysctl := range sysctls {
+		if sysctl.Name == IPv6DisableInterfaceSysctl && sysctl.Value != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseInterfaceSysctls(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        string
+		expected  []InterfaceSysctl
+		expectErr bool
+	}{
+		{
+			"empty input",
+			"",
+			nil,
+			false,
+		},
+		{
+			"multiple sysctls",
+			"net.ipv6.conf.disable_ipv6=0, net.ipv4.conf.rp_filter=2",
+			[]InterfaceSysctl{
+				{Name: "net.ipv4.conf.rp_filter", Value: 2},
+				{Name: "net.ipv6.conf.disable_ipv6", Value: 0},
+			},
+			false,
+		},
+		{
+			"sysctl not in allowlist",
+			"net.ipv4.ip_forward=1",
+			nil,
+			true,
+		},
+		{
+			"duplicated sysctls",
+			"net.ipv4.conf.rp_filter=1,net.ipv4.conf.rp_filter=2",
+			nil,
+			true,
+		},
+		{
+			"invalid value",
+			"net.ipv4.conf.rp_filter=loose",
+			nil,
+			true,
+		},
+		{
+			"negative value",
+			"net.ipv4.conf.rp_filter=-1",
+			nil,
+			true,
+		},
+		{
+			"invalid format",
+			"net.ipv4.conf.rp_filter",
+			nil,
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sysctls, err := ParseInterfaceSysctls(test.in)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("test %s fails, unexpected error %v", test.name, err)
+			}
+			if !reflect.DeepEqual(test.expected, sysctls) {
+				t.Fatalf("test %s fails, expected %v but got %v", test.name, test.expected, sysctls)
+			}
+		})
+	}
+}
+
+func TestInterfaceSysctlPath(t *testing.T) {
+	sysctl := InterfaceSysctl{Name: "net.ipv6.conf.disable_ipv6", Value: 0}
+	if path := sysctl.Path("eth0"); path != "/proc/sys/net/ipv6/conf/eth0/disable_ipv6" {
+		t.Fatalf("unexpected sysctl path %s", path)
+	}
+}
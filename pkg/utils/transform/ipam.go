@@ -27,7 +27,7 @@ import (
 func TransferSubnetForIPAM(in *v1.Subnet) *ipamtypes.Subnet {
 	_, cidr, _ := net.ParseCIDR(in.Spec.Range.CIDR)
 
-	return ipamtypes.NewSubnet(in.Name,
+	subnet := ipamtypes.NewSubnet(in.Name,
 		in.Spec.Network,
 		int32pToUint32p(in.Spec.NetID),
 		net.ParseIP(in.Spec.Range.Start),
@@ -37,17 +37,32 @@ func TransferSubnetForIPAM(in *v1.Subnet) *ipamtypes.Subnet {
 		utils.StringSliceToMap(in.Spec.Range.ReservedIPs),
 		utils.StringSliceToMap(in.Spec.Range.ExcludeIPs),
 		net.ParseIP(in.Status.LastAllocatedIP),
-		v1.IsPrivateSubnet(in),
+		// deleting subnet is treated as private to stop allocation from it
+		v1.IsPrivateSubnet(in) || !in.DeletionTimestamp.IsZero(),
 		v1.IsIPv6Subnet(in),
 	)
+	subnet.AddressSelector = v1.GetSubnetAddressSelector(in)
+	subnet.ConflictedIPs = make(map[string]struct{}, len(in.Spec.ConflictedIPs))
+	for _, conflictedIP := range in.Spec.ConflictedIPs {
+		if ip := net.ParseIP(conflictedIP); ip != nil {
+			subnet.ConflictedIPs[ip.String()] = struct{}{}
+		}
+	}
+	return subnet
 }
 
 func TransferNetworkForIPAM(in *v1.Network) *ipamtypes.Network {
-	return ipamtypes.NewNetwork(in.Name,
+	network := ipamtypes.NewNetwork(in.Name,
 		int32pToUint32p(in.Spec.NetID),
 		in.Status.LastAllocatedSubnet,
 		ipamtypes.ParseNetworkTypeFromString(string(v1.GetNetworkType(in))),
 	)
+	network.AddressPool = in.Spec.AddressPool
+	if in.Spec.AllocationRateLimit != nil {
+		network.AllocationQPS = in.Spec.AllocationRateLimit.QPS
+		network.AllocationBurst = in.Spec.AllocationRateLimit.Burst
+	}
+	return network
 }
 
 func TransferIPInstanceForIPAM(in *v1.IPInstance) *ipamtypes.IP {
@@ -59,8 +74,17 @@ func TransferIPInstanceForIPAM(in *v1.IPInstance) *ipamtypes.IP {
 		Network:      in.Spec.Network,
 		PodName:      in.Status.PodName,
 		PodNamespace: in.Status.PodNamespace,
-		Status:       string(in.Status.Phase),
+		Status:       transferIPPhaseForIPAM(in.Status.Phase),
+	}
+}
+
+// transferIPPhaseForIPAM treats all the phases of an IP being used as using status,
+// because IPAM does not care about whether nic of pod is configured
+func transferIPPhaseForIPAM(phase v1.IPPhase) string {
+	if v1.IsUsingPhase(phase) {
+		return ipamtypes.IPStatusUsing
 	}
+	return string(phase)
 }
 
 func TransferIPInstancesForIPAM(ips []*v1.IPInstance) []*ipamtypes.IP {
@@ -0,0 +1,72 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// WorkloadReference refers to a workload, which is the top-level controller of pods,
+// in the same namespace
+type WorkloadReference struct {
+	Kind string
+	Name string
+}
+
+func (w WorkloadReference) String() string {
+	return w.Kind + "/" + w.Name
+}
+
+// ParseWorkloadReferences parses workload references from string in format of
+// "StatefulSet/db,Deployment/web", the order of references is kept
+func ParseWorkloadReferences(in string) ([]WorkloadReference, error) {
+	var (
+		refs     []WorkloadReference
+		existing = map[WorkloadReference]bool{}
+	)
+
+	for _, refStr := range strings.Split(in, ",") {
+		refStr = strings.TrimSpace(refStr)
+		if len(refStr) == 0 {
+			continue
+		}
+
+		parts := strings.Split(refStr, "/")
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("invalid workload reference %q, must be in format of Kind/name", refStr)
+		}
+
+		ref := WorkloadReference{Kind: strings.TrimSpace(parts[0]), Name: strings.TrimSpace(parts[1])}
+		if errs := validation.IsValidLabelValue(ref.Kind); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid workload kind %q: %s", ref.Kind, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(ref.Name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid workload name %q: %s", ref.Name, strings.Join(errs, "; "))
+		}
+		if existing[ref] {
+			return nil, fmt.Errorf("duplicated workload reference %q", ref.String())
+		}
+		existing[ref] = true
+
+		refs = append(refs, ref)
+	}
+
+	return refs, nil
+}
@@ -0,0 +1,92 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseWorkloadReferences(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        string
+		expected  []WorkloadReference
+		expectErr bool
+	}{
+		{
+			"single reference",
+			"StatefulSet/db",
+			[]WorkloadReference{{Kind: "StatefulSet", Name: "db"}},
+			false,
+		},
+		{
+			"multiple references with spaces",
+			" StatefulSet/db , Deployment/web,",
+			[]WorkloadReference{{Kind: "StatefulSet", Name: "db"}, {Kind: "Deployment", Name: "web"}},
+			false,
+		},
+		{
+			"empty string",
+			"",
+			nil,
+			false,
+		},
+		{
+			"missing kind",
+			"/db",
+			nil,
+			true,
+		},
+		{
+			"missing name",
+			"StatefulSet",
+			nil,
+			true,
+		},
+		{
+			"too many parts",
+			"apps/StatefulSet/db",
+			nil,
+			true,
+		},
+		{
+			"invalid name",
+			"StatefulSet/db@",
+			nil,
+			true,
+		},
+		{
+			"duplicated reference",
+			"StatefulSet/db,StatefulSet/db",
+			nil,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			refs, err := ParseWorkloadReferences(test.in)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expect error %v, but got %v", test.expectErr, err)
+			}
+			if !reflect.DeepEqual(refs, test.expected) {
+				t.Errorf("expect %v, but got %v", test.expected, refs)
+			}
+		})
+	}
+}
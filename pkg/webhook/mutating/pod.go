@@ -31,6 +31,7 @@ import (
 
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
 	"github.com/alibaba/hybridnet/pkg/constants"
+	controllerutils "github.com/alibaba/hybridnet/pkg/controllers/utils"
 	"github.com/alibaba/hybridnet/pkg/feature"
 	"github.com/alibaba/hybridnet/pkg/ipam/strategy"
 	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
@@ -74,28 +75,17 @@ func PodCreateMutation(ctx context.Context, req *admission.Request, handler *Han
 		delete(pod.Annotations, constants.AnnotationIP)
 	}
 
-	// select 4 networking configs in order as below
+	// select 5 networking configs in order as below
 	var (
-		networkNameStr string
-		subnetNameStr  string
-		networkTypeStr string
-		ipFamilyStr    string
-
-		// elected will be true iff one networking config was assigned
-		elected = func() bool {
-			return len(networkNameStr) > 0 || len(subnetNameStr) > 0 || len(networkTypeStr) > 0 || len(ipFamilyStr) > 0
-		}
+		networkConfig = &controllerutils.NetworkConfig{}
 
 		// fetchFromObject will fetch networking configs from k8s objects
 		fetchFromObject = func(obj client.Object) error {
 			var err error
-			if networkNameStr, subnetNameStr, err = webhookutils.SelectNetworkAndSubnetFromObject(ctx, handler.Cache, obj); err != nil {
+			if networkConfig, err = controllerutils.ResolveNetworkConfigOfObject(ctx, handler.Cache, obj); err != nil {
 				return fmt.Errorf("unable to select network and subnet from object %s/%s/%s: %v",
 					obj.GetObjectKind().GroupVersionKind().String(), obj.GetNamespace(), obj.GetName(), err)
 			}
-			networkTypeStr = utils.PickFirstNonEmptyString(obj.GetAnnotations()[constants.AnnotationNetworkType],
-				obj.GetLabels()[constants.LabelNetworkType])
-			ipFamilyStr = obj.GetAnnotations()[constants.AnnotationIPFamily]
 			return nil
 		}
 	)
@@ -120,7 +110,7 @@ func PodCreateMutation(ctx context.Context, req *admission.Request, handler *Han
 			// ignore terminating ipInstance
 			for i := range ipList.Items {
 				if ipList.Items[i].DeletionTimestamp == nil {
-					networkNameStr = ipList.Items[i].Spec.Network
+					networkConfig.NetworkName = ipList.Items[i].Spec.Network
 					break
 				}
 			}
@@ -129,7 +119,7 @@ func PodCreateMutation(ctx context.Context, req *admission.Request, handler *Han
 
 	// priority level 2
 	// fetch networking configs from pod annotations/labels
-	if !elected() {
+	if networkConfig.IsEmpty() {
 		pod.Namespace, pod.Name = req.Namespace, req.Name
 		if err = fetchFromObject(pod); err != nil {
 			return webhookutils.AdmissionErroredWithLog(http.StatusBadRequest, err, logger)
@@ -138,7 +128,7 @@ func PodCreateMutation(ctx context.Context, req *admission.Request, handler *Han
 
 	// priority level 3
 	// fetch networking configs from namespace annotations/labels
-	if !elected() {
+	if networkConfig.IsEmpty() {
 		ns := &corev1.Namespace{}
 		if err = handler.Cache.Get(ctx, types.NamespacedName{Name: req.Namespace}, ns); err != nil {
 			return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError,
@@ -151,30 +141,32 @@ func PodCreateMutation(ctx context.Context, req *admission.Request, handler *Han
 
 	// parsing networking configs
 	// TODO: validation
-	var networkName = networkNameStr
-	var networkType = ipamtypes.ParseNetworkTypeFromString(networkTypeStr)
+	var networkName = networkConfig.NetworkName
 
+	// specified network takes higher priority than network type defaulting, if no network type specified
+	// from pod, then network type should inherit from network type of specified network from pod
+	var network *networkingv1.Network
 	var networkNodeSelector map[string]string
 	if len(networkName) > 0 {
-		network := &networkingv1.Network{}
+		network = &networkingv1.Network{}
 		if err = handler.Client.Get(ctx, types.NamespacedName{Name: networkName}, network); err != nil {
 			return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
 		}
-
-		// specified network takes higher priority than network type defaulting, if no network type specified
-		// from pod, then network type should inherit from network type of specified network from pod
-		if len(networkTypeStr) == 0 {
-			networkType = ipamtypes.ParseNetworkTypeFromString(string(networkingv1.GetNetworkType(network)))
-		}
-
 		networkNodeSelector = network.Spec.NodeSelector
+		// ip family inherits from the default ip family of specified network, and it is persisted
+		// on pod so that the address quota selector below agrees with allocation
+		if feature.DualStackEnabled() && len(networkConfig.IPFamily) == 0 {
+			networkConfig.IPFamily = string(network.Spec.DefaultIPFamily)
+		}
 	}
+	var networkType = networkConfig.NetworkTypeOf(network)
 
 	// persistent specified network and subnet in pod annotations
 	patchAnnotationToPod(pod, constants.AnnotationSpecifiedNetwork, networkName)
-	patchAnnotationToPod(pod, constants.AnnotationSpecifiedSubnet, subnetNameStr)
+	patchAnnotationToPod(pod, constants.AnnotationSpecifiedSubnet, networkConfig.SubnetNames)
 	patchAnnotationToPod(pod, constants.AnnotationNetworkType, string(networkType))
-	patchAnnotationToPod(pod, constants.AnnotationIPFamily, ipFamilyStr)
+	patchAnnotationToPod(pod, constants.AnnotationIPFamily, networkConfig.IPFamily)
+	patchAnnotationToPod(pod, constants.AnnotationSpecifiedNetIDRange, networkConfig.NetIDRange)
 
 	switch networkType {
 	case ipamtypes.Underlay:
@@ -29,6 +29,7 @@ import (
 	multiclusterv1 "github.com/alibaba/hybridnet/pkg/apis/multicluster/v1"
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
 	"github.com/alibaba/hybridnet/pkg/feature"
+	"github.com/alibaba/hybridnet/pkg/utils"
 
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -53,6 +54,22 @@ func NetworkCreateValidation(ctx context.Context, req *admission.Request, handle
 
 	networkType := networkingv1.GetNetworkType(network)
 
+	if len(network.Spec.DefaultInterfaceName) > 0 && !utils.IsValidInterfaceName(network.Spec.DefaultInterfaceName) {
+		return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("invalid default interface name %s", network.Spec.DefaultInterfaceName), logger)
+	}
+
+	if err = validateAllocationRetry(network.Spec.AllocationRetry); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	if err = validateAllocationRateLimit(network.Spec.AllocationRateLimit); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	if err = validateDefaultIPFamily(network.Spec.DefaultIPFamily); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
 	switch networkType {
 	case networkingv1.NetworkTypeUnderlay:
 		if network.Spec.NodeSelector == nil || len(network.Spec.NodeSelector) == 0 {
@@ -94,15 +111,15 @@ func NetworkCreateValidation(ctx context.Context, req *admission.Request, handle
 			return admission.Denied("must assign net ID for bgp network")
 		}
 
-		if network.Spec.Config == nil || len(network.Spec.Config.BGPPeers) != 1 {
-			return admission.Denied("one and only one bgp router need to be set")
+		if network.Spec.Config == nil {
+			return admission.Denied("at least one bgp router need to be set")
 		}
 
-		for _, peer := range network.Spec.Config.BGPPeers {
-			if net.ParseIP(peer.Address) == nil {
-				return admission.Denied(fmt.Sprintf("invalid bgp peer ip address %v", peer.Address))
-			}
+		if err := validateBGPPeers(network.Spec.Config.BGPPeers); err != nil {
+			return admission.Denied(err.Error())
+		}
 
+		for _, peer := range network.Spec.Config.BGPPeers {
 			if peer.ASN == 0 {
 				return admission.Denied(fmt.Sprintf("bgp peer %v's AS number need to be set", peer.Address))
 			}
@@ -155,16 +172,30 @@ func NetworkUpdateValidation(ctx context.Context, req *admission.Request, handle
 		return admission.Denied("network mode must not be changed")
 	}
 
+	if len(newN.Spec.DefaultInterfaceName) > 0 && !utils.IsValidInterfaceName(newN.Spec.DefaultInterfaceName) {
+		return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("invalid default interface name %s", newN.Spec.DefaultInterfaceName), logger)
+	}
+
+	if err = validateAllocationRetry(newN.Spec.AllocationRetry); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	if err = validateAllocationRateLimit(newN.Spec.AllocationRateLimit); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	if err = validateDefaultIPFamily(newN.Spec.DefaultIPFamily); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
 	switch networkingv1.GetNetworkMode(newN) {
 	case networkingv1.NetworkModeBGP:
-		if len(newN.Spec.Config.BGPPeers) != 1 {
-			return admission.Denied("one and only one bgp router need to be set")
+		if newN.Spec.Config == nil {
+			return admission.Denied("at least one bgp router need to be set")
 		}
 
-		for _, peer := range newN.Spec.Config.BGPPeers {
-			if net.ParseIP(peer.Address) == nil {
-				return admission.Denied(fmt.Sprintf("invalid bgp peer ip address %v", peer.Address))
-			}
+		if err := validateBGPPeers(newN.Spec.Config.BGPPeers); err != nil {
+			return admission.Denied(err.Error())
 		}
 	case networkingv1.NetworkModeVlan:
 	case networkingv1.NetworkModeVxlan:
@@ -176,6 +207,27 @@ func NetworkUpdateValidation(ctx context.Context, req *admission.Request, handle
 		return webhookutils.AdmissionDeniedWithLog("net ID must not be changed", logger)
 	}
 
+	// overlapped subnets are only allowed in the same address pool
+	if oldN.Spec.AddressPool != newN.Spec.AddressPool {
+		return webhookutils.AdmissionDeniedWithLog("address pool must not be changed", logger)
+	}
+
+	// existing subnets must be small enough to derive unique mac addresses from ips
+	if oldN.Spec.MACAddressMode != newN.Spec.MACAddressMode && newN.Spec.MACAddressMode == networkingv1.MACAddressModeIPDerived {
+		subnetList := &networkingv1.SubnetList{}
+		if err = handler.Client.List(ctx, subnetList); err != nil {
+			return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+		}
+		for i := range subnetList.Items {
+			if subnetList.Items[i].Spec.Network != newN.Name {
+				continue
+			}
+			if err = validateDerivedMACSubnet(&subnetList.Items[i], newN); err != nil {
+				return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("subnet %s: %v", subnetList.Items[i].Name, err), logger)
+			}
+		}
+	}
+
 	return admission.Allowed("validation pass")
 }
 
@@ -211,3 +263,75 @@ func NetworkDeleteValidation(ctx context.Context, req *admission.Request, handle
 
 	return admission.Allowed("validation pass")
 }
+
+func validateAllocationRetry(allocationRetry *networkingv1.AllocationRetry) error {
+	if allocationRetry == nil {
+		return nil
+	}
+
+	if allocationRetry.BaseDelay.Duration <= 0 {
+		return fmt.Errorf("base delay of allocation retry must be positive")
+	}
+	if allocationRetry.MaxDelay.Duration != 0 && allocationRetry.MaxDelay.Duration < allocationRetry.BaseDelay.Duration {
+		return fmt.Errorf("max delay of allocation retry must not be less than base delay")
+	}
+	return nil
+}
+
+func validateAllocationRateLimit(allocationRateLimit *networkingv1.AllocationRateLimit) error {
+	if allocationRateLimit == nil {
+		return nil
+	}
+
+	if allocationRateLimit.QPS <= 0 {
+		return fmt.Errorf("qps of allocation rate limit must be positive")
+	}
+	if allocationRateLimit.Burst < 0 {
+		return fmt.Errorf("burst of allocation rate limit must not be negative")
+	}
+	return nil
+}
+
+func validateDefaultIPFamily(defaultIPFamily networkingv1.IPFamily) error {
+	if len(defaultIPFamily) == 0 {
+		return nil
+	}
+
+	if !feature.DualStackEnabled() {
+		return fmt.Errorf("default ip family %s is not supported if DualStack feature is disabled", defaultIPFamily)
+	}
+	return nil
+}
+
+func validateBGPPeers(peers []networkingv1.BGPPeer) error {
+	if len(peers) == 0 {
+		return fmt.Errorf("at least one bgp router need to be set")
+	}
+
+	var peerAddresses = map[string]struct{}{}
+	for _, peer := range peers {
+		peerIP := net.ParseIP(peer.Address)
+		if peerIP == nil {
+			return fmt.Errorf("invalid bgp peer ip address %v", peer.Address)
+		}
+
+		if _, exist := peerAddresses[peerIP.String()]; exist {
+			return fmt.Errorf("duplicated bgp peer ip address %v", peer.Address)
+		}
+		peerAddresses[peerIP.String()] = struct{}{}
+
+		switch peer.ExportPolicy {
+		case "", networkingv1.BGPExportPolicyAll, networkingv1.BGPExportPolicySubnets,
+			networkingv1.BGPExportPolicyIPs, networkingv1.BGPExportPolicyNone:
+		default:
+			return fmt.Errorf("unknown export policy %v of bgp peer %v", peer.ExportPolicy, peer.Address)
+		}
+
+		switch peer.ImportPolicy {
+		case "", networkingv1.BGPImportPolicyAccept, networkingv1.BGPImportPolicyReject:
+		default:
+			return fmt.Errorf("unknown import policy %v of bgp peer %v", peer.ImportPolicy, peer.Address)
+		}
+	}
+	return nil
+}
@@ -28,12 +28,14 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
 	"github.com/alibaba/hybridnet/pkg/constants"
+	controllerutils "github.com/alibaba/hybridnet/pkg/controllers/utils"
 	"github.com/alibaba/hybridnet/pkg/feature"
 	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
 	"github.com/alibaba/hybridnet/pkg/utils"
@@ -58,16 +60,21 @@ func PodCreateValidation(ctx context.Context, req *admission.Request, handler *H
 		return admission.Allowed("skip validation on host-networking pod")
 	}
 
-	var (
-		networkTypeFromPod = utils.PickFirstNonEmptyString(pod.Annotations[constants.AnnotationNetworkType], pod.Labels[constants.LabelNetworkType])
-		networkType        = ipamtypes.ParseNetworkTypeFromString(networkTypeFromPod)
-	)
-
-	specifiedNetwork, specifiedSubnetStr, err := webhookutils.SelectNetworkAndSubnetFromObject(ctx, handler.Cache, pod)
+	networkConfig, err := controllerutils.ResolveNetworkConfigOfObject(ctx, handler.Cache, pod)
 	if err != nil {
 		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
 	}
 
+	var (
+		specifiedNetwork   = networkConfig.NetworkName
+		specifiedSubnetStr = networkConfig.SubnetNames
+		networkType        = networkConfig.NetworkTypeOf(nil)
+		ipFamily           = networkConfig.IPFamilyOf(nil)
+	)
+
+	// default interface name is only known here if it is specified by network, or else it
+	// depends on the configuration of daemon and will be checked by daemon
+	var defaultIfName string
 	if len(specifiedNetwork) > 0 {
 		// Specified Network Validation
 		network := &networkingv1.Network{}
@@ -80,14 +87,16 @@ func PodCreateValidation(ctx context.Context, req *admission.Request, handler *H
 
 		// check network type, if network type is explicitly specified on pod, use it for comparison directly,
 		// or else mutate network type inherit from specified network
-		if len(networkTypeFromPod) > 0 {
+		if len(networkConfig.NetworkType) > 0 {
 			if !stringEqualCaseInsensitive(string(networkingv1.GetNetworkType(network)), string(networkType)) {
 				return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError,
 					fmt.Errorf("specified network type mismatch, network-type %s, network %s", networkType, network.Name), logger)
 			}
 		} else {
-			networkType = ipamtypes.ParseNetworkTypeFromString(string(networkingv1.GetNetworkType(network)))
+			networkType = networkConfig.NetworkTypeOf(network)
 		}
+		defaultIfName = network.Spec.DefaultInterfaceName
+		ipFamily = networkConfig.IPFamilyOf(network)
 
 		// Existing IP Instances Validation
 		ipList := &networkingv1.IPInstanceList{}
@@ -125,17 +134,155 @@ func PodCreateValidation(ctx context.Context, req *admission.Request, handler *H
 		}
 	}
 
+	// Network Admission Policy Validation, the network is only checked if it is specified, while network
+	// type and ip family are checked with the defaults if not specified
+	// policies are ignored if the crd is not installed yet, e.g., during upgrading
+	policyList := &networkingv1.NetworkAdmissionPolicyList{}
+	if err = handler.Cache.List(ctx, policyList); err != nil && !meta.IsNoMatchError(err) {
+		return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+	}
+	if len(policyList.Items) > 0 {
+		namespace := &corev1.Namespace{}
+		if err = handler.Cache.Get(ctx, types.NamespacedName{Name: pod.Namespace}, namespace); err != nil {
+			return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+		}
+		if err = networkingv1.CheckNetworkAdmission(policyList.Items, namespace.Labels, specifiedNetwork,
+			networkingv1.NetworkType(networkType),
+			string(ipFamily)); err != nil {
+			return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("pod in namespace %s is denied: %v", pod.Namespace, err), logger)
+		}
+	}
+
 	// IP Pool Validation
 	var ipPool string
 	if ipPool = pod.Annotations[constants.AnnotationIPPool]; len(ipPool) > 0 {
 		if len(specifiedNetwork) == 0 {
 			return webhookutils.AdmissionDeniedWithLog("ip pool and network(subnet) must be specified at the same time", logger)
 		}
-		ips := strings.Split(ipPool, ",")
-		for _, ip := range ips {
-			if utils.NormalizedIP(ip) != ip {
-				return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("ip pool has invalid ip %s", ip), logger)
+		if utils.IsPerFamilyIPPool(ipPool) {
+			if _, err = utils.ParsePerFamilyIPPool(ipPool); err != nil {
+				return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+			}
+		} else if feature.DualStackEnabled() {
+			// every ordinal is assigned with one ip of each family that pod expects at the same time
+			if err = utils.ValidateOrdinalIPPool(ipPool, ipFamily != ipamtypes.IPv6Only, ipFamily != ipamtypes.IPv4Only); err != nil {
+				return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+			}
+		} else {
+			// ips of each ordinal are separated by "/" for dual-stack pods
+			for _, ordinalIPs := range strings.Split(ipPool, ",") {
+				for _, ip := range strings.Split(ordinalIPs, "/") {
+					if utils.NormalizedIP(ip) != ip {
+						return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("ip pool has invalid ip %s", ip), logger)
+					}
+				}
+			}
+		}
+	}
+
+	// Net ID Range Validation
+	if netIDRangeStr := pod.Annotations[constants.AnnotationSpecifiedNetIDRange]; len(netIDRangeStr) > 0 {
+		if len(specifiedSubnetStr) > 0 {
+			return webhookutils.AdmissionDeniedWithLog("net ID range and subnet can not be specified at the same time", logger)
+		}
+		if _, err = utils.ParseNetIDRange(netIDRangeStr); err != nil {
+			return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+		}
+	}
+
+	// Subnet Affinity Validation
+	if affinityStr := pod.Annotations[constants.AnnotationSubnetAffinity]; len(affinityStr) > 0 {
+		if _, err = utils.ParseSubnetAffinity(affinityStr); err != nil {
+			return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+		}
+	}
+
+	// Subnet Anti-Affinity Validation
+	if antiAffinityStr := pod.Annotations[constants.AnnotationSubnetAntiAffinity]; len(antiAffinityStr) > 0 {
+		if _, err = utils.ParseWorkloadReferences(antiAffinityStr); err != nil {
+			return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+		}
+	}
+
+	// IP Family Validation
+	if ipFamilyStr := pod.Annotations[constants.AnnotationIPFamily]; feature.IPv6OnlyEnabled() && len(ipFamilyStr) > 0 &&
+		!strings.EqualFold(ipFamilyStr, string(ipamtypes.IPv6Only)) && !strings.EqualFold(ipFamilyStr, ipamtypes.IPv6OnlyAlias) {
+		return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("ip family %s is not supported in ipv6-only cluster", ipFamilyStr), logger)
+	}
+
+	// Interface Sysctls Validation
+	if interfaceSysctlsStr := pod.Annotations[constants.AnnotationInterfaceSysctls]; len(interfaceSysctlsStr) > 0 {
+		interfaceSysctls, err := utils.ParseInterfaceSysctls(interfaceSysctlsStr)
+		if err != nil {
+			return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+		}
+		// ipv6 address is required by ipv6-only and dual-stack pods, so ipv6 must not be disabled on interface,
+		// the ip family is resolved as controller does, which inherits from the default of network if not annotated
+		if utils.IPv6DisabledByInterfaceSysctls(interfaceSysctls) {
+			sysctlIPFamily := ipFamily
+			if len(specifiedNetwork) == 0 && networkType == ipamtypes.Overlay {
+				// the only overlay network will be selected, while the underlay network depends on node and
+				// is left to be checked by daemon
+				overlayNetworkName, err := controllerutils.FindOverlayNetwork(handler.Cache)
+				if err != nil {
+					return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+				}
+				if len(overlayNetworkName) > 0 {
+					overlayNetwork, err := controllerutils.GetNetwork(handler.Cache, overlayNetworkName)
+					if err != nil {
+						return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+					}
+					sysctlIPFamily = networkConfig.IPFamilyOf(overlayNetwork)
+				}
 			}
+			if sysctlIPFamily != ipamtypes.IPv4Only {
+				return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("ipv6 can not be disabled on interface of pod in ip family %s",
+					sysctlIPFamily), logger)
+			}
+		}
+	}
+
+	// Interfaces Validation
+	if interfacesStr := pod.Annotations[constants.AnnotationInterfaces]; len(interfacesStr) > 0 {
+		ifNames, err := utils.ParseInterfaceNames(interfacesStr)
+		if err != nil {
+			return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+		}
+		if _, exist := utils.StringSliceToMap(ifNames)[defaultIfName]; len(defaultIfName) > 0 && !exist {
+			return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("default interface %s must be in expected interfaces", defaultIfName), logger)
+		}
+	}
+
+	// Bandwidth Validation
+	if _, err = utils.ParseBandwidth(pod.Annotations[constants.AnnotationIngressBandwidth],
+		pod.Annotations[constants.AnnotationEgressBandwidth]); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	// Route Table Validation, the tables managed by daemon are left to be refused by daemon
+	if _, err = utils.ParseRouteTable(pod.Annotations[constants.AnnotationRouteTable]); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	// Loopback Subnet Validation, loopback ip is only reachable by being advertised as a host route by bgp
+	if loopbackSubnetName := pod.Annotations[constants.AnnotationLoopbackSubnet]; len(loopbackSubnetName) > 0 {
+		loopbackSubnet := &networkingv1.Subnet{}
+		if err = handler.Cache.Get(ctx, types.NamespacedName{Name: loopbackSubnetName}, loopbackSubnet); err != nil {
+			if errors.IsNotFound(err) {
+				return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("loopback subnet %s not found", loopbackSubnetName), logger)
+			}
+			return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+		}
+		if !feature.DualStackEnabled() && loopbackSubnet.Spec.Range.Version == networkingv1.IPv6 {
+			return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("ipv6 loopback subnet %s is only supported in dual stack mode", loopbackSubnetName), logger)
+		}
+		loopbackNetwork := &networkingv1.Network{}
+		if err = handler.Cache.Get(ctx, types.NamespacedName{Name: loopbackSubnet.Spec.Network}, loopbackNetwork); err != nil {
+			return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+		}
+		if networkingv1.GetNetworkMode(loopbackNetwork) != networkingv1.NetworkModeBGP {
+			return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("network %s of loopback subnet %s is not in bgp mode",
+				loopbackNetwork.Name, loopbackSubnetName), logger)
 		}
 	}
 
@@ -148,7 +295,7 @@ func PodCreateValidation(ctx context.Context, req *admission.Request, handler *H
 		for i := range networkList.Items {
 			network := &networkList.Items[i]
 			if network.Spec.Type == networkingv1.NetworkTypeOverlay {
-				switch ipamtypes.ParseIPFamilyFromString(pod.Annotations[constants.AnnotationIPFamily]) {
+				switch networkConfig.IPFamilyOf(network) {
 				case ipamtypes.IPv4Only:
 					if network.Status.Statistics.Available <= 0 {
 						return webhookutils.AdmissionDeniedWithLog("lacking ipv4 addresses for overlay mode", logger)
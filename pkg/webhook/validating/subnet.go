@@ -19,6 +19,7 @@ package validating
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"reflect"
 	"strings"
@@ -36,12 +37,17 @@ import (
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
 const (
 	MaxSubnetCapacity = 1 << 16
+
+	// MinDerivedMACIPv6PrefixLength is the min prefix length of ipv6 subnets in network deriving
+	// mac addresses from ips, which keeps the last 32 bits of ips, and so the mac addresses, unique
+	MinDerivedMACIPv6PrefixLength = 96
 )
 
 var subnetGVK = gvkConverter(networkingv1.GroupVersion.WithKind("Subnet"))
@@ -114,10 +120,38 @@ func SubnetCreateValidation(ctx context.Context, req *admission.Request, handler
 		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
 	}
 
+	// Stateful Base IP validation
+	if err = validateStatefulBaseIP(subnet); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	// Topology validation
+	if err = validateTopology(subnet); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	// Conflicted IPs validation
+	if err = validateConflictedIPs(subnet); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	// Gateway-less validation
+	if err = validateGatewayLess(subnet, network); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	// Derived MAC validation
+	if err = validateDerivedMACSubnet(subnet, network); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
 	// IP Family validation
 	if !feature.DualStackEnabled() && networkingv1.IsIPv6Subnet(subnet) {
 		return webhookutils.AdmissionDeniedWithLog("ipv6 subnet non-supported if dualstack not enabled", logger)
 	}
+	if feature.IPv6OnlyEnabled() && !networkingv1.IsIPv6Subnet(subnet) {
+		return webhookutils.AdmissionDeniedWithLog("ipv4 subnet non-supported if ipv6-only enabled", logger)
+	}
 
 	// Capacity validation
 	if capacity := networkingv1.CalculateCapacity(&subnet.Spec.Range); capacity > MaxSubnetCapacity {
@@ -133,7 +167,14 @@ func SubnetCreateValidation(ctx context.Context, req *admission.Request, handler
 	if err = handler.Client.List(ctx, subnetList); err != nil {
 		return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
 	}
+	sharingNetworks, err := networksSharingAddressPool(ctx, handler.Client, network)
+	if err != nil {
+		return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+	}
 	for i := range subnetList.Items {
+		if _, shared := sharingNetworks[subnetList.Items[i].Spec.Network]; shared {
+			continue
+		}
 		comparedSubnet := transform.TransferSubnetForIPAM(&subnetList.Items[i])
 		// we assume that all existing subnets all have been canonicalized
 		if err = comparedSubnet.Canonicalize(); err == nil && comparedSubnet.Overlap(ipamSubnet) {
@@ -201,25 +242,241 @@ func SubnetUpdateValidation(ctx context.Context, req *admission.Request, handler
 	if err != nil {
 		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
 	}
-	if oldS.Spec.Range.Start != newS.Spec.Range.Start {
-		return webhookutils.AdmissionDeniedWithLog("must not change range start", logger)
-	}
-	if oldS.Spec.Range.End != newS.Spec.Range.End {
-		return webhookutils.AdmissionDeniedWithLog("must not change range end", logger)
+	if oldS.Spec.Range.Version != newS.Spec.Range.Version {
+		return webhookutils.AdmissionDeniedWithLog("must not change range version", logger)
 	}
 	if oldS.Spec.Range.Gateway != newS.Spec.Range.Gateway {
 		return webhookutils.AdmissionDeniedWithLog("must not change range gateway", logger)
 	}
-	if oldS.Spec.Range.CIDR != newS.Spec.Range.CIDR {
-		return webhookutils.AdmissionDeniedWithLog("must not change range CIDR", logger)
-	}
 	if !utils.DeepEqualStringSlice(oldS.Spec.Range.ExcludeIPs, newS.Spec.Range.ExcludeIPs) {
 		return webhookutils.AdmissionDeniedWithLog("must not change excluded IPs", logger)
 	}
 
+	// Range change validation, expansion is allowed freely but shrinking is only allowed if
+	// no allocated ip will be out of new range
+	if oldS.Spec.Range.CIDR != newS.Spec.Range.CIDR || oldS.Spec.Range.Start != newS.Spec.Range.Start ||
+		oldS.Spec.Range.End != newS.Spec.Range.End {
+		reason, err := validateSubnetRangeChange(ctx, handler.Client, oldS, newS)
+		if err != nil {
+			return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+		}
+		if len(reason) > 0 {
+			return webhookutils.AdmissionDeniedWithLog(reason, logger)
+		}
+	}
+
+	// Stateful Base IP validation
+	if err = validateStatefulBaseIP(newS); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	// Topology validation
+	if err = validateTopology(newS); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	// Conflicted IPs validation
+	if err = validateConflictedIPs(newS); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	// Gateway-less validation
+	if networkingv1.IsGatewayLessSubnet(oldS) != networkingv1.IsGatewayLessSubnet(newS) {
+		return webhookutils.AdmissionDeniedWithLog("must not change gateway-less", logger)
+	}
+
+	// Derived MAC validation
+	if err = validateDerivedMACSubnet(newS, network); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
 	return admission.Allowed("validation pass")
 }
 
+// validateSubnetRangeChange checks the new address range of subnet as creation does, and also checks
+// that no allocated ip will be orphaned or left with a stale mask, a non-empty reason is returned if
+// the change should be denied
+func validateSubnetRangeChange(ctx context.Context, c client.Reader, oldSubnet, subnet *networkingv1.Subnet) (string, error) {
+	// Capacity validation
+	if capacity := networkingv1.CalculateCapacity(&subnet.Spec.Range); capacity > MaxSubnetCapacity {
+		return fmt.Sprintf("subnet contains more than %d IPs", MaxSubnetCapacity), nil
+	}
+
+	// Subnet overlap validation
+	ipamSubnet := transform.TransferSubnetForIPAM(subnet)
+	if err := ipamSubnet.Canonicalize(); err != nil {
+		return fmt.Sprintf("canonicalize subnet failed: %v", err), nil
+	}
+	subnetList := &networkingv1.SubnetList{}
+	if err := c.List(ctx, subnetList); err != nil {
+		return "", err
+	}
+	network := &networkingv1.Network{}
+	if err := c.Get(ctx, types.NamespacedName{Name: subnet.Spec.Network}, network); err != nil {
+		return "", err
+	}
+	sharingNetworks, err := networksSharingAddressPool(ctx, c, network)
+	if err != nil {
+		return "", err
+	}
+	for i := range subnetList.Items {
+		if subnetList.Items[i].Name == subnet.Name {
+			continue
+		}
+		if _, shared := sharingNetworks[subnetList.Items[i].Spec.Network]; shared {
+			continue
+		}
+		comparedSubnet := transform.TransferSubnetForIPAM(&subnetList.Items[i])
+		if err := comparedSubnet.Canonicalize(); err == nil && comparedSubnet.Overlap(ipamSubnet) {
+			return fmt.Sprintf("overlap with existing subnet %s", comparedSubnet.Name), nil
+		}
+	}
+
+	if feature.MultiClusterEnabled() {
+		rcSubnetList := &multiclusterv1.RemoteSubnetList{}
+		if err := c.List(ctx, rcSubnetList); err != nil {
+			return "", err
+		}
+		for _, rcSubnet := range rcSubnetList.Items {
+			if utils.Intersect(&subnet.Spec.Range, &rcSubnet.Spec.Range) {
+				return fmt.Sprintf("overlap with existing RemoteSubnet %s", rcSubnet.Name), nil
+			}
+		}
+	}
+
+	// Allocated IPs validation
+	ipList := &networkingv1.IPInstanceList{}
+	if err := c.List(ctx, ipList, client.MatchingLabels{constants.LabelSubnet: subnet.Name}); err != nil {
+		return "", err
+	}
+
+	// the mask of allocated ips is recorded by ip instances and pod nics, which will not follow the
+	// new CIDR, so CIDR can only be changed if no ip is allocated
+	if oldSubnet.Spec.Range.CIDR != subnet.Spec.Range.CIDR && len(ipList.Items) > 0 {
+		var allocatedIPs []string
+		for i := range ipList.Items {
+			allocatedIPs = append(allocatedIPs, strings.Split(ipList.Items[i].Spec.Address.IP, "/")[0])
+		}
+		return fmt.Sprintf("must not change range CIDR while ips %v are allocated", allocatedIPs), nil
+	}
+
+	var orphanedIPs []string
+	for i := range ipList.Items {
+		ip := strings.Split(ipList.Items[i].Spec.Address.IP, "/")[0]
+		if !utils.InAddressRange(&subnet.Spec.Range, net.ParseIP(ip)) {
+			orphanedIPs = append(orphanedIPs, ip)
+		}
+	}
+	if len(orphanedIPs) > 0 {
+		return fmt.Sprintf("allocated ips %v will be out of new range", orphanedIPs), nil
+	}
+
+	return "", nil
+}
+
+// networksSharingAddressPool returns the other networks in the same address pool with network,
+// subnets of them are allowed to overlap with the ones of network
+func networksSharingAddressPool(ctx context.Context, c client.Reader, network *networkingv1.Network) (map[string]struct{}, error) {
+	sharingNetworks := make(map[string]struct{})
+	if len(network.Spec.AddressPool) == 0 {
+		return sharingNetworks, nil
+	}
+
+	networkList := &networkingv1.NetworkList{}
+	if err := c.List(ctx, networkList); err != nil {
+		return nil, err
+	}
+	for i := range networkList.Items {
+		if networkList.Items[i].Name != network.Name && networkList.Items[i].Spec.AddressPool == network.Spec.AddressPool {
+			sharingNetworks[networkList.Items[i].Name] = struct{}{}
+		}
+	}
+	return sharingNetworks, nil
+}
+
+func validateStatefulBaseIP(subnet *networkingv1.Subnet) error {
+	if subnet.Spec.Config == nil || len(subnet.Spec.Config.StatefulBaseIP) == 0 {
+		return nil
+	}
+
+	baseIP := net.ParseIP(subnet.Spec.Config.StatefulBaseIP)
+	if baseIP == nil {
+		return fmt.Errorf("invalid stateful base ip %s", subnet.Spec.Config.StatefulBaseIP)
+	}
+
+	_, cidr, _ := net.ParseCIDR(subnet.Spec.Range.CIDR)
+	if cidr == nil || !cidr.Contains(baseIP) {
+		return fmt.Errorf("stateful base ip %s is not in subnet cidr %s", baseIP, subnet.Spec.Range.CIDR)
+	}
+	return nil
+}
+
+func validateTopology(subnet *networkingv1.Subnet) error {
+	if subnet.Spec.Config == nil || subnet.Spec.Config.Topology == nil {
+		return nil
+	}
+
+	topology := subnet.Spec.Config.Topology
+	if errs := validation.IsQualifiedName(topology.Key); len(errs) > 0 {
+		return fmt.Errorf("invalid topology key %s: %s", topology.Key, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsValidLabelValue(topology.Value); len(errs) > 0 {
+		return fmt.Errorf("invalid topology value %s: %s", topology.Value, strings.Join(errs, ", "))
+	}
+	if topology.Weight != nil && (*topology.Weight < 1 || *topology.Weight > 100) {
+		return fmt.Errorf("invalid topology weight %d, must be in range [1, 100]", *topology.Weight)
+	}
+	return nil
+}
+
+// validateConflictedIPs checks that conflicted ips are valid addresses in the range of subnet
+func validateConflictedIPs(subnet *networkingv1.Subnet) error {
+	for _, conflictedIP := range subnet.Spec.ConflictedIPs {
+		ip := net.ParseIP(conflictedIP)
+		if ip == nil {
+			return fmt.Errorf("invalid conflicted ip %s", conflictedIP)
+		}
+		if !utils.InAddressRange(&subnet.Spec.Range, ip) {
+			return fmt.Errorf("conflicted ip %s is not in range of subnet", conflictedIP)
+		}
+	}
+	return nil
+}
+
+// validateGatewayLess checks that gateway-less subnet has no gateway and is only used in BGP
+// network, pods of which are routed by advertisement rather than gateway
+func validateGatewayLess(subnet *networkingv1.Subnet, network *networkingv1.Network) error {
+	if !networkingv1.IsGatewayLessSubnet(subnet) {
+		return nil
+	}
+
+	if len(subnet.Spec.Range.Gateway) > 0 {
+		return fmt.Errorf("must not assign gateway for a gateway-less subnet")
+	}
+	if mode := networkingv1.GetNetworkMode(network); mode != networkingv1.NetworkModeBGP {
+		return fmt.Errorf("gateway-less subnet is not supported in %s network", mode)
+	}
+	return nil
+}
+
+// validateDerivedMACSubnet checks that ipv6 subnet is not larger than /96 if its network derives mac
+// addresses from ips, otherwise different ips may be derived to the same mac address
+func validateDerivedMACSubnet(subnet *networkingv1.Subnet, network *networkingv1.Network) error {
+	if network.Spec.MACAddressMode != networkingv1.MACAddressModeIPDerived || !networkingv1.IsIPv6Subnet(subnet) {
+		return nil
+	}
+
+	_, cidr, err := net.ParseCIDR(subnet.Spec.Range.CIDR)
+	if err != nil {
+		return fmt.Errorf("invalid cidr %s: %v", subnet.Spec.Range.CIDR, err)
+	}
+	if ones, _ := cidr.Mask.Size(); ones < MinDerivedMACIPv6PrefixLength {
+		return fmt.Errorf("ipv6 subnet must not be larger than /%d in network %s deriving mac addresses from ips",
+			MinDerivedMACIPv6PrefixLength, network.Name)
+	}
+	return nil
+}
+
 func SubnetDeleteValidation(ctx context.Context, req *admission.Request, handler *Handler) admission.Response {
 	logger := log.FromContext(ctx)
 